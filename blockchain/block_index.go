@@ -0,0 +1,894 @@
+// Package blockchain keeps track of the structure of the block chain: which headers and blocks are known, how they link to each other and
+// which chain has the most work
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"math/big"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrHeadersNotContinuous = errors.New("headers do not form a chain")
+	ErrHeadersDoNotConnect  = errors.New("first header does not follow a known header")
+	ErrCheckpointMismatch   = errors.New("header does not match the checkpoint at its height")
+	ErrForkBelowCheckpoint  = errors.New("header forks from the best chain below the last checkpoint")
+	ErrUnknownSnapshotBase  = errors.New("header of the snapshot base block is not known")
+	ErrSnapshotBehindTip    = errors.New("snapshot base block is not above the tip of the active chain")
+	ErrUnknownBlock         = errors.New("block is not known")
+	ErrInvalidateGenesis    = errors.New("genesis block cannot be invalidated")
+	ErrInvalidateSnapshot   = errors.New("base block of the UTXO snapshot and its ancestors cannot be invalidated")
+)
+
+// BlockStatus records how much is known about a block
+type BlockStatus uint8
+
+const (
+	// The block's header follows a known header and its proof of work is valid
+	StatusValidHeader BlockStatus = 1 << iota
+	// The block's transactions are stored
+	StatusHaveData
+	// The block or one of its ancestors was marked invalid (see BlockIndex.InvalidateBlock), which keeps it out of the best and active chains
+	StatusFailed
+)
+
+// Has reports whether all of status is set
+func (s BlockStatus) Has(status BlockStatus) bool {
+	return s&status == status
+}
+
+// BlockNode is a block of the block index
+type BlockNode struct {
+	Hash   message.Hash256
+	Parent *BlockNode
+	Height int32
+	// Fields of the block's header (zero for the genesis block until its data is stored, apart from Bits, unless it was set with
+	// BlockIndex.SetGenesisBlock)
+	Version    int32
+	MerkleRoot message.Hash256
+	Timestamp  uint32
+	// Target of the block's header in compact form
+	Bits  uint32
+	Nonce uint32
+	// Total work of the chain ending with the block
+	ChainWork *big.Int
+	Status    BlockStatus
+	// The block, once its data is stored, unless the index has a block reader to read it from (see BlockIndex.BlockData)
+	Block *message.BlockPayload
+	// blocks following this one
+	children []*BlockNode
+	// whether the data of the block and of all its ancestors is stored, which makes it a candidate for the tip of the active chain
+	chainComplete bool
+}
+
+// Header returns the header of the block, which is the block without its transactions
+func (node *BlockNode) Header() message.BlockPayload {
+	header := message.BlockPayload{
+		Version:    node.Version,
+		MerkleRoot: node.MerkleRoot,
+		Timestamp:  node.Timestamp,
+		Bits:       node.Bits,
+		Nonce:      node.Nonce,
+	}
+	if node.Parent != nil {
+		header.PrevBlock = node.Parent.Hash
+	}
+	return header
+}
+
+func (node *BlockNode) setHeader(header *message.BlockPayload) {
+	node.Version = header.Version
+	node.MerkleRoot = header.MerkleRoot
+	node.Timestamp = header.Timestamp
+	node.Bits = header.Bits
+	node.Nonce = header.Nonce
+}
+
+// TipChange lists the blocks that left and joined the active chain when its tip moved
+type TipChange struct {
+	// Blocks that left the active chain, the former tip first
+	Disconnected []BlockNode
+	// Blocks that joined the active chain, lowest first
+	Connected []BlockNode
+}
+
+// IsReorg reports whether the active chain switched to another branch rather than only being extended
+func (c TipChange) IsReorg() bool {
+	return len(c.Disconnected) > 0
+}
+
+// ProofOfWorkCheck checks the proof of work of a header whose hash is hash
+type ProofOfWorkCheck func(header *message.BlockPayload, hash message.Hash256) error
+
+// CheckProofOfWork checks that a header's hash meets its target
+var CheckProofOfWork ProofOfWorkCheck = (*message.BlockPayload).CheckProofOfWork
+
+// BlockReader reads the data of blocks stored outside of the block index
+type BlockReader interface {
+	ReadBlock(hash message.Hash256) (*message.BlockPayload, error)
+}
+
+// BlockIndex maps the hash of every known block that connects to the genesis block to its height, parent, chain work and status, and tracks
+// the chain with the most work, whose blocks are downloaded (https://github.com/bitcoin/bitcoin/pull/4468). Blocks whose parent is not known
+// (orphans) are kept apart until it is, up to a limit beyond which arbitrary orphans are evicted.
+type BlockIndex struct {
+	mu    sync.RWMutex
+	nodes map[message.Hash256]*BlockNode
+	// blocks of the chain with the most work, indexed by height
+	best []*BlockNode
+	// blocks of the active chain, whose data is stored, indexed by height. It moves to candidate when the best chain is activated.
+	active    []*BlockNode
+	candidate *BlockNode
+	// heights below it are known to have their data stored
+	firstMissingHeight int32
+	// block of the UTXO snapshot the active chain starts from until its ancestors are validated, and the height below which the blocks
+	// following it are known to have their data stored
+	snapshotBase              *BlockNode
+	firstMissingAfterSnapshot int32
+	// blocks no known block follows, the tips of the known chains
+	tips map[*BlockNode]struct{}
+	// blocks whose parent is not known, and their hashes by the hash of their parent
+	orphans         map[message.Hash256]*message.BlockPayload
+	orphansByParent map[message.Hash256][]message.Hash256
+	maxOrphans      int
+	// known block hashes indexed by height, and the highest block of the index that is one of them
+	checkpoints    map[int32]message.Hash256
+	lastCheckpoint *BlockNode
+	// number of blocks whose data is stored, including orphans
+	blockCount       int
+	checkProofOfWork ProofOfWorkCheck
+	// if set, the data of the blocks whose parent is known is read from it rather than kept in memory
+	blockReader BlockReader
+}
+
+// NewBlockIndex returns an index knowing only the genesis block's hash, which checks the proof of work of headers with checkProofOfWork and
+// keeps at most maxOrphans blocks whose parent is not known
+func NewBlockIndex(checkProofOfWork ProofOfWorkCheck, maxOrphans int) *BlockIndex {
+	// blocks can follow the genesis block before its data is stored, as it is hard-coded
+	genesis := &BlockNode{
+		Hash:          message.Hash256(constants.GenesisBlockHash),
+		Bits:          constants.PowLimitBits,
+		ChainWork:     HeaderWork(constants.PowLimitBits),
+		Status:        StatusValidHeader,
+		chainComplete: true,
+	}
+	return &BlockIndex{
+		nodes:            map[message.Hash256]*BlockNode{genesis.Hash: genesis},
+		best:             []*BlockNode{genesis},
+		active:           []*BlockNode{genesis},
+		candidate:        genesis,
+		tips:             map[*BlockNode]struct{}{genesis: {}},
+		orphans:          make(map[message.Hash256]*message.BlockPayload),
+		orphansByParent:  make(map[message.Hash256][]message.Hash256),
+		maxOrphans:       maxOrphans,
+		checkProofOfWork: checkProofOfWork,
+	}
+}
+
+// SetGenesisBlock makes the known chains start from block, whose hash is hash, instead of the mainnet genesis block, whose hash only the index
+// knows of. As the data of the genesis block is given rather than stored, it is kept in memory but neither counted by BlockCount nor returned
+// by Blocks. It is meant to be called before anything is added.
+func (x *BlockIndex) SetGenesisBlock(block *message.BlockPayload, hash message.Hash256) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	genesis := &BlockNode{
+		Hash:          hash,
+		ChainWork:     HeaderWork(block.Bits),
+		Status:        StatusValidHeader | StatusHaveData,
+		Block:         block,
+		chainComplete: true,
+	}
+	genesis.setHeader(block)
+	x.nodes = map[message.Hash256]*BlockNode{hash: genesis}
+	x.best = []*BlockNode{genesis}
+	x.active = []*BlockNode{genesis}
+	x.candidate = genesis
+	x.tips = map[*BlockNode]struct{}{genesis: {}}
+}
+
+// Genesis returns a copy of the genesis block, which the known chains start from
+func (x *BlockIndex) Genesis() BlockNode {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return *x.active[0]
+}
+
+// HeaderWork is the expected number of hashes needed to find a header with the target bits encodes, 2^256 / (target + 1)
+func HeaderWork(bits uint32) *big.Int {
+	target, err := message.CompactToTarget(bits)
+	if err != nil || target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// CheckProofOfWork checks the proof of work of header, whose hash is hash, the way headers added to the index are checked
+func (x *BlockIndex) CheckProofOfWork(header *message.BlockPayload, hash message.Hash256) error {
+	return x.checkProofOfWork(header, hash)
+}
+
+// AddHeaders adds headers, which must each follow the previous one with the first following a known header, after checking their proof of
+// work. It returns how many of them were not known. Headers are added up to the first invalid one.
+func (x *BlockIndex) AddHeaders(headers []message.BlockPayload) (int, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	added := 0
+	var prevHash message.Hash256
+	for i := range headers {
+		header := &headers[i]
+		if i > 0 && header.PrevBlock != prevHash {
+			return added, ErrHeadersNotContinuous
+		}
+		hash, err := header.GetBlockHash()
+		if err != nil {
+			return added, err
+		}
+		prevHash = hash
+		if _, ok := x.nodes[hash]; ok {
+			continue
+		}
+		parent, ok := x.nodes[header.PrevBlock]
+		if !ok {
+			return added, fmt.Errorf("%w: %s", ErrHeadersDoNotConnect, header.PrevBlock)
+		}
+		err = x.checkProofOfWork(header, hash)
+		if err != nil {
+			return added, err
+		}
+		err = x.checkCheckpoints(hash, parent.Height+1)
+		if err != nil {
+			return added, err
+		}
+		x.add(hash, header, parent)
+		added++
+	}
+	return added, nil
+}
+
+// AddBlock stores block, whose hash is hash and whose proof of work was already checked, adding its header if it is not known. It returns
+// false if the block was already stored.
+func (x *BlockIndex) AddBlock(block *message.BlockPayload, hash message.Hash256) (bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if node, ok := x.nodes[hash]; ok {
+		if node.Status.Has(StatusHaveData) {
+			return false, nil
+		}
+		x.storeData(node, block)
+		x.blockCount++
+		x.completeChain(node)
+		return true, nil
+	}
+	if _, ok := x.orphans[hash]; ok {
+		return false, nil
+	}
+	parent, ok := x.nodes[block.PrevBlock]
+	if !ok {
+		if len(x.orphans) >= x.maxOrphans {
+			x.evictOrphan()
+		}
+		x.orphans[hash] = block
+		x.orphansByParent[block.PrevBlock] = append(x.orphansByParent[block.PrevBlock], hash)
+		x.blockCount++
+		return true, nil
+	}
+	err := x.checkCheckpoints(hash, parent.Height+1)
+	if err != nil {
+		return false, err
+	}
+	// add moves the block out of the orphans
+	x.orphans[hash] = block
+	x.blockCount++
+	x.add(hash, block, parent)
+	return true, nil
+}
+
+// SetBlockReader makes the index forget the data of the blocks whose parent is known, reading it from reader when it is needed instead. The
+// blocks must be stored in reader before they are added. It is meant to be called before anything is added.
+func (x *BlockIndex) SetBlockReader(reader BlockReader) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.blockReader = reader
+}
+
+// BlockData returns the data of node, reading it from the block reader if it is not kept in memory
+func (x *BlockIndex) BlockData(node BlockNode) (*message.BlockPayload, error) {
+	if node.Block != nil || !node.Status.Has(StatusHaveData) {
+		return node.Block, nil
+	}
+	x.mu.RLock()
+	reader := x.blockReader
+	x.mu.RUnlock()
+	return reader.ReadBlock(node.Hash)
+}
+
+// storeData records that the data of node, which is block, is stored, keeping block in memory unless there is a block reader
+func (x *BlockIndex) storeData(node *BlockNode, block *message.BlockPayload) {
+	node.Status |= StatusHaveData
+	node.setHeader(block)
+	if x.blockReader == nil {
+		node.Block = block
+	}
+}
+
+// SetCheckpoints makes the index reject the headers that do not match checkpoints, which are known block hashes indexed by height, or that
+// fork from the best chain below the highest checkpoint it knows of. It is meant to be called before anything is added.
+func (x *BlockIndex) SetCheckpoints(checkpoints map[int32]message.Hash256) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.checkpoints = checkpoints
+}
+
+// checkCheckpoints checks that the header with hash, which is not known yet, can be added at height
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L4035)
+func (x *BlockIndex) checkCheckpoints(hash message.Hash256, height int32) error {
+	if expected, ok := x.checkpoints[height]; ok && expected != hash {
+		return fmt.Errorf("%w: %s at height %d is not %s", ErrCheckpointMismatch, hash, height, expected)
+	}
+	// every block below the last checkpoint is known already, so a new one forks
+	if x.lastCheckpoint != nil && height < x.lastCheckpoint.Height {
+		return fmt.Errorf("%w: %s at height %d", ErrForkBelowCheckpoint, hash, height)
+	}
+	return nil
+}
+
+// BuriedByCheckpoint reports whether the block at height is an ancestor of (or is) the highest checkpoint the index knows of. The scripts of
+// such blocks need not be validated, as their hashes are known in advance.
+func (x *BlockIndex) BuriedByCheckpoint(height int32) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.lastCheckpoint != nil && height <= x.lastCheckpoint.Height
+}
+
+// evictOrphan forgets an arbitrary orphan (map iteration order is randomized) to make room for another one
+func (x *BlockIndex) evictOrphan() {
+	for hash := range x.orphans {
+		x.dropOrphan(hash)
+		return
+	}
+}
+
+func (x *BlockIndex) dropOrphan(hash message.Hash256) {
+	block := x.orphans[hash]
+	delete(x.orphans, hash)
+	siblings := slices.DeleteFunc(x.orphansByParent[block.PrevBlock], func(sibling message.Hash256) bool { return sibling == hash })
+	if len(siblings) == 0 {
+		delete(x.orphansByParent, block.PrevBlock)
+	} else {
+		x.orphansByParent[block.PrevBlock] = siblings
+	}
+	x.blockCount--
+}
+
+// add adds header, whose hash is hash, following parent, together with the orphans waiting for it, making the one with the most work the best
+// block
+func (x *BlockIndex) add(hash message.Hash256, header *message.BlockPayload, parent *BlockNode) {
+	type pending struct {
+		hash   message.Hash256
+		header *message.BlockPayload
+		parent *BlockNode
+	}
+	queue := []pending{{hash: hash, header: header, parent: parent}}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		// the orphans connected by the header are checked here, the header itself was checked by the caller
+		if p.hash != hash && x.checkCheckpoints(p.hash, p.parent.Height+1) != nil {
+			x.dropOrphan(p.hash)
+			continue
+		}
+		node := &BlockNode{Hash: p.hash, Parent: p.parent, Height: p.parent.Height + 1, Status: StatusValidHeader}
+		if p.parent.Status.Has(StatusFailed) {
+			node.Status |= StatusFailed
+		}
+		node.setHeader(p.header)
+		node.ChainWork = new(big.Int).Add(p.parent.ChainWork, HeaderWork(p.header.Bits))
+		x.nodes[p.hash] = node
+		p.parent.children = append(p.parent.children, node)
+		delete(x.tips, p.parent)
+		x.tips[node] = struct{}{}
+		// the block is already counted
+		if block, ok := x.orphans[p.hash]; ok {
+			delete(x.orphans, p.hash)
+			x.storeData(node, block)
+			x.completeChain(node)
+		}
+		if _, ok := x.checkpoints[node.Height]; ok && (x.lastCheckpoint == nil || node.Height > x.lastCheckpoint.Height) {
+			x.lastCheckpoint = node
+		}
+		if !node.Status.Has(StatusFailed) && node.ChainWork.Cmp(x.best[len(x.best)-1].ChainWork) > 0 {
+			x.setBest(node)
+		}
+		for _, child := range x.orphansByParent[p.hash] {
+			queue = append(queue, pending{hash: child, header: x.orphans[child], parent: node})
+		}
+		delete(x.orphansByParent, p.hash)
+	}
+}
+
+// completeChain marks node, whose data was just stored, and its descendants whose data is stored as complete if its parent is, making the one
+// with the most work the candidate for the tip of the active chain
+func (x *BlockIndex) completeChain(node *BlockNode) {
+	if node.Parent == nil || !node.Parent.chainComplete {
+		return
+	}
+	queue := []*BlockNode{node}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.chainComplete = true
+		if !node.Status.Has(StatusFailed) && node.ChainWork.Cmp(x.candidate.ChainWork) > 0 {
+			x.candidate = node
+		}
+		for _, child := range node.children {
+			if child.Status.Has(StatusHaveData) && !child.chainComplete {
+				queue = append(queue, child)
+			}
+		}
+	}
+}
+
+// findFork returns the last block a and b have in common
+func findFork(a *BlockNode, b *BlockNode) *BlockNode {
+	for a != b {
+		if a.Height >= b.Height {
+			a = a.Parent
+		}
+		if b.Height > a.Height {
+			b = b.Parent
+		}
+	}
+	return a
+}
+
+// ActivateBestChain moves the tip of the active chain to the block with the most work whose data and ancestors' data are stored, disconnecting
+// the blocks back to the fork point if it is on another branch (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L3197)
+func (x *BlockIndex) ActivateBestChain() TipChange {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var change TipChange
+	tip := x.active[len(x.active)-1]
+	if x.candidate == tip {
+		return change
+	}
+	fork := findFork(tip, x.candidate)
+	for node := tip; node != fork; node = node.Parent {
+		change.Disconnected = append(change.Disconnected, *node)
+	}
+	x.active = append(x.active[:fork.Height+1], make([]*BlockNode, x.candidate.Height-fork.Height)...)
+	for node := x.candidate; node != fork; node = node.Parent {
+		x.active[node.Height] = node
+	}
+	for _, node := range x.active[fork.Height+1:] {
+		change.Connected = append(change.Connected, *node)
+	}
+	return change
+}
+
+// Tip returns a copy of the tip of the active chain
+func (x *BlockIndex) Tip() BlockNode {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return *x.active[len(x.active)-1]
+}
+
+// setBest makes the best chain end with tip, replacing the blocks above the fork point
+func (x *BlockIndex) setBest(tip *BlockNode) {
+	fork := tip
+	for fork.Height >= int32(len(x.best)) || x.best[fork.Height] != fork {
+		fork = fork.Parent
+	}
+	x.best = append(x.best[:fork.Height+1], make([]*BlockNode, tip.Height-fork.Height)...)
+	x.firstMissingHeight = min(x.firstMissingHeight, fork.Height+1)
+	x.firstMissingAfterSnapshot = min(x.firstMissingAfterSnapshot, fork.Height+1)
+	for node := tip; node != fork; node = node.Parent {
+		x.best[node.Height] = node
+	}
+}
+
+// InvalidateBlock marks the block with hash and its descendants invalid, making the block with the most work that is not invalid the best
+// block and the one among them whose chain is complete the candidate for the tip of the active chain, which moves there once
+// ActivateBestChain is called (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp)
+func (x *BlockIndex) InvalidateBlock(hash message.Hash256) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	node, ok := x.nodes[hash]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownBlock, hash)
+	}
+	if node.Parent == nil {
+		return ErrInvalidateGenesis
+	}
+	if base := x.snapshotBase; base != nil && node.Height <= base.Height {
+		for ancestor := base; ancestor.Height >= node.Height; ancestor = ancestor.Parent {
+			if ancestor == node {
+				return fmt.Errorf("%w: %s is the ancestor of %s at height %d", ErrInvalidateSnapshot, hash, base.Hash, node.Height)
+			}
+		}
+	}
+	queue := []*BlockNode{node}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.Status |= StatusFailed
+		queue = append(queue, node.children...)
+	}
+	x.selectBestChains()
+	return nil
+}
+
+// ReconsiderBlock clears the invalid mark of the block with hash, of its descendants and of its ancestors, making the block with the most work
+// that is not invalid the best block again and the one among them whose chain is complete the candidate for the tip of the active chain
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp)
+func (x *BlockIndex) ReconsiderBlock(hash message.Hash256) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	node, ok := x.nodes[hash]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownBlock, hash)
+	}
+	for ancestor := node.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		ancestor.Status &^= StatusFailed
+	}
+	queue := []*BlockNode{node}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.Status &^= StatusFailed
+		queue = append(queue, node.children...)
+	}
+	x.selectBestChains()
+	return nil
+}
+
+// selectBestChains makes the block with the most work that is not invalid the best block, and the one among them whose chain is complete the
+// candidate for the tip of the active chain, after blocks were marked invalid or valid again. The current ones are kept on a tie.
+func (x *BlockIndex) selectBestChains() {
+	best := x.best[len(x.best)-1]
+	for best.Status.Has(StatusFailed) {
+		best = best.Parent
+	}
+	candidate := x.candidate
+	for candidate.Status.Has(StatusFailed) {
+		candidate = candidate.Parent
+	}
+	for _, node := range x.nodes {
+		if node.Status.Has(StatusFailed) {
+			continue
+		}
+		if node.ChainWork.Cmp(best.ChainWork) > 0 {
+			best = node
+		}
+		if node.chainComplete && node.ChainWork.Cmp(candidate.ChainWork) > 0 {
+			candidate = node
+		}
+	}
+	x.setBest(best)
+	x.candidate = candidate
+}
+
+// ChainTipStatus describes the chain ending with a chain tip
+type ChainTipStatus string
+
+const (
+	// The tip of the active chain
+	ChainTipActive ChainTipStatus = "active"
+	// The data of the chain is stored but it has less work than the active chain
+	ChainTipValidFork ChainTipStatus = "valid-fork"
+	// The chain contains a block marked invalid
+	ChainTipInvalid ChainTipStatus = "invalid"
+	// The data of some blocks of the chain is not stored
+	ChainTipHeadersOnly ChainTipStatus = "headers-only"
+)
+
+// ChainTip is the last block of a known chain
+type ChainTip struct {
+	Block BlockNode
+	// Number of blocks of the chain following the block it shares with the active chain (0 for the tip of the active chain)
+	BranchLen int32
+	Status    ChainTipStatus
+}
+
+// ChainTips returns the blocks no known block follows and the tip of the active chain, the latter first and the others by decreasing height,
+// like Bitcoin Core's getchaintips (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp)
+func (x *BlockIndex) ChainTips() []ChainTip {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	tip := x.active[len(x.active)-1]
+	tips := []ChainTip{{Block: *tip, Status: ChainTipActive}}
+	others := make([]*BlockNode, 0, len(x.tips))
+	for node := range x.tips {
+		if node != tip {
+			others = append(others, node)
+		}
+	}
+	slices.SortFunc(others, func(a, b *BlockNode) int {
+		if a.Height != b.Height {
+			return int(b.Height - a.Height)
+		}
+		return slices.Compare(a.Hash[:], b.Hash[:])
+	})
+	for _, node := range others {
+		fork := node
+		for fork.Height >= int32(len(x.active)) || x.active[fork.Height] != fork {
+			fork = fork.Parent
+		}
+		status := ChainTipHeadersOnly
+		switch {
+		case node.Status.Has(StatusFailed):
+			status = ChainTipInvalid
+		case node.chainComplete:
+			status = ChainTipValidFork
+		}
+		tips = append(tips, ChainTip{Block: *node, BranchLen: node.Height - fork.Height, Status: status})
+	}
+	return tips
+}
+
+// Get returns a copy of the block with hash, if its header is known
+func (x *BlockIndex) Get(hash message.Hash256) (BlockNode, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	node, ok := x.nodes[hash]
+	if !ok {
+		return BlockNode{}, false
+	}
+	return *node, true
+}
+
+// HasHeader reports whether the header of the block with hash is known
+func (x *BlockIndex) HasHeader(hash message.Hash256) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.nodes[hash]
+	return ok
+}
+
+// HasBlock reports whether the block with hash is stored, even if its parent is not known
+func (x *BlockIndex) HasBlock(hash message.Hash256) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.hasBlock(hash)
+}
+
+func (x *BlockIndex) hasBlock(hash message.Hash256) bool {
+	if node, ok := x.nodes[hash]; ok {
+		return node.Status.Has(StatusHaveData)
+	}
+	_, ok := x.orphans[hash]
+	return ok
+}
+
+// BlockCount returns the number of stored blocks, including the ones whose parent is not known
+func (x *BlockIndex) BlockCount() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.blockCount
+}
+
+// Blocks returns the stored blocks kept in memory (all of them unless the index has a block reader), lowest first, followed by the ones whose
+// parent is not known. The genesis block is left out, as it comes with the network's parameters rather than from peers.
+func (x *BlockIndex) Blocks() []*message.BlockPayload {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	nodes := make([]*BlockNode, 0, x.blockCount)
+	for _, node := range x.nodes {
+		if node.Block != nil && node.Parent != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	slices.SortFunc(nodes, func(a, b *BlockNode) int { return int(a.Height - b.Height) })
+	blocks := make([]*message.BlockPayload, 0, x.blockCount)
+	for _, node := range nodes {
+		blocks = append(blocks, node.Block)
+	}
+	for _, orphan := range x.orphans {
+		blocks = append(blocks, orphan)
+	}
+	return blocks
+}
+
+// BestHeight returns the height of the best block, whose data may not be stored yet
+func (x *BlockIndex) BestHeight() int32 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return int32(len(x.best) - 1)
+}
+
+// Locator returns the hashes of the best chain peers are told about to find the last block they have in common with us: the eleven best
+// ones, then ones twice further apart each time, ending with the genesis block (https://en.bitcoin.it/wiki/Protocol_documentation#getblocks)
+func (x *BlockIndex) Locator() []message.Hash256 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	locator := make([]message.Hash256, 0, 32)
+	step := 1
+	for height := len(x.best) - 1; height > 0; height -= step {
+		locator = append(locator, x.best[height].Hash)
+		if len(locator) > 10 {
+			step *= 2
+		}
+	}
+	return append(locator, x.best[0].Hash)
+}
+
+// MissingBlocks returns the hashes of up to max blocks of the best chain, lowest first, whose data is not stored and for which skip is false,
+// among the window blocks starting with the first block whose data is not stored. While the active chain starts from a UTXO snapshot, the
+// blocks following its base block come first, so that the tip keeps up with the network while the blocks below it are downloaded.
+func (x *BlockIndex) MissingBlocks(skip func(message.Hash256) bool, window int, max int) []message.Hash256 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	missing := make([]message.Hash256, 0)
+	if x.snapshotBase != nil {
+		if x.firstMissingAfterSnapshot <= x.snapshotBase.Height {
+			x.firstMissingAfterSnapshot = x.snapshotBase.Height + 1
+		}
+		missing = x.appendMissingBlocks(missing, &x.firstMissingAfterSnapshot, int32(len(x.best)), skip, window, max)
+	}
+	end := int32(len(x.best))
+	if x.snapshotBase != nil {
+		end = x.snapshotBase.Height + 1
+	}
+	return x.appendMissingBlocks(missing, &x.firstMissingHeight, end, skip, window, max)
+}
+
+// appendMissingBlocks appends to missing the blocks of the best chain below end for MissingBlocks, starting from *first, which it moves up to
+// the first block whose data is not stored
+func (x *BlockIndex) appendMissingBlocks(missing []message.Hash256, first *int32, end int32, skip func(message.Hash256) bool, window int,
+	max int) []message.Hash256 {
+	end = min(end, int32(len(x.best)))
+	for *first < end && x.best[*first].Status.Has(StatusHaveData) {
+		*first++
+	}
+	end = min(end, *first+int32(window))
+	for height := *first; height < end && len(missing) < max; height++ {
+		node := x.best[height]
+		if !node.Status.Has(StatusHaveData) && !skip(node.Hash) {
+			missing = append(missing, node.Hash)
+		}
+	}
+	return missing
+}
+
+// OrphanCount returns the number of stored blocks whose parent is not known
+func (x *BlockIndex) OrphanCount() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.orphans)
+}
+
+// MissingAncestor returns the hash of the first block that is not stored among the ancestors of the orphan with hash, if it is an orphan
+func (x *BlockIndex) MissingAncestor(hash message.Hash256) (message.Hash256, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	block, ok := x.orphans[hash]
+	if !ok {
+		return message.Hash256{}, false
+	}
+	for {
+		parent, ok := x.orphans[block.PrevBlock]
+		if !ok {
+			// the genesis block has no parent
+			return block.PrevBlock, block.PrevBlock != message.Hash256{}
+		}
+		block = parent
+	}
+}
+
+// BlocksAfter returns the hashes of up to max blocks of the active chain following the first block of locator on the active chain (or the
+// genesis block if there is none), stopping after hashStop
+func (x *BlockIndex) BlocksAfter(locator []message.Hash256, hashStop message.Hash256, max int) []message.Hash256 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	start := int32(0)
+	for _, hash := range locator {
+		if node, ok := x.nodes[hash]; ok && node.Height < int32(len(x.active)) && x.active[node.Height] == node {
+			start = node.Height
+			break
+		}
+	}
+	hashes := make([]message.Hash256, 0)
+	for height := start + 1; height < int32(len(x.active)) && len(hashes) < max; height++ {
+		node := x.active[height]
+		// the blocks below the base block of a UTXO snapshot may not be stored yet
+		if !node.Status.Has(StatusHaveData) {
+			break
+		}
+		hashes = append(hashes, node.Hash)
+		if node.Hash == hashStop {
+			break
+		}
+	}
+	return hashes
+}
+
+// SetSnapshotBase makes the active chain end with the block with hash, whose UTXO snapshot was loaded, as if the data of its ancestors were
+// stored. The blocks following it are downloaded before its ancestors, which are downloaded to validate the snapshot until SnapshotValidated
+// is called (https://github.com/bitcoin/bitcoin/blob/v27.0/doc/design/assumeutxo.md).
+func (x *BlockIndex) SetSnapshotBase(hash message.Hash256) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	base, ok := x.nodes[hash]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSnapshotBase, hash)
+	}
+	tip := x.active[len(x.active)-1]
+	if base.Height <= tip.Height {
+		return fmt.Errorf("%w: base %s is at height %d, the tip at height %d", ErrSnapshotBehindTip, hash, base.Height, tip.Height)
+	}
+	x.snapshotBase = base
+	x.active = make([]*BlockNode, base.Height+1)
+	for node := base; node != nil; node = node.Parent {
+		x.active[node.Height] = node
+	}
+	x.candidate = base
+	// the stored blocks following the base block can extend the active chain
+	base.chainComplete = true
+	for _, child := range base.children {
+		if child.Status.Has(StatusHaveData) {
+			x.completeChain(child)
+		}
+	}
+	return nil
+}
+
+// SnapshotBase returns a copy of the base block of the UTXO snapshot the active chain starts from, if its ancestors are not validated yet
+func (x *BlockIndex) SnapshotBase() (BlockNode, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.snapshotBase == nil {
+		return BlockNode{}, false
+	}
+	return *x.snapshotBase, true
+}
+
+// SnapshotValidated records that the ancestors of the base block of the UTXO snapshot were validated, downloading the blocks lowest first again
+func (x *BlockIndex) SnapshotValidated() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.snapshotBase = nil
+}
+
+// MedianTimePast returns the median timestamp of the block with hash and of the blocks preceding it, up to constants.MedianTimeSpan blocks
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/chain.h#L278-L290), if its header is known
+func (x *BlockIndex) MedianTimePast(hash message.Hash256) (uint32, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	node, ok := x.nodes[hash]
+	if !ok {
+		return 0, false
+	}
+	timestamps := make([]uint32, 0, constants.MedianTimeSpan)
+	for ; node != nil && len(timestamps) < constants.MedianTimeSpan; node = node.Parent {
+		timestamps = append(timestamps, node.Timestamp)
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2], true
+}
+
+// ActiveBlock returns a copy of the block of the active chain at height, if there is one
+func (x *BlockIndex) ActiveBlock(height int32) (BlockNode, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if height < 0 || height >= int32(len(x.active)) {
+		return BlockNode{}, false
+	}
+	return *x.active[height], true
+}
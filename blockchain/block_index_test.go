@@ -0,0 +1,445 @@
+package blockchain_test
+
+import (
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// regtest's proof of work limit, which makes headers weigh little
+const easyBits = 0x207fffff
+
+var genesisHash = message.Hash256(constants.GenesisBlockHash)
+
+// createHeaders returns length headers following prev (their proof of work is not valid) and their hashes
+func createHeaders(t *testing.T, prev message.Hash256, length int, bits uint32, nonce uint32) ([]message.BlockPayload, []message.Hash256) {
+	headers := make([]message.BlockPayload, length)
+	hashes := make([]message.Hash256, length)
+	for i := range length {
+		headers[i] = message.BlockPayload{Version: 1, PrevBlock: prev, Timestamp: uint32(1231006505 + i), Bits: bits, Nonce: nonce + uint32(i)}
+		hash, err := headers[i].GetBlockHash()
+		require.NoError(t, err)
+		hashes[i], prev = hash, hash
+	}
+	return headers, hashes
+}
+
+// newTestBlockIndex returns a block index that does not check proof of work
+func newTestBlockIndex() *blockchain.BlockIndex {
+	return blockchain.NewBlockIndex(func(header *message.BlockPayload, hash message.Hash256) error { return nil }, constants.MaxOrphanBlocks)
+}
+
+// addBlock adds block to x, failing the test if it is rejected
+func addBlock(t *testing.T, x *blockchain.BlockIndex, block *message.BlockPayload, hash message.Hash256) bool {
+	added, err := x.AddBlock(block, hash)
+	require.NoError(t, err)
+	return added
+}
+
+func TestBlockIndex_AddHeaders(t *testing.T) {
+	t.Run("headers following the genesis block should become the best chain", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 5, easyBits, 0)
+		added, err := x.AddHeaders(headers)
+		require.NoError(t, err)
+		require.Equal(t, 5, added)
+		require.Equal(t, int32(5), x.BestHeight())
+
+		// known headers are skipped
+		added, err = x.AddHeaders(headers[3:])
+		require.NoError(t, err)
+		require.Zero(t, added)
+		require.True(t, x.HasHeader(hashes[4]))
+		require.False(t, x.HasBlock(hashes[4]))
+
+		node, ok := x.Get(hashes[4])
+		require.True(t, ok)
+		require.Equal(t, int32(5), node.Height)
+		require.Equal(t, hashes[3], node.Parent.Hash)
+		require.Equal(t, blockchain.StatusValidHeader, node.Status)
+	})
+
+	t.Run("a fork with more work should replace the best chain above the fork point", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 5, easyBits, 0)
+		_, err := x.AddHeaders(headers)
+		require.NoError(t, err)
+		// two headers at the mainnet proof of work limit are worth more than three at regtest's
+		fork, forkHashes := createHeaders(t, hashes[1], 2, constants.PowLimitBits, 100)
+		_, err = x.AddHeaders(fork)
+		require.NoError(t, err)
+
+		require.Equal(t, int32(4), x.BestHeight())
+		require.Equal(t, []message.Hash256{forkHashes[1], forkHashes[0], hashes[1], hashes[0], genesisHash}, x.Locator())
+		forkTip, _ := x.Get(forkHashes[1])
+		replacedTip, _ := x.Get(hashes[4])
+		require.Positive(t, forkTip.ChainWork.Cmp(replacedTip.ChainWork))
+	})
+
+	t.Run("headers which are not continuous or do not connect should be rejected", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, _ := createHeaders(t, genesisHash, 5, easyBits, 0)
+		_, err := x.AddHeaders([]message.BlockPayload{headers[0], headers[2]})
+		require.ErrorIs(t, err, blockchain.ErrHeadersNotContinuous)
+		_, err = x.AddHeaders(headers[2:])
+		require.ErrorIs(t, err, blockchain.ErrHeadersDoNotConnect)
+		require.Equal(t, int32(1), x.BestHeight())
+	})
+
+	t.Run("headers without a valid proof of work should be rejected", func(t *testing.T) {
+		x := blockchain.NewBlockIndex(blockchain.CheckProofOfWork, constants.MaxOrphanBlocks)
+		headers, _ := createHeaders(t, genesisHash, 1, constants.PowLimitBits, 0)
+		_, err := x.AddHeaders(headers)
+		require.ErrorIs(t, err, message.ErrHighHash)
+		require.Zero(t, x.BestHeight())
+	})
+}
+
+func TestBlockIndex_AddBlock(t *testing.T) {
+	t.Run("blocks should be connected once their parent is known, whatever order they arrive in", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 3, easyBits, 0)
+		require.True(t, addBlock(t, x, &headers[2], hashes[2]))
+		require.True(t, addBlock(t, x, &headers[1], hashes[1]))
+		require.False(t, addBlock(t, x, &headers[1], hashes[1]))
+
+		require.True(t, x.HasBlock(hashes[2]))
+		require.False(t, x.HasHeader(hashes[2]))
+		missing, ok := x.MissingAncestor(hashes[2])
+		require.True(t, ok)
+		require.Equal(t, hashes[0], missing)
+		require.Zero(t, x.BestHeight())
+
+		require.True(t, addBlock(t, x, &headers[0], hashes[0]))
+		require.Zero(t, x.OrphanCount())
+		require.Equal(t, int32(3), x.BestHeight())
+		node, ok := x.Get(hashes[2])
+		require.True(t, ok)
+		require.Equal(t, int32(3), node.Height)
+		require.Equal(t, blockchain.StatusValidHeader|blockchain.StatusHaveData, node.Status)
+		require.Equal(t, 3, x.BlockCount())
+		require.Equal(t, []*message.BlockPayload{&headers[0], &headers[1], &headers[2]}, x.Blocks())
+	})
+
+	t.Run("headers should connect the blocks waiting for them", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 3, easyBits, 0)
+		require.True(t, addBlock(t, x, &headers[2], hashes[2]))
+		_, err := x.AddHeaders(headers[:2])
+		require.NoError(t, err)
+
+		require.Equal(t, int32(3), x.BestHeight())
+		require.Zero(t, x.OrphanCount())
+		require.Equal(t, 1, x.BlockCount())
+	})
+
+	t.Run("orphans beyond the limit should evict other orphans", func(t *testing.T) {
+		x := blockchain.NewBlockIndex(func(header *message.BlockPayload, hash message.Hash256) error { return nil }, 2)
+		for i := range 4 {
+			orphans, hashes := createHeaders(t, message.Hash256{byte(i + 1)}, 1, easyBits, 0)
+			require.True(t, addBlock(t, x, &orphans[0], hashes[0]))
+		}
+		require.Equal(t, 2, x.OrphanCount())
+		require.Equal(t, 2, x.BlockCount())
+		require.Len(t, x.Blocks(), 2)
+
+		_, ok := x.MissingAncestor(genesisHash)
+		require.False(t, ok)
+	})
+}
+
+func TestBlockIndex_SetGenesisBlock(t *testing.T) {
+	x := newTestBlockIndex()
+	genesis, genesisHashes := createHeaders(t, message.Hash256{}, 1, easyBits, 0)
+	x.SetGenesisBlock(&genesis[0], genesisHashes[0])
+	require.False(t, x.HasHeader(genesisHash), "the mainnet genesis block should be forgotten")
+	require.Equal(t, genesisHashes[0], x.Genesis().Hash)
+	require.Equal(t, genesis[0].Timestamp, x.Tip().Timestamp)
+	require.True(t, x.HasBlock(genesisHashes[0]))
+	require.False(t, addBlock(t, x, &genesis[0], genesisHashes[0]), "the genesis block should already be stored")
+
+	headers, hashes := createHeaders(t, genesisHashes[0], 2, easyBits, 0)
+	for i := range headers {
+		addBlock(t, x, &headers[i], hashes[i])
+	}
+	require.Len(t, x.ActivateBestChain().Connected, 2)
+	require.Equal(t, 2, x.BlockCount())
+	require.Equal(t, []*message.BlockPayload{&headers[0], &headers[1]}, x.Blocks())
+}
+
+func TestBlockIndex_Locator(t *testing.T) {
+	x := newTestBlockIndex()
+	headers, hashes := createHeaders(t, genesisHash, 100, easyBits, 0)
+	_, err := x.AddHeaders(headers)
+	require.NoError(t, err)
+
+	locator := x.Locator()
+	// heights 100 to 91 one apart, then 90, 88, 84, 76, 60, 28 and the genesis block
+	require.Len(t, locator, 17)
+	require.Equal(t, hashes[99], locator[0])
+	require.Equal(t, hashes[89], locator[10])
+	require.Equal(t, hashes[27], locator[15])
+	require.Equal(t, genesisHash, locator[16])
+}
+
+func TestBlockIndex_MissingBlocks(t *testing.T) {
+	x := newTestBlockIndex()
+	headers, hashes := createHeaders(t, genesisHash, 6, easyBits, 0)
+	_, err := x.AddHeaders(headers)
+	require.NoError(t, err)
+	addBlock(t, x, &message.BlockPayload{}, genesisHash)
+	addBlock(t, x, &headers[0], hashes[0])
+	addBlock(t, x, &headers[2], hashes[2])
+	inFlight := map[message.Hash256]bool{hashes[1]: true}
+
+	skip := func(hash message.Hash256) bool { return inFlight[hash] }
+	require.Equal(t, []message.Hash256{hashes[3], hashes[4]}, x.MissingBlocks(skip, 10, 2))
+	// the window starts with the first missing block
+	require.Equal(t, []message.Hash256{hashes[3]}, x.MissingBlocks(skip, 3, 10))
+}
+
+func TestBlockIndex_BlocksAfter(t *testing.T) {
+	x := newTestBlockIndex()
+	headers, chain := createHeaders(t, genesisHash, 10, easyBits, 0)
+	for i := range headers {
+		addBlock(t, x, &headers[i], chain[i])
+	}
+	// a fork off the third block with less work, which is not followed
+	fork, forkHashes := createHeaders(t, chain[2], 1, 0, 100)
+	addBlock(t, x, &fork[0], forkHashes[0])
+	x.ActivateBestChain()
+
+	require.Equal(t, chain[5:], x.BlocksAfter([]message.Hash256{{0x01}, forkHashes[0], chain[4], chain[1]}, message.Hash256{}, 500))
+
+	// without a known locator hash the blocks are walked from the genesis block
+	require.Equal(t, chain, x.BlocksAfter([]message.Hash256{{0x01}}, message.Hash256{}, 500))
+	require.Equal(t, chain[:4], x.BlocksAfter(nil, chain[3], 500))
+	require.Equal(t, chain[:2], x.BlocksAfter(nil, message.Hash256{}, 2))
+	require.Empty(t, x.BlocksAfter([]message.Hash256{chain[9]}, message.Hash256{}, 500))
+}
+
+func TestBlockIndex_ActivateBestChain(t *testing.T) {
+	hashesOf := func(nodes []blockchain.BlockNode) []message.Hash256 {
+		hashes := make([]message.Hash256, len(nodes))
+		for i, node := range nodes {
+			hashes[i] = node.Hash
+		}
+		return hashes
+	}
+
+	t.Run("the active chain should be extended with the blocks whose parents are stored", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 3, easyBits, 0)
+		addBlock(t, x, &headers[0], hashes[0])
+		addBlock(t, x, &headers[2], hashes[2])
+
+		change := x.ActivateBestChain()
+		require.False(t, change.IsReorg())
+		require.Equal(t, hashes[:1], hashesOf(change.Connected))
+		require.Equal(t, hashes[0], x.Tip().Hash)
+
+		addBlock(t, x, &headers[1], hashes[1])
+		change = x.ActivateBestChain()
+		require.Equal(t, hashes[1:], hashesOf(change.Connected))
+		require.Equal(t, int32(3), x.Tip().Height)
+		require.Empty(t, x.ActivateBestChain().Connected)
+	})
+
+	t.Run("a branch with more work should replace the active chain once its blocks are stored", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 4, easyBits, 0)
+		for i := range headers {
+			addBlock(t, x, &headers[i], hashes[i])
+		}
+		x.ActivateBestChain()
+		fork, forkHashes := createHeaders(t, hashes[0], 2, constants.PowLimitBits, 100)
+		_, err := x.AddHeaders(fork)
+		require.NoError(t, err)
+
+		// only the headers of the branch are known
+		require.Empty(t, x.ActivateBestChain().Connected)
+		require.Equal(t, hashes[3], x.Tip().Hash)
+
+		addBlock(t, x, &fork[0], forkHashes[0])
+		addBlock(t, x, &fork[1], forkHashes[1])
+		change := x.ActivateBestChain()
+		require.True(t, change.IsReorg())
+		require.Equal(t, []message.Hash256{hashes[3], hashes[2], hashes[1]}, hashesOf(change.Disconnected))
+		require.Equal(t, forkHashes, hashesOf(change.Connected))
+		require.Equal(t, forkHashes[1], x.Tip().Hash)
+		require.Equal(t, forkHashes, x.BlocksAfter([]message.Hash256{hashes[3], hashes[0]}, message.Hash256{}, 500))
+	})
+
+	t.Run("a branch with as much work should not replace the active chain", func(t *testing.T) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 2, easyBits, 0)
+		fork, forkHashes := createHeaders(t, hashes[0], 1, easyBits, 100)
+		addBlock(t, x, &headers[0], hashes[0])
+		addBlock(t, x, &headers[1], hashes[1])
+		x.ActivateBestChain()
+		addBlock(t, x, &fork[0], forkHashes[0])
+
+		require.Empty(t, x.ActivateBestChain().Connected)
+		require.Equal(t, hashes[1], x.Tip().Hash)
+	})
+}
+
+func TestBlockIndex_InvalidateBlock(t *testing.T) {
+	x := newTestBlockIndex()
+	headers, hashes := createHeaders(t, genesisHash, 3, easyBits, 0)
+	fork, forkHashes := createHeaders(t, hashes[0], 1, easyBits, 100)
+	for i := range headers {
+		addBlock(t, x, &headers[i], hashes[i])
+	}
+	addBlock(t, x, &fork[0], forkHashes[0])
+	x.ActivateBestChain()
+
+	require.ErrorIs(t, x.InvalidateBlock(genesisHash), blockchain.ErrInvalidateGenesis)
+	require.ErrorIs(t, x.InvalidateBlock(message.Hash256{0x01}), blockchain.ErrUnknownBlock)
+
+	require.NoError(t, x.InvalidateBlock(hashes[1]))
+	change := x.ActivateBestChain()
+	require.Len(t, change.Disconnected, 2)
+	require.Equal(t, forkHashes[0], x.Tip().Hash)
+	require.Equal(t, int32(2), x.BestHeight())
+	node, ok := x.Get(hashes[2])
+	require.True(t, ok)
+	require.True(t, node.Status.Has(blockchain.StatusFailed), "descendants should be invalid too")
+
+	// blocks following an invalid block are invalid
+	next, nextHashes := createHeaders(t, hashes[2], 1, easyBits, 200)
+	addBlock(t, x, &next[0], nextHashes[0])
+	require.Empty(t, x.ActivateBestChain().Connected)
+	require.Equal(t, int32(2), x.BestHeight())
+
+	// reconsidering a descendant clears its ancestors too
+	require.NoError(t, x.ReconsiderBlock(nextHashes[0]))
+	change = x.ActivateBestChain()
+	require.True(t, change.IsReorg())
+	require.Equal(t, nextHashes[0], x.Tip().Hash)
+	node, ok = x.Get(hashes[1])
+	require.True(t, ok)
+	require.False(t, node.Status.Has(blockchain.StatusFailed))
+}
+
+func TestBlockIndex_ChainTips(t *testing.T) {
+	x := newTestBlockIndex()
+	require.Equal(t, []blockchain.ChainTip{{Block: x.Tip(), Status: blockchain.ChainTipActive}}, x.ChainTips())
+
+	headers, hashes := createHeaders(t, genesisHash, 4, easyBits, 0)
+	validFork, validForkHashes := createHeaders(t, hashes[0], 2, easyBits, 100)
+	invalidFork, invalidForkHashes := createHeaders(t, hashes[1], 1, easyBits, 200)
+	for i := range headers[:3] {
+		addBlock(t, x, &headers[i], hashes[i])
+	}
+	_, err := x.AddHeaders(headers[3:])
+	require.NoError(t, err)
+	for i := range validFork {
+		addBlock(t, x, &validFork[i], validForkHashes[i])
+	}
+	addBlock(t, x, &invalidFork[0], invalidForkHashes[0])
+	require.NoError(t, x.InvalidateBlock(invalidForkHashes[0]))
+	x.ActivateBestChain()
+
+	tips := x.ChainTips()
+	summary := make([]blockchain.ChainTip, len(tips))
+	for i, tip := range tips {
+		summary[i] = blockchain.ChainTip{Block: blockchain.BlockNode{Hash: tip.Block.Hash}, BranchLen: tip.BranchLen, Status: tip.Status}
+	}
+	require.Equal(t, []blockchain.ChainTip{
+		// the tip of the active chain comes first, even though a header follows it
+		{Block: blockchain.BlockNode{Hash: hashes[2]}, Status: blockchain.ChainTipActive},
+		{Block: blockchain.BlockNode{Hash: hashes[3]}, BranchLen: 1, Status: blockchain.ChainTipHeadersOnly},
+		{Block: blockchain.BlockNode{Hash: validForkHashes[1]}, BranchLen: 2, Status: blockchain.ChainTipValidFork},
+		{Block: blockchain.BlockNode{Hash: invalidForkHashes[0]}, BranchLen: 1, Status: blockchain.ChainTipInvalid},
+	}, summary)
+}
+
+func TestBlockIndex_Checkpoints(t *testing.T) {
+	newCheckpointedIndex := func(t *testing.T) (*blockchain.BlockIndex, []message.BlockPayload, []message.Hash256) {
+		x := newTestBlockIndex()
+		headers, hashes := createHeaders(t, genesisHash, 5, easyBits, 0)
+		x.SetCheckpoints(map[int32]message.Hash256{3: hashes[2]})
+		return x, headers, hashes
+	}
+
+	t.Run("a header not matching the checkpoint at its height should be rejected", func(t *testing.T) {
+		x, _, _ := newCheckpointedIndex(t)
+		fork, _ := createHeaders(t, genesisHash, 3, constants.PowLimitBits, 100)
+		added, err := x.AddHeaders(fork)
+		require.ErrorIs(t, err, blockchain.ErrCheckpointMismatch)
+		require.Equal(t, 2, added)
+	})
+
+	t.Run("a fork below the last checkpoint should be rejected, even with more work", func(t *testing.T) {
+		x, headers, hashes := newCheckpointedIndex(t)
+		_, err := x.AddHeaders(headers)
+		require.NoError(t, err)
+		require.True(t, x.BuriedByCheckpoint(3))
+		require.False(t, x.BuriedByCheckpoint(4))
+
+		fork, forkHashes := createHeaders(t, hashes[0], 1, constants.PowLimitBits, 100)
+		_, err = x.AddHeaders(fork)
+		require.ErrorIs(t, err, blockchain.ErrForkBelowCheckpoint)
+		_, err = x.AddBlock(&fork[0], forkHashes[0])
+		require.ErrorIs(t, err, blockchain.ErrForkBelowCheckpoint)
+		require.False(t, x.HasHeader(forkHashes[0]))
+		require.Equal(t, hashes[4], x.Locator()[0])
+	})
+
+	t.Run("a fork above the last checkpoint should be accepted", func(t *testing.T) {
+		x, headers, hashes := newCheckpointedIndex(t)
+		_, err := x.AddHeaders(headers)
+		require.NoError(t, err)
+
+		fork, forkHashes := createHeaders(t, hashes[2], 2, constants.PowLimitBits, 100)
+		_, err = x.AddHeaders(fork)
+		require.NoError(t, err)
+		require.Equal(t, forkHashes[1], x.Locator()[0])
+	})
+
+	t.Run("an orphan not matching the checkpoint at its height should be dropped when it is connected", func(t *testing.T) {
+		x, headers, hashes := newCheckpointedIndex(t)
+		_, err := x.AddHeaders(headers[:1])
+		require.NoError(t, err)
+		fork, forkHashes := createHeaders(t, hashes[0], 2, constants.PowLimitBits, 100)
+		require.True(t, addBlock(t, x, &fork[1], forkHashes[1]))
+		require.Equal(t, 1, x.OrphanCount())
+
+		_, err = x.AddHeaders(fork[:1])
+		require.NoError(t, err)
+		require.Zero(t, x.OrphanCount())
+		require.True(t, x.HasHeader(forkHashes[0]))
+		require.False(t, x.HasHeader(forkHashes[1]))
+	})
+}
+
+func TestBlockIndex_SetSnapshotBase(t *testing.T) {
+	x := newTestBlockIndex()
+	headers, hashes := createHeaders(t, genesisHash, 6, easyBits, 0)
+	_, err := x.AddHeaders(headers)
+	require.NoError(t, err)
+	addBlock(t, x, &message.BlockPayload{}, genesisHash)
+	require.ErrorIs(t, x.SetSnapshotBase(message.Hash256{0x01}), blockchain.ErrUnknownSnapshotBase)
+
+	require.NoError(t, x.SetSnapshotBase(hashes[2]))
+	require.Equal(t, hashes[2], x.Tip().Hash)
+	base, ok := x.SnapshotBase()
+	require.True(t, ok)
+	require.Equal(t, int32(3), base.Height)
+	// the blocks following the base block come first
+	noSkip := func(message.Hash256) bool { return false }
+	require.Equal(t, []message.Hash256{hashes[3], hashes[4], hashes[5], hashes[0], hashes[1], hashes[2]}, x.MissingBlocks(noSkip, 100, 100))
+	require.Empty(t, x.BlocksAfter(nil, message.Hash256{}, 10))
+
+	addBlock(t, x, &headers[3], hashes[3])
+	require.Equal(t, hashes[3], x.ActivateBestChain().Connected[0].Hash)
+	require.ErrorIs(t, x.SetSnapshotBase(hashes[1]), blockchain.ErrSnapshotBehindTip)
+
+	x.SnapshotValidated()
+	_, ok = x.SnapshotBase()
+	require.False(t, ok)
+	require.Equal(t, []message.Hash256{hashes[0], hashes[1], hashes[2], hashes[4], hashes[5]}, x.MissingBlocks(noSkip, 100, 100))
+}
@@ -0,0 +1,82 @@
+// Package blockfilter builds the basic compact block filters of BIP158 (https://github.com/bitcoin/bips/blob/master/bip-0158.mediawiki),
+// which let light clients find out whether a block is relevant to their scripts without downloading it
+package blockfilter
+
+import (
+	"crypto/sha256"
+	"github.com/aang114/bitcoin-node/message"
+	"slices"
+)
+
+// Opcode starting the scripts of provably unspendable outputs, which are not added to filters
+const opReturn = 0x6a
+
+// BasicElements returns the elements of the basic filter of block: the script of every output it creates, except the empty and OP_RETURN
+// ones, and spentScripts, the scripts of the outputs spent by its inputs, except the empty ones, without duplicates
+func BasicElements(block *message.BlockPayload, spentScripts [][]byte) [][]byte {
+	seen := make(map[string]bool)
+	var elements [][]byte
+	add := func(script []byte) {
+		if len(script) == 0 || seen[string(script)] {
+			return
+		}
+		seen[string(script)] = true
+		elements = append(elements, script)
+	}
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TransactionOutputs {
+			if len(txOut.PkScript) > 0 && txOut.PkScript[0] == opReturn {
+				continue
+			}
+			add(txOut.PkScript)
+		}
+	}
+	for _, script := range spentScripts {
+		add(script)
+	}
+	return elements
+}
+
+// BuildBasic returns the serialized basic filter of the block with hash hash holding elements, as returned by BasicElements
+func BuildBasic(hash message.Hash256, elements [][]byte) ([]byte, error) {
+	return encodeGCS(hash, elements)
+}
+
+// Match reports whether element may be one of the elements of filter, the basic filter of the block with hash hash. False positives happen
+// with a probability of 1/784931.
+func Match(hash message.Hash256, filter []byte, element []byte) (bool, error) {
+	values, n, err := decodeGCS(filter)
+	if err != nil {
+		return false, err
+	}
+	_, found := slices.BinarySearch(values, hashToRange(hash, element, n))
+	return found, nil
+}
+
+// MatchAny reports whether any of elements may be one of the elements of filter, decoding the filter once
+func MatchAny(hash message.Hash256, filter []byte, elements [][]byte) (bool, error) {
+	values, n, err := decodeGCS(filter)
+	if err != nil {
+		return false, err
+	}
+	for _, element := range elements {
+		if _, found := slices.BinarySearch(values, hashToRange(hash, element, n)); found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Hash returns the double SHA-256 of filter
+func Hash(filter []byte) message.Hash256 {
+	hash := sha256.Sum256(filter)
+	return sha256.Sum256(hash[:])
+}
+
+// Header returns the header of filter, committing to it and to previous, the header of the filter of the previous block (zero for the
+// genesis block)
+func Header(filter []byte, previous message.Hash256) message.Hash256 {
+	filterHash := Hash(filter)
+	hash := sha256.Sum256(append(filterHash[:], previous[:]...))
+	return sha256.Sum256(hash[:])
+}
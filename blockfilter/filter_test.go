@@ -0,0 +1,137 @@
+package blockfilter_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+func TestBuildBasic(t *testing.T) {
+	// the testnet genesis block, which only differs from the mainnet one by its timestamp and nonce, is the first BIP158 test vector
+	// (https://github.com/bitcoin/bips/blob/master/bip-0158/testnet-19.json)
+	genesis := networkingtest.GenesisBlock(t)
+	genesis.Timestamp = 1296688602
+	genesis.Nonce = 414098458
+	hash, err := genesis.GetBlockHash()
+	require.NoError(t, err)
+	require.Equal(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", hash.String())
+
+	filter, err := blockfilter.BuildBasic(hash, blockfilter.BasicElements(genesis, nil))
+	require.NoError(t, err)
+	require.Equal(t, "019dfca8", hex.EncodeToString(filter))
+	require.Equal(t, "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750", blockfilter.Header(filter, message.Hash256{}).String())
+	match, err := blockfilter.Match(hash, filter, genesis.Transactions[0].TransactionOutputs[0].PkScript)
+	require.NoError(t, err)
+	require.True(t, match)
+}
+
+// the other testnet-19 vectors need whole testnet blocks and the scripts they spend, so the blocks with several elements and with none are made
+// up here: their filters and headers, which follow the genesis block's, were computed by an independent implementation of BIP158, which gives
+// the testnet-19 filter and header of the genesis block too
+func TestBuildBasic_SeveralElements(t *testing.T) {
+	p2pkh := slices.Concat([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x11}, 20), []byte{0x88, 0xac})
+	p2wpkh := slices.Concat([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x22}, 20))
+	p2sh := slices.Concat([]byte{0xa9, 0x14}, bytes.Repeat([]byte{0x33}, 20), []byte{0x87})
+	p2wsh := slices.Concat([]byte{0x00, 0x20}, bytes.Repeat([]byte{0x44}, 32))
+	p2tr := slices.Concat([]byte{0x51, 0x20}, bytes.Repeat([]byte{0x55}, 32))
+	spentP2WPKH := slices.Concat([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x66}, 20))
+	spentP2SH := slices.Concat([]byte{0xa9, 0x14}, bytes.Repeat([]byte{0x77}, 20), []byte{0x87})
+	witnessCommitment := slices.Concat([]byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}, bytes.Repeat([]byte{0x88}, 32))
+
+	// the testnet-19 filter of the genesis block
+	genesisFilterHeader := blockfilter.Header([]byte{0x01, 0x9d, 0xfc, 0xa8}, message.Hash256{})
+
+	block := &message.BlockPayload{Version: 1, Timestamp: 1296688602, Bits: 0x1d00ffff, Transactions: []message.TxPayload{
+		{TransactionOutputs: []message.TxOut{{Value: 50_0000_0000, PkScript: p2pkh}, {PkScript: witnessCommitment}}},
+		{TransactionOutputs: []message.TxOut{{Value: 1000, PkScript: p2wpkh}, {Value: 2000, PkScript: p2sh}, {Value: 0, PkScript: []byte{}}}},
+		{TransactionOutputs: []message.TxOut{{Value: 3000, PkScript: p2wsh}, {Value: 4000, PkScript: p2tr}}},
+	}}
+	var err error
+	block.MerkleRoot, err = block.ComputeMerkleRoot()
+	require.NoError(t, err)
+	hash, err := block.GetBlockHash()
+	require.NoError(t, err)
+	require.Equal(t, "082e481c309c3246fc4dae6f7519810d87246adb2e2071cc947ea0ee20f29d60", hash.String())
+	// the output of the coinbase is spent again in the block, and the empty script spent is left out
+	elements := blockfilter.BasicElements(block, [][]byte{spentP2WPKH, spentP2SH, p2pkh, {}})
+	require.Equal(t, [][]byte{p2pkh, p2wpkh, p2sh, p2wsh, p2tr, spentP2WPKH, spentP2SH}, elements)
+
+	filter, err := blockfilter.BuildBasic(hash, elements)
+	require.NoError(t, err)
+	require.Equal(t, "074c651aef67e8a3d8a95c850ffb25616b881d80", hex.EncodeToString(filter))
+	filterHeader := blockfilter.Header(filter, genesisFilterHeader)
+	require.Equal(t, "035ef0f9e316d6bbbd1d253b08975cfc1384a70b1cf79d537b9c9706c3557f56", filterHeader.String())
+	match, err := blockfilter.MatchAny(hash, filter, [][]byte{witnessCommitment, spentP2SH})
+	require.NoError(t, err)
+	require.True(t, match)
+
+	// a block whose only output is OP_RETURN has an empty filter, which still has a header
+	empty := &message.BlockPayload{Version: 1, PrevBlock: hash, Timestamp: 1296688603, Bits: 0x1d00ffff, Transactions: []message.TxPayload{
+		{TransactionOutputs: []message.TxOut{{PkScript: witnessCommitment}}},
+	}}
+	empty.MerkleRoot, err = empty.ComputeMerkleRoot()
+	require.NoError(t, err)
+	emptyHash, err := empty.GetBlockHash()
+	require.NoError(t, err)
+	require.Equal(t, "c507c3d6c6d260573fedab6019e56b265193d61e26f55a249085b4396eb5876d", emptyHash.String())
+	elements = blockfilter.BasicElements(empty, nil)
+	require.Empty(t, elements)
+	emptyFilter, err := blockfilter.BuildBasic(emptyHash, elements)
+	require.NoError(t, err)
+	require.Equal(t, "00", hex.EncodeToString(emptyFilter))
+	require.Equal(t, "7e8b1047d14ca1736d4c74cb00eb96b2e6155768d93eae59f3af05741cc8c4d1", blockfilter.Header(emptyFilter, filterHeader).String())
+	match, err = blockfilter.Match(emptyHash, emptyFilter, p2pkh)
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestMatch(t *testing.T) {
+	hash := message.Hash256{1, 2, 3}
+	block := &message.BlockPayload{Transactions: []message.TxPayload{{
+		TransactionOutputs: []message.TxOut{
+			{PkScript: []byte{0x51}},
+			{PkScript: []byte{0x52}},
+			// neither empty nor OP_RETURN scripts are added
+			{PkScript: []byte{}},
+			{PkScript: []byte{0x6a, 0x01, 0x01}},
+		},
+	}}}
+	spentScripts := make([][]byte, 100)
+	for i := range spentScripts {
+		spentScripts[i] = []byte{0x00, 0x14, byte(i)}
+	}
+	// the duplicate is only added once
+	spentScripts = append(spentScripts, []byte{0x51})
+	elements := blockfilter.BasicElements(block, spentScripts)
+	require.Len(t, elements, 102)
+
+	filter, err := blockfilter.BuildBasic(hash, elements)
+	require.NoError(t, err)
+	for _, element := range elements {
+		match, err := blockfilter.Match(hash, filter, element)
+		require.NoError(t, err)
+		require.True(t, match)
+	}
+	match, err := blockfilter.Match(hash, filter, []byte{0x6a, 0x01, 0x01})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	_, err = blockfilter.Match(hash, filter[:len(filter)/2], []byte{0x51})
+	require.ErrorIs(t, err, blockfilter.ErrInvalidFilter)
+
+	match, err = blockfilter.MatchAny(hash, filter, [][]byte{{0x6a, 0x01, 0x01}, {0x52}})
+	require.NoError(t, err)
+	require.True(t, match)
+	match, err = blockfilter.MatchAny(hash, filter, [][]byte{{0x6a, 0x01, 0x01}, {}})
+	require.NoError(t, err)
+	require.False(t, match)
+
+	empty, err := blockfilter.BuildBasic(hash, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0}, empty)
+}
@@ -0,0 +1,154 @@
+package blockfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Parameters of the Golomb-coded sets of basic filters (https://github.com/bitcoin/bips/blob/master/bip-0158.mediawiki#block-filters)
+const (
+	// number of low bits of each delta written as they are
+	basicFilterP = 19
+	// inverse of the false positive rate
+	basicFilterM = 784931
+)
+
+var ErrInvalidFilter = errors.New("invalid block filter")
+
+// bitWriter writes bits to a byte slice, most significant bit first
+type bitWriter struct {
+	data []byte
+	// number of bits of the last byte of data that are written
+	used uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.used%8 == 0 {
+		w.data = append(w.data, 0)
+		w.used = 0
+	}
+	if bit {
+		w.data[len(w.data)-1] |= 0x80 >> w.used
+	}
+	w.used++
+}
+
+// writeBits writes the count least significant bits of value, most significant first
+func (w *bitWriter) writeBits(value uint64, count uint) {
+	for i := count; i > 0; i-- {
+		w.writeBit(value>>(i-1)&1 == 1)
+	}
+}
+
+// bitReader reads the bits written by a bitWriter
+type bitReader struct {
+	data []byte
+	// index of the next bit to read
+	offset int
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.offset >= 8*len(r.data) {
+		return false, io.ErrUnexpectedEOF
+	}
+	bit := r.data[r.offset/8]&(0x80>>(r.offset%8)) != 0
+	r.offset++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(count uint) (uint64, error) {
+	var value uint64
+	for range count {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
+
+// hashToRange maps element to [0, n*m) with the SipHash keyed by the first 16 bytes of the block hash
+func hashToRange(key message.Hash256, element []byte, n uint64) uint64 {
+	k0, k1 := filterKey(key)
+	hi, _ := bits.Mul64(SipHash(k0, k1, element), n*basicFilterM)
+	return hi
+}
+
+func filterKey(key message.Hash256) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:16])
+}
+
+// encodeGCS returns the Golomb-coded set of elements: their number as a varint, followed by the Golomb-Rice coded deltas between their
+// sorted hashes
+func encodeGCS(key message.Hash256, elements [][]byte) ([]byte, error) {
+	n := uint64(len(elements))
+	values := make([]uint64, len(elements))
+	for i, element := range elements {
+		values[i] = hashToRange(key, element, n)
+	}
+	slices.Sort(values)
+
+	encoded, err := message.VarInt(n).Encode()
+	if err != nil {
+		return nil, err
+	}
+	w := &bitWriter{}
+	var previous uint64
+	for _, value := range values {
+		delta := value - previous
+		for range delta >> basicFilterP {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, basicFilterP)
+		previous = value
+	}
+	return append(encoded, w.data...), nil
+}
+
+// decodeGCS returns the sorted hashes of the elements of the Golomb-coded set filter and their number
+func decodeGCS(filter []byte) ([]uint64, uint64, error) {
+	r := bytes.NewReader(filter)
+	n, err := message.DecodeVarInt(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+	}
+	count := uint64(n)
+	// every element takes at least P+1 bits
+	if count > uint64(r.Len())*8/(basicFilterP+1) {
+		return nil, 0, fmt.Errorf("%w: %d elements in %d bytes", ErrInvalidFilter, count, r.Len())
+	}
+	br := &bitReader{data: filter[len(filter)-r.Len():]}
+	values := make([]uint64, count)
+	var previous uint64
+	for i := range values {
+		var quotient uint64
+		for {
+			bit, err := br.readBit()
+			if err != nil {
+				return nil, 0, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+			}
+			if !bit {
+				break
+			}
+			quotient++
+		}
+		remainder, err := br.readBits(basicFilterP)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrInvalidFilter, err)
+		}
+		values[i] = previous + quotient<<basicFilterP + remainder
+		previous = values[i]
+	}
+	return values, count, nil
+}
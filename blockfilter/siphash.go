@@ -0,0 +1,56 @@
+package blockfilter
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SipHash returns the SipHash-2-4 of data keyed by k0 and k1 (https://github.com/bitcoin/bitcoin/blob/v27.0/src/crypto/siphash.cpp)
+func SipHash(k0, k1 uint64, data []byte) uint64 {
+	v0 := 0x736f6d6570736575 ^ k0
+	v1 := 0x646f72616e646f6d ^ k1
+	v2 := 0x6c7967656e657261 ^ k0
+	v3 := 0x7465646279746573 ^ k1
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+	// the last word holds the remaining bytes and the length of data in its most significant byte
+	var last [8]byte
+	copy(last[:], data)
+	m := binary.LittleEndian.Uint64(last[:]) | uint64(length)<<56
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,172 @@
+// Command bitcoin-node-cli calls the JSON-RPC methods of a running node, like bitcoin-cli does for bitcoind:
+//
+//	bitcoin-node-cli [options] <method> [params...]
+//
+// It authenticates with -rpcuser and -rpcpassword, or with the cookie file the node writes to its data directory when it runs without
+// -rpcpassword. Results which are strings are printed as they are, and other results as indented JSON. Errors are printed to stderr, and
+// the command exits with the absolute value of their code.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/rpc"
+	"github.com/aang114/bitcoin-node/storage"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Time waited between two attempts to reach the node with -rpcwait
+const rpcWaitRetryInterval = time.Second
+
+func main() {
+	flags := flag.NewFlagSet("bitcoin-node-cli", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: bitcoin-node-cli [options] <method> [params...]\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	rpcAddr := flags.String("rpcaddr", constants.DefaultRPCAddr, "Address the node serves JSON-RPC requests on")
+	rpcUser := flags.String("rpcuser", "", "User name to authenticate with (the cookie file is used without -rpcpassword)")
+	rpcPassword := flags.String("rpcpassword", "", "Password to authenticate with (empty to read the credentials from the cookie file)")
+	rpcCookieFile := flags.String("rpccookiefile", "", "Cookie file to read the credentials from (empty for the .cookie file of the data directory)")
+	dataDir := flags.String("datadir", constants.DefaultDataDir, "Data directory of the node, whose network subdirectory holds the cookie file")
+	chain := flags.String("chain", constants.MainnetParams.Name, "Network the node joins (mainnet, testnet or regtest), whose subdirectory of -datadir holds the cookie file")
+	rpcWait := flags.Bool("rpcwait", false, "Wait for the node to start serving JSON-RPC requests")
+	rpcWaitTimeout := flags.Duration("rpcwaittimeout", 0, "How long -rpcwait waits for the node (0 to wait forever)")
+	rpcClientTimeout := flags.Duration("rpcclienttimeout", 15*time.Minute, "How long to wait for the answer of the node (0 to wait forever)")
+	named := flags.Bool("named", false, "Pass the parameters by name, as name=value arguments")
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	method, args := flags.Arg(0), flags.Args()[1:]
+	var params any
+	var err error
+	if *named {
+		params, err = namedParams(method, args)
+	} else {
+		params, err = positionalParams(method, args)
+	}
+	if err != nil {
+		fail("error: %s", err)
+	}
+
+	chainParams, ok := constants.NetworkParamsByName(*chain)
+	if !ok {
+		fail("error: unknown chain %s: expected mainnet, testnet or regtest", *chain)
+	}
+
+	conn := connection{addr: *rpcAddr, user: *rpcUser, password: *rpcPassword, cookiePath: *rpcCookieFile}
+	if conn.password == "" && conn.cookiePath == "" {
+		conn.cookiePath = filepath.Join(*dataDir, chainParams.DataDir, constants.RPCCookieFileName)
+	}
+	ctx := context.Background()
+	if *rpcClientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *rpcClientTimeout)
+		defer cancel()
+	}
+	result, err := call(ctx, conn, method, params, *rpcWait, *rpcWaitTimeout)
+	var rpcErr *rpc.Error
+	if errors.As(err, &rpcErr) {
+		fmt.Fprintf(os.Stderr, "error code: %d\nerror message:\n%s\n", rpcErr.Code, rpcErr.Message)
+		os.Exit(max(rpcErr.Code, -rpcErr.Code))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		fail("error: %s\n\nMake sure the node is running, or pass -rpcpassword, -rpccookiefile or the -datadir of the node.", err)
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		fail("error: Could not connect to the server %s (%s)\n\nMake sure the node is running and that -rpcaddr is the address it serves JSON-RPC requests on.",
+			*rpcAddr, err)
+	}
+	if err != nil {
+		fail("error: %s", err)
+	}
+
+	output, err := format(result)
+	if err != nil {
+		fail("error: %s", err)
+	}
+	if output != "" {
+		fmt.Println(output)
+	}
+}
+
+// connection is the address of the node and the credentials to authenticate with, read from the cookie file if there is no password
+type connection struct {
+	addr       string
+	user       string
+	password   string
+	cookiePath string
+}
+
+// call calls method with params. The cookie file is read before every call, as the node writes a new one each time it starts.
+func (c connection) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	user, password := c.user, c.password
+	if password == "" {
+		var err error
+		user, password, err = rpc.ReadCookie(storage.OSFS{}, c.cookiePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the cookie file: %w", err)
+		}
+	}
+	return rpc.NewClient("http://"+c.addr, user, password).Call(ctx, method, params)
+}
+
+// call calls method with params, and if wait is set retries until the node can be reached or timeout has passed (0 to retry forever)
+func call(ctx context.Context, conn connection, method string, params any, wait bool, timeout time.Duration) (json.RawMessage, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		result, err := conn.call(ctx, method, params)
+		// the node is not serving requests yet if it cannot be connected to or has not written its cookie file yet
+		var opErr *net.OpError
+		starting := errors.As(err, &opErr) || errors.Is(err, fs.ErrNotExist)
+		if !wait || !starting || ctx.Err() != nil {
+			return result, err
+		}
+		if timeout > 0 && time.Now().Add(rpcWaitRetryInterval).After(deadline) {
+			return nil, fmt.Errorf("timeout on transient error: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(rpcWaitRetryInterval):
+		}
+	}
+}
+
+// format returns result as bitcoin-cli prints it: nothing for null, strings without quotes, and anything else as JSON indented by 2 spaces
+func format(result json.RawMessage) (string, error) {
+	result = bytes.TrimSpace(result)
+	if len(result) == 0 || string(result) == "null" {
+		return "", nil
+	}
+	if result[0] == '"' {
+		var s string
+		err := json.Unmarshal(result, &s)
+		return s, err
+	}
+	var indented bytes.Buffer
+	err := json.Indent(&indented, result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return indented.String(), nil
+}
+
+// fail prints the error described by format and args to stderr, and exits with status 1
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
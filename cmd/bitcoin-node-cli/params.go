@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// convertParam is a parameter of a method which is not a string, so that its argument is passed as JSON rather than as a string
+type convertParam struct {
+	method string
+	index  int
+	name   string
+}
+
+// Parameters of the node's methods whose arguments are passed as JSON, like bitcoin-cli's
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/client.cpp). The arguments of the other parameters are passed as strings, so that
+// e.g. hashes made of digits only are not taken for numbers.
+var convertParams = []convertParam{
+	{"disconnectnode", 1, "nodeid"},
+	{"getblock", 1, "verbosity"},
+	{"getblockfrompeer", 1, "peer_id"},
+	{"getblockheader", 1, "verbose"},
+	{"getrawtransaction", 1, "verbose"},
+	{"scanblocks", 1, "scanobjects"},
+	{"scanblocks", 2, "start_height"},
+	{"scanblocks", 3, "stop_height"},
+	{"sendrawtransaction", 1, "maxfeerate"},
+	{"sendrawtransaction", 2, "maxburnamount"},
+	{"setban", 2, "bantime"},
+	{"setban", 3, "absolute"},
+}
+
+// positionalParams returns the arguments of a call to method as parameters in order
+func positionalParams(method string, args []string) ([]json.RawMessage, error) {
+	params := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		convert := slices.ContainsFunc(convertParams, func(p convertParam) bool { return p.method == method && p.index == i })
+		param, err := encodeParam(arg, convert)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = param
+	}
+	return params, nil
+}
+
+// namedParams returns the arguments of a call to method, of the form name=value, as parameters by name
+func namedParams(method string, args []string) (map[string]json.RawMessage, error) {
+	params := make(map[string]json.RawMessage, len(args))
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("no '=' in named argument '%s', this may be because a positional argument was passed with -named", arg)
+		}
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("parameter %s specified multiple times", name)
+		}
+		convert := slices.ContainsFunc(convertParams, func(p convertParam) bool { return p.method == method && p.name == name })
+		param, err := encodeParam(value, convert)
+		if err != nil {
+			return nil, err
+		}
+		params[name] = param
+	}
+	return params, nil
+}
+
+// encodeParam encodes arg as a JSON string, or checks that it is valid JSON if it is to be converted
+func encodeParam(arg string, convert bool) (json.RawMessage, error) {
+	if !convert {
+		return json.Marshal(arg)
+	}
+	if !json.Valid([]byte(arg)) {
+		return nil, fmt.Errorf("error parsing JSON: %s", arg)
+	}
+	return json.RawMessage(arg), nil
+}
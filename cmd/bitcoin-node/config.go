@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// applyConfig sets the flags of flags which were neither passed on the command line nor set from the environment from the configuration file
+// read from r, so that the file holds the defaults of a deployment which variables and flags override. Each line of the file sets one flag,
+// as name=value without the leading dash (e.g. rpcaddr=0.0.0.0:8332, txindex=1), like the bitcoin.conf of Bitcoin Core; blank lines and
+// lines starting with # are ignored. Repeatable flags take a value per line. It returns the names of the flags applied, and of the names in
+// the file which match no flag, which are likely misspelled.
+func applyConfig(flags *flag.FlagSet, r io.Reader) (applied []string, unknown []string, err error) {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	isApplied := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: expected name=value, got %q", line, text)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		f := flags.Lookup(name)
+		if f == nil || f.Name == "conf" {
+			unknown = append(unknown, name)
+			continue
+		}
+		if set[f.Name] {
+			continue
+		}
+		err := flags.Set(f.Name, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid value %q for %s: %w", line, value, name, err)
+		}
+		if !isApplied[f.Name] {
+			isApplied[f.Name] = true
+			applied = append(applied, f.Name)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+	return applied, unknown, nil
+}
+
+// readConfigFile applies the configuration file at path to flags with applyConfig, returning its path along with the flags applied and the
+// unknown names. Without a path, it reads constants.ConfigFileName in dataDir if it exists, which the value of -datadir set from the
+// environment or the command line is used to find, but not a value of -datadir in the file itself.
+func readConfigFile(flags *flag.FlagSet, path string, dataDir string) (string, []string, []string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = filepath.Join(dataDir, constants.ConfigFileName)
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return path, nil, nil, nil
+	}
+	if err != nil {
+		return path, nil, nil, err
+	}
+	defer file.Close()
+
+	applied, unknown, err := applyConfig(flags, file)
+	if err != nil {
+		return path, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return path, applied, unknown, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		config  string
+		// expected values of the flags once the environment and the configuration file are applied
+		rpcAddr  string
+		txIndex  bool
+		addNodes string
+		applied  []string
+		unknown  []string
+		err      string
+	}{
+		{
+			name:    "an empty file should keep the defaults",
+			config:  "\n# nothing to see\n",
+			rpcAddr: "127.0.0.1:8332",
+		},
+		{
+			name:     "the file should override the defaults",
+			config:   "# rpc\nrpcaddr = 0.0.0.0:8332\ntxindex=1\naddnode=10.0.0.1:8333\naddnode=10.0.0.2:8333\n",
+			rpcAddr:  "0.0.0.0:8332",
+			txIndex:  true,
+			addNodes: "10.0.0.1:8333,10.0.0.2:8333",
+			applied:  []string{"rpcaddr", "txindex", "addnode"},
+		},
+		{
+			name:     "flags should override the environment, which overrides the file",
+			args:     []string{"-rpcaddr", "127.0.0.1:18332"},
+			environ:  []string{"BITCOIN_NODE_RPCADDR=0.0.0.0:8332", "BITCOIN_NODE_ADDNODE=10.0.0.3:8333"},
+			config:   "rpcaddr=10.0.0.1:8332\naddnode=10.0.0.1:8333\ntxindex=1\n",
+			rpcAddr:  "127.0.0.1:18332",
+			txIndex:  true,
+			addNodes: "10.0.0.3:8333",
+			applied:  []string{"txindex"},
+		},
+		{
+			name:    "names matching no flag should be reported, and the others applied",
+			config:  "rpcaddress=0.0.0.0:8332\nconf=other.conf\ntxindex=1\n",
+			rpcAddr: "127.0.0.1:8332",
+			txIndex: true,
+			applied: []string{"txindex"},
+			unknown: []string{"rpcaddress", "conf"},
+		},
+		{
+			name:   "lines without a value should be rejected",
+			config: "txindex=1\ntxindex\n",
+			err:    `line 2: expected name=value, got "txindex"`,
+		},
+		{
+			name:   "malformed values should be rejected",
+			config: "txindex=maybe\n",
+			err:    `line 1: invalid value "maybe" for txindex`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("bitcoin-node", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			rpcAddr := flags.String("rpcaddr", "127.0.0.1:8332", "")
+			txIndex := flags.Bool("txindex", false, "")
+			var addNodes addrsFlag
+			flags.Var(&addNodes, "addnode", "")
+			flags.String("conf", "", "")
+			require.NoError(t, flags.Parse(test.args))
+			_, _, err := applyEnv(flags, test.environ)
+			require.NoError(t, err)
+
+			applied, unknown, err := applyConfig(flags, strings.NewReader(test.config))
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.applied, applied)
+			require.Equal(t, test.unknown, unknown)
+			require.Equal(t, test.rpcAddr, *rpcAddr)
+			require.Equal(t, test.txIndex, *txIndex)
+			require.Equal(t, test.addNodes, addNodes.String())
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *bool) {
+		flags := flag.NewFlagSet("bitcoin-node", flag.ContinueOnError)
+		return flags, flags.Bool("txindex", false, "")
+	}
+	dataDir := t.TempDir()
+
+	t.Run("a missing file in the data directory should be ignored", func(t *testing.T) {
+		flags, _ := newFlags()
+		path, applied, _, err := readConfigFile(flags, "", dataDir)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dataDir, constants.ConfigFileName), path)
+		require.Empty(t, applied)
+	})
+
+	t.Run("a missing file passed with -conf should be an error", func(t *testing.T) {
+		flags, _ := newFlags()
+		_, _, _, err := readConfigFile(flags, filepath.Join(dataDir, "missing.conf"), dataDir)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("the file in the data directory should be read without -conf", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, constants.ConfigFileName), []byte("txindex=1\n"), 0o644))
+		flags, txIndex := newFlags()
+		_, applied, _, err := readConfigFile(flags, "", dataDir)
+		require.NoError(t, err)
+		require.Equal(t, []string{"txindex"}, applied)
+		require.True(t, *txIndex)
+	})
+}
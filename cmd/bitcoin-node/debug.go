@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/aang114/bitcoin-node/networking"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+// Subdirectory of the data directory the snapshots of /debug/snapshot are written to
+const debugSnapshotDirectory = "debug"
+
+// debugSnapshot is the list of files a /debug/snapshot request wrote
+type debugSnapshot struct {
+	// Stack traces of every goroutine, as text
+	Goroutines string `json:"goroutines"`
+	// Heap profile taken after a garbage collection, to be read with go tool pprof
+	Heap string `json:"heap"`
+}
+
+// serveDebug serves the profiles of net/http/pprof (/debug/pprof/), the variables of expvar along with the state of node (/debug/vars), and
+// writes a snapshot of the goroutines and of the heap to the debug subdirectory of dir when /debug/snapshot is posted to
+func serveDebug(addr string, node *networking.Node, dir string) *http.Server {
+	expvar.Publish("node", expvar.Func(func() any { return nodeVars(node) }))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /debug/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := writeSnapshot(filepath.Join(dir, debugSnapshotDirectory), time.Now())
+		if err != nil {
+			log.Printf("⚠️ Could not write debug snapshot due to error: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Wrote debug snapshot to %s and %s", snapshot.Goroutines, snapshot.Heap)
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(snapshot)
+		if err != nil {
+			log.Printf("⚠️ Could not write debug snapshot paths due to error: %s", err)
+		}
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Printf("📡 Serving profiles on http://%s/debug/pprof/ and runtime variables on http://%s/debug/vars", addr, addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("⚠️ Debug server failed with error: %s", err)
+		}
+	}()
+
+	return server
+}
+
+// nodeVars returns the state of node published under the "node" expvar
+func nodeVars(node *networking.Node) map[string]any {
+	vars := map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"peers":      len(node.Peers()),
+		"mempool":    node.MempoolInfo(),
+		"netTotals":  node.NetTotals(),
+	}
+	if chain, err := node.ChainInfo(); err == nil {
+		vars["chain"] = chain
+	}
+	return vars
+}
+
+// writeSnapshot writes the stack traces of every goroutine and a heap profile to dir, in files named after now
+func writeSnapshot(dir string, now time.Time) (debugSnapshot, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return debugSnapshot{}, err
+	}
+	stamp := now.UTC().Format("20060102T150405")
+	snapshot := debugSnapshot{
+		Goroutines: filepath.Join(dir, fmt.Sprintf("goroutines-%s.txt", stamp)),
+		Heap:       filepath.Join(dir, fmt.Sprintf("heap-%s.pb.gz", stamp)),
+	}
+
+	err = writeProfile(snapshot.Goroutines, "goroutine", 2)
+	if err != nil {
+		return debugSnapshot{}, err
+	}
+	// collecting garbage first, so that the profile shows the memory which is still in use
+	runtime.GC()
+	err = writeProfile(snapshot.Heap, "heap", 0)
+	if err != nil {
+		return debugSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// writeProfile writes the runtime profile called name to the file at path, in the format debug selects (see pprof.Profile.WriteTo)
+func writeProfile(path string, name string, debug int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = runtimepprof.Lookup(name).WriteTo(file, debug)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// decodeVerdict is what an implementation did with a raw message
+type decodeVerdict string
+
+const (
+	verdictAccepted decodeVerdict = "accepted"
+	// the command is unknown, so the message was skipped
+	verdictIgnored decodeVerdict = "ignored"
+	// the connection was dropped
+	verdictRejected decodeVerdict = "rejected"
+	// bitcoind neither answered nor dropped the connection in time
+	verdictNoAnswer decodeVerdict = "no answer"
+)
+
+// keepsConnection reports whether the connection survives a message with this verdict
+func (v decodeVerdict) keepsConnection() bool {
+	return v == verdictAccepted || v == verdictIgnored
+}
+
+// runDiffDecode implements the "diff-decode" subcommand, which feeds raw messages to our decoder and, optionally, to a bitcoind -regtest
+// node, and flags the messages that only one of them drops the connection over
+func runDiffDecode(args []string) {
+	fs := flag.NewFlagSet("diff-decode", flag.ExitOnError)
+	input := fs.String("in", "-", "File of hex-encoded raw messages (header and payload), one per line (- for stdin)")
+	bitcoindAddr := fs.String("bitcoind", "", "Address of a bitcoind -regtest whitebind to compare against (empty to only run our decoder)")
+	timeout := fs.Duration("timeout", 5*time.Second, "How long to wait for bitcoind to answer each message")
+	_ = fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatalf("Could not open %s: %s", *input, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	divergences := 0
+	scanner := bufio.NewScanner(r)
+	// room for the hex of the biggest message our decoder accepts
+	scanner.Buffer(nil, 2*(message.HeaderLength+32*1024*1024)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(text)
+		if err != nil {
+			log.Printf("⚠️ Skipping line %d as it is not hex: %s", line, err)
+			continue
+		}
+
+		ours, ourErr := ourVerdict(raw)
+		result := fmt.Sprintf("line %d: %s: ours=%s", line, rawCommand(raw), ours)
+		if ourErr != nil {
+			result += fmt.Sprintf(" (%s)", ourErr)
+		}
+		if *bitcoindAddr != "" {
+			theirs, err := bitcoindVerdict(*bitcoindAddr, raw, *timeout)
+			if err != nil {
+				log.Fatalf("Could not probe bitcoind at %s: %s", *bitcoindAddr, err)
+			}
+			result += fmt.Sprintf(" bitcoind=%s", theirs)
+			if diverges(ours, theirs) {
+				result += " ❗ DIVERGENCE"
+				divergences++
+			}
+		}
+		fmt.Println(result)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Could not read messages: %s", err)
+	}
+
+	if divergences > 0 {
+		log.Printf("Found %d divergences", divergences)
+		os.Exit(1)
+	}
+}
+
+// diverges reports whether only one of our decoder and bitcoind dropped the connection over a message. bitcoind not answering tells nothing.
+func diverges(ours, theirs decodeVerdict) bool {
+	return theirs != verdictNoAnswer && theirs.keepsConnection() != ours.keepsConnection()
+}
+
+// ourVerdict decodes raw the way a peer's read loop does
+func ourVerdict(raw []byte) (decodeVerdict, error) {
+	_, err := message.DecodeMessage(bytes.NewReader(raw))
+	unknownCommandErr := &message.ErrUnknownCommandName{}
+	switch {
+	case err == nil:
+		return verdictAccepted, nil
+	case errors.As(err, &unknownCommandErr):
+		return verdictIgnored, nil
+	default:
+		return verdictRejected, err
+	}
+}
+
+// rawCommand returns the command name in the header of raw, without decoding the rest of the message
+func rawCommand(raw []byte) string {
+	if len(raw) < message.HeaderLength {
+		return "(truncated header)"
+	}
+	return strings.TrimRight(string(raw[4:16]), "\x00")
+}
+
+// bitcoindVerdict sends raw, followed by a ping, to the bitcoind at addr on a fresh connection. bitcoind handles a peer's messages in order,
+// so raw was accepted (or ignored) if the ping is answered and rejected if the connection is dropped first.
+func bitcoindVerdict(addr string, raw []byte, timeout time.Duration) (decodeVerdict, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return "", err
+	}
+	err = regtestHandshake(conn)
+	if err != nil {
+		return "", fmt.Errorf("handshake failed: %w", err)
+	}
+
+	// messages with mainnet magic are sent with regtest magic instead, so that they are judged on their payload
+	raw = bytes.Clone(raw)
+	if len(raw) >= 4 && binary.LittleEndian.Uint32(raw) == constants.MainnetMagicValue {
+		binary.LittleEndian.PutUint32(raw, constants.RegtestMagicValue)
+	}
+	_, err = conn.Write(raw)
+	if err != nil {
+		return verdictRejected, nil
+	}
+	nonce := rand.Uint64()
+	ping, err := message.NewPingMessage(nonce)
+	if err != nil {
+		return "", err
+	}
+	err = writeRegtestMessage(conn, ping)
+	if err != nil {
+		return verdictRejected, nil
+	}
+
+	for {
+		msg, err := message.DecodeMessage(conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return verdictNoAnswer, nil
+		}
+		unknownCommandErr := &message.ErrUnknownCommandName{}
+		if errors.As(err, &unknownCommandErr) {
+			continue
+		}
+		if err != nil {
+			return verdictRejected, nil
+		}
+		if pong, ok := msg.Payload.(*message.PongPayload); ok && pong.Nonce == nonce {
+			return verdictAccepted, nil
+		}
+	}
+}
+
+// regtestHandshake performs the version handshake with a regtest node
+func regtestHandshake(conn net.Conn) error {
+	localAddr := conn.LocalAddr().(*net.TCPAddr)
+	remoteAddr := conn.RemoteAddr().(*net.TCPAddr)
+	version, err := message.NewVersionMessage(
+		constants.ProtocolVersion,
+		0,
+		time.Now().Unix(),
+		*message.NewNetworkAddress(0, remoteAddr.IP, uint16(remoteAddr.Port)),
+		*message.NewNetworkAddress(0, localAddr.IP, uint16(localAddr.Port)),
+		rand.Uint64(),
+		constants.UserAgent,
+		0,
+		false)
+	if err != nil {
+		return err
+	}
+	err = writeRegtestMessage(conn, version)
+	if err != nil {
+		return err
+	}
+
+	// bitcoind answers with its version, feature negotiation messages and a verack
+	for {
+		msg, err := message.DecodeMessage(conn)
+		unknownCommandErr := &message.ErrUnknownCommandName{}
+		if errors.As(err, &unknownCommandErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Header.Magic != constants.RegtestMagicValue {
+			return errors.New("not a regtest node")
+		}
+		if msg.Header.Command == message.VerackCommand {
+			break
+		}
+	}
+	verack, err := message.NewVerackMessage()
+	if err != nil {
+		return err
+	}
+	return writeRegtestMessage(conn, verack)
+}
+
+func writeRegtestMessage(conn net.Conn, msg *message.Message) error {
+	msg.Header.Magic = constants.RegtestMagicValue
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(encoded)
+	return err
+}
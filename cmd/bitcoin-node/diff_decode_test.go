@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// encodeMessage returns the raw bytes of the message newMessage creates
+func encodeMessage(t *testing.T, newMessage func() (*message.Message, error)) []byte {
+	t.Helper()
+	msg, err := newMessage()
+	require.NoError(t, err)
+	encoded, err := msg.Encode()
+	require.NoError(t, err)
+	return encoded
+}
+
+func newPing() (*message.Message, error) {
+	return message.NewPingMessage(1)
+}
+
+func TestOurVerdict(t *testing.T) {
+	ping := encodeMessage(t, newPing)
+	badChecksum := append([]byte(nil), ping...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	unknownCommand := append([]byte(nil), ping...)
+	copy(unknownCommand[4:16], "notacommand\x00")
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		command string
+		verdict decodeVerdict
+	}{
+		{name: "well-formed message", raw: ping, command: "ping", verdict: verdictAccepted},
+		{name: "unknown command", raw: unknownCommand, command: "notacommand", verdict: verdictIgnored},
+		{name: "payload not matching its checksum", raw: badChecksum, command: "ping", verdict: verdictRejected},
+		{name: "truncated header", raw: ping[:10], command: "(truncated header)", verdict: verdictRejected},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.command, rawCommand(test.raw))
+			verdict, err := ourVerdict(test.raw)
+			require.Equal(t, test.verdict, verdict)
+			if test.verdict == verdictRejected {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBitcoindVerdict(t *testing.T) {
+	// a regtest peer standing in for bitcoind, which answers pings and drops the connection over sendheaders messages
+	bitcoind := networkingtest.NewFakePeer(t)
+	bitcoind.UseMagic(constants.RegtestMagicValue)
+	bitcoind.Handle(message.SendHeadersCommand, func(c *networkingtest.Conn, msg *message.Message) {
+		c.Close()
+	})
+
+	t.Run("a message both implementations accept should not diverge", func(t *testing.T) {
+		raw := encodeMessage(t, newPing)
+		ours, err := ourVerdict(raw)
+		require.NoError(t, err)
+		theirs, err := bitcoindVerdict(bitcoind.Addr().String(), raw, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, verdictAccepted, ours)
+		require.Equal(t, verdictAccepted, theirs)
+		require.False(t, diverges(ours, theirs))
+	})
+
+	t.Run("a message only bitcoind drops the connection over should diverge", func(t *testing.T) {
+		raw := encodeMessage(t, message.NewSendHeadersMessage)
+		ours, err := ourVerdict(raw)
+		require.NoError(t, err)
+		theirs, err := bitcoindVerdict(bitcoind.Addr().String(), raw, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, verdictAccepted, ours)
+		require.Equal(t, verdictRejected, theirs)
+		require.True(t, diverges(ours, theirs))
+	})
+
+	t.Run("a message both implementations drop the connection over should not diverge", func(t *testing.T) {
+		raw := encodeMessage(t, newPing)
+		raw[len(raw)-1] ^= 0xff
+		ours, err := ourVerdict(raw)
+		require.Error(t, err)
+		theirs, err := bitcoindVerdict(bitcoind.Addr().String(), raw, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, verdictRejected, ours)
+		require.Equal(t, verdictRejected, theirs)
+		require.False(t, diverges(ours, theirs))
+	})
+
+	t.Run("bitcoind not answering should not count as a divergence", func(t *testing.T) {
+		require.False(t, diverges(verdictRejected, verdictNoAnswer))
+	})
+}
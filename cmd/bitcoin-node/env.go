@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Prefix of the environment variables setting the flags of the node, e.g. BITCOIN_NODE_RPCADDR for -rpcaddr
+const envPrefix = "BITCOIN_NODE_"
+
+// applyEnv sets the flags of flags which were not passed on the command line from the environment variables of environ named after them: the
+// name of the flag in upper case, prefixed with envPrefix. Flags passed on the command line take precedence, so that a variable set for a
+// container can be overridden for one run. Repeatable flags take several values separated by whitespace. It returns the names of the
+// variables applied, and of the variables with the prefix which match no flag, which are likely misspelled.
+func applyEnv(flags *flag.FlagSet, environ []string) (applied []string, unknown []string, err error) {
+	passed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	byVariable := make(map[string]*flag.Flag)
+	flags.VisitAll(func(f *flag.Flag) {
+		byVariable[envPrefix+strings.ToUpper(f.Name)] = f
+	})
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		f, ok := byVariable[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if passed[f.Name] {
+			continue
+		}
+		values := []string{value}
+		if repeatable(f.Value) {
+			values = strings.Fields(value)
+		}
+		for _, value := range values {
+			err := flags.Set(f.Name, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+		}
+		applied = append(applied, name)
+	}
+	return applied, unknown, nil
+}
+
+// repeatable reports whether value is the value of a flag which can be repeated, each occurrence adding a value
+func repeatable(value flag.Value) bool {
+	switch value.(type) {
+	case *addrsFlag, *bindingsFlag, *regexpsFlag:
+		return true
+	}
+	return false
+}
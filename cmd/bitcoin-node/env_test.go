@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		// expected values of the flags once the environment is applied
+		rpcAddr  string
+		txIndex  bool
+		addNodes string
+		allowUAs string
+		applied  []string
+		unknown  []string
+		err      string
+	}{
+		{
+			name:    "flags should keep their defaults without variables",
+			rpcAddr: "127.0.0.1:8332",
+		},
+		{
+			name:    "variables should override the defaults",
+			environ: []string{"BITCOIN_NODE_RPCADDR=0.0.0.0:8332", "BITCOIN_NODE_TXINDEX=1"},
+			rpcAddr: "0.0.0.0:8332",
+			txIndex: true,
+			applied: []string{"BITCOIN_NODE_RPCADDR", "BITCOIN_NODE_TXINDEX"},
+		},
+		{
+			name:     "flags passed on the command line should override the variables",
+			args:     []string{"-rpcaddr", "127.0.0.1:18332", "-addnode", "10.0.0.3:8333"},
+			environ:  []string{"BITCOIN_NODE_RPCADDR=0.0.0.0:8332", "BITCOIN_NODE_ADDNODE=10.0.0.1:8333", "BITCOIN_NODE_TXINDEX=true"},
+			rpcAddr:  "127.0.0.1:18332",
+			txIndex:  true,
+			addNodes: "10.0.0.3:8333",
+			applied:  []string{"BITCOIN_NODE_TXINDEX"},
+		},
+		{
+			name:     "repeatable flags should take values separated by whitespace",
+			environ:  []string{"BITCOIN_NODE_ADDNODE=10.0.0.1:8333  10.0.0.2:8333", "BITCOIN_NODE_ALLOWUA=^/Satoshi:27 \t^/btcd:"},
+			rpcAddr:  "127.0.0.1:8332",
+			addNodes: "10.0.0.1:8333,10.0.0.2:8333",
+			allowUAs: "^/Satoshi:27,^/btcd:",
+			applied:  []string{"BITCOIN_NODE_ADDNODE", "BITCOIN_NODE_ALLOWUA"},
+		},
+		{
+			name:    "variables matching no flag should be reported, and the others ignored",
+			environ: []string{"BITCOIN_NODE_RPCADDRESS=0.0.0.0:8332", "RPCADDR=0.0.0.0:8332", "HOME=/root"},
+			rpcAddr: "127.0.0.1:8332",
+			unknown: []string{"BITCOIN_NODE_RPCADDRESS"},
+		},
+		{
+			name:    "malformed boolean values should be rejected",
+			environ: []string{"BITCOIN_NODE_TXINDEX=maybe"},
+			err:     `invalid value "maybe" for BITCOIN_NODE_TXINDEX`,
+		},
+		{
+			name:    "malformed values of repeatable flags should be rejected",
+			environ: []string{"BITCOIN_NODE_ALLOWUA=^/Satoshi ("},
+			err:     `invalid value "(" for BITCOIN_NODE_ALLOWUA`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("bitcoin-node", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			rpcAddr := flags.String("rpcaddr", "127.0.0.1:8332", "")
+			txIndex := flags.Bool("txindex", false, "")
+			var addNodes addrsFlag
+			flags.Var(&addNodes, "addnode", "")
+			var allowUAs regexpsFlag
+			flags.Var(&allowUAs, "allowua", "")
+			require.NoError(t, flags.Parse(test.args))
+
+			applied, unknown, err := applyEnv(flags, test.environ)
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.applied, applied)
+			require.Equal(t, test.unknown, unknown)
+			require.Equal(t, test.rpcAddr, *rpcAddr)
+			require.Equal(t, test.txIndex, *txIndex)
+			require.Equal(t, test.addNodes, addNodes.String())
+			require.Equal(t, test.allowUAs, allowUAs.String())
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// runExport implements the "export" subcommand, which writes the blocks of the stored chain (or of a range of its heights) to a file or to the
+// standard output, as raw blocks or as JSON or CSV summaries, without connecting to peers. The node must not be running, since the stored
+// blocks are read the way the node reads them when it starts.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	formatStr := fs.String("format", "csv", "Format of the exported blocks: raw (concatenated blocks, each preceded by the network magic and its size), json (one summary per line) or csv (one summary per row)")
+	from := fs.Int("from", 0, "Height of the first exported block")
+	to := fs.Int("to", -1, "Height of the last exported block (-1 for the tip)")
+	out := fs.String("out", "", "File to write the exported blocks to (empty for the standard output)")
+	blockStore := fs.String("blockstore", "file", "Where the node keeps its blocks: file, kv or blk (see the node's -blockstore flag)")
+	dataDir := fs.String("datadir", constants.DefaultDataDir, "Directory the data of each network is kept in (see the node's -datadir flag)")
+	chain := fs.String("chain", constants.MainnetParams.Name, "Network whose blocks are exported: mainnet, testnet or regtest")
+	_ = fs.Parse(args)
+
+	format, err := networking.ParseExportFormat(*formatStr)
+	if err != nil {
+		log.Fatalf("Could not parse the export format: %s", err)
+	}
+
+	params := chainParams(*chain)
+	dir := networkDataDir(storage.OSFS{}, *dataDir, params)
+	config := networking.DefaultConfig()
+	config.MinimumPeers = 0
+	config.BlocksFile = filepath.Join(dir, constants.BlocksFileName)
+	node := networking.NewNode(config)
+	err = node.SetNetworkParams(params)
+	if err != nil {
+		log.Fatalf("Could not join %s: %s", params.Name, err)
+	}
+	setBlockStore(node, storage.OSFS{}, dir, *blockStore, params)
+	err = node.LoadBlocks()
+	if err != nil {
+		log.Fatalf("Could not read the stored blocks: %s", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Could not create file %s: %s", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	exported, err := node.ExportBlocks(w, format, int32(*from), int32(*to))
+	if err != nil {
+		log.Fatalf("Export failed after %d blocks with error: %s", exported, err)
+	}
+	log.Printf("📤 Exported %d blocks", exported)
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/internal/logging"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/rpc"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/tracing"
+	"github.com/aang114/bitcoin-node/utxo"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultEventsAddr = "127.0.0.1:8335"
+
+// Peer connected to first on mainnet when no -peer is given (https://bitnodes.io/nodes/46.166.142.2:8333/)
+const defaultMainnetPeer = "46.166.142.2:8333"
+
+// Name of the service the exported spans belong to
+const tracingServiceName = "bitcoin-node"
+
+func init() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "watch":
+			runWatch(os.Args[2:])
+			return
+		case "diff-decode":
+			runDiffDecode(os.Args[2:])
+			return
+		case "seed-addrs":
+			runSeedAddrs(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
+	}
+
+	chain := flag.String("chain", constants.MainnetParams.Name, "Network to join: mainnet, testnet or regtest")
+	remoteAddrStr := flag.String("peer", "", "First Peer to Connect with (defaults to "+defaultMainnetPeer+" on mainnet)")
+	minPeers := flag.Int("minPeers", 5, "Minimum Number of Peers that the Node must be connected with at all times")
+	eventsAddr := flag.String("eventsaddr", defaultEventsAddr, "Address to serve the event stream and metrics on (empty to disable)")
+	rpcAddr := flag.String("rpcaddr", constants.DefaultRPCAddr, "Address to serve JSON-RPC requests on (empty to disable)")
+	rpcUser := flag.String("rpcuser", "", "User name JSON-RPC clients must authenticate with (cookie authentication is used without -rpcpassword)")
+	rpcPassword := flag.String("rpcpassword", "", "Password JSON-RPC clients must authenticate with (empty to write random credentials to the .cookie file of the data directory)")
+	debugAddr := flag.String("debugaddr", "", "Address to serve pprof profiles, expvar variables and goroutine and heap snapshots on (empty to disable)")
+	rest := flag.Bool("rest", false, "Serve the read-only REST interface on -rpcaddr, without authentication")
+	rpcThreads := flag.Int("rpcthreads", constants.DefaultRPCThreads, "Number of JSON-RPC and REST requests handled at once (0 for no limit)")
+	rpcWorkQueue := flag.Int("rpcworkqueue", constants.DefaultRPCWorkQueue, "Number of JSON-RPC and REST requests which may wait to be handled, the others being rejected")
+	rpcRate := flag.Float64("rpcrate", constants.DefaultRPCRequestRate, "JSON-RPC and REST requests per second each client IP address may make")
+	rpcBurst := flag.Int("rpcburst", constants.DefaultRPCRequestBurst, "JSON-RPC and REST requests each client IP address may make at once (0 to not rate limit clients)")
+	rpcMaxRequestSize := flag.Int("rpcmaxrequestsize", constants.DefaultRPCMaxRequestSizeMiB, "Size, in MiB, of the largest JSON-RPC request body accepted (0 for no limit)")
+	var addNodes, connectNodes addrsFlag
+	flag.Var(&addNodes, "addnode", "Peer to always keep connected to, in addition to the discovered peers (can be repeated)")
+	flag.Var(&connectNodes, "connect", "Only connect to this peer, disabling peer discovery (can be repeated; -peer is ignored)")
+	workers := flag.Int("workers", 0, "Number of block validation workers (0 to size by CPU count)")
+	msgBuffer := flag.Int("msgbuffer", 0, "Buffer length of the channels carrying messages from peers to the node (0 to size by CPU count)")
+	maxInFlight := flag.Int("maxinflight", 0, "Maximum number of blocks requested at once (0 to size by available memory)")
+	dialWorkers := flag.Int("dialworkers", constants.DefaultDialWorkers, "Maximum number of new peers dialed at once")
+	dialInterval := flag.Duration("dialinterval", constants.DefaultDialInterval, "Time waited between starting two dials to new peers (0 to not wait)")
+	var bindings bindingsFlag
+	flag.Var(&bindings, "bind", "Address to accept inbound connections on, followed by comma-separated options: onion, noban, allow=<cidr> (can be repeated)")
+	externalIP := flag.String("externalip", "", "IP address to advertise to peers (empty to use the address peers see us at)")
+	traceMsgs := flag.String("tracemsgs", "", "File to append every message exchanged with peers to, as lines of JSON (empty to disable)")
+	otlpEndpoint := flag.String("otlpendpoint", "", "OTLP/HTTP endpoint of an OpenTelemetry collector to export traces of message and block processing to, e.g. http://127.0.0.1:4318 (empty to disable)")
+	tracePayloads := flag.Bool("tracepayloads", false, "Include the hex of message payloads in the -tracemsgs file")
+	requiredServices := flag.String("services", "NODE_NETWORK", "Services peers must offer, as names joined by | (e.g. NODE_NETWORK|NODE_WITNESS) or a number")
+	var allowUserAgents, denyUserAgents regexpsFlag
+	flag.Var(&allowUserAgents, "allowua", "Regular expression that user agents of peers must match, if any is given (can be repeated; manual peers are exempt)")
+	flag.Var(&denyUserAgents, "denyua", "Regular expression of user agents of peers to disconnect from (can be repeated; manual peers are exempt)")
+	minProtocol := flag.Int("minprotocol", 0, "Lowest protocol version peers may announce (0 for no limit; manual peers are exempt)")
+	maxProtocol := flag.Int("maxprotocol", 0, "Highest protocol version peers may announce (0 for no limit; manual peers are exempt)")
+	inMemory := flag.Bool("inmemory", false, "Keep all storage in memory instead of writing to disk (for tests and short-lived runs)")
+	blockStore := flag.String("blockstore", "file", "Where blocks are kept: file (in memory, saved to the blocks file on exit), kv (in a key-value store as they arrive) or blk (appended to block files as they arrive)")
+	dbCache := flag.Int("dbcache", 0, "Memory the unspent outputs cached in memory may take, in MiB, before they are written to the chainstate database (0 to size by available memory)")
+	maxMempool := flag.Int("maxmempool", constants.DefaultMaxMempoolMiB, "Memory the transactions of the mempool may take, in MiB, before the ones paying the lowest fee rates are evicted")
+	mempoolExpiry := flag.Int("mempoolexpiry", int(constants.DefaultMempoolExpiry.Hours()), "Hours after which transactions that stayed unconfirmed expire from the mempool, with their descendants")
+	minRelayTxFee := flag.Int64("minrelaytxfee", constants.DefaultMinRelayFeeRate, "Fee rate, in satoshis per 1000 virtual bytes, transactions must pay to be accepted into the mempool and relayed")
+	dustRelayFee := flag.Int64("dustrelayfee", constants.DefaultDustRelayFeeRate, "Fee rate, in satoshis per 1000 virtual bytes, outputs worth less than the fee of spending them at are dust, which transactions must not create")
+	blockFilterIndex := flag.Bool("blockfilterindex", false, "Keep the basic filters (BIP158) of the blocks of the active chain and serve them to light clients (BIP157)")
+	txIndex := flag.Bool("txindex", false, "Index the transactions of the active chain by txid so that any of them can be looked up")
+	checkBlocks := flag.Int("checkblocks", constants.DefaultCheckBlocks, "Number of the last blocks of the active chain read back and verified at startup (-1 for all of them, 0 for none)")
+	conf := flag.String("conf", "", "Configuration file of name=value lines setting the flags which are neither passed nor set in the environment (empty to read "+constants.ConfigFileName+" in -datadir, if any)")
+	dataDir := flag.String("datadir", constants.DefaultDataDir, "Directory the data of each network is kept in, in a subdirectory named after the network (e.g. mainnet/)")
+	logFile := flag.String("logfile", "", "File to write the log to instead of stderr (empty to write to stderr)")
+	logMaxSize := flag.Int("logmaxsize", constants.DefaultLogMaxSizeMiB, "Size, in MiB, the log file is rotated at (0 for no limit)")
+	logMaxAge := flag.Duration("logmaxage", constants.DefaultLogMaxAge, "Age the log file is rotated at (0 for no limit)")
+	logBackups := flag.Int("logbackups", constants.DefaultLogBackups, "Number of rotated log files kept, the oldest being removed (0 to keep them all)")
+	stopTimeout := flag.Duration("stoptimeout", constants.DefaultStopTimeout, "Time the node may take to finish handling its current message when it is stopped, after which it quits without saving its state")
+	flag.Parse()
+	envVariables, unknownEnvVariables, err := applyEnv(flag.CommandLine, os.Environ())
+	if err != nil {
+		log.Fatalf("Could not read the configuration from the environment: %s", err)
+	}
+	confPath, confFlags, unknownConfFlags, err := readConfigFile(flag.CommandLine, *conf, *dataDir)
+	if err != nil {
+		log.Fatalf("Could not read the configuration file: %s", err)
+	}
+
+	if *logFile != "" {
+		file, err := logging.OpenRotatingFile(*logFile, int64(*logMaxSize)*1024*1024, *logMaxAge, *logBackups)
+		if err != nil {
+			log.Fatalf("Could not open the log file: %s", err)
+		}
+		defer file.Close()
+		log.SetOutput(file)
+	}
+	if len(envVariables) > 0 {
+		log.Printf("Configured by environment variables %s", strings.Join(envVariables, ", "))
+	}
+	for _, name := range unknownEnvVariables {
+		log.Printf("⚠️ Ignoring environment variable %s, which matches no flag", name)
+	}
+	if len(confFlags) > 0 {
+		log.Printf("Configured by %s: %s", confPath, strings.Join(confFlags, ", "))
+	}
+	for _, name := range unknownConfFlags {
+		log.Printf("⚠️ Ignoring %s in %s, which matches no flag", name, confPath)
+	}
+
+	params := chainParams(*chain)
+	var fs storage.FS = storage.OSFS{}
+	if *inMemory {
+		log.Printf("Running in in-memory mode: nothing will be persisted to disk")
+		fs = storage.NewMemFS()
+	}
+	dir := networkDataDir(fs, *dataDir, params)
+
+	resources := networking.DetectResources()
+	tuning := networking.AutoTuning(resources)
+	if *workers > 0 {
+		tuning.ValidationWorkers = *workers
+	}
+	if *msgBuffer > 0 {
+		tuning.MessageBufferSize = *msgBuffer
+	}
+	if *maxInFlight > 0 {
+		tuning.MaxBlocksInFlight = *maxInFlight
+	}
+	tuning.DialWorkers = max(*dialWorkers, 1)
+	tuning.DialInterval = *dialInterval
+	dbCacheMiB := networking.AutoDBCacheMiB(resources)
+	if *dbCache > 0 {
+		dbCacheMiB = *dbCache
+	}
+	log.Printf("Detected %d CPUs and %d MiB of available memory; using %s and a UTXO cache of %d MiB", resources.CPUs, resources.MemoryBytes/(1024*1024), tuning,
+		dbCacheMiB)
+
+	config := networking.DefaultConfig()
+	config.MinimumPeers = *minPeers
+	config.BlocksFile = filepath.Join(dir, constants.BlocksFileName)
+	config.FS = fs
+	config.Tuning = tuning
+	node := networking.NewNode(config)
+
+	err = node.SetNetworkParams(params)
+	if err != nil {
+		log.Fatalf("Could not join %s: %s", params.Name, err)
+	}
+	setBlockStore(node, fs, dir, *blockStore, params)
+	node.SetMaxMempoolSize(*maxMempool * 1024 * 1024)
+	node.SetMempoolExpiry(time.Duration(*mempoolExpiry) * time.Hour)
+	node.SetMempoolPolicy(networking.MempoolPolicy{MinRelayFeeRate: *minRelayTxFee, DustRelayFeeRate: *dustRelayFee})
+	node.SetCheckBlocks(*checkBlocks)
+	utxoDB, err := utxo.OpenDB(fs, filepath.Join(dir, constants.ChainstateDirectory))
+	if err != nil {
+		log.Fatalf("Could not open the chainstate database: %s", err)
+	}
+	err = node.SetUTXODatabase(utxoDB, dbCacheMiB*1024*1024)
+	if err != nil {
+		log.Fatalf("Could not read the chainstate database: %s", err)
+	}
+	if *txIndex {
+		index, err := networking.OpenTxIndex(fs, filepath.Join(dir, constants.TxIndexDirectory))
+		if err != nil {
+			log.Fatalf("Could not open the transaction index: %s", err)
+		}
+		node.SetTxIndex(index)
+	}
+	if *blockFilterIndex {
+		index, err := networking.OpenBlockFilterIndex(fs, filepath.Join(dir, constants.BlockFilterIndexDirectory))
+		if err != nil {
+			log.Fatalf("Could not open the block filter index: %s", err)
+		}
+		err = node.SetBlockFilterIndex(index)
+		if err != nil {
+			log.Fatalf("Could not index the filter of the genesis block: %s", err)
+		}
+	}
+
+	services, err := message.ParseServices(*requiredServices)
+	if err != nil {
+		log.Fatalf("Could not parse required services: %s", err)
+	}
+	node.SetRequiredServices(services)
+	node.SetPeerFilter(networking.PeerFilter{
+		AllowUserAgents:    allowUserAgents,
+		DenyUserAgents:     denyUserAgents,
+		MinProtocolVersion: int32(*minProtocol),
+		MaxProtocolVersion: int32(*maxProtocol),
+	})
+
+	if *traceMsgs != "" {
+		traceFile, err := os.OpenFile(*traceMsgs, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Could not open message trace file: %s", err)
+		}
+		defer traceFile.Close()
+		node.SetMessageTracer(networking.NewMessageTraceWriter(traceFile), *tracePayloads)
+	}
+
+	if *otlpEndpoint != "" {
+		tracer := tracing.NewTracer(tracing.NewOTLPExporter(*otlpEndpoint, tracingServiceName))
+		tracing.SetTracer(tracer)
+		defer tracer.Shutdown()
+		log.Printf("📡 Exporting traces to %s", *otlpEndpoint)
+	}
+
+	if *externalIP != "" {
+		ip := net.ParseIP(*externalIP)
+		if ip == nil {
+			log.Fatalf("Could not parse external IP %s", *externalIP)
+		}
+		node.SetExternalIP(ip)
+	}
+
+	if len(bindings) > 0 {
+		err := node.Listen(bindings)
+		if err != nil {
+			log.Fatalf("Listening failed with error: %s", err)
+		}
+	}
+
+	if len(connectNodes) > 0 {
+		node.DisableDiscovery()
+		for _, addr := range connectNodes {
+			node.AddManualPeer(addr)
+		}
+	} else {
+		if *remoteAddrStr == "" && params.Name != constants.MainnetParams.Name {
+			log.Fatalf("No peer to connect to on %s: pass -peer or -connect", params.Name)
+		}
+		if *remoteAddrStr == "" {
+			*remoteAddrStr = defaultMainnetPeer
+		}
+		remoteAddr, err := net.ResolveTCPAddr("tcp", *remoteAddrStr)
+		if err != nil {
+			log.Fatalf("Could not parse first peer: %s", err)
+		}
+		_, err = node.AddPeer(remoteAddr)
+		if err != nil {
+			log.Fatalf("Adding Peer failed with error: %s", err)
+		}
+	}
+	for _, addr := range addNodes {
+		node.AddManualPeer(addr)
+	}
+
+	if *eventsAddr != "" {
+		server := serveHTTP(*eventsAddr, node)
+		defer server.Close()
+	}
+	if *debugAddr != "" {
+		server := serveDebug(*debugAddr, node, dir)
+		defer server.Close()
+	}
+	if *rpcAddr != "" {
+		user, password := *rpcUser, *rpcPassword
+		if password == "" {
+			cookiePath := filepath.Join(dir, constants.RPCCookieFileName)
+			user = rpc.CookieUser
+			password, err = rpc.WriteCookie(fs, cookiePath)
+			if err != nil {
+				log.Fatalf("Could not write the RPC cookie file: %s", err)
+			}
+			defer fs.Remove(cookiePath)
+		}
+		limits := rpc.Limits{
+			PerClient:     networking.RateLimit{Rate: *rpcRate, Burst: *rpcBurst},
+			MaxConcurrent: *rpcThreads,
+			MaxQueued:     *rpcWorkQueue,
+			MaxBodySize:   int64(*rpcMaxRequestSize) * 1024 * 1024,
+		}
+		server := serveRPC(*rpcAddr, node, user, password, *rest, limits)
+		defer server.Close()
+	}
+
+	logRuntimeStatsOnSignal(node)
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+	defer stop()
+
+	err = node.Start(ctx)
+	if err != nil {
+		log.Printf("Node has quit due to an unresolvable error: %s", err)
+	} else if ctx.Err() != nil {
+		log.Println("User sent a signal to quit the node")
+	}
+	stopCtx, cancel := context.WithTimeout(context.Background(), *stopTimeout)
+	defer cancel()
+	err = node.Stop(stopCtx)
+	if err != nil {
+		log.Printf("⚠️ Node did not stop cleanly: %s", err)
+	}
+
+	log.Println("Goodbye!")
+}
+
+// addrsFlag is a repeatable flag of peer addresses
+type addrsFlag []*net.TCPAddr
+
+func (a *addrsFlag) String() string {
+	addrs := make([]string, len(*a))
+	for i, addr := range *a {
+		addrs[i] = addr.String()
+	}
+	return strings.Join(addrs, ",")
+}
+
+func (a *addrsFlag) Set(value string) error {
+	addr, err := net.ResolveTCPAddr("tcp", value)
+	if err != nil {
+		return err
+	}
+	*a = append(*a, addr)
+	return nil
+}
+
+// bindingsFlag is a repeatable flag of listener bindings
+type bindingsFlag []networking.Binding
+
+func (b *bindingsFlag) String() string {
+	addrs := make([]string, len(*b))
+	for i, binding := range *b {
+		addrs[i] = binding.Addr
+	}
+	return strings.Join(addrs, ",")
+}
+
+func (b *bindingsFlag) Set(value string) error {
+	binding, err := networking.ParseBinding(value)
+	if err != nil {
+		return err
+	}
+	*b = append(*b, binding)
+	return nil
+}
+
+// regexpsFlag is a repeatable flag of regular expressions
+type regexpsFlag []*regexp.Regexp
+
+func (r *regexpsFlag) String() string {
+	exprs := make([]string, len(*r))
+	for i, expr := range *r {
+		exprs[i] = expr.String()
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (r *regexpsFlag) Set(value string) error {
+	expr, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, expr)
+	return nil
+}
+
+// serveHTTP serves the node's event stream (/events and, over WebSocket, /ws) and its metrics in the Prometheus format (/metrics)
+func serveHTTP(addr string, node *networking.Node) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/events", events.StreamHandler(node.Events()))
+	mux.Handle("/ws", events.WebSocketHandler(node.Events()))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		err := node.P2PMetrics().WritePrometheus(w)
+		if err != nil {
+			log.Printf("⚠️ Could not write metrics due to error: %s", err)
+		}
+	})
+	mux.HandleFunc("/debug/handshakes", func(w http.ResponseWriter, r *http.Request) {
+		redact, _ := strconv.ParseBool(r.URL.Query().Get("redact"))
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(node.FailedHandshakes(redact))
+		if err != nil {
+			log.Printf("⚠️ Could not write handshake traces due to error: %s", err)
+		}
+	})
+	mux.HandleFunc("/debug/peers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(node.Peers())
+		if err != nil {
+			log.Printf("⚠️ Could not write peers due to error: %s", err)
+		}
+	})
+	mux.HandleFunc("/debug/churn", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(node.PeerChurn())
+		if err != nil {
+			log.Printf("⚠️ Could not write peer churn due to error: %s", err)
+		}
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Printf("📡 Serving event stream on http://%s/events and ws://%s/ws, metrics on http://%s/metrics and failed handshakes on http://%s/debug/handshakes", addr, addr, addr, addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("⚠️ HTTP server failed with error: %s", err)
+		}
+	}()
+
+	return server
+}
+
+// serveRPC serves the JSON-RPC requests of the clients authenticating with user and password, and the REST requests of any client if rest is
+// set, within limits
+func serveRPC(addr string, node *networking.Node, user string, password string, rest bool, limits rpc.Limits) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/", rpc.NewServer(node, user, password))
+	if rest {
+		mux.Handle("/rest/", rpc.NewREST(node))
+	}
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           rpc.Limit(mux, limits),
+		ReadHeaderTimeout: constants.RPCReadHeaderTimeout,
+		MaxHeaderBytes:    constants.RPCMaxHeaderBytes,
+	}
+
+	go func() {
+		log.Printf("📡 Serving JSON-RPC requests on http://%s", addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("⚠️ RPC server failed with error: %s", err)
+		}
+	}()
+
+	return server
+}
+
+// chainParams returns the parameters of the network named chain (the value of the -chain flag), exiting if there is no such network
+func chainParams(chain string) constants.NetworkParams {
+	params, ok := constants.NetworkParamsByName(chain)
+	if !ok {
+		log.Fatalf("Unknown chain %s: expected mainnet, testnet or regtest", chain)
+	}
+	return params
+}
+
+// networkDataDir returns the subdirectory of dataDir the data of the network with params is kept in, creating it if it does not exist yet
+func networkDataDir(fs storage.FS, dataDir string, params constants.NetworkParams) string {
+	dir := filepath.Join(dataDir, params.DataDir)
+	err := fs.MkdirAll(dir, 0o755)
+	if err != nil {
+		log.Fatalf("Could not create the data directory %s: %s", dir, err)
+	}
+	return dir
+}
+
+// setBlockStore makes node keep the blocks of the network with params in dir, in the block store named kind (one of the values of the
+// -blockstore flag)
+func setBlockStore(node *networking.Node, fs storage.FS, dir string, kind string, params constants.NetworkParams) {
+	switch kind {
+	case "file":
+	case "kv":
+		store, err := networking.OpenKVBlockStore(fs, filepath.Join(dir, constants.BlockStoreDirectory))
+		if err != nil {
+			log.Fatalf("Could not open the block store: %s", err)
+		}
+		node.SetBlockStore(store)
+	case "blk":
+		store, err := networking.OpenBlockFileStore(fs, filepath.Join(dir, constants.BlockFilesPrefix), params.Magic, constants.MaxBlockFileSize)
+		if err != nil {
+			log.Fatalf("Could not open the block files: %s", err)
+		}
+		node.SetBlockStore(store)
+	default:
+		log.Fatalf("Unknown block store %s", kind)
+	}
+}
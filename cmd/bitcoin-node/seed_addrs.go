@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/storage"
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// runSeedAddrs implements the "seed-addrs" subcommand, which connects to a few peers, saves the addresses they know of to the node's address
+// database and exits, so that address databases can be populated before a node is started
+func runSeedAddrs(args []string) {
+	fs := flag.NewFlagSet("seed-addrs", flag.ExitOnError)
+	var seeds addrsFlag
+	fs.Var(&seeds, "peer", "Peer to ask for addresses first (can be repeated; defaults to "+defaultMainnetPeer+" on mainnet)")
+	maxPeers := fs.Int("peers", 8, "Number of peers to ask for addresses")
+	wait := fs.Duration("wait", 10*time.Second, "How long to wait for each peer's addresses")
+	dataDir := fs.String("datadir", constants.DefaultDataDir, "Directory the data of each network is kept in (see the node's -datadir flag)")
+	chain := fs.String("chain", constants.MainnetParams.Name, "Network whose peers are asked for addresses: mainnet, testnet or regtest")
+	_ = fs.Parse(args)
+
+	params := chainParams(*chain)
+	if len(seeds) == 0 {
+		if params.Name != constants.MainnetParams.Name {
+			log.Fatalf("No peer to ask for addresses on %s: pass -peer", params.Name)
+		}
+		_ = seeds.Set(defaultMainnetPeer)
+	}
+
+	config := networking.DefaultConfig()
+	config.MinimumPeers = 0
+	config.BlocksFile = filepath.Join(networkDataDir(storage.OSFS{}, *dataDir, params), constants.BlocksFileName)
+	config.GetAddrWait = *wait
+	node := networking.NewNode(config)
+
+	err := node.SetNetworkParams(params)
+	if err != nil {
+		log.Fatalf("Could not join %s: %s", params.Name, err)
+	}
+	newAddrs, err := node.HarvestAddrs([]*net.TCPAddr(seeds), *maxPeers)
+	if err != nil {
+		log.Fatalf("Seeding addresses failed with error: %s", err)
+	}
+	log.Printf("🌱 Added %d new addresses to the address database", newAddrs)
+}
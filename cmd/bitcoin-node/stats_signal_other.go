@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "github.com/aang114/bitcoin-node/networking"
+
+// logRuntimeStatsOnSignal does nothing, as there is no SIGUSR1 on this platform: the runtime stats are only logged by the dumpstats RPC
+func logRuntimeStatsOnSignal(node *networking.Node) {}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"github.com/aang114/bitcoin-node/networking"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// logRuntimeStatsOnSignal logs the runtime stats of node whenever the process receives SIGUSR1 (e.g. kill -USR1 <pid>)
+func logRuntimeStatsOnSignal(node *networking.Node) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			_, _ = node.LogRuntimeStats()
+		}
+	}()
+}
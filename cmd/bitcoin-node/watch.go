@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aang114/bitcoin-node/events"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runWatch implements the "watch" subcommand, which prints a live feed of the blocks accepted by a running node (like `tail -f`)
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	eventsAddr := fs.String("eventsaddr", defaultEventsAddr, "Address of the running node's event stream")
+	retryInterval := fs.Duration("retry", 5*time.Second, "How long to wait before reconnecting to the node")
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	url := fmt.Sprintf("http://%s/events?topics=%s", *eventsAddr, events.TopicNewBlock)
+	for {
+		err := watchBlocks(ctx, url)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Lost connection to the node's event stream (%s). Reconnecting in %s...", err, *retryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*retryInterval):
+		}
+	}
+}
+
+func watchBlocks(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	log.Printf("👀 Watching for new blocks at %s", url)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event struct {
+			Topic events.Topic    `json:"topic"`
+			Data  events.NewBlock `json:"data"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Printf("⚠️ Skipping malformed event: %s", err)
+			continue
+		}
+		if event.Topic != events.TopicNewBlock {
+			continue
+		}
+		fmt.Println(formatNewBlock(&event.Data))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream closed")
+}
+
+func formatNewBlock(b *events.NewBlock) string {
+	height := "?"
+	if b.Height >= 0 {
+		height = fmt.Sprintf("%d", b.Height)
+	}
+	fees := "?"
+	if b.Fees != nil {
+		fees = fmt.Sprintf("%d.%08d BTC", *b.Fees/1e8, *b.Fees%1e8)
+	}
+	delay := b.ReceivedAt.Sub(b.Timestamp).Round(100 * time.Millisecond)
+
+	return fmt.Sprintf("%s  height %-7s  %s  txs %-5d  fees %-16s  delay %s  (from %s)",
+		b.ReceivedAt.Local().Format(time.DateTime), height, b.Hash, b.TxCount, fees, delay, b.Peer)
+}
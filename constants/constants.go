@@ -1,15 +1,265 @@
+// Package constants holds the defaults of the node and the parameters of the networks it can join (NetworkParams)
 package constants
 
 import (
 	"encoding/hex"
+	"time"
 )
 
 const (
-	ProtocolVersion     int32  = 70016
-	MainnetMagicValue          = uint32(0xD9B4BEF9)
-	UserAgent           string = "/bitcoin-node-go:0.0.1/"
-	BlocksFileDirectory string = "./blocks.dat"
+	ProtocolVersion   int32 = 70016
+	MainnetMagicValue       = uint32(0xD9B4BEF9)
+	// Magic value of regtest messages (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L506-L509)
+	RegtestMagicValue = uint32(0xDAB5BFFA)
+	// Magic value of testnet3 messages (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L221-L224)
+	TestnetMagicValue = uint32(0x0709110B)
+	// Port mainnet nodes listen on
+	DefaultPort uint16 = 8333
+	UserAgent   string = "/bitcoin-node-go:0.0.1/"
+	// Version of UserAgent, encoded like bitcoind's CLIENT_VERSION (10000 * major + 100 * minor + patch)
+	ClientVersion int32 = 1
+	// Address the node serves JSON-RPC requests on by default, which is bitcoind's for mainnet
+	// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/chainparamsbase.cpp)
+	DefaultRPCAddr string = "127.0.0.1:8332"
+	// Directory the subdirectories of the networks are created in by default
+	DefaultDataDir string = "."
+	// Configuration file read from the data directory when the node runs without -conf
+	ConfigFileName string = "bitcoin-node.conf"
+	// The files below are kept in the subdirectory of the data directory of the network the node joins (see NetworkParams.DataDir)
+	BlocksFileName string = "blocks.dat"
+	// Key-value store the blocks are kept in when the node runs with -blockstore kv
+	BlockStoreDirectory string = "blocks.kv"
+	// Key-value store the unspent outputs of the active chain are kept in
+	ChainstateDirectory string = "chainstate.kv"
+	// Key-value store the transactions of the active chain are indexed in when the node runs with -txindex
+	TxIndexDirectory string = "txindex.kv"
+	// Key-value store the basic filters of the blocks of the active chain are kept in when the node runs with -blockfilterindex
+	BlockFilterIndexDirectory string = "blockfilter.kv"
+	// Prefix of the block files the blocks are appended to when the node runs with -blockstore blk
+	BlockFilesPrefix string = "blk"
+	// File the credentials of RPC cookie authentication are written to while the RPC server runs without -rpcpassword
+	RPCCookieFileName string = ".cookie"
+	// Compact representation of the easiest target a mainnet block may have (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L101)
+	PowLimitBits uint32 = 0x1d00ffff
 )
 
-// https://bitcoinexplorer.org/block/000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f
+const (
+	// How often a ping is sent to each peer (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L56)
+	PingInterval = 2 * time.Minute
+	// How long a peer may take to answer a ping before it is disconnected (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net.h#L58)
+	PingTimeout = 20 * time.Minute
+	// How long a misbehaving peer is banned for (https://github.com/bitcoin/bitcoin/blob/v0.20.0/src/banman.h#L20)
+	BanDuration = 24 * time.Hour
+	// How long sending a message may wait for room in a peer's full write queue
+	WriteQueueTimeout = 10 * time.Second
+	// How long to wait before reconnecting to a manual peer whose connection failed or dropped
+	ManualPeerRetryInterval = 30 * time.Second
+	// How often our external address is advertised to the connected peers (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L152)
+	AddrAdvertiseInterval = 24 * time.Hour
+	// How often the history of connections is saved (it is also saved when the node quits)
+	PeerChurnSaveInterval = time.Hour
+	// How often the blocks file is saved and the write-ahead log of the blocks accepted since then emptied (it is also saved when the node quits)
+	BlocksCheckpointInterval = 10 * time.Minute
+	// How often the cached unspent outputs are written to the chainstate database (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L89)
+	ChainstateFlushInterval = time.Hour
+	// How often newly learnt addresses are relayed to peers (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L162)
+	AddrRelayInterval = 30 * time.Second
+	// How long the chain tip may go without advancing before an extra peer is synced from (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L1834)
+	StaleTipTimeout = 30 * time.Minute
+	// Time waited by default between starting two dials to new peers, so that a large backlog of addresses does not cause a burst of connections
+	DefaultDialInterval = 100 * time.Millisecond
+	// How long a requested block may take to arrive before it is requested from another peer
+	BlockDownloadTimeout = time.Minute
+	// How often blocks in flight are checked for timeouts, and the sync peer for a stalled download
+	BlockDownloadCheckInterval = 10 * time.Second
+	// How often the fee filters of the peers are updated, e.g. once the initial block download is over
+	FeeFilterUpdateInterval = 10 * time.Second
+	// A tip older than this means the node is still catching up with the chain (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+	MaxTipAge = 24 * time.Hour
+	// How long a block may be in flight from a peer before a peer without blocks in flight takes it over
+	SlowBlockDelay = 5 * time.Second
+	// How often the chain tip is checked for staleness (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L103)
+	StaleTipCheckInterval = 10 * time.Minute
+	// Addresses not seen for longer are not handed out to peers asking for addresses (https://github.com/bitcoin/bitcoin/blob/v27.0/src/addrman_impl.h#L30)
+	AddrHorizon = 30 * 24 * time.Hour
+	// Average time between two announcements of transactions to an inbound peer (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+	InboundTxAnnounceInterval = 5 * time.Second
+	// Average time between two announcements of transactions to an outbound peer, which is shorter as outbound peers are less likely to be
+	// spies (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+	OutboundTxAnnounceInterval = 2 * time.Second
+	// Transactions that entered the mempool longer ago are sent to peers asking for them even if they were never announced to them
+	// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+	UnconditionalRelayDelay = 2 * time.Minute
+	// Time between two reconciliation rounds started with a peer relaying transactions by set reconciliation
+	// (https://github.com/bitcoin/bips/blob/master/bip-0330.mediawiki)
+	TxReconciliationInterval = 8 * time.Second
+	// Time in which the minimum fee rate of the mempool halves once a block was connected since it was raised
+	// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/txmempool.h)
+	MempoolMinFeeHalfLife = 12 * time.Hour
+	// Transactions expire from the mempool by default once they have been in it for longer
+	// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/mempool_options.h)
+	DefaultMempoolExpiry = 336 * time.Hour
+	// Time between two checks for expired transactions of the mempool
+	MempoolExpiryCheckInterval = 10 * time.Minute
+)
+
+// Number of encoded messages that can be queued for sending to a peer
+const WriteQueueSize = 256
+
+// Misbehavior score at which a peer is disconnected and banned (https://github.com/bitcoin/bitcoin/blob/v0.20.0/src/net_processing.h#L37)
+const BanScoreThreshold = 100
+
+// Maximum number of inbound connections (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net.h#L79 minus the outbound connections)
+const MaxInboundPeers = 114
+
+// Number of unsolicited addresses processed per second from each peer, beyond a burst of MaxAddrRelayQueue (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L169)
+const AddrRatePerSecond = 0.1
+
+// Number of addresses tried when connecting to an extra peer to sync from
+const MaxExtraSyncPeerAttempts = 10
+
+// Number of outbound connections dialed at once by default
+const DefaultDialWorkers = 8
+
+// Number of peers asked for addresses at once when the node is below its minimum peers
+const GetAddrPeers = 3
+
+// Number of peers each newly learnt address is relayed to (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L2232)
+const AddrRelayPeers = 2
+
+// Maximum number of addresses waiting to be relayed, which is also the most an addr message may hold
+const MaxAddrRelayQueue = 1000
+
+// Maximum number of addresses sent in answer to a getaddr message (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L177)
+const MaxGetAddrResponse = 1000
+
+// Maximum number of blocks announced in answer to a getblocks message (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L3602)
+const MaxGetBlocksInv = 500
+
+// Maximum number of blocks in flight from a single peer (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+const MaxBlocksInFlightPerPeer = 16
+
+// Number of blocks requested from a peer in a single getdata message
+const BlockDownloadBatchSize = 16
+
+// Share of its best block throughput under which the sync peer's download is considered stalled
+const StalledThroughputFraction = 0.1
+
+// Maximum number of blocks whose parent is not known kept at once (https://github.com/bitcoin/bitcoin/blob/v0.9.5/src/main.h)
+const MaxOrphanBlocks = 750
+
+// Maximum number of inventories in an inv message (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.h#L42)
+const MaxInvPerMessage = 50000
+
+// Maximum number of inventories the node puts in a getdata message, far fewer than peers accept, as they serve the blocks of a getdata
+// message one after the other before reading the next message
+const MaxGetDataInventories = 128
+
+// Number of satoshis in all the bitcoins there will ever be (https://github.com/bitcoin/bitcoin/blob/v27.0/src/consensus/amount.h#L26)
+const MaxMoney int64 = 21_000_000 * 100_000_000
+
+// Number of addresses remembered as known to each peer (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L5311)
+const MaxKnownAddrsPerPeer = 5000
+
+// Maximum number of transactions announced to a peer at once, the rest waiting for the next announcement
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+const MaxTxAnnouncements = 1000
+
+// Number of transactions remembered as known to each peer (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+const MaxKnownTxsPerPeer = 50000
+
+// Maximum number of transactions a transaction may replace in the mempool, counting the descendants of the transactions it conflicts with
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/rbf.h)
+const MaxReplacedTxs = 100
+
+// Fee rate, in satoshis per 1000 virtual bytes, a replacement must pay on top of the fees of the transactions it replaces
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h)
+const IncrementalRelayFeeRate int64 = 1000
+
+// Fee rate, in satoshis per 1000 virtual bytes, transactions must pay by default to be accepted into the mempool, and the fee rate outputs
+// must be worth spending at by default not to be dust (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h)
+const (
+	DefaultMinRelayFeeRate  int64 = 1000
+	DefaultDustRelayFeeRate int64 = 3000
+)
+
+// Maximum number of transactions of the mempool a transaction and its ancestors in the mempool may add up to, which is also the most a
+// transaction and its descendants may add up to (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h)
+const (
+	MaxAncestorCount   = 25
+	MaxDescendantCount = 25
+)
+
+// Maximum virtual size of a transaction of the mempool with its ancestors, or with its descendants, in the mempool
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h)
+const (
+	MaxAncestorVSize   = 101_000
+	MaxDescendantVSize = 101_000
+)
+
+// Number of failed handshakes whose traces are kept for debugging
+const HandshakeTraceBufferSize = 32
+
+// Size up to which blocks are appended to a block file before the next one is started
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/node/blockstorage.h#L68)
+const MaxBlockFileSize = 128 * 1024 * 1024
+
+// Number of blocks the tip may be below the best height the peers advertised before the node is still catching up with the chain, which is
+// about as many blocks as are mined in constants.MaxTipAge
+const MaxTipHeightLag = 144
+
+// Number of blocks whose median timestamp is the median time past of the last one (https://github.com/bitcoin/bitcoin/blob/v27.0/src/chain.h#L276)
+const MedianTimeSpan = 11
+
+// Reward of the coinbase of the first blocks, in satoshis, which is halved every SubsidyHalvingInterval blocks
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L1789)
+const InitialBlockSubsidy int64 = 50 * 100_000_000
+
+// Number of confirmations the outputs of a coinbase need before they can be spent
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/consensus/consensus.h#L19)
+const CoinbaseMaturity = 100
+
+// Number of blocks after which the block subsidy is halved (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L81)
+const SubsidyHalvingInterval = 210_000
+
+// Time the node may take by default to finish handling its current message when it is stopped, after which it quits without saving its state
+const DefaultStopTimeout = time.Minute
+
+// Number of the last blocks of the active chain verified at startup by default (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.h)
+const DefaultCheckBlocks = 6
+
+// Memory the transactions of the mempool may take by default, in MiB (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/mempool_options.h)
+const DefaultMaxMempoolMiB = 300
+
+// Memory the unspent outputs cached in memory may take by default, in MiB, if the available memory cannot be detected
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/txdb.h#L32)
+const DefaultDBCacheMiB = 450
+
+// Size and age the log file is rotated at by default, and number of rotated log files kept
+const (
+	DefaultLogMaxSizeMiB = 100
+	DefaultLogMaxAge     = 24 * time.Hour
+	DefaultLogBackups    = 7
+)
+
+// Limits of the JSON-RPC and REST servers
+const (
+	// Requests handled at once by default, and waiting to be (https://github.com/bitcoin/bitcoin/blob/v27.0/src/httpserver.h#L13-L14)
+	DefaultRPCThreads   = 4
+	DefaultRPCWorkQueue = 16
+	// Requests per second each client may make by default, and at once
+	DefaultRPCRequestRate  = 50.0
+	DefaultRPCRequestBurst = 100
+	// Size in MiB of the largest request body accepted by default (https://github.com/bitcoin/bitcoin/blob/v27.0/src/serialize.h#L32)
+	DefaultRPCMaxRequestSizeMiB = 32
+	// Time clients may take to send the headers of a request, and the largest size of those headers
+	RPCReadHeaderTimeout = 30 * time.Second
+	RPCMaxHeaderBytes    = 8192
+)
+
+// Hash of the mainnet genesis block (https://bitcoinexplorer.org/block/000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f)
 var GenesisBlockHash, _ = hex.DecodeString("6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000")
+
+// Script of the only output of the coinbase of the mainnet and regtest genesis blocks, the only element of its basic block filter
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L58)
+var GenesisOutputScript, _ = hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
@@ -0,0 +1,160 @@
+package constants
+
+// NetworkParams holds the parameters that differ between the networks a node can join
+type NetworkParams struct {
+	Name string
+	// Subdirectory of the data directory the network's blocks, chainstate, addresses and bans are kept in
+	// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/chainparamsbase.cpp#L44)
+	DataDir string
+	// Magic value starting the messages of the network and the records of its block files
+	Magic uint32
+	// Port the nodes of the network listen on by default
+	DefaultPort uint16
+	// Compact representation of the easiest target a block of the network may have
+	PowLimitBits uint32
+	// Serialized genesis block (in hexadecimal), which the chain starts from
+	GenesisBlock string
+	// Known block hashes (in big-endian hexadecimal) indexed by height. Header chains forking below the highest known one are rejected.
+	Checkpoints map[int32]string
+	// UTXO snapshots the node trusts, so that it can start from them while it validates the blocks below them
+	AssumeUTXO []AssumeUTXOParams
+	// Work (in big-endian hexadecimal) a peer's header chain must have before its headers are added to the block index (empty for none)
+	MinimumChainWork string
+	// Version bytes of the base58 addresses of pay-to-pubkey-hash and pay-to-script-hash outputs, and human-readable part of the bech32
+	// addresses of witness outputs (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	Bech32HRP        string
+	// Heights from which the network enforces the soft forks that are buried in its chain
+	Deployments Deployments
+}
+
+// Deployments holds the heights from which a network enforces the soft forks changing the rules of blocks and scripts: P2SH (BIP16), block
+// height in coinbase (BIP34), strict DER signatures (BIP66), OP_CHECKLOCKTIMEVERIFY (BIP65), OP_CHECKSEQUENCEVERIFY (BIP112), segwit
+// (BIP141, BIP143 and BIP147) and taproot (BIP341 and BIP342) (https://github.com/bitcoin/bitcoin/blob/v27.0/src/consensus/params.h#L74-L102).
+// bitcoind instead enforces P2SH and taproot on every block but the ones breaking them, which comes to the same as the other blocks before
+// their activation follow their rules (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L2216-L2232).
+type Deployments struct {
+	BIP16Height   int32
+	BIP34Height   int32
+	BIP66Height   int32
+	BIP65Height   int32
+	CSVHeight     int32
+	SegwitHeight  int32
+	TaprootHeight int32
+}
+
+// AssumeUTXOParams identifies a trusted UTXO snapshot (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.h#L44)
+type AssumeUTXOParams struct {
+	Height int32
+	// Hash of the block the snapshot was taken at (in big-endian hexadecimal)
+	BlockHash string
+	// Hash of the unspent outputs, as computed by utxo.Set.Hash (in big-endian hexadecimal)
+	UTXOSetHash string
+}
+
+var MainnetParams = NetworkParams{
+	Name:         "mainnet",
+	DataDir:      "mainnet",
+	Magic:        MainnetMagicValue,
+	DefaultPort:  DefaultPort,
+	PowLimitBits: PowLimitBits,
+	GenesisBlock: mainnetGenesisBlock,
+	Checkpoints:  Checkpoints,
+	// the hashes Bitcoin Core publishes are of its own snapshot format, so none of its snapshots can be loaded
+	AssumeUTXO: []AssumeUTXOParams{},
+	// https://github.com/bitcoin/bitcoin/blob/v26.0/src/kernel/chainparams.cpp
+	MinimumChainWork: "000000000000000000000000000000000000000052b2559353df4117b7348b64",
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	Bech32HRP:        "bc",
+	// https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L85-L96
+	Deployments: Deployments{
+		BIP16Height:   173805,
+		BIP34Height:   227931,
+		BIP66Height:   363725,
+		BIP65Height:   388381,
+		CSVHeight:     419328,
+		SegwitHeight:  481824,
+		TaprootHeight: 709632,
+	},
+}
+
+// Testnet3 shares mainnet's proof of work limit, but its blocks may have the easiest target when they come more than 20 minutes after their
+// parent, which the node does not tell apart as it does not check targets against the difficulty adjustment
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp#L184-L290). No minimum chain work is enforced on it.
+var TestnetParams = NetworkParams{
+	Name:         "testnet",
+	DataDir:      "testnet3",
+	Magic:        TestnetMagicValue,
+	DefaultPort:  18333,
+	PowLimitBits: PowLimitBits,
+	GenesisBlock: testnetGenesisBlock,
+	Checkpoints: map[int32]string{
+		546: "000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70",
+	},
+	AssumeUTXO:       []AssumeUTXOParams{},
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	Bech32HRP:        "tb",
+	// P2SH is enforced after block 514, the one breaking it, and taproot from the start, as bitcoind does: no testnet block breaks it
+	Deployments: Deployments{
+		BIP16Height:   515,
+		BIP34Height:   21111,
+		BIP66Height:   330776,
+		BIP65Height:   581885,
+		CSVHeight:     770112,
+		SegwitHeight:  834624,
+		TaprootHeight: 0,
+	},
+}
+
+// Regtest has no checkpoints and no minimum chain work, and its blocks are mined at once, as about every other hash meets its easiest target
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+var RegtestParams = NetworkParams{
+	Name:         "regtest",
+	DataDir:      "regtest",
+	Magic:        RegtestMagicValue,
+	DefaultPort:  18444,
+	PowLimitBits: 0x207fffff,
+	GenesisBlock: regtestGenesisBlock,
+	Checkpoints:  map[int32]string{},
+	AssumeUTXO:   []AssumeUTXOParams{},
+	// testnet's address prefixes, with a human-readable part of its own for bech32 addresses
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	Bech32HRP:        "bcrt",
+	// the soft forks are enforced from the first blocks, so that regtest chains follow the rules of today's blocks
+	Deployments: Deployments{
+		BIP34Height:   1,
+		BIP66Height:   1,
+		BIP65Height:   1,
+		CSVHeight:     1,
+		SegwitHeight:  0,
+		TaprootHeight: 0,
+	},
+}
+
+// Networks are the networks a node can join
+var Networks = []NetworkParams{MainnetParams, TestnetParams, RegtestParams}
+
+// NetworkParamsByName returns the parameters of the network named name (see NetworkParams.Name)
+func NetworkParamsByName(name string) (NetworkParams, bool) {
+	for _, params := range Networks {
+		if params.Name == name {
+			return params, true
+		}
+	}
+	return NetworkParams{}, false
+}
+
+// Block 000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+const mainnetGenesisBlock = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c0101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+// Block 000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943, which differs from the mainnet genesis block only by its timestamp and
+// nonce (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+const testnetGenesisBlock = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae180101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+// Block 0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206, which differs from the mainnet genesis block only by its timestamp,
+// target and nonce (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/chainparams.cpp)
+const regtestGenesisBlock = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff7f20020000000101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
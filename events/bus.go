@@ -0,0 +1,233 @@
+// Package events publishes what happens in the node (new blocks, reorganizations, transactions, peers) on a Bus, which programs embedding
+// the node subscribe to, and streams them to clients over WebSocket.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Topic identifies the kind of event published on a Bus
+type Topic string
+
+const (
+	// A new block was accepted by the node (data: NewBlock)
+	TopicNewBlock Topic = "newblock"
+	// The active chain switched to a branch with more work (data: Reorg)
+	TopicReorg Topic = "reorg"
+	// A transaction expired from the mempool after staying unconfirmed for too long (data: MempoolExpiry)
+	TopicMempoolExpiry Topic = "mempoolexpiry"
+	// A transaction sent by a peer, submitted to the node or confirmed by a block spends outputs that transactions of the mempool already spend
+	// (data: DoubleSpend)
+	TopicDoubleSpend Topic = "doublespend"
+	// A transaction was added to the mempool, sent by a peer or submitted to the node (data: NewTx)
+	TopicNewTx Topic = "newtx"
+	// A peer connected or disconnected (data: PeerEvent)
+	TopicPeerEvents Topic = "peerevents"
+)
+
+// Topics are the topics events are published on
+var Topics = []Topic{TopicNewBlock, TopicReorg, TopicMempoolExpiry, TopicDoubleSpend, TopicNewTx, TopicPeerEvents}
+
+// Actions of PeerEvent
+const (
+	PeerConnected    = "connected"
+	PeerDisconnected = "disconnected"
+)
+
+// Event is a notification published by the node
+type Event struct {
+	Topic Topic     `json:"topic"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// NewBlock is the data of a TopicNewBlock event
+type NewBlock struct {
+	// Big-endian hexadecimal hash of the block
+	Hash string `json:"hash"`
+	// Height of the block, or -1 if it is not known
+	Height int32 `json:"height"`
+	// Number of transactions in the block
+	TxCount int `json:"txCount"`
+	// Total fees of the block in satoshis, or nil if they are not known
+	Fees *int64 `json:"fees,omitempty"`
+	// Timestamp from the block header
+	Timestamp time.Time `json:"timestamp"`
+	// When the block was received by the node
+	ReceivedAt time.Time `json:"receivedAt"`
+	// Address of the peer that sent the block
+	Peer string `json:"peer"`
+}
+
+// Reorg is the data of a TopicReorg event
+type Reorg struct {
+	// Big-endian hexadecimal hashes of the tips of the active chain before and after the reorganization
+	OldTip string `json:"oldTip"`
+	NewTip string `json:"newTip"`
+	// Height of the last block both branches have in common
+	ForkHeight int32 `json:"forkHeight"`
+	// Hashes of the blocks that left the active chain, the old tip first
+	Disconnected []string `json:"disconnected"`
+	// Hashes of the blocks that joined the active chain, lowest first
+	Connected []string `json:"connected"`
+}
+
+// MempoolExpiry is the data of a TopicMempoolExpiry event
+type MempoolExpiry struct {
+	// Big-endian hexadecimal txid of the transaction
+	TxId string `json:"txid"`
+	// When the transaction entered the mempool
+	AddedAt time.Time `json:"addedAt"`
+	// Txids of the descendants of the transaction, which were evicted with it
+	Descendants []string `json:"descendants"`
+}
+
+// DoubleSpend is the data of a TopicDoubleSpend event
+type DoubleSpend struct {
+	// Big-endian hexadecimal txid of the transaction spending the same outputs as transactions of the mempool
+	TxId string `json:"txid"`
+	// Hash of the block confirming the transaction, or empty if it was not confirmed
+	BlockHash string `json:"blockHash,omitempty"`
+	// Address of the peer that sent the transaction, or empty if a block confirmed it or it was submitted to the node
+	Peer string `json:"peer,omitempty"`
+	// Txids of the transactions of the mempool spending the same outputs
+	Conflicts []string `json:"conflicts"`
+	// Outputs spent by both, as txid:index
+	Outpoints []string `json:"outpoints"`
+	// Txids of the transactions that left the mempool, replaced by the transaction or invalidated by the block: the conflicting transactions
+	// and their descendants. It is empty if the transaction was rejected instead.
+	Evicted []string `json:"evicted"`
+}
+
+// NewTx is the data of a TopicNewTx event
+type NewTx struct {
+	// Big-endian hexadecimal txid and wtxid of the transaction
+	TxId  string `json:"txid"`
+	WtxId string `json:"wtxid"`
+	VSize int    `json:"vsize"`
+	// Fee of the transaction in satoshis, or nil if it is not known
+	Fee *int64 `json:"fee,omitempty"`
+	// Address of the peer that sent the transaction, or empty if it was submitted to the node
+	Peer string `json:"peer,omitempty"`
+	// Txids of the transactions of the mempool the transaction replaced
+	Replaced []string `json:"replaced"`
+}
+
+// PeerEvent is the data of a TopicPeerEvents event
+type PeerEvent struct {
+	// PeerConnected or PeerDisconnected
+	Action string `json:"action"`
+	// Id and address of the peer, as the node's peer info reports them
+	Id             int64  `json:"id"`
+	Addr           string `json:"addr"`
+	Direction      string `json:"direction"`
+	ConnectionType string `json:"connectionType"`
+	UserAgent      string `json:"userAgent"`
+	// How long the peer was connected, only set when it disconnected
+	ConnectedFor time.Duration `json:"connectedFor,omitempty"`
+}
+
+// Subscription receives the events published on a Bus for the topics it subscribed to
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	topics map[Topic]struct{}
+	all    bool
+	bus    *Bus
+}
+
+// Unsubscribe stops the subscription and closes C
+func (s *Subscription) Unsubscribe() {
+	s.bus.unsubscribe(s)
+}
+
+// SetTopics replaces the topics of the subscription: it only receives the events of the given topics from then on, and none if none are given
+func (s *Subscription) SetTopics(topics ...Topic) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.topics = make(map[Topic]struct{}, len(topics))
+	for _, topic := range topics {
+		s.topics[topic] = struct{}{}
+	}
+	s.all = false
+}
+
+func (s *Subscription) wants(topic Topic) bool {
+	if s.all {
+		return true
+	}
+	_, ok := s.topics[topic]
+	return ok
+}
+
+// Bus fans out published events to its subscribers.
+//
+// Publishing never blocks: a subscriber whose buffer is full misses the event instead of stalling the node.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription with a buffer of bufferSize events for the given topics (or all topics if none are given)
+func (b *Bus) Subscribe(bufferSize int, topics ...Topic) *Subscription {
+	return b.subscribe(bufferSize, len(topics) == 0, topics...)
+}
+
+// subscribe returns a subscription with a buffer of bufferSize events for all topics or for the given topics only
+func (b *Bus) subscribe(bufferSize int, all bool, topics ...Topic) *Subscription {
+	ch := make(chan Event, bufferSize)
+	s := &Subscription{
+		C:      ch,
+		ch:     ch,
+		topics: make(map[Topic]struct{}, len(topics)),
+		all:    all,
+		bus:    b,
+	}
+	for _, topic := range topics {
+		s.topics[topic] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions[s] = struct{}{}
+
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscriptions[s]; !ok {
+		return
+	}
+	delete(b.subscriptions, s)
+	close(s.ch)
+}
+
+// Publish sends an event with the given topic and data to all interested subscribers
+func (b *Bus) Publish(topic Topic, data any) {
+	event := Event{Topic: topic, Time: time.Now(), Data: data}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscriptions {
+		if !s.wants(topic) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+			log.Printf("⚠️ Dropping %s event for a slow subscriber", topic)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package events_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	t.Run("subscribers should only receive their topics", func(t *testing.T) {
+		bus := events.NewBus()
+		all := bus.Subscribe(10)
+		defer all.Unsubscribe()
+		other := bus.Subscribe(10, events.Topic("other"))
+		defer other.Unsubscribe()
+
+		bus.Publish(events.TopicNewBlock, events.NewBlock{Hash: "00"})
+
+		event := <-all.C
+		require.Equal(t, events.TopicNewBlock, event.Topic)
+		require.Equal(t, events.NewBlock{Hash: "00"}, event.Data)
+		require.Empty(t, other.C)
+	})
+
+	t.Run("publishing should not block on slow subscribers", func(t *testing.T) {
+		bus := events.NewBus()
+		subscription := bus.Subscribe(1)
+		defer subscription.Unsubscribe()
+
+		bus.Publish(events.TopicNewBlock, nil)
+		bus.Publish(events.TopicNewBlock, nil)
+
+		require.Len(t, subscription.C, 1)
+	})
+
+	t.Run("unsubscribing should close the channel", func(t *testing.T) {
+		bus := events.NewBus()
+		subscription := bus.Subscribe(1)
+		subscription.Unsubscribe()
+		subscription.Unsubscribe()
+
+		_, ok := <-subscription.C
+		require.False(t, ok)
+	})
+}
+
+func TestStreamHandler(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(events.StreamHandler(bus))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?topics=newblock")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the handler subscribes before writing the response header, so this event is not missed
+	bus.Publish(events.Topic("other"), nil)
+	bus.Publish(events.TopicNewBlock, events.NewBlock{Hash: "ab", Height: 840000, TxCount: 2, Timestamp: time.Unix(100, 0).UTC()})
+
+	scanner := bufio.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	var event struct {
+		Topic events.Topic    `json:"topic"`
+		Data  events.NewBlock `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+	require.Equal(t, events.TopicNewBlock, event.Topic)
+	require.Equal(t, "ab", event.Data.Hash)
+	require.Equal(t, int32(840000), event.Data.Height)
+	require.Nil(t, event.Data.Fees)
+}
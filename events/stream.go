@@ -0,0 +1,50 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Number of events buffered per stream client before events start being dropped
+const streamBufferSize = 256
+
+// StreamHandler serves the events published on bus as a stream of newline-delimited JSON objects.
+//
+// Clients can restrict the stream to some topics with the "topics" query parameter (e.g. /events?topics=newblock).
+func StreamHandler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var topics []Topic
+		if t := r.URL.Query().Get("topics"); t != "" {
+			for _, topic := range strings.Split(t, ",") {
+				topics = append(topics, Topic(topic))
+			}
+		}
+		subscription := bus.Subscribe(streamBufferSize, topics...)
+		defer subscription.Unsubscribe()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		encoder := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-subscription.C:
+				if err := encoder.Encode(event); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,380 @@
+package events
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GUID appended to the Sec-WebSocket-Key of a client to compute the Sec-WebSocket-Accept of the server
+// (https://www.rfc-editor.org/rfc/rfc6455#section-1.3)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of WebSocket frames (https://www.rfc-editor.org/rfc/rfc6455#section-5.2)
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+// Status codes of close frames (https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1)
+const (
+	closeProtocolError   uint16 = 1002
+	closeUnsupportedData uint16 = 1003
+	closeMessageTooBig   uint16 = 1009
+)
+
+const (
+	// Maximum size of the messages WebSocket clients send, which are only subscription commands
+	maxWebSocketMessageSize = 4096
+	// Interval between the pings keeping idle WebSocket connections alive
+	webSocketPingInterval = 30 * time.Second
+	// Time after which a WebSocket client that does not read what is written to it is disconnected
+	webSocketWriteTimeout = 10 * time.Second
+)
+
+// WebSocketCommand is a message WebSocket clients send to change the topics they are subscribed to
+type WebSocketCommand struct {
+	Subscribe   []Topic `json:"subscribe,omitempty"`
+	Unsubscribe []Topic `json:"unsubscribe,omitempty"`
+}
+
+// WebSocketReply answers a WebSocketCommand with the topics the client is subscribed to, and the reason the command was rejected if it was
+type WebSocketReply struct {
+	Subscribed []Topic `json:"subscribed"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// WebSocketHandler serves the events published on bus over WebSocket connections, one JSON text message per event.
+//
+// Clients start subscribed to the topics of the "topics" query parameter (e.g. /ws?topics=newblock,newtx), or to none, and change their
+// topics by sending WebSocketCommand messages (e.g. {"subscribe":["reorg"]}), each answered with a WebSocketReply.
+func WebSocketHandler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := checkWebSocketHandshake(w, r)
+		if !ok {
+			return
+		}
+		var topics []Topic
+		if t := r.URL.Query().Get("topics"); t != "" {
+			for _, topic := range strings.Split(t, ",") {
+				topics = append(topics, Topic(topic))
+			}
+		}
+		if err := checkTopics(topics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+			return
+		}
+		// subscribing before answering the handshake, so that clients receive every event published once they are connected
+		subscription := bus.subscribe(streamBufferSize, false, topics...)
+		defer subscription.Unsubscribe()
+
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			log.Printf("⚠️ Could not take over WebSocket connection from %s due to error: %s", r.RemoteAddr, err)
+			return
+		}
+		defer conn.Close()
+		_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			webSocketAccept(key))
+		if err == nil {
+			err = rw.Flush()
+		}
+		if err != nil {
+			return
+		}
+
+		ws := &webSocketConn{conn: conn, reader: rw.Reader, subscription: subscription, topics: append([]Topic{}, topics...)}
+		ws.serve()
+	})
+}
+
+// checkWebSocketHandshake returns the Sec-WebSocket-Key of r, or answers that r does not open a WebSocket connection
+// (https://www.rfc-editor.org/rfc/rfc6455#section-4.2.1)
+func checkWebSocketHandshake(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return "", false
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return "", false
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
+
+// headerHasToken reports whether one of the comma-separated values of the header called name is token, ignoring case
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// checkTopics returns an error if one of topics is not in Topics
+func checkTopics(topics []Topic) error {
+	for _, topic := range topics {
+		if !slices.Contains(Topics, topic) {
+			return fmt.Errorf("unknown topic %q", topic)
+		}
+	}
+	return nil
+}
+
+// webSocketError closes a WebSocket connection because its client broke the protocol
+type webSocketError struct {
+	code   uint16
+	reason string
+}
+
+func (e *webSocketError) Error() string {
+	return e.reason
+}
+
+// webSocketConn is a WebSocket connection receiving the events of subscription. Only frames sent by the server are written to conn, by any
+// goroutine, while frames sent by the client are read from reader by a single goroutine.
+type webSocketConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeMu      sync.Mutex
+	subscription *Subscription
+	// Topics the client is subscribed to, only accessed by the goroutine reading its messages
+	topics []Topic
+}
+
+// serve writes the events of the subscription and pings to the client while its commands are read, until either side closes the connection
+func (ws *webSocketConn) serve() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readCommands()
+	}()
+
+	ticker := time.NewTicker(webSocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case event := <-ws.subscription.C:
+			if err := ws.writeJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommands answers the commands of the client until it closes the connection or breaks the protocol
+func (ws *webSocketConn) readCommands() {
+	for {
+		opcode, message, err := ws.readMessage()
+		var wsErr *webSocketError
+		switch {
+		case errors.As(err, &wsErr):
+			_ = ws.writeClose(wsErr.code, wsErr.reason)
+			return
+		case err != nil:
+			return
+		case opcode != opText:
+			_ = ws.writeClose(closeUnsupportedData, "expected a text message")
+			return
+		}
+
+		if err := ws.writeJSON(ws.handleCommand(message)); err != nil {
+			return
+		}
+	}
+}
+
+// handleCommand applies the WebSocketCommand encoded in message to the subscription of the client
+func (ws *webSocketConn) handleCommand(message []byte) WebSocketReply {
+	var command WebSocketCommand
+	if err := json.Unmarshal(message, &command); err != nil {
+		return WebSocketReply{Subscribed: ws.topics, Error: fmt.Sprintf("invalid command: %s", err)}
+	}
+	if err := checkTopics(slices.Concat(command.Subscribe, command.Unsubscribe)); err != nil {
+		return WebSocketReply{Subscribed: ws.topics, Error: err.Error()}
+	}
+
+	topics := make([]Topic, 0, len(Topics))
+	for _, topic := range Topics {
+		subscribed := slices.Contains(ws.topics, topic) || slices.Contains(command.Subscribe, topic)
+		if subscribed && !slices.Contains(command.Unsubscribe, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	ws.topics = topics
+	ws.subscription.SetTopics(topics...)
+	return WebSocketReply{Subscribed: topics}
+}
+
+// readMessage reads the next data message of the client, answering the control frames sent before it. It returns io.EOF once the client
+// closed the connection.
+func (ws *webSocketConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, frameOpcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// echoing the status code of the client, as the closing handshake requires
+			_ = ws.writeFrame(opClose, payload[:min(len(payload), 2)])
+			return 0, nil, io.EOF
+		case opContinuation:
+			if opcode == 0 {
+				return 0, nil, &webSocketError{closeProtocolError, "unexpected continuation frame"}
+			}
+		case opText, opBinary:
+			if opcode != 0 {
+				return 0, nil, &webSocketError{closeProtocolError, "expected a continuation frame"}
+			}
+			opcode = frameOpcode
+		default:
+			return 0, nil, &webSocketError{closeProtocolError, fmt.Sprintf("unknown opcode %d", frameOpcode)}
+		}
+
+		if len(message)+len(payload) > maxWebSocketMessageSize {
+			return 0, nil, &webSocketError{closeMessageTooBig, "message too big"}
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads a frame of the client and unmasks its payload (https://www.rfc-editor.org/rfc/rfc6455#section-5.2)
+func (ws *webSocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &webSocketError{closeProtocolError, "reserved bits are set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &webSocketError{closeProtocolError, "frames of clients must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, &webSocketError{closeProtocolError, "control frames must not be fragmented or longer than 125 bytes"}
+	}
+	if length > maxWebSocketMessageSize {
+		return false, 0, nil, &webSocketError{closeMessageTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (ws *webSocketConn) writeJSON(v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(opText, payload)
+}
+
+func (ws *webSocketConn) writeClose(code uint16, reason string) error {
+	return ws.writeFrame(opClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+}
+
+// writeFrame writes an unfragmented, unmasked frame to the client
+func (ws *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= math.MaxUint16:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(len(payload)))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	_ = ws.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	_, err := ws.conn.Write(frame)
+	return err
+}
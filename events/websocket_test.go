@@ -0,0 +1,159 @@
+package events_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWebSocket is the client side of a WebSocket connection, which masks the frames it writes
+type testWebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWebSocket opens a WebSocket connection to path on server, with the sample key of RFC 6455
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) *testWebSocket {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", path)
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testWebSocket{conn: conn, reader: reader}
+}
+
+func (ws *testWebSocket) send(t *testing.T, opcode byte, payload []byte) {
+	require.Less(t, len(payload), 126)
+	mask := [4]byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := ws.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func (ws *testWebSocket) receive(t *testing.T) (byte, []byte) {
+	require.NoError(t, ws.conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var header [2]byte
+	_, err := io.ReadFull(ws.reader, header[:])
+	require.NoError(t, err)
+	require.Equal(t, byte(0x80), header[0]&0xF0)
+	require.Zero(t, header[1]&0x80, "frames of the server must not be masked")
+	length := int(header[1])
+	if length == 126 {
+		var extended [2]byte
+		_, err = io.ReadFull(ws.reader, extended[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(ws.reader, payload)
+	require.NoError(t, err)
+	return header[0] & 0x0F, payload
+}
+
+func (ws *testWebSocket) receiveJSON(t *testing.T, v any) {
+	opcode, payload := ws.receive(t)
+	require.Equal(t, byte(0x1), opcode)
+	require.NoError(t, json.Unmarshal(payload, v))
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(events.WebSocketHandler(bus))
+	defer server.Close()
+
+	type event struct {
+		Topic events.Topic    `json:"topic"`
+		Data  json.RawMessage `json:"data"`
+	}
+
+	t.Run("clients should receive the events of the topics of the query", func(t *testing.T) {
+		ws := dialWebSocket(t, server, "/?topics=newblock")
+
+		bus.Publish(events.TopicNewTx, events.NewTx{TxId: "cd"})
+		bus.Publish(events.TopicNewBlock, events.NewBlock{Hash: "ab", Height: 840000})
+
+		var received event
+		ws.receiveJSON(t, &received)
+		require.Equal(t, events.TopicNewBlock, received.Topic)
+		var block events.NewBlock
+		require.NoError(t, json.Unmarshal(received.Data, &block))
+		require.Equal(t, "ab", block.Hash)
+		require.Equal(t, int32(840000), block.Height)
+	})
+
+	t.Run("clients should change their topics with commands", func(t *testing.T) {
+		ws := dialWebSocket(t, server, "/")
+
+		var reply events.WebSocketReply
+		ws.send(t, 0x1, []byte(`{"subscribe":["peerevents","newtx"]}`))
+		ws.receiveJSON(t, &reply)
+		require.Equal(t, events.WebSocketReply{Subscribed: []events.Topic{events.TopicNewTx, events.TopicPeerEvents}}, reply)
+
+		ws.send(t, 0x1, []byte(`{"unsubscribe":["newtx"]}`))
+		ws.receiveJSON(t, &reply)
+		require.Equal(t, events.WebSocketReply{Subscribed: []events.Topic{events.TopicPeerEvents}}, reply)
+
+		ws.send(t, 0x1, []byte(`{"subscribe":["blocks"]}`))
+		ws.receiveJSON(t, &reply)
+		require.Equal(t, events.WebSocketReply{Subscribed: []events.Topic{events.TopicPeerEvents}, Error: `unknown topic "blocks"`}, reply)
+
+		bus.Publish(events.TopicNewTx, events.NewTx{TxId: "cd"})
+		bus.Publish(events.TopicPeerEvents, events.PeerEvent{Action: events.PeerConnected, Id: 3})
+		var received event
+		ws.receiveJSON(t, &received)
+		require.Equal(t, events.TopicPeerEvents, received.Topic)
+	})
+
+	t.Run("pings should be answered and the closing handshake completed", func(t *testing.T) {
+		ws := dialWebSocket(t, server, "/")
+
+		ws.send(t, 0x9, []byte("hello"))
+		opcode, payload := ws.receive(t)
+		require.Equal(t, byte(0xA), opcode)
+		require.Equal(t, []byte("hello"), payload)
+
+		ws.send(t, 0x8, []byte{0x03, 0xE8})
+		opcode, payload = ws.receive(t)
+		require.Equal(t, byte(0x8), opcode)
+		require.Equal(t, []byte{0x03, 0xE8}, payload)
+		_, err := ws.reader.ReadByte()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("unmasked frames should close the connection", func(t *testing.T) {
+		ws := dialWebSocket(t, server, "/")
+
+		_, err := ws.conn.Write([]byte{0x81, 0x02, '{', '}'})
+		require.NoError(t, err)
+		opcode, payload := ws.receive(t)
+		require.Equal(t, byte(0x8), opcode)
+		require.Equal(t, uint16(1002), binary.BigEndian.Uint16(payload))
+	})
+
+	t.Run("requests which are not WebSocket handshakes should be rejected", func(t *testing.T) {
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,149 @@
+// Package logging writes the log of the node to files, which are rotated once they grow too large or too old
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the timestamp appended to the names of rotated files, which sorts them from the oldest to the newest
+const rotatedFileTimeLayout = "20060102T150405.000"
+
+// RotatingFile is a file which is rotated before a write would make it larger than its maximum size, or once it is older than its maximum age:
+// it is renamed after the time of the rotation (e.g. node.log.20240420T101500.000) and a new file is started. Only the newest rotated files
+// are kept, up to the maximum number of backups. A limit of 0 disables it.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if it does not exist. Its age is counted from when it is opened.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups, now: time.Now}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooLarge := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	tooOld := r.maxAge > 0 && r.now().Sub(r.openedAt) >= r.maxAge
+	if tooLarge || tooOld {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size, r.openedAt = file, info.Size(), r.now()
+	return nil
+}
+
+// rotate renames the file after the current time, starts a new one and removes the rotated files beyond the maximum number of backups
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return err
+	}
+	rotatedPath := r.path + "." + r.now().UTC().Format(rotatedFileTimeLayout)
+	for i := 1; fileExists(rotatedPath); i++ {
+		rotatedPath = fmt.Sprintf("%s.%s-%d", r.path, r.now().UTC().Format(rotatedFileTimeLayout), i)
+	}
+	err = os.Rename(r.path, rotatedPath)
+	if err != nil {
+		return err
+	}
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	return r.removeOldBackups()
+}
+
+func (r *RotatingFile) removeOldBackups() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := r.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > r.maxBackups {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Backups returns the paths of the rotated files, from the oldest to the newest
+func (r *RotatingFile) Backups() ([]string, error) {
+	dir, name := filepath.Split(r.path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), name+".") {
+			backups = append(backups, filepath.Join(dir, entry.Name()))
+		}
+	}
+	slices.Sort(backups)
+	return backups, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("files should be rotated once they would exceed their maximum size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node.log")
+		r, err := OpenRotatingFile(path, 10, 0, 2)
+		require.NoError(t, err)
+		defer r.Close()
+		now := time.Date(2024, 4, 20, 10, 15, 0, 0, time.UTC)
+		r.now = func() time.Time { return now }
+
+		for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+			_, err = r.Write([]byte(line))
+			require.NoError(t, err)
+			now = now.Add(time.Second)
+		}
+
+		backups, err := r.Backups()
+		require.NoError(t, err)
+		require.Equal(t, []string{path + ".20240420T101502.000", path + ".20240420T101503.000"}, backups)
+		requireContent(t, "second\n", backups[0])
+		requireContent(t, "third\n", backups[1])
+		requireContent(t, "fourth\n", path)
+	})
+
+	t.Run("files should be rotated once they are older than their maximum age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node.log")
+		require.NoError(t, os.WriteFile(path, []byte("before\n"), 0o644))
+		r, err := OpenRotatingFile(path, 0, time.Hour, 0)
+		require.NoError(t, err)
+		defer r.Close()
+		now := time.Now()
+		r.now = func() time.Time { return now }
+		r.openedAt = now
+
+		_, err = r.Write([]byte("first\n"))
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+		_, err = r.Write([]byte("second\n"))
+		require.NoError(t, err)
+		_, err = r.Write([]byte("third\n"))
+		require.NoError(t, err)
+
+		backups, err := r.Backups()
+		require.NoError(t, err)
+		require.Len(t, backups, 1)
+		requireContent(t, "before\nfirst\n", backups[0])
+		requireContent(t, "second\nthird\n", path)
+	})
+
+	t.Run("rotations within the same millisecond should not overwrite each other", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "node.log")
+		r, err := OpenRotatingFile(path, 1, 0, 0)
+		require.NoError(t, err)
+		now := time.Date(2024, 4, 20, 10, 15, 0, 0, time.UTC)
+		r.now = func() time.Time { return now }
+
+		for _, line := range []string{"a", "b", "c"} {
+			_, err = r.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, r.Close())
+
+		backups, err := r.Backups()
+		require.NoError(t, err)
+		require.Equal(t, []string{path + ".20240420T101500.000", path + ".20240420T101500.000-1"}, backups)
+		_, err = r.Write([]byte("d"))
+		require.ErrorIs(t, err, os.ErrClosed)
+	})
+}
+
+func requireContent(t *testing.T, expected string, path string) {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(content))
+}
@@ -0,0 +1,35 @@
+package minisketch
+
+// Elements of sketches are elements of GF(2^32), represented as polynomials over GF(2) modulo x^32 + x^7 + x^3 + x^2 + 1, bit i holding the
+// coefficient of x^i. Adding two elements is XORing them.
+const fieldModulus = 1<<32 | 1<<7 | 1<<3 | 1<<2 | 1
+
+func mul(a, b uint32) uint32 {
+	product := uint64(0)
+	for i := 0; b != 0; i++ {
+		if b&1 == 1 {
+			product ^= uint64(a) << i
+		}
+		b >>= 1
+	}
+	for i := 62; i >= 32; i-- {
+		if product>>i&1 == 1 {
+			product ^= fieldModulus << (i - 32)
+		}
+	}
+	return uint32(product)
+}
+
+func square(a uint32) uint32 {
+	return mul(a, a)
+}
+
+// inv returns the inverse of a, which must not be 0, as a^(2^32-2)
+func inv(a uint32) uint32 {
+	result := uint32(1)
+	for i := 0; i < 31; i++ {
+		a = square(a)
+		result = mul(result, a)
+	}
+	return result
+}
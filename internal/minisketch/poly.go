@@ -0,0 +1,173 @@
+package minisketch
+
+import (
+	"math/rand"
+	"slices"
+)
+
+// poly is a polynomial over GF(2^32), the coefficient of x^i at index i, without trailing zero coefficients
+type poly []uint32
+
+func (p poly) trim() poly {
+	for len(p) > 0 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+func (p poly) degree() int {
+	return len(p) - 1
+}
+
+func (p poly) add(q poly) poly {
+	sum := make(poly, max(len(p), len(q)))
+	copy(sum, p)
+	for i, c := range q {
+		sum[i] ^= c
+	}
+	return sum.trim()
+}
+
+// mod returns the remainder of the division of p by the monic polynomial m
+func (p poly) mod(m poly) poly {
+	r := slices.Clone(p)
+	for len(r) >= len(m) {
+		lead := r[len(r)-1]
+		shift := len(r) - len(m)
+		for i, c := range m {
+			r[shift+i] ^= mul(lead, c)
+		}
+		r = r.trim()
+	}
+	return r
+}
+
+// div returns the quotient of the division of p by the monic polynomial m
+func (p poly) div(m poly) poly {
+	r := slices.Clone(p)
+	if len(r) < len(m) {
+		return nil
+	}
+	q := make(poly, len(r)-len(m)+1)
+	for len(r) >= len(m) {
+		lead := r[len(r)-1]
+		shift := len(r) - len(m)
+		q[shift] = lead
+		for i, c := range m {
+			r[shift+i] ^= mul(lead, c)
+		}
+		r = r.trim()
+	}
+	return q
+}
+
+// mulMod returns p times q modulo the monic polynomial m
+func (p poly) mulMod(q poly, m poly) poly {
+	if len(p) == 0 || len(q) == 0 {
+		return nil
+	}
+	product := make(poly, len(p)+len(q)-1)
+	for i, a := range p {
+		if a == 0 {
+			continue
+		}
+		for j, b := range q {
+			product[i+j] ^= mul(a, b)
+		}
+	}
+	return product.trim().mod(m)
+}
+
+// monic returns p divided by its leading coefficient
+func (p poly) monic() poly {
+	lead := inv(p[len(p)-1])
+	m := make(poly, len(p))
+	for i, c := range p {
+		m[i] = mul(c, lead)
+	}
+	return m
+}
+
+// gcd returns the monic greatest common divisor of p and q, which must not both be 0
+func gcd(p, q poly) poly {
+	for len(q) > 0 {
+		p, q = q, p.mod(q.monic())
+	}
+	return p.monic()
+}
+
+// hasDistinctRoots reports whether the monic polynomial m is the product of distinct factors x - r, which is when it divides x^(2^32) - x,
+// the product of x - r over every element r
+func (m poly) hasDistinctRoots() bool {
+	x := poly{0, 1}.mod(m)
+	power := x
+	for i := 0; i < 32; i++ {
+		power = power.mulMod(power, m)
+	}
+	return len(power.add(x)) == 0
+}
+
+// roots returns the roots of the monic polynomial m, which must have distinct roots. m is split into factors by its gcd with the trace
+// Tr(a*x) = sum of (a*x)^(2^i) for i < 32 modulo m, which is 0 at the roots r where Tr(a*r) is 0 and 1 at the others, for random elements a
+// (Berlekamp's trace algorithm).
+func (m poly) roots(rnd *rand.Rand) []uint32 {
+	switch m.degree() {
+	case 0:
+		return nil
+	case 1:
+		return []uint32{m[0]}
+	}
+	for {
+		a := poly{0, rnd.Uint32()}.mod(m)
+		trace := a
+		for i := 1; i < 32; i++ {
+			a = a.mulMod(a, m)
+			trace = trace.add(a)
+		}
+		if len(trace) == 0 {
+			continue
+		}
+		factor := gcd(m, trace)
+		if factor.degree() == 0 || factor.degree() == m.degree() {
+			continue
+		}
+		return append(factor.roots(rnd), m.div(factor).roots(rnd)...)
+	}
+}
+
+// berlekampMassey returns the shortest linear feedback shift register generating syndromes, as its connection polynomial
+// 1 + c1*x + ... + cL*x^L, whose roots are the inverses of the elements of a set if syndromes are their power sums
+func berlekampMassey(syndromes []uint32) poly {
+	current, previous := poly{1}, poly{1}
+	length, shift := 0, 1
+	previousDiscrepancy := uint32(1)
+	for n, syndrome := range syndromes {
+		discrepancy := syndrome
+		for i := 1; i <= length && i < len(current); i++ {
+			discrepancy ^= mul(current[i], syndromes[n-i])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		scale := mul(discrepancy, inv(previousDiscrepancy))
+		next := make(poly, max(len(current), len(previous)+shift))
+		copy(next, current)
+		for i, c := range previous {
+			next[i+shift] ^= mul(scale, c)
+		}
+		if 2*length <= n {
+			previous = current
+			length = n + 1 - length
+			previousDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		current = next
+	}
+	// the coefficients above the length are 0
+	connection := make(poly, length+1)
+	copy(connection, current)
+	return connection
+}
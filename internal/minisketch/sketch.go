@@ -0,0 +1,108 @@
+// Package minisketch implements PinSketch set sketches over 32-bit elements, the sketches BIP 330 transaction reconciliation exchanges, after
+// libminisketch (https://github.com/sipa/minisketch). A sketch of capacity c is the c odd power sums of the elements of a
+// set: combining the sketches of two sets gives the sketch of their symmetric difference, which can be decoded while it has at most c
+// elements.
+package minisketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+var (
+	ErrDecodeFailed     = errors.New("sketch could not be decoded")
+	ErrInvalidSketch    = errors.New("invalid sketch")
+	ErrCapacityMismatch = errors.New("sketches of different capacities")
+)
+
+// Size in bytes of an element of a serialized sketch
+const ElementSize = 4
+
+// Sketch holds the power sums of odd exponents 1, 3, ..., 2*capacity-1 of the elements added to it
+type Sketch struct {
+	syndromes []uint32
+}
+
+// New returns the sketch of an empty set with the given capacity
+func New(capacity int) *Sketch {
+	return &Sketch{syndromes: make([]uint32, capacity)}
+}
+
+// FromBytes returns the sketch serialized as data, whose capacity is its length divided by ElementSize
+func FromBytes(data []byte) (*Sketch, error) {
+	if len(data)%ElementSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes are not a whole number of elements", ErrInvalidSketch, len(data))
+	}
+	s := New(len(data) / ElementSize)
+	for i := range s.syndromes {
+		s.syndromes[i] = binary.LittleEndian.Uint32(data[i*ElementSize:])
+	}
+	return s, nil
+}
+
+func (s *Sketch) Capacity() int {
+	return len(s.syndromes)
+}
+
+// Add adds element, which must not be 0, to the set of the sketch, or removes it if it was already added
+func (s *Sketch) Add(element uint32) {
+	elementSquared := square(element)
+	power := element
+	for i := range s.syndromes {
+		s.syndromes[i] ^= power
+		power = mul(power, elementSquared)
+	}
+}
+
+// Merge turns s into the sketch of the symmetric difference of its set and the set of other, which must have the same capacity
+func (s *Sketch) Merge(other *Sketch) error {
+	if len(other.syndromes) != len(s.syndromes) {
+		return fmt.Errorf("%w: %d and %d", ErrCapacityMismatch, len(s.syndromes), len(other.syndromes))
+	}
+	for i, syndrome := range other.syndromes {
+		s.syndromes[i] ^= syndrome
+	}
+	return nil
+}
+
+// Bytes returns the serialization of the sketch: its power sums as 32-bit little-endian integers
+func (s *Sketch) Bytes() []byte {
+	data := make([]byte, len(s.syndromes)*ElementSize)
+	for i, syndrome := range s.syndromes {
+		binary.LittleEndian.PutUint32(data[i*ElementSize:], syndrome)
+	}
+	return data
+}
+
+// Decode returns the elements of the set of the sketch, in no particular order. ErrDecodeFailed is returned if the set has more elements than
+// the capacity of the sketch, which is detected unless the power sums happen to be those of a smaller set: roughly one time in capacity!.
+func (s *Sketch) Decode() ([]uint32, error) {
+	// the power sums of even exponents are the squares of the power sums of half the exponent, as squaring is linear in GF(2^32)
+	sums := make([]uint32, 2*len(s.syndromes))
+	for i := range sums {
+		if i%2 == 0 {
+			sums[i] = s.syndromes[i/2]
+		} else {
+			sums[i] = square(sums[i/2])
+		}
+	}
+	connection := berlekampMassey(sums)
+	if connection.degree() == 0 {
+		return []uint32{}, nil
+	}
+	if connection.degree() > len(s.syndromes) || connection[connection.degree()] == 0 {
+		return nil, ErrDecodeFailed
+	}
+
+	// the roots of the connection polynomial are the inverses of the elements, which are the roots of the reversed polynomial
+	locator := make(poly, len(connection))
+	for i, c := range connection {
+		locator[len(connection)-1-i] = c
+	}
+	if !locator.hasDistinctRoots() {
+		return nil, ErrDecodeFailed
+	}
+	return locator.roots(rand.New(rand.NewSource(rand.Int63()))), nil
+}
@@ -0,0 +1,70 @@
+package minisketch
+
+import (
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+)
+
+func TestField(t *testing.T) {
+	for _, a := range []uint32{1, 2, 0x8D, 0xFFFFFFFF, 0x12345678} {
+		require.Equal(t, uint32(1), mul(a, inv(a)))
+	}
+	// x^31 * x = x^32 = x^7 + x^3 + x^2 + 1
+	require.Equal(t, uint32(0x8D), mul(1<<31, 2))
+}
+
+func TestSketch_Decode(t *testing.T) {
+	t.Run("a set of at most the capacity should decode", func(t *testing.T) {
+		for _, size := range []int{0, 1, 2, 7, 20} {
+			elements := make([]uint32, 0, size)
+			s := New(20)
+			for len(elements) < size {
+				element := rand.Uint32()
+				if element == 0 {
+					continue
+				}
+				elements = append(elements, element)
+				s.Add(element)
+			}
+			decoded, err := s.Decode()
+			require.NoError(t, err)
+			require.ElementsMatch(t, elements, decoded)
+		}
+	})
+
+	t.Run("merged sketches should decode to the symmetric difference", func(t *testing.T) {
+		ours, theirs := New(8), New(8)
+		for element := uint32(1); element <= 100; element++ {
+			ours.Add(element)
+			theirs.Add(element)
+		}
+		ours.Add(1000)
+		ours.Add(1001)
+		theirs.Add(2000)
+		// serialization should round trip
+		theirs, err := FromBytes(theirs.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, 8, theirs.Capacity())
+
+		require.NoError(t, ours.Merge(theirs))
+		decoded, err := ours.Decode()
+		require.NoError(t, err)
+		require.ElementsMatch(t, []uint32{1000, 1001, 2000}, decoded)
+		require.ErrorIs(t, ours.Merge(New(4)), ErrCapacityMismatch)
+	})
+
+	t.Run("a set larger than the capacity should not decode", func(t *testing.T) {
+		s := New(20)
+		for range 40 {
+			s.Add(rand.Uint32() | 1)
+		}
+		_, err := s.Decode()
+		require.ErrorIs(t, err, ErrDecodeFailed)
+	})
+
+	t.Run("serialized sketches should be whole elements", func(t *testing.T) {
+		_, err := FromBytes([]byte{0x01, 0x02, 0x03})
+		require.ErrorIs(t, err, ErrInvalidSketch)
+	})
+}
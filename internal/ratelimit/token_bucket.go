@@ -0,0 +1,35 @@
+// Package ratelimit limits how often peers and RPC clients may do something, e.g. send a message of a command or make a request
+package ratelimit
+
+import "time"
+
+// TokenBucket implements a token bucket rate limiter. It is not safe for concurrent use.
+type TokenBucket struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewTokenBucket returns a full bucket of burst tokens, refilled at rate tokens per second from now
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:       rate,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: now,
+	}
+}
+
+// Allow takes a token from the bucket, and reports whether there was one to take
+func (t *TokenBucket) Allow(now time.Time) bool {
+	if elapsed := now.Sub(t.lastRefill).Seconds(); elapsed > 0 {
+		t.tokens = min(t.burst, t.tokens+elapsed*t.rate)
+		t.lastRefill = now
+	}
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
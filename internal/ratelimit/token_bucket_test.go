@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := NewTokenBucket(2, 3, now)
+
+	for range 3 {
+		if !bucket.Allow(now) {
+			t.Fatal("burst should be allowed")
+		}
+	}
+	if bucket.Allow(now) {
+		t.Fatal("message exceeding the burst should not be allowed")
+	}
+	// 2 tokens per second are refilled
+	now = now.Add(time.Second)
+	if !bucket.Allow(now) || !bucket.Allow(now) || bucket.Allow(now) {
+		t.Fatal("bucket should have been refilled with exactly 2 tokens")
+	}
+}
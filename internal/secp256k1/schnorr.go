@@ -0,0 +1,99 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+// TaggedHash returns the hash of data tagged with tag, SHA256(SHA256(tag) || SHA256(tag) || data), which keeps the hashes of different
+// purposes apart (https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki#design)
+func TaggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+// ParseXOnlyPublicKey parses a 32-byte public key of BIP 340, the X coordinate of the point whose Y is even
+func ParseXOnlyPublicKey(serialized []byte) (*PublicKey, error) {
+	if len(serialized) != 32 {
+		return nil, ErrInvalidPublicKey
+	}
+	return ParsePublicKey(append([]byte{0x02}, serialized...))
+}
+
+// SerializeXOnly returns the X coordinate of the key, which is how BIP 340 serializes the key or its negation, whichever has an even Y
+func (k *PublicKey) SerializeXOnly() []byte {
+	return k.X.FillBytes(make([]byte, 32))
+}
+
+// AddTweak returns the key plus tweak times G, and false if tweak is not below the order of the curve or the sum is the point at infinity
+// (https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#script-validation-rules)
+func (k *PublicKey) AddTweak(tweak []byte) (*PublicKey, bool) {
+	t := new(big.Int).SetBytes(tweak)
+	if t.Cmp(n) >= 0 {
+		return nil, false
+	}
+	x, y, ok := doubleScalarMult(t, big.NewInt(1), k).affine()
+	if !ok {
+		return nil, false
+	}
+	return &PublicKey{X: x, Y: y}, true
+}
+
+// VerifySchnorr reports whether sig, 64 bytes long, is a BIP 340 signature of msg by key, whose Y is taken to be even
+// (https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki#verification)
+func VerifySchnorr(key *PublicKey, msg []byte, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(p) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+	challenge := TaggedHash("BIP0340/challenge", sig[:32], key.SerializeXOnly(), msg)
+	e := new(big.Int).SetBytes(challenge[:])
+	e.Mod(e, n)
+
+	// R = sG - eP, P being the point of X key.X with an even Y
+	evenKey := key
+	if key.Y.Bit(0) == 1 {
+		evenKey = &PublicKey{X: key.X, Y: new(big.Int).Sub(p, key.Y)}
+	}
+	x, y, ok := doubleScalarMult(s, e.Sub(n, e), evenKey).affine()
+	if !ok || y.Bit(0) == 1 {
+		return false
+	}
+	return x.Cmp(r) == 0
+}
+
+// SignSchnorr returns the BIP 340 signature of msg by the private key d, in [1, n-1], with the auxiliary random data aux, 32 bytes long
+// (https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki#default-signing)
+func SignSchnorr(d *big.Int, msg []byte, aux []byte) []byte {
+	key := PublicKeyOf(d)
+	if key.Y.Bit(0) == 1 {
+		d = new(big.Int).Sub(n, d)
+	}
+	auxHash := TaggedHash("BIP0340/aux", aux)
+	masked := d.FillBytes(make([]byte, 32))
+	for i := range masked {
+		masked[i] ^= auxHash[i]
+	}
+	nonce := TaggedHash("BIP0340/nonce", masked, key.SerializeXOnly(), msg)
+	k := new(big.Int).SetBytes(nonce[:])
+	k.Mod(k, n)
+	r := PublicKeyOf(k)
+	if r.Y.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	challenge := TaggedHash("BIP0340/challenge", r.SerializeXOnly(), key.SerializeXOnly(), msg)
+	e := new(big.Int).SetBytes(challenge[:])
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(r.SerializeXOnly(), s.FillBytes(make([]byte, 32))...)
+}
@@ -0,0 +1,101 @@
+package secp256k1
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestSignSchnorr(t *testing.T) {
+	// test vectors of BIP 340 (https://github.com/bitcoin/bips/blob/master/bip-0340/test-vectors.csv)
+	tests := []struct {
+		privateKey string
+		publicKey  string
+		aux        string
+		msg        string
+		sig        string
+	}{
+		{
+			privateKey: "0000000000000000000000000000000000000000000000000000000000000003",
+			publicKey:  "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			aux:        "0000000000000000000000000000000000000000000000000000000000000000",
+			msg:        "0000000000000000000000000000000000000000000000000000000000000000",
+			sig:        "e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		},
+		{
+			privateKey: "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+			publicKey:  "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			aux:        "0000000000000000000000000000000000000000000000000000000000000001",
+			msg:        "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			sig:        "6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		},
+		{
+			privateKey: "c90fdaa22168c234c4c6628b80dc1cd129024e088a67cc74020bbea63b14e5c9",
+			publicKey:  "dd308afec5777e13121fa72b9cc1b7cc0139715309b086c960e18fd969774eb8",
+			aux:        "c87aa53824b4d7ae2eb035a2b5bbbccc080e76cdc6d1692c4b0b62d798e6d906",
+			msg:        "7e2d58d8b3bcdf1abadec7829054f90dda9805aab56c77333024b9d0a508b75c",
+			sig:        "5831aaeed7b44bb74e5eab94ba9d4294c49bcf2a60728d8b4c200f50dd313c1bab745879a5ad954a72c45a91c3a51d3c7adea98d82f8481e0e1e03674a6f3fb7",
+		},
+	}
+	for _, test := range tests {
+		d, _ := new(big.Int).SetString(test.privateKey, 16)
+		require.Equal(t, test.publicKey, hex.EncodeToString(PublicKeyOf(d).SerializeXOnly()))
+		sig := SignSchnorr(d, mustDecode(t, test.msg), mustDecode(t, test.aux))
+		require.Equal(t, test.sig, hex.EncodeToString(sig))
+
+		key, err := ParseXOnlyPublicKey(mustDecode(t, test.publicKey))
+		require.NoError(t, err)
+		require.True(t, VerifySchnorr(key, mustDecode(t, test.msg), sig))
+	}
+}
+
+func TestVerifySchnorr(t *testing.T) {
+	d := big.NewInt(3)
+	key := PublicKeyOf(d)
+	msg := mustDecode(t, "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89")
+	sig := SignSchnorr(d, msg, make([]byte, 32))
+	require.True(t, VerifySchnorr(key, msg, sig))
+
+	otherMsg := append([]byte{}, msg...)
+	otherMsg[0] ^= 1
+	require.False(t, VerifySchnorr(key, otherMsg, sig), "the signature should not match another message")
+	require.False(t, VerifySchnorr(PublicKeyOf(big.NewInt(4)), msg, sig), "the signature should not match another key")
+	require.False(t, VerifySchnorr(key, msg, sig[:63]))
+
+	// r and s must be below the orders of the field and of the group
+	highS := append([]byte{}, sig...)
+	n.FillBytes(highS[32:])
+	require.False(t, VerifySchnorr(key, msg, highS))
+	highR := append([]byte{}, sig...)
+	p.FillBytes(highR[:32])
+	require.False(t, VerifySchnorr(key, msg, highR))
+
+	// -s gives R with an odd Y
+	negatedS := append([]byte{}, sig...)
+	new(big.Int).Sub(n, new(big.Int).SetBytes(sig[32:])).FillBytes(negatedS[32:])
+	require.False(t, VerifySchnorr(key, msg, negatedS))
+}
+
+func TestAddTweak(t *testing.T) {
+	// the first key of the BIP 86 test vectors (https://github.com/bitcoin/bips/blob/master/bip-0086.mediawiki#test-vectors)
+	internalKey, err := ParseXOnlyPublicKey(mustDecode(t, "cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115"))
+	require.NoError(t, err)
+	tweak := TaggedHash("TapTweak", internalKey.SerializeXOnly())
+	outputKey, ok := internalKey.AddTweak(tweak[:])
+	require.True(t, ok)
+	require.Equal(t, "a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c", hex.EncodeToString(outputKey.SerializeXOnly()))
+
+	_, ok = internalKey.AddTweak(n.Bytes())
+	require.False(t, ok, "tweaks should be below the order of the curve")
+	// adding -1 to G gives the point at infinity
+	_, ok = PublicKeyOf(big.NewInt(1)).AddTweak(new(big.Int).Sub(n, big.NewInt(1)).Bytes())
+	require.False(t, ok)
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return decoded
+}
@@ -0,0 +1,275 @@
+// Package secp256k1 verifies the ECDSA and Schnorr (BIP 340) signatures of Bitcoin transactions, made over the secp256k1 curve
+// (https://www.secg.org/sec2-v2.pdf#subsection.2.4.1). It favours simplicity over speed and is not constant-time, which only matters for
+// signing: Sign and SignSchnorr are meant for tests.
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrInvalidPublicKey = errors.New("invalid public key")
+
+var (
+	// order of the field the coordinates of the points are in
+	p, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	// order of the group of points generated by G
+	n, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	// the curve is y² = x³ + 7
+	b = big.NewInt(7)
+	// exponent of the square roots of the field, as p = 3 mod 4
+	sqrtExponent = new(big.Int).Rsh(new(big.Int).Add(p, big.NewInt(1)), 2)
+)
+
+// PublicKey is a point of the curve other than the point at infinity
+type PublicKey struct {
+	X, Y *big.Int
+}
+
+// ParsePublicKey parses a public key serialized the way Bitcoin does: compressed (0x02 or 0x03 followed by X, the prefix telling whether Y
+// is even or odd), uncompressed (0x04 followed by X and Y) or hybrid (0x06 or 0x07 followed by X and Y, the prefix telling whether Y is even
+// or odd)
+func ParsePublicKey(serialized []byte) (*PublicKey, error) {
+	switch {
+	case len(serialized) == 33 && (serialized[0] == 0x02 || serialized[0] == 0x03):
+		x := new(big.Int).SetBytes(serialized[1:])
+		if x.Cmp(p) >= 0 {
+			return nil, ErrInvalidPublicKey
+		}
+		y := new(big.Int).Exp(curveRight(x), sqrtExponent, p)
+		// x³ + 7 has no square root if x is not the coordinate of a point
+		if !isOnCurve(x, y) {
+			return nil, ErrInvalidPublicKey
+		}
+		if y.Bit(0) != uint(serialized[0]&1) {
+			y.Sub(p, y)
+		}
+		return &PublicKey{X: x, Y: y}, nil
+	case len(serialized) == 65 && (serialized[0] == 0x04 || serialized[0] == 0x06 || serialized[0] == 0x07):
+		x, y := new(big.Int).SetBytes(serialized[1:33]), new(big.Int).SetBytes(serialized[33:])
+		if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 || !isOnCurve(x, y) {
+			return nil, ErrInvalidPublicKey
+		}
+		if serialized[0] != 0x04 && y.Bit(0) != uint(serialized[0]&1) {
+			return nil, ErrInvalidPublicKey
+		}
+		return &PublicKey{X: x, Y: y}, nil
+	default:
+		return nil, ErrInvalidPublicKey
+	}
+}
+
+// SerializeCompressed returns the compressed serialization of the key
+func (k *PublicKey) SerializeCompressed() []byte {
+	serialized := make([]byte, 33)
+	serialized[0] = 0x02 | byte(k.Y.Bit(0))
+	k.X.FillBytes(serialized[1:])
+	return serialized
+}
+
+// SerializeUncompressed returns the uncompressed serialization of the key
+func (k *PublicKey) SerializeUncompressed() []byte {
+	serialized := make([]byte, 65)
+	serialized[0] = 0x04
+	k.X.FillBytes(serialized[1:33])
+	k.Y.FillBytes(serialized[33:])
+	return serialized
+}
+
+// curveRight returns x³ + 7
+func curveRight(x *big.Int) *big.Int {
+	right := new(big.Int).Exp(x, big.NewInt(3), p)
+	right.Add(right, b)
+	return right.Mod(right, p)
+}
+
+func isOnCurve(x, y *big.Int) bool {
+	left := new(big.Int).Mul(y, y)
+	return left.Mod(left, p).Cmp(curveRight(x)) == 0
+}
+
+// Verify reports whether (r, s) is a valid signature of hash, the 32-byte hash of the signed message, by key. Signatures whose s is above
+// half the order of the curve are valid too, as they are in Bitcoin's consensus rules.
+func Verify(key *PublicKey, hash []byte, r, s *big.Int) bool {
+	if r.Sign() <= 0 || r.Cmp(n) >= 0 || s.Sign() <= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+	e := hashToInt(hash)
+	w := new(big.Int).ModInverse(s, n)
+	u1 := e.Mul(e, w)
+	u1.Mod(u1, n)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, n)
+
+	x, _, ok := doubleScalarMult(u1, u2, key).affine()
+	if !ok {
+		return false
+	}
+	return x.Mod(x, n).Cmp(r) == 0
+}
+
+// Sign signs hash, the 32-byte hash of the message, with the private key d and the nonce k, both in [1, n-1], returning the signature with
+// its s below half the order of the curve. The nonce must never be reused and must be secret, which tests do not need.
+func Sign(d *big.Int, k *big.Int, hash []byte) (*big.Int, *big.Int) {
+	x, _, _ := scalarMult(k, &PublicKey{X: gx, Y: gy}).affine()
+	r := x.Mod(x, n)
+	s := new(big.Int).Mul(r, d)
+	s.Add(s, hashToInt(hash))
+	s.Mul(s, new(big.Int).ModInverse(k, n))
+	s.Mod(s, n)
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+	return r, s
+}
+
+// PublicKeyOf returns the public key of the private key d, which is in [1, n-1]
+func PublicKeyOf(d *big.Int) *PublicKey {
+	x, y, _ := scalarMult(d, &PublicKey{X: gx, Y: gy}).affine()
+	return &PublicKey{X: x, Y: y}
+}
+
+func hashToInt(hash []byte) *big.Int {
+	// the order of the curve is 256 bits long, so a 32-byte hash is used as is
+	e := new(big.Int).SetBytes(hash)
+	return e.Mod(e, n)
+}
+
+// jacobianPoint is a point (X/Z², Y/Z³) of the curve, which is the point at infinity if Z is zero
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func infinity() jacobianPoint {
+	return jacobianPoint{x: big.NewInt(1), y: big.NewInt(1), z: new(big.Int)}
+}
+
+func toJacobian(key *PublicKey) jacobianPoint {
+	return jacobianPoint{x: new(big.Int).Set(key.X), y: new(big.Int).Set(key.Y), z: big.NewInt(1)}
+}
+
+// affine returns the coordinates of the point, unless it is the point at infinity
+func (a jacobianPoint) affine() (*big.Int, *big.Int, bool) {
+	if a.z.Sign() == 0 {
+		return nil, nil, false
+	}
+	zInv := new(big.Int).ModInverse(a.z, p)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+	x := new(big.Int).Mul(a.x, zInv2)
+	x.Mod(x, p)
+	y := zInv2.Mul(zInv2, zInv)
+	y.Mul(y, a.y)
+	y.Mod(y, p)
+	return x, y, true
+}
+
+// double returns 2a (https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-0.html#doubling-dbl-2009-l)
+func (a jacobianPoint) double() jacobianPoint {
+	if a.z.Sign() == 0 || a.y.Sign() == 0 {
+		return infinity()
+	}
+	xx := new(big.Int).Mul(a.x, a.x)
+	yy := new(big.Int).Mul(a.y, a.y)
+	yyyy := new(big.Int).Mul(yy, yy)
+	// d = 2((x + yy)² - xx - yyyy)
+	d := new(big.Int).Add(a.x, yy)
+	d.Mul(d, d)
+	d.Sub(d, xx)
+	d.Sub(d, yyyy)
+	d.Lsh(d, 1)
+	d.Mod(d, p)
+	e := xx.Mul(xx, big.NewInt(3))
+	f := new(big.Int).Mul(e, e)
+
+	x := f.Sub(f, new(big.Int).Lsh(d, 1))
+	x.Mod(x, p)
+	y := d.Sub(d, x)
+	y.Mul(y, e)
+	y.Sub(y, yyyy.Lsh(yyyy, 3))
+	y.Mod(y, p)
+	z := new(big.Int).Mul(a.y, a.z)
+	z.Lsh(z, 1)
+	z.Mod(z, p)
+	return jacobianPoint{x: x, y: y, z: z}
+}
+
+// add returns a + c (https://hyperelliptic.org/EFD/g1p/auto-shortw-jacobian-0.html#addition-add-2007-bl)
+func (a jacobianPoint) add(c jacobianPoint) jacobianPoint {
+	if a.z.Sign() == 0 {
+		return c
+	}
+	if c.z.Sign() == 0 {
+		return a
+	}
+	z1z1 := new(big.Int).Mul(a.z, a.z)
+	z1z1.Mod(z1z1, p)
+	z2z2 := new(big.Int).Mul(c.z, c.z)
+	z2z2.Mod(z2z2, p)
+	u1 := new(big.Int).Mul(a.x, z2z2)
+	u1.Mod(u1, p)
+	u2 := new(big.Int).Mul(c.x, z1z1)
+	u2.Mod(u2, p)
+	s1 := new(big.Int).Mul(a.y, c.z)
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, p)
+	s2 := new(big.Int).Mul(c.y, a.z)
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, p)
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) != 0 {
+			return infinity()
+		}
+		return a.double()
+	}
+
+	h := u2.Sub(u2, u1)
+	i := new(big.Int).Lsh(h, 1)
+	i.Mul(i, i)
+	j := new(big.Int).Mul(h, i)
+	r := s2.Sub(s2, s1)
+	r.Lsh(r, 1)
+	v := u1.Mul(u1, i)
+
+	x := new(big.Int).Mul(r, r)
+	x.Sub(x, j)
+	x.Sub(x, new(big.Int).Lsh(v, 1))
+	x.Mod(x, p)
+	y := v.Sub(v, x)
+	y.Mul(y, r)
+	y.Sub(y, s1.Mul(s1, j).Lsh(s1, 1))
+	y.Mod(y, p)
+	z := new(big.Int).Add(a.z, c.z)
+	z.Mul(z, z)
+	z.Sub(z, z1z1)
+	z.Sub(z, z2z2)
+	z.Mul(z, h)
+	z.Mod(z, p)
+	return jacobianPoint{x: x, y: y, z: z}
+}
+
+// scalarMult returns k times key
+func scalarMult(k *big.Int, key *PublicKey) jacobianPoint {
+	return doubleScalarMult(new(big.Int), k, key)
+}
+
+// doubleScalarMult returns u1 times G plus u2 times key, doubling once for both (Shamir's trick)
+func doubleScalarMult(u1, u2 *big.Int, key *PublicKey) jacobianPoint {
+	g := toJacobian(&PublicKey{X: gx, Y: gy})
+	q := toJacobian(key)
+	gq := g.add(q)
+	result := infinity()
+	for i := max(u1.BitLen(), u2.BitLen()) - 1; i >= 0; i-- {
+		result = result.double()
+		switch {
+		case u1.Bit(i) == 1 && u2.Bit(i) == 1:
+			result = result.add(gq)
+		case u1.Bit(i) == 1:
+			result = result.add(g)
+		case u2.Bit(i) == 1:
+			result = result.add(q)
+		}
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestPublicKeyOf(t *testing.T) {
+	// multiples of G (https://crypto.stackexchange.com/questions/784)
+	for d, expected := range map[int64]string{
+		1: "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		2: "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5",
+		3: "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+	} {
+		key := PublicKeyOf(big.NewInt(d))
+		require.Equal(t, expected, hex.EncodeToString(key.SerializeCompressed()))
+
+		parsed, err := ParsePublicKey(key.SerializeCompressed())
+		require.NoError(t, err)
+		require.Equal(t, key, parsed)
+		parsed, err = ParsePublicKey(key.SerializeUncompressed())
+		require.NoError(t, err)
+		require.Equal(t, key, parsed)
+	}
+	require.Equal(t, "1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a", hex.EncodeToString(PublicKeyOf(big.NewInt(2)).Y.Bytes()))
+	// (n - 1)G = -G
+	minusG := PublicKeyOf(new(big.Int).Sub(n, big.NewInt(1)))
+	require.Equal(t, gx, minusG.X)
+	require.Equal(t, new(big.Int).Sub(p, gy), minusG.Y)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	g := PublicKeyOf(big.NewInt(1)).SerializeUncompressed()
+	hybrid := append([]byte{0x06}, g[1:]...)
+	_, err := ParsePublicKey(hybrid)
+	require.NoError(t, err)
+	hybrid[0] = 0x07
+	_, err = ParsePublicKey(hybrid)
+	require.ErrorIs(t, err, ErrInvalidPublicKey, "the prefix of a hybrid key must match the parity of Y")
+
+	offCurve := append([]byte{}, g...)
+	offCurve[64]++
+	_, err = ParsePublicKey(offCurve)
+	require.ErrorIs(t, err, ErrInvalidPublicKey)
+	// x = 5 is not the coordinate of a point, as 5³ + 7 has no square root
+	notOnCurve := make([]byte, 33)
+	notOnCurve[0], notOnCurve[32] = 0x02, 5
+	_, err = ParsePublicKey(notOnCurve)
+	require.ErrorIs(t, err, ErrInvalidPublicKey)
+	_, err = ParsePublicKey(g[:64])
+	require.ErrorIs(t, err, ErrInvalidPublicKey)
+}
+
+func TestVerify(t *testing.T) {
+	d, _ := new(big.Int).SetString("c0ffee", 16)
+	key := PublicKeyOf(d)
+	hash := sha256.Sum256([]byte("message"))
+	r, s := Sign(d, big.NewInt(0x1234567), hash[:])
+
+	require.True(t, Verify(key, hash[:], r, s))
+	require.True(t, Verify(key, hash[:], r, new(big.Int).Sub(n, s)), "signatures with a high s are valid too")
+	otherHash := sha256.Sum256([]byte("other message"))
+	require.False(t, Verify(key, otherHash[:], r, s))
+	require.False(t, Verify(PublicKeyOf(big.NewInt(2)), hash[:], r, s))
+	require.False(t, Verify(key, hash[:], new(big.Int), s))
+	require.False(t, Verify(key, hash[:], r, n))
+}
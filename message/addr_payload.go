@@ -3,7 +3,7 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 )
 
@@ -77,7 +77,7 @@ func decodeAddrPayload(r io.Reader) (*AddrPayload, error) {
 		return nil, err
 	}
 	if addrCount > maxAddrCount {
-		return nil, errors.New("exceeded max address count")
+		return nil, fmt.Errorf("%w of addresses (%d)", ErrMaxCountExceeded, addrCount)
 	}
 
 	addressList := make([]Address, addrCount)
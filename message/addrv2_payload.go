@@ -0,0 +1,167 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Longest address an addrv2 message may carry (https://github.com/bitcoin/bips/blob/master/bip-0155.mediawiki)
+const maxAddrV2Length = 512
+
+// NetworkID identifies the network of an address in an addrv2 message (BIP 155)
+type NetworkID uint8
+
+const (
+	NetworkIDIPv4  NetworkID = 1
+	NetworkIDIPv6  NetworkID = 2
+	NetworkIDTorV2 NetworkID = 3
+	NetworkIDTorV3 NetworkID = 4
+	NetworkIDI2P   NetworkID = 5
+	NetworkIDCJDNS NetworkID = 6
+)
+
+// length of the addresses of each network
+var networkIDAddrLengths = map[NetworkID]int{
+	NetworkIDIPv4:  4,
+	NetworkIDIPv6:  16,
+	NetworkIDTorV2: 10,
+	NetworkIDTorV3: 32,
+	NetworkIDI2P:   32,
+	NetworkIDCJDNS: 16,
+}
+
+// AddressV2 is an address of a node in an addrv2 message, which can also be an address of a network other than IPv4 and IPv6 (BIP 155)
+type AddressV2 struct {
+	Timestamp uint32
+	Services  Services
+	NetworkID NetworkID
+	Addr      []byte
+	Port      uint16
+}
+
+// Address returns the address in the format of addr messages, which can only hold IPv4 and IPv6 (including CJDNS) addresses
+func (a AddressV2) Address() (Address, bool) {
+	switch a.NetworkID {
+	case NetworkIDIPv4, NetworkIDIPv6, NetworkIDCJDNS:
+		return *NewAddress(a.Timestamp, *NewNetworkAddress(a.Services, net.IP(a.Addr).To16(), a.Port)), true
+	}
+	return Address{}, false
+}
+
+// https://github.com/bitcoin/bips/blob/master/bip-0155.mediawiki#specification
+type AddrV2Payload struct {
+	AddressList []AddressV2
+}
+
+func NewAddrV2Message(addressList []AddressV2) (*Message, error) {
+	payload := &AddrV2Payload{AddressList: addressList}
+	return newMessage(payload)
+}
+
+func (a *AddrV2Payload) CommandName() CommandName {
+	return AddrV2Command
+}
+
+func (a *AddrV2Payload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	addrCountEncoded, err := VarInt(len(a.AddressList)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(addrCountEncoded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range a.AddressList {
+		err = binary.Write(buffer, binary.LittleEndian, address.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		servicesEncoded, err := VarInt(address.Services).Encode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = buffer.Write(servicesEncoded)
+		if err != nil {
+			return nil, err
+		}
+		err = buffer.WriteByte(byte(address.NetworkID))
+		if err != nil {
+			return nil, err
+		}
+		addrLengthEncoded, err := VarInt(len(address.Addr)).Encode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = buffer.Write(addrLengthEncoded)
+		if err != nil {
+			return nil, err
+		}
+		_, err = buffer.Write(address.Addr)
+		if err != nil {
+			return nil, err
+		}
+		// the port is in network byte order
+		err = binary.Write(buffer, binary.BigEndian, address.Port)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decodeAddrV2Payload(r io.Reader) (*AddrV2Payload, error) {
+	addrCount, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if addrCount > maxAddrCount {
+		return nil, fmt.Errorf("%w of addresses (%d)", ErrMaxCountExceeded, addrCount)
+	}
+
+	addressList := make([]AddressV2, addrCount)
+	for i := range addrCount {
+		address := &addressList[i]
+		err = binary.Read(r, binary.LittleEndian, &address.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		services, err := DecodeVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		address.Services = Services(services)
+		err = binary.Read(r, binary.LittleEndian, &address.NetworkID)
+		if err != nil {
+			return nil, err
+		}
+		addrLength, err := DecodeVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		if addrLength > maxAddrV2Length {
+			return nil, fmt.Errorf("address (length: %d) %w", addrLength, ErrMaxLengthExceeded)
+		}
+		// addresses of unknown networks must be skipped, but those of known networks must have the network's length
+		if expected, ok := networkIDAddrLengths[address.NetworkID]; ok && int(addrLength) != expected {
+			return nil, fmt.Errorf("address of network %d has length %d instead of %d", address.NetworkID, addrLength, expected)
+		}
+		address.Addr = make([]byte, addrLength)
+		_, err = io.ReadFull(r, address.Addr)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.BigEndian, &address.Port)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &AddrV2Payload{AddressList: addressList}, nil
+}
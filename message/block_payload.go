@@ -1,6 +1,7 @@
 package message
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
@@ -126,9 +127,12 @@ func DecodeBlockPayload(r io.Reader) (*BlockPayload, error) {
 	if err != nil {
 		return nil, err
 	}
+	// DecodeTxPayload buffers its reader, so the transactions must share a single buffered reader for none of them to be read ahead of
+	// (bufio.NewReader returns r itself if it is already buffered)
+	br := bufio.NewReader(r)
 	b.Transactions = make([]TxPayload, transactionsCount)
 	for i := range transactionsCount {
-		tx, err := decodeTxPayload(r)
+		tx, err := DecodeTxPayload(br)
 		if err != nil {
 			return nil, err
 		}
@@ -172,3 +176,35 @@ func (b *BlockPayload) GetBlockHash() (Hash256, error) {
 
 	return hash, nil
 }
+
+// CoinbaseHeight returns the block height committed to in the coinbase transaction's signature script (https://github.com/bitcoin/bips/blob/master/bip-0034.mediawiki).
+// The second return value is false if the block does not commit to a height that can be trusted, which is the case for blocks mined before BIP34 was activated
+// at bip34Height (see constants.Deployments).
+func (b *BlockPayload) CoinbaseHeight(bip34Height int32) (int32, bool) {
+	if b.Version < 2 || len(b.Transactions) == 0 || len(b.Transactions[0].TransactionInputs) == 0 {
+		return 0, false
+	}
+	script := b.Transactions[0].TransactionInputs[0].SignatureScript
+	if len(script) == 0 {
+		return 0, false
+	}
+
+	var height int32
+	// the height is the first push of the script, serialized as a little-endian CScriptNum
+	switch pushLength := int(script[0]); {
+	case pushLength >= 1 && pushLength <= 4:
+		if len(script) < 1+pushLength {
+			return 0, false
+		}
+		var heightBytes [4]byte
+		copy(heightBytes[:], script[1:1+pushLength])
+		height = int32(binary.LittleEndian.Uint32(heightBytes[:]))
+	default:
+		return 0, false
+	}
+
+	if height < bip34Height {
+		return 0, false
+	}
+	return height, true
+}
@@ -0,0 +1,362 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FilterType identifies the kind of compact block filter (BIP157) a message is about
+type FilterType uint8
+
+// Filters of the scripts of the outputs a block creates and spends (BIP158)
+const BasicFilterType FilterType = 0
+
+// Limits of the compact block filter messages (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L159-L163)
+const (
+	// Maximum number of filters requested by a getcfilters message
+	MaxGetCFiltersSize = 1000
+	// Maximum number of filter hashes requested by a getcfheaders message and carried by a cfheaders message
+	MaxGetCFHeadersSize = 2000
+	// Number of blocks between two filter headers of a cfcheckpt message
+	CFCheckptInterval = 1000
+)
+
+// Requests the filters of the blocks of the chain ending with StopHash, from StartHeight up to it (BIP157)
+type GetCFiltersPayload struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    Hash256
+}
+
+func (p *GetCFiltersPayload) CommandName() CommandName {
+	return GetCFiltersCommand
+}
+
+func (p *GetCFiltersPayload) Encode() ([]byte, error) {
+	return encodeCFilterRange(p.FilterType, p.StartHeight, p.StopHash)
+}
+
+func decodeGetCFiltersPayload(r io.Reader) (*GetCFiltersPayload, error) {
+	p := GetCFiltersPayload{}
+	err := decodeCFilterRange(r, &p.FilterType, &p.StartHeight, &p.StopHash)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewGetCFiltersMessage(filterType FilterType, startHeight uint32, stopHash Hash256) (*Message, error) {
+	return newMessage(&GetCFiltersPayload{FilterType: filterType, StartHeight: startHeight, StopHash: stopHash})
+}
+
+// The filter of a block, sent for every block requested by a getcfilters message
+type CFilterPayload struct {
+	FilterType FilterType
+	BlockHash  Hash256
+	Filter     []byte
+}
+
+func (p *CFilterPayload) CommandName() CommandName {
+	return CFilterCommand
+}
+
+func (p *CFilterPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.BlockHash[:])
+	if err != nil {
+		return nil, err
+	}
+	filterLengthEncoded, err := VarInt(len(p.Filter)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(filterLengthEncoded)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeCFilterPayload(r io.Reader) (*CFilterPayload, error) {
+	p := CFilterPayload{}
+	err := binary.Read(r, binary.LittleEndian, &p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, p.BlockHash[:])
+	if err != nil {
+		return nil, err
+	}
+	filterLength, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if filterLength > VarInt(maxPayloadSize) {
+		return nil, fmt.Errorf("cfilter filter (length: %d) %w", filterLength, ErrMaxLengthExceeded)
+	}
+	p.Filter = make([]byte, filterLength)
+	_, err = io.ReadFull(r, p.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewCFilterMessage(filterType FilterType, blockHash Hash256, filter []byte) (*Message, error) {
+	return newMessage(&CFilterPayload{FilterType: filterType, BlockHash: blockHash, Filter: filter})
+}
+
+// Requests the filter headers of the blocks of the chain ending with StopHash, from StartHeight up to it (BIP157)
+type GetCFHeadersPayload struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    Hash256
+}
+
+func (p *GetCFHeadersPayload) CommandName() CommandName {
+	return GetCFHeadersCommand
+}
+
+func (p *GetCFHeadersPayload) Encode() ([]byte, error) {
+	return encodeCFilterRange(p.FilterType, p.StartHeight, p.StopHash)
+}
+
+func decodeGetCFHeadersPayload(r io.Reader) (*GetCFHeadersPayload, error) {
+	p := GetCFHeadersPayload{}
+	err := decodeCFilterRange(r, &p.FilterType, &p.StartHeight, &p.StopHash)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewGetCFHeadersMessage(filterType FilterType, startHeight uint32, stopHash Hash256) (*Message, error) {
+	return newMessage(&GetCFHeadersPayload{FilterType: filterType, StartHeight: startHeight, StopHash: stopHash})
+}
+
+// Answers a getcfheaders message with the hashes of the requested filters, from which the filter headers are derived starting from the
+// header preceding them
+type CFHeadersPayload struct {
+	FilterType FilterType
+	StopHash   Hash256
+	// Filter header of the block preceding the first requested one
+	PreviousFilterHeader Hash256
+	FilterHashes         []Hash256
+}
+
+func (p *CFHeadersPayload) CommandName() CommandName {
+	return CFHeadersCommand
+}
+
+func (p *CFHeadersPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.PreviousFilterHeader[:])
+	if err != nil {
+		return nil, err
+	}
+	err = encodeHashes(buffer, p.FilterHashes)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeCFHeadersPayload(r io.Reader) (*CFHeadersPayload, error) {
+	p := CFHeadersPayload{}
+	err := binary.Read(r, binary.LittleEndian, &p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, p.PreviousFilterHeader[:])
+	if err != nil {
+		return nil, err
+	}
+	p.FilterHashes, err = decodeHashes(r, MaxGetCFHeadersSize)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewCFHeadersMessage(filterType FilterType, stopHash Hash256, previousFilterHeader Hash256, filterHashes []Hash256) (*Message, error) {
+	return newMessage(&CFHeadersPayload{FilterType: filterType, StopHash: stopHash, PreviousFilterHeader: previousFilterHeader, FilterHashes: filterHashes})
+}
+
+// Requests the filter headers of every CFCheckptInterval-th block of the chain ending with StopHash (BIP157)
+type GetCFCheckptPayload struct {
+	FilterType FilterType
+	StopHash   Hash256
+}
+
+func (p *GetCFCheckptPayload) CommandName() CommandName {
+	return GetCFCheckptCommand
+}
+
+func (p *GetCFCheckptPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeGetCFCheckptPayload(r io.Reader) (*GetCFCheckptPayload, error) {
+	p := GetCFCheckptPayload{}
+	err := binary.Read(r, binary.LittleEndian, &p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewGetCFCheckptMessage(filterType FilterType, stopHash Hash256) (*Message, error) {
+	return newMessage(&GetCFCheckptPayload{FilterType: filterType, StopHash: stopHash})
+}
+
+// Answers a getcfcheckpt message with the filter headers at heights CFCheckptInterval, 2*CFCheckptInterval, ... of the chain ending with
+// StopHash
+type CFCheckptPayload struct {
+	FilterType    FilterType
+	StopHash      Hash256
+	FilterHeaders []Hash256
+}
+
+func (p *CFCheckptPayload) CommandName() CommandName {
+	return CFCheckptCommand
+}
+
+func (p *CFCheckptPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	err = encodeHashes(buffer, p.FilterHeaders)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeCFCheckptPayload(r io.Reader) (*CFCheckptPayload, error) {
+	p := CFCheckptPayload{}
+	err := binary.Read(r, binary.LittleEndian, &p.FilterType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, p.StopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	p.FilterHeaders, err = decodeHashes(r, int(maxPayloadSize/32))
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewCFCheckptMessage(filterType FilterType, stopHash Hash256, filterHeaders []Hash256) (*Message, error) {
+	return newMessage(&CFCheckptPayload{FilterType: filterType, StopHash: stopHash, FilterHeaders: filterHeaders})
+}
+
+func encodeCFilterRange(filterType FilterType, startHeight uint32, stopHash Hash256) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, filterType)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, startHeight)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(stopHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeCFilterRange(r io.Reader, filterType *FilterType, startHeight *uint32, stopHash *Hash256) error {
+	err := binary.Read(r, binary.LittleEndian, filterType)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(r, binary.LittleEndian, startHeight)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(r, stopHash[:])
+	return err
+}
+
+// encodeHashes writes the number of hashes as a varint followed by the hashes
+func encodeHashes(buffer *bytes.Buffer, hashes []Hash256) error {
+	countEncoded, err := VarInt(len(hashes)).Encode()
+	if err != nil {
+		return err
+	}
+	_, err = buffer.Write(countEncoded)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = buffer.Write(hash[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeHashes(r io.Reader, max int) ([]Hash256, error) {
+	count, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > VarInt(max) {
+		return nil, fmt.Errorf("%w of hashes (%d)", ErrMaxCountExceeded, count)
+	}
+	hashes := make([]Hash256, count)
+	for i := range hashes {
+		_, err = io.ReadFull(r, hashes[i][:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
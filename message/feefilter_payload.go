@@ -0,0 +1,49 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Asks the receiver not to announce transactions paying a lower fee rate than FeeRate (BIP 133)
+type FeeFilterPayload struct {
+	// Minimum fee rate in satoshis per 1000 bytes
+	FeeRate int64
+}
+
+func (f *FeeFilterPayload) CommandName() CommandName {
+	return FeeFilterCommand
+}
+
+func (f *FeeFilterPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, f.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeFeeFilterPayload(r io.Reader) (*FeeFilterPayload, error) {
+	f := FeeFilterPayload{}
+	err := binary.Read(r, binary.LittleEndian, &f.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	if f.FeeRate < 0 {
+		return nil, ErrNegativeFeeRate
+	}
+	return &f, nil
+}
+
+func newFeeFilterPayload(feeRate int64) *FeeFilterPayload {
+	return &FeeFilterPayload{
+		FeeRate: feeRate,
+	}
+}
+
+func NewFeeFilterMessage(feeRate int64) (*Message, error) {
+	payload := newFeeFilterPayload(feeRate)
+	return newMessage(payload)
+}
@@ -0,0 +1,170 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Limits of bloom filters (https://github.com/bitcoin/bitcoin/blob/v27.0/src/common/bloom.h#L18-L19)
+const (
+	MaxBloomFilterSize = 36000
+	MaxBloomHashFuncs  = 50
+)
+
+// Largest element that can be added to a bloom filter, which is the largest script push (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L27)
+const MaxFilterAddDataSize = 520
+
+// BloomUpdateFlags decide which outpoints are added to a bloom filter when one of their transaction's outputs matches it (BIP 37)
+type BloomUpdateFlags uint8
+
+const (
+	BloomUpdateNone BloomUpdateFlags = iota
+	BloomUpdateAll
+	// Only outpoints of pay-to-pubkey and bare multisig outputs are added
+	BloomUpdateP2PubkeyOnly
+)
+
+// Sets a bloom filter on the connection, so that only the transactions matching it are announced (https://en.bitcoin.it/wiki/Protocol_documentation#filterload.2C_filteradd.2C_filterclear.2C_merkleblock)
+type FilterLoadPayload struct {
+	Filter []byte
+	// Number of hash functions of the filter
+	HashFuncs uint32
+	// Added to the seed of every hash function
+	Tweak uint32
+	Flags BloomUpdateFlags
+}
+
+func (f *FilterLoadPayload) CommandName() CommandName {
+	return FilterLoadCommand
+}
+
+func (f *FilterLoadPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	filterLengthEncoded, err := VarInt(len(f.Filter)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(filterLengthEncoded)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(f.Filter)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, f.HashFuncs)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, f.Tweak)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, f.Flags)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeFilterLoadPayload(r io.Reader) (*FilterLoadPayload, error) {
+	f := FilterLoadPayload{}
+	filterLength, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if filterLength > MaxBloomFilterSize {
+		return nil, fmt.Errorf("bloom filter (length: %d) %w", filterLength, ErrMaxLengthExceeded)
+	}
+	f.Filter = make([]byte, filterLength)
+	_, err = io.ReadFull(r, f.Filter)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &f.HashFuncs)
+	if err != nil {
+		return nil, err
+	}
+	if f.HashFuncs > MaxBloomHashFuncs {
+		return nil, fmt.Errorf("%w of bloom filter hash functions (%d)", ErrMaxCountExceeded, f.HashFuncs)
+	}
+	err = binary.Read(r, binary.LittleEndian, &f.Tweak)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &f.Flags)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func NewFilterLoadMessage(filter []byte, hashFuncs uint32, tweak uint32, flags BloomUpdateFlags) (*Message, error) {
+	payload := &FilterLoadPayload{Filter: filter, HashFuncs: hashFuncs, Tweak: tweak, Flags: flags}
+	return newMessage(payload)
+}
+
+// Adds Data to the bloom filter set on the connection
+type FilterAddPayload struct {
+	Data []byte
+}
+
+func (f *FilterAddPayload) CommandName() CommandName {
+	return FilterAddCommand
+}
+
+func (f *FilterAddPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	dataLengthEncoded, err := VarInt(len(f.Data)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(dataLengthEncoded)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(f.Data)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeFilterAddPayload(r io.Reader) (*FilterAddPayload, error) {
+	dataLength, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if dataLength > MaxFilterAddDataSize {
+		return nil, fmt.Errorf("filteradd data (length: %d) %w", dataLength, ErrMaxLengthExceeded)
+	}
+	f := FilterAddPayload{Data: make([]byte, dataLength)}
+	_, err = io.ReadFull(r, f.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func NewFilterAddMessage(data []byte) (*Message, error) {
+	payload := &FilterAddPayload{Data: data}
+	return newMessage(payload)
+}
+
+// Removes the bloom filter set on the connection
+type FilterClearPayload struct{}
+
+func (f *FilterClearPayload) CommandName() CommandName {
+	return FilterClearCommand
+}
+
+func (f *FilterClearPayload) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func NewFilterClearMessage() (*Message, error) {
+	payload := &FilterClearPayload{}
+	return newMessage(payload)
+}
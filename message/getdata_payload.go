@@ -3,7 +3,7 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 )
 
@@ -56,7 +56,7 @@ func decodeGetDataPayload(r io.Reader) (*GetDataPayload, error) {
 		return nil, err
 	}
 	if count > maxInvCount {
-		return nil, errors.New("exceeded max inv count")
+		return nil, fmt.Errorf("%w of inventory vectors (%d)", ErrMaxCountExceeded, count)
 	}
 
 	inventoryList := make([]Inventory, count)
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Return a headers packet containing the headers of blocks starting right after the last known hash in the block locator object, up to hash_stop or 2000 blocks, whichever comes first. (https://en.bitcoin.it/wiki/Protocol_documentation#getheaders)
+type GetHeadersPayload struct {
+	// The protocol version number; the same as sent in the “version” message.
+	Version uint32
+	// Hashes should be provided in reverse order of block height, so highest-height hashes are listed first and lowest-height hashes are listed last.
+	BlockLocatorHashes []Hash256
+	// Hash of the last desired block header; set to zero to get as many blocks as possible (2000)
+	HashStop Hash256
+}
+
+func (p *GetHeadersPayload) CommandName() CommandName {
+	return GetHeadersCommand
+}
+
+func (p *GetHeadersPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	err := binary.Write(buffer, binary.LittleEndian, p.Version)
+	if err != nil {
+		return nil, err
+	}
+	blockLocatorHashesCountEncoded, err := VarInt(len(p.BlockLocatorHashes)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(blockLocatorHashesCountEncoded)
+	if err != nil {
+		return nil, err
+	}
+	for _, blockHash := range p.BlockLocatorHashes {
+		_, err = buffer.Write(blockHash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = buffer.Write(p.HashStop[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decodeGetHeadersPayload(r io.Reader) (*GetHeadersPayload, error) {
+	p := GetHeadersPayload{}
+
+	err := binary.Read(r, binary.LittleEndian, &p.Version)
+	if err != nil {
+		return nil, err
+	}
+	blockLocatorHashesCount, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	p.BlockLocatorHashes = make([]Hash256, blockLocatorHashesCount)
+	for i := range p.BlockLocatorHashes {
+		_, err = io.ReadFull(r, p.BlockLocatorHashes[i][:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = io.ReadFull(r, p.HashStop[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func newGetHeadersPayload(version uint32, blockLocatorHashes []Hash256, hashStop Hash256) *GetHeadersPayload {
+	return &GetHeadersPayload{
+		Version:            version,
+		BlockLocatorHashes: blockLocatorHashes,
+		HashStop:           hashStop,
+	}
+}
+
+func NewGetHeadersMessage(version uint32, blockLocatorHashes []Hash256, hashStop Hash256) (*Message, error) {
+	payload := newGetHeadersPayload(version, blockLocatorHashes, hashStop)
+	return newMessage(payload)
+}
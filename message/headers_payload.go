@@ -0,0 +1,97 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Maximum number of headers a headers message may carry (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L113)
+const MaxHeadersResults = 2000
+
+// The headers packet returns block headers in response to a getheaders packet. (https://en.bitcoin.it/wiki/Protocol_documentation#headers)
+type HeadersPayload struct {
+	// Block headers, which are blocks without transactions
+	Headers []BlockPayload
+}
+
+func (p *HeadersPayload) CommandName() CommandName {
+	return HeadersCommand
+}
+
+func (p *HeadersPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	countEncoded, err := VarInt(len(p.Headers)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(countEncoded)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range p.Headers {
+		// the transactions are left out, so every header is followed by a transaction count of zero
+		header.Transactions = nil
+		headerEncoded, err := header.Encode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = buffer.Write(headerEncoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decodeHeadersPayload(r io.Reader) (*HeadersPayload, error) {
+	count, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > MaxHeadersResults {
+		return nil, fmt.Errorf("%w of headers (%d)", ErrMaxCountExceeded, count)
+	}
+	p := HeadersPayload{Headers: make([]BlockPayload, count)}
+	for i := range p.Headers {
+		header := &p.Headers[i]
+		err = binary.Read(r, binary.LittleEndian, &header.Version)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(r, header.PrevBlock[:])
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(r, header.MerkleRoot[:])
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.LittleEndian, &header.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.LittleEndian, &header.Bits)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(r, binary.LittleEndian, &header.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		// the transaction count is always zero
+		_, err = DecodeVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+func NewHeadersMessage(headers []BlockPayload) (*Message, error) {
+	return newMessage(&HeadersPayload{Headers: headers})
+}
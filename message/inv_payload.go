@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"errors"
+	"fmt"
 	"io"
 	"slices"
 )
@@ -23,11 +23,13 @@ func (h Hash256) String() string {
 type InventoryType uint32
 
 const (
-	Error                   InventoryType = 0
-	MsgTx                   InventoryType = 1
-	MsgBlock                InventoryType = 2
-	MsgFilteredBlock        InventoryType = 3
-	MsgCmpctBlock           InventoryType = 4
+	Error            InventoryType = 0
+	MsgTx            InventoryType = 1
+	MsgBlock         InventoryType = 2
+	MsgFilteredBlock InventoryType = 3
+	MsgCmpctBlock    InventoryType = 4
+	// Transaction announced by its wtxid, to peers that negotiated wtxidrelay (BIP 339)
+	MsgWtx                  InventoryType = 5
 	MsgWitnessTx            InventoryType = 0x40000001
 	MsgWitnessBlock         InventoryType = 0x40000002
 	MsgFilteredWitnessBlock InventoryType = 0x40000003
@@ -87,7 +89,7 @@ func decodeInvPayload(r io.Reader) (*InvPayload, error) {
 		return nil, err
 	}
 	if count > maxInvCount {
-		return nil, errors.New("exceeded max inv count")
+		return nil, fmt.Errorf("%w of inventory vectors (%d)", ErrMaxCountExceeded, count)
 	}
 
 	inventoryList := make([]Inventory, count)
@@ -0,0 +1,21 @@
+package message
+
+// Asks for the transactions in the receiver's mempool, which it announces in inv messages (https://en.bitcoin.it/wiki/Protocol_documentation#mempool)
+type MempoolPayload struct{}
+
+func (m *MempoolPayload) CommandName() CommandName {
+	return MempoolCommand
+}
+
+func (m *MempoolPayload) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func newMempoolPayload() *MempoolPayload {
+	return &MempoolPayload{}
+}
+
+func NewMempoolMessage() (*Message, error) {
+	payload := newMempoolPayload()
+	return newMessage(payload)
+}
@@ -1,3 +1,5 @@
+// Package message encodes and decodes the messages of the Bitcoin P2P protocol and their payloads
+// (https://en.bitcoin.it/wiki/Protocol_documentation).
 package message
 
 import (
@@ -8,6 +10,8 @@ import (
 )
 
 const (
+	// Length of an encoded message header
+	HeaderLength             = 24
 	commandNameLength        = 12
 	checksumLength           = 4
 	maxPayloadSize    uint32 = 32 * 1024 * 1024
@@ -17,10 +21,19 @@ var (
 	ErrPayloadTooBig        = errors.New("payload too big")
 	ErrInvalidChecksum      = errors.New("invalid Checksum")
 	ErrInvalidPayloadLength = errors.New("invalid Payload length")
+	// a payload lists more items (e.g. inventory vectors or headers) than the protocol allows
+	ErrMaxCountExceeded = errors.New("exceeded max count")
+	// a variable-length field of a payload (e.g. a script or a filter) is longer than the protocol allows
+	ErrMaxLengthExceeded  = errors.New("exceeded max length")
+	ErrNegativeFeeRate    = errors.New("negative fee rate")
+	ErrInvalidSuccessFlag = errors.New("invalid reconcildiff success flag")
+	ErrUnknownService     = errors.New("unknown service")
 )
 
 type ErrUnknownCommandName struct {
 	Command CommandName
+	// Length of the skipped payload
+	Length uint32
 }
 
 func (e *ErrUnknownCommandName) Error() string {
@@ -28,23 +41,48 @@ func (e *ErrUnknownCommandName) Error() string {
 }
 
 var (
-	VersionCommand    = CommandName{'v', 'e', 'r', 's', 'i', 'o', 'n'}
-	VerackCommand     = CommandName{'v', 'e', 'r', 'a', 'c', 'k'}
-	WtxidRelayCommand = CommandName{'w', 't', 'x', 'i', 'd', 'r', 'e', 'l', 'a', 'y'}
-	SendAddrV2Command = CommandName{'s', 'e', 'n', 'd', 'a', 'd', 'd', 'r', 'v', '2'}
-	GetAddrCommand    = CommandName{'g', 'e', 't', 'a', 'd', 'd', 'r'}
-	AddrCommand       = CommandName{'a', 'd', 'd', 'r'}
-	GetBlocksCommand  = CommandName{'g', 'e', 't', 'b', 'l', 'o', 'c', 'k', 's'}
-	InvCommand        = CommandName{'i', 'n', 'v'}
-	GetDataCommand    = CommandName{'g', 'e', 't', 'd', 'a', 't', 'a'}
-	BlockCommand      = CommandName{'b', 'l', 'o', 'c', 'k'}
-	TxCommand         = CommandName{'t', 'x'}
-	PingCommand       = CommandName{'p', 'i', 'n', 'g'}
-	PongCommand       = CommandName{'p', 'o', 'n', 'g'}
+	VersionCommand      = CommandName{'v', 'e', 'r', 's', 'i', 'o', 'n'}
+	VerackCommand       = CommandName{'v', 'e', 'r', 'a', 'c', 'k'}
+	WtxidRelayCommand   = CommandName{'w', 't', 'x', 'i', 'd', 'r', 'e', 'l', 'a', 'y'}
+	SendAddrV2Command   = CommandName{'s', 'e', 'n', 'd', 'a', 'd', 'd', 'r', 'v', '2'}
+	SendHeadersCommand  = CommandName{'s', 'e', 'n', 'd', 'h', 'e', 'a', 'd', 'e', 'r', 's'}
+	GetAddrCommand      = CommandName{'g', 'e', 't', 'a', 'd', 'd', 'r'}
+	AddrCommand         = CommandName{'a', 'd', 'd', 'r'}
+	AddrV2Command       = CommandName{'a', 'd', 'd', 'r', 'v', '2'}
+	GetBlocksCommand    = CommandName{'g', 'e', 't', 'b', 'l', 'o', 'c', 'k', 's'}
+	GetHeadersCommand   = CommandName{'g', 'e', 't', 'h', 'e', 'a', 'd', 'e', 'r', 's'}
+	HeadersCommand      = CommandName{'h', 'e', 'a', 'd', 'e', 'r', 's'}
+	InvCommand          = CommandName{'i', 'n', 'v'}
+	GetDataCommand      = CommandName{'g', 'e', 't', 'd', 'a', 't', 'a'}
+	NotFoundCommand     = CommandName{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd'}
+	BlockCommand        = CommandName{'b', 'l', 'o', 'c', 'k'}
+	TxCommand           = CommandName{'t', 'x'}
+	PingCommand         = CommandName{'p', 'i', 'n', 'g'}
+	PongCommand         = CommandName{'p', 'o', 'n', 'g'}
+	MempoolCommand      = CommandName{'m', 'e', 'm', 'p', 'o', 'o', 'l'}
+	FeeFilterCommand    = CommandName{'f', 'e', 'e', 'f', 'i', 'l', 't', 'e', 'r'}
+	FilterLoadCommand   = CommandName{'f', 'i', 'l', 't', 'e', 'r', 'l', 'o', 'a', 'd'}
+	FilterAddCommand    = CommandName{'f', 'i', 'l', 't', 'e', 'r', 'a', 'd', 'd'}
+	FilterClearCommand  = CommandName{'f', 'i', 'l', 't', 'e', 'r', 'c', 'l', 'e', 'a', 'r'}
+	GetCFiltersCommand  = CommandName{'g', 'e', 't', 'c', 'f', 'i', 'l', 't', 'e', 'r', 's'}
+	CFilterCommand      = CommandName{'c', 'f', 'i', 'l', 't', 'e', 'r'}
+	GetCFHeadersCommand = CommandName{'g', 'e', 't', 'c', 'f', 'h', 'e', 'a', 'd', 'e', 'r', 's'}
+	CFHeadersCommand    = CommandName{'c', 'f', 'h', 'e', 'a', 'd', 'e', 'r', 's'}
+	GetCFCheckptCommand = CommandName{'g', 'e', 't', 'c', 'f', 'c', 'h', 'e', 'c', 'k', 'p', 't'}
+	CFCheckptCommand    = CommandName{'c', 'f', 'c', 'h', 'e', 'c', 'k', 'p', 't'}
+	SendTxRcnclCommand  = CommandName{'s', 'e', 'n', 'd', 't', 'x', 'r', 'c', 'n', 'c', 'l'}
+	ReqReconCommand     = CommandName{'r', 'e', 'q', 'r', 'e', 'c', 'o', 'n'}
+	SketchCommand       = CommandName{'s', 'k', 'e', 't', 'c', 'h'}
+	ReconcilDiffCommand = CommandName{'r', 'e', 'c', 'o', 'n', 'c', 'i', 'l', 'd', 'i', 'f', 'f'}
 )
 
 type CommandName [commandNameLength]byte
 
+// Returns the command name without its NUL padding
+func (c CommandName) String() string {
+	return string(bytes.TrimRight(c[:], "\x00"))
+}
+
 type Payload interface {
 	CommandName() CommandName
 	Encode() ([]byte, error)
@@ -128,8 +166,15 @@ func DecodeMessage(r io.Reader) (*Message, error) {
 			return nil, ErrInvalidPayloadLength
 		}
 		payload = &SendAddrV2Payload{}
+	case SendHeadersCommand:
+		if len(encodedPayload) != 0 {
+			return nil, ErrInvalidPayloadLength
+		}
+		payload = &SendHeadersPayload{}
 	case AddrCommand:
 		payload, err = decodeAddrPayload(bytes.NewReader(encodedPayload))
+	case AddrV2Command:
+		payload, err = decodeAddrV2Payload(bytes.NewReader(encodedPayload))
 	case GetAddrCommand:
 		if len(encodedPayload) != 0 {
 			return nil, ErrInvalidPayloadLength
@@ -137,20 +182,62 @@ func DecodeMessage(r io.Reader) (*Message, error) {
 		payload = &GetAddrPayload{}
 	case GetBlocksCommand:
 		payload, err = decodeGetBlocksPayload(bytes.NewReader(encodedPayload))
+	case GetHeadersCommand:
+		payload, err = decodeGetHeadersPayload(bytes.NewReader(encodedPayload))
+	case HeadersCommand:
+		payload, err = decodeHeadersPayload(bytes.NewReader(encodedPayload))
 	case InvCommand:
 		payload, err = decodeInvPayload(bytes.NewReader(encodedPayload))
 	case GetDataCommand:
 		payload, err = decodeGetDataPayload(bytes.NewReader(encodedPayload))
+	case NotFoundCommand:
+		payload, err = decodeNotFoundPayload(bytes.NewReader(encodedPayload))
 	case TxCommand:
-		payload, err = decodeTxPayload(bytes.NewReader(encodedPayload))
+		payload, err = DecodeTxPayload(bytes.NewReader(encodedPayload))
 	case BlockCommand:
 		payload, err = DecodeBlockPayload(bytes.NewReader(encodedPayload))
 	case PingCommand:
 		payload, err = decodePingPayload(bytes.NewReader(encodedPayload))
 	case PongCommand:
 		payload, err = decodePongPayload(bytes.NewReader(encodedPayload))
+	case MempoolCommand:
+		if len(encodedPayload) != 0 {
+			return nil, ErrInvalidPayloadLength
+		}
+		payload = &MempoolPayload{}
+	case FeeFilterCommand:
+		payload, err = decodeFeeFilterPayload(bytes.NewReader(encodedPayload))
+	case FilterLoadCommand:
+		payload, err = decodeFilterLoadPayload(bytes.NewReader(encodedPayload))
+	case FilterAddCommand:
+		payload, err = decodeFilterAddPayload(bytes.NewReader(encodedPayload))
+	case FilterClearCommand:
+		if len(encodedPayload) != 0 {
+			return nil, ErrInvalidPayloadLength
+		}
+		payload = &FilterClearPayload{}
+	case GetCFiltersCommand:
+		payload, err = decodeGetCFiltersPayload(bytes.NewReader(encodedPayload))
+	case CFilterCommand:
+		payload, err = decodeCFilterPayload(bytes.NewReader(encodedPayload))
+	case GetCFHeadersCommand:
+		payload, err = decodeGetCFHeadersPayload(bytes.NewReader(encodedPayload))
+	case CFHeadersCommand:
+		payload, err = decodeCFHeadersPayload(bytes.NewReader(encodedPayload))
+	case GetCFCheckptCommand:
+		payload, err = decodeGetCFCheckptPayload(bytes.NewReader(encodedPayload))
+	case CFCheckptCommand:
+		payload, err = decodeCFCheckptPayload(bytes.NewReader(encodedPayload))
+	case SendTxRcnclCommand:
+		payload, err = decodeSendTxRcnclPayload(bytes.NewReader(encodedPayload))
+	case ReqReconCommand:
+		payload, err = decodeReqReconPayload(bytes.NewReader(encodedPayload))
+	case SketchCommand:
+		payload, err = decodeSketchPayload(bytes.NewReader(encodedPayload))
+	case ReconcilDiffCommand:
+		payload, err = decodeReconcilDiffPayload(bytes.NewReader(encodedPayload))
 	default:
-		return nil, &ErrUnknownCommandName{Command: header.Command}
+		return nil, &ErrUnknownCommandName{Command: header.Command, Length: header.Length}
 	}
 	if err != nil {
 		return nil, err
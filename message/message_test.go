@@ -3,6 +3,7 @@ package message_test
 import (
 	"bytes"
 	"encoding/hex"
+	"github.com/aang114/bitcoin-node/constants"
 	"github.com/aang114/bitcoin-node/message"
 	"github.com/stretchr/testify/assert"
 	"net"
@@ -436,6 +437,25 @@ func TestDecodeMessage(t *testing.T) {
 		assert.Equal(t, expected, decodedMsg)
 	})
 
+	t.Run("segwit tx payload should decode with one witness per input", func(t *testing.T) {
+		// the signed transaction of the native P2WPKH example of BIP 143 (https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki#native-p2wpkh),
+		// whose first input has an empty witness
+		encoded, err := hex.DecodeString("01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := message.DecodeTxPayload(bytes.NewReader(encoded))
+
+		assert.NoError(t, err)
+		assert.Len(t, tx.TransactionWitnesses, 2)
+		assert.Empty(t, tx.TransactionWitnesses[0].ComponentDataList)
+		assert.Len(t, tx.TransactionWitnesses[1].ComponentDataList, 2)
+		assert.EqualValues(t, 0x11, tx.LockTime)
+		reencoded, err := tx.Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, encoded, reencoded)
+	})
+
 	t.Run("block message should decode", func(t *testing.T) {
 		prevBlock, err := hex.DecodeString("B6FF0B1B1680A2862A30CA44D346D9E8910D334BEB48CA0C0000000000000000")
 		if err != nil {
@@ -461,3 +481,325 @@ func TestDecodeMessage(t *testing.T) {
 		assert.Equal(t, expected, decodedMsg)
 	})
 }
+
+func TestBlockPayload_CoinbaseHeight(t *testing.T) {
+	newBlock := func(version int32, signatureScript []byte) *message.BlockPayload {
+		txIn := message.NewTxIn(message.OutPoint{Index: 0xFFFFFFFF}, signatureScript, 0xFFFFFFFF)
+		return &message.BlockPayload{Version: version, Transactions: []message.TxPayload{{TransactionInputs: []message.TxIn{*txIn}}}}
+	}
+
+	bip34Height := constants.MainnetParams.Deployments.BIP34Height
+	t.Run("height should be read from a BIP34 coinbase", func(t *testing.T) {
+		// coinbase of block 840000 starts with the push 0x03 0x40 0xD1 0x0C
+		height, ok := newBlock(0x20000000, []byte{0x03, 0x40, 0xD1, 0x0C, 0xFF}).CoinbaseHeight(bip34Height)
+		assert.True(t, ok)
+		assert.Equal(t, int32(840000), height)
+	})
+
+	t.Run("heights before BIP34 activation should not be trusted", func(t *testing.T) {
+		_, ok := newBlock(2, []byte{0x03, 0x01, 0x00, 0x00}).CoinbaseHeight(bip34Height)
+		assert.False(t, ok)
+	})
+
+	t.Run("version 1 blocks should not have a height", func(t *testing.T) {
+		_, ok := newBlock(1, []byte{0x03, 0x40, 0xD1, 0x0C}).CoinbaseHeight(bip34Height)
+		assert.False(t, ok)
+	})
+}
+
+// genesisBlock returns the mainnet genesis block (https://en.bitcoin.it/wiki/Genesis_block)
+func genesisBlock(t *testing.T) *message.BlockPayload {
+	signatureScript, err := hex.DecodeString("04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pkScript, err := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	merkleRoot, err := hex.DecodeString("3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	coinbase := message.TxPayload{
+		Version:            1,
+		TransactionInputs:  []message.TxIn{*message.NewTxIn(message.OutPoint{Index: 0xFFFFFFFF}, signatureScript, 0xFFFFFFFF)},
+		TransactionOutputs: []message.TxOut{*message.NewTxOut(50_0000_0000, pkScript)},
+	}
+	return &message.BlockPayload{
+		Version:      1,
+		MerkleRoot:   message.Hash256(merkleRoot),
+		Timestamp:    1231006505,
+		Bits:         0x1d00ffff,
+		Nonce:        2083236893,
+		Transactions: []message.TxPayload{coinbase},
+	}
+}
+
+func TestBlockPayload_PreVerification(t *testing.T) {
+	t.Run("genesis block should pass", func(t *testing.T) {
+		block := genesisBlock(t)
+		hash, err := block.GetBlockHash()
+		assert.NoError(t, err)
+		assert.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", hash.String())
+		assert.NoError(t, block.CheckProofOfWork(hash))
+		assert.NoError(t, block.CheckMerkleRoot())
+	})
+
+	t.Run("a hash above the target should fail", func(t *testing.T) {
+		block := genesisBlock(t)
+		block.Nonce++
+		hash, err := block.GetBlockHash()
+		assert.NoError(t, err)
+		assert.ErrorIs(t, block.CheckProofOfWork(hash), message.ErrHighHash)
+	})
+
+	t.Run("targets above the limit or negative should fail", func(t *testing.T) {
+		block := genesisBlock(t)
+		for _, bits := range []uint32{0x1d01ffff, 0x1d80ffff, 0x00000000} {
+			block.Bits = bits
+			assert.ErrorIs(t, block.CheckProofOfWork(message.Hash256{}), message.ErrInvalidTarget)
+		}
+	})
+
+	t.Run("a tampered transaction should fail the merkle root check", func(t *testing.T) {
+		block := genesisBlock(t)
+		block.Transactions[0].TransactionOutputs[0].Value++
+		assert.ErrorIs(t, block.CheckMerkleRoot(), message.ErrBadMerkleRoot)
+	})
+}
+
+func TestCompactToTarget(t *testing.T) {
+	target, err := message.CompactToTarget(0x1d00ffff)
+	assert.NoError(t, err)
+	assert.Equal(t, "ffff0000000000000000000000000000000000000000000000000000", target.Text(16))
+
+	target, err = message.CompactToTarget(0x01123456)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0x12), target.Int64())
+}
+
+func TestDifficulty(t *testing.T) {
+	difficulty, err := message.Difficulty(0x1d00ffff)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, difficulty)
+
+	// https://en.bitcoin.it/wiki/Difficulty#How_is_difficulty_calculated.3F_What_is_the_difference_between_bdiff_and_pdiff.3F
+	difficulty, err = message.Difficulty(0x1b0404cb)
+	assert.NoError(t, err)
+	assert.InDelta(t, 16307.420938523983, difficulty, 1e-9)
+}
+
+func TestParseServices(t *testing.T) {
+	services, err := message.ParseServices("NODE_NETWORK|NODE_WITNESS")
+	assert.NoError(t, err)
+	assert.Equal(t, message.NodeNetwork|message.NodeWitness, services)
+	assert.True(t, services.Has(message.NodeWitness))
+	assert.False(t, services.Has(message.NodeWitness|message.NodeNetworkLimited))
+
+	services, err = message.ParseServices("0x409")
+	assert.NoError(t, err)
+	assert.Equal(t, message.NodeNetwork|message.NodeWitness|message.NodeNetworkLimited, services)
+
+	_, err = message.ParseServices("NODE_NETWORK|NODE_TELEPATHY")
+	assert.ErrorIs(t, err, message.ErrUnknownService)
+}
+
+func TestServices_Names(t *testing.T) {
+	assert.Equal(t, []string{}, message.Unnamed.Names())
+	services := message.NodeNetwork | message.NodeWitness | message.NodeNetworkLimited | 1<<27
+	assert.Equal(t, []string{"NETWORK", "WITNESS", "NETWORK_LIMITED", "UNKNOWN[2^27]"}, services.Names())
+}
+
+func TestDecodeMessage_TxRelayMessages(t *testing.T) {
+	mempoolMsg, err := message.NewMempoolMessage()
+	assert.NoError(t, err)
+	feeFilterMsg, err := message.NewFeeFilterMessage(1000)
+	assert.NoError(t, err)
+	filterLoadMsg, err := message.NewFilterLoadMessage([]byte{0x61, 0x4e, 0x9b}, 5, 0, message.BloomUpdateAll)
+	assert.NoError(t, err)
+	filterAddMsg, err := message.NewFilterAddMessage([]byte{0x99, 0x10, 0x8a})
+	assert.NoError(t, err)
+	filterClearMsg, err := message.NewFilterClearMessage()
+	assert.NoError(t, err)
+	notFoundMsg, err := message.NewNotFoundMessage([]message.Inventory{{Type: message.MsgWtx, Hash: message.Hash256{0x01}}})
+	assert.NoError(t, err)
+
+	for _, msg := range []*message.Message{mempoolMsg, feeFilterMsg, filterLoadMsg, filterAddMsg, filterClearMsg, notFoundMsg} {
+		t.Run(msg.Header.Command.String()+" message should decode", func(t *testing.T) {
+			encoded, err := msg.Encode()
+			assert.NoError(t, err)
+			decodedMsg, err := message.DecodeMessage(bytes.NewReader(encoded))
+			assert.NoError(t, err)
+			assert.Equal(t, msg, decodedMsg)
+		})
+	}
+
+	t.Run("filterload payload should encode like bitcoind", func(t *testing.T) {
+		// serialized filter of bitcoind's bloom_create_insert_serialize test (https://github.com/bitcoin/bitcoin/blob/v27.0/src/test/bloom_tests.cpp)
+		expected, err := hex.DecodeString("03614e9b050000000000000001")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		encoded, err := filterLoadMsg.Payload.Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, encoded)
+	})
+
+	t.Run("oversized bloom filters should not decode", func(t *testing.T) {
+		oversizedMsg, err := message.NewFilterLoadMessage(make([]byte, message.MaxBloomFilterSize+1), 5, 0, message.BloomUpdateNone)
+		assert.NoError(t, err)
+		encoded, err := oversizedMsg.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.ErrorIs(t, err, message.ErrMaxLengthExceeded)
+	})
+
+	t.Run("negative fee rates should not decode", func(t *testing.T) {
+		negativeMsg, err := message.NewFeeFilterMessage(-1)
+		assert.NoError(t, err)
+		encoded, err := negativeMsg.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.ErrorIs(t, err, message.ErrNegativeFeeRate)
+	})
+}
+
+func TestDecodeMessage_AddrV2(t *testing.T) {
+	addresses := []message.AddressV2{
+		{Timestamp: 1292899810, Services: message.NodeNetwork | message.NodeWitness, NetworkID: message.NetworkIDIPv4, Addr: []byte{10, 0, 0, 1}, Port: 8333},
+		{Timestamp: 1292899810, Services: message.NodeNetwork, NetworkID: message.NetworkIDTorV3, Addr: make([]byte, 32), Port: 8333},
+	}
+	expected, err := message.NewAddrV2Message(addresses)
+	assert.NoError(t, err)
+
+	t.Run("addrv2 message should decode", func(t *testing.T) {
+		encoded, err := expected.Encode()
+		assert.NoError(t, err)
+		decodedMsg, err := message.DecodeMessage(bytes.NewReader(encoded))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, decodedMsg)
+	})
+
+	t.Run("only IPv4 and IPv6 addresses should convert to addr addresses", func(t *testing.T) {
+		address, ok := addresses[0].Address()
+		assert.True(t, ok)
+		assert.Equal(t, *message.NewAddress(1292899810, *message.NewNetworkAddress(message.NodeNetwork|message.NodeWitness, net.IPv4(10, 0, 0, 1), 8333)), address)
+		_, ok = addresses[1].Address()
+		assert.False(t, ok)
+	})
+
+	t.Run("addresses with the wrong length for their network should not decode", func(t *testing.T) {
+		invalid, err := message.NewAddrV2Message([]message.AddressV2{{NetworkID: message.NetworkIDIPv4, Addr: make([]byte, 16), Port: 8333}})
+		assert.NoError(t, err)
+		encoded, err := invalid.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeMessage_HeadersFirstSync(t *testing.T) {
+	genesisHeader := *genesisBlock(t)
+	genesisHeader.Transactions = nil
+	genesisHash, err := genesisHeader.GetBlockHash()
+	assert.NoError(t, err)
+	getHeadersMsg, err := message.NewGetHeadersMessage(70015, []message.Hash256{genesisHash}, message.Hash256{})
+	assert.NoError(t, err)
+	headersMsg, err := message.NewHeadersMessage([]message.BlockPayload{genesisHeader, genesisHeader})
+	assert.NoError(t, err)
+
+	for _, msg := range []*message.Message{getHeadersMsg, headersMsg} {
+		t.Run(msg.Header.Command.String()+" message should decode", func(t *testing.T) {
+			encoded, err := msg.Encode()
+			assert.NoError(t, err)
+			decodedMsg, err := message.DecodeMessage(bytes.NewReader(encoded))
+			assert.NoError(t, err)
+			assert.Equal(t, msg, decodedMsg)
+		})
+	}
+
+	t.Run("headers should be encoded without their transactions", func(t *testing.T) {
+		headersMsg, err := message.NewHeadersMessage([]message.BlockPayload{*genesisBlock(t)})
+		assert.NoError(t, err)
+		encoded, err := headersMsg.Payload.Encode()
+		assert.NoError(t, err)
+		// count, 80 bytes of header and a transaction count of zero
+		assert.Len(t, encoded, 1+80+1)
+		assert.Equal(t, byte(0), encoded[len(encoded)-1])
+	})
+
+	t.Run("more than 2000 headers should not decode", func(t *testing.T) {
+		tooManyMsg, err := message.NewHeadersMessage(make([]message.BlockPayload, message.MaxHeadersResults+1))
+		assert.NoError(t, err)
+		encoded, err := tooManyMsg.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.ErrorIs(t, err, message.ErrMaxCountExceeded)
+	})
+}
+
+func TestDecodeMessage_CompactFilterMessages(t *testing.T) {
+	stopHash := message.Hash256{0x01, 0x02}
+	getCFiltersMsg, err := message.NewGetCFiltersMessage(message.BasicFilterType, 1, stopHash)
+	assert.NoError(t, err)
+	cfilterMsg, err := message.NewCFilterMessage(message.BasicFilterType, stopHash, []byte{0x01, 0x9d, 0xfc, 0xa8})
+	assert.NoError(t, err)
+	getCFHeadersMsg, err := message.NewGetCFHeadersMessage(message.BasicFilterType, 1, stopHash)
+	assert.NoError(t, err)
+	cfheadersMsg, err := message.NewCFHeadersMessage(message.BasicFilterType, stopHash, message.Hash256{0x03}, []message.Hash256{{0x04}, {0x05}})
+	assert.NoError(t, err)
+	getCFCheckptMsg, err := message.NewGetCFCheckptMessage(message.BasicFilterType, stopHash)
+	assert.NoError(t, err)
+	cfcheckptMsg, err := message.NewCFCheckptMessage(message.BasicFilterType, stopHash, []message.Hash256{{0x06}})
+	assert.NoError(t, err)
+
+	for _, msg := range []*message.Message{getCFiltersMsg, cfilterMsg, getCFHeadersMsg, cfheadersMsg, getCFCheckptMsg, cfcheckptMsg} {
+		t.Run(msg.Header.Command.String()+" message should decode", func(t *testing.T) {
+			encoded, err := msg.Encode()
+			assert.NoError(t, err)
+			decodedMsg, err := message.DecodeMessage(bytes.NewReader(encoded))
+			assert.NoError(t, err)
+			assert.Equal(t, msg, decodedMsg)
+		})
+	}
+
+	t.Run("more than 2000 filter hashes should not decode", func(t *testing.T) {
+		tooManyMsg, err := message.NewCFHeadersMessage(message.BasicFilterType, stopHash, message.Hash256{}, make([]message.Hash256, message.MaxGetCFHeadersSize+1))
+		assert.NoError(t, err)
+		encoded, err := tooManyMsg.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.ErrorIs(t, err, message.ErrMaxCountExceeded)
+	})
+}
+
+func TestDecodeMessage_TxReconciliationMessages(t *testing.T) {
+	sendTxRcnclMsg, err := message.NewSendTxRcnclMessage(message.TxReconciliationVersion, 0x0123456789abcdef)
+	assert.NoError(t, err)
+	reqReconMsg, err := message.NewReqReconMessage(100, message.QPrecision/4)
+	assert.NoError(t, err)
+	sketchMsg, err := message.NewSketchMessage([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08})
+	assert.NoError(t, err)
+	reconcilDiffMsg, err := message.NewReconcilDiffMessage(true, []uint32{1, 0xFFFFFFFF})
+	assert.NoError(t, err)
+
+	for _, msg := range []*message.Message{sendTxRcnclMsg, reqReconMsg, sketchMsg, reconcilDiffMsg} {
+		t.Run(msg.Header.Command.String()+" message should decode", func(t *testing.T) {
+			encoded, err := msg.Encode()
+			assert.NoError(t, err)
+			decodedMsg, err := message.DecodeMessage(bytes.NewReader(encoded))
+			assert.NoError(t, err)
+			assert.Equal(t, msg, decodedMsg)
+		})
+	}
+
+	t.Run("sketches above the maximum capacity should not decode", func(t *testing.T) {
+		oversizedMsg, err := message.NewSketchMessage(make([]byte, 4*message.MaxSketchCapacity+4))
+		assert.NoError(t, err)
+		encoded, err := oversizedMsg.Encode()
+		assert.NoError(t, err)
+		_, err = message.DecodeMessage(bytes.NewReader(encoded))
+		assert.ErrorIs(t, err, message.ErrMaxLengthExceeded)
+	})
+}
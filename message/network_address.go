@@ -3,8 +3,11 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 )
 
 // Services supported by a node (encoded as a bitfield) (https://en.bitcoin.it/wiki/Protocol_documentation#version)
@@ -29,6 +32,58 @@ const (
 	NodeNetworkLimited Services = 1024
 )
 
+// names of the service bits as used by Bitcoin Core
+var serviceNames = map[string]Services{
+	"NODE_NETWORK":         NodeNetwork,
+	"NODE_GETUTXO":         NodeGetUtxo,
+	"NODE_BLOOM":           NodeBloom,
+	"NODE_WITNESS":         NodeWitness,
+	"NODE_XTHIN":           NodeXThin,
+	"NODE_COMPACT_FILTERS": NodeCompactFilters,
+	"NODE_NETWORK_LIMITED": NodeNetworkLimited,
+}
+
+// Has reports whether all of required are set in s
+func (s Services) Has(required Services) bool {
+	return s&required == required
+}
+
+// Names returns the names of the service bits set in s from the lowest bit, without the NODE_ prefix, as Bitcoin Core lists them in
+// getpeerinfo (https://github.com/bitcoin/bitcoin/blob/v27.0/src/protocol.cpp#L197-L226)
+func (s Services) Names() []string {
+	names := []string{}
+	for bit := 0; bit < 64; bit++ {
+		service := Services(1) << bit
+		if !s.Has(service) {
+			continue
+		}
+		name := fmt.Sprintf("UNKNOWN[2^%d]", bit)
+		for known, knownService := range serviceNames {
+			if knownService == service {
+				name = strings.TrimPrefix(known, "NODE_")
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ParseServices parses service bits given as names joined by "|" (e.g. "NODE_NETWORK|NODE_WITNESS") or as a number
+func ParseServices(str string) (Services, error) {
+	if n, err := strconv.ParseUint(str, 0, 64); err == nil {
+		return Services(n), nil
+	}
+	var services Services
+	for _, name := range strings.Split(str, "|") {
+		service, ok := serviceNames[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("%w %q", ErrUnknownService, name)
+		}
+		services |= service
+	}
+	return services, nil
+}
+
 // Network address of a node (https://en.bitcoin.it/wiki/Protocol_documentation#version)
 type NetworkAddress struct {
 	// Services supported by the node encoded as a bitfield
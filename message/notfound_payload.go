@@ -0,0 +1,30 @@
+package message
+
+import "io"
+
+// NotFoundPayload lists the inventories of a getdata message that the sender could not send, so that they can be asked for from other peers
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+type NotFoundPayload struct {
+	InventoryList []Inventory
+}
+
+func NewNotFoundMessage(inventoryList []Inventory) (*Message, error) {
+	return newMessage(&NotFoundPayload{InventoryList: inventoryList})
+}
+
+func (p *NotFoundPayload) CommandName() CommandName {
+	return NotFoundCommand
+}
+
+// Encode encodes the payload the same way as an inv payload
+func (p *NotFoundPayload) Encode() ([]byte, error) {
+	return newInvPayload(p.InventoryList).Encode()
+}
+
+func decodeNotFoundPayload(r io.Reader) (*NotFoundPayload, error) {
+	invPayload, err := decodeInvPayload(r)
+	if err != nil {
+		return nil, err
+	}
+	return &NotFoundPayload{InventoryList: invPayload.InventoryList}, nil
+}
@@ -0,0 +1,144 @@
+package message
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"math/big"
+	"slices"
+)
+
+var (
+	ErrInvalidTarget  = errors.New("block target is negative, zero, overflows or is above the proof of work limit")
+	ErrHighHash       = errors.New("block hash does not meet its target")
+	ErrBadMerkleRoot  = errors.New("merkle root does not match the block's transactions")
+	ErrNoTransactions = errors.New("block has no transactions")
+)
+
+// CompactToTarget expands the compact representation of a target used in the bits field of a block header (https://developer.bitcoin.org/reference/block_chain.html#target-nbits)
+func CompactToTarget(bits uint32) (*big.Int, error) {
+	exponent := bits >> 24
+	mantissa := bits & 0x007FFFFF
+	if bits&0x00800000 != 0 && mantissa != 0 {
+		return nil, ErrInvalidTarget
+	}
+	target := new(big.Int)
+	if exponent <= 3 {
+		target.SetUint64(uint64(mantissa >> (8 * (3 - exponent))))
+	} else {
+		target.SetUint64(uint64(mantissa))
+		target.Lsh(target, uint(8*(exponent-3)))
+	}
+	if target.BitLen() > 256 {
+		return nil, ErrInvalidTarget
+	}
+	return target, nil
+}
+
+var powLimit, _ = CompactToTarget(constants.PowLimitBits)
+
+// Difficulty returns how many times harder it is to meet the target bits than the proof of work limit, the target of the genesis block
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L82-L102)
+func Difficulty(bits uint32) (float64, error) {
+	target, err := CompactToTarget(bits)
+	if err != nil {
+		return 0, err
+	}
+	if target.Sign() <= 0 {
+		return 0, ErrInvalidTarget
+	}
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(powLimit), new(big.Float).SetInt(target)).Float64()
+	return difficulty, nil
+}
+
+// CheckProofOfWork checks that the block's target is valid and that hash, the block's hash, does not exceed it.
+// It does not check that the target is the one required by the difficulty adjustment.
+func (b *BlockPayload) CheckProofOfWork(hash Hash256) error {
+	return b.CheckProofOfWorkWithLimit(hash, constants.PowLimitBits)
+}
+
+// CheckProofOfWorkWithLimit checks the proof of work of the block like CheckProofOfWork, on a network whose easiest target is powLimitBits in
+// compact form
+func (b *BlockPayload) CheckProofOfWorkWithLimit(hash Hash256, powLimitBits uint32) error {
+	limit, err := CompactToTarget(powLimitBits)
+	if err != nil {
+		return err
+	}
+	target, err := CompactToTarget(b.Bits)
+	if err != nil {
+		return err
+	}
+	if target.Sign() <= 0 || target.Cmp(limit) > 0 {
+		return ErrInvalidTarget
+	}
+	// hashes are little-endian
+	hashBytes := slices.Clone(hash[:])
+	slices.Reverse(hashBytes)
+	if new(big.Int).SetBytes(hashBytes).Cmp(target) > 0 {
+		return ErrHighHash
+	}
+	return nil
+}
+
+// GetTxId returns the transaction's identifier, the double SHA256 of its encoding without witnesses
+func (t *TxPayload) GetTxId() (Hash256, error) {
+	withoutWitnesses := *t
+	withoutWitnesses.TransactionWitnesses = nil
+	encoded, err := withoutWitnesses.Encode()
+	if err != nil {
+		return Hash256{}, err
+	}
+	hash := sha256.Sum256(encoded)
+	return sha256.Sum256(hash[:]), nil
+}
+
+// GetWtxId returns the transaction's witness identifier, the double SHA256 of its encoding with witnesses (BIP 141), which is its txid if it
+// has no witnesses
+func (t *TxPayload) GetWtxId() (Hash256, error) {
+	encoded, err := t.Encode()
+	if err != nil {
+		return Hash256{}, err
+	}
+	hash := sha256.Sum256(encoded)
+	return sha256.Sum256(hash[:]), nil
+}
+
+// ComputeMerkleRoot returns the root of the merkle tree of the block's transaction identifiers (https://developer.bitcoin.org/reference/block_chain.html#merkle-trees)
+func (b *BlockPayload) ComputeMerkleRoot() (Hash256, error) {
+	if len(b.Transactions) == 0 {
+		return Hash256{}, ErrNoTransactions
+	}
+	level := make([]Hash256, len(b.Transactions))
+	for i := range b.Transactions {
+		txId, err := b.Transactions[i].GetTxId()
+		if err != nil {
+			return Hash256{}, err
+		}
+		level[i] = txId
+	}
+	for len(level) > 1 {
+		// the last hash of a level with an odd number of hashes is paired with itself
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]Hash256, len(level)/2)
+		for i := range next {
+			hash := sha256.Sum256(append(level[2*i][:], level[2*i+1][:]...))
+			next[i] = sha256.Sum256(hash[:])
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+// CheckMerkleRoot checks that the block's merkle root commits to its transactions
+func (b *BlockPayload) CheckMerkleRoot() error {
+	merkleRoot, err := b.ComputeMerkleRoot()
+	if err != nil {
+		return err
+	}
+	if merkleRoot != b.MerkleRoot {
+		return ErrBadMerkleRoot
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package message
+
+type SendHeadersPayload struct{}
+
+func (s *SendHeadersPayload) CommandName() CommandName {
+	return SendHeadersCommand
+}
+
+func (s *SendHeadersPayload) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func newSendHeadersPayload() *SendHeadersPayload {
+	return &SendHeadersPayload{}
+}
+
+func NewSendHeadersMessage() (*Message, error) {
+	payload := newSendHeadersPayload()
+	return newMessage(payload)
+}
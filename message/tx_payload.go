@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 )
@@ -155,16 +154,8 @@ func (t *TxPayload) Encode() ([]byte, error) {
 			return nil, err
 		}
 	}
+	// the witnesses follow one another without a count, as there is one per input
 	if len(t.TransactionWitnesses) > 0 {
-		txWitnessesCount := VarInt(len(t.TransactionWitnesses))
-		encodedCount, err = txWitnessesCount.Encode()
-		if err != nil {
-			return nil, err
-		}
-		_, err = buffer.Write(encodedCount)
-		if err != nil {
-			return nil, err
-		}
 		for _, txWitness := range t.TransactionWitnesses {
 			encodedTxWitness, err := txWitness.Encode()
 			if err != nil {
@@ -184,7 +175,7 @@ func (t *TxPayload) Encode() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func decodeTxPayload(reader io.Reader) (*TxPayload, error) {
+func DecodeTxPayload(reader io.Reader) (*TxPayload, error) {
 	r := bufio.NewReader(reader)
 
 	t := TxPayload{}
@@ -230,12 +221,8 @@ func decodeTxPayload(reader io.Reader) (*TxPayload, error) {
 		t.TransactionOutputs[i] = *txOut
 	}
 	if flag {
-		txWitnessCount, err := DecodeVarInt(r)
-		if err != nil {
-			return nil, err
-		}
-		t.TransactionWitnesses = make([]TxWitness, txWitnessCount)
-		for i := range txWitnessCount {
+		t.TransactionWitnesses = make([]TxWitness, txInputCount)
+		for i := range txInputCount {
 			txWitness, err := decodeTxWitness(r)
 			if err != nil {
 				return nil, err
@@ -324,7 +311,7 @@ func decodeTxIn(r io.Reader) (*TxIn, error) {
 	}
 	//log.Printf("scriptLength is %d", scriptLength)
 	if scriptLength > maxScriptSize {
-		return nil, errors.New(fmt.Sprintf("signatureScript (length: %d) exceeded max length", scriptLength))
+		return nil, fmt.Errorf("signatureScript (length: %d) %w", scriptLength, ErrMaxLengthExceeded)
 	}
 	t.SignatureScript = make([]byte, scriptLength)
 	_, err = io.ReadFull(r, t.SignatureScript)
@@ -373,7 +360,7 @@ func decodeTxOut(r io.Reader) (*TxOut, error) {
 	}
 	//log.Printf("pkScriptLength is %d", pkScriptLength)
 	if pkScriptLength > maxScriptSize {
-		return nil, errors.New(fmt.Sprintf("pkScript (length %d) exceeded max length", pkScriptLength))
+		return nil, fmt.Errorf("pkScript (length: %d) %w", pkScriptLength, ErrMaxLengthExceeded)
 	}
 	t.PkScript = make([]byte, pkScriptLength)
 	_, err = io.ReadFull(r, t.PkScript)
@@ -0,0 +1,216 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Version of transaction reconciliation announced in sendtxrcncl messages (BIP 330)
+const TxReconciliationVersion = 1
+
+// Largest capacity of a sketch sent in a sketch message, and largest number of short ids asked for in a reconcildiff message
+// (https://github.com/bitcoin/bips/blob/master/bip-0330.mediawiki)
+const MaxSketchCapacity = 2 << 12
+
+// Size in bytes of an element of a sketch
+const sketchElementSize = 4
+
+// Announces, before the verack, that the sender supports transaction reconciliation (BIP 330)
+type SendTxRcnclPayload struct {
+	Version uint32
+	// Half of the key of the short ids of the transactions reconciled
+	Salt uint64
+}
+
+func (s *SendTxRcnclPayload) CommandName() CommandName {
+	return SendTxRcnclCommand
+}
+
+func (s *SendTxRcnclPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, s.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeSendTxRcnclPayload(r io.Reader) (*SendTxRcnclPayload, error) {
+	s := SendTxRcnclPayload{}
+	err := binary.Read(r, binary.LittleEndian, &s.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func NewSendTxRcnclMessage(version uint32, salt uint64) (*Message, error) {
+	payload := &SendTxRcnclPayload{Version: version, Salt: salt}
+	return newMessage(payload)
+}
+
+// Asks the peer for a sketch of the transactions it would announce to the sender, starting a reconciliation round (BIP 330)
+type ReqReconPayload struct {
+	// Number of transactions the sender would announce to the peer
+	SetSize uint16
+	// Coefficient estimating the difference between the sets of the peers, multiplied by QPrecision
+	Q uint16
+}
+
+// Precision of the coefficient of reqrecon messages
+const QPrecision = 1<<15 - 1
+
+func (r *ReqReconPayload) CommandName() CommandName {
+	return ReqReconCommand
+}
+
+func (r *ReqReconPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, r.SetSize)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, r.Q)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeReqReconPayload(r io.Reader) (*ReqReconPayload, error) {
+	p := ReqReconPayload{}
+	err := binary.Read(r, binary.LittleEndian, &p.SetSize)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &p.Q)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewReqReconMessage(setSize uint16, q uint16) (*Message, error) {
+	payload := &ReqReconPayload{SetSize: setSize, Q: q}
+	return newMessage(payload)
+}
+
+// Answers a reqrecon message with the sketch of the short ids of the transactions the sender would announce to the peer (BIP 330)
+type SketchPayload struct {
+	SketchData []byte
+}
+
+func (s *SketchPayload) CommandName() CommandName {
+	return SketchCommand
+}
+
+func (s *SketchPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	lengthEncoded, err := VarInt(len(s.SketchData)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(lengthEncoded)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(s.SketchData)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeSketchPayload(r io.Reader) (*SketchPayload, error) {
+	length, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > MaxSketchCapacity*sketchElementSize {
+		return nil, fmt.Errorf("sketch (length: %d) %w", length, ErrMaxLengthExceeded)
+	}
+	s := SketchPayload{SketchData: make([]byte, length)}
+	_, err = io.ReadFull(r, s.SketchData)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func NewSketchMessage(sketchData []byte) (*Message, error) {
+	payload := &SketchPayload{SketchData: sketchData}
+	return newMessage(payload)
+}
+
+// Ends a reconciliation round, asking the peer for the transactions whose short ids the sender is missing if the sketches could be decoded
+// (BIP 330)
+type ReconcilDiffPayload struct {
+	Success     bool
+	AskShortIds []uint32
+}
+
+func (r *ReconcilDiffPayload) CommandName() CommandName {
+	return ReconcilDiffCommand
+}
+
+func (r *ReconcilDiffPayload) Encode() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := binary.Write(buffer, binary.LittleEndian, r.Success)
+	if err != nil {
+		return nil, err
+	}
+	countEncoded, err := VarInt(len(r.AskShortIds)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = buffer.Write(countEncoded)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(buffer, binary.LittleEndian, r.AskShortIds)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeReconcilDiffPayload(r io.Reader) (*ReconcilDiffPayload, error) {
+	p := ReconcilDiffPayload{}
+	var success uint8
+	err := binary.Read(r, binary.LittleEndian, &success)
+	if err != nil {
+		return nil, err
+	}
+	if success > 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidSuccessFlag, success)
+	}
+	p.Success = success == 1
+	count, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > MaxSketchCapacity {
+		return nil, fmt.Errorf("%w of reconcildiff short ids (%d)", ErrMaxCountExceeded, count)
+	}
+	p.AskShortIds = make([]uint32, count)
+	err = binary.Read(r, binary.LittleEndian, p.AskShortIds)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func NewReconcilDiffMessage(success bool, askShortIds []uint32) (*Message, error) {
+	payload := &ReconcilDiffPayload{Success: success, AskShortIds: askShortIds}
+	return newMessage(payload)
+}
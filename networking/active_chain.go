@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/tracing"
+	"log"
+)
+
+// activateBestChain moves the tip of the active chain to the stored block with the most work. The transactions of the blocks that left the
+// active chain go back to the mempool and the ones of the blocks that joined it leave the mempool. A reorganization is logged and published.
+// The blocks connected to the chainstate are traced as children of the span identified by trace.
+func (n *Node) activateBestChain(trace tracing.SpanContext) error {
+	n.chainMu.Lock()
+	defer n.chainMu.Unlock()
+
+	change := n.blockIndex.ActivateBestChain()
+	err := n.loadBlockData(change.Disconnected)
+	if err != nil {
+		return err
+	}
+	err = n.loadBlockData(change.Connected)
+	if err != nil {
+		return err
+	}
+	returnedTxs := 0
+	for _, node := range change.Disconnected {
+		returnedTxs += n.mempool.addBlockTxs(node.Block)
+	}
+	for _, node := range change.Connected {
+		doubleSpends, err := n.mempool.removeBlockTxs(node.Block)
+		if err != nil {
+			return err
+		}
+		for _, d := range doubleSpends {
+			n.publishDoubleSpend(d, nil, node.Hash)
+		}
+	}
+	err = n.updateChainstate(change, trace)
+	if err != nil {
+		return err
+	}
+	if !change.IsReorg() {
+		return nil
+	}
+
+	oldTip, newTip := change.Disconnected[0], change.Connected[len(change.Connected)-1]
+	forkHeight := change.Connected[0].Height - 1
+	log.Printf("🔀 Reorganized the active chain from %s to %s at height %d: disconnected %d blocks, connected %d blocks, returned %d transactions to the mempool",
+		oldTip.Hash.String(), newTip.Hash.String(), forkHeight, len(change.Disconnected), len(change.Connected), returnedTxs)
+	reorg := events.Reorg{
+		OldTip:       oldTip.Hash.String(),
+		NewTip:       newTip.Hash.String(),
+		ForkHeight:   forkHeight,
+		Disconnected: make([]string, len(change.Disconnected)),
+		Connected:    make([]string, len(change.Connected)),
+	}
+	for i, node := range change.Disconnected {
+		reorg.Disconnected[i] = node.Hash.String()
+	}
+	for i, node := range change.Connected {
+		reorg.Connected[i] = node.Hash.String()
+	}
+	n.events.Publish(events.TopicReorg, reorg)
+	return nil
+}
+
+// loadBlockData reads the data of nodes from the block store if the block index does not keep it in memory
+func (n *Node) loadBlockData(nodes []blockchain.BlockNode) error {
+	for i := range nodes {
+		block, err := n.blockIndex.BlockData(nodes[i])
+		if err != nil {
+			return err
+		}
+		nodes[i].Block = block
+	}
+	return nil
+}
+
+// updateChainstate applies change to the unspent outputs of the active chain and to the transaction and block filter indexes, if any,
+// reading the data of the blocks change does not hold one at a time, and traces the blocks it connects as children of the span identified by
+// trace
+func (n *Node) updateChainstate(change blockchain.TipChange, trace tracing.SpanContext) error {
+	chainstate := n.chainstate.Load()
+	for _, node := range change.Disconnected {
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return err
+		}
+		err = chainstate.DisconnectBlock(node.Hash, node.Height, block)
+		if err != nil {
+			return err
+		}
+		if n.txIndex != nil {
+			err = n.txIndex.DisconnectBlock(node.Hash, block)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, node := range change.Connected {
+		// the blocks the chainstate started from and which are indexed are not read, e.g. when the chain is read again after a restart
+		connected := node.Height <= chainstate.BaseHeight()
+		indexed := n.txIndex == nil || n.txIndex.HasBlock(node.Hash)
+		filtered := n.filterIndex == nil || n.filterIndex.HasBlock(node.Hash)
+		if connected && indexed && filtered {
+			continue
+		}
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return err
+		}
+		if !connected {
+			span := tracing.Start(trace, "chain.connect_block", blockSpanAttributes(node.Hash, node.Height)...)
+			err = chainstate.ConnectBlock(node.Hash, node.Height, block)
+			span.SetError(err)
+			span.End()
+			if err != nil {
+				return err
+			}
+		}
+		if !indexed {
+			err = n.txIndex.ConnectBlock(node.Hash, block)
+			if err != nil {
+				return err
+			}
+		}
+		if !filtered {
+			err = n.indexBlockFilter(chainstate, node.Hash, block)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_ReorgsToBranchWithMoreWork(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	sub := node.Events().Subscribe(10, events.TopicReorg)
+	defer sub.Unsubscribe()
+	useTestCoins(node.mempool)
+	confirmedTx := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	unconfirmedTx := newTestTx(message.Hash256{0x02}, 1000, []byte{0x51})
+	_, err := node.mempool.Add(unconfirmedTx)
+	require.NoError(t, err)
+
+	chain, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 3, easyBits, 0)
+	chain[1].Transactions = []message.TxPayload{*confirmedTx}
+	for i := range chain {
+		require.NoError(t, node.addBlockToNode(&chain[i]))
+	}
+	// two blocks at the mainnet proof of work limit are worth more than two at regtest's
+	fork, forkHashes := createHeaders(t, hashes[0], 2, constants.PowLimitBits, 100)
+	fork[0].Transactions = []message.TxPayload{*unconfirmedTx}
+	require.NoError(t, node.addBlockToNode(&fork[1]))
+	require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+	require.NoError(t, node.addBlockToNode(&fork[0]))
+
+	require.Equal(t, forkHashes[1], node.blockIndex.Tip().Hash)
+	confirmedTxId, err := confirmedTx.GetTxId()
+	require.NoError(t, err)
+	_, ok := node.mempool.Get(confirmedTxId)
+	require.True(t, ok, "transaction of a disconnected block should be back in the mempool")
+	require.Equal(t, 1, node.mempool.Len())
+
+	select {
+	case event := <-sub.C:
+		reorg := event.Data.(events.Reorg)
+		require.Equal(t, hashes[2].String(), reorg.OldTip)
+		require.Equal(t, forkHashes[1].String(), reorg.NewTip)
+		require.Equal(t, int32(1), reorg.ForkHeight)
+		require.Equal(t, []string{hashes[2].String(), hashes[1].String()}, reorg.Disconnected)
+		require.Equal(t, []string{forkHashes[0].String(), forkHashes[1].String()}, reorg.Connected)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no reorg event was published")
+	}
+}
@@ -0,0 +1,62 @@
+package networking
+
+import (
+	"context"
+	"time"
+)
+
+// addrManager discovers addresses: it learns the addresses peers send without being asked for them, relays the new ones to other peers and
+// advertises our external address. The addresses themselves are kept in the node's AddrMan.
+type addrManager interface {
+	manager
+	// addrMsgs returns the channel peers pass their unsolicited addr messages to
+	addrMsgs() chan<- *addrPayloadWithSender
+}
+
+// addrHandler learns, relays and advertises addresses for the address manager, which Node does
+type addrHandler interface {
+	handleAddrMsg(msg *addrPayloadWithSender)
+	// relayAddrs relays the addresses learnt since it was last called
+	relayAddrs()
+	advertiseExternalAddr()
+	// addrIntervals returns how often addresses are relayed and our external address advertised
+	addrIntervals() (relay time.Duration, advertise time.Duration)
+}
+
+// addrGossip is the addrManager handling addresses in its own goroutine
+type addrGossip struct {
+	handler   addrHandler
+	addrMsgCh chan *addrPayloadWithSender
+}
+
+func newAddrGossip(handler addrHandler, bufferSize int) *addrGossip {
+	return &addrGossip{handler: handler, addrMsgCh: make(chan *addrPayloadWithSender, bufferSize)}
+}
+
+func (g *addrGossip) addrMsgs() chan<- *addrPayloadWithSender {
+	return g.addrMsgCh
+}
+
+// run learns the addresses of addr messages, and relays and advertises addresses at the intervals of the handler
+func (g *addrGossip) run(ctx context.Context, quitCh <-chan struct{}) {
+	relayInterval, advertiseInterval := g.handler.addrIntervals()
+	relayTicker := time.NewTicker(relayInterval)
+	defer relayTicker.Stop()
+	advertiseTicker := time.NewTicker(advertiseInterval)
+	defer advertiseTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-quitCh:
+			return
+		case addrMsg := <-g.addrMsgCh:
+			g.handler.handleAddrMsg(addrMsg)
+		case <-relayTicker.C:
+			g.handler.relayAddrs()
+		case <-advertiseTicker.C:
+			g.handler.advertiseExternalAddr()
+		}
+	}
+}
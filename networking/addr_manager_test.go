@@ -0,0 +1,64 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// fakeAddrHandler records the addr messages it is passed and the relays and advertisements it is asked for
+type fakeAddrHandler struct {
+	learnt            chan *addrPayloadWithSender
+	relayed           chan struct{}
+	advertised        chan struct{}
+	advertiseInterval time.Duration
+}
+
+func (f *fakeAddrHandler) handleAddrMsg(msg *addrPayloadWithSender) {
+	f.learnt <- msg
+}
+
+func (f *fakeAddrHandler) relayAddrs() {
+	select {
+	case f.relayed <- struct{}{}:
+	default:
+	}
+}
+
+func (f *fakeAddrHandler) advertiseExternalAddr() {
+	select {
+	case f.advertised <- struct{}{}:
+	default:
+	}
+}
+
+func (f *fakeAddrHandler) addrIntervals() (time.Duration, time.Duration) {
+	return 10 * time.Millisecond, f.advertiseInterval
+}
+
+func TestAddrGossip_Run(t *testing.T) {
+	handler := &fakeAddrHandler{
+		learnt:            make(chan *addrPayloadWithSender, 1),
+		relayed:           make(chan struct{}, 1),
+		advertised:        make(chan struct{}, 1),
+		advertiseInterval: time.Hour,
+	}
+	gossip := newAddrGossip(handler, 1)
+	quitCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gossip.run(context.Background(), quitCh)
+	}()
+
+	msg := &addrPayloadWithSender{AddrPayload: &message.AddrPayload{}}
+	gossip.addrMsgs() <- msg
+	require.Equal(t, msg, <-handler.learnt)
+	<-handler.relayed
+	require.Empty(t, handler.advertised)
+
+	close(quitCh)
+	<-done
+}
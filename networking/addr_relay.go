@@ -0,0 +1,149 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// addrPayloadWithSender holds the addresses of an addr or addrv2 message a peer sent without being asked for addresses
+type addrPayloadWithSender struct {
+	AddrPayload *message.AddrPayload
+	Sender      *Peer
+}
+
+func tcpAddressOf(address message.Address) TCPAddress {
+	return TCPAddress{IpAddress: [16]byte(address.NetworkAddress.IpAddress.To16()), Port: address.NetworkAddress.Port}
+}
+
+// knownAddrs remembers the addresses a peer sent us or was sent, so that they are not relayed to it again. Once capacity addresses were added,
+// the oldest half is forgotten.
+type knownAddrs struct {
+	mu       sync.Mutex
+	capacity int
+	current  map[TCPAddress]struct{}
+	previous map[TCPAddress]struct{}
+}
+
+func newKnownAddrs(capacity int) *knownAddrs {
+	return &knownAddrs{
+		capacity: capacity,
+		current:  make(map[TCPAddress]struct{}),
+		previous: make(map[TCPAddress]struct{}),
+	}
+}
+
+func (k *knownAddrs) add(addresses []message.Address) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, address := range addresses {
+		if len(k.current) >= k.capacity/2 {
+			k.previous = k.current
+			k.current = make(map[TCPAddress]struct{})
+		}
+		k.current[tcpAddressOf(address)] = struct{}{}
+	}
+}
+
+func (k *knownAddrs) contains(addr TCPAddress) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.current[addr]; ok {
+		return true
+	}
+	_, ok := k.previous[addr]
+	return ok
+}
+
+type queuedAddr struct {
+	address message.Address
+	// peer the address was learnt from, which it is not relayed back to
+	source *Peer
+}
+
+// addrRelayQueue holds the newly learnt addresses until they are relayed, dropping new ones while it is full
+type addrRelayQueue struct {
+	mu    sync.Mutex
+	addrs []queuedAddr
+}
+
+func (q *addrRelayQueue) push(address message.Address, source *Peer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.addrs) >= constants.MaxAddrRelayQueue {
+		return
+	}
+	q.addrs = append(q.addrs, queuedAddr{address: address, source: source})
+}
+
+func (q *addrRelayQueue) drain() []queuedAddr {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	addrs := q.addrs
+	q.addrs = nil
+	return addrs
+}
+
+// learnAddrs adds the addresses source sent us to the address manager and to the addresses to connect to, queueing the ones we did not know
+// to be relayed to other peers
+func (n *Node) learnAddrs(addresses []message.Address, source *Peer) {
+	now := time.Now()
+	for _, address := range addresses {
+		address.Timestamp = sanitizeAddrTimestamp(address.Timestamp, now)
+		if n.addrMan.Add(address) {
+			n.addrRelay.push(address, source)
+		}
+		if !address.NetworkAddress.Services.Has(n.requiredServices) {
+			continue
+		}
+		n.addUnconnectedAddrToNode(tcpAddressOf(address))
+	}
+}
+
+// sanitizeAddrTimestamp treats addresses claiming to have been seen in the future or before 1973 as seen five days ago, so that peers cannot
+// make their addresses look fresher than others (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L3896-L3897)
+func sanitizeAddrTimestamp(timestamp uint32, now time.Time) uint32 {
+	if timestamp <= 100000000 || int64(timestamp) > now.Add(10*time.Minute).Unix() {
+		return uint32(now.Add(-5 * 24 * time.Hour).Unix())
+	}
+	return timestamp
+}
+
+func (n *Node) handleAddrMsg(msg *addrPayloadWithSender) {
+	log.Printf("Unsolicited addr message from peer %s has %d addresses", msg.Sender.conn.RemoteAddr(), len(msg.AddrPayload.AddressList))
+	n.learnAddrs(msg.AddrPayload.AddressList, msg.Sender)
+}
+
+// relayAddrs sends each queued address to constants.AddrRelayPeers random peers, skipping the peer it was learnt from and the peers which
+// already know it. The addresses queued during the initial block download are dropped.
+func (n *Node) relayAddrs() {
+	queued := n.addrRelay.drain()
+	if len(queued) == 0 || n.IsInitialBlockDownload() {
+		return
+	}
+	peers := n.peers.Keys()
+	batches := make(map[*Peer][]message.Address)
+	for _, q := range queued {
+		rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		relayedTo := 0
+		for _, peer := range peers {
+			if relayedTo == constants.AddrRelayPeers {
+				break
+			}
+			if peer == q.source || peer.knownAddrs.contains(tcpAddressOf(q.address)) {
+				continue
+			}
+			batches[peer] = append(batches[peer], q.address)
+			relayedTo++
+		}
+	}
+	for peer, addresses := range batches {
+		err := peer.sendAddrMsg(addresses)
+		if err != nil {
+			log.Printf("⚠️ Could not relay %d addresses to peer %s due to error: %s", len(addresses), peer.conn.RemoteAddr(), err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKnownAddrs_ForgetsOldestHalfWhenFull(t *testing.T) {
+	known := newKnownAddrs(4)
+	addresses := make([]message.Address, 5)
+	for i := range addresses {
+		addresses[i] = *message.NewAddress(0, *message.NewNetworkAddress(message.NodeNetwork, net.IPv4(10, 0, 0, byte(i)), 8333))
+	}
+	known.add(addresses)
+
+	require.False(t, known.contains(tcpAddressOf(addresses[0])))
+	require.False(t, known.contains(tcpAddressOf(addresses[1])))
+	for _, address := range addresses[2:] {
+		require.True(t, known.contains(tcpAddressOf(address)))
+	}
+}
+
+func TestSanitizeAddrTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	fiveDaysAgo := uint32(now.Add(-5 * 24 * time.Hour).Unix())
+
+	require.Equal(t, uint32(now.Unix()-60), sanitizeAddrTimestamp(uint32(now.Unix()-60), now))
+	require.Equal(t, fiveDaysAgo, sanitizeAddrTimestamp(uint32(now.Add(time.Hour).Unix()), now))
+	require.Equal(t, fiveDaysAgo, sanitizeAddrTimestamp(0, now))
+}
@@ -0,0 +1,242 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KnownAddress is an address of a node learnt from an addr message
+type KnownAddress struct {
+	Addr     TCPAddress
+	Services message.Services
+	// when the node was last seen according to the peer that sent the address
+	LastSeen time.Time
+}
+
+// AddrMan is the node's database of peer addresses, which is kept across restarts
+type AddrMan struct {
+	addrs *SafeMap[TCPAddress, KnownAddress]
+}
+
+func NewAddrMan() *AddrMan {
+	return &AddrMan{addrs: NewSafeMap[TCPAddress, KnownAddress]()}
+}
+
+// Add adds address, or updates it if it was seen more recently than the known one, and reports whether it is new
+func (a *AddrMan) Add(address message.Address) bool {
+	ip := address.NetworkAddress.IpAddress.To16()
+	if ip == nil || address.NetworkAddress.Port == 0 {
+		return false
+	}
+	known := KnownAddress{
+		Addr:     TCPAddress{IpAddress: [16]byte(ip), Port: address.NetworkAddress.Port},
+		Services: address.NetworkAddress.Services,
+		LastSeen: time.Unix(int64(address.Timestamp), 0),
+	}
+	existing, ok := a.addrs.Get(known.Addr)
+	if !ok || known.LastSeen.After(existing.LastSeen) {
+		a.addrs.Set(known.Addr, known)
+	}
+	return !ok
+}
+
+func (a *AddrMan) Get(addr TCPAddress) (KnownAddress, bool) {
+	return a.addrs.Get(addr)
+}
+
+func (a *AddrMan) Len() int {
+	return a.addrs.Len()
+}
+
+func (a *AddrMan) Addresses() []KnownAddress {
+	return a.addrs.Values()
+}
+
+// Sample returns up to max random addresses seen since the given time
+func (a *AddrMan) Sample(max int, since time.Time) []KnownAddress {
+	recent := slices.DeleteFunc(a.Addresses(), func(known KnownAddress) bool {
+		return known.LastSeen.Before(since)
+	})
+	rand.Shuffle(len(recent), func(i, j int) { recent[i], recent[j] = recent[j], recent[i] })
+	return recent[:min(max, len(recent))]
+}
+
+func (k KnownAddress) address() message.Address {
+	return *message.NewAddress(uint32(k.LastSeen.Unix()), *message.NewNetworkAddress(k.Services, net.IP(k.Addr.IpAddress[:]), k.Addr.Port))
+}
+
+// persistedAddress is the encoding of a KnownAddress in the addresses file
+type persistedAddress struct {
+	Addr     string           `json:"addr"`
+	Services message.Services `json:"services"`
+	LastSeen int64            `json:"lastSeen"`
+}
+
+// Load adds the addresses saved at path, doing nothing if no addresses were saved yet
+func (a *AddrMan) Load(fsys storage.FS, path string) error {
+	f, err := storage.Open(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoded, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	var persisted []persistedAddress
+	err = json.Unmarshal(encoded, &persisted)
+	if err != nil {
+		return err
+	}
+	for _, p := range persisted {
+		addr, err := net.ResolveTCPAddr("tcp", p.Addr)
+		if err != nil {
+			return err
+		}
+		a.Add(message.Address{
+			Timestamp:      uint32(p.LastSeen),
+			NetworkAddress: *message.NewNetworkAddress(p.Services, addr.IP, uint16(addr.Port)),
+		})
+	}
+	return nil
+}
+
+func (a *AddrMan) Save(fsys storage.FS, path string) error {
+	addresses := a.Addresses()
+	persisted := make([]persistedAddress, len(addresses))
+	for i, known := range addresses {
+		persisted[i] = persistedAddress{
+			Addr:     net.JoinHostPort(net.IP(known.Addr.IpAddress[:]).String(), strconv.Itoa(int(known.Addr.Port))),
+			Services: known.Services,
+			LastSeen: known.LastSeen.Unix(),
+		}
+	}
+	encoded, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(fsys, path, encoded)
+}
+
+// addrManPath returns the path of the file the address database is saved to, which lives next to the blocks file
+func (n *Node) addrManPath() string {
+	return filepath.Join(filepath.Dir(n.blocksFileDirectory), "addrs.json")
+}
+
+// HarvestAddrs connects to up to maxPeers peers one after the other, starting with seeds and continuing with the addresses learnt from them, asks
+// each for addresses and adds the answers to the address database, which is saved at the end. It returns the number of new addresses.
+func (n *Node) HarvestAddrs(seeds []*net.TCPAddr, maxPeers int) (int, error) {
+	err := n.addrMan.Load(n.fs, n.addrManPath())
+	if err != nil {
+		return 0, err
+	}
+	before := n.addrMan.Len()
+
+	tried := make(map[TCPAddress]struct{})
+	next := func() (*net.TCPAddr, bool) {
+		for ; len(seeds) > 0; seeds = seeds[1:] {
+			tcpAddress := TCPAddress{IpAddress: [16]byte(seeds[0].IP.To16()), Port: uint16(seeds[0].Port)}
+			if _, ok := tried[tcpAddress]; !ok {
+				tried[tcpAddress] = struct{}{}
+				return seeds[0], true
+			}
+		}
+		for _, known := range n.addrMan.Addresses() {
+			if _, ok := tried[known.Addr]; !ok {
+				tried[known.Addr] = struct{}{}
+				return &net.TCPAddr{IP: known.Addr.IpAddress[:], Port: int(known.Addr.Port)}, true
+			}
+		}
+		return nil, false
+	}
+
+	// the error rate for dialing is very high, so up to 10 times as many addresses are tried
+	harvested := 0
+	for attempts := 0; harvested < maxPeers && attempts < maxPeers*10; attempts++ {
+		addr, ok := next()
+		if !ok {
+			break
+		}
+		peer, err := n.AddPeer(addr)
+		if err != nil {
+			log.Printf("❌ Could not add peer %s due to error: %s", addr, err)
+			continue
+		}
+		addresses, err := n.requestAddrs(peer)
+		peer.Quit()
+		if err != nil {
+			log.Printf("❌ Could not request addresses from peer %s due to error: %s", addr, err)
+			continue
+		}
+		newAddresses := 0
+		for _, address := range addresses {
+			if n.addrMan.Add(address) {
+				newAddresses++
+			}
+		}
+		log.Printf("🌱 Harvested %d new addresses from peer %s (known addresses: %d)", newAddresses, addr, n.addrMan.Len())
+		harvested++
+	}
+
+	return n.addrMan.Len() - before, n.addrMan.Save(n.fs, n.addrManPath())
+}
+
+// requestAddrs sends a getaddr message to peer and waits for its answer, returning no addresses if the peer does not answer in time
+func (n *Node) requestAddrs(peer *Peer) ([]message.Address, error) {
+	getAddrResponseCh, err := n.sendGetAddrMsg(peer)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case addresses := <-getAddrResponseCh:
+		return addresses, nil
+	case <-time.After(n.getAddrWaitTime):
+		return nil, nil
+	case <-n.ctx.Done():
+		return nil, nil
+	}
+}
+
+// requestAddrsFromPeers sends getaddr messages to up to maxPeers random peers at once and learns the addresses they answer with in time, so
+// that a peer which is slow to answer or has few addresses does not hold up finding new peers
+func (n *Node) requestAddrsFromPeers(maxPeers int) {
+	peers := n.peers.Keys()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	peers = peers[:min(maxPeers, len(peers))]
+
+	answers := make([][]message.Address, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addresses, err := n.requestAddrs(peer)
+			if err != nil {
+				log.Printf("❌ Could not request addresses from peer %s due to error: %s", peer.conn.RemoteAddr(), err)
+				return
+			}
+			answers[i] = addresses
+		}()
+	}
+	wg.Wait()
+
+	for i, addresses := range answers {
+		n.learnAddrs(addresses, peers[i])
+	}
+	log.Printf("Requested addresses from %d peers (addresses to connect to: %d)", len(peers), n.unconnectedAddrs.Len())
+}
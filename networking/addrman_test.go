@@ -0,0 +1,67 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestAddress(ip string, port uint16, lastSeen time.Time) message.Address {
+	return message.Address{
+		Timestamp:      uint32(lastSeen.Unix()),
+		NetworkAddress: *message.NewNetworkAddress(message.NodeNetwork, net.ParseIP(ip), port),
+	}
+}
+
+func TestAddrMan_Add(t *testing.T) {
+	a := NewAddrMan()
+	now := time.Unix(1700000000, 0)
+
+	require.True(t, a.Add(newTestAddress("8.8.8.8", 8333, now)))
+	require.False(t, a.Add(newTestAddress("8.8.8.8", 8333, now.Add(-time.Hour))))
+	require.Equal(t, now, a.Addresses()[0].LastSeen)
+
+	// a more recent sighting updates the known address
+	require.False(t, a.Add(newTestAddress("8.8.8.8", 8333, now.Add(time.Hour))))
+	require.Equal(t, now.Add(time.Hour), a.Addresses()[0].LastSeen)
+
+	require.True(t, a.Add(newTestAddress("8.8.8.8", 8334, now)))
+	require.False(t, a.Add(newTestAddress("8.8.4.4", 0, now)))
+	require.Equal(t, 2, a.Len())
+}
+
+func TestAddrMan_SaveAndLoad(t *testing.T) {
+	fsys := storage.NewMemFS()
+	now := time.Unix(1700000000, 0)
+
+	// loading a database that was never saved leaves it empty
+	a := NewAddrMan()
+	require.NoError(t, a.Load(fsys, "addrs.json"))
+	require.Equal(t, 0, a.Len())
+
+	a.Add(newTestAddress("8.8.8.8", 8333, now))
+	a.Add(newTestAddress("2001:db8::1", 18333, now))
+	require.NoError(t, a.Save(fsys, "addrs.json"))
+
+	loaded := NewAddrMan()
+	require.NoError(t, loaded.Load(fsys, "addrs.json"))
+	require.ElementsMatch(t, a.Addresses(), loaded.Addresses())
+}
+
+func TestAddrMan_Sample(t *testing.T) {
+	a := NewAddrMan()
+	now := time.Unix(1700000000, 0)
+	a.Add(newTestAddress("8.8.8.8", 8333, now))
+	a.Add(newTestAddress("8.8.4.4", 8333, now.Add(-time.Hour)))
+	a.Add(newTestAddress("1.1.1.1", 8333, now.Add(-48*time.Hour)))
+
+	recent := a.Sample(10, now.Add(-24*time.Hour))
+	require.Len(t, recent, 2)
+	for _, known := range recent {
+		require.False(t, known.LastSeen.Before(now.Add(-24*time.Hour)))
+	}
+	require.Len(t, a.Sample(1, now.Add(-24*time.Hour)), 1)
+}
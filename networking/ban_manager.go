@@ -0,0 +1,191 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BanManager keeps track of banned IP addresses and subnets
+type BanManager struct {
+	// bans keyed by their subnet in CIDR notation
+	banned      *SafeMap[string, Ban]
+	banDuration time.Duration
+}
+
+// Ban is a banned subnet, single IP addresses being banned as subnets of one address
+type Ban struct {
+	Subnet  *net.IPNet
+	Created time.Time
+	Until   time.Time
+}
+
+func NewBanManager(banDuration time.Duration) *BanManager {
+	return &BanManager{
+		banned:      NewSafeMap[string, Ban](),
+		banDuration: banDuration,
+	}
+}
+
+// Ban bans ip for the default ban duration
+func (b *BanManager) Ban(ip net.IP) {
+	b.BanUntil(ip, time.Now().Add(b.banDuration))
+}
+
+// BanUntil bans ip until the given time
+func (b *BanManager) BanUntil(ip net.IP, until time.Time) {
+	subnet := singleIPSubnet(ip)
+	b.banned.Set(subnet.String(), Ban{Subnet: subnet, Created: time.Now(), Until: until})
+	log.Printf("🚫 Banned %s until %s", ip, until.Format(time.RFC3339))
+}
+
+// BanSubnet bans every IP address of subnet until the given time, and reports whether subnet was not banned already
+func (b *BanManager) BanSubnet(subnet *net.IPNet, until time.Time) bool {
+	subnet = canonicalSubnet(subnet)
+	if !b.banned.SetIfAbsent(subnet.String(), Ban{Subnet: subnet, Created: time.Now(), Until: until}) {
+		return false
+	}
+	log.Printf("🚫 Banned %s until %s", subnet, until.Format(time.RFC3339))
+	return true
+}
+
+func (b *BanManager) Unban(ip net.IP) {
+	b.banned.Delete(singleIPSubnet(ip).String())
+}
+
+// UnbanSubnet lifts the ban of subnet, and reports whether it was banned. Bans of the subnets it contains or is part of are kept.
+func (b *BanManager) UnbanSubnet(subnet *net.IPNet) bool {
+	key := canonicalSubnet(subnet).String()
+	if _, ok := b.banned.Get(key); !ok {
+		return false
+	}
+	b.banned.Delete(key)
+	return true
+}
+
+// Clear lifts every ban
+func (b *BanManager) Clear() {
+	b.banned.Clear()
+}
+
+// IsBanned reports whether ip is currently banned, on its own or as part of a subnet, forgetting the bans that have expired
+func (b *BanManager) IsBanned(ip net.IP) bool {
+	now := time.Now()
+	banned := false
+	for _, ban := range b.banned.Values() {
+		if now.After(ban.Until) {
+			b.banned.Delete(ban.Subnet.String())
+			continue
+		}
+		banned = banned || ban.Subnet.Contains(ip)
+	}
+	return banned
+}
+
+// Bans returns the bans which have not expired yet, sorted by subnet
+func (b *BanManager) Bans() []Ban {
+	now := time.Now()
+	var bans []Ban
+	for _, ban := range b.banned.Values() {
+		if ban.Until.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return strings.Compare(a.Subnet.String(), b.Subnet.String())
+	})
+	return bans
+}
+
+// singleIPSubnet returns the subnet made of ip only
+func singleIPSubnet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+// canonicalSubnet returns subnet with the host bits of its IP address cleared, and in 4 bytes if it is an IPv4 subnet, so that equal subnets
+// are formatted alike
+func canonicalSubnet(subnet *net.IPNet) *net.IPNet {
+	ones, bits := subnet.Mask.Size()
+	if ip4 := subnet.IP.To4(); ip4 != nil && bits == 8*net.IPv4len {
+		return &net.IPNet{IP: ip4.Mask(subnet.Mask), Mask: net.CIDRMask(ones, bits)}
+	}
+	return &net.IPNet{IP: subnet.IP.To16().Mask(subnet.Mask), Mask: net.CIDRMask(ones, bits)}
+}
+
+// persistedBan is the encoding of a ban in the bans file
+type persistedBan struct {
+	// banned subnet in CIDR notation, or banned IP address in the files saved before subnets could be banned
+	IP      string `json:"ip"`
+	Created int64  `json:"created,omitempty"`
+	Until   int64  `json:"until"`
+}
+
+// Load adds the bans saved at path which have not expired yet, doing nothing if no bans were saved yet
+func (b *BanManager) Load(fsys storage.FS, path string) error {
+	f, err := storage.Open(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoded, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	var persisted []persistedBan
+	err = json.Unmarshal(encoded, &persisted)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range persisted {
+		_, subnet, err := net.ParseCIDR(p.IP)
+		if err != nil {
+			ip := net.ParseIP(p.IP)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: p.IP}
+			}
+			subnet = singleIPSubnet(ip)
+		}
+		subnet = canonicalSubnet(subnet)
+		ban := Ban{Subnet: subnet, Until: time.Unix(p.Until, 0)}
+		if p.Created != 0 {
+			ban.Created = time.Unix(p.Created, 0)
+		}
+		if ban.Until.After(now) {
+			b.banned.Set(subnet.String(), ban)
+		}
+	}
+	return nil
+}
+
+// Save writes the bans which have not expired yet to path
+func (b *BanManager) Save(fsys storage.FS, path string) error {
+	persisted := make([]persistedBan, 0)
+	for _, ban := range b.Bans() {
+		persisted = append(persisted, persistedBan{IP: ban.Subnet.String(), Created: ban.Created.Unix(), Until: ban.Until.Unix()})
+	}
+	encoded, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(fsys, path, encoded)
+}
+
+// banListPath returns the path of the file the bans are saved to, which lives next to the blocks file
+func (n *Node) banListPath() string {
+	return filepath.Join(filepath.Dir(n.blocksFileDirectory), "banlist.json")
+}
@@ -0,0 +1,65 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanManager_SaveAndLoad(t *testing.T) {
+	fs := storage.NewMemFS()
+	bans := NewBanManager(time.Hour)
+	bans.Ban(net.ParseIP("1.2.3.4"))
+	bans.BanUntil(net.ParseIP("2001:db8::1"), time.Now().Add(time.Minute))
+	bans.BanUntil(net.ParseIP("5.6.7.8"), time.Now().Add(-time.Minute))
+	require.NoError(t, bans.Save(fs, "banlist.json"))
+
+	loaded := NewBanManager(time.Hour)
+	require.NoError(t, loaded.Load(fs, "banlist.json"))
+	require.True(t, loaded.IsBanned(net.ParseIP("1.2.3.4")))
+	require.True(t, loaded.IsBanned(net.ParseIP("2001:db8::1")))
+	// expired bans are not saved
+	require.False(t, loaded.IsBanned(net.ParseIP("5.6.7.8")))
+	require.Equal(t, 2, loaded.banned.Len())
+
+	// nothing was saved yet
+	require.NoError(t, NewBanManager(time.Hour).Load(fs, "missing.json"))
+}
+
+func TestBanManager_Subnets(t *testing.T) {
+	bans := NewBanManager(time.Hour)
+	_, subnet, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	require.True(t, bans.BanSubnet(subnet, time.Now().Add(time.Hour)))
+	// the host bits are ignored
+	_, same, err := net.ParseCIDR("10.1.2.3/16")
+	require.NoError(t, err)
+	require.False(t, bans.BanSubnet(same, time.Now().Add(time.Hour)))
+	bans.BanUntil(net.ParseIP("2001:db8::1"), time.Now().Add(time.Hour))
+
+	require.True(t, bans.IsBanned(net.ParseIP("10.1.200.7")))
+	require.True(t, bans.IsBanned(net.ParseIP("::ffff:10.1.0.1")))
+	require.False(t, bans.IsBanned(net.ParseIP("10.2.0.1")))
+	require.True(t, bans.IsBanned(net.ParseIP("2001:db8::1")))
+	require.False(t, bans.IsBanned(net.ParseIP("2001:db8::2")))
+
+	listed := bans.Bans()
+	require.Len(t, listed, 2)
+	require.Equal(t, "10.1.0.0/16", listed[0].Subnet.String())
+	require.Equal(t, "2001:db8::1/128", listed[1].Subnet.String())
+
+	fs := storage.NewMemFS()
+	require.NoError(t, bans.Save(fs, "banlist.json"))
+	loaded := NewBanManager(time.Hour)
+	require.NoError(t, loaded.Load(fs, "banlist.json"))
+	require.True(t, loaded.IsBanned(net.ParseIP("10.1.200.7")))
+	require.Equal(t, listed[0].Created.Unix(), loaded.Bans()[0].Created.Unix())
+
+	require.False(t, bans.UnbanSubnet(&net.IPNet{IP: net.ParseIP("10.1.0.0"), Mask: net.CIDRMask(24, 32)}))
+	require.True(t, bans.UnbanSubnet(same))
+	require.False(t, bans.IsBanned(net.ParseIP("10.1.200.7")))
+	bans.Clear()
+	require.Empty(t, bans.Bans())
+}
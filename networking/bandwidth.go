@@ -0,0 +1,70 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Name under which bytes of messages with an unknown command are accounted (like Bitcoin Core)
+const otherCommand = "*other*"
+
+// NetTotals is a snapshot of the bytes sent and received by the node across all peers
+type NetTotals struct {
+	BytesSent               uint64
+	BytesReceived           uint64
+	BytesSentPerCommand     map[string]uint64
+	BytesReceivedPerCommand map[string]uint64
+	Time                    time.Time
+}
+
+// bandwidthCounter counts the bytes sent and received per command
+type bandwidthCounter struct {
+	mu                      sync.Mutex
+	bytesSent               uint64
+	bytesReceived           uint64
+	bytesSentPerCommand     map[string]uint64
+	bytesReceivedPerCommand map[string]uint64
+}
+
+func newBandwidthCounter() *bandwidthCounter {
+	return &bandwidthCounter{
+		bytesSentPerCommand:     make(map[string]uint64),
+		bytesReceivedPerCommand: make(map[string]uint64),
+	}
+}
+
+func (b *bandwidthCounter) addSent(command string, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytesSent += uint64(n)
+	b.bytesSentPerCommand[command] += uint64(n)
+}
+
+func (b *bandwidthCounter) addReceived(command string, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytesReceived += uint64(n)
+	b.bytesReceivedPerCommand[command] += uint64(n)
+}
+
+func (b *bandwidthCounter) snapshot() NetTotals {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return NetTotals{
+		BytesSent:               b.bytesSent,
+		BytesReceived:           b.bytesReceived,
+		BytesSentPerCommand:     maps.Clone(b.bytesSentPerCommand),
+		BytesReceivedPerCommand: maps.Clone(b.bytesReceivedPerCommand),
+		Time:                    time.Now(),
+	}
+}
+
+// commandOfEncodedMessage returns the command name of an encoded message
+func commandOfEncodedMessage(encoded []byte) string {
+	if len(encoded) < message.HeaderLength {
+		return otherCommand
+	}
+	return message.CommandName(encoded[4:16]).String()
+}
@@ -0,0 +1,128 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"slices"
+	"time"
+)
+
+// blockRequest records which peer a block was requested from and when
+type blockRequest struct {
+	peer        *Peer
+	requestedAt time.Time
+}
+
+// blocksInFlightByPeer returns the number of blocks in flight from each peer
+func (n *Node) blocksInFlightByPeer() map[*Peer]int {
+	inFlight := make(map[*Peer]int)
+	for _, request := range n.blocksInFlight.Values() {
+		inFlight[request.peer]++
+	}
+	return inFlight
+}
+
+// blockInFlight reports whether the block with hash was requested from a peer and has not arrived yet, in which case it is not requested again
+// from another peer until the request times out, stalls or the peer leaves
+func (n *Node) blockInFlight(hash message.Hash256) bool {
+	_, ok := n.blocksInFlight.Get(hash)
+	return ok
+}
+
+// scheduleBlockDownloads splits the blocks of the best chain we neither have nor requested among the peers serving blocks, except the excluded
+// ones,, in batches of constants.BlockDownloadBatchSize and with at most constants.MaxBlocksInFlightPerPeer blocks in
+// flight from each peer. Only the n.tuning.MaxBlocksInFlight blocks following the first missing block are downloaded, so that blocks arriving
+// out of order are connected to the active chain soon after. A peer left without blocks in flight takes over the lowest blocks that have been
+// in flight from another peer for constants.SlowBlockDelay, so that a slow peer does not hold back the active chain.
+func (n *Node) scheduleBlockDownloads(excluded ...*Peer) {
+	now := time.Now()
+	inFlight := n.blocksInFlightByPeer()
+	blockHashes := n.blockIndex.MissingBlocks(n.blockInFlight, n.tuning.MaxBlocksInFlight, n.tuning.MaxBlocksInFlight)
+
+	candidates := make([]*Peer, 0)
+	for _, peer := range n.peers.Keys() {
+		if slices.Contains(excluded, peer) {
+			continue
+		}
+		if peer.Capabilities().HasServices(message.NodeNetwork) && inFlight[peer] < constants.MaxBlocksInFlightPerPeer {
+			candidates = append(candidates, peer)
+		}
+	}
+
+	for len(blockHashes) > 0 && len(candidates) > 0 {
+		peer := n.peerSelector.SelectPeer(candidates)
+		// the selector may hold on to the candidates it was given
+		candidates = slices.DeleteFunc(slices.Clone(candidates), func(candidate *Peer) bool { return candidate == peer })
+		batch := blockHashes[:min(len(blockHashes), constants.BlockDownloadBatchSize, constants.MaxBlocksInFlightPerPeer-inFlight[peer])]
+		blockHashes = blockHashes[len(batch):]
+		n.requestBlocks(peer, batch, now)
+		inFlight[peer] += len(batch)
+	}
+
+	for _, peer := range candidates {
+		if inFlight[peer] > 0 {
+			continue
+		}
+		slow := func(hash message.Hash256) bool {
+			request, ok := n.blocksInFlight.Get(hash)
+			return ok && request.peer != peer && now.Sub(request.requestedAt) >= constants.SlowBlockDelay
+		}
+		batch := n.blockIndex.MissingBlocks(func(hash message.Hash256) bool { return !slow(hash) }, n.tuning.MaxBlocksInFlight,
+			constants.BlockDownloadBatchSize)
+		if len(batch) == 0 {
+			break
+		}
+		log.Printf("Peer %s takes over %d slow blocks", peer.conn.RemoteAddr(), len(batch))
+		n.requestBlocks(peer, batch, now)
+		inFlight[peer] += len(batch)
+	}
+}
+
+// requestBlocks asks peer for the blocks with blockHashes, recording them as in flight from it unless the request could not be sent
+func (n *Node) requestBlocks(peer *Peer, blockHashes []message.Hash256, now time.Time) {
+	for _, hash := range blockHashes {
+		n.blocksInFlight.Set(hash, blockRequest{peer: peer, requestedAt: now})
+	}
+	log.Printf("Requesting %d blocks of the best chain from peer %s", len(blockHashes), peer.conn.RemoteAddr())
+	err := n.sendGetBlockDataMsg(peer, blockHashes)
+	if err != nil {
+		log.Printf("⚠️ Could not request blocks from peer %s due to error: %s", peer.conn.RemoteAddr(), err)
+		n.releaseBlocksInFlight(peer)
+	}
+}
+
+// releaseBlocksInFlight forgets the blocks in flight from peer, which left or could not be asked for blocks, so that they are requested from
+// other peers
+func (n *Node) releaseBlocksInFlight(peer *Peer) {
+	for _, hash := range n.blocksInFlight.Keys() {
+		if request, ok := n.blocksInFlight.Get(hash); ok && request.peer == peer {
+			n.blocksInFlight.Delete(hash)
+		}
+	}
+}
+
+// checkBlockDownloadTimeouts requests the blocks which did not arrive n.blockDownloadTimeout after they were requested from other peers than
+// the ones they were requested from, which are penalized in peer selection
+func (n *Node) checkBlockDownloadTimeouts() {
+	now := time.Now()
+	timedOut := make(map[*Peer]int)
+	for _, hash := range n.blocksInFlight.Keys() {
+		request, ok := n.blocksInFlight.Get(hash)
+		if !ok || now.Sub(request.requestedAt) < n.blockDownloadTimeout {
+			continue
+		}
+		n.blocksInFlight.Delete(hash)
+		timedOut[request.peer]++
+	}
+	if len(timedOut) == 0 {
+		return
+	}
+	slowPeers := make([]*Peer, 0, len(timedOut))
+	for peer, blocks := range timedOut {
+		log.Printf("⚠️ %d blocks requested from peer %s did not arrive within %s", blocks, peer.conn.RemoteAddr(), n.blockDownloadTimeout)
+		peer.blockTimeouts.Add(1)
+		slowPeers = append(slowPeers, peer)
+	}
+	n.scheduleBlockDownloads(slowPeers...)
+}
@@ -0,0 +1,121 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newDownloadTestNode returns a node knowing length headers following the genesis block, whose block it has, and connected to two fake peers
+func newDownloadTestNode(t *testing.T, length int) (*Node, []message.Hash256, []*Peer, map[*Peer]*networkingtest.Conn) {
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	node.tuning.MaxBlocksInFlight = 64
+	require.NoError(t, node.addBlockToNode(networkingtest.GenesisBlock(t)))
+	headers, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), length, easyBits, 0)
+	_, err := node.blockIndex.AddHeaders(headers)
+	require.NoError(t, err)
+
+	peers := make([]*Peer, 2)
+	conns := make(map[*Peer]*networkingtest.Conn)
+	for i := range peers {
+		fakePeer := networkingtest.NewFakePeer(t)
+		peers[i], err = node.AddPeer(fakePeer.Addr())
+		require.NoError(t, err)
+		conns[peers[i]] = fakePeer.Accept(time.Second)
+	}
+	return node, hashes, peers, conns
+}
+
+func requestedBlocks(t *testing.T, conn *networkingtest.Conn) []message.Hash256 {
+	getData := conn.Expect(message.GetDataCommand, time.Second).Payload.(*message.GetDataPayload)
+	hashes := make([]message.Hash256, len(getData.InventoryList))
+	for i, inventory := range getData.InventoryList {
+		hashes[i] = inventory.Hash
+	}
+	return hashes
+}
+
+func TestNode_SplitsBlockDownloadsAcrossPeers(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 40)
+
+	node.scheduleBlockDownloads()
+
+	requested := append(requestedBlocks(t, conns[peers[0]]), requestedBlocks(t, conns[peers[1]])...)
+	require.Len(t, requested, 2*constants.BlockDownloadBatchSize)
+	require.ElementsMatch(t, hashes[:2*constants.BlockDownloadBatchSize], requested)
+	for _, peer := range peers {
+		require.Equal(t, constants.MaxBlocksInFlightPerPeer, node.blocksInFlightByPeer()[peer])
+	}
+
+	// both peers are at their cap, so the remaining blocks wait for them
+	node.scheduleBlockDownloads()
+	_, ok := node.blocksInFlight.Get(hashes[2*constants.BlockDownloadBatchSize])
+	require.False(t, ok)
+}
+
+func TestNode_IdlePeerTakesOverSlowBlocks(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 4)
+	slowPeer, idlePeer := peers[0], peers[1]
+	for _, hash := range hashes {
+		node.blocksInFlight.Set(hash, blockRequest{peer: slowPeer, requestedAt: time.Now().Add(-constants.SlowBlockDelay)})
+	}
+
+	node.scheduleBlockDownloads()
+
+	require.Equal(t, hashes, requestedBlocks(t, conns[idlePeer]))
+	request, ok := node.blocksInFlight.Get(hashes[0])
+	require.True(t, ok)
+	require.Equal(t, idlePeer, request.peer)
+}
+
+func TestNode_ReleasesBlocksInFlightFromRemovedPeer(t *testing.T) {
+	node, hashes, peers, _ := newDownloadTestNode(t, 4)
+	node.blocksInFlight.Set(hashes[0], blockRequest{peer: peers[0], requestedAt: time.Now()})
+	node.blocksInFlight.Set(hashes[1], blockRequest{peer: peers[1], requestedAt: time.Now()})
+
+	node.removePeerFromNode(peers[0])
+
+	_, ok := node.blocksInFlight.Get(hashes[0])
+	require.False(t, ok)
+	_, ok = node.blocksInFlight.Get(hashes[1])
+	require.True(t, ok)
+}
+
+func TestNode_RequestsTimedOutBlocksFromAnotherPeer(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 4)
+	node.blockDownloadTimeout = 50 * time.Millisecond
+	slowPeer, otherPeer := peers[0], peers[1]
+	for _, hash := range hashes[:2] {
+		node.blocksInFlight.Set(hash, blockRequest{peer: slowPeer, requestedAt: time.Now().Add(-time.Second)})
+	}
+	for _, hash := range hashes[2:] {
+		node.blocksInFlight.Set(hash, blockRequest{peer: slowPeer, requestedAt: time.Now()})
+	}
+
+	node.checkBlockDownloadTimeouts()
+
+	require.Equal(t, hashes[:2], requestedBlocks(t, conns[otherPeer]))
+	request, ok := node.blocksInFlight.Get(hashes[0])
+	require.True(t, ok)
+	require.Equal(t, otherPeer, request.peer)
+	request, ok = node.blocksInFlight.Get(hashes[2])
+	require.True(t, ok)
+	require.Equal(t, slowPeer, request.peer)
+	require.Equal(t, uint64(1), slowPeer.Stats().BlockTimeouts)
+	require.Zero(t, otherPeer.Stats().BlockTimeouts)
+}
+
+func TestNode_ChunksLargeGetDataRequests(t *testing.T) {
+	node, _, peers, conns := newDownloadTestNode(t, 0)
+	_, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 2*constants.MaxGetDataInventories+10, easyBits, 0)
+
+	require.NoError(t, node.sendGetBlockDataMsg(peers[0], hashes))
+
+	require.Equal(t, hashes[:constants.MaxGetDataInventories], requestedBlocks(t, conns[peers[0]]))
+	require.Equal(t, hashes[constants.MaxGetDataInventories:2*constants.MaxGetDataInventories], requestedBlocks(t, conns[peers[0]]))
+	require.Equal(t, hashes[2*constants.MaxGetDataInventories:], requestedBlocks(t, conns[peers[0]]))
+}
@@ -0,0 +1,214 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrBlockFileCorrupted = errors.New("block file does not hold the block the index points to")
+
+// Key of the BlockFileStore's index holding the number of the block file blocks are appended to
+var blockFileNumberKey = []byte{'f'}
+
+// BlockFileStore is a BlockStore appending the blocks to block files the way Bitcoin Core does: each block is preceded by the network's magic
+// value and its size, and a new file is started when a block would make the current one exceed the maximum file size. The position of every
+// block and its header are kept in an index (a storage.KV), so that blocks can be read back without scanning the files
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/node/blockstorage.h).
+type BlockFileStore struct {
+	mu          sync.Mutex
+	fsys        storage.FS
+	prefix      string
+	magic       uint32
+	maxFileSize int64
+	index       *storage.KV
+	// block file blocks are appended to
+	file       storage.File
+	fileNumber uint32
+	fileSize   int64
+}
+
+// blockLocation is where a block is in the block files, and the CRC32C checksum it is read back with
+type blockLocation struct {
+	FileNumber uint32
+	Offset     int64
+	Length     uint32
+	Checksum   uint32
+}
+
+// OpenBlockFileStore opens (or creates) the block files whose names start with prefix in fsys, followed by their number and ".dat", and their
+// index, whose name is prefix followed by "index.kv". Blocks are preceded by magic, the magic value of the network, and appended to a file
+// until it would exceed maxFileSize bytes.
+func OpenBlockFileStore(fsys storage.FS, prefix string, magic uint32, maxFileSize int64) (*BlockFileStore, error) {
+	index, err := storage.OpenKV(fsys, prefix+"index.kv")
+	if err != nil {
+		return nil, err
+	}
+	s := &BlockFileStore{fsys: fsys, prefix: prefix, magic: magic, maxFileSize: maxFileSize, index: index}
+	if encoded, err := index.Get(blockFileNumberKey); err == nil {
+		s.fileNumber = binary.LittleEndian.Uint32(encoded)
+	}
+	err = s.openFile()
+	if err != nil {
+		_ = index.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BlockFileStore) fileName(number uint32) string {
+	return fmt.Sprintf("%s%05d.dat", s.prefix, number)
+}
+
+// openFile opens the current block file for appending. Bytes left at its end by a write that was interrupted are not referred to by the index,
+// so they are simply skipped.
+func (s *BlockFileStore) openFile() error {
+	f, err := s.fsys.OpenFile(s.fileName(s.fileNumber), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.file, s.fileSize = f, size
+	return nil
+}
+
+func (s *BlockFileStore) WriteBlock(hash message.Hash256, block *message.BlockPayload) error {
+	encodedBlock, err := block.Encode()
+	if err != nil {
+		return err
+	}
+	encodedHeader, err := encodeHeader(block)
+	if err != nil {
+		return err
+	}
+	record := encodeBlockFileRecord(s.magic, encodedBlock)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fileSize > 0 && s.fileSize+int64(len(record)) > s.maxFileSize {
+		err = s.file.Close()
+		if err != nil {
+			return err
+		}
+		s.fileNumber++
+		err = s.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	// the block is durably in its file before the index points to it
+	_, err = s.file.Write(record)
+	if err != nil {
+		return err
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return err
+	}
+	location := blockLocation{
+		FileNumber: s.fileNumber,
+		Offset:     s.fileSize + 8,
+		Length:     uint32(len(encodedBlock)),
+		Checksum:   crc32.Checksum(encodedBlock, castagnoli),
+	}
+	s.fileSize += int64(len(record))
+
+	encodedLocation := new(bytes.Buffer)
+	err = binary.Write(encodedLocation, binary.LittleEndian, location)
+	if err != nil {
+		return err
+	}
+	fileNumber := binary.LittleEndian.AppendUint32(nil, s.fileNumber)
+	batch := &storage.KVBatch{}
+	batch.Put(kvBlockKey(kvBlockPrefix, hash), encodedLocation.Bytes())
+	batch.Put(kvBlockKey(kvHeaderPrefix, hash), encodedHeader)
+	batch.Put(blockFileNumberKey, fileNumber)
+	return s.index.Write(batch)
+}
+
+// encodeBlockFileRecord returns encodedBlock preceded by magic, the network's magic value, and its size, the way blocks are laid out in block
+// files
+func encodeBlockFileRecord(magic uint32, encodedBlock []byte) []byte {
+	record := make([]byte, 8, 8+len(encodedBlock))
+	binary.LittleEndian.PutUint32(record[0:4], magic)
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(encodedBlock)))
+	return append(record, encodedBlock...)
+}
+
+func (s *BlockFileStore) ReadBlock(hash message.Hash256) (*message.BlockPayload, error) {
+	encodedLocation, err := s.index.Get(kvBlockKey(kvBlockPrefix, hash))
+	if err != nil {
+		return nil, err
+	}
+	var location blockLocation
+	err = binary.Read(bytes.NewReader(encodedLocation), binary.LittleEndian, &location)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := storage.Open(s.fsys, s.fileName(location.FileNumber))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var header [8]byte
+	_, err = f.Seek(location.Offset-int64(len(header)), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(f, header[:])
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != s.magic || binary.LittleEndian.Uint32(header[4:8]) != location.Length {
+		return nil, fmt.Errorf("%w: %s in %s at offset %d", ErrBlockFileCorrupted, hash, s.fileName(location.FileNumber), location.Offset)
+	}
+	encodedBlock := make([]byte, location.Length)
+	_, err = io.ReadFull(f, encodedBlock)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(encodedBlock, castagnoli) != location.Checksum {
+		return nil, fmt.Errorf("%w: %s in %s at offset %d does not match its checksum", ErrBlockFileCorrupted, hash, s.fileName(location.FileNumber),
+			location.Offset)
+	}
+	return message.DecodeBlockPayload(bytes.NewReader(encodedBlock))
+}
+
+func (s *BlockFileStore) Headers() ([]message.BlockPayload, error) {
+	return readStoredHeaders(s.index)
+}
+
+// Size returns the size of the block files and of their index
+func (s *BlockFileStore) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := s.fileSize + s.index.Size()
+	for number := range s.fileNumber {
+		fileSize, err := storage.Size(s.fsys, s.fileName(number))
+		if err != nil {
+			return 0, err
+		}
+		size += fileSize
+	}
+	return size, nil
+}
+
+func (s *BlockFileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.file.Close()
+	return errors.Join(err, s.index.Close())
+}
@@ -0,0 +1,173 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/utxo"
+)
+
+var (
+	ErrBlockFilterIndexDisabled = errors.New("block filter index is disabled")
+	ErrBlockFilterNotFound      = errors.New("block filter not found")
+)
+
+// Key prefixes of the BlockFilterIndex
+const (
+	// followed by a block hash, the basic filter of the block
+	filterIndexFilterPrefix byte = 'f'
+	// followed by a block hash, the header of the basic filter of the block
+	filterIndexHeaderPrefix byte = 'h'
+)
+
+// BlockFilterIndex keeps the basic filter (BIP158) of every block that joined the active chain and the chain of their filter headers
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/index/blockfilterindex.cpp). Filters are kept by block hash, so the filters of blocks
+// that left the active chain during a reorganization stay in the index.
+type BlockFilterIndex struct {
+	kv *storage.KV
+}
+
+// OpenBlockFilterIndex opens (or creates) the block filter index whose key-value store is at path in fsys
+func OpenBlockFilterIndex(fsys storage.FS, path string) (*BlockFilterIndex, error) {
+	kv, err := storage.OpenKV(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockFilterIndex{kv: kv}, nil
+}
+
+// connectGenesis indexes the filter of the genesis block, whose hash is hash, if it is not indexed yet. Its filter header commits to a zero
+// header, as no block precedes it.
+func (x *BlockFilterIndex) connectGenesis(hash message.Hash256, genesis *message.BlockPayload) error {
+	if x.HasBlock(hash) {
+		return nil
+	}
+	filter, err := blockfilter.BuildBasic(hash, blockfilter.BasicElements(genesis, nil))
+	if err != nil {
+		return err
+	}
+	return x.put(hash, filter, blockfilter.Header(filter, message.Hash256{}))
+}
+
+func filterIndexKey(prefix byte, hash message.Hash256) []byte {
+	return append([]byte{prefix}, hash[:]...)
+}
+
+func (x *BlockFilterIndex) put(hash message.Hash256, filter []byte, header message.Hash256) error {
+	batch := &storage.KVBatch{}
+	batch.Put(filterIndexKey(filterIndexFilterPrefix, hash), filter)
+	batch.Put(filterIndexKey(filterIndexHeaderPrefix, hash), header[:])
+	return x.kv.Write(batch)
+}
+
+// HasBlock reports whether the filter of the block with hash hash is indexed
+func (x *BlockFilterIndex) HasBlock(hash message.Hash256) bool {
+	return x.kv.Has(filterIndexKey(filterIndexHeaderPrefix, hash))
+}
+
+// ConnectBlock indexes the filter of block, whose hash is hash and whose inputs spent the outputs spent. The filter of its parent must be
+// indexed, as the header of the filter commits to the parent's.
+func (x *BlockFilterIndex) ConnectBlock(hash message.Hash256, block *message.BlockPayload, spent []utxo.Coin) error {
+	previous, err := x.Header(block.PrevBlock)
+	if err != nil {
+		return err
+	}
+	spentScripts := make([][]byte, len(spent))
+	for i, coin := range spent {
+		spentScripts[i] = coin.PkScript
+	}
+	filter, err := blockfilter.BuildBasic(hash, blockfilter.BasicElements(block, spentScripts))
+	if err != nil {
+		return err
+	}
+	return x.put(hash, filter, blockfilter.Header(filter, previous))
+}
+
+// Filter returns the basic filter of the block with hash hash, or ErrBlockFilterNotFound
+func (x *BlockFilterIndex) Filter(hash message.Hash256) ([]byte, error) {
+	filter, err := x.kv.Get(filterIndexKey(filterIndexFilterPrefix, hash))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrBlockFilterNotFound, hash)
+	}
+	return filter, err
+}
+
+// Header returns the header of the basic filter of the block with hash hash, or ErrBlockFilterNotFound
+func (x *BlockFilterIndex) Header(hash message.Hash256) (message.Hash256, error) {
+	encoded, err := x.kv.Get(filterIndexKey(filterIndexHeaderPrefix, hash))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return message.Hash256{}, fmt.Errorf("%w: %s", ErrBlockFilterNotFound, hash)
+	}
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	if len(encoded) != len(message.Hash256{}) {
+		return message.Hash256{}, fmt.Errorf("invalid filter header of block %s: %x", hash, encoded)
+	}
+	return message.Hash256(encoded), nil
+}
+
+func (x *BlockFilterIndex) Close() error {
+	return x.kv.Close()
+}
+
+// SetBlockFilterIndex makes the node keep the basic filters of the blocks of the active chain in index, so that they can be looked up with
+// GetBlockFilter and served to peers, which the node advertises with the NODE_COMPACT_FILTERS service (BIP157). The filter of the genesis
+// block is indexed at once. It must be called after SetNetworkParams and before Start.
+func (n *Node) SetBlockFilterIndex(index *BlockFilterIndex) error {
+	genesis := n.blockIndex.Genesis()
+	err := index.connectGenesis(genesis.Hash, genesis.Block)
+	if err != nil {
+		return err
+	}
+	n.filterIndex = index
+	n.services |= message.NodeCompactFilters
+	return nil
+}
+
+// GetBlockFilter returns the basic filter of the block with hash hash and its filter header. It fails with ErrBlockFilterIndexDisabled if
+// the node has no block filter index.
+func (n *Node) GetBlockFilter(hash message.Hash256) ([]byte, message.Hash256, error) {
+	if n.filterIndex == nil {
+		return nil, message.Hash256{}, ErrBlockFilterIndexDisabled
+	}
+	filter, err := n.filterIndex.Filter(hash)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	header, err := n.filterIndex.Header(hash)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	return filter, header, nil
+}
+
+// indexBlockFilter indexes the filter of block, whose hash is hash, with the outputs it spent as kept by chainstate. Blocks whose spent
+// outputs the chainstate does not have (e.g. the blocks below a UTXO snapshot) are not indexed, and neither are their descendants.
+func (n *Node) indexBlockFilter(chainstate *utxo.Chainstate, hash message.Hash256, block *message.BlockPayload) error {
+	spent, err := chainstate.Undo(hash)
+	if errors.Is(err, utxo.ErrMissingUndoData) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = n.filterIndex.ConnectBlock(hash, block, spent)
+	if errors.Is(err, ErrBlockFilterNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (n *Node) closeBlockFilterIndex() error {
+	if n.filterIndex == nil {
+		return nil
+	}
+	err := n.filterIndex.Close()
+	if err != nil {
+		return fmt.Errorf("could not close the block filter index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNode_GetBlockFilter(t *testing.T) {
+	blocks, hashes := createSnapshotChain(t, constants.CoinbaseMaturity+2)
+	last := len(blocks) - 1
+	blocks[0].Transactions[0].TransactionOutputs[0].PkScript = []byte{0x52}
+	coinbaseId, err := blocks[0].Transactions[0].GetTxId()
+	require.NoError(t, err)
+	// spends the coinbase of the first block in the last, once it has matured, so that its script is in the filters of the first and last
+	// blocks
+	blocks[last].Transactions = append(blocks[last].Transactions, message.TxPayload{
+		Version:            1,
+		TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: coinbaseId}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs: []message.TxOut{{Value: 50, PkScript: []byte{0x00, 0x14, 0x01}}},
+	})
+
+	fs := storage.NewMemFS()
+	node := newBlockStoreNode(t, fs)
+	_, _, err = node.GetBlockFilter(hashes[0])
+	require.ErrorIs(t, err, ErrBlockFilterIndexDisabled)
+	index, err := OpenBlockFilterIndex(fs, "blockfilter.kv")
+	require.NoError(t, err)
+	require.NoError(t, node.SetBlockFilterIndex(index))
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+
+	genesisFilter, previous, err := node.GetBlockFilter(message.Hash256(constants.GenesisBlockHash))
+	require.NoError(t, err)
+	match, err := blockfilter.Match(message.Hash256(constants.GenesisBlockHash), genesisFilter, constants.GenesisOutputScript)
+	require.NoError(t, err)
+	require.True(t, match)
+	for i, hash := range hashes {
+		filter, header, err := node.GetBlockFilter(hash)
+		require.NoError(t, err)
+		require.Equal(t, blockfilter.Header(filter, previous), header, "filter headers should be chained")
+		previous = header
+
+		match, err := blockfilter.Match(hash, filter, []byte{0x52})
+		require.NoError(t, err)
+		require.Equal(t, i == 0 || i == last, match)
+		match, err = blockfilter.Match(hash, filter, []byte{0x00, 0x14, 0x01})
+		require.NoError(t, err)
+		require.Equal(t, i == last, match)
+	}
+
+	_, _, err = node.GetBlockFilter(message.Hash256{})
+	require.ErrorIs(t, err, ErrBlockFilterNotFound)
+}
@@ -0,0 +1,146 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	ErrBlocksFileRecordCorrupted = errors.New("corrupted block record")
+	ErrStoredBlockCorrupted      = errors.New("stored block failed verification")
+)
+
+// Size of what precedes every block in the blocks file: the network's magic value, the size of the block and its CRC32C checksum
+const blocksFileRecordHeaderSize = 12
+
+// encodeBlocksFileRecord returns encodedBlock preceded by magic, the network's magic value, its size and its checksum, the way blocks are laid out in
+// the blocks file
+func encodeBlocksFileRecord(magic uint32, encodedBlock []byte) []byte {
+	record := make([]byte, blocksFileRecordHeaderSize, blocksFileRecordHeaderSize+len(encodedBlock))
+	binary.LittleEndian.PutUint32(record[0:4], magic)
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(encodedBlock)))
+	binary.LittleEndian.PutUint32(record[8:12], crc32.Checksum(encodedBlock, castagnoli))
+	return append(record, encodedBlock...)
+}
+
+// readBlocksFileRecord reads the next record of the blocks file of the network whose magic value is magic from r and returns its block and its
+// size. It returns io.EOF if r ends before
+// the record starts, and an error wrapping ErrBlocksFileRecordCorrupted if the record is cut short or does not hold a block matching its checksum.
+func readBlocksFileRecord(r io.Reader, magic uint32) (*message.BlockPayload, int64, error) {
+	var header [blocksFileRecordHeaderSize]byte
+	_, err := io.ReadFull(r, header[:])
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, 0, fmt.Errorf("%w: truncated header", ErrBlocksFileRecordCorrupted)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if recordMagic := binary.LittleEndian.Uint32(header[0:4]); recordMagic != magic {
+		return nil, 0, fmt.Errorf("%w: magic value %08x", ErrBlocksFileRecordCorrupted, recordMagic)
+	}
+	length := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxBlockSize {
+		return nil, 0, fmt.Errorf("%w: size %d", ErrBlocksFileRecordCorrupted, length)
+	}
+	encodedBlock := make([]byte, length)
+	_, err = io.ReadFull(r, encodedBlock)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, 0, fmt.Errorf("%w: truncated block", ErrBlocksFileRecordCorrupted)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if checksum := crc32.Checksum(encodedBlock, castagnoli); checksum != binary.LittleEndian.Uint32(header[8:12]) {
+		return nil, 0, fmt.Errorf("%w: checksum %08x", ErrBlocksFileRecordCorrupted, checksum)
+	}
+	block, err := message.DecodeBlockPayload(bytes.NewReader(encodedBlock))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrBlocksFileRecordCorrupted, err)
+	}
+	return block, int64(len(header) + len(encodedBlock)), nil
+}
+
+// truncateBlocksFile drops the records of the blocks file from offset size on, so that the blocks they held are downloaded again, and updates
+// its manifest to the blocks left
+func (n *Node) truncateBlocksFile(size int64, blocks int) error {
+	f, err := n.fs.OpenFile(n.blocksFileDirectory, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return err
+	}
+
+	size, checksum, err := n.fileChecksum(n.blocksFileDirectory)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(blocksManifest{Blocks: blocks, Size: size, CRC32C: checksum})
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(n.fs, n.blocksManifestPath(), manifest)
+}
+
+// SetCheckBlocks makes the node verify the last blocks blocks of the active chain when it loads the stored blocks (none by default, and all of
+// them if blocks is negative). It must be called before Start.
+func (n *Node) SetCheckBlocks(blocks int) {
+	n.checkBlocks = blocks
+}
+
+// checkStoredBlocks reads back the last blocks of the active chain, as many as set with SetCheckBlocks, and checks that each still hashes to the
+// block the index holds and that its transactions match its merkle root, much like the first level of Bitcoin Core's -checkblocks
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp)
+func (n *Node) checkStoredBlocks() error {
+	if n.checkBlocks == 0 {
+		return nil
+	}
+	start := time.Now()
+	tip := n.blockIndex.Tip()
+	lowest := int32(0)
+	if n.checkBlocks > 0 {
+		lowest = max(tip.Height-int32(n.checkBlocks)+1, 0)
+	}
+	checked := 0
+	for height := tip.Height; height >= lowest; height-- {
+		node, ok := n.blockIndex.ActiveBlock(height)
+		// the genesis block and the blocks below a UTXO snapshot still being validated are not stored
+		if !ok || !node.Status.Has(blockchain.StatusHaveData) {
+			break
+		}
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return fmt.Errorf("%w: block %s at height %d could not be read: %w", ErrStoredBlockCorrupted, node.Hash, height, err)
+		}
+		hash, err := block.GetBlockHash()
+		if err != nil {
+			return err
+		}
+		if hash != node.Hash {
+			return fmt.Errorf("%w: block at height %d hashes to %s instead of %s", ErrStoredBlockCorrupted, height, hash, node.Hash)
+		}
+		err = block.CheckMerkleRoot()
+		if err != nil {
+			return fmt.Errorf("%w: block %s at height %d: %w", ErrStoredBlockCorrupted, node.Hash, height, err)
+		}
+		checked++
+	}
+	log.Printf("✅ Verified the last %d blocks of the active chain in %s", checked, time.Since(start))
+	return nil
+}
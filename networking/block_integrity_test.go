@@ -0,0 +1,100 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// createMerkleChain returns a chain of length blocks following the genesis block whose merkle roots commit to their transactions
+func createMerkleChain(t *testing.T, length int) ([]message.BlockPayload, []message.Hash256) {
+	blocks, _ := createSnapshotChain(t, length)
+	hashes := make([]message.Hash256, length)
+	prev := message.Hash256(constants.GenesisBlockHash)
+	for i := range blocks {
+		merkleRoot, err := blocks[i].ComputeMerkleRoot()
+		require.NoError(t, err)
+		blocks[i].PrevBlock, blocks[i].MerkleRoot = prev, merkleRoot
+		hashes[i], err = blocks[i].GetBlockHash()
+		require.NoError(t, err)
+		prev = hashes[i]
+	}
+	return blocks, hashes
+}
+
+// newBlockFileStoreNode returns a node appending its blocks to block files in fs
+func newBlockFileStoreNode(t *testing.T, fs storage.FS) *Node {
+	store, err := OpenBlockFileStore(fs, "blk", constants.MainnetMagicValue, constants.MaxBlockFileSize)
+	require.NoError(t, err)
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	node.SetBlockStore(store)
+	return node
+}
+
+// flipLastByte damages the last byte of the named file, which is the end of the last block written to it
+func flipLastByte(t *testing.T, fs storage.FS, name string) {
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Seek(-1, io.SeekEnd)
+	require.NoError(t, err)
+	var b [1]byte
+	_, err = io.ReadFull(f, b[:])
+	require.NoError(t, err)
+	_, err = f.Seek(-1, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{^b[0]})
+	require.NoError(t, err)
+}
+
+func TestNode_ChecksStoredBlocks(t *testing.T) {
+	fs := storage.NewMemFS()
+	node := newBlockFileStoreNode(t, fs)
+	blocks, hashes := createMerkleChain(t, 3)
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	require.NoError(t, node.blockStore.Close())
+
+	node = newBlockFileStoreNode(t, fs)
+	defer node.blockStore.Close()
+	node.SetCheckBlocks(-1)
+	require.NoError(t, node.LoadBlocks())
+	require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+
+	flipLastByte(t, fs, "blk00000.dat")
+	node.SetCheckBlocks(0)
+	require.NoError(t, node.checkStoredBlocks(), "blocks should not be checked")
+	node.SetCheckBlocks(1)
+	err := node.checkStoredBlocks()
+	require.ErrorIs(t, err, ErrStoredBlockCorrupted)
+	require.ErrorIs(t, err, ErrBlockFileCorrupted)
+}
+
+func TestNode_ChecksMerkleRootsOfStoredBlocks(t *testing.T) {
+	fs := storage.NewMemFS()
+	node := newBlocksFileNode(t, fs)
+	require.NoError(t, node.readBlocksFile())
+	// the merkle roots of these blocks do not commit to their transactions
+	blocks, hashes := createSnapshotChain(t, 2)
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	require.NoError(t, node.checkpointBlocks())
+	require.NoError(t, node.wal.Close())
+
+	restarted := newBlocksFileNode(t, fs)
+	restarted.SetCheckBlocks(1)
+	err := restarted.LoadBlocks()
+	require.ErrorIs(t, err, ErrStoredBlockCorrupted)
+	require.ErrorIs(t, err, message.ErrBadMerkleRoot)
+	require.NoError(t, restarted.wal.Close())
+}
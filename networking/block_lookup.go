@@ -0,0 +1,98 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/message"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// GetBlock returns the stored block with hash hash, which may not be in the active chain. It fails with ErrBlockNotFound if the block is
+// not known and with ErrBlockNotStored if only its header is.
+func (n *Node) GetBlock(hash message.Hash256) (*message.BlockPayload, error) {
+	node, ok := n.blockIndex.Get(hash)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	return n.storedBlock(node)
+}
+
+// GetBlockByHeight returns the block of the active chain at height. It fails with ErrBlockNotFound if the active chain is shorter and with
+// ErrBlockNotStored if the block is not stored (e.g. below a UTXO snapshot still being validated).
+func (n *Node) GetBlockByHeight(height int32) (*message.BlockPayload, error) {
+	node, ok := n.blockIndex.ActiveBlock(height)
+	if !ok {
+		return nil, fmt.Errorf("%w: height %d is above the tip or negative", ErrBlockNotFound, height)
+	}
+	return n.storedBlock(node)
+}
+
+func (n *Node) storedBlock(node blockchain.BlockNode) (*message.BlockPayload, error) {
+	if !node.Status.Has(blockchain.StatusHaveData) {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotStored, node.Hash)
+	}
+	return n.blockIndex.BlockData(node)
+}
+
+// GetBlockHeader returns the header of the block with hash hash, whose data does not have to be stored, and its height. It fails with
+// ErrBlockNotFound if the header is not known.
+func (n *Node) GetBlockHeader(hash message.Hash256) (message.BlockPayload, int32, error) {
+	node, ok := n.blockIndex.Get(hash)
+	if !ok {
+		return message.BlockPayload{}, 0, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	return node.Header(), node.Height, nil
+}
+
+// BlockHeaderInfo describes a block whose header is known and its place in the block tree
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L134-L159)
+type BlockHeaderInfo struct {
+	Hash   message.Hash256
+	Header message.BlockPayload
+	Height int32
+	// Number of blocks of the active chain from the block to the tip, or -1 if the block is not in the active chain
+	Confirmations int32
+	// Median timestamp of the block and the 10 blocks preceding it
+	MedianTime uint32
+	// Total work of the chain ending with the block, as 64 hex digits
+	ChainWork string
+	// Number of transactions of the block, or 0 if its data is not stored
+	TxCount int
+	// Hash of the block following it in the active chain, if any
+	NextBlockHash *message.Hash256
+}
+
+// BlockHeaderInfo describes the block with hash hash, whose data does not have to be stored. It fails with ErrBlockNotFound if the header
+// is not known.
+func (n *Node) BlockHeaderInfo(hash message.Hash256) (BlockHeaderInfo, error) {
+	node, ok := n.blockIndex.Get(hash)
+	if !ok {
+		return BlockHeaderInfo{}, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	medianTime, _ := n.blockIndex.MedianTimePast(hash)
+	info := BlockHeaderInfo{
+		Hash:          hash,
+		Header:        node.Header(),
+		Height:        node.Height,
+		Confirmations: -1,
+		MedianTime:    medianTime,
+		ChainWork:     fmt.Sprintf("%064x", node.ChainWork),
+	}
+	if active, ok := n.blockIndex.ActiveBlock(node.Height); ok && active.Hash == hash {
+		info.Confirmations = n.blockIndex.Tip().Height - node.Height + 1
+		if next, ok := n.blockIndex.ActiveBlock(node.Height + 1); ok {
+			info.NextBlockHash = &next.Hash
+		}
+	}
+	// the index does not count transactions, so they are counted from the stored block
+	if node.Status.Has(blockchain.StatusHaveData) {
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return BlockHeaderInfo{}, err
+		}
+		info.TxCount = len(block.Transactions)
+	}
+	return info, nil
+}
@@ -0,0 +1,93 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNode_GetBlock(t *testing.T) {
+	node := newBlockStoreNode(t, storage.NewMemFS())
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks[:2] {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	_, err := node.blockIndex.AddHeaders(blocks[2:])
+	require.NoError(t, err)
+
+	block, err := node.GetBlock(hashes[1])
+	require.NoError(t, err)
+	hash, err := block.GetBlockHash()
+	require.NoError(t, err)
+	require.Equal(t, hashes[1], hash)
+	require.Len(t, block.Transactions, 1)
+	block, err = node.GetBlockByHeight(1)
+	require.NoError(t, err)
+	hash, err = block.GetBlockHash()
+	require.NoError(t, err)
+	require.Equal(t, hashes[0], hash)
+
+	t.Run("only the header of a block whose data is not stored should be returned", func(t *testing.T) {
+		_, err := node.GetBlock(hashes[2])
+		require.ErrorIs(t, err, ErrBlockNotStored)
+		header, height, err := node.GetBlockHeader(hashes[2])
+		require.NoError(t, err)
+		require.EqualValues(t, 3, height)
+		expected := blocks[2]
+		expected.Transactions = nil
+		require.Equal(t, expected, header)
+	})
+
+	t.Run("unknown blocks and heights above the tip should not be found", func(t *testing.T) {
+		_, err := node.GetBlock(message.Hash256{0x01})
+		require.ErrorIs(t, err, ErrBlockNotFound)
+		_, _, err = node.GetBlockHeader(message.Hash256{0x01})
+		require.ErrorIs(t, err, ErrBlockNotFound)
+		_, err = node.GetBlockByHeight(3)
+		require.ErrorIs(t, err, ErrBlockNotFound)
+		_, err = node.GetBlockByHeight(-1)
+		require.ErrorIs(t, err, ErrBlockNotFound)
+	})
+}
+
+func TestNode_BlockHeaderInfo(t *testing.T) {
+	node := newBlockStoreNode(t, storage.NewMemFS())
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks[:2] {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	_, err := node.blockIndex.AddHeaders(blocks[2:])
+	require.NoError(t, err)
+
+	info, err := node.BlockHeaderInfo(hashes[0])
+	require.NoError(t, err)
+	require.Equal(t, hashes[0], info.Hash)
+	require.EqualValues(t, 1, info.Height)
+	require.EqualValues(t, 2, info.Confirmations)
+	require.Equal(t, 1, info.TxCount)
+	require.Equal(t, &hashes[1], info.NextBlockHash)
+	require.Len(t, info.ChainWork, 64)
+	expected := blocks[0]
+	expected.Transactions = nil
+	require.Equal(t, expected, info.Header)
+
+	info, err = node.BlockHeaderInfo(hashes[1])
+	require.NoError(t, err)
+	require.EqualValues(t, 1, info.Confirmations)
+	require.Nil(t, info.NextBlockHash, "the tip should have no next block")
+
+	t.Run("a block whose data is not stored should not be in the active chain nor have transactions", func(t *testing.T) {
+		info, err := node.BlockHeaderInfo(hashes[2])
+		require.NoError(t, err)
+		require.EqualValues(t, 3, info.Height)
+		require.EqualValues(t, -1, info.Confirmations)
+		require.Zero(t, info.TxCount)
+		require.Nil(t, info.NextBlockHash)
+	})
+
+	_, err = node.BlockHeaderInfo(message.Hash256{0x01})
+	require.ErrorIs(t, err, ErrBlockNotFound)
+}
@@ -0,0 +1,202 @@
+package networking
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/tracing"
+	"log"
+	"time"
+)
+
+// BlockStore durably stores the blocks the node accepts, so that the block index does not keep them in memory and the node does not read
+// every block when it starts
+type BlockStore interface {
+	blockchain.BlockReader
+	// WriteBlock durably stores block, whose hash is hash
+	WriteBlock(hash message.Hash256, block *message.BlockPayload) error
+	// Headers returns the headers of the stored blocks, in no particular order
+	Headers() ([]message.BlockPayload, error)
+	// Size returns the number of bytes the stored blocks take on disk
+	Size() (int64, error)
+	Close() error
+}
+
+// Key prefixes of the KVBlockStore
+const (
+	// followed by the block hash, the encoded block
+	kvBlockPrefix byte = 'b'
+	// followed by the block hash, the encoded header of the block
+	kvHeaderPrefix byte = 'h'
+)
+
+// KVBlockStore is a BlockStore keeping the blocks in a storage.KV, together with their headers so that the block index can be rebuilt without
+// reading the blocks
+type KVBlockStore struct {
+	kv *storage.KV
+}
+
+// OpenKVBlockStore opens (or creates) the block store whose key-value store is at path in fsys
+func OpenKVBlockStore(fsys storage.FS, path string) (*KVBlockStore, error) {
+	kv, err := storage.OpenKV(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return &KVBlockStore{kv: kv}, nil
+}
+
+func kvBlockKey(prefix byte, hash message.Hash256) []byte {
+	return append([]byte{prefix}, hash[:]...)
+}
+
+func (s *KVBlockStore) WriteBlock(hash message.Hash256, block *message.BlockPayload) error {
+	encodedBlock, err := block.Encode()
+	if err != nil {
+		return err
+	}
+	encodedHeader, err := encodeHeader(block)
+	if err != nil {
+		return err
+	}
+	batch := &storage.KVBatch{}
+	batch.Put(kvBlockKey(kvBlockPrefix, hash), encodedBlock)
+	batch.Put(kvBlockKey(kvHeaderPrefix, hash), encodedHeader)
+	return s.kv.Write(batch)
+}
+
+// encodeHeader encodes block without its transactions
+func encodeHeader(block *message.BlockPayload) ([]byte, error) {
+	header := *block
+	header.Transactions = nil
+	return header.Encode()
+}
+
+func (s *KVBlockStore) ReadBlock(hash message.Hash256) (*message.BlockPayload, error) {
+	encoded, err := s.kv.Get(kvBlockKey(kvBlockPrefix, hash))
+	if err != nil {
+		return nil, err
+	}
+	return message.DecodeBlockPayload(bytes.NewReader(encoded))
+}
+
+func (s *KVBlockStore) Headers() ([]message.BlockPayload, error) {
+	return readStoredHeaders(s.kv)
+}
+
+// readStoredHeaders returns the headers stored in kv under kvHeaderPrefix
+func readStoredHeaders(kv *storage.KV) ([]message.BlockPayload, error) {
+	headers := make([]message.BlockPayload, 0)
+	err := kv.Iterate([]byte{kvHeaderPrefix}, func(_ []byte, encoded []byte) error {
+		header, err := message.DecodeBlockPayload(bytes.NewReader(encoded))
+		if err != nil {
+			return err
+		}
+		headers = append(headers, *header)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+func (s *KVBlockStore) Size() (int64, error) {
+	return s.kv.Size(), nil
+}
+
+func (s *KVBlockStore) Close() error {
+	return s.kv.Close()
+}
+
+// SetBlockStore makes the node keep the blocks it accepts in store rather than in memory and in the blocks file. It must be called before
+// Start.
+func (n *Node) SetBlockStore(store BlockStore) {
+	n.blockStore = store
+}
+
+// persistBlock durably records block, which is not stored yet, before it is added to the block index: in the block store if the node has one,
+// or else in the write-ahead log until the blocks file is saved
+func (n *Node) persistBlock(hash message.Hash256, block *message.BlockPayload) error {
+	if n.blockStore != nil {
+		return n.blockStore.WriteBlock(hash, block)
+	}
+	return n.logConnectBlock(block)
+}
+
+// readBlocksFromStore rebuilds the block index from the headers of the blocks in the block store, parents first, only reading the blocks
+// whose parent is not stored. Blocks the index rejects (e.g. because a checkpoint was added since they were stored) are skipped.
+func (n *Node) readBlocksFromStore() error {
+	start := time.Now()
+	headers, err := n.blockStore.Headers()
+	if err != nil {
+		return err
+	}
+	hashes := make([]message.Hash256, len(headers))
+	children := make(map[message.Hash256][]int)
+	for i := range headers {
+		hashes[i], err = headers[i].GetBlockHash()
+		if err != nil {
+			return err
+		}
+		children[headers[i].PrevBlock] = append(children[headers[i].PrevBlock], i)
+	}
+
+	added := make([]bool, len(headers))
+	addBlock := func(i int, block *message.BlockPayload) (bool, error) {
+		added[i] = true
+		_, err := n.blockIndex.AddBlock(block, hashes[i])
+		if errors.Is(err, blockchain.ErrCheckpointMismatch) || errors.Is(err, blockchain.ErrForkBelowCheckpoint) {
+			log.Printf("⚠️ Skipping stored block %s: %s", hashes[i], err)
+			return false, nil
+		}
+		return err == nil, err
+	}
+	// the index reads the data of the blocks whose parent is known from the store, so their headers are enough
+	genesisHash := n.blockIndex.Genesis().Hash
+	for i := range headers {
+		if hashes[i] == genesisHash {
+			_, err = addBlock(i, &headers[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	queue := []message.Hash256{genesisHash}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, i := range children[parent] {
+			ok, err := addBlock(i, &headers[i])
+			if err != nil {
+				return err
+			}
+			if ok {
+				queue = append(queue, hashes[i])
+			}
+		}
+	}
+	// the blocks whose parent is not stored, or was skipped, are kept in memory
+	for i := range headers {
+		if added[i] {
+			continue
+		}
+		block, err := n.blockStore.ReadBlock(hashes[i])
+		if err != nil {
+			return err
+		}
+		_, err = addBlock(i, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the mempool is empty, so only the chainstate needs the data of the connected blocks, which it reads one at a time
+	err = n.updateChainstate(n.blockIndex.ActivateBestChain(), tracing.SpanContext{})
+	if err != nil {
+		return err
+	}
+	log.Printf("💾 Read the headers of %d stored blocks in %s", len(headers), time.Since(start))
+	return nil
+}
@@ -0,0 +1,100 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newBlockStoreNode returns a node keeping its blocks in a block store in fs
+func newBlockStoreNode(t *testing.T, fs storage.FS) *Node {
+	store, err := OpenKVBlockStore(fs, "blocks.kv")
+	require.NoError(t, err)
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	node.SetBlockStore(store)
+	node.blockIndex.SetBlockReader(store)
+	return node
+}
+
+func TestNode_KeepsBlocksInBlockStore(t *testing.T) {
+	fs := storage.NewMemFS()
+	node := newBlockStoreNode(t, fs)
+	blocks, hashes := createSnapshotChain(t, 3)
+	orphans, orphanHashes := createHeaders(t, message.Hash256{0x01}, 1, easyBits, 0)
+	for i, block := range append(blocks, orphans...) {
+		hash := append(hashes, orphanHashes...)[i]
+		require.NoError(t, node.persistBlock(hash, &block))
+		require.NoError(t, node.addBlockToNode(&block))
+	}
+
+	tip := node.blockIndex.Tip()
+	require.Equal(t, hashes[2], tip.Hash)
+	require.Nil(t, tip.Block, "blocks should not be kept in memory")
+	block, err := node.blockIndex.BlockData(tip)
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	expectedTxId, err := blocks[2].Transactions[0].GetTxId()
+	require.NoError(t, err)
+	txId, err := block.Transactions[0].GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, expectedTxId, txId)
+	// only the orphan is kept in memory
+	require.Len(t, node.blockIndex.Blocks(), 1)
+	require.NoError(t, node.Stop(context.Background()))
+
+	restarted := newBlockStoreNode(t, fs)
+	require.NoError(t, restarted.readBlocksFromStore())
+	require.Equal(t, hashes[2], restarted.blockIndex.Tip().Hash)
+	require.Equal(t, 1, restarted.blockIndex.OrphanCount())
+	require.Equal(t, 4, restarted.blockIndex.BlockCount())
+	require.Equal(t, message.Hash256(constants.GenesisBlockHash), restarted.blockIndex.Locator()[3])
+}
+
+func TestBlockFileStore(t *testing.T) {
+	fs := storage.NewMemFS()
+	blocks, hashes := createSnapshotChain(t, 3)
+	encoded, err := blocks[1].Encode()
+	require.NoError(t, err)
+	// two blocks fit in a file
+	maxFileSize := int64(2 * (8 + len(encoded)))
+
+	store, err := OpenBlockFileStore(fs, "blk", constants.MainnetMagicValue, maxFileSize)
+	require.NoError(t, err)
+	for i := range blocks {
+		require.NoError(t, store.WriteBlock(hashes[i], &blocks[i]))
+	}
+	require.NoError(t, store.Close())
+	for _, name := range []string{"blk00000.dat", "blk00001.dat"} {
+		f, err := storage.Open(fs, name)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	store, err = OpenBlockFileStore(fs, "blk", constants.MainnetMagicValue, maxFileSize)
+	require.NoError(t, err)
+	defer store.Close()
+	headers, err := store.Headers()
+	require.NoError(t, err)
+	require.Len(t, headers, 3)
+	for i := range blocks {
+		block, err := store.ReadBlock(hashes[i])
+		require.NoError(t, err)
+		hash, err := block.GetBlockHash()
+		require.NoError(t, err)
+		require.Equal(t, hashes[i], hash)
+		require.Len(t, block.Transactions, len(blocks[i].Transactions))
+	}
+	// the reopened store keeps appending to the last file
+	extra, extraHashes := createHeaders(t, hashes[2], 1, easyBits, 0)
+	require.NoError(t, store.WriteBlock(extraHashes[0], &extra[0]))
+	_, err = storage.Open(fs, "blk00002.dat")
+	require.Error(t, err)
+	block, err := store.ReadBlock(extraHashes[0])
+	require.NoError(t, err)
+	require.Equal(t, extra[0].PrevBlock, block.PrevBlock)
+}
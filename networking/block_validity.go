@@ -0,0 +1,38 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/tracing"
+	"log"
+)
+
+// InvalidateBlock marks the block with hash and its descendants invalid and moves the tip of the active chain to the valid block with the
+// most work, disconnecting the invalidated blocks if they are in it. Headers and blocks following an invalid block are invalid too, so the
+// node does not return to that chain until ReconsiderBlock is called. It is meant for testing how forks are handled and for operators to
+// step away from a chain they do not trust. The marks are only kept in memory, so they are lost when the node restarts.
+func (n *Node) InvalidateBlock(hash message.Hash256) error {
+	if _, ok := n.blockIndex.Get(hash); !ok {
+		return fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	err := n.blockIndex.InvalidateBlock(hash)
+	if err != nil {
+		return err
+	}
+	log.Printf("⛔ Marked block %s and its descendants invalid", hash)
+	return n.activateBestChain(tracing.SpanContext{})
+}
+
+// ReconsiderBlock clears the invalid mark of the block with hash, of its descendants and of its ancestors, set by InvalidateBlock, and moves
+// the tip of the active chain back to the block with the most work
+func (n *Node) ReconsiderBlock(hash message.Hash256) error {
+	if _, ok := n.blockIndex.Get(hash); !ok {
+		return fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	err := n.blockIndex.ReconsiderBlock(hash)
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ Reconsidered block %s and its descendants", hash)
+	return n.activateBestChain(tracing.SpanContext{})
+}
@@ -0,0 +1,32 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_InvalidateAndReconsiderBlock(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	chain, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 3, easyBits, 0)
+	fork, forkHashes := createHeaders(t, hashes[0], 1, easyBits, 100)
+	for i := range chain {
+		require.NoError(t, node.addBlockToNode(&chain[i]))
+	}
+	require.NoError(t, node.addBlockToNode(&fork[0]))
+	require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+
+	require.ErrorIs(t, node.InvalidateBlock(message.Hash256{0x01}), ErrBlockNotFound)
+	require.NoError(t, node.InvalidateBlock(hashes[1]))
+	require.Equal(t, forkHashes[0], node.blockIndex.Tip().Hash)
+	tip, height := node.chainstate.Load().Tip()
+	require.Equal(t, forkHashes[0], tip)
+	require.Equal(t, int32(2), height)
+
+	require.NoError(t, node.ReconsiderBlock(hashes[1]))
+	require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+	tip, _ = node.chainstate.Load().Tip()
+	require.Equal(t, hashes[2], tip)
+}
@@ -0,0 +1,147 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/storage"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// blocksManifest describes the blocks file last saved completely. It is written after the new blocks file is durably written next to the
+// current one and before it replaces it, so that the node can tell on restart whether a save was interrupted and which of the two files holds
+// the saved blocks.
+type blocksManifest struct {
+	Blocks int    `json:"blocks"`
+	Size   int64  `json:"size"`
+	CRC32C uint32 `json:"crc32c"`
+}
+
+func (n *Node) blocksManifestPath() string {
+	return n.blocksFileDirectory + ".manifest"
+}
+
+// blocksFileTmpPath returns the path the blocks file is written to before it replaces the current one, in the same directory so that the
+// rename does not cross file systems
+func (n *Node) blocksFileTmpPath() string {
+	return n.blocksFileDirectory + ".tmp"
+}
+
+// readBlocksManifest returns the manifest of the blocks file, or nil if there is none (e.g. the blocks file was saved by an older version)
+func (n *Node) readBlocksManifest() (*blocksManifest, error) {
+	f, err := storage.Open(n.fs, n.blocksManifestPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	encoded, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &blocksManifest{}
+	err = json.Unmarshal(encoded, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// fileChecksum returns the size and the CRC32C checksum of the file at path
+func (n *Node) fileChecksum(path string) (int64, uint32, error) {
+	f, err := storage.Open(n.fs, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	checksum := crc32.New(castagnoli)
+	size, err := io.Copy(checksum, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, checksum.Sum32(), nil
+}
+
+// matchesManifest reports whether the file at path exists and has the size and checksum recorded in manifest
+func (n *Node) matchesManifest(path string, manifest *blocksManifest) (bool, error) {
+	size, checksum, err := n.fileChecksum(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return size == manifest.Size && checksum == manifest.CRC32C, nil
+}
+
+// recoverBlocksFile finishes or discards a save of the blocks file that was interrupted by a crash, and checks that the blocks file is the one
+// last saved. A blocks file that is not is still read, as its damaged records are found and dropped by readBlocksFromDisk.
+func (n *Node) recoverBlocksFile() error {
+	manifest, err := n.readBlocksManifest()
+	if err != nil || manifest == nil {
+		return err
+	}
+	tmpPath := n.blocksFileTmpPath()
+	// the manifest is written before the new blocks file is renamed, so the rename did not happen
+	ok, err := n.matchesManifest(tmpPath, manifest)
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.Printf("💾 Completing the interrupted save of %d blocks to file %s", manifest.Blocks, n.blocksFileDirectory)
+		return n.fs.Rename(tmpPath, n.blocksFileDirectory)
+	}
+	err = n.fs.Remove(tmpPath)
+	if err == nil {
+		log.Printf("⚠️ Discarded the incomplete blocks file %s", tmpPath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	ok, err = n.matchesManifest(n.blocksFileDirectory, manifest)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Printf("⚠️ Blocks file %s does not match its manifest (%d blocks in %d bytes with checksum %08x). Checking every block in it...",
+			n.blocksFileDirectory, manifest.Blocks, manifest.Size, manifest.CRC32C)
+	}
+	return nil
+}
+
+// checkpointBlocks saves the blocks file and then empties the write-ahead log of the blocks it now holds, bounding how much of the log is
+// replayed when the node restarts
+func (n *Node) checkpointBlocks() error {
+	n.checkpointMu.Lock()
+	defer n.checkpointMu.Unlock()
+
+	err := n.saveBlocksToDisk()
+	if err != nil {
+		return err
+	}
+	n.uncheckpointedBlocks.Store(0)
+	if n.wal == nil {
+		return nil
+	}
+	// blocks replayed from the log if the node crashes before it is truncated are already known, so they are skipped
+	return n.wal.Truncate()
+}
+
+// checkpointBlocksIfNeeded checkpoints the blocks file if blocks were accepted since the last checkpoint
+func (n *Node) checkpointBlocksIfNeeded() {
+	if n.blockStore != nil || n.uncheckpointedBlocks.Load() == 0 {
+		return
+	}
+	err := n.checkpointBlocks()
+	if err != nil {
+		log.Printf("⚠️ Could not checkpoint blocks due to error: %s", err)
+		return
+	}
+	log.Printf("💾 Checkpointed blocks to file %s", n.blocksFileDirectory)
+}
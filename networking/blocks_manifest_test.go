@@ -0,0 +1,75 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newBlocksFileNode returns a node saving its blocks to the blocks file in fs
+func newBlocksFileNode(t *testing.T, fs storage.FS) *Node {
+	config := testConfig(fs)
+	config.MinimumPeers = 1
+	config.DialTimeout, config.GetAddrWait = time.Second, time.Second
+	node := NewNode(config)
+	skipProofOfWork(node)
+	return node
+}
+
+func TestNode_CheckpointsBlocksFile(t *testing.T) {
+	fs := storage.NewMemFS()
+	node := newBlocksFileNode(t, fs)
+	require.NoError(t, node.readBlocksFile())
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks[:2] {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	require.EqualValues(t, 2, node.uncheckpointedBlocks.Load())
+	node.checkpointBlocksIfNeeded()
+	require.Zero(t, node.uncheckpointedBlocks.Load())
+	// the last block is only in the write-ahead log when the node crashes
+	require.NoError(t, node.persistBlock(hashes[2], &blocks[2]))
+	require.NoError(t, node.addBlockToNode(&blocks[2]))
+	require.NoError(t, node.wal.Close())
+
+	restarted := newBlocksFileNode(t, fs)
+	require.NoError(t, restarted.readBlocksFile())
+	require.Equal(t, hashes[2], restarted.blockIndex.Tip().Hash)
+	require.EqualValues(t, 1, restarted.uncheckpointedBlocks.Load())
+	require.NoError(t, restarted.checkpointBlocks())
+	require.NoError(t, restarted.wal.Close())
+
+	t.Run("interrupted before the rename", func(t *testing.T) {
+		require.NoError(t, fs.Rename("blocks.dat", "blocks.dat.tmp"))
+		node := newBlocksFileNode(t, fs)
+		require.NoError(t, node.readBlocksFile())
+		require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+		require.NoError(t, node.wal.Close())
+	})
+
+	t.Run("interrupted before the manifest", func(t *testing.T) {
+		require.NoError(t, storage.WriteFileAtomic(fs, "blocks.dat.tmp", []byte{0x01}))
+		node := newBlocksFileNode(t, fs)
+		require.NoError(t, node.readBlocksFile())
+		require.Equal(t, hashes[2], node.blockIndex.Tip().Hash)
+		require.NoError(t, node.wal.Close())
+		_, err := storage.Open(fs, "blocks.dat.tmp")
+		require.Error(t, err)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		flipLastByte(t, fs, "blocks.dat")
+		node := newBlocksFileNode(t, fs)
+		require.NoError(t, node.readBlocksFile())
+		require.Equal(t, hashes[1], node.blockIndex.Tip().Hash, "the damaged block should be dropped")
+		require.NoError(t, node.wal.Close())
+		manifest, err := node.readBlocksManifest()
+		require.NoError(t, err)
+		require.Equal(t, 2, manifest.Blocks)
+		ok, err := node.matchesManifest("blocks.dat", manifest)
+		require.NoError(t, err)
+		require.True(t, ok, "the manifest should describe the truncated file")
+	})
+}
@@ -0,0 +1,198 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/aang114/bitcoin-node/message"
+	"math/bits"
+	"sync"
+)
+
+// Script opcodes needed to find the data pushed by scripts (https://en.bitcoin.it/wiki/Script#Constants)
+const (
+	opPushData1     = 0x4c
+	opPushData2     = 0x4d
+	opPushData4     = 0x4e
+	opCheckSig      = 0xac
+	opCheckMultiSig = 0xae
+)
+
+// bloomFilter is the BIP 37 bloom filter a peer set on its connection with a filterload message
+type bloomFilter struct {
+	mu        sync.Mutex
+	filter    []byte
+	hashFuncs uint32
+	tweak     uint32
+	flags     message.BloomUpdateFlags
+}
+
+func newBloomFilter(p *message.FilterLoadPayload) *bloomFilter {
+	return &bloomFilter{
+		filter:    bytes.Clone(p.Filter),
+		hashFuncs: p.HashFuncs,
+		tweak:     p.Tweak,
+		flags:     p.Flags,
+	}
+}
+
+// bitIndex returns the bit of the filter that the hashNum-th hash function maps data to (https://github.com/bitcoin/bitcoin/blob/v27.0/src/common/bloom.cpp#L40-L44)
+func (b *bloomFilter) bitIndex(hashNum uint32, data []byte) uint32 {
+	return murmur3(hashNum*0xFBA4C795+b.tweak, data) % uint32(len(b.filter)*8)
+}
+
+func (b *bloomFilter) add(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.insert(data)
+}
+
+func (b *bloomFilter) insert(data []byte) {
+	if len(b.filter) == 0 {
+		return
+	}
+	for i := range b.hashFuncs {
+		index := b.bitIndex(i, data)
+		b.filter[index>>3] |= 1 << (index & 7)
+	}
+}
+
+func (b *bloomFilter) contains(data []byte) bool {
+	if len(b.filter) == 0 {
+		return false
+	}
+	for i := range b.hashFuncs {
+		index := b.bitIndex(i, data)
+		if b.filter[index>>3]&(1<<(index&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesTx reports whether tx matches the filter, i.e. whether the filter contains its txid, data pushed by one of its output scripts, one of the
+// outpoints it spends or data pushed by one of its input scripts. Depending on the filter's flags, the outpoints of the matching outputs are added
+// to the filter, so that the transactions spending them match too (https://github.com/bitcoin/bitcoin/blob/v27.0/src/common/bloom.cpp#L94-L149).
+func (b *bloomFilter) matchesTx(tx *message.TxPayload, txId message.Hash256) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	found := b.contains(txId[:])
+	for i, txOut := range tx.TransactionOutputs {
+		for _, data := range scriptPushes(txOut.PkScript) {
+			if len(data) == 0 || !b.contains(data) {
+				continue
+			}
+			found = true
+			if b.flags == message.BloomUpdateAll || (b.flags == message.BloomUpdateP2PubkeyOnly && isP2PubkeyOrMultisig(txOut.PkScript)) {
+				b.insert(encodeOutPoint(txId, uint32(i)))
+			}
+			break
+		}
+	}
+	if found {
+		return true
+	}
+
+	for _, txIn := range tx.TransactionInputs {
+		if b.contains(encodeOutPoint(txIn.PreviousOutput.Hash, txIn.PreviousOutput.Index)) {
+			return true
+		}
+		for _, data := range scriptPushes(txIn.SignatureScript) {
+			if len(data) != 0 && b.contains(data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func encodeOutPoint(hash message.Hash256, index uint32) []byte {
+	return binary.LittleEndian.AppendUint32(bytes.Clone(hash[:]), index)
+}
+
+// scriptPushes returns the data pushed by script, stopping at the first malformed push
+func scriptPushes(script []byte) [][]byte {
+	pushes := make([][]byte, 0)
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+		var length int
+		switch {
+		case opcode < opPushData1:
+			length = int(opcode)
+		case opcode == opPushData1 && i+1 <= len(script):
+			length = int(script[i])
+			i++
+		case opcode == opPushData2 && i+2 <= len(script):
+			length = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case opcode == opPushData4 && i+4 <= len(script):
+			length = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		case opcode >= opPushData1 && opcode <= opPushData4:
+			return pushes
+		default:
+			continue
+		}
+		if length < 0 || length > len(script)-i {
+			return pushes
+		}
+		pushes = append(pushes, script[i:i+length])
+		i += length
+	}
+	return pushes
+}
+
+// isP2PubkeyOrMultisig reports whether script is a pay-to-pubkey or a bare multisig script
+func isP2PubkeyOrMultisig(script []byte) bool {
+	if len(script) == 0 {
+		return false
+	}
+	switch script[len(script)-1] {
+	case opCheckSig:
+		// <33 or 65 byte pubkey> OP_CHECKSIG
+		return (len(script) == 35 && script[0] == 33) || (len(script) == 67 && script[0] == 65)
+	case opCheckMultiSig:
+		return true
+	}
+	return false
+}
+
+// murmur3 is the 32-bit MurmurHash3 hash function (https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp)
+func murmur3(seed uint32, data []byte) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	blocks := len(data) / 4
+	for i := range blocks {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	tail := data[blocks*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,62 @@
+package networking
+
+import (
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBloomFilter_Contains(t *testing.T) {
+	// filters holding the same three elements, serialized by bitcoind's bloom_create_insert_serialize tests (https://github.com/bitcoin/bitcoin/blob/v27.0/src/test/bloom_tests.cpp)
+	for _, tc := range []struct {
+		filter string
+		tweak  uint32
+	}{
+		{filter: "614e9b", tweak: 0},
+		{filter: "ce4299", tweak: 2147483649},
+	} {
+		filter, err := hex.DecodeString(tc.filter)
+		require.NoError(t, err)
+		bloom := newBloomFilter(&message.FilterLoadPayload{Filter: filter, HashFuncs: 5, Tweak: tc.tweak, Flags: message.BloomUpdateAll})
+
+		for _, element := range []string{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", "b5a2c786d9ef4658287ced5914b37a1b4aa32eee", "b9300670b4c5366e95b2699e8b18bc75e5f729c5"} {
+			data, err := hex.DecodeString(element)
+			require.NoError(t, err)
+			require.True(t, bloom.contains(data), element)
+		}
+		data, err := hex.DecodeString("19108ad8ed9bb6274d3980bab5a85c048f0950c8")
+		require.NoError(t, err)
+		require.False(t, bloom.contains(data))
+	}
+}
+
+func TestBloomFilter_MatchesTx(t *testing.T) {
+	pubKeyHash, err := hex.DecodeString("99108ad8ed9bb6274d3980bab5a85c048f0950c8")
+	require.NoError(t, err)
+	// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+	pkScript := append(append([]byte{0x76, 0xa9, 0x14}, pubKeyHash...), 0x88, 0xac)
+	funding := newTestTx(message.Hash256{0x01}, 1000, pkScript)
+	fundingTxId, err := funding.GetTxId()
+	require.NoError(t, err)
+	spending := newTestTx(fundingTxId, 900, []byte{0x51})
+	spendingTxId, err := spending.GetTxId()
+	require.NoError(t, err)
+	unrelated := newTestTx(message.Hash256{0x02}, 1000, []byte{0x51})
+	unrelatedTxId, err := unrelated.GetTxId()
+	require.NoError(t, err)
+
+	bloom := newBloomFilter(&message.FilterLoadPayload{Filter: make([]byte, 64), HashFuncs: 5, Flags: message.BloomUpdateAll})
+	bloom.add(pubKeyHash)
+
+	require.True(t, bloom.matchesTx(funding, fundingTxId))
+	// the outpoint of the matching output was added, so the transaction spending it matches too
+	require.True(t, bloom.matchesTx(spending, spendingTxId))
+	require.False(t, bloom.matchesTx(unrelated, unrelatedTxId))
+}
+
+func TestScriptPushes(t *testing.T) {
+	// OP_0 <2 bytes> OP_DUP OP_PUSHDATA1 <3 bytes> OP_PUSHDATA2 <1 byte> <push running past the end>
+	script := []byte{0x00, 0x02, 0xaa, 0xbb, 0x76, 0x4c, 0x03, 0x01, 0x02, 0x03, 0x4d, 0x01, 0x00, 0xcc, 0x05, 0x01}
+	require.Equal(t, [][]byte{{}, {0xaa, 0xbb}, {0x01, 0x02, 0x03}, {0xcc}}, scriptPushes(script))
+}
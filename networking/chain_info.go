@@ -0,0 +1,116 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"io/fs"
+)
+
+// ChainInfo describes the active chain of the node (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L1272-L1320)
+type ChainInfo struct {
+	// Name of the network the node joined (see constants.NetworkParams)
+	Chain string `json:"chain"`
+	// Height and hash of the tip of the active chain
+	Height        int32  `json:"height"`
+	BestBlockHash string `json:"bestBlockHash"`
+	// Height of the best known header
+	Headers int32 `json:"headers"`
+	// Total work of the active chain, as 64 hex digits
+	ChainWork string `json:"chainWork"`
+	// Difficulty of the tip's target, relative to the proof of work limit
+	Difficulty float64 `json:"difficulty"`
+	// Timestamp of the tip, and median timestamp of the tip and the 10 blocks preceding it
+	Time       uint32 `json:"time"`
+	MedianTime uint32 `json:"medianTime"`
+	// Estimated fraction of the chain that is connected, from 0 to 1: the height of the tip over the height of the best known header
+	VerificationProgress float64 `json:"verificationProgress"`
+	// Whether the node is still catching up with the chain (see Node.IsInitialBlockDownload)
+	InitialBlockDownload bool `json:"initialBlockDownload"`
+	// Whether old blocks were deleted (the node keeps every block)
+	Pruned bool `json:"pruned"`
+	// Bytes the stored blocks take on disk
+	SizeOnDisk int64 `json:"sizeOnDisk"`
+}
+
+// ChainInfo returns the state of the active chain
+func (n *Node) ChainInfo() (ChainInfo, error) {
+	tip := n.blockIndex.Tip()
+	difficulty, err := message.Difficulty(tip.Bits)
+	if err != nil {
+		return ChainInfo{}, err
+	}
+	medianTime, ok := n.blockIndex.MedianTimePast(tip.Hash)
+	if !ok {
+		return ChainInfo{}, fmt.Errorf("tip %s is not in the block index", tip.Hash)
+	}
+	size, err := n.blocksSizeOnDisk()
+	if err != nil {
+		return ChainInfo{}, err
+	}
+	headers := n.blockIndex.BestHeight()
+	progress := 1.0
+	if headers > 0 {
+		progress = float64(tip.Height) / float64(headers)
+	}
+	return ChainInfo{
+		Chain:                n.params.Name,
+		Height:               tip.Height,
+		BestBlockHash:        tip.Hash.String(),
+		Headers:              headers,
+		ChainWork:            fmt.Sprintf("%064x", tip.ChainWork),
+		Difficulty:           difficulty,
+		Time:                 tip.Timestamp,
+		MedianTime:           medianTime,
+		VerificationProgress: min(progress, 1),
+		InitialBlockDownload: n.IsInitialBlockDownload(),
+		SizeOnDisk:           size,
+	}, nil
+}
+
+// ChainTip describes the last block of a chain known to the node (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp)
+type ChainTip struct {
+	Height int32  `json:"height"`
+	Hash   string `json:"hash"`
+	// Number of blocks of the chain following the block it shares with the active chain
+	BranchLen int32 `json:"branchlen"`
+	// "active", "valid-fork", "invalid" or "headers-only" (see blockchain.ChainTipStatus)
+	Status string `json:"status"`
+}
+
+// ChainTips returns the tip of the active chain followed by the last blocks of the forks the node knows of, highest first, so that forks can
+// be watched
+func (n *Node) ChainTips() []ChainTip {
+	tips := n.blockIndex.ChainTips()
+	chainTips := make([]ChainTip, len(tips))
+	for i, tip := range tips {
+		chainTips[i] = ChainTip{
+			Height:    tip.Block.Height,
+			Hash:      tip.Block.Hash.String(),
+			BranchLen: tip.BranchLen,
+			Status:    string(tip.Status),
+		}
+	}
+	return chainTips
+}
+
+// blocksSizeOnDisk returns the size of the block store, or of the blocks file and its write-ahead log if the node has no block store
+func (n *Node) blocksSizeOnDisk() (int64, error) {
+	if n.blockStore != nil {
+		return n.blockStore.Size()
+	}
+	var total int64
+	for _, name := range []string{n.blocksFileDirectory, n.chainstateWALPath()} {
+		size, err := storage.Size(n.fs, name)
+		// the blocks file is only written when the node checkpoints its blocks
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
@@ -0,0 +1,55 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_ChainInfo(t *testing.T) {
+	node := newBlockStoreNode(t, storage.NewMemFS())
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+	}
+	require.NoError(t, node.addBlockToNode(&blocks[0]))
+	require.NoError(t, node.addBlockToNode(&blocks[1]))
+	_, err := node.blockIndex.AddHeaders(blocks[2:])
+	require.NoError(t, err)
+
+	info, err := node.ChainInfo()
+	require.NoError(t, err)
+	require.Equal(t, "mainnet", info.Chain)
+	require.EqualValues(t, 2, info.Height)
+	require.Equal(t, hashes[1].String(), info.BestBlockHash)
+	require.EqualValues(t, 3, info.Headers)
+	require.Len(t, info.ChainWork, 64)
+	require.InDelta(t, 2.0/3, info.VerificationProgress, 1e-9)
+	// the timestamps of the genesis block (unknown until its data is stored) and of the first two blocks
+	require.Equal(t, blocks[0].Timestamp, info.MedianTime)
+	require.Equal(t, blocks[1].Timestamp, info.Time)
+	require.True(t, info.InitialBlockDownload, "the blocks of the test chain are old")
+	require.Greater(t, info.Difficulty, 0.0)
+	require.False(t, info.Pruned)
+	require.Positive(t, info.SizeOnDisk)
+}
+
+func TestNode_ChainTips(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	chain, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 2, easyBits, 0)
+	fork, forkHashes := createHeaders(t, hashes[0], 1, easyBits, 100)
+	for i := range chain {
+		require.NoError(t, node.addBlockToNode(&chain[i]))
+	}
+	_, err := node.blockIndex.AddHeaders(fork)
+	require.NoError(t, err)
+
+	require.Equal(t, []ChainTip{
+		{Height: 2, Hash: hashes[1].String(), BranchLen: 0, Status: "active"},
+		{Height: 2, Hash: forkHashes[0].String(), BranchLen: 1, Status: "headers-only"},
+	}, node.ChainTips())
+}
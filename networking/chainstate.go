@@ -0,0 +1,76 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/utxo"
+	"log"
+)
+
+// SetUTXODatabase makes the node keep the unspent outputs of the active chain in db, caching up to about maxCacheBytes of them in memory. The
+// chainstate resumes from the block it was last flushed at, so the blocks up to it must be stored. It must be called after SetNetworkParams
+// and before Start.
+func (n *Node) SetUTXODatabase(db *utxo.DB, maxCacheBytes int) error {
+	chainstate, err := utxo.OpenChainstate(db, n.blockIndex.Genesis().Hash, maxCacheBytes)
+	if err != nil {
+		return err
+	}
+	n.configureChainstate(chainstate)
+	n.utxoDB = db
+	n.chainstate.Store(chainstate)
+	return nil
+}
+
+// configureChainstate makes chainstate validate the blocks it connects the way the node does: with its validation workers, the soft forks of its
+// network, and without running the scripts of the blocks buried by a checkpoint
+func (n *Node) configureChainstate(chainstate *utxo.Chainstate) {
+	chainstate.SetValidationWorkers(n.tuning.ValidationWorkers)
+	chainstate.SetDeployments(n.params.Deployments)
+	chainstate.SetSkipScripts(n.blockIndex.BuriedByCheckpoint)
+}
+
+// unspentOutput returns the unspent output of the active chain outpoint refers to, if there is one
+func (n *Node) unspentOutput(outpoint message.OutPoint) (utxo.Coin, bool, error) {
+	return n.chainstate.Load().Coins().Get(outpoint)
+}
+
+// tipHeight returns the height of the tip of the active chain
+func (n *Node) tipHeight() int32 {
+	_, height := n.chainstate.Load().Tip()
+	return height
+}
+
+// flushChainstate writes the cached unspent outputs to the chainstate database
+func (n *Node) flushChainstate() {
+	if n.utxoDB == nil {
+		return
+	}
+	chainstate := n.chainstate.Load()
+	err := chainstate.Flush()
+	if err != nil {
+		log.Printf("⚠️ Could not flush the chainstate due to error: %s", err)
+		return
+	}
+	tip, height := chainstate.Tip()
+	log.Printf("💾 Flushed %d unspent outputs at block %s (height %d)", chainstate.Coins().Len(), tip, height)
+}
+
+// closeChainstate flushes the chainstate and closes its database
+func (n *Node) closeChainstate() error {
+	if n.utxoDB == nil {
+		return nil
+	}
+	chainstate := n.chainstate.Load()
+	err := chainstate.Flush()
+	if err != nil {
+		_ = n.utxoDB.Close()
+		return fmt.Errorf("could not flush the chainstate: %w", err)
+	}
+	tip, height := chainstate.Tip()
+	log.Printf("💾 Flushed %d unspent outputs at block %s (height %d)", chainstate.Coins().Len(), tip, height)
+	err = n.utxoDB.Close()
+	if err != nil {
+		return fmt.Errorf("could not close the chainstate database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// setUTXODatabase makes node keep its unspent outputs in the chainstate database in fs
+func setUTXODatabase(t *testing.T, node *Node, fs storage.FS) {
+	db, err := utxo.OpenDB(fs, "chainstate.kv")
+	require.NoError(t, err)
+	require.NoError(t, node.SetUTXODatabase(db, 1024*1024))
+}
+
+func TestNode_ResumesChainstateFromDatabase(t *testing.T) {
+	fs := storage.NewMemFS()
+	node := newBlockStoreNode(t, fs)
+	setUTXODatabase(t, node, fs)
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	require.Equal(t, 3, node.chainstate.Load().Coins().Len())
+	require.NoError(t, node.Stop(context.Background()))
+
+	restarted := newBlockStoreNode(t, fs)
+	setUTXODatabase(t, restarted, fs)
+	tip, height := restarted.chainstate.Load().Tip()
+	require.Equal(t, hashes[2], tip)
+	require.EqualValues(t, 3, height)
+	require.NoError(t, restarted.readBlocksFromStore())
+	require.Equal(t, 3, restarted.chainstate.Load().Coins().Len())
+
+	coinbase, err := blocks[0].Transactions[0].GetTxId()
+	require.NoError(t, err)
+	_, ok, err := restarted.chainstate.Load().Coins().Get(message.OutPoint{Hash: coinbase})
+	require.NoError(t, err)
+	require.True(t, ok)
+}
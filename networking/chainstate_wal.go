@@ -0,0 +1,78 @@
+package networking
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+)
+
+// Operations recorded in the chainstate write-ahead log. Every entry starts with its operation byte.
+const (
+	// Entry data is the encoded block that was connected
+	walOpConnectBlock byte = 1
+)
+
+var ErrUnknownWALOperation = errors.New("unknown wal operation")
+
+func encodeConnectBlockWALEntry(block *message.BlockPayload) ([]byte, error) {
+	encoded, err := block.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{walOpConnectBlock}, encoded...), nil
+}
+
+func (n *Node) chainstateWALPath() string {
+	return n.blocksFileDirectory + ".wal"
+}
+
+// logConnectBlock durably records that block is about to be connected, before the in-memory chainstate is changed
+func (n *Node) logConnectBlock(block *message.BlockPayload) error {
+	if n.wal == nil {
+		return nil
+	}
+	entry, err := encodeConnectBlockWALEntry(block)
+	if err != nil {
+		return err
+	}
+	err = n.wal.Append([][]byte{entry})
+	if err != nil {
+		return err
+	}
+	n.uncheckpointedBlocks.Add(1)
+	return nil
+}
+
+// replayChainstateWAL re-applies the mutations that were logged after the blocks file was last saved, which are lost from it if the node
+// did not shut down cleanly. Every batch in the log covers a whole block, so replaying leaves the chainstate at a block boundary.
+func (n *Node) replayChainstateWAL() (int, error) {
+	replayed := 0
+	err := n.wal.Replay(func(batch [][]byte) error {
+		for _, entry := range batch {
+			if len(entry) == 0 {
+				return ErrUnknownWALOperation
+			}
+			switch entry[0] {
+			case walOpConnectBlock:
+				block, err := message.DecodeBlockPayload(bytes.NewReader(entry[1:]))
+				if err != nil {
+					return err
+				}
+				err = n.addBlockToNode(block)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%w: %d", ErrUnknownWALOperation, entry[0])
+			}
+		}
+		replayed++
+		return nil
+	})
+	if replayed > 0 {
+		log.Printf("💾 Replayed %d batches from %s", replayed, n.chainstateWALPath())
+	}
+	return replayed, err
+}
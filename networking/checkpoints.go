@@ -0,0 +1,131 @@
+package networking
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"slices"
+	"sync"
+)
+
+type ErrCheckpointMismatch struct {
+	Height   int32
+	Expected message.Hash256
+	Actual   message.Hash256
+}
+
+func (e *ErrCheckpointMismatch) Error() string {
+	return fmt.Sprintf("block at height %d is %s but checkpoint is %s", e.Height, e.Actual, e.Expected)
+}
+
+// parseCheckpoints converts checkpoints given as big-endian hexadecimal strings into little-endian hashes
+func parseCheckpoints(checkpoints map[int32]string) (map[int32]message.Hash256, error) {
+	parsed := make(map[int32]message.Hash256, len(checkpoints))
+	for height, hashStr := range checkpoints {
+		hash, err := parseHash(hashStr)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint at height %d: %w", height, err)
+		}
+		parsed[height] = hash
+	}
+	return parsed, nil
+}
+
+// parseHash converts a hash given as a big-endian hexadecimal string into a little-endian hash
+func parseHash(hashStr string) (message.Hash256, error) {
+	hashBytes, err := hex.DecodeString(hashStr)
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	if len(hashBytes) != len(message.Hash256{}) {
+		return message.Hash256{}, fmt.Errorf("hash has invalid length %d", len(hashBytes))
+	}
+	slices.Reverse(hashBytes)
+	return message.Hash256(hashBytes), nil
+}
+
+// hashBlocks hashes the blocks using the given number of goroutines
+func hashBlocks(blocks []*message.BlockPayload, workers int) ([]message.Hash256, error) {
+	workers = max(workers, 1)
+	hashes := make([]message.Hash256, len(blocks))
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(blocks); i += workers {
+				hash, err := blocks[i].GetBlockHash()
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				hashes[i] = hash
+			}
+		}()
+	}
+	wg.Wait()
+	return hashes, errors.Join(errs...)
+}
+
+// verifyCheckpoints spot-checks stored blocks against the checkpoints.
+//
+// Heights are derived by following each block's PrevBlock link back to the genesis block of the network, whose hash is genesisHash, without
+// doing any PoW checks, so this is cheap enough to run on every startup. Any block that sits at a checkpointed height must have the
+// checkpointed hash. Blocks whose ancestry is not (yet) connected to the genesis block are skipped, including the genesis blocks of other
+// networks, whose PrevBlock is zero too. The blocks are hashed by the given number of workers.
+func verifyCheckpoints(blocks []*message.BlockPayload, genesisHash message.Hash256, checkpoints map[int32]message.Hash256, workers int) error {
+	if len(checkpoints) == 0 {
+		return nil
+	}
+
+	hashes, err := hashBlocks(blocks, workers)
+	if err != nil {
+		return err
+	}
+	prevBlocks := make(map[message.Hash256]message.Hash256, len(blocks))
+	for i, block := range blocks {
+		prevBlocks[hashes[i]] = block.PrevBlock
+	}
+
+	// -1 marks blocks whose ancestry is not connected to the genesis block
+	heights := make(map[message.Hash256]int32, len(blocks))
+	heightOf := func(hash message.Hash256) int32 {
+		var path []message.Hash256
+		height := int32(-1)
+		for {
+			if h, ok := heights[hash]; ok {
+				height = h
+				break
+			}
+			if hash == genesisHash {
+				heights[hash] = 0
+				height = 0
+				break
+			}
+			prevBlock, ok := prevBlocks[hash]
+			if !ok {
+				break
+			}
+			path = append(path, hash)
+			hash = prevBlock
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			if height >= 0 {
+				height++
+			}
+			heights[path[i]] = height
+		}
+		return height
+	}
+
+	for _, blockHash := range hashes {
+		height := heightOf(blockHash)
+		if expected, ok := checkpoints[height]; ok && expected != blockHash {
+			return &ErrCheckpointMismatch{Height: height, Expected: expected, Actual: blockHash}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func createChain(t *testing.T, length int) ([]*message.BlockPayload, []message.Hash256) {
+	blocks := make([]*message.BlockPayload, length)
+	hashes := make([]message.Hash256, length)
+	prevBlock := message.Hash256{}
+	for i := range length {
+		blocks[i] = &message.BlockPayload{Version: 1, PrevBlock: prevBlock, Nonce: uint32(i)}
+		hash, err := blocks[i].GetBlockHash()
+		require.NoError(t, err)
+		hashes[i] = hash
+		prevBlock = hash
+	}
+	return blocks, hashes
+}
+
+func TestParseCheckpoints(t *testing.T) {
+	checkpoints, err := parseCheckpoints(constants.Checkpoints)
+	require.NoError(t, err)
+	require.Len(t, checkpoints, len(constants.Checkpoints))
+	require.Equal(t, constants.Checkpoints[295000], checkpoints[295000].String())
+}
+
+func TestVerifyCheckpoints(t *testing.T) {
+	blocks, hashes := createChain(t, 5)
+
+	t.Run("matching checkpoints should pass", func(t *testing.T) {
+		// blocks stored out of order should not matter
+		unordered := []*message.BlockPayload{blocks[3], blocks[0], blocks[4], blocks[2], blocks[1]}
+		err := verifyCheckpoints(unordered, hashes[0], map[int32]message.Hash256{0: hashes[0], 2: hashes[2], 4: hashes[4]}, 4)
+		require.NoError(t, err)
+	})
+
+	t.Run("mismatching checkpoint should fail", func(t *testing.T) {
+		err := verifyCheckpoints(blocks, hashes[0], map[int32]message.Hash256{3: hashes[2]}, 4)
+		mismatch := &ErrCheckpointMismatch{}
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, int32(3), mismatch.Height)
+		require.Equal(t, hashes[3], mismatch.Actual)
+	})
+
+	t.Run("blocks disconnected from genesis should be skipped", func(t *testing.T) {
+		err := verifyCheckpoints(blocks[2:], hashes[0], map[int32]message.Hash256{0: hashes[2]}, 4)
+		require.NoError(t, err)
+	})
+
+	t.Run("blocks descending from the genesis block of another network should be skipped", func(t *testing.T) {
+		// a chain of 6 blocks whose first block, like a genesis block, has a zero PrevBlock
+		other, otherHashes := createChain(t, 6)
+		for i := range other {
+			other[i].Version = 2
+			if i > 0 {
+				other[i].PrevBlock = otherHashes[i-1]
+			}
+			hash, err := other[i].GetBlockHash()
+			require.NoError(t, err)
+			otherHashes[i] = hash
+		}
+		err := verifyCheckpoints(append(other, blocks...), hashes[0], map[int32]message.Hash256{3: hashes[3], 5: hashes[4]}, 4)
+		require.NoError(t, err, "only the blocks of the network's chain should be given heights")
+
+		err = verifyCheckpoints(append(other, blocks...), otherHashes[0], map[int32]message.Hash256{3: hashes[3]}, 4)
+		mismatch := &ErrCheckpointMismatch{}
+		require.ErrorAs(t, err, &mismatch)
+		require.Equal(t, otherHashes[3], mismatch.Actual)
+	})
+}
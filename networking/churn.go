@@ -0,0 +1,196 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Upper bounds in seconds of the buckets of the connection duration histograms (the last bucket holds the longer connections)
+var ConnectionDurationBuckets = []float64{10, 60, 300, 1800, 3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600}
+
+// Number of hourly intervals kept in the churn history
+const churnHistoryLength = 7 * 24
+
+// ConnectionDurations is a histogram of how long closed connections lasted
+type ConnectionDurations struct {
+	// Counts[i] is the number of connections that lasted longer than ConnectionDurationBuckets[i-1] and at most ConnectionDurationBuckets[i]
+	Counts []uint64 `json:"counts"`
+	// total duration in seconds
+	Sum   float64 `json:"sum"`
+	Count uint64  `json:"count"`
+}
+
+func (d *ConnectionDurations) observe(duration time.Duration) {
+	if len(d.Counts) != len(ConnectionDurationBuckets)+1 {
+		d.Counts = make([]uint64, len(ConnectionDurationBuckets)+1)
+	}
+	seconds := duration.Seconds()
+	bucket, _ := slices.BinarySearch(ConnectionDurationBuckets, seconds)
+	d.Counts[bucket]++
+	d.Sum += seconds
+	d.Count++
+}
+
+// ChurnInterval counts the connections opened and closed during an hour
+type ChurnInterval struct {
+	Start  time.Time `json:"start"`
+	Opened uint64    `json:"opened"`
+	Closed uint64    `json:"closed"`
+}
+
+// PeerChurn is the history of the node's connections, which is kept across restarts
+type PeerChurn struct {
+	Opened    map[Direction]uint64              `json:"opened"`
+	Closed    map[Direction]uint64              `json:"closed"`
+	Durations map[Direction]ConnectionDurations `json:"durations"`
+	// hourly counts of the last week, oldest first (hours without any connection opened or closed are left out)
+	History []ChurnInterval `json:"history"`
+}
+
+type churnTracker struct {
+	mu    sync.Mutex
+	churn PeerChurn
+}
+
+func newChurnTracker() *churnTracker {
+	return &churnTracker{churn: PeerChurn{
+		Opened:    make(map[Direction]uint64),
+		Closed:    make(map[Direction]uint64),
+		Durations: make(map[Direction]ConnectionDurations),
+	}}
+}
+
+// interval returns the history's interval containing now, adding it if necessary. c.mu must be held.
+func (c *churnTracker) interval(now time.Time) *ChurnInterval {
+	start := now.Truncate(time.Hour)
+	history := c.churn.History
+	if len(history) == 0 || !history[len(history)-1].Start.Equal(start) {
+		history = append(history, ChurnInterval{Start: start})
+	}
+	// forget the intervals that are more than a week old
+	cutoff := start.Add(-(churnHistoryLength - 1) * time.Hour)
+	for len(history) > 0 && history[0].Start.Before(cutoff) {
+		history = history[1:]
+	}
+	c.churn.History = history
+	return &history[len(history)-1]
+}
+
+func (c *churnTracker) opened(direction Direction, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.churn.Opened[direction]++
+	c.interval(now).Opened++
+}
+
+func (c *churnTracker) closed(direction Direction, duration time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.churn.Closed[direction]++
+	durations := c.churn.Durations[direction]
+	durations.observe(duration)
+	c.churn.Durations[direction] = durations
+	c.interval(now).Closed++
+}
+
+func (c *churnTracker) snapshot() PeerChurn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	durations := make(map[Direction]ConnectionDurations, len(c.churn.Durations))
+	for direction, d := range c.churn.Durations {
+		d.Counts = slices.Clone(d.Counts)
+		durations[direction] = d
+	}
+	return PeerChurn{
+		Opened:    maps.Clone(c.churn.Opened),
+		Closed:    maps.Clone(c.churn.Closed),
+		Durations: durations,
+		History:   slices.Clone(c.churn.History),
+	}
+}
+
+// load adds the churn saved at path to the tracked one (which holds the connections made since the node was created)
+func (c *churnTracker) load(fsys storage.FS, path string) error {
+	f, err := storage.Open(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoded, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	churn := newChurnTracker().churn
+	err = json.Unmarshal(encoded, &churn)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for direction, opened := range c.churn.Opened {
+		churn.Opened[direction] += opened
+	}
+	for direction, closed := range c.churn.Closed {
+		churn.Closed[direction] += closed
+	}
+	for direction, d := range c.churn.Durations {
+		merged := churn.Durations[direction]
+		if len(merged.Counts) != len(d.Counts) {
+			merged.Counts = make([]uint64, len(d.Counts))
+		}
+		for i := range d.Counts {
+			merged.Counts[i] += d.Counts[i]
+		}
+		merged.Sum += d.Sum
+		merged.Count += d.Count
+		churn.Durations[direction] = merged
+	}
+	for _, interval := range c.churn.History {
+		if n := len(churn.History); n > 0 && churn.History[n-1].Start.Equal(interval.Start) {
+			churn.History[n-1].Opened += interval.Opened
+			churn.History[n-1].Closed += interval.Closed
+		} else {
+			churn.History = append(churn.History, interval)
+		}
+	}
+	c.churn = churn
+	return nil
+}
+
+func (c *churnTracker) save(fsys storage.FS, path string) error {
+	encoded, err := json.Marshal(c.snapshot())
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(fsys, path, encoded)
+}
+
+// peerChurnPath returns the path of the file the peer churn is saved to, which lives next to the blocks file
+func (n *Node) peerChurnPath() string {
+	return filepath.Join(filepath.Dir(n.blocksFileDirectory), "peer_churn.json")
+}
+
+// PeerChurn returns the history of the node's connections
+func (n *Node) PeerChurn() PeerChurn {
+	return n.churn.snapshot()
+}
+
+func (n *Node) savePeerChurn() {
+	err := n.churn.save(n.fs, n.peerChurnPath())
+	if err != nil {
+		log.Printf("⚠️ Could not save peer churn due to error: %s", err)
+	}
+}
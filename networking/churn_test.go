@@ -0,0 +1,71 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestChurnTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("durations should be bucketed", func(t *testing.T) {
+		c := newChurnTracker()
+		c.closed(Outbound, 5*time.Second, start)
+		c.closed(Outbound, 60*time.Second, start)
+		c.closed(Outbound, 30*24*time.Hour, start)
+
+		durations := c.snapshot().Durations[Outbound]
+		require.Equal(t, []uint64{1, 1, 0, 0, 0, 0, 0, 0, 1}, durations.Counts)
+		require.Equal(t, uint64(3), durations.Count)
+		require.Equal(t, 65+30*24*3600.0, durations.Sum)
+	})
+
+	t.Run("history should be hourly and only keep the last week", func(t *testing.T) {
+		c := newChurnTracker()
+		c.opened(Inbound, start)
+		c.opened(Outbound, start.Add(30*time.Minute))
+		c.closed(Inbound, time.Minute, start.Add(90*time.Minute))
+
+		churn := c.snapshot()
+		require.Equal(t, []ChurnInterval{
+			{Start: start, Opened: 2},
+			{Start: start.Add(time.Hour), Closed: 1},
+		}, churn.History)
+		require.Equal(t, map[Direction]uint64{Inbound: 1, Outbound: 1}, churn.Opened)
+
+		c.opened(Inbound, start.Add(7*24*time.Hour))
+		churn = c.snapshot()
+		require.Equal(t, []ChurnInterval{
+			{Start: start.Add(time.Hour), Closed: 1},
+			{Start: start.Add(7 * 24 * time.Hour), Opened: 1},
+		}, churn.History)
+	})
+
+	t.Run("saved churn should be added to the churn since the restart", func(t *testing.T) {
+		fsys := storage.NewMemFS()
+		before := newChurnTracker()
+		before.opened(Outbound, start)
+		before.closed(Outbound, time.Hour, start)
+		require.NoError(t, before.save(fsys, "peer_churn.json"))
+
+		after := newChurnTracker()
+		after.opened(Outbound, start.Add(10*time.Minute))
+		require.NoError(t, after.load(fsys, "peer_churn.json"))
+
+		churn := after.snapshot()
+		require.Equal(t, uint64(2), churn.Opened[Outbound])
+		require.Equal(t, uint64(1), churn.Closed[Outbound])
+		require.Equal(t, uint64(1), churn.Durations[Outbound].Count)
+		require.Len(t, churn.History, 1)
+		require.True(t, churn.History[0].Start.Equal(start))
+		require.Equal(t, uint64(2), churn.History[0].Opened)
+	})
+
+	t.Run("loading without a saved churn should do nothing", func(t *testing.T) {
+		c := newChurnTracker()
+		require.NoError(t, c.load(storage.NewMemFS(), "peer_churn.json"))
+		require.Empty(t, c.snapshot().History)
+	})
+}
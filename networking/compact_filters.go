@@ -0,0 +1,185 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+)
+
+var ErrInvalidCompactFilterRequest = errors.New("invalid compact filter request")
+
+// handleCompactFilterRequest answers a getcfilters, getcfheaders or getcfcheckpt message (BIP157) from the block filter index. Peers asking
+// for filters we do not serve or for a range that is not on the active chain are disconnected, like Bitcoin Core does
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L3107-L3160).
+func (n *Node) handleCompactFilterRequest(msg *compactFilterRequestWithSender) error {
+	switch request := msg.Request.(type) {
+	case *message.GetCFiltersPayload:
+		return n.answerGetCFilters(msg.Sender, request)
+	case *message.GetCFHeadersPayload:
+		return n.answerGetCFHeaders(msg.Sender, request)
+	case *message.GetCFCheckptPayload:
+		return n.answerGetCFCheckpt(msg.Sender, request)
+	default:
+		return fmt.Errorf("%w: unexpected %s message", ErrInvalidCompactFilterRequest, msg.Request.CommandName())
+	}
+}
+
+// compactFilterStop returns the block of the active chain with hash stopHash, the last one whose filter is requested
+func (n *Node) compactFilterStop(filterType message.FilterType, stopHash message.Hash256) (blockchain.BlockNode, error) {
+	if n.filterIndex == nil || filterType != message.BasicFilterType {
+		return blockchain.BlockNode{}, fmt.Errorf("%w: filters of type %d are not served", ErrInvalidCompactFilterRequest, filterType)
+	}
+	stop, ok := n.blockIndex.Get(stopHash)
+	if ok {
+		active, ok := n.blockIndex.ActiveBlock(stop.Height)
+		if ok && active.Hash == stopHash {
+			return stop, nil
+		}
+	}
+	return blockchain.BlockNode{}, fmt.Errorf("%w: stop block %s is not in the active chain", ErrInvalidCompactFilterRequest, stopHash)
+}
+
+// compactFilterRange returns the hashes of the blocks of the active chain from startHeight up to the block with hash stopHash, which must
+// not be more than maxCount blocks
+func (n *Node) compactFilterRange(filterType message.FilterType, startHeight uint32, stopHash message.Hash256, maxCount int) ([]message.Hash256, error) {
+	stop, err := n.compactFilterStop(filterType, stopHash)
+	if err != nil {
+		return nil, err
+	}
+	if int64(startHeight) > int64(stop.Height) {
+		return nil, fmt.Errorf("%w: start height %d is above the stop block's height %d", ErrInvalidCompactFilterRequest, startHeight, stop.Height)
+	}
+	count := int(stop.Height) - int(startHeight) + 1
+	if count > maxCount {
+		return nil, fmt.Errorf("%w: %d filters were requested, more than %d", ErrInvalidCompactFilterRequest, count, maxCount)
+	}
+	hashes := make([]message.Hash256, count)
+	for i := range hashes {
+		node, ok := n.blockIndex.ActiveBlock(int32(startHeight) + int32(i))
+		if !ok {
+			return nil, fmt.Errorf("no block at height %d of the active chain", int(startHeight)+i)
+		}
+		hashes[i] = node.Hash
+	}
+	return hashes, nil
+}
+
+func (n *Node) answerGetCFilters(sender *Peer, request *message.GetCFiltersPayload) error {
+	hashes, err := n.compactFilterRange(request.FilterType, request.StartHeight, request.StopHash, message.MaxGetCFiltersSize)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		filter, err := n.filterIndex.Filter(hash)
+		if err != nil {
+			return err
+		}
+		err = sender.sendCFilterMsg(request.FilterType, hash, filter)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Answered getcfilters message of peer %s with %d filters", sender.conn.RemoteAddr(), len(hashes))
+	return nil
+}
+
+func (n *Node) answerGetCFHeaders(sender *Peer, request *message.GetCFHeadersPayload) error {
+	hashes, err := n.compactFilterRange(request.FilterType, request.StartHeight, request.StopHash, message.MaxGetCFHeadersSize)
+	if err != nil {
+		return err
+	}
+	// the headers are derived from the header preceding the first filter, which is zero before the genesis block's
+	var previous message.Hash256
+	if request.StartHeight > 0 {
+		node, ok := n.blockIndex.ActiveBlock(int32(request.StartHeight) - 1)
+		if !ok {
+			return fmt.Errorf("no block at height %d of the active chain", request.StartHeight-1)
+		}
+		previous, err = n.filterIndex.Header(node.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	filterHashes := make([]message.Hash256, len(hashes))
+	for i, hash := range hashes {
+		filter, err := n.filterIndex.Filter(hash)
+		if err != nil {
+			return err
+		}
+		filterHashes[i] = blockfilter.Hash(filter)
+	}
+	return sender.sendCFHeadersMsg(request.FilterType, request.StopHash, previous, filterHashes)
+}
+
+func (n *Node) answerGetCFCheckpt(sender *Peer, request *message.GetCFCheckptPayload) error {
+	stop, err := n.compactFilterStop(request.FilterType, request.StopHash)
+	if err != nil {
+		return err
+	}
+	headers := make([]message.Hash256, 0, stop.Height/message.CFCheckptInterval)
+	for height := int32(message.CFCheckptInterval); height <= stop.Height; height += message.CFCheckptInterval {
+		node, ok := n.blockIndex.ActiveBlock(height)
+		if !ok {
+			return fmt.Errorf("no block at height %d of the active chain", height)
+		}
+		header, err := n.filterIndex.Header(node.Hash)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header)
+	}
+	return sender.sendCFCheckptMsg(request.FilterType, request.StopHash, headers)
+}
+
+func (p *Peer) sendCFilterMsg(filterType message.FilterType, blockHash message.Hash256, filter []byte) error {
+	cfilterMsg, err := message.NewCFilterMessage(filterType, blockHash, filter)
+	if err != nil {
+		return err
+	}
+	cfilterMsgEncoded, err := cfilterMsg.Encode()
+	if err != nil {
+		return err
+	}
+	return p.write(cfilterMsgEncoded)
+}
+
+func (p *Peer) sendCFHeadersMsg(filterType message.FilterType, stopHash message.Hash256, previousFilterHeader message.Hash256, filterHashes []message.Hash256) error {
+	cfheadersMsg, err := message.NewCFHeadersMessage(filterType, stopHash, previousFilterHeader, filterHashes)
+	if err != nil {
+		return err
+	}
+	cfheadersMsgEncoded, err := cfheadersMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(cfheadersMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent cfheaders Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendCFCheckptMsg(filterType message.FilterType, stopHash message.Hash256, filterHeaders []message.Hash256) error {
+	cfcheckptMsg, err := message.NewCFCheckptMessage(filterType, stopHash, filterHeaders)
+	if err != nil {
+		return err
+	}
+	cfcheckptMsgEncoded, err := cfcheckptMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(cfcheckptMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent cfcheckpt Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
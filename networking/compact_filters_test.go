@@ -0,0 +1,81 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_ServesCompactFilters(t *testing.T) {
+	blocks, hashes := createSnapshotChain(t, 3)
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	index, err := OpenBlockFilterIndex(storage.NewMemFS(), "blockfilter.kv")
+	require.NoError(t, err)
+	require.NoError(t, node.SetBlockFilterIndex(index))
+	require.True(t, node.services.Has(message.NodeCompactFilters))
+	for i := range blocks {
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	fakePeer := networkingtest.NewFakePeer(t)
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	t.Run("getcfilters should be answered with a cfilter message per block", func(t *testing.T) {
+		getCFiltersMsg, err := message.NewGetCFiltersMessage(message.BasicFilterType, 2, hashes[2])
+		require.NoError(t, err)
+		conn.Send(getCFiltersMsg)
+		for _, hash := range hashes[1:] {
+			cfilter := conn.Expect(message.CFilterCommand, time.Second).Payload.(*message.CFilterPayload)
+			require.Equal(t, hash, cfilter.BlockHash)
+			filter, _, err := node.GetBlockFilter(hash)
+			require.NoError(t, err)
+			require.Equal(t, filter, cfilter.Filter)
+		}
+	})
+
+	t.Run("getcfheaders should be answered with the filter hashes following the previous filter header", func(t *testing.T) {
+		getCFHeadersMsg, err := message.NewGetCFHeadersMessage(message.BasicFilterType, 0, hashes[2])
+		require.NoError(t, err)
+		conn.Send(getCFHeadersMsg)
+		cfheaders := conn.Expect(message.CFHeadersCommand, time.Second).Payload.(*message.CFHeadersPayload)
+		require.Equal(t, message.Hash256{}, cfheaders.PreviousFilterHeader)
+		require.Len(t, cfheaders.FilterHashes, 4)
+		header := cfheaders.PreviousFilterHeader
+		for i, hash := range append([]message.Hash256{message.Hash256(constants.GenesisBlockHash)}, hashes...) {
+			filter, expected, err := node.GetBlockFilter(hash)
+			require.NoError(t, err)
+			require.Equal(t, blockfilter.Hash(filter), cfheaders.FilterHashes[i])
+			header = blockfilter.Header(filter, header)
+			require.Equal(t, expected, header)
+		}
+	})
+
+	t.Run("getcfcheckpt should be answered with no headers below the first checkpoint interval", func(t *testing.T) {
+		getCFCheckptMsg, err := message.NewGetCFCheckptMessage(message.BasicFilterType, hashes[2])
+		require.NoError(t, err)
+		conn.Send(getCFCheckptMsg)
+		cfcheckpt := conn.Expect(message.CFCheckptCommand, time.Second).Payload.(*message.CFCheckptPayload)
+		require.Equal(t, hashes[2], cfcheckpt.StopHash)
+		require.Empty(t, cfcheckpt.FilterHeaders)
+	})
+
+	t.Run("a peer asking for filters of a block that is not in the active chain should be disconnected", func(t *testing.T) {
+		getCFiltersMsg, err := message.NewGetCFiltersMessage(message.BasicFilterType, 0, message.Hash256{0x01})
+		require.NoError(t, err)
+		conn.Send(getCFiltersMsg)
+		select {
+		case <-conn.Closed():
+		case <-time.After(time.Second):
+			t.Fatal("peer was not disconnected")
+		}
+	})
+}
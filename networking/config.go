@@ -0,0 +1,45 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"time"
+)
+
+// Config holds the settings a node is created with (see NewNode). The other settings have setters, e.g. SetNetworkParams.
+type Config struct {
+	// protocol version announced in our version messages
+	ProtocolVersion uint32
+	// services announced in our version messages
+	Services message.Services
+	// number of outbound peers the node dials new peers to stay connected to
+	MinimumPeers int
+	// path of the blocks file, next to which the addresses, bans and peer churn are kept
+	BlocksFile string
+	// file system the node's state is stored in
+	FS storage.FS
+	// how often the sync manager requests new blocks
+	RequestInterval time.Duration
+	// how long dialing a peer and completing the handshake with it may take
+	DialTimeout time.Duration
+	// how long the addresses a peer is asked for are waited for
+	GetAddrWait time.Duration
+	Tuning      Tuning
+}
+
+// DefaultConfig returns the settings of a mainnet node announcing NODE_NETWORK, keeping its state in the blocks file of the current directory
+// and tuned to the detected resources
+func DefaultConfig() Config {
+	return Config{
+		ProtocolVersion: uint32(constants.ProtocolVersion),
+		Services:        message.NodeNetwork,
+		MinimumPeers:    5,
+		BlocksFile:      constants.BlocksFileName,
+		FS:              storage.OSFS{},
+		RequestInterval: 20 * time.Second,
+		DialTimeout:     10 * time.Second,
+		GetAddrWait:     10 * time.Second,
+		Tuning:          AutoTuning(DetectResources()),
+	}
+}
@@ -0,0 +1,81 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+)
+
+// doubleSpend is a transaction spending outputs that transactions of the mempool already spend
+type doubleSpend struct {
+	txId message.Hash256
+	// transactions of the mempool spending the same outputs, and those outputs
+	conflicts []*MempoolEntry
+	outpoints []message.OutPoint
+	// transactions that left the mempool because of the transaction: the conflicts and their descendants
+	evicted []*MempoolEntry
+}
+
+// findDoubleSpend returns the transactions of the mempool spending the outputs tx spends, if there are any. It must be called with m.mu held.
+func (m *Mempool) findDoubleSpend(txId message.Hash256, tx *message.TxPayload) (doubleSpend, bool) {
+	conflicts := m.conflicts(tx)
+	if len(conflicts) == 0 {
+		return doubleSpend{}, false
+	}
+	outpoints := make([]message.OutPoint, 0)
+	for _, txIn := range tx.TransactionInputs {
+		if _, ok := m.spends[txIn.PreviousOutput]; ok {
+			outpoints = append(outpoints, txIn.PreviousOutput)
+		}
+	}
+	return doubleSpend{txId: txId, conflicts: conflicts, outpoints: outpoints}, true
+}
+
+// checkDoubleSpend is findDoubleSpend for a transaction that is not in the mempool yet
+func (m *Mempool) checkDoubleSpend(tx *message.TxPayload) (doubleSpend, bool) {
+	txId, err := tx.GetTxId()
+	if err != nil {
+		return doubleSpend{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.txs.Get(txId); ok {
+		return doubleSpend{}, false
+	}
+	return m.findDoubleSpend(txId, tx)
+}
+
+// publishDoubleSpend logs and publishes the double spend of transactions of the mempool by a transaction that peer sent, that the block
+// blockHash confirmed if peer is nil, or that was submitted to the node if blockHash is zero too
+func (n *Node) publishDoubleSpend(d doubleSpend, peer *Peer, blockHash message.Hash256) {
+	event := events.DoubleSpend{
+		TxId:      d.txId.String(),
+		Conflicts: make([]string, len(d.conflicts)),
+		Outpoints: make([]string, len(d.outpoints)),
+		Evicted:   make([]string, len(d.evicted)),
+	}
+	for i, conflict := range d.conflicts {
+		event.Conflicts[i] = conflict.TxId.String()
+	}
+	for i, outpoint := range d.outpoints {
+		event.Outpoints[i] = fmt.Sprintf("%s:%d", outpoint.Hash, outpoint.Index)
+	}
+	for i, evicted := range d.evicted {
+		event.Evicted[i] = evicted.TxId.String()
+	}
+	switch {
+	case peer != nil:
+		event.Peer = peer.conn.RemoteAddr().String()
+		log.Printf("⚠️ Transaction %s from peer %s double-spends %d transactions of the mempool, evicting %d transactions", d.txId, event.Peer,
+			len(d.conflicts), len(d.evicted))
+	case blockHash != message.Hash256{}:
+		event.BlockHash = blockHash.String()
+		log.Printf("⚠️ Transaction %s of block %s double-spends %d transactions of the mempool, evicting %d transactions", d.txId, blockHash,
+			len(d.conflicts), len(d.evicted))
+	default:
+		log.Printf("⚠️ Transaction %s submitted to the node double-spends %d transactions of the mempool, evicting %d transactions", d.txId,
+			len(d.conflicts), len(d.evicted))
+	}
+	n.events.Publish(events.TopicDoubleSpend, event)
+}
@@ -0,0 +1,56 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_PublishesDoubleSpendsFromPeers(t *testing.T) {
+	node, _, peers, _ := newDownloadTestNode(t, 0)
+	node.mempool = newRBFTestMempool()
+	subscription := node.Events().Subscribe(10, events.TopicDoubleSpend)
+	defer subscription.Unsubscribe()
+	confirmed := message.OutPoint{Hash: message.Hash256{0x01}}
+	nextEvent := func() events.DoubleSpend {
+		select {
+		case event := <-subscription.C:
+			return event.Data.(events.DoubleSpend)
+		case <-time.After(time.Second):
+			t.Fatal("no double spend event was published")
+			return events.DoubleSpend{}
+		}
+	}
+
+	original := newSpendingTx(maxReplaceableSequence, 9000, confirmed)
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: original})
+	originalTxId, err := original.GetTxId()
+	require.NoError(t, err)
+	require.Empty(t, subscription.C, "a transaction spending outputs no other transaction spends is no double spend")
+
+	// a double spend that cannot replace the transaction is rejected, and still reported
+	tooCheap := newSpendingTx(0xFFFFFFFF, 8999, confirmed)
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[1], TxPayload: tooCheap})
+	tooCheapTxId, err := tooCheap.GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, events.DoubleSpend{
+		TxId:      tooCheapTxId.String(),
+		Peer:      peers[1].conn.RemoteAddr().String(),
+		Conflicts: []string{originalTxId.String()},
+		Outpoints: []string{fmt.Sprintf("%s:0", confirmed.Hash)},
+		Evicted:   []string{},
+	}, nextEvent())
+
+	replacement := newSpendingTx(0xFFFFFFFF, 7000, confirmed)
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[1], TxPayload: replacement})
+	event := nextEvent()
+	require.Equal(t, []string{originalTxId.String()}, event.Evicted)
+	require.Equal(t, 1, node.mempool.Len())
+
+	// a transaction sent again is no double spend of itself
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: replacement})
+	require.Empty(t, subscription.C)
+}
@@ -0,0 +1,79 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"time"
+)
+
+// blockThroughput samples the number of blocks the sync peer delivered, remembering the best rate it reached
+type blockThroughput struct {
+	peer          *Peer
+	lastDelivered uint64
+	lastSample    time.Time
+	// best number of blocks delivered per second between two samples
+	peakRate float64
+}
+
+// currentSyncPeer returns the sync peer, picking a new one among the peers serving blocks if there is none or it left
+func (n *Node) currentSyncPeer() (*Peer, bool) {
+	peer := n.syncPeer.Load()
+	if peer != nil {
+		if _, ok := n.peers.Get(peer); ok {
+			return peer, true
+		}
+	}
+	peer, ok := n.selectPeerWithServices(message.NodeNetwork)
+	if !ok {
+		return nil, false
+	}
+	log.Printf("Syncing from peer %s", peer.conn.RemoteAddr())
+	n.syncPeer.Store(peer)
+	return peer, true
+}
+
+// checkForDownloadStall measures the block throughput of the sync peer during the initial block download. If it falls under
+// constants.StalledThroughputFraction of the best throughput the peer reached while it still has blocks in flight, the sync peer is replaced.
+func (n *Node) checkForDownloadStall() {
+	peer := n.syncPeer.Load()
+	if peer == nil || !n.IsInitialBlockDownload() {
+		return
+	}
+	now := time.Now()
+	delivered := peer.blocksDelivered.Load()
+	sample := &n.syncThroughput
+	if sample.peer != peer {
+		*sample = blockThroughput{peer: peer, lastDelivered: delivered, lastSample: now}
+		return
+	}
+	rate := float64(delivered-sample.lastDelivered) / now.Sub(sample.lastSample).Seconds()
+	sample.lastDelivered, sample.lastSample = delivered, now
+	log.Printf("📈 Downloading %.1f blocks per second from sync peer %s (best: %.1f)", rate, peer.conn.RemoteAddr(), sample.peakRate)
+	if n.blocksInFlightByPeer()[peer] > 0 && rate < sample.peakRate*constants.StalledThroughputFraction {
+		n.rotateSyncPeer(peer, rate)
+		return
+	}
+	sample.peakRate = max(sample.peakRate, rate)
+}
+
+// rotateSyncPeer replaces stalled, the sync peer whose throughput collapsed to rate, with another peer serving blocks if there is one, and
+// requests the blocks in flight from stalled from other peers
+func (n *Node) rotateSyncPeer(stalled *Peer, rate float64) {
+	stalled.blockStalls.Add(1)
+	next, ok := n.selectPeerWithServices(message.NodeNetwork, stalled)
+	if !ok {
+		log.Printf("⚠️ Block download from sync peer %s stalled at %.1f blocks per second, but there is no other peer to sync from",
+			stalled.conn.RemoteAddr(), rate)
+		return
+	}
+	n.releaseBlocksInFlight(stalled)
+	log.Printf("⚠️ Block download from sync peer %s stalled at %.1f blocks per second. Syncing from peer %s instead", stalled.conn.RemoteAddr(),
+		rate, next.conn.RemoteAddr())
+	n.syncPeer.Store(next)
+	n.scheduleBlockDownloads(stalled)
+	err := n.requestNewBlocksFrom(next)
+	if err != nil {
+		log.Printf("⚠️ Could not request headers from sync peer %s due to error: %s", next.conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,41 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_RotatesSyncPeerWhoseThroughputCollapses(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 40)
+	stalled, next := peers[0], peers[1]
+	node.syncPeer.Store(stalled)
+	require.True(t, node.IsInitialBlockDownload())
+
+	// the peer delivered 20 blocks in a second, then none while it had blocks in flight
+	node.checkForDownloadStall()
+	stalled.blocksDelivered.Add(20)
+	node.syncThroughput.lastSample = time.Now().Add(-time.Second)
+	node.checkForDownloadStall()
+	require.InDelta(t, 20, node.syncThroughput.peakRate, 1)
+	node.blocksInFlight.Set(hashes[0], blockRequest{peer: stalled, requestedAt: time.Now()})
+	node.syncThroughput.lastSample = time.Now().Add(-time.Second)
+	node.checkForDownloadStall()
+
+	require.Equal(t, next, node.syncPeer.Load())
+	require.Equal(t, uint64(1), stalled.Stats().BlockStalls)
+	conns[next].Expect(message.GetHeadersCommand, time.Second)
+	request, ok := node.blocksInFlight.Get(hashes[0])
+	require.True(t, ok)
+	require.Equal(t, next, request.peer)
+}
+
+func TestNode_KeepsSyncPeerAfterInitialBlockDownload(t *testing.T) {
+	node, _, peers, _ := newDownloadTestNode(t, 0)
+	node.syncPeer.Store(peers[0])
+	require.False(t, node.IsInitialBlockDownload())
+
+	node.checkForDownloadStall()
+	require.Nil(t, node.syncThroughput.peer)
+}
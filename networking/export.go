@@ -0,0 +1,147 @@
+package networking
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"io"
+	"strconv"
+)
+
+var (
+	ErrUnknownExportFormat = errors.New("unknown export format")
+	ErrInvalidExportRange  = errors.New("invalid export height range")
+	ErrBlockNotStored      = errors.New("block is not stored")
+)
+
+// ExportFormat is the format blocks are exported in
+type ExportFormat string
+
+const (
+	// Blocks concatenated the way they are laid out in block files: the network's magic value, the size of the block and the block
+	// (the format of Bitcoin Core's bootstrap.dat)
+	ExportRaw ExportFormat = "raw"
+	// One ExportedBlock per line, as JSON
+	ExportJSON ExportFormat = "json"
+	// One ExportedBlock per row, after a row naming the columns
+	ExportCSV ExportFormat = "csv"
+)
+
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch format := ExportFormat(s); format {
+	case ExportRaw, ExportJSON, ExportCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownExportFormat, s)
+	}
+}
+
+// ExportedBlock is the summary of a block exported as JSON or CSV
+type ExportedBlock struct {
+	Height     int32  `json:"height"`
+	Hash       string `json:"hash"`
+	PrevBlock  string `json:"prevBlock"`
+	MerkleRoot string `json:"merkleRoot"`
+	Version    int32  `json:"version"`
+	Timestamp  uint32 `json:"timestamp"`
+	Bits       uint32 `json:"bits"`
+	Nonce      uint32 `json:"nonce"`
+	TxCount    int    `json:"txCount"`
+	// size of the serialized block in bytes
+	Size int `json:"size"`
+}
+
+var exportedBlockColumns = []string{"height", "hash", "prevBlock", "merkleRoot", "version", "timestamp", "bits", "nonce", "txCount", "size"}
+
+func (b ExportedBlock) csvRecord() []string {
+	return []string{
+		strconv.Itoa(int(b.Height)),
+		b.Hash,
+		b.PrevBlock,
+		b.MerkleRoot,
+		strconv.Itoa(int(b.Version)),
+		strconv.FormatUint(uint64(b.Timestamp), 10),
+		strconv.FormatUint(uint64(b.Bits), 10),
+		strconv.FormatUint(uint64(b.Nonce), 10),
+		strconv.Itoa(b.TxCount),
+		strconv.Itoa(b.Size),
+	}
+}
+
+// ExportBlocks writes the blocks of the active chain from height from to height to (the tip if to is negative), both included, to w in format,
+// and returns the number of blocks written. Blocks are read one at a time, so the chain does not have to fit in memory. It fails with
+// ErrBlockNotStored at the first block whose data is not stored (e.g. below a UTXO snapshot still being validated).
+func (n *Node) ExportBlocks(w io.Writer, format ExportFormat, from int32, to int32) (int, error) {
+	tip := n.blockIndex.Tip().Height
+	if to < 0 || to > tip {
+		to = tip
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("%w: %d to %d (tip is at height %d)", ErrInvalidExportRange, from, to, tip)
+	}
+
+	bw := bufio.NewWriter(w)
+	jsonEncoder := json.NewEncoder(bw)
+	csvWriter := csv.NewWriter(bw)
+	switch format {
+	case ExportRaw, ExportJSON:
+	case ExportCSV:
+		err := csvWriter.Write(exportedBlockColumns)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownExportFormat, format)
+	}
+
+	exported := 0
+	for height := from; height <= to; height++ {
+		node, ok := n.blockIndex.ActiveBlock(height)
+		if !ok || !node.Status.Has(blockchain.StatusHaveData) {
+			return exported, fmt.Errorf("%w: height %d", ErrBlockNotStored, height)
+		}
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return exported, err
+		}
+		encoded, err := block.Encode()
+		if err != nil {
+			return exported, err
+		}
+
+		summary := ExportedBlock{
+			Height:     node.Height,
+			Hash:       node.Hash.String(),
+			PrevBlock:  block.PrevBlock.String(),
+			MerkleRoot: block.MerkleRoot.String(),
+			Version:    block.Version,
+			Timestamp:  block.Timestamp,
+			Bits:       block.Bits,
+			Nonce:      block.Nonce,
+			TxCount:    len(block.Transactions),
+			Size:       len(encoded),
+		}
+		switch format {
+		case ExportRaw:
+			_, err = bw.Write(encodeBlockFileRecord(n.params.Magic, encoded))
+		case ExportJSON:
+			err = jsonEncoder.Encode(summary)
+		case ExportCSV:
+			err = csvWriter.Write(summary.csvRecord())
+		}
+		if err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	csvWriter.Flush()
+	err := csvWriter.Error()
+	if err != nil {
+		return exported, err
+	}
+	return exported, bw.Flush()
+}
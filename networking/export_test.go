@@ -0,0 +1,62 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_ExportBlocks(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	blocks, hashes := createSnapshotChain(t, 3)
+	for i := range blocks {
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+
+	buffer := new(bytes.Buffer)
+	exported, err := node.ExportBlocks(buffer, ExportCSV, 2, -1)
+	require.NoError(t, err)
+	require.Equal(t, 2, exported)
+	records, err := csv.NewReader(buffer).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, exportedBlockColumns, records[0])
+	require.Equal(t, []string{"2", hashes[1].String(), hashes[0].String()}, records[1][:3])
+	require.Equal(t, hashes[2].String(), records[2][1])
+
+	buffer.Reset()
+	exported, err = node.ExportBlocks(buffer, ExportJSON, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, exported)
+	var summary ExportedBlock
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &summary))
+	require.Equal(t, hashes[0].String(), summary.Hash)
+	require.Equal(t, 1, summary.TxCount)
+
+	buffer.Reset()
+	exported, err = node.ExportBlocks(buffer, ExportRaw, 1, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, exported)
+	for i := range blocks {
+		require.Equal(t, constants.MainnetMagicValue, binary.LittleEndian.Uint32(buffer.Next(4)))
+		size := binary.LittleEndian.Uint32(buffer.Next(4))
+		block, err := message.DecodeBlockPayload(bytes.NewReader(buffer.Next(int(size))))
+		require.NoError(t, err)
+		hash, err := block.GetBlockHash()
+		require.NoError(t, err)
+		require.Equal(t, hashes[i], hash)
+	}
+	require.Zero(t, buffer.Len())
+
+	_, err = node.ExportBlocks(buffer, ExportCSV, 3, 2)
+	require.ErrorIs(t, err, ErrInvalidExportRange)
+	_, err = ParseExportFormat("xml")
+	require.ErrorIs(t, err, ErrUnknownExportFormat)
+}
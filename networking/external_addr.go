@@ -0,0 +1,70 @@
+package networking
+
+import (
+	"net"
+	"sync"
+)
+
+// externalAddrs keeps track of the IP address other nodes can reach us at.
+//
+// Each peer reports the address it sees us at in the ReceivingNode field of its version message, and the address reported by the most peers wins
+// unless an address was configured explicitly.
+type externalAddrs struct {
+	mu         sync.Mutex
+	votes      map[[16]byte]int
+	best       net.IP
+	configured net.IP
+}
+
+func newExternalAddrs() *externalAddrs {
+	return &externalAddrs{votes: make(map[[16]byte]int)}
+}
+
+func (e *externalAddrs) setConfigured(ip net.IP) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.configured = ip
+}
+
+// observe records that a peer sees us at ip
+func (e *externalAddrs) observe(ip net.IP) {
+	if !isRoutable(ip) {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := [16]byte(ip.To16())
+	e.votes[key]++
+	if e.best == nil || e.votes[key] > e.votes[[16]byte(e.best.To16())] {
+		e.best = net.IP(key[:])
+	}
+}
+
+// get returns our external IP address, if it is known
+func (e *externalAddrs) get() (net.IP, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.configured != nil {
+		return e.configured, true
+	}
+	return e.best, e.best != nil
+}
+
+// isRoutable reports whether ip can be reached from the public internet
+func isRoutable(ip net.IP) bool {
+	return ip.To16() != nil &&
+		!ip.IsUnspecified() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast()
+}
+
+// votesFor returns how many peers reported seeing us at ip
+func (e *externalAddrs) votesFor(ip net.IP) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.votes[[16]byte(ip.To16())]
+}
@@ -0,0 +1,40 @@
+package networking
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestExternalAddrs(t *testing.T) {
+	t.Run("the address reported by the most peers should win", func(t *testing.T) {
+		e := newExternalAddrs()
+		_, ok := e.get()
+		require.False(t, ok)
+
+		e.observe(net.ParseIP("1.2.3.4"))
+		e.observe(net.ParseIP("5.6.7.8"))
+		e.observe(net.ParseIP("5.6.7.8"))
+		ip, ok := e.get()
+		require.True(t, ok)
+		require.True(t, ip.Equal(net.ParseIP("5.6.7.8")))
+	})
+
+	t.Run("unroutable addresses should be ignored", func(t *testing.T) {
+		e := newExternalAddrs()
+		for _, ip := range []string{"127.0.0.1", "10.0.0.1", "192.168.1.1", "0.0.0.0", "fe80::1", "::1"} {
+			e.observe(net.ParseIP(ip))
+		}
+		_, ok := e.get()
+		require.False(t, ok)
+	})
+
+	t.Run("a configured address should take precedence", func(t *testing.T) {
+		e := newExternalAddrs()
+		e.observe(net.ParseIP("1.2.3.4"))
+		e.setConfigured(net.ParseIP("9.9.9.9"))
+		ip, ok := e.get()
+		require.True(t, ok)
+		require.True(t, ip.Equal(net.ParseIP("9.9.9.9")))
+	})
+}
@@ -0,0 +1,50 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/utxo"
+)
+
+// Genesis block of constants.MainnetParams, which the chain of a node starts from until SetNetworkParams is called
+var mainnetGenesisBlock, mainnetGenesisHash, _ = parseGenesisBlock(constants.MainnetParams.GenesisBlock)
+
+// parseGenesisBlock decodes the genesis block of a network, given as a serialized block in hexadecimal, and returns it with its hash
+func parseGenesisBlock(encoded string) (*message.BlockPayload, message.Hash256, error) {
+	encodedBlock, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	r := bytes.NewReader(encodedBlock)
+	block, err := message.DecodeBlockPayload(r)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	if r.Len() > 0 {
+		return nil, message.Hash256{}, fmt.Errorf("%d bytes follow the genesis block", r.Len())
+	}
+	if block.PrevBlock != (message.Hash256{}) {
+		return nil, message.Hash256{}, fmt.Errorf("genesis block follows block %s", block.PrevBlock)
+	}
+	err = block.CheckMerkleRoot()
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	hash, err := block.GetBlockHash()
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	return block, hash, nil
+}
+
+// setGenesisBlock makes the chain of the node start from genesis, whose hash is hash, with the unspent outputs of the genesis block, of
+// which there are none as the output of its coinbase cannot be spent
+func (n *Node) setGenesisBlock(genesis *message.BlockPayload, hash message.Hash256) {
+	n.blockIndex.SetGenesisBlock(genesis, hash)
+	chainstate := utxo.NewChainstate(utxo.NewSet(), hash, 0)
+	n.configureChainstate(chainstate)
+	n.chainstate.Store(chainstate)
+}
@@ -0,0 +1,22 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+)
+
+// handleGetBlocksMsg announces to the sender the blocks following the first block of its locator on our best chain, so that it can sync from us
+func (n *Node) handleGetBlocksMsg(msg *getBlocksPayloadWithSender) error {
+	blockHashes := n.blockIndex.BlocksAfter(msg.GetBlocksPayload.BlockLocatorHashes, msg.GetBlocksPayload.HashStop, constants.MaxGetBlocksInv)
+	log.Printf("Answering getblocks message of peer %s with %d blocks", msg.Sender.conn.RemoteAddr(), len(blockHashes))
+	if len(blockHashes) == 0 {
+		return nil
+	}
+
+	inventories := make([]message.Inventory, len(blockHashes))
+	for i, blockHash := range blockHashes {
+		inventories[i] = message.Inventory{Type: message.MsgBlock, Hash: blockHash}
+	}
+	return msg.Sender.sendInvMsg(inventories)
+}
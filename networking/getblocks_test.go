@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// addTestChain adds length blocks following the genesis block to the node (their proof of work is not valid) and returns their hashes
+func addTestChain(t *testing.T, node *Node, length int) []message.Hash256 {
+	hashes := make([]message.Hash256, length)
+	prev := message.Hash256(constants.GenesisBlockHash)
+	for i := range length {
+		block := &message.BlockPayload{Version: 1, PrevBlock: prev, Timestamp: uint32(1231006505 + i), Bits: easyBits, Nonce: uint32(i)}
+		require.NoError(t, node.addBlockToNode(block))
+		hash, err := block.GetBlockHash()
+		require.NoError(t, err)
+		hashes[i], prev = hash, hash
+	}
+	return hashes
+}
+
+func TestNode_AnswersGetBlocksWithInv(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	chain := addTestChain(t, node, 3)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	getBlocksMsg, err := message.NewGetBlocksMessage(70015, []message.Hash256{chain[0]}, message.Hash256{})
+	require.NoError(t, err)
+	conn.Send(getBlocksMsg)
+
+	inv := conn.Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+	require.Equal(t, []message.Inventory{{Type: message.MsgBlock, Hash: chain[1]}, {Type: message.MsgBlock, Hash: chain[2]}}, inv.InventoryList)
+}
@@ -1,32 +1,46 @@
 package networking
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/aang114/bitcoin-node/constants"
 	"github.com/aang114/bitcoin-node/message"
 	"log"
 	"math/rand"
 	"net"
+	"os"
 	"time"
 )
 
-func getLocalAddr(conn *net.TCPConn) (*net.TCPAddr, error) {
+var (
+	ErrSelfConnection         = errors.New("connected to self")
+	ErrNotTCPAddr             = errors.New("address is not a tcp address")
+	ErrInvalidMagic           = errors.New("invalid Magic")
+	ErrInvalidCommand         = errors.New("invalid Command")
+	ErrProtocolVersionTooHigh = errors.New("protocol version not supported")
+	// the peer did not complete the handshake in time, or the context of the handshake expired
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+)
+
+func getLocalAddr(conn net.Conn) (*net.TCPAddr, error) {
 	localTcpAddr, ok := conn.LocalAddr().(*net.TCPAddr)
 	if !ok {
-		return nil, errors.New("local address is not a tcp address")
+		return nil, fmt.Errorf("local %w: %s", ErrNotTCPAddr, conn.LocalAddr())
 	}
 	return localTcpAddr, nil
 }
 
-func getRemoteAddr(conn *net.TCPConn) (*net.TCPAddr, error) {
+func getRemoteAddr(conn net.Conn) (*net.TCPAddr, error) {
 	remoteTcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
 	if !ok {
-		return nil, errors.New("remote address is not a tcp address")
+		return nil, fmt.Errorf("remote %w: %s", ErrNotTCPAddr, conn.RemoteAddr())
 	}
 	return remoteTcpAddr, nil
 }
 
-func exchangeVersionMessage(conn *net.TCPConn, services message.Services, receivingServices message.Services) (*message.VersionPayload, error) {
+// newVersionMessage returns our version message for the peer at the other end of conn, which offers receivingServices
+func newVersionMessage(conn net.Conn, services message.Services, receivingServices message.Services, nonce uint64) (*message.Message, error) {
 	localTcpAddr, err := getLocalAddr(conn)
 	if err != nil {
 		return nil, err
@@ -36,48 +50,80 @@ func exchangeVersionMessage(conn *net.TCPConn, services message.Services, receiv
 		return nil, err
 	}
 
-	// send version message
-	msg, err := message.NewVersionMessage(
+	return message.NewVersionMessage(
 		constants.ProtocolVersion,
 		message.NodeNetwork,
 		time.Now().Unix(),
 		*message.NewNetworkAddress(receivingServices, remoteTcpAddr.IP, uint16(remoteTcpAddr.Port)),
 		*message.NewNetworkAddress(services, localTcpAddr.IP, uint16(localTcpAddr.Port)),
-		rand.Uint64(),
+		nonce,
 		constants.UserAgent,
 		0,
-		false)
+		// transactions are relayed through the mempool
+		true)
+}
+
+// handshakeConn is a connection a handshake is performed on, whose messages start with the magic of the network the node joins
+type handshakeConn struct {
+	net.Conn
+	magic uint32
+}
+
+// writeMessage sends msg with the magic of the connection
+func (conn handshakeConn) writeMessage(msg *message.Message) error {
+	msg.Header.Magic = conn.magic
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(encoded)
+	return err
+}
+
+// readMessage receives a message, which fails with ErrInvalidMagic if it does not start with the magic of the connection
+func (conn handshakeConn) readMessage() (*message.Message, error) {
+	msg, err := message.DecodeMessage(conn)
 	if err != nil {
 		return nil, err
 	}
-	encoded, err := msg.Encode()
+	if msg.Header.Magic != conn.magic {
+		return nil, invalidMagic(msg)
+	}
+	return msg, nil
+}
+
+func exchangeVersionMessage(conn handshakeConn, services message.Services, receivingServices message.Services, nonce uint64) (*message.VersionPayload, error) {
+	// send version message
+	msg, err := newVersionMessage(conn, services, receivingServices, nonce)
 	if err != nil {
 		return nil, err
 	}
-	_, err = conn.Write(encoded)
+	err = conn.writeMessage(msg)
 	if err != nil {
 		return nil, err
 	}
 
 	// receive version message
-	msg, err = message.DecodeMessage(conn)
+	msg, err = conn.readMessage()
 	if err != nil {
 		return nil, err
 	}
 	if msg.Header.Command != message.VersionCommand {
-		return nil, errors.New("invalid Command")
-	}
-	if msg.Header.Magic != constants.MainnetMagicValue {
-		return nil, errors.New("invalid Magic")
+		return nil, unexpectedCommand(msg, message.VersionCommand)
 	}
 
 	payload, ok := msg.Payload.(*message.VersionPayload)
 	if !ok {
-		return nil, errors.New("invalid Payload")
+		return nil, fmt.Errorf("%w of %s message", ErrInvalidPayload, msg.Header.Command)
 	}
 
 	if payload.Version > constants.ProtocolVersion {
-		return nil, errors.New("protocol version not supported")
+		return nil, fmt.Errorf("%w: %d is above %d", ErrProtocolVersionTooHigh, payload.Version, constants.ProtocolVersion)
+	}
+
+	// a peer replying with the nonce we just sent is ourselves
+	if payload.Nonce != 0 && payload.Nonce == nonce {
+		return nil, ErrSelfConnection
 	}
 
 	log.Printf("🔄 Exchanged version message with peer %s", conn.RemoteAddr())
@@ -85,43 +131,39 @@ func exchangeVersionMessage(conn *net.TCPConn, services message.Services, receiv
 	return payload, nil
 }
 
-func exchangeVerackMessage(conn *net.TCPConn, receivedVersionNumber int32) error {
+// exchangeVerackMessage exchanges verack messages, recording in h the sendaddrv2 and sendtxrcncl messages the peer sent before its verack
+func exchangeVerackMessage(conn handshakeConn, h *handshake, sentTxRcncl bool) error {
 	// send verack message
 	msg, err := message.NewVerackMessage()
 	if err != nil {
 		return err
 	}
-	encoded, err := msg.Encode()
-	if err != nil {
-		return err
-	}
-	_, err = conn.Write(encoded)
+	err = conn.writeMessage(msg)
 	if err != nil {
 		return err
 	}
 
 	// receive verack message
-	msg, err = message.DecodeMessage(conn)
-	if err != nil {
-		return err
-	}
-	if receivedVersionNumber >= 70016 {
-		if msg.Header.Magic != constants.MainnetMagicValue {
-			return errors.New("invalid Magic")
+	for {
+		msg, err = conn.readMessage()
+		if err != nil {
+			return err
 		}
 		// Before receiving a VERACK, a node should not send anything but VERSION/VERACK and feature negotiation messages (WTXIDRELAY, SENDADDRV2). (https://github.com/bitcoin/bitcoin/blob/e9262ea32a6e1d364fb7974844fadc36f931f8c6/test/functional/p2p_leak.py#L7-L8)
-		if msg.Header.Command == message.SendAddrV2Command {
-			msg, err = message.DecodeMessage(conn)
-			if err != nil {
-				return err
-			}
+		if h.Version.Version < 70016 || msg.Header.Command == message.VerackCommand {
+			break
+		}
+		switch msg.Header.Command {
+		case message.SendAddrV2Command:
+			h.SendAddrV2 = true
+		case message.SendTxRcnclCommand:
+			recordTxRcncl(h, msg, sentTxRcncl)
+		default:
+			return unexpectedCommand(msg, message.VerackCommand)
 		}
 	}
 	if msg.Header.Command != message.VerackCommand {
-		return errors.New("invalid Command")
-	}
-	if msg.Header.Magic != constants.MainnetMagicValue {
-		return errors.New("invalid Magic")
+		return unexpectedCommand(msg, message.VerackCommand)
 	}
 
 	log.Printf("🔄 Exchanged verack message with peer %s", conn.RemoteAddr())
@@ -129,31 +171,51 @@ func exchangeVerackMessage(conn *net.TCPConn, receivedVersionNumber int32) error
 	return nil
 }
 
-func exchangeWtxidrelayMessage(conn *net.TCPConn) error {
-	// send wtxidrelay message
-	msg, err := message.NewWtxidRelayMessage()
+// offersTxRcncl reports whether a sendtxrcncl message is sent to the peer which sent version, which must support wtxid relay and ask to be sent
+// transactions (BIP 330)
+func offersTxRcncl(version *message.VersionPayload) bool {
+	return version.Version >= 70016 && version.Relay
+}
+
+// sendTxRcnclMessage sends our sendtxrcncl message with a new random salt, which is recorded in h
+func sendTxRcnclMessage(conn handshakeConn, h *handshake) error {
+	h.LocalTxRcnclSalt = rand.Uint64()
+	msg, err := message.NewSendTxRcnclMessage(message.TxReconciliationVersion, h.LocalTxRcnclSalt)
 	if err != nil {
 		return err
 	}
-	encoded, err := msg.Encode()
+	return conn.writeMessage(msg)
+}
+
+// recordTxRcncl records the peer's sendtxrcncl message in h. Transaction reconciliation is used if we sent one too, wtxid relay was
+// negotiated and the peer supports our version of the protocol; the message is ignored otherwise.
+func recordTxRcncl(h *handshake, msg *message.Message, sentTxRcncl bool) {
+	payload, ok := msg.Payload.(*message.SendTxRcnclPayload)
+	if !ok || !sentTxRcncl || !h.WtxidRelay || payload.Version < message.TxReconciliationVersion {
+		return
+	}
+	h.TxReconciliation = true
+	h.RemoteTxRcnclSalt = payload.Salt
+}
+
+func exchangeWtxidrelayMessage(conn handshakeConn) error {
+	// send wtxidrelay message
+	msg, err := message.NewWtxidRelayMessage()
 	if err != nil {
 		return err
 	}
-	_, err = conn.Write(encoded)
+	err = conn.writeMessage(msg)
 	if err != nil {
 		return err
 	}
 
 	// receive wtxidrelay message
-	msg, err = message.DecodeMessage(conn)
+	msg, err = conn.readMessage()
 	if err != nil {
 		return err
 	}
 	if msg.Header.Command != message.WtxidRelayCommand {
-		return errors.New("invalid Command")
-	}
-	if msg.Header.Magic != constants.MainnetMagicValue {
-		return errors.New("invalid Magic")
+		return unexpectedCommand(msg, message.WtxidRelayCommand)
 	}
 
 	log.Printf("🔄 Exchanged wtxidrelay message with peer %s", conn.RemoteAddr())
@@ -161,35 +223,221 @@ func exchangeWtxidrelayMessage(conn *net.TCPConn) error {
 	return nil
 }
 
-func PerformHandshake(remoteAddr *net.TCPAddr, tcpTimeout time.Duration, services message.Services, receivingServices message.Services) (*net.TCPConn, error) {
+// NewNonce returns a random non-zero nonce to be sent in a version message
+func NewNonce() uint64 {
+	for {
+		if nonce := rand.Uint64(); nonce != 0 {
+			return nonce
+		}
+	}
+}
+
+// handshake is the outcome of a successful handshake
+type handshake struct {
+	// version message received from the peer
+	Version *message.VersionPayload
+	// wtxidrelay messages were exchanged (BIP 339)
+	WtxidRelay bool
+	// the peer asked for addrv2 messages (BIP 155)
+	SendAddrV2 bool
+	// sendtxrcncl messages were exchanged (BIP 330), so that transactions are relayed to the peer by reconciling the sets of transactions
+	// each side would announce
+	TxReconciliation bool
+	// salts of the short ids of reconciled transactions, sent in our sendtxrcncl message and in the peer's
+	LocalTxRcnclSalt, RemoteTxRcnclSalt uint64
+}
+
+// performHandshake dials remoteAddr with dialer and performs the initiator side of the handshake on the network whose messages start with
+// magic, sending nonce in our version message. It returns the connection together with the outcome of the handshake. Cancelling ctx aborts both the dial and the handshake.
+//
+// If the handshake fails after the connection was established, the returned error is an *ErrHandshakeFailed holding a trace of the exchanged bytes.
+// It wraps ErrHandshakeTimeout if the peer did not answer in time, the error of ctx if the handshake was aborted, and otherwise the reason the
+// peer's messages were rejected, e.g. ErrInvalidMagic, ErrInvalidCommand, ErrProtocolVersionTooHigh or ErrSelfConnection.
+func performHandshake(ctx context.Context, dialer Dialer, remoteAddr *net.TCPAddr, magic uint32, services message.Services, receivingServices message.Services, nonce uint64) (Conn, *handshake, error) {
 	log.Printf("🤝 Performing handshake with peer %s", remoteAddr.String())
-	//conn, err := net.DialTCP("tcp", nil, &remoteAddr)
 	// TODO - Improve (Currently, the node uses a different TCP address for each new connection. A Bitcoin node should only have one TCP address)
-	connI, err := net.DialTimeout("tcp", remoteAddr.String(), tcpTimeout)
+	conn, err := dialer.DialContext(ctx, "tcp", remoteAddr.String())
 	if err != nil {
+		return nil, nil, err
+	}
+	h, err := traceHandshake(ctx, conn, func(conn net.Conn) (*handshake, error) {
+		return initiateHandshake(handshakeConn{Conn: conn, magic: magic}, services, receivingServices, nonce)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, h, nil
+}
+
+// acceptHandshake performs the responder side of the handshake on an inbound connection, which must complete within timeout and before ctx is
+// cancelled: the initiator's version message is received first, and answered with our version, wtxidrelay (if the initiator's protocol version
+// supports it) and verack messages. The initiator's feature negotiation messages are then received until its verack.
+// Errors are returned as in performHandshake, and the connection is closed on failure.
+func acceptHandshake(ctx context.Context, conn Conn, timeout time.Duration, magic uint32, services message.Services, nonce uint64) (*handshake, error) {
+	log.Printf("🤝 Accepting handshake from peer %s", conn.RemoteAddr())
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
-	conn, ok := connI.(*net.TCPConn)
-	if !ok {
-		return nil, errors.New("Could not convert net.Conn to *net.TCPConn")
+	h, err := traceHandshake(ctx, conn, func(conn net.Conn) (*handshake, error) {
+		return respondToHandshake(handshakeConn{Conn: conn, magic: magic}, services, nonce)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// traceHandshake runs the handshake performed by perform on a connection recording the exchanged bytes, closing conn and returning an
+// *ErrHandshakeFailed if it fails. Cancelling ctx aborts the handshake.
+func traceHandshake(ctx context.Context, conn Conn, perform func(conn net.Conn) (*handshake, error)) (*handshake, error) {
+	// a deadline in the past makes the pending read or write fail at once
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	tracingConn := newTracingConn(conn)
+	h, err := perform(tracingConn)
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.Canceled):
+		// the pending read or write failed because the handshake was aborted
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	case errors.Is(err, os.ErrDeadlineExceeded) || ctx.Err() != nil:
+		err = fmt.Errorf("%w: %w", ErrHandshakeTimeout, err)
 	}
-	receivedVersionPayload, err := exchangeVersionMessage(conn, services, receivingServices)
+	if err != nil {
+		_ = conn.Close()
+		return nil, &ErrHandshakeFailed{Trace: tracingConn.finish(err), Err: err}
+	}
+
+	log.Printf("✅ Handshake successful with peer %s!", conn.RemoteAddr())
+
+	return h, nil
+}
+
+// initiateHandshake exchanges the version, wtxidrelay and verack messages on conn, sending our message before reading the peer's at every step.
+// A sendtxrcncl message is sent before our verack if the peer's version message allows it.
+func initiateHandshake(conn handshakeConn, services message.Services, receivingServices message.Services, nonce uint64) (*handshake, error) {
+	receivedVersionPayload, err := exchangeVersionMessage(conn, services, receivingServices, nonce)
 	if err != nil {
 		return nil, err
 	}
+	h := &handshake{Version: receivedVersionPayload}
 	// The wtxidrelay message MUST be sent in response to a version message from a peer whose protocol version is >= 70016 and prior to sending a verack. A wtxidrelay message received after a verack message MUST be ignored or treated as invalid. (https://bips.dev/339/)
 	if receivedVersionPayload.Version >= 70016 {
 		err = exchangeWtxidrelayMessage(conn)
 		if err != nil {
 			return nil, err
 		}
+		h.WtxidRelay = true
 	}
-	err = exchangeVerackMessage(conn, receivedVersionPayload.Version)
+	sentTxRcncl := offersTxRcncl(receivedVersionPayload)
+	if sentTxRcncl {
+		err = sendTxRcnclMessage(conn, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = exchangeVerackMessage(conn, h, sentTxRcncl)
 	if err != nil {
 		return nil, err
 	}
+	return h, nil
+}
 
-	log.Printf("✅ Handshake successful with peer %s!", conn.RemoteAddr())
+// respondToHandshake waits for the initiator's version message on conn before sending ours, followed by a wtxidrelay message if the initiator's
+// protocol version is >= 70016, a sendtxrcncl message if its version message allows it and a verack. It then receives the initiator's feature negotiation messages until its verack.
+func respondToHandshake(conn handshakeConn, services message.Services, nonce uint64) (*handshake, error) {
+	msg, err := conn.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	receivedVersionPayload, ok := msg.Payload.(*message.VersionPayload)
+	if !ok {
+		return nil, unexpectedCommand(msg, message.VersionCommand)
+	}
+	// a version message with the nonce we are about to send is our own
+	if receivedVersionPayload.Nonce != 0 && receivedVersionPayload.Nonce == nonce {
+		return nil, ErrSelfConnection
+	}
+	h := &handshake{Version: receivedVersionPayload}
+
+	// the initiator's services are only known from its version message
+	msg, err = newVersionMessage(conn, services, receivedVersionPayload.Services, nonce)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.writeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	sentWtxidRelay := receivedVersionPayload.Version >= 70016
+	if sentWtxidRelay {
+		msg, err = message.NewWtxidRelayMessage()
+		if err != nil {
+			return nil, err
+		}
+		err = conn.writeMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sentTxRcncl := offersTxRcncl(receivedVersionPayload)
+	if sentTxRcncl {
+		err = sendTxRcnclMessage(conn, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	msg, err = message.NewVerackMessage()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.writeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before receiving a VERACK, a node should not send anything but VERSION/VERACK and feature negotiation messages (WTXIDRELAY, SENDADDRV2). (https://github.com/bitcoin/bitcoin/blob/e9262ea32a6e1d364fb7974844fadc36f931f8c6/test/functional/p2p_leak.py#L7-L8)
+	for {
+		msg, err = conn.readMessage()
+		unknownCommandErr := &message.ErrUnknownCommandName{}
+		if errors.As(err, &unknownCommandErr) {
+			// feature negotiation messages we don't support
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Header.Command {
+		case message.WtxidRelayCommand:
+			// wtxid relay is only used if both sides announced it
+			h.WtxidRelay = sentWtxidRelay
+		case message.SendAddrV2Command:
+			h.SendAddrV2 = true
+		case message.SendTxRcnclCommand:
+			recordTxRcncl(h, msg, sentTxRcncl)
+		case message.VerackCommand:
+			log.Printf("🔄 Received verack message from peer %s", conn.RemoteAddr())
+			return h, nil
+		default:
+			return nil, unexpectedCommand(msg, message.VerackCommand)
+		}
+	}
+}
+
+// unexpectedCommand returns the error of receiving msg during the handshake while waiting for a message with command expected
+func unexpectedCommand(msg *message.Message, expected message.CommandName) error {
+	return fmt.Errorf("%w: expected %s, got %s", ErrInvalidCommand, expected, msg.Header.Command)
+}
 
-	return conn, nil
+// invalidMagic returns the error of receiving msg during the handshake with the magic of another network
+func invalidMagic(msg *message.Message) error {
+	return fmt.Errorf("%w: %x", ErrInvalidMagic, msg.Header.Magic)
 }
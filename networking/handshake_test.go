@@ -1,8 +1,11 @@
 package networking
 
 import (
+	"context"
+	"encoding/hex"
 	"github.com/aang114/bitcoin-node/constants"
 	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"net"
@@ -135,10 +138,13 @@ func (s *HandshakeTestSuite) TestPerformHandshake_ShouldWork() {
 	}()
 
 	// handshake should work
-	conn, err := PerformHandshake(&s.peerAddr, s.tcpTimeout, message.NodeNetwork, message.NodeNetwork)
+	conn, h, err := performHandshake(context.Background(), &net.Dialer{Timeout: s.tcpTimeout}, &s.peerAddr, constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
 	s.NoError(err)
 	defer conn.Close()
 	s.Equal(s.peerAddr.String(), conn.RemoteAddr().String())
+	s.Equal(s.peerVersionMsg.Payload, h.Version)
+	s.False(h.WtxidRelay)
+	s.False(h.SendAddrV2)
 
 	wg.Wait()
 
@@ -188,10 +194,312 @@ func (s *HandshakeTestSuite) TestPerformHandshake_ShouldExchangeWtxidRelayWithVe
 	}()
 
 	// handshake should work
-	conn, err := PerformHandshake(&s.peerAddr, s.tcpTimeout, message.NodeNetwork, message.NodeNetwork)
+	conn, h, err := performHandshake(context.Background(), &net.Dialer{Timeout: s.tcpTimeout}, &s.peerAddr, constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
 	s.NoError(err)
 	defer conn.Close()
 	s.Equal(s.peerAddr.String(), conn.RemoteAddr().String())
+	s.True(h.WtxidRelay)
+	s.False(h.SendAddrV2)
 
 	wg.Wait()
 }
+
+func (s *HandshakeTestSuite) TestPerformHandshake_ShouldRecordSendAddrV2() {
+	ln, err := net.Listen("tcp", s.peerAddr.String())
+	if err != nil {
+		s.FailNow(err.Error())
+	}
+	defer ln.Close()
+
+	sendAddrV2Msg, err := message.NewSendAddrV2Message()
+	s.NoError(err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		conn, err := ln.Accept()
+		s.NoError(err)
+		defer conn.Close()
+
+		receiveMsg(s.T(), conn)
+		sendMsg(s.T(), conn, s.peerVersionMsgWithVersion70016)
+		receiveMsg(s.T(), conn)
+		sendMsg(s.T(), conn, s.wtxidrelayMsg)
+		receiveMsg(s.T(), conn)
+
+		// sendaddrv2 is sent before verack
+		sendMsg(s.T(), conn, sendAddrV2Msg)
+		sendMsg(s.T(), conn, s.verackMsg)
+	}()
+
+	conn, h, err := performHandshake(context.Background(), &net.Dialer{Timeout: s.tcpTimeout}, &s.peerAddr, constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	s.NoError(err)
+	defer conn.Close()
+	s.True(h.WtxidRelay)
+	s.True(h.SendAddrV2)
+
+	wg.Wait()
+}
+
+func (s *HandshakeTestSuite) TestPerformHandshake_ShouldFailOnSelfConnection() {
+	ln, err := net.Listen("tcp", s.peerAddr.String())
+	if err != nil {
+		s.FailNow(err.Error())
+	}
+	defer ln.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		conn, err := ln.Accept()
+		s.NoError(err)
+		defer conn.Close()
+
+		// receive version msg
+		msg := receiveMsg(s.T(), conn)
+		payload, ok := msg.Payload.(*message.VersionPayload)
+		s.True(ok)
+
+		// echo back a version msg with the same nonce
+		versionMsg, err := message.NewVersionMessage(
+			70015,
+			message.NodeNetwork,
+			100,
+			payload.TransmittingNode,
+			payload.ReceivingNode,
+			payload.Nonce,
+			"/Peer:0.0.1",
+			300,
+			false,
+		)
+		s.NoError(err)
+		sendMsg(s.T(), conn, versionMsg)
+	}()
+
+	_, _, err = performHandshake(context.Background(), &net.Dialer{Timeout: s.tcpTimeout}, &s.peerAddr, constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	s.ErrorIs(err, ErrSelfConnection)
+
+	// the failed handshake should have been traced
+	var handshakeErr *ErrHandshakeFailed
+	s.Require().ErrorAs(err, &handshakeErr)
+	trace := handshakeErr.Trace
+	s.Equal(s.peerAddr.String(), trace.Peer)
+	s.Equal(ErrSelfConnection.Error(), trace.Error)
+	s.Require().Len(trace.Steps, 2)
+	s.Equal(HandshakeSent, trace.Steps[0].Direction)
+	s.Equal(HandshakeReceived, trace.Steps[1].Direction)
+	for _, step := range trace.Steps {
+		s.Equal("version", step.Command)
+		s.False(step.Incomplete)
+		s.Len(step.Payload, 2*int(step.PayloadLength))
+	}
+	s.LessOrEqual(trace.Steps[0].Elapsed, trace.Steps[1].Elapsed)
+
+	redacted := trace.redacted()
+	s.Empty(redacted.Steps[1].Payload)
+	s.True(redacted.Steps[1].Redacted)
+	s.NotEmpty(trace.Steps[1].Payload)
+
+	wg.Wait()
+}
+
+func TestHandshakeTraces(t *testing.T) {
+	traces := newHandshakeTraces(2)
+	traces.add(HandshakeTrace{Peer: "a"})
+	traces.add(HandshakeTrace{Peer: "b"})
+	traces.add(HandshakeTrace{Peer: "c"})
+
+	all := traces.all(false)
+	require.Len(t, all, 2)
+	require.Equal(t, "b", all[0].Peer)
+	require.Equal(t, "c", all[1].Peer)
+}
+
+func TestSplitHandshakeSteps(t *testing.T) {
+	verack, err := message.NewVerackMessage()
+	require.NoError(t, err)
+	verackEncoded, err := verack.Encode()
+	require.NoError(t, err)
+	ping, err := message.NewPingMessage(1)
+	require.NoError(t, err)
+	pingEncoded, err := ping.Encode()
+	require.NoError(t, err)
+
+	start := time.Now()
+	// the ping message arrives split across two reads together with the start of a third message
+	received := append(append([]byte{}, pingEncoded...), verackEncoded[:10]...)
+	steps := splitHandshakeSteps(start, []handshakeChunk{
+		{direction: HandshakeSent, at: start, data: verackEncoded},
+		{direction: HandshakeReceived, at: start.Add(time.Second), data: received[:5]},
+		{direction: HandshakeReceived, at: start.Add(2 * time.Second), data: received[5:]},
+	})
+
+	require.Len(t, steps, 3)
+	require.Equal(t, HandshakeStep{Direction: HandshakeSent, Command: "verack", Header: hex.EncodeToString(verackEncoded)}, steps[0])
+	require.Equal(t, "ping", steps[1].Command)
+	require.Equal(t, time.Second, steps[1].Elapsed)
+	require.EqualValues(t, 8, steps[1].PayloadLength)
+	require.Equal(t, hex.EncodeToString(pingEncoded[message.HeaderLength:]), steps[1].Payload)
+	require.True(t, steps[2].Incomplete)
+	require.Equal(t, 2*time.Second, steps[2].Elapsed)
+	require.Equal(t, hex.EncodeToString(verackEncoded[:10]), steps[2].Header)
+}
+
+func TestPerformHandshake_IsAbortedByContext(t *testing.T) {
+	// the listener accepts connections but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = performHandshake(ctx, &net.Dialer{}, ln.Addr().(*net.TCPAddr), constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	require.ErrorIs(t, err, ErrHandshakeTimeout)
+	require.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, _, err = performHandshake(ctx, &net.Dialer{}, ln.Addr().(*net.TCPAddr), constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, ErrHandshakeTimeout)
+}
+
+func TestPerformHandshake_RejectsInvalidVersionMessages(t *testing.T) {
+	h := CreateHandshakeData(t)
+	otherNetwork := *h.peerVersionMsg
+	otherNetwork.Header.Magic = 0x0709110B
+	tooHigh, err := message.NewVersionMessage(constants.ProtocolVersion+1, message.NodeNetwork, 100, message.NetworkAddress{}, message.NetworkAddress{}, 200,
+		"/Peer:0.0.1", 300, false)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name     string
+		response *message.Message
+		err      error
+	}{
+		{"a message other than version", h.verackMsg, ErrInvalidCommand},
+		{"a version message of another network", &otherNetwork, ErrInvalidMagic},
+		{"a protocol version above ours", tooHigh, ErrProtocolVersionTooHigh},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer conn.Close()
+				receiveMsg(t, conn)
+				sendMsg(t, conn, test.response)
+			}()
+
+			_, _, err = performHandshake(context.Background(), &net.Dialer{}, ln.Addr().(*net.TCPAddr), constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestAcceptHandshake(t *testing.T) {
+	h := CreateHandshakeData(t)
+	sendAddrV2Msg, err := message.NewSendAddrV2Message()
+	require.NoError(t, err)
+
+	accept := func(t *testing.T, initiator func(conn net.Conn)) (*handshake, error) {
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer ln.Close()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+			initiator(conn)
+		}()
+		conn, err := ln.AcceptTCP()
+		require.NoError(t, err)
+		defer conn.Close()
+		result, err := acceptHandshake(context.Background(), conn, time.Second, constants.MainnetMagicValue, message.NodeNetwork, NewNonce())
+		<-done
+		return result, err
+	}
+
+	t.Run("responder should wait for the initiator's version and negotiate features", func(t *testing.T) {
+		result, err := accept(t, func(conn net.Conn) {
+			sendMsg(t, conn, h.peerVersionMsgWithVersion70016)
+			assert.Equal(t, message.VersionCommand, receiveMsg(t, conn).Header.Command)
+			assert.Equal(t, h.wtxidrelayMsg, receiveMsg(t, conn))
+			assert.Equal(t, h.verackMsg, receiveMsg(t, conn))
+			sendMsg(t, conn, h.wtxidrelayMsg)
+			sendMsg(t, conn, sendAddrV2Msg)
+			sendMsg(t, conn, h.verackMsg)
+		})
+		require.NoError(t, err)
+		require.Equal(t, h.peerVersionMsgWithVersion70016.Payload, result.Version)
+		require.True(t, result.WtxidRelay)
+		require.True(t, result.SendAddrV2)
+	})
+
+	t.Run("responder should not send wtxidrelay to initiators below version 70016", func(t *testing.T) {
+		result, err := accept(t, func(conn net.Conn) {
+			sendMsg(t, conn, h.peerVersionMsg)
+			assert.Equal(t, message.VersionCommand, receiveMsg(t, conn).Header.Command)
+			assert.Equal(t, h.verackMsg, receiveMsg(t, conn))
+			sendMsg(t, conn, h.verackMsg)
+		})
+		require.NoError(t, err)
+		require.False(t, result.WtxidRelay)
+		require.False(t, result.SendAddrV2)
+	})
+
+	t.Run("responder should fail if the initiator does not start with a version message", func(t *testing.T) {
+		_, err := accept(t, func(conn net.Conn) {
+			sendMsg(t, conn, h.verackMsg)
+		})
+		require.ErrorIs(t, err, ErrInvalidCommand)
+		var handshakeErr *ErrHandshakeFailed
+		require.ErrorAs(t, err, &handshakeErr)
+		require.Len(t, handshakeErr.Trace.Steps, 1)
+	})
+}
+
+func TestHandshake_NegotiatesTxReconciliation(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	responderCh := make(chan *handshake, 1)
+	go func() {
+		conn, err := ln.AcceptTCP()
+		if !assert.NoError(t, err) {
+			responderCh <- nil
+			return
+		}
+		defer conn.Close()
+		h, err := acceptHandshake(context.Background(), conn, time.Second, constants.MainnetMagicValue, message.NodeNetwork, NewNonce())
+		assert.NoError(t, err)
+		responderCh <- h
+	}()
+
+	conn, initiator, err := performHandshake(context.Background(), &net.Dialer{Timeout: time.Second}, ln.Addr().(*net.TCPAddr), constants.MainnetMagicValue, message.NodeNetwork,
+		message.NodeNetwork, NewNonce())
+	require.NoError(t, err)
+	defer conn.Close()
+	responder := <-responderCh
+	require.NotNil(t, responder)
+
+	require.True(t, initiator.TxReconciliation)
+	require.True(t, responder.TxReconciliation)
+	require.Equal(t, initiator.LocalTxRcnclSalt, responder.RemoteTxRcnclSalt)
+	require.Equal(t, responder.LocalTxRcnclSalt, initiator.RemoteTxRcnclSalt)
+}
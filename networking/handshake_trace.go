@@ -0,0 +1,213 @@
+package networking
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"net"
+	"sync"
+	"time"
+)
+
+type HandshakeDirection string
+
+const (
+	HandshakeSent     HandshakeDirection = "sent"
+	HandshakeReceived HandshakeDirection = "received"
+)
+
+// HandshakeStep is a message (or the incomplete tail of one) sent or received during a handshake
+type HandshakeStep struct {
+	Direction HandshakeDirection `json:"direction"`
+	// time since the connection was established at which the first byte of the message was sent or received
+	Elapsed       time.Duration `json:"elapsed"`
+	Command       string        `json:"command,omitempty"`
+	Header        string        `json:"header"`
+	Payload       string        `json:"payload,omitempty"`
+	PayloadLength uint32        `json:"payloadLength"`
+	Redacted      bool          `json:"redacted,omitempty"`
+	// the connection failed before the whole message was transferred
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// HandshakeTrace records the bytes exchanged during a handshake together with their timing
+type HandshakeTrace struct {
+	Peer     string          `json:"peer"`
+	Start    time.Time       `json:"start"`
+	Duration time.Duration   `json:"duration"`
+	Error    string          `json:"error"`
+	Steps    []HandshakeStep `json:"steps"`
+	// raw bytes in the order they were transferred (Steps is derived from them)
+	chunks []handshakeChunk
+}
+
+type handshakeChunk struct {
+	direction HandshakeDirection
+	at        time.Time
+	data      []byte
+}
+
+// ErrHandshakeFailed is the error AddPeer fails with when the handshake fails after the connection was established, holding its trace
+type ErrHandshakeFailed struct {
+	Trace *HandshakeTrace
+	Err   error
+}
+
+func (e *ErrHandshakeFailed) Error() string {
+	return fmt.Sprintf("handshake with %s failed: %s", e.Trace.Peer, e.Err)
+}
+
+func (e *ErrHandshakeFailed) Unwrap() error {
+	return e.Err
+}
+
+// tracingConn records every byte written to and read from the underlying connection
+type tracingConn struct {
+	net.Conn
+	trace *HandshakeTrace
+}
+
+func newTracingConn(conn net.Conn) *tracingConn {
+	return &tracingConn{
+		Conn:  conn,
+		trace: &HandshakeTrace{Peer: conn.RemoteAddr().String(), Start: time.Now()},
+	}
+}
+
+func (c *tracingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(HandshakeReceived, b[:n])
+	return n, err
+}
+
+func (c *tracingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(HandshakeSent, b[:n])
+	return n, err
+}
+
+func (c *tracingConn) record(direction HandshakeDirection, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	c.trace.chunks = append(c.trace.chunks, handshakeChunk{direction: direction, at: time.Now(), data: append([]byte(nil), data...)})
+}
+
+// finish completes the trace of a failed handshake, splitting the bytes transferred in each direction into messages
+func (c *tracingConn) finish(err error) *HandshakeTrace {
+	t := c.trace
+	t.Duration = time.Since(t.Start)
+	t.Error = err.Error()
+	t.Steps = splitHandshakeSteps(t.Start, t.chunks)
+	return t
+}
+
+func splitHandshakeSteps(start time.Time, chunks []handshakeChunk) []HandshakeStep {
+	type pending struct {
+		at   time.Time
+		data []byte
+	}
+	var steps []HandshakeStep
+	streams := map[HandshakeDirection]*pending{}
+	for _, chunk := range chunks {
+		data := chunk.data
+		for len(data) > 0 {
+			p := streams[chunk.direction]
+			if p == nil {
+				p = &pending{at: chunk.at}
+				streams[chunk.direction] = p
+			}
+			need := message.HeaderLength - len(p.data)
+			if need <= 0 {
+				need += int(binary.LittleEndian.Uint32(p.data[16:20]))
+			}
+			if need > len(data) {
+				p.data = append(p.data, data...)
+				break
+			}
+			p.data = append(p.data, data[:need]...)
+			data = data[need:]
+			if len(p.data) == message.HeaderLength && binary.LittleEndian.Uint32(p.data[16:20]) > 0 {
+				continue
+			}
+			steps = append(steps, newHandshakeStep(chunk.direction, p.at.Sub(start), p.data, false))
+			delete(streams, chunk.direction)
+		}
+	}
+	for _, direction := range []HandshakeDirection{HandshakeSent, HandshakeReceived} {
+		if p := streams[direction]; p != nil {
+			steps = append(steps, newHandshakeStep(direction, p.at.Sub(start), p.data, true))
+		}
+	}
+	return steps
+}
+
+func newHandshakeStep(direction HandshakeDirection, elapsed time.Duration, data []byte, incomplete bool) HandshakeStep {
+	step := HandshakeStep{Direction: direction, Elapsed: elapsed, Incomplete: incomplete}
+	if len(data) < message.HeaderLength {
+		step.Header = hex.EncodeToString(data)
+		return step
+	}
+	step.Command = message.CommandName(data[4:16]).String()
+	step.Header = hex.EncodeToString(data[:message.HeaderLength])
+	step.Payload = hex.EncodeToString(data[message.HeaderLength:])
+	step.PayloadLength = binary.LittleEndian.Uint32(data[16:20])
+	return step
+}
+
+// redacted returns a copy of the trace without the payloads of its messages
+func (t HandshakeTrace) redacted() HandshakeTrace {
+	t.Steps = append([]HandshakeStep(nil), t.Steps...)
+	for i := range t.Steps {
+		if t.Steps[i].Payload != "" {
+			t.Steps[i].Payload = ""
+			t.Steps[i].Redacted = true
+		}
+	}
+	return t
+}
+
+// handshakeTraces is a ring buffer holding the traces of the most recent failed handshakes
+type handshakeTraces struct {
+	mu     sync.Mutex
+	traces []HandshakeTrace
+	next   int
+	full   bool
+}
+
+func newHandshakeTraces(size int) *handshakeTraces {
+	return &handshakeTraces{traces: make([]HandshakeTrace, size)}
+}
+
+func (h *handshakeTraces) add(trace HandshakeTrace) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.traces) == 0 {
+		return
+	}
+	h.traces[h.next] = trace
+	h.next = (h.next + 1) % len(h.traces)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// all returns the buffered traces from oldest to newest, without message payloads if redactPayloads is set
+func (h *handshakeTraces) all(redactPayloads bool) []HandshakeTrace {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var traces []HandshakeTrace
+	if h.full {
+		traces = append(traces, h.traces[h.next:]...)
+	}
+	traces = append(traces, h.traces[:h.next]...)
+	if redactPayloads {
+		for i := range traces {
+			traces[i] = traces[i].redacted()
+		}
+	}
+	return traces
+}
@@ -0,0 +1,182 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"math/big"
+)
+
+// Number of headers between two of the hashes kept while a peer's headers are presynced, which its headers are checked against when they are
+// downloaded again
+const headersCommitmentInterval = 1000
+
+var ErrHeadersRedownloadMismatch = errors.New("headers sent again differ from the presynced ones")
+
+// headersPresync follows the headers of a peer's chain that has less than the minimum chain work without adding them to the block index,
+// keeping only the hash of one header in headersCommitmentInterval, until the chain has enough work. The headers are then downloaded again
+// from the known header the chain forks from, and added to the block index as they are checked against the kept hashes, so that headers of a
+// chain with little work cannot fill the node's memory (https://github.com/bitcoin/bitcoin/blob/v27.0/src/headerssync.h).
+type headersPresync struct {
+	// known header the peer's chain forks from
+	start blockchain.BlockNode
+	// last header received and its height
+	last   message.Hash256
+	height int32
+	// work of the presynced chain
+	work *big.Int
+	// hashes of the presynced headers at every headersCommitmentInterval heights above start and at the height the chain reached the minimum
+	// chain work
+	commitments map[int32]message.Hash256
+	// whether the presynced chain has enough work and its headers are downloaded again, the height of the last one downloaded again and the
+	// ones downloaded again since the last hash they were checked against
+	redownloading    bool
+	redownloadHeight int32
+	buffer           []message.BlockPayload
+}
+
+// parseMinimumChainWork converts the minimum chain work of a network, given in big-endian hexadecimal, into a number
+func parseMinimumChainWork(work string) (*big.Int, error) {
+	if work == "" {
+		return new(big.Int), nil
+	}
+	parsed, ok := new(big.Int).SetString(work, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid minimum chain work %q", work)
+	}
+	return parsed, nil
+}
+
+// startHeadersPresync starts presyncing the headers of peer if headers follow a known header and the chain they end has less than the minimum
+// chain work. It reports whether the peer's headers are presynced.
+func (n *Node) startHeadersPresync(peer *Peer, headers []message.BlockPayload) bool {
+	if peer.headersPresync != nil {
+		return true
+	}
+	if len(headers) == 0 || n.minimumChainWork == nil || n.minimumChainWork.Sign() == 0 {
+		return false
+	}
+	start, ok := n.blockIndex.Get(headers[0].PrevBlock)
+	if !ok {
+		return false
+	}
+	work := new(big.Int).Set(start.ChainWork)
+	for i := range headers {
+		work.Add(work, blockchain.HeaderWork(headers[i].Bits))
+	}
+	if work.Cmp(n.minimumChainWork) >= 0 {
+		return false
+	}
+	log.Printf("🧾 Presyncing the headers of peer %s from height %d, as its chain has less than the minimum chain work so far", peer.conn.RemoteAddr(),
+		start.Height)
+	peer.headersPresync = &headersPresync{
+		start:       start,
+		last:        start.Hash,
+		height:      start.Height,
+		work:        new(big.Int).Set(start.ChainWork),
+		commitments: make(map[int32]message.Hash256),
+	}
+	return true
+}
+
+// presyncHeaders follows the headers peer sent while they are presynced, returning the ones downloaded again that can be added to the block
+// index and whether the peer should be asked for the following headers. Headers that do not follow the last one received are ignored, as they
+// answer an earlier request.
+func (n *Node) presyncHeaders(peer *Peer, headers []message.BlockPayload) ([]message.BlockPayload, bool, error) {
+	p := peer.headersPresync
+	if len(headers) == 0 || headers[0].PrevBlock != p.last {
+		return nil, false, nil
+	}
+	if p.redownloading {
+		return n.redownloadHeaders(peer, headers)
+	}
+
+	for i := range headers {
+		header := &headers[i]
+		if header.PrevBlock != p.last {
+			peer.headersPresync = nil
+			peer.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent invalid headers: %s", blockchain.ErrHeadersNotContinuous))
+			return nil, false, nil
+		}
+		hash, err := header.GetBlockHash()
+		if err != nil {
+			return nil, false, err
+		}
+		err = n.blockIndex.CheckProofOfWork(header, hash)
+		if err != nil {
+			peer.headersPresync = nil
+			peer.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent invalid headers: %s", err))
+			return nil, false, nil
+		}
+		p.last, p.height = hash, p.height+1
+		p.work.Add(p.work, blockchain.HeaderWork(header.Bits))
+		if (p.height-p.start.Height)%headersCommitmentInterval == 0 {
+			p.commitments[p.height] = hash
+		}
+		if p.work.Cmp(n.minimumChainWork) >= 0 {
+			p.commitments[p.height] = hash
+			p.redownloading, p.redownloadHeight, p.last = true, p.start.Height, p.start.Hash
+			log.Printf("🧾 Headers of peer %s reached the minimum chain work at height %d. Downloading them again from height %d...",
+				peer.conn.RemoteAddr(), p.height, p.start.Height)
+			return nil, true, nil
+		}
+	}
+	if len(headers) < message.MaxHeadersResults {
+		peer.headersPresync = nil
+		log.Printf("⚠️ Ignoring the headers of peer %s, whose chain ends at height %d with less than the minimum chain work", peer.conn.RemoteAddr(),
+			p.height)
+		return nil, false, nil
+	}
+	return nil, true, nil
+}
+
+// redownloadHeaders checks the headers peer sent again against the hashes kept while they were presynced, returning the ones that were
+// checked and whether the peer should be asked for the following headers. Once the height the chain reached the minimum chain work is
+// downloaded again, the peer's headers are no longer presynced.
+func (n *Node) redownloadHeaders(peer *Peer, headers []message.BlockPayload) ([]message.BlockPayload, bool, error) {
+	p := peer.headersPresync
+	checked := make([]message.BlockPayload, 0)
+	for i := range headers {
+		header := &headers[i]
+		if header.PrevBlock != p.last {
+			peer.headersPresync = nil
+			peer.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent invalid headers: %s", blockchain.ErrHeadersNotContinuous))
+			return checked, false, nil
+		}
+		hash, err := header.GetBlockHash()
+		if err != nil {
+			return checked, false, err
+		}
+		p.last, p.redownloadHeight = hash, p.redownloadHeight+1
+		p.buffer = append(p.buffer, *header)
+		commitment, ok := p.commitments[p.redownloadHeight]
+		if !ok {
+			continue
+		}
+		if hash != commitment {
+			peer.headersPresync = nil
+			peer.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("%s: %s at height %d is not %s", ErrHeadersRedownloadMismatch, hash,
+				p.redownloadHeight, commitment))
+			return checked, false, nil
+		}
+		checked = append(checked, p.buffer...)
+		p.buffer = nil
+		if p.redownloadHeight == p.height {
+			peer.headersPresync = nil
+			log.Printf("🧾 Downloaded the headers of peer %s again up to height %d", peer.conn.RemoteAddr(), p.height)
+			// the rest of the headers follow a chain with enough work
+			checked = append(checked, headers[i+1:]...)
+			return checked, len(headers) == message.MaxHeadersResults, nil
+		}
+	}
+	if len(headers) < message.MaxHeadersResults {
+		peer.headersPresync = nil
+		log.Printf("⚠️ Peer %s stopped sending headers at height %d before the presynced height %d", peer.conn.RemoteAddr(), p.redownloadHeight,
+			p.height)
+		return checked, false, nil
+	}
+	return checked, true, nil
+}
@@ -0,0 +1,85 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newPresyncTestNode returns a node whose minimum chain work is reached by the chain of minimumHeight headers at regtest's proof of work
+// limit, and a peer connected to it
+func newPresyncTestNode(t *testing.T, minimumHeight int) (*Node, *Peer, *networkingtest.Conn) {
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	genesis, ok := node.blockIndex.Get(message.Hash256(constants.GenesisBlockHash))
+	require.True(t, ok)
+	work := new(big.Int).Mul(blockchain.HeaderWork(easyBits), big.NewInt(int64(minimumHeight)))
+	node.minimumChainWork = work.Add(work, genesis.ChainWork)
+	fakePeer := networkingtest.NewFakePeer(t)
+	peer, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	return node, peer, fakePeer.Accept(time.Second)
+}
+
+// sendHeaders passes headers to the node as if peer had sent them
+func sendHeaders(t *testing.T, node *Node, peer *Peer, headers []message.BlockPayload) {
+	require.NoError(t, node.handleHeadersMsg(&headersPayloadWithSender{HeadersPayload: &message.HeadersPayload{Headers: headers}, Sender: peer}))
+}
+
+// expectGetHeaders waits for the node to ask for the headers following locator
+func expectGetHeaders(t *testing.T, conn *networkingtest.Conn, locator message.Hash256) {
+	getHeaders := conn.Expect(message.GetHeadersCommand, time.Second).Payload.(*message.GetHeadersPayload)
+	require.Equal(t, locator, getHeaders.BlockLocatorHashes[0])
+}
+
+func TestNode_PresyncsHeadersWithLittleWork(t *testing.T) {
+	genesisHash := message.Hash256(constants.GenesisBlockHash)
+	headers, hashes := createHeaders(t, genesisHash, 2500, easyBits, 0)
+
+	t.Run("headers should be added once their chain has the minimum chain work", func(t *testing.T) {
+		node, peer, conn := newPresyncTestNode(t, 2200)
+		sendHeaders(t, node, peer, headers[:2000])
+		require.Zero(t, node.blockIndex.BestHeight())
+		expectGetHeaders(t, conn, hashes[1999])
+
+		// the chain reaches the minimum chain work at height 2200, so its headers are downloaded again from the genesis block
+		sendHeaders(t, node, peer, headers[2000:])
+		require.Zero(t, node.blockIndex.BestHeight())
+		expectGetHeaders(t, conn, genesisHash)
+
+		sendHeaders(t, node, peer, headers[:2000])
+		require.Equal(t, int32(2000), node.blockIndex.BestHeight())
+		expectGetHeaders(t, conn, hashes[1999])
+		sendHeaders(t, node, peer, headers[2000:])
+		require.Equal(t, int32(2500), node.blockIndex.BestHeight())
+		require.Nil(t, peer.headersPresync)
+	})
+
+	t.Run("headers of a chain without the minimum chain work should be dropped", func(t *testing.T) {
+		node, peer, _ := newPresyncTestNode(t, 2200)
+		sendHeaders(t, node, peer, headers[:100])
+		require.Zero(t, node.blockIndex.BestHeight())
+		require.Nil(t, peer.headersPresync)
+	})
+
+	t.Run("a peer sending other headers again should be banned", func(t *testing.T) {
+		node, peer, conn := newPresyncTestNode(t, 2200)
+		sendHeaders(t, node, peer, headers[:2000])
+		expectGetHeaders(t, conn, hashes[1999])
+		sendHeaders(t, node, peer, headers[2000:])
+		expectGetHeaders(t, conn, genesisHash)
+
+		other, _ := createHeaders(t, genesisHash, 2000, easyBits, 100)
+		sendHeaders(t, node, peer, other)
+		require.Zero(t, node.blockIndex.BestHeight())
+		require.Nil(t, peer.headersPresync)
+		require.Eventually(t, func() bool { return node.banManager.IsBanned(peer.conn.RemoteAddr().(*net.TCPAddr).IP) }, time.Second,
+			10*time.Millisecond)
+	})
+}
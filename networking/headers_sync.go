@@ -0,0 +1,73 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/tracing"
+	"log"
+)
+
+type headersPayloadWithSender struct {
+	HeadersPayload *message.HeadersPayload
+	Sender         *Peer
+}
+
+// handleHeadersMsg adds the headers a peer sent to the block index, asks the peer for the following headers if it sent as many as a headers
+// message can hold, and requests the blocks of the best chain we do not have yet. The headers of a chain with less than the minimum chain work
+// are presynced instead (see headersPresync).
+func (n *Node) handleHeadersMsg(msg *headersPayloadWithSender) error {
+	headers := msg.HeadersPayload.Headers
+	requestMore := len(headers) == message.MaxHeadersResults
+	if n.startHeadersPresync(msg.Sender, headers) {
+		var err error
+		headers, requestMore, err = n.presyncHeaders(msg.Sender, headers)
+		if err != nil {
+			return err
+		}
+	}
+	added, err := n.blockIndex.AddHeaders(headers)
+	if errors.Is(err, blockchain.ErrHeadersDoNotConnect) {
+		// the peer may be on a chain whose start we do not know, which we will ask it for the next time we sync from it
+		log.Printf("Headers sent by peer %s do not connect to our block index: %s", msg.Sender.conn.RemoteAddr(), err)
+		return nil
+	}
+	if err != nil {
+		msg.Sender.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent invalid headers: %s", err))
+		return nil
+	}
+	log.Printf("🧾 Added %d of the %d headers sent by peer %s (best header height: %d)", added, len(headers), msg.Sender.conn.RemoteAddr(),
+		n.blockIndex.BestHeight())
+	if added > 0 {
+		// the headers may connect blocks that were received before them
+		err = n.activateBestChain(tracing.SpanContext{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if requestMore {
+		err = n.requestNewBlocksFrom(msg.Sender)
+		if err != nil {
+			return err
+		}
+	}
+	n.scheduleBlockDownloads()
+	return nil
+}
+
+// requestNewBlocksFrom sends peer a getheaders message for the headers following our best header, whose blocks are downloaded once the peer
+// answers, or following the last header received from the peer while its headers are presynced
+func (n *Node) requestNewBlocksFrom(peer *Peer) error {
+	locator := n.blockIndex.Locator()
+	if presync := peer.headersPresync; presync != nil {
+		// the presynced headers are not in the block index
+		locator = []message.Hash256{presync.last}
+	}
+	log.Printf("sending getheaders message with best header %s", locator[0].String())
+	zeroBlockHash := message.Hash256{}
+	// hashStop set to zero to get as many headers as possible (2000)
+	return peer.sendGetHeadersMsg(n.protocolVersion, locator, zeroBlockHash)
+}
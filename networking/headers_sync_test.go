@@ -0,0 +1,77 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// regtest's proof of work limit, which makes headers weigh little
+const easyBits = 0x207fffff
+
+// createHeaders returns length headers following prev (their proof of work is not valid) and their hashes
+func createHeaders(t *testing.T, prev message.Hash256, length int, bits uint32, nonce uint32) ([]message.BlockPayload, []message.Hash256) {
+	headers := make([]message.BlockPayload, length)
+	hashes := make([]message.Hash256, length)
+	for i := range length {
+		headers[i] = message.BlockPayload{Version: 1, PrevBlock: prev, Timestamp: uint32(1231006505 + i), Bits: bits, Nonce: nonce + uint32(i)}
+		hash, err := headers[i].GetBlockHash()
+		require.NoError(t, err)
+		hashes[i], prev = hash, hash
+	}
+	return headers, hashes
+}
+
+// skipProofOfWork makes the node accept headers whose proof of work is not valid
+func skipProofOfWork(node *Node) {
+	node.blockIndex = blockchain.NewBlockIndex(func(header *message.BlockPayload, hash message.Hash256) error { return nil }, constants.MaxOrphanBlocks)
+	node.blockIndex.SetGenesisBlock(mainnetGenesisBlock, mainnetGenesisHash)
+}
+
+func TestNode_SyncsHeadersBeforeBlocks(t *testing.T) {
+	genesis := networkingtest.GenesisBlock(t)
+	headers, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 3, easyBits, 0)
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.AnswerGetHeaders(genesis, &headers[0], &headers[1], &headers[2])
+	node := newFakePeerNode(t, 20*time.Second)
+	skipProofOfWork(node)
+	require.NoError(t, node.addBlockToNode(genesis))
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	require.NoError(t, node.requestForNewBlocks())
+
+	getData := conn.Expect(message.GetDataCommand, time.Second).Payload.(*message.GetDataPayload)
+	require.Equal(t, networkingtest.BlockInventory(t, &headers[0], &headers[1], &headers[2]), getData.InventoryList)
+	require.Equal(t, int32(3), node.blockIndex.BestHeight())
+	_, ok := node.blocksInFlight.Get(hashes[2])
+	require.True(t, ok)
+}
+
+func TestNode_BansFakePeerSendingInvalidHeaders(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	headers, _ := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 1, constants.PowLimitBits, 0)
+	headersMsg, err := message.NewHeadersMessage(headers)
+	require.NoError(t, err)
+	conn.Send(headersMsg)
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		require.FailNow(t, "peer sending invalid headers was not disconnected")
+	}
+	require.Eventually(t, func() bool { return node.banManager.IsBanned(fakePeer.Addr().IP) }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,76 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"log"
+	"time"
+)
+
+// Protocol version from which peers understand feefilter messages (https://bips.dev/133/)
+const feeFilterVersion = 70013
+
+// IsInitialBlockDownload reports whether the node is still catching up with the chain: the best chain has blocks we do not have and the tip of
+// the active chain is older than constants.MaxTipAge, or the tip is more than constants.MaxTipHeightLag blocks below the best height the peers
+// advertised when they connected. Once the tip is recent and not behind the peers, the node has caught up and the initial block download never
+// starts again (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L1712).
+//
+// During the initial block download the node ignores the transactions it is sent, asks its peers not to send any with a feefilter message, and
+// neither advertises its address nor relays the addresses it learns.
+func (n *Node) IsInitialBlockDownload() bool {
+	if n.caughtUp.Load() {
+		return false
+	}
+	tip := n.blockIndex.Tip()
+	stale := time.Since(time.Unix(int64(tip.Timestamp), 0)) > constants.MaxTipAge
+	behindPeers := tip.Height+constants.MaxTipHeightLag < n.bestPeerHeight()
+	if !stale && !behindPeers {
+		if n.caughtUp.CompareAndSwap(false, true) {
+			log.Printf("✅ Finished the initial block download at height %d", tip.Height)
+		}
+		return false
+	}
+	return behindPeers || (stale && tip.Height < n.blockIndex.BestHeight())
+}
+
+// bestPeerHeight returns the best height the connected peers advertised when they connected
+func (n *Node) bestPeerHeight() int32 {
+	var best int32
+	for _, peer := range n.peers.Keys() {
+		best = max(best, peer.Capabilities().StartHeight)
+	}
+	return best
+}
+
+// feeFilter returns the fee rate below which the node asks its peers not to announce transactions: during the initial block download, no
+// transaction pays enough (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L5355), and afterwards the minimum fee rate
+// of the mempool or the minimum relay fee rate, whichever is higher
+func (n *Node) feeFilter() int64 {
+	if n.IsInitialBlockDownload() {
+		return constants.MaxMoney
+	}
+	return max(n.mempool.MinFeeRate(), n.mempool.Policy().MinRelayFeeRate)
+}
+
+// sendFeeFilterTo sends the node's fee filter to peer, if the peer understands feefilter messages and it is more than a third above or a
+// quarter below the last one it was sent, so that the slowly decaying minimum fee rate of the mempool is not sent over and over
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+func (n *Node) sendFeeFilterTo(peer *Peer) {
+	if peer.Capabilities().ProtocolVersion < feeFilterVersion {
+		return
+	}
+	feeRate := n.feeFilter()
+	if sent := peer.sentFeeFilter.Load(); feeRate >= 3*sent/4 && feeRate <= 4*sent/3 {
+		return
+	}
+	err := peer.sendFeeFilterMsg(feeRate)
+	if err != nil {
+		log.Printf("⚠️ Could not send a fee filter to peer %s due to error: %s", peer.conn.RemoteAddr(), err)
+	}
+}
+
+// updateFeeFilters sends the node's fee filter to the peers whose last one differs, e.g. once the initial block download is over
+func (n *Node) updateFeeFilters() {
+	for _, peer := range n.peers.Keys() {
+		n.sendFeeFilterTo(peer)
+	}
+}
@@ -0,0 +1,48 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_IsInitialBlockDownloadWhileBehindPeers(t *testing.T) {
+	node, _, peers, _ := newDownloadTestNode(t, 0)
+	require.False(t, node.IsInitialBlockDownload())
+
+	peers[0].version.StartHeight = constants.MaxTipHeightLag + 1
+	require.True(t, node.IsInitialBlockDownload())
+}
+
+func TestNode_GatesRelayDuringInitialBlockDownload(t *testing.T) {
+	node, _, peers, conns := newDownloadTestNode(t, 40)
+	useTestCoins(node.mempool)
+	require.True(t, node.IsInitialBlockDownload())
+	feeFilter := conns[peers[0]].Expect(message.FeeFilterCommand, time.Second).Payload.(*message.FeeFilterPayload)
+	require.Equal(t, constants.MaxMoney, feeFilter.FeeRate)
+
+	// transactions and addresses are ignored
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})})
+	require.Zero(t, node.mempool.Len())
+	node.addrRelay.push(message.Address{}, peers[0])
+	node.relayAddrs()
+	require.Empty(t, node.addrRelay.drain())
+
+	// a recent tip ends the initial block download for good, and lowers the fee filter to the minimum relay fee rate
+	genesis := networkingtest.GenesisBlock(t)
+	recent := message.BlockPayload{Version: 1, PrevBlock: message.Hash256(constants.GenesisBlockHash), Timestamp: uint32(time.Now().Unix()),
+		Bits: easyBits, Transactions: genesis.Transactions}
+	require.NoError(t, node.addBlockToNode(&recent))
+	require.False(t, node.IsInitialBlockDownload())
+	node.updateFeeFilters()
+	feeFilter = conns[peers[0]].Expect(message.FeeFilterCommand, time.Second).Payload.(*message.FeeFilterPayload)
+	require.Equal(t, constants.DefaultMinRelayFeeRate, feeFilter.FeeRate)
+
+	peers[0].version.StartHeight = constants.MaxTipHeightLag + 100
+	require.False(t, node.IsInitialBlockDownload())
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})})
+	require.Equal(t, 1, node.mempool.Len())
+}
@@ -0,0 +1,213 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"log"
+	"net"
+	"strings"
+)
+
+var (
+	ErrPeerNotAllowed      = errors.New("peer is not allowed to connect through this binding")
+	ErrTooManyInboundPeers = errors.New("too many inbound peers")
+	ErrNodeHasQuit         = errors.New("node has quit")
+)
+
+// Binding is an address the node accepts inbound connections on, together with the permissions of the peers connecting through it
+type Binding struct {
+	Addr string
+	// Onion marks the binding a local Tor onion service forwards its connections to, so that its peers are labelled as onion peers
+	Onion bool
+	// NoBan exempts the binding's peers from banning
+	NoBan bool
+	// Allow restricts the binding to peers from these networks, if it is not empty
+	Allow []*net.IPNet
+}
+
+// ParseBinding parses a binding given as host:port followed by comma-separated options: "onion", "noban" and "allow=<cidr>" (which can be repeated),
+// e.g. "10.0.0.5:8333,noban,allow=10.0.0.0/8"
+func ParseBinding(s string) (Binding, error) {
+	parts := strings.Split(s, ",")
+	binding := Binding{Addr: parts[0]}
+	_, _, err := net.SplitHostPort(binding.Addr)
+	if err != nil {
+		return Binding{}, err
+	}
+	for _, option := range parts[1:] {
+		switch {
+		case option == "onion":
+			binding.Onion = true
+		case option == "noban":
+			binding.NoBan = true
+		case strings.HasPrefix(option, "allow="):
+			_, ipNet, err := net.ParseCIDR(strings.TrimPrefix(option, "allow="))
+			if err != nil {
+				return Binding{}, err
+			}
+			binding.Allow = append(binding.Allow, ipNet)
+		default:
+			return Binding{}, fmt.Errorf("unknown binding option %q", option)
+		}
+	}
+	return binding, nil
+}
+
+func (b Binding) allows(ip net.IP) bool {
+	if len(b.Allow) == 0 {
+		return true
+	}
+	for _, ipNet := range b.Allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type listener struct {
+	*net.TCPListener
+	binding Binding
+}
+
+// Listen starts accepting inbound connections on every binding. Either all bindings are listened on or none is.
+func (n *Node) Listen(bindings []Binding) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return ErrNodeHasQuit
+	}
+
+	listeners := make([]listener, 0, len(bindings))
+	for _, binding := range bindings {
+		addr, err := net.ResolveTCPAddr("tcp", binding.Addr)
+		if err == nil {
+			var ln *net.TCPListener
+			ln, err = net.ListenTCP("tcp", addr)
+			if err == nil {
+				listeners = append(listeners, listener{TCPListener: ln, binding: binding})
+				continue
+			}
+		}
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		return fmt.Errorf("could not listen on %s: %w", binding.Addr, err)
+	}
+
+	for _, l := range listeners {
+		log.Printf("👂 Listening for inbound connections on %s", l.Addr())
+		go n.acceptLoop(l)
+	}
+	n.listeners = append(n.listeners, listeners...)
+	return nil
+}
+
+// ListenAddrs returns the addresses the node accepts inbound connections on
+func (n *Node) ListenAddrs() []*net.TCPAddr {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	addrs := make([]*net.TCPAddr, len(n.listeners))
+	for i, l := range n.listeners {
+		addrs[i] = l.Addr().(*net.TCPAddr)
+	}
+	return addrs
+}
+
+// advertisedPort returns the port of the first clearnet listener, if there is one
+func (n *Node) advertisedPort() (uint16, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, l := range n.listeners {
+		if !l.binding.Onion {
+			return uint16(l.Addr().(*net.TCPAddr).Port), true
+		}
+	}
+	return 0, false
+}
+
+func (n *Node) closeListeners() {
+	for _, l := range n.listeners {
+		_ = l.Close()
+	}
+}
+
+func (n *Node) acceptLoop(l listener) {
+	for {
+		conn, err := l.AcceptTCP()
+		if err != nil {
+			select {
+			case <-n.quitCh:
+			default:
+				log.Printf("⚠️ Stopped listening on %s due to error: %s", l.Addr(), err)
+			}
+			return
+		}
+		go func() {
+			_, err := n.acceptPeer(conn, l.binding)
+			if err != nil {
+				log.Printf("❌ Could not accept peer %s due to error: %s (Current peer count: %d)", conn.RemoteAddr(), err, n.peers.Len())
+			}
+		}()
+	}
+}
+
+func (n *Node) acceptPeer(conn *net.TCPConn, binding Binding) (*Peer, error) {
+	remoteAddr, err := getRemoteAddr(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !binding.allows(remoteAddr.IP) {
+		_ = conn.Close()
+		return nil, ErrPeerNotAllowed
+	}
+	if !binding.NoBan && n.banManager.IsBanned(remoteAddr.IP) {
+		_ = conn.Close()
+		return nil, ErrPeerBanned
+	}
+	if n.inboundPeersCount() >= constants.MaxInboundPeers {
+		_ = conn.Close()
+		return nil, ErrTooManyInboundPeers
+	}
+
+	nonce := NewNonce()
+	n.localNonces.Set(nonce, struct{}{})
+	defer n.localNonces.Delete(nonce)
+
+	h, err := acceptHandshake(n.ctx, conn, n.tcpDialTimeout, n.params.Magic, n.services, nonce)
+	if err != nil {
+		var handshakeErr *ErrHandshakeFailed
+		if errors.As(err, &handshakeErr) {
+			n.handshakeTraces.add(*handshakeErr.Trace)
+		}
+		return nil, err
+	}
+	p, err := n.registerPeer(conn, h, func(p *Peer) {
+		p.direction = Inbound
+		if binding.Onion {
+			p.network = NetworkOnion
+		}
+		p.noBan = binding.NoBan
+	})
+	if err != nil {
+		return nil, err
+	}
+	go p.Start(n.ctx)
+	n.sendFeeFilterTo(p)
+	return p, nil
+}
+
+func (n *Node) inboundPeersCount() int {
+	count := 0
+	for _, peer := range n.peers.Keys() {
+		if peer.direction == Inbound {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,125 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseBinding(t *testing.T) {
+	binding, err := ParseBinding("10.0.0.5:8333,noban,allow=10.0.0.0/8,allow=fd00::/8")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.5:8333", binding.Addr)
+	require.True(t, binding.NoBan)
+	require.False(t, binding.Onion)
+	require.Len(t, binding.Allow, 2)
+	require.True(t, binding.allows(net.ParseIP("10.1.2.3")))
+	require.True(t, binding.allows(net.ParseIP("fd00::1")))
+	require.False(t, binding.allows(net.ParseIP("1.2.3.4")))
+
+	binding, err = ParseBinding("127.0.0.1:8334,onion")
+	require.NoError(t, err)
+	require.True(t, binding.Onion)
+	require.True(t, binding.allows(net.ParseIP("1.2.3.4")))
+
+	for _, invalid := range []string{"127.0.0.1", "127.0.0.1:8333,whitelist", "127.0.0.1:8333,allow=10.0.0.0"} {
+		_, err = ParseBinding(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func newListeningNode(t *testing.T, bindings ...Binding) *Node {
+	node := NewNode(testConfig(storage.NewMemFS()))
+	require.NoError(t, node.Listen(bindings))
+	t.Cleanup(func() { _ = node.Stop(context.Background()) })
+	return node
+}
+
+func TestNode_Listen(t *testing.T) {
+	t.Run("inbound peers should be labelled by their binding", func(t *testing.T) {
+		node := newListeningNode(t, Binding{Addr: "127.0.0.1:0", Onion: true, NoBan: true})
+
+		conn, _, err := performHandshake(context.Background(), &net.Dialer{Timeout: time.Second}, node.ListenAddrs()[0], constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.Eventually(t, func() bool { return node.peers.Len() == 1 }, time.Second, 10*time.Millisecond)
+		peer, _ := node.peers.GetRandomKey()
+		require.Equal(t, Inbound, peer.direction)
+		require.Equal(t, NetworkOnion, peer.network)
+		require.True(t, peer.noBan)
+	})
+
+	t.Run("peers outside the allowed networks should be rejected", func(t *testing.T) {
+		allow, err := ParseBinding("127.0.0.1:0,allow=10.0.0.0/8")
+		require.NoError(t, err)
+		node := newListeningNode(t, allow)
+
+		_, _, err = performHandshake(context.Background(), &net.Dialer{Timeout: time.Second}, node.ListenAddrs()[0], constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+		require.Error(t, err)
+		require.Zero(t, node.peers.Len())
+	})
+
+	t.Run("no binding should be listened on if one fails", func(t *testing.T) {
+		node := newListeningNode(t)
+		err := node.Listen([]Binding{{Addr: "127.0.0.1:0"}, {Addr: "256.0.0.1:0"}})
+		require.Error(t, err)
+		require.Empty(t, node.ListenAddrs())
+	})
+}
+
+func TestNode_ExternalAddrNeedsClearnetListener(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		bindings []Binding
+		ok       bool
+	}{
+		{name: "no listener", ok: false},
+		{name: "onion listener only", bindings: []Binding{{Addr: "127.0.0.1:0", Onion: true}}, ok: false},
+		{name: "clearnet listener", bindings: []Binding{{Addr: "127.0.0.1:0", Onion: true}, {Addr: "127.0.0.1:0"}}, ok: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			node := newListeningNode(t, test.bindings...)
+			node.SetExternalIP(net.ParseIP("203.0.113.5"))
+
+			addr, ok := node.ExternalAddr()
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.ok, node.NetworkInfo().LocalAddr != nil)
+			if test.ok {
+				// the port peers can connect to, not the default port of the network
+				require.Equal(t, node.ListenAddrs()[1].Port, addr.Port)
+			}
+		})
+	}
+}
+
+func TestNode_AnswersGetAddrFromInboundPeers(t *testing.T) {
+	node := newListeningNode(t, Binding{Addr: "127.0.0.1:0"})
+	known := newTestAddress("8.8.8.8", 8333, time.Now())
+	node.addrMan.Add(known)
+	node.addrMan.Add(newTestAddress("8.8.4.4", 8333, time.Now().Add(-2*constants.AddrHorizon)))
+
+	conn, _, err := performHandshake(context.Background(), &net.Dialer{Timeout: time.Second}, node.ListenAddrs()[0], constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	require.NoError(t, err)
+	defer conn.Close()
+	getAddrMsg, err := message.NewGetAddrMessage()
+	require.NoError(t, err)
+	require.NoError(t, handshakeConn{Conn: conn, magic: constants.MainnetMagicValue}.writeMessage(getAddrMsg))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		msg, err := message.DecodeMessage(conn)
+		require.NoError(t, err)
+		if msg.Header.Command == message.AddrCommand {
+			addresses := msg.Payload.(*message.AddrPayload).AddressList
+			require.Len(t, addresses, 1)
+			require.Equal(t, tcpAddressOf(known), tcpAddressOf(addresses[0]))
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"time"
+)
+
+var (
+	ErrPeerNotFound       = errors.New("peer not found")
+	ErrBlockAlreadyStored = errors.New("block is already stored")
+	ErrInvalidScanRange   = errors.New("invalid scan height range")
+)
+
+// Resync starts a new round of synchronization, as if the node had just started: the blocks in flight are forgotten so that the ones which
+// never arrived are requested again, every connected peer serving blocks is asked for the headers following our best header, and the missing
+// blocks of the best header chain are requested. It returns the number of peers asked for headers.
+func (n *Node) Resync() int {
+	log.Printf("🔁 Resyncing as requested, forgetting %d blocks in flight", n.blocksInFlight.Len())
+	for _, hash := range n.blocksInFlight.Keys() {
+		n.blocksInFlight.Delete(hash)
+	}
+	asked := 0
+	for _, peer := range n.peers.Keys() {
+		if !peer.Capabilities().HasServices(message.NodeNetwork) {
+			continue
+		}
+		err := n.requestNewBlocksFrom(peer)
+		if err != nil {
+			log.Printf("⚠️ Could not ask peer %s for headers due to error: %s", peer.conn.RemoteAddr(), err)
+			continue
+		}
+		asked++
+	}
+	n.scheduleBlockDownloads()
+	return asked
+}
+
+// RequestBlock asks the connected peer whose PeerInfo.Id is peerId for the block with hash hash, like bitcoind's getblockfrompeer. The block
+// is stored when it arrives even if it is not in the best chain, e.g. to look at a stale block. It fails with ErrBlockNotFound if the header
+// of the block is not known, with ErrBlockAlreadyStored if the block is stored and with ErrPeerNotFound if no connected peer has that id.
+func (n *Node) RequestBlock(hash message.Hash256, peerId int64) error {
+	node, ok := n.blockIndex.Get(hash)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	if node.Status.Has(blockchain.StatusHaveData) {
+		return fmt.Errorf("%w: %s", ErrBlockAlreadyStored, hash)
+	}
+	var peer *Peer
+	for _, p := range n.peers.Keys() {
+		if p.id == peerId {
+			peer = p
+		}
+	}
+	if peer == nil {
+		return fmt.Errorf("%w: %d", ErrPeerNotFound, peerId)
+	}
+
+	log.Printf("Requesting block %s from peer %s as requested", hash, peer.conn.RemoteAddr())
+	n.blocksInFlight.Set(hash, blockRequest{peer: peer, requestedAt: time.Now()})
+	err := n.sendGetBlockDataMsg(peer, []message.Hash256{hash})
+	if err != nil {
+		n.blocksInFlight.Delete(hash)
+		return err
+	}
+	return nil
+}
+
+// BlockScan lists the blocks of a range of the active chain which are relevant to scripts
+type BlockScan struct {
+	FromHeight int32
+	ToHeight   int32
+	// Hashes of the blocks whose filter matches one of the scripts, from the lowest. As filters have false positives, a few of them may be
+	// irrelevant.
+	RelevantBlocks []message.Hash256
+}
+
+// ScanBlocks looks up the blocks of the active chain from startHeight to stopHeight (the tip if negative) which create or spend outputs paying
+// one of scripts, by matching the scripts against the basic filters of the blocks (see bitcoind's scanblocks). Blocks whose filter is not
+// indexed are skipped. It fails with ErrBlockFilterIndexDisabled if the node has no block filter index.
+func (n *Node) ScanBlocks(scripts [][]byte, startHeight int32, stopHeight int32) (BlockScan, error) {
+	if n.filterIndex == nil {
+		return BlockScan{}, ErrBlockFilterIndexDisabled
+	}
+	tip := n.blockIndex.Tip()
+	if stopHeight < 0 {
+		stopHeight = tip.Height
+	}
+	if startHeight < 0 || startHeight > stopHeight || stopHeight > tip.Height {
+		return BlockScan{}, fmt.Errorf("%w: %d to %d, with the tip at height %d", ErrInvalidScanRange, startHeight, stopHeight, tip.Height)
+	}
+
+	scan := BlockScan{FromHeight: startHeight, ToHeight: stopHeight, RelevantBlocks: []message.Hash256{}}
+	for height := startHeight; height <= stopHeight; height++ {
+		node, ok := n.blockIndex.ActiveBlock(height)
+		if !ok {
+			// the active chain changed during the scan
+			scan.ToHeight = height - 1
+			break
+		}
+		filter, err := n.filterIndex.Filter(node.Hash)
+		if errors.Is(err, ErrBlockFilterNotFound) {
+			continue
+		}
+		if err != nil {
+			return BlockScan{}, err
+		}
+		match, err := blockfilter.MatchAny(node.Hash, filter, scripts)
+		if err != nil {
+			return BlockScan{}, err
+		}
+		if match {
+			scan.RelevantBlocks = append(scan.RelevantBlocks, node.Hash)
+		}
+	}
+	return scan, nil
+}
@@ -0,0 +1,86 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_Resync(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 4)
+	// requested from the first peer, which never sent them
+	requestedAt := time.Now().Add(-time.Second)
+	for _, hash := range hashes {
+		node.blocksInFlight.Set(hash, blockRequest{peer: peers[0], requestedAt: requestedAt})
+	}
+
+	require.Equal(t, 2, node.Resync())
+
+	for _, peer := range peers {
+		getHeaders := conns[peer].Expect(message.GetHeadersCommand, time.Second).Payload.(*message.GetHeadersPayload)
+		require.Equal(t, node.blockIndex.Locator(), getHeaders.BlockLocatorHashes)
+	}
+	// the blocks are requested again
+	for _, hash := range hashes {
+		request, ok := node.blocksInFlight.Get(hash)
+		require.True(t, ok)
+		require.True(t, request.requestedAt.After(requestedAt))
+	}
+}
+
+func TestNode_RequestBlock(t *testing.T) {
+	node, hashes, peers, conns := newDownloadTestNode(t, 2)
+
+	require.NoError(t, node.RequestBlock(hashes[1], peers[1].id))
+	require.Equal(t, []message.Hash256{hashes[1]}, requestedBlocks(t, conns[peers[1]]))
+	request, ok := node.blocksInFlight.Get(hashes[1])
+	require.True(t, ok)
+	require.Equal(t, peers[1], request.peer)
+
+	require.ErrorIs(t, node.RequestBlock(message.Hash256{1}, peers[1].id), ErrBlockNotFound)
+	require.ErrorIs(t, node.RequestBlock(message.Hash256(constants.GenesisBlockHash), peers[1].id), ErrBlockAlreadyStored)
+	require.ErrorIs(t, node.RequestBlock(hashes[0], 1000), ErrPeerNotFound)
+}
+
+func TestNode_ScanBlocks(t *testing.T) {
+	blocks, hashes := createSnapshotChain(t, constants.CoinbaseMaturity+2)
+	last := len(blocks) - 1
+	blocks[0].Transactions[0].TransactionOutputs[0].PkScript = []byte{0x52}
+	coinbaseId, err := blocks[0].Transactions[0].GetTxId()
+	require.NoError(t, err)
+	// spends the coinbase of the first block in the last, once it has matured, so that its script is in the filters of the first and last
+	// blocks
+	blocks[last].Transactions = append(blocks[last].Transactions, message.TxPayload{
+		Version:            1,
+		TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: coinbaseId}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs: []message.TxOut{{Value: 50, PkScript: []byte{0x00, 0x14, 0x01}}},
+	})
+
+	fs := storage.NewMemFS()
+	node := newBlockStoreNode(t, fs)
+	_, err = node.ScanBlocks([][]byte{{0x52}}, 0, -1)
+	require.ErrorIs(t, err, ErrBlockFilterIndexDisabled)
+	index, err := OpenBlockFilterIndex(fs, "blockfilter.kv")
+	require.NoError(t, err)
+	require.NoError(t, node.SetBlockFilterIndex(index))
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+
+	scan, err := node.ScanBlocks([][]byte{{0x52}}, 0, -1)
+	require.NoError(t, err)
+	require.Equal(t, BlockScan{FromHeight: 0, ToHeight: int32(len(blocks)), RelevantBlocks: []message.Hash256{hashes[0], hashes[last]}}, scan)
+
+	scan, err = node.ScanBlocks([][]byte{{0x00, 0x14, 0x01}, {0x53}}, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, BlockScan{FromHeight: 1, ToHeight: 2, RelevantBlocks: []message.Hash256{}}, scan)
+
+	for _, heights := range [][2]int32{{-1, 2}, {3, 2}, {0, int32(len(blocks)) + 1}} {
+		_, err = node.ScanBlocks([][]byte{{0x52}}, heights[0], heights[1])
+		require.ErrorIs(t, err, ErrInvalidScanRange)
+	}
+}
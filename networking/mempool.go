@@ -0,0 +1,382 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/aang114/bitcoin-node/utxo"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTxAlreadyInMempool = errors.New("transaction is already in the mempool")
+	// the error of utxo, so that the transactions utxo.CheckTxInputs rejects are invalid transactions of the mempool too
+	ErrInvalidTx = utxo.ErrInvalidTransaction
+	// some of the outputs the transaction spends are neither unspent outputs of the active chain nor outputs of transactions of the mempool,
+	// which the transaction may be waiting for rather than be invalid
+	ErrMissingInputs = errors.New("missing inputs")
+	// the transaction spends an output a transaction of the mempool already spends, and cannot replace it
+	ErrTxConflict = errors.New("transaction conflicts with the mempool")
+	// the transaction pays less than the minimum fee rate of the mempool, which rises when transactions are evicted from a full mempool
+	ErrMempoolMinFee = errors.New("mempool min fee not met")
+	ErrMempoolFull   = errors.New("mempool full")
+	// the transaction would have more ancestors or descendants in the mempool than the package limits allow
+	ErrTooLongMempoolChain = errors.New("too long mempool chain")
+)
+
+// MempoolEntry is an unconfirmed transaction kept in the mempool
+type MempoolEntry struct {
+	Tx    *message.TxPayload
+	TxId  message.Hash256
+	WtxId message.Hash256
+	// Virtual size of the transaction in bytes (BIP 141)
+	VSize int
+	// Fee paid by the transaction in satoshis, which is known for every transaction of the mempool as transactions whose inputs are missing
+	// are rejected
+	Fee     *int64
+	AddedAt time.Time
+	// memory taken by the entry, counted towards the size limit of the mempool
+	usage int
+	// transactions of the mempool whose outputs the transaction spends, and which spend its outputs, only accessed with the mempool's mu held
+	parents  map[*MempoolEntry]struct{}
+	children map[*MempoolEntry]struct{}
+	// sums over the transaction and its ancestors, and over the transaction and its descendants, only accessed with the mempool's mu held
+	ancestorStats   PackageStats
+	descendantStats PackageStats
+}
+
+// knownFee returns the fee paid by the transaction, counting unknown fees as 0
+func (e *MempoolEntry) knownFee() int64 {
+	if e.Fee == nil {
+		return 0
+	}
+	return *e.Fee
+}
+
+// FeeRate returns the fee rate of the transaction in satoshis per 1000 virtual bytes, if its fee is known
+func (e *MempoolEntry) FeeRate() (int64, bool) {
+	if e.Fee == nil {
+		return 0, false
+	}
+	return *e.Fee * 1000 / int64(e.VSize), true
+}
+
+// coinView returns the unspent output of the active chain outpoint refers to, if there is one
+type coinView func(outpoint message.OutPoint) (utxo.Coin, bool, error)
+
+// Mempool holds the unconfirmed transactions the node received, keyed by txid
+type Mempool struct {
+	// serializes the changes to the mempool, which must keep txs, wtxIds and spends consistent
+	mu  sync.Mutex
+	txs *SafeMap[message.Hash256, *MempoolEntry]
+	// txids of the transactions, keyed by wtxid, for the peers asking for transactions by wtxid (BIP 339)
+	wtxIds *SafeMap[message.Hash256, message.Hash256]
+	// txids of the transactions of the mempool, keyed by the outpoints they spend, only accessed with mu held
+	spends map[message.OutPoint]message.Hash256
+	// unspent outputs of the active chain the transactions may spend besides the outputs of the mempool, and the height of its tip, which
+	// the coinbases they spend must be buried under. The mempool is made without a chain, whose outputs are all missing.
+	coins     coinView
+	tipHeight func() int32
+	// memory taken by the transactions, which trimToSize keeps under maxSize unless it is 0
+	usage   int
+	maxSize int
+	// minimum fee rate of the transactions accepted, in satoshis per 1000 virtual bytes, raised when transactions are evicted and decaying
+	// once a block was connected since (see minFeeRate)
+	rollingMinFeeRate            float64
+	lastRollingFeeUpdate         time.Time
+	blockSinceLastRollingFeeBump bool
+	// transactions that have been in the mempool for longer expire, unless it is 0
+	expiry time.Duration
+	// dust and minimum relay fee rates of the transactions accepted, which are not checked while they are 0
+	policy MempoolPolicy
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:    NewSafeMap[message.Hash256, *MempoolEntry](),
+		wtxIds: NewSafeMap[message.Hash256, message.Hash256](),
+		spends: make(map[message.OutPoint]message.Hash256),
+		coins: func(message.OutPoint) (utxo.Coin, bool, error) {
+			return utxo.Coin{}, false, nil
+		},
+		tipHeight: func() int32 { return 0 },
+	}
+}
+
+// setCoinView makes the mempool look up the outputs its transactions spend with coins, rejecting the transactions spending coinbases that
+// would not have matured in a block on top of the chain of height tipHeight
+func (m *Mempool) setCoinView(coins coinView, tipHeight func() int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.coins = coins
+	m.tipHeight = tipHeight
+}
+
+// Add adds tx to the mempool if it is valid, replacing the transactions it conflicts with if it meets the replacement rules (see Accept)
+func (m *Mempool) Add(tx *message.TxPayload) (*MempoolEntry, error) {
+	entry, _, err := m.Accept(tx)
+	return entry, err
+}
+
+// Accept adds tx to the mempool like Add, and also returns the transactions it replaced. A transaction spending outputs that are not known
+// is rejected with an error wrapping ErrMissingInputs, and one whose inputs are invalid or whose scripts fail with script.StandardFlags with
+// an error wrapping ErrInvalidTx (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L776-L1127). A transaction spending an
+// output that transactions of the mempool already spend is rejected with an error wrapping ErrTxConflict, unless it can replace them and
+// their descendants under the rules of BIP 125 (see checkReplacement).
+func (m *Mempool) Accept(tx *message.TxPayload) (*MempoolEntry, []*MempoolEntry, error) {
+	err := checkTransaction(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	txId, err := tx.GetTxId()
+	if err != nil {
+		return nil, nil, err
+	}
+	wtxId, err := tx.GetWtxId()
+	if err != nil {
+		return nil, nil, err
+	}
+	size, vSize, err := txSizes(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.txs.Get(txId); ok {
+		return nil, nil, ErrTxAlreadyInMempool
+	}
+	entry := &MempoolEntry{Tx: tx, TxId: txId, WtxId: wtxId, VSize: vSize, AddedAt: time.Now(), usage: size + mempoolEntryOverhead}
+	coins, fee, err := m.checkInputs(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry.Fee = &fee
+	err = m.policy.check(tx, entry.Fee, vSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if minFeeRate := m.minFeeRate(entry.AddedAt); minFeeRate > 0 {
+		// transactions whose fee is not known cannot be shown to pay enough
+		if feeRate, ok := entry.FeeRate(); !ok || feeRate < minFeeRate {
+			return nil, nil, fmt.Errorf("%w: the minimum fee rate is %d satoshis per 1000 virtual bytes", ErrMempoolMinFee, minFeeRate)
+		}
+	}
+	var replaced []*MempoolEntry
+	if conflicts := m.conflicts(tx); len(conflicts) > 0 {
+		replaced, err = m.checkReplacement(entry, conflicts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = m.checkPackageLimits(entry, replaced)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the scripts are run last, as they cost the most to check
+	err = verifyScripts(tx, coins)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range replaced {
+		m.remove(r)
+	}
+	m.insert(entry)
+	m.trimToSize(entry.AddedAt)
+	if _, ok := m.txs.Get(txId); !ok {
+		return nil, nil, ErrMempoolFull
+	}
+	return entry, replaced, nil
+}
+
+// checkInputs returns the outputs the inputs of tx spend, which are outputs of the transactions of the mempool or unspent outputs of the
+// active chain, and the fee tx pays, after checking its inputs against them for tx to be in the next block. It fails with an error wrapping
+// ErrMissingInputs if some of the outputs are not known.
+func (m *Mempool) checkInputs(tx *message.TxPayload) ([]utxo.Coin, int64, error) {
+	spendHeight := m.tipHeight() + 1
+	coins := make([]utxo.Coin, len(tx.TransactionInputs))
+	for i, txIn := range tx.TransactionInputs {
+		outpoint := txIn.PreviousOutput
+		if parent, ok := m.txs.Get(outpoint.Hash); ok {
+			if int(outpoint.Index) >= len(parent.Tx.TransactionOutputs) {
+				return nil, 0, fmt.Errorf("%w: input spends output %d of a transaction with %d outputs", ErrInvalidTx, outpoint.Index, len(parent.Tx.TransactionOutputs))
+			}
+			// outputs of the mempool are confirmed in the next block at the earliest
+			coins[i] = utxo.Coin{TxOut: parent.Tx.TransactionOutputs[outpoint.Index], Height: spendHeight}
+			continue
+		}
+		coin, ok, err := m.coins(outpoint)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: input %d spends unknown output %s:%d", ErrMissingInputs, i, outpoint.Hash, outpoint.Index)
+		}
+		coins[i] = coin
+	}
+	fee, err := utxo.CheckTxInputs(tx, coins, spendHeight)
+	if err != nil {
+		return nil, 0, err
+	}
+	return coins, fee, nil
+}
+
+// verifyScripts fails with an error wrapping ErrInvalidTx unless the scripts of the inputs of tx succeed in spending coins with
+// script.StandardFlags
+func verifyScripts(tx *message.TxPayload, coins []utxo.Coin) error {
+	sigHashes := script.NewTxSigHashes(tx, utxo.SpentOutputs(coins))
+	for i := range coins {
+		err := script.VerifyInput(tx, i, &coins[i].TxOut, script.StandardFlags, sigHashes)
+		if err != nil {
+			return fmt.Errorf("%w: input %d: %w", ErrInvalidTx, i, err)
+		}
+	}
+	return nil
+}
+
+// insert adds entry to the mempool, linking it to its parents and children in the mempool
+func (m *Mempool) insert(entry *MempoolEntry) {
+	m.txs.Set(entry.TxId, entry)
+	m.wtxIds.Set(entry.WtxId, entry.TxId)
+	for _, txIn := range entry.Tx.TransactionInputs {
+		m.spends[txIn.PreviousOutput] = entry.TxId
+	}
+	m.usage += entry.usage
+	m.link(entry)
+	m.updatePackageStats(append(m.relatives(entry), entry))
+}
+
+// remove removes entry from the mempool, leaving its descendants in it
+func (m *Mempool) remove(entry *MempoolEntry) {
+	relatives := m.relatives(entry)
+	m.txs.Delete(entry.TxId)
+	m.wtxIds.Delete(entry.WtxId)
+	m.usage -= entry.usage
+	for _, txIn := range entry.Tx.TransactionInputs {
+		if m.spends[txIn.PreviousOutput] == entry.TxId {
+			delete(m.spends, txIn.PreviousOutput)
+		}
+	}
+	m.unlink(entry)
+	m.updatePackageStats(relatives)
+}
+
+func (m *Mempool) Get(txId message.Hash256) (*MempoolEntry, bool) {
+	return m.txs.Get(txId)
+}
+
+func (m *Mempool) GetByWtxId(wtxId message.Hash256) (*MempoolEntry, bool) {
+	txId, ok := m.wtxIds.Get(wtxId)
+	if !ok {
+		return nil, false
+	}
+	return m.txs.Get(txId)
+}
+
+func (m *Mempool) Len() int {
+	return m.txs.Len()
+}
+
+func (m *Mempool) Entries() []*MempoolEntry {
+	return m.txs.Values()
+}
+
+// removeBlockTxs removes the transactions confirmed by block, and the transactions spending the same outputs as them with their descendants, as
+// they can no longer be confirmed. The transactions of block double-spending transactions of the mempool are returned.
+func (m *Mempool) removeBlockTxs(block *message.BlockPayload) ([]doubleSpend, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blockSinceLastRollingFeeBump = true
+	doubleSpends := make([]doubleSpend, 0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txId, err := tx.GetTxId()
+		if err != nil {
+			return nil, err
+		}
+		if entry, ok := m.txs.Get(txId); ok {
+			m.remove(entry)
+		}
+		d, ok := m.findDoubleSpend(txId, tx)
+		if !ok {
+			continue
+		}
+		for _, conflict := range d.conflicts {
+			// a conflict may be the descendant of another, removed with it
+			if _, ok := m.txs.Get(conflict.TxId); !ok {
+				continue
+			}
+			for _, e := range append(m.descendants(conflict), conflict) {
+				m.remove(e)
+				d.evicted = append(d.evicted, e)
+			}
+		}
+		doubleSpends = append(doubleSpends, d)
+	}
+	return doubleSpends, nil
+}
+
+// addBlockTxs returns the transactions of block, which left the active chain, to the mempool. Its coinbase transaction is left out as it fails
+// the checks.
+func (m *Mempool) addBlockTxs(block *message.BlockPayload) int {
+	added := 0
+	for i := range block.Transactions {
+		_, err := m.Add(&block.Transactions[i])
+		if err == nil {
+			added++
+		}
+	}
+	return added
+}
+
+// checkTransaction does the checks of a transaction that need nothing but the transaction itself, rejecting coinbase transactions which cannot
+// be relayed (https://github.com/bitcoin/bitcoin/blob/v27.0/src/consensus/tx_check.cpp)
+func checkTransaction(tx *message.TxPayload) error {
+	if len(tx.TransactionInputs) == 0 {
+		return fmt.Errorf("%w: no inputs", ErrInvalidTx)
+	}
+	if len(tx.TransactionOutputs) == 0 {
+		return fmt.Errorf("%w: no outputs", ErrInvalidTx)
+	}
+	total := int64(0)
+	for _, txOut := range tx.TransactionOutputs {
+		if txOut.Value < 0 || txOut.Value > constants.MaxMoney {
+			return fmt.Errorf("%w: output value out of range", ErrInvalidTx)
+		}
+		total += txOut.Value
+		if total > constants.MaxMoney {
+			return fmt.Errorf("%w: total output value out of range", ErrInvalidTx)
+		}
+	}
+	spent := make(map[message.OutPoint]struct{}, len(tx.TransactionInputs))
+	for _, txIn := range tx.TransactionInputs {
+		if txIn.PreviousOutput == (message.OutPoint{Index: 0xFFFFFFFF}) {
+			return fmt.Errorf("%w: coinbase transaction", ErrInvalidTx)
+		}
+		if _, ok := spent[txIn.PreviousOutput]; ok {
+			return fmt.Errorf("%w: duplicate input", ErrInvalidTx)
+		}
+		spent[txIn.PreviousOutput] = struct{}{}
+	}
+	return nil
+}
+
+// txSizes returns the size of tx in bytes, and in virtual bytes, where witness bytes count for a quarter
+// (https://github.com/bitcoin/bips/blob/master/bip-0141.mediawiki#transaction-size-calculations)
+func txSizes(tx *message.TxPayload) (int, int, error) {
+	withoutWitnesses := *tx
+	withoutWitnesses.TransactionWitnesses = nil
+	base, err := withoutWitnesses.Encode()
+	if err != nil {
+		return 0, 0, err
+	}
+	full, err := tx.Encode()
+	if err != nil {
+		return 0, 0, err
+	}
+	weight := len(base)*3 + len(full)
+	return len(full), (weight + 3) / 4, nil
+}
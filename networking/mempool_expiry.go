@@ -0,0 +1,78 @@
+package networking
+
+import (
+	"cmp"
+	"github.com/aang114/bitcoin-node/events"
+	"log"
+	"slices"
+	"time"
+)
+
+// SetMempoolExpiry makes transactions expire from the mempool once they have been in it for longer than expiry (constants.DefaultMempoolExpiry
+// by default). It must be called before Start.
+func (n *Node) SetMempoolExpiry(expiry time.Duration) {
+	n.mempool.setExpiry(expiry)
+}
+
+func (m *Mempool) setExpiry(expiry time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expiry = expiry
+}
+
+// expiredTx is a transaction that expired from the mempool, with the descendants evicted with it
+type expiredTx struct {
+	entry       *MempoolEntry
+	descendants []*MempoolEntry
+}
+
+// expire removes the transactions that entered the mempool more than m.expiry before now, the oldest first, together with their descendants
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/txmempool.cpp). Descendants that expired themselves are only reported as descendants
+// of their expired ancestor.
+func (m *Mempool) expire(now time.Time) []expiredTx {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.expiry == 0 {
+		return nil
+	}
+	expired := make([]*MempoolEntry, 0)
+	for _, entry := range m.txs.Values() {
+		if now.Sub(entry.AddedAt) > m.expiry {
+			expired = append(expired, entry)
+		}
+	}
+	slices.SortFunc(expired, func(a, b *MempoolEntry) int {
+		return cmp.Compare(a.AddedAt.UnixNano(), b.AddedAt.UnixNano())
+	})
+
+	removed := make([]expiredTx, 0, len(expired))
+	for _, entry := range expired {
+		// the transaction may already have been removed as the descendant of another
+		if _, ok := m.txs.Get(entry.TxId); !ok {
+			continue
+		}
+		descendants := m.descendants(entry)
+		for _, e := range append(descendants, entry) {
+			m.remove(e)
+		}
+		removed = append(removed, expiredTx{entry: entry, descendants: descendants})
+	}
+	return removed
+}
+
+// expireMempoolTxs removes the expired transactions of the mempool and their descendants, publishing an event for each expired transaction
+func (n *Node) expireMempoolTxs() {
+	for _, expired := range n.mempool.expire(time.Now()) {
+		descendants := make([]string, len(expired.descendants))
+		for i, descendant := range expired.descendants {
+			descendants[i] = descendant.TxId.String()
+		}
+		log.Printf("⌛ Transaction %s expired from the mempool after %s, evicting %d descendants", expired.entry.TxId,
+			time.Since(expired.entry.AddedAt).Round(time.Second), len(descendants))
+		n.events.Publish(events.TopicMempoolExpiry, events.MempoolExpiry{
+			TxId:        expired.entry.TxId.String(),
+			AddedAt:     expired.entry.AddedAt,
+			Descendants: descendants,
+		})
+	}
+}
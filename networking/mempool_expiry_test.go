@@ -0,0 +1,43 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_ExpiresStaleMempoolTxs(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	node.mempool = newRBFTestMempool()
+	node.SetMempoolExpiry(constants.DefaultMempoolExpiry)
+	subscription := node.Events().Subscribe(10, events.TopicMempoolExpiry)
+	defer subscription.Unsubscribe()
+
+	stale, err := node.mempool.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+	stale.AddedAt = time.Now().Add(-constants.DefaultMempoolExpiry - time.Minute)
+	// the descendants of an expired transaction are evicted with it, however recent
+	child, err := node.mempool.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: stale.TxId}))
+	require.NoError(t, err)
+	recent, err := node.mempool.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}, Index: 1}))
+	require.NoError(t, err)
+	recent.AddedAt = time.Now().Add(-constants.DefaultMempoolExpiry + time.Minute)
+
+	node.expireMempoolTxs()
+	require.Equal(t, 1, node.mempool.Len())
+	_, ok := node.mempool.Get(recent.TxId)
+	require.True(t, ok)
+	select {
+	case event := <-subscription.C:
+		require.Equal(t, events.MempoolExpiry{TxId: stale.TxId.String(), AddedAt: stale.AddedAt, Descendants: []string{child.TxId.String()}}, event.Data)
+	case <-time.After(time.Second):
+		t.Fatal("no expiry event was published")
+	}
+	require.Empty(t, subscription.C, "a single transaction expired")
+	// the outputs the expired transactions spent can be spent again
+	_, err = node.mempool.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+}
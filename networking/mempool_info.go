@@ -0,0 +1,139 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"slices"
+	"time"
+)
+
+// MempoolInfo describes the mempool of the node (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/mempool.cpp)
+type MempoolInfo struct {
+	// Number of transactions
+	Size int `json:"size"`
+	// Sum of the virtual sizes of the transactions
+	Bytes int `json:"bytes"`
+	// Memory taken by the transactions, and the most they may take before the ones paying the lowest fee rates are evicted (0 for no limit)
+	Usage      int `json:"usage"`
+	MaxMempool int `json:"maxMempool"`
+	// Sum of the fees of the transactions whose fee is known, in satoshis
+	TotalFee int64 `json:"totalFee"`
+	// Fee rates in satoshis per 1000 virtual bytes: the lowest a transaction must pay to be accepted, which rises above the minimum relay fee
+	// rate once transactions are evicted from the full mempool, and the minimum relay fee rate and incremental relay fee rate
+	MempoolMinFee       int64 `json:"mempoolMinFee"`
+	MinRelayTxFee       int64 `json:"minRelayTxFee"`
+	IncrementalRelayFee int64 `json:"incrementalRelayFee"`
+}
+
+// MempoolEntryInfo describes a transaction of the mempool (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/mempool.cpp)
+type MempoolEntryInfo struct {
+	TxId  string `json:"txid"`
+	WtxId string `json:"wtxid"`
+	VSize int    `json:"vsize"`
+	// Fee paid by the transaction in satoshis, omitted if it is not known
+	Fee *int64 `json:"fee,omitempty"`
+	// When the transaction entered the mempool
+	Time time.Time `json:"time"`
+	// Sums over the transaction and its ancestors, and over the transaction and its descendants, in the mempool
+	Ancestors   PackageStats `json:"ancestors"`
+	Descendants PackageStats `json:"descendants"`
+	// Txids of the transactions of the mempool whose outputs the transaction spends, and which spend its outputs
+	Depends []string `json:"depends"`
+	SpentBy []string `json:"spentBy"`
+	// Whether the transaction can be replaced by fee, as it or one of its ancestors signals replaceability (BIP 125)
+	BIP125Replaceable bool `json:"bip125Replaceable"`
+}
+
+// MempoolInfo returns the state of the mempool
+func (n *Node) MempoolInfo() MempoolInfo {
+	return n.mempool.info(time.Now())
+}
+
+// MempoolContents returns a snapshot of every transaction of the mempool, sorted by the time it entered the mempool
+func (n *Node) MempoolContents() []MempoolEntryInfo {
+	return n.mempool.contents()
+}
+
+// MempoolEntry returns a snapshot of the transaction of the mempool whose txid is txId, if it is in the mempool
+func (n *Node) MempoolEntry(txId message.Hash256) (MempoolEntryInfo, bool) {
+	return n.mempool.entryInfo(txId)
+}
+
+// MempoolTx returns the transaction of the mempool whose txid is txId, if it is in the mempool
+func (n *Node) MempoolTx(txId message.Hash256) (*message.TxPayload, bool) {
+	entry, ok := n.mempool.Get(txId)
+	if !ok {
+		return nil, false
+	}
+	return entry.Tx, true
+}
+
+func (m *Mempool) info(now time.Time) MempoolInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info := MempoolInfo{
+		Size:                m.txs.Len(),
+		Usage:               m.usage,
+		MaxMempool:          m.maxSize,
+		MempoolMinFee:       max(m.minFeeRate(now), m.policy.MinRelayFeeRate),
+		MinRelayTxFee:       m.policy.MinRelayFeeRate,
+		IncrementalRelayFee: constants.IncrementalRelayFeeRate,
+	}
+	for _, entry := range m.txs.Values() {
+		info.Bytes += entry.VSize
+		info.TotalFee += entry.knownFee()
+	}
+	return info
+}
+
+func (m *Mempool) contents() []MempoolEntryInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.txs.Values()
+	infos := make([]MempoolEntryInfo, len(entries))
+	for i, entry := range entries {
+		infos[i] = m.describe(entry)
+	}
+	slices.SortFunc(infos, func(a, b MempoolEntryInfo) int {
+		return a.Time.Compare(b.Time)
+	})
+	return infos
+}
+
+func (m *Mempool) entryInfo(txId message.Hash256) (MempoolEntryInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.txs.Get(txId)
+	if !ok {
+		return MempoolEntryInfo{}, false
+	}
+	return m.describe(entry), true
+}
+
+// describe returns the snapshot of entry. It must be called with m.mu held.
+func (m *Mempool) describe(entry *MempoolEntry) MempoolEntryInfo {
+	info := MempoolEntryInfo{
+		TxId:              entry.TxId.String(),
+		WtxId:             entry.WtxId.String(),
+		VSize:             entry.VSize,
+		Time:              entry.AddedAt,
+		Ancestors:         entry.ancestorStats,
+		Descendants:       entry.descendantStats,
+		Depends:           make([]string, 0, len(entry.parents)),
+		SpentBy:           make([]string, 0, len(entry.children)),
+		BIP125Replaceable: m.signalsReplaceability(entry),
+	}
+	if entry.Fee != nil {
+		fee := *entry.Fee
+		info.Fee = &fee
+	}
+	for parent := range entry.parents {
+		info.Depends = append(info.Depends, parent.TxId.String())
+	}
+	for child := range entry.children {
+		info.SpentBy = append(info.SpentBy, child.TxId.String())
+	}
+	slices.Sort(info.Depends)
+	slices.Sort(info.SpentBy)
+	return info
+}
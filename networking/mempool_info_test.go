@@ -0,0 +1,67 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_MempoolQueries(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	node.mempool = newRBFTestMempool()
+	node.SetMempoolPolicy(DefaultMempoolPolicy())
+	node.SetMaxMempoolSize(constants.DefaultMaxMempoolMiB * 1024 * 1024)
+
+	parent, err := node.mempool.Add(newSpendingTx(maxReplaceableSequence, 9000, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+	child, err := node.mempool.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: parent.TxId}))
+	require.NoError(t, err)
+	unrelated, err := node.mempool.Add(newSpendingTx(0xFFFFFFFF, 9500, message.OutPoint{Hash: message.Hash256{0x01}, Index: 1}))
+	require.NoError(t, err)
+	unrelated.AddedAt = parent.AddedAt.Add(time.Second)
+	child.AddedAt = parent.AddedAt.Add(2 * time.Second)
+
+	info := node.MempoolInfo()
+	require.Equal(t, 3, info.Size)
+	require.Equal(t, parent.VSize+child.VSize+unrelated.VSize, info.Bytes)
+	require.Positive(t, info.Usage)
+	require.Equal(t, constants.DefaultMaxMempoolMiB*1024*1024, info.MaxMempool)
+	require.Equal(t, int64(2500), info.TotalFee)
+	require.Equal(t, constants.DefaultMinRelayFeeRate, info.MempoolMinFee)
+	require.Equal(t, constants.DefaultMinRelayFeeRate, info.MinRelayTxFee)
+	require.Equal(t, constants.IncrementalRelayFeeRate, info.IncrementalRelayFee)
+
+	entry, ok := node.MempoolEntry(child.TxId)
+	require.True(t, ok)
+	require.Equal(t, child.TxId.String(), entry.TxId)
+	require.Equal(t, child.WtxId.String(), entry.WtxId)
+	require.Equal(t, int64(1000), *entry.Fee)
+	require.Equal(t, child.AddedAt, entry.Time)
+	require.Equal(t, PackageStats{Count: 2, VSize: parent.VSize + child.VSize, Fees: 2000}, entry.Ancestors)
+	require.Equal(t, PackageStats{Count: 1, VSize: child.VSize, Fees: 1000}, entry.Descendants)
+	require.Equal(t, []string{parent.TxId.String()}, entry.Depends)
+	require.Empty(t, entry.SpentBy)
+	require.True(t, entry.BIP125Replaceable, "replaceability should be inherited from the parent")
+
+	entry, ok = node.MempoolEntry(parent.TxId)
+	require.True(t, ok)
+	require.Equal(t, []string{child.TxId.String()}, entry.SpentBy)
+	entry, ok = node.MempoolEntry(unrelated.TxId)
+	require.True(t, ok)
+	require.Equal(t, int64(500), *entry.Fee)
+	require.False(t, entry.BIP125Replaceable)
+	_, ok = node.MempoolEntry(message.Hash256{0x03})
+	require.False(t, ok)
+	tx, ok := node.MempoolTx(child.TxId)
+	require.True(t, ok)
+	require.Same(t, child.Tx, tx)
+	_, ok = node.MempoolTx(message.Hash256{0x03})
+	require.False(t, ok)
+
+	contents := node.MempoolContents()
+	require.Len(t, contents, 3)
+	require.Equal(t, []string{parent.TxId.String(), unrelated.TxId.String(), child.TxId.String()},
+		[]string{contents[0].TxId, contents[1].TxId, contents[2].TxId}, "transactions should be sorted by the time they entered the mempool")
+}
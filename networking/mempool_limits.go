@@ -0,0 +1,114 @@
+package networking
+
+import (
+	"cmp"
+	"github.com/aang114/bitcoin-node/constants"
+	"log"
+	"math"
+	"slices"
+	"time"
+)
+
+// Approximate memory taken by a transaction of the mempool besides its serialization
+const mempoolEntryOverhead = 256
+
+// SetMaxMempoolSize limits the memory the transactions of the mempool may take to bytes (constants.DefaultMaxMempoolMiB by default, and no
+// limit if bytes is 0). It must be called before Start.
+func (n *Node) SetMaxMempoolSize(bytes int) {
+	n.mempool.setMaxSize(bytes)
+}
+
+func (m *Mempool) setMaxSize(bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSize = bytes
+	m.trimToSize(time.Now())
+}
+
+// Usage returns the memory taken by the transactions of the mempool
+func (m *Mempool) Usage() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+// MinFeeRate returns the fee rate, in satoshis per 1000 virtual bytes, below which transactions are not accepted (see minFeeRate)
+func (m *Mempool) MinFeeRate() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.minFeeRate(time.Now())
+}
+
+// minFeeRate returns the fee rate below which transactions are not accepted. It is 0 until transactions are evicted from the full mempool,
+// when it becomes the highest fee rate evicted plus constants.IncrementalRelayFeeRate, and halves every constants.MempoolMinFeeHalfLife once a
+// block was connected since, faster while the mempool is less than half full, until it drops to 0
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/txmempool.cpp). It must be called with m.mu held.
+func (m *Mempool) minFeeRate(now time.Time) int64 {
+	if !m.blockSinceLastRollingFeeBump || m.rollingMinFeeRate == 0 {
+		return int64(math.Round(m.rollingMinFeeRate))
+	}
+	if now.Sub(m.lastRollingFeeUpdate) > 10*time.Second {
+		halfLife := constants.MempoolMinFeeHalfLife
+		if m.usage < m.maxSize/4 {
+			halfLife /= 4
+		} else if m.usage < m.maxSize/2 {
+			halfLife /= 2
+		}
+		m.rollingMinFeeRate /= math.Pow(2, float64(now.Sub(m.lastRollingFeeUpdate))/float64(halfLife))
+		m.lastRollingFeeUpdate = now
+		if m.rollingMinFeeRate < float64(constants.IncrementalRelayFeeRate)/2 {
+			m.rollingMinFeeRate = 0
+			return 0
+		}
+	}
+	return max(int64(math.Round(m.rollingMinFeeRate)), constants.IncrementalRelayFeeRate)
+}
+
+// descendantScore returns the fee rate entry is evicted by: the highest of its own fee rate and the fee rate of the package of it and its
+// descendants, which are evicted with it. Transactions whose fee is not known count as paying none.
+func (m *Mempool) descendantScore(entry *MempoolEntry) float64 {
+	descendants := entry.descendantStats
+	return max(float64(entry.knownFee())*1000/float64(entry.VSize), float64(descendants.Fees)*1000/float64(descendants.VSize))
+}
+
+// trimToSize evicts the transactions with the lowest descendant scores, with their descendants, until the mempool takes at most m.maxSize
+// bytes, and raises the minimum fee rate above the highest score evicted. The scores are worked out once, before the first eviction. It must
+// be called with m.mu held.
+func (m *Mempool) trimToSize(now time.Time) {
+	if m.maxSize == 0 || m.usage <= m.maxSize {
+		return
+	}
+	entries := m.txs.Values()
+	scores := make(map[*MempoolEntry]float64, len(entries))
+	for _, entry := range entries {
+		scores[entry] = m.descendantScore(entry)
+	}
+	slices.SortFunc(entries, func(a, b *MempoolEntry) int {
+		return cmp.Compare(scores[a], scores[b])
+	})
+
+	evicted := 0
+	maxEvictedScore := 0.0
+	for _, entry := range entries {
+		if m.usage <= m.maxSize {
+			break
+		}
+		// the transaction may already have been evicted as the descendant of another
+		if _, ok := m.txs.Get(entry.TxId); !ok {
+			continue
+		}
+		for _, e := range append(m.descendants(entry), entry) {
+			m.remove(e)
+			evicted++
+		}
+		maxEvictedScore = max(maxEvictedScore, scores[entry])
+	}
+
+	if minFeeRate := maxEvictedScore + float64(constants.IncrementalRelayFeeRate); minFeeRate > m.rollingMinFeeRate {
+		m.rollingMinFeeRate = minFeeRate
+		m.lastRollingFeeUpdate = now
+		m.blockSinceLastRollingFeeBump = false
+	}
+	log.Printf("🧹 Evicted %d transactions from the full mempool, which now only accepts transactions paying at least %.0f satoshis per 1000 virtual bytes",
+		evicted, m.rollingMinFeeRate)
+}
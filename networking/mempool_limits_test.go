@@ -0,0 +1,89 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMempool_EvictsLowestFeeRatesWhenFull(t *testing.T) {
+	m := newRBFTestMempool()
+	confirmed := func(index uint32) message.OutPoint {
+		return message.OutPoint{Hash: message.Hash256{0x01}, Index: index}
+	}
+	low, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, confirmed(0)))
+	require.NoError(t, err)
+	// the parent pays the lowest fee rate, but its child pays for both
+	parent, err := m.Add(newSpendingTx(0xFFFFFFFF, 9500, confirmed(1)))
+	require.NoError(t, err)
+	child, err := m.Add(newSpendingTx(0xFFFFFFFF, 4500, message.OutPoint{Hash: parent.TxId}))
+	require.NoError(t, err)
+	high, err := m.Add(newSpendingTx(0xFFFFFFFF, 5000, confirmed(2)))
+	require.NoError(t, err)
+	require.Zero(t, m.MinFeeRate())
+
+	m.setMaxSize(m.Usage() - 1)
+	require.Equal(t, 3, m.Len())
+	for _, entry := range []*MempoolEntry{parent, child, high} {
+		_, ok := m.Get(entry.TxId)
+		require.True(t, ok)
+	}
+	lowFeeRate, _ := low.FeeRate()
+	minFeeRate := m.MinFeeRate()
+	require.InDelta(t, lowFeeRate+constants.IncrementalRelayFeeRate, minFeeRate, 1)
+
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 9000, confirmed(3)))
+	require.ErrorIs(t, err, ErrMempoolMinFee)
+	// a transaction paying enough evicts the transactions paying the least, which may be itself
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 8000, confirmed(3)))
+	require.ErrorIs(t, err, ErrMempoolFull)
+	entry, err := m.Add(newSpendingTx(0xFFFFFFFF, 1000, confirmed(3)))
+	require.NoError(t, err)
+	_, ok := m.Get(entry.TxId)
+	require.True(t, ok)
+	require.LessOrEqual(t, m.Usage(), m.maxSize)
+}
+
+func TestMempool_MinFeeRateDecaysAfterABlock(t *testing.T) {
+	m := newRBFTestMempool()
+	_, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 5000, message.OutPoint{Hash: message.Hash256{0x01}, Index: 1}))
+	require.NoError(t, err)
+	m.setMaxSize(m.Usage() - 1)
+	bumpedAt := m.lastRollingFeeUpdate
+	bumped := m.minFeeRate(bumpedAt)
+
+	// the minimum fee rate does not decay until a block is connected
+	require.Equal(t, bumped, m.minFeeRate(bumpedAt.Add(constants.MempoolMinFeeHalfLife)))
+	_, err = m.removeBlockTxs(&message.BlockPayload{})
+	require.NoError(t, err)
+	// the mempool is more than half full
+	require.InDelta(t, bumped/2, m.minFeeRate(bumpedAt.Add(constants.MempoolMinFeeHalfLife)), 1)
+	require.Zero(t, m.minFeeRate(bumpedAt.Add(20*constants.MempoolMinFeeHalfLife)))
+	require.Zero(t, m.minFeeRate(bumpedAt.Add(21*constants.MempoolMinFeeHalfLife)), "the minimum fee rate should stay 0")
+	require.Zero(t, m.rollingMinFeeRate)
+}
+
+func TestNode_FeeFilterFollowsMempoolMinFeeRate(t *testing.T) {
+	node, _, peers, _ := newDownloadTestNode(t, 0)
+	peers[0].version.Version = feeFilterVersion
+	peers[0].sentFeeFilter.Store(0)
+	require.False(t, node.IsInitialBlockDownload())
+
+	node.mempool.rollingMinFeeRate = 10000
+	node.mempool.lastRollingFeeUpdate = time.Now()
+	require.Equal(t, int64(10000), node.feeFilter())
+	node.sendFeeFilterTo(peers[0])
+	require.Equal(t, int64(10000), peers[0].sentFeeFilter.Load())
+
+	// small changes are not sent
+	node.mempool.rollingMinFeeRate = 9000
+	node.sendFeeFilterTo(peers[0])
+	require.Equal(t, int64(10000), peers[0].sentFeeFilter.Load())
+	node.mempool.rollingMinFeeRate = 7000
+	node.sendFeeFilterTo(peers[0])
+	require.Equal(t, int64(7000), peers[0].sentFeeFilter.Load())
+}
@@ -0,0 +1,171 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+)
+
+// PackageStats sums a transaction of the mempool with its ancestors, or with its descendants, in the mempool
+type PackageStats struct {
+	Count int `json:"count"`
+	VSize int `json:"vsize"`
+	// Fees of the transactions, counting the fees that are not known as 0
+	Fees int64 `json:"fees"`
+}
+
+func (s *PackageStats) add(entry *MempoolEntry) {
+	s.Count++
+	s.VSize += entry.VSize
+	s.Fees += entry.knownFee()
+}
+
+// PackageStats returns the sums over the transaction whose txid is txId and its ancestors, and over it and its descendants, if it is in the
+// mempool
+func (m *Mempool) PackageStats(txId message.Hash256) (PackageStats, PackageStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.txs.Get(txId)
+	if !ok {
+		return PackageStats{}, PackageStats{}, false
+	}
+	return entry.ancestorStats, entry.descendantStats, true
+}
+
+// parentsOf returns the transactions of the mempool whose outputs tx spends
+func (m *Mempool) parentsOf(tx *message.TxPayload) map[*MempoolEntry]struct{} {
+	parents := make(map[*MempoolEntry]struct{})
+	for _, txIn := range tx.TransactionInputs {
+		if parent, ok := m.txs.Get(txIn.PreviousOutput.Hash); ok {
+			parents[parent] = struct{}{}
+		}
+	}
+	return parents
+}
+
+// link links entry to the transactions of the mempool it spends the outputs of, and to those spending its outputs, which are only there if
+// entry returned to the mempool from a disconnected block
+func (m *Mempool) link(entry *MempoolEntry) {
+	entry.parents = m.parentsOf(entry.Tx)
+	entry.children = make(map[*MempoolEntry]struct{})
+	for parent := range entry.parents {
+		parent.children[entry] = struct{}{}
+	}
+	for i := range entry.Tx.TransactionOutputs {
+		txId, ok := m.spends[message.OutPoint{Hash: entry.TxId, Index: uint32(i)}]
+		if !ok {
+			continue
+		}
+		if child, ok := m.txs.Get(txId); ok {
+			entry.children[child] = struct{}{}
+			child.parents[entry] = struct{}{}
+		}
+	}
+}
+
+func (m *Mempool) unlink(entry *MempoolEntry) {
+	for parent := range entry.parents {
+		delete(parent.children, entry)
+	}
+	for child := range entry.children {
+		delete(child.parents, entry)
+	}
+	entry.parents, entry.children = nil, nil
+}
+
+// ancestorsOf returns parents and the transactions of the mempool they descend from
+func ancestorsOf(parents map[*MempoolEntry]struct{}) []*MempoolEntry {
+	return walkMempool(parents, func(e *MempoolEntry) map[*MempoolEntry]struct{} { return e.parents })
+}
+
+// ancestors returns the transactions of the mempool entry descends from
+func (m *Mempool) ancestors(entry *MempoolEntry) []*MempoolEntry {
+	return ancestorsOf(entry.parents)
+}
+
+// descendants returns the transactions of the mempool spending the outputs of entry, directly or through other transactions of the mempool
+func (m *Mempool) descendants(entry *MempoolEntry) []*MempoolEntry {
+	return walkMempool(entry.children, func(e *MempoolEntry) map[*MempoolEntry]struct{} { return e.children })
+}
+
+// relatives returns the ancestors and descendants of entry
+func (m *Mempool) relatives(entry *MempoolEntry) []*MempoolEntry {
+	return append(m.ancestors(entry), m.descendants(entry)...)
+}
+
+// walkMempool returns the transactions reached from start by following next, each once
+func walkMempool(start map[*MempoolEntry]struct{}, next func(*MempoolEntry) map[*MempoolEntry]struct{}) []*MempoolEntry {
+	reached := make([]*MempoolEntry, 0, len(start))
+	seen := make(map[*MempoolEntry]struct{}, len(start))
+	for e := range start {
+		seen[e] = struct{}{}
+		reached = append(reached, e)
+	}
+	for i := 0; i < len(reached); i++ {
+		for e := range next(reached[i]) {
+			if _, ok := seen[e]; ok {
+				continue
+			}
+			seen[e] = struct{}{}
+			reached = append(reached, e)
+		}
+	}
+	return reached
+}
+
+// updatePackageStats works out the package stats of entries again, after transactions related to them entered or left the mempool
+func (m *Mempool) updatePackageStats(entries []*MempoolEntry) {
+	for _, entry := range entries {
+		entry.ancestorStats, entry.descendantStats = PackageStats{}, PackageStats{}
+		entry.ancestorStats.add(entry)
+		for _, ancestor := range m.ancestors(entry) {
+			entry.ancestorStats.add(ancestor)
+		}
+		entry.descendantStats.add(entry)
+		for _, descendant := range m.descendants(entry) {
+			entry.descendantStats.add(descendant)
+		}
+	}
+}
+
+// checkPackageLimits checks that entry, once the transactions in replaced left the mempool, would have at most constants.MaxAncestorCount
+// ancestors and constants.MaxAncestorVSize virtual bytes with them, and that none of its ancestors would then have more than
+// constants.MaxDescendantCount descendants or constants.MaxDescendantVSize virtual bytes with them
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/doc/policy/packages.md). An error wrapping ErrTooLongMempoolChain is returned otherwise.
+func (m *Mempool) checkPackageLimits(entry *MempoolEntry, replaced []*MempoolEntry) error {
+	// replaced transactions are never ancestors of their replacement, which may not spend their outputs
+	ancestors := ancestorsOf(m.parentsOf(entry.Tx))
+	stats := PackageStats{}
+	stats.add(entry)
+	for _, ancestor := range ancestors {
+		stats.add(ancestor)
+	}
+	if stats.Count > constants.MaxAncestorCount {
+		return fmt.Errorf("%w: %d ancestors, more than %d", ErrTooLongMempoolChain, stats.Count-1, constants.MaxAncestorCount-1)
+	}
+	if stats.VSize > constants.MaxAncestorVSize {
+		return fmt.Errorf("%w: %d virtual bytes with its ancestors, more than %d", ErrTooLongMempoolChain, stats.VSize, constants.MaxAncestorVSize)
+	}
+
+	leaving := make(map[*MempoolEntry][]*MempoolEntry)
+	for _, r := range replaced {
+		for _, ancestor := range m.ancestors(r) {
+			leaving[ancestor] = append(leaving[ancestor], r)
+		}
+	}
+	for _, ancestor := range ancestors {
+		descendants := ancestor.descendantStats
+		for _, r := range leaving[ancestor] {
+			descendants.Count--
+			descendants.VSize -= r.VSize
+		}
+		if descendants.Count+1 > constants.MaxDescendantCount {
+			return fmt.Errorf("%w: transaction %s would have more than %d descendants", ErrTooLongMempoolChain, ancestor.TxId, constants.MaxDescendantCount-1)
+		}
+		if descendants.VSize+entry.VSize > constants.MaxDescendantVSize {
+			return fmt.Errorf("%w: transaction %s would have more than %d virtual bytes with its descendants", ErrTooLongMempoolChain, ancestor.TxId,
+				constants.MaxDescendantVSize)
+		}
+	}
+	return nil
+}
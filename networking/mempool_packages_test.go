@@ -0,0 +1,85 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMempool_PackageStats(t *testing.T) {
+	m := newRBFTestMempool()
+	parent, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+	child, err := m.Add(newSpendingTx(0xFFFFFFFF, 8500, message.OutPoint{Hash: parent.TxId}))
+	require.NoError(t, err)
+	grandchild, err := m.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: child.TxId}))
+	require.NoError(t, err)
+	vsize := parent.VSize
+
+	ancestors, descendants, ok := m.PackageStats(parent.TxId)
+	require.True(t, ok)
+	require.Equal(t, PackageStats{Count: 1, VSize: vsize, Fees: 1000}, ancestors)
+	require.Equal(t, PackageStats{Count: 3, VSize: 3 * vsize, Fees: 2000}, descendants)
+	ancestors, descendants, _ = m.PackageStats(child.TxId)
+	require.Equal(t, PackageStats{Count: 2, VSize: 2 * vsize, Fees: 1500}, ancestors)
+	require.Equal(t, PackageStats{Count: 2, VSize: 2 * vsize, Fees: 1000}, descendants)
+	ancestors, descendants, _ = m.PackageStats(grandchild.TxId)
+	require.Equal(t, PackageStats{Count: 3, VSize: 3 * vsize, Fees: 2000}, ancestors)
+	require.Equal(t, PackageStats{Count: 1, VSize: vsize, Fees: 500}, descendants)
+
+	// the parent is confirmed
+	block := &message.BlockPayload{Transactions: []message.TxPayload{*parent.Tx}}
+	_, err = m.removeBlockTxs(block)
+	require.NoError(t, err)
+	_, _, ok = m.PackageStats(parent.TxId)
+	require.False(t, ok)
+	ancestors, descendants, _ = m.PackageStats(child.TxId)
+	require.Equal(t, PackageStats{Count: 1, VSize: vsize, Fees: 500}, ancestors)
+	require.Equal(t, PackageStats{Count: 2, VSize: 2 * vsize, Fees: 1000}, descendants)
+	ancestors, _, _ = m.PackageStats(grandchild.TxId)
+	require.Equal(t, PackageStats{Count: 2, VSize: 2 * vsize, Fees: 1000}, ancestors)
+
+	// the parent returns to the mempool after its block was disconnected, and is linked to its child already there
+	require.Equal(t, 1, m.addBlockTxs(block))
+	_, descendants, _ = m.PackageStats(parent.TxId)
+	require.Equal(t, PackageStats{Count: 3, VSize: 3 * vsize, Fees: 2000}, descendants)
+	ancestors, _, _ = m.PackageStats(grandchild.TxId)
+	require.Equal(t, PackageStats{Count: 3, VSize: 3 * vsize, Fees: 2000}, ancestors)
+}
+
+func TestMempool_RejectsTooLongChains(t *testing.T) {
+	m := newRBFTestMempool()
+	first, err := m.Add(newSpendingTx(0xFFFFFFFF, 9900, message.OutPoint{Hash: message.Hash256{0x01}}))
+	require.NoError(t, err)
+	last := first
+	for range constants.MaxAncestorCount - 1 {
+		last, err = m.Add(newSpendingTx(0xFFFFFFFF, last.Tx.TransactionOutputs[0].Value-100, message.OutPoint{Hash: last.TxId}))
+		require.NoError(t, err)
+	}
+	ancestors, _, _ := m.PackageStats(last.TxId)
+	require.Equal(t, constants.MaxAncestorCount, ancestors.Count)
+
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 1000, message.OutPoint{Hash: last.TxId}))
+	require.ErrorIs(t, err, ErrTooLongMempoolChain)
+	require.Equal(t, constants.MaxAncestorCount, m.Len())
+}
+
+func TestMempool_RejectsTooManyDescendants(t *testing.T) {
+	m := newRBFTestMempool()
+	tx := newSpendingTx(0xFFFFFFFF, 300, message.OutPoint{Hash: message.Hash256{0x01}})
+	for range constants.MaxDescendantCount {
+		tx.TransactionOutputs = append(tx.TransactionOutputs, tx.TransactionOutputs[0])
+	}
+	parent, err := m.Add(tx)
+	require.NoError(t, err)
+	for i := range constants.MaxDescendantCount - 1 {
+		_, err = m.Add(newSpendingTx(0xFFFFFFFF, 200, message.OutPoint{Hash: parent.TxId, Index: uint32(i)}))
+		require.NoError(t, err)
+	}
+
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 200, message.OutPoint{Hash: parent.TxId, Index: constants.MaxDescendantCount}))
+	require.ErrorIs(t, err, ErrTooLongMempoolChain)
+	_, descendants, _ := m.PackageStats(parent.TxId)
+	require.Equal(t, constants.MaxDescendantCount, descendants.Count)
+}
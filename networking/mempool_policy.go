@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+)
+
+var (
+	// the transaction has an output worth less than the fee of spending it at the dust relay fee rate
+	ErrDust = errors.New("dust output")
+	// the transaction pays less than the minimum relay fee rate
+	ErrMinRelayFee = errors.New("min relay fee not met")
+	// the fee of the transaction is not known, so it cannot be shown to pay the minimum relay fee rate
+	ErrUnknownFee = fmt.Errorf("%w: the fee of the transaction is not known", ErrMinRelayFee)
+)
+
+// Script opcodes the policy checks recognize outputs by
+const (
+	opReturn = 0x6a
+	op0      = 0x00
+	op1      = 0x51
+	op16     = 0x60
+)
+
+// Size in bytes of the largest script an output can be spent with (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h)
+const maxScriptSize = 10000
+
+// MempoolPolicy holds the fee rates, in satoshis per 1000 virtual bytes, the mempool accepts transactions by
+type MempoolPolicy struct {
+	// transactions must pay at least this fee rate
+	MinRelayFeeRate int64
+	// outputs worth less than the fee of spending them at this fee rate are dust, which transactions must not create
+	DustRelayFeeRate int64
+}
+
+// DefaultMempoolPolicy returns the policy of Bitcoin Core (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h)
+func DefaultMempoolPolicy() MempoolPolicy {
+	return MempoolPolicy{
+		MinRelayFeeRate:  constants.DefaultMinRelayFeeRate,
+		DustRelayFeeRate: constants.DefaultDustRelayFeeRate,
+	}
+}
+
+// DustThreshold returns the value below which txOut is dust: the fee, at the dust relay fee rate, of the output and of an input spending it,
+// which is 67 bytes for witness programs and 148 bytes for other scripts. Outputs that cannot be spent are never dust
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.cpp).
+func (p MempoolPolicy) DustThreshold(txOut message.TxOut) int64 {
+	if isUnspendable(txOut.PkScript) {
+		return 0
+	}
+	size := 8 + varIntSize(len(txOut.PkScript)) + len(txOut.PkScript)
+	if isWitnessProgram(txOut.PkScript) {
+		// 36 bytes of outpoint, 1 byte of script length, 4 bytes of sequence number, and the signature and public key discounted as witness data
+		size += 32 + 4 + 1 + (107 / 4) + 4
+	} else {
+		// 36 bytes of outpoint, 1 byte of script length, 107 bytes of signature and public key, and 4 bytes of sequence number
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return p.DustRelayFeeRate * int64(size) / 1000
+}
+
+// IsDust reports whether txOut is worth less than its dust threshold
+func (p MempoolPolicy) IsDust(txOut message.TxOut) bool {
+	return txOut.Value < p.DustThreshold(txOut)
+}
+
+// check rejects tx, which pays fee (nil if it is not known) for vSize virtual bytes, if it creates dust or pays less than the minimum relay
+// fee rate. Transactions whose fee is not known cannot be shown to pay enough, and are rejected with ErrUnknownFee whatever the rate.
+func (p MempoolPolicy) check(tx *message.TxPayload, fee *int64, vSize int) error {
+	for i, txOut := range tx.TransactionOutputs {
+		if p.IsDust(txOut) {
+			return fmt.Errorf("%w: output %d is worth %d satoshis, less than %d", ErrDust, i, txOut.Value, p.DustThreshold(txOut))
+		}
+	}
+	if fee == nil {
+		return ErrUnknownFee
+	}
+	if *fee < p.MinRelayFeeRate*int64(vSize)/1000 {
+		return fmt.Errorf("%w: the minimum relay fee rate is %d satoshis per 1000 virtual bytes", ErrMinRelayFee, p.MinRelayFeeRate)
+	}
+	return nil
+}
+
+// isUnspendable reports whether no input can spend an output locked by pkScript
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h)
+func isUnspendable(pkScript []byte) bool {
+	return (len(pkScript) > 0 && pkScript[0] == opReturn) || len(pkScript) > maxScriptSize
+}
+
+// isWitnessProgram reports whether pkScript is a version byte followed by a push of 2 to 40 bytes (BIP 141)
+func isWitnessProgram(pkScript []byte) bool {
+	if len(pkScript) < 4 || len(pkScript) > 42 {
+		return false
+	}
+	if pkScript[0] != op0 && (pkScript[0] < op1 || pkScript[0] > op16) {
+		return false
+	}
+	return int(pkScript[1])+2 == len(pkScript)
+}
+
+// varIntSize returns the size in bytes of n encoded as a message.VarInt
+func varIntSize(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	default:
+		return 9
+	}
+}
+
+// SetMempoolPolicy makes the mempool accept transactions by policy (DefaultMempoolPolicy by default). It must be called before Start.
+func (n *Node) SetMempoolPolicy(policy MempoolPolicy) {
+	n.mempool.setPolicy(policy)
+}
+
+func (n *Node) MempoolPolicy() MempoolPolicy {
+	return n.mempool.Policy()
+}
+
+func (m *Mempool) setPolicy(policy MempoolPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+func (m *Mempool) Policy() MempoolPolicy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.policy
+}
@@ -0,0 +1,77 @@
+package networking
+
+import (
+	"bytes"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMempoolPolicy_DustThreshold(t *testing.T) {
+	policy := DefaultMempoolPolicy()
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x01}, 20)...), 0x88, 0xac)
+	p2wpkh := append([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x01}, 20)...)
+	p2tr := append([]byte{0x51, 0x20}, bytes.Repeat([]byte{0x01}, 32)...)
+
+	// the thresholds of Bitcoin Core at its default dust relay fee rate
+	require.Equal(t, int64(546), policy.DustThreshold(message.TxOut{PkScript: p2pkh}))
+	require.Equal(t, int64(294), policy.DustThreshold(message.TxOut{PkScript: p2wpkh}))
+	require.Equal(t, int64(330), policy.DustThreshold(message.TxOut{PkScript: p2tr}))
+	require.Zero(t, policy.DustThreshold(message.TxOut{PkScript: []byte{0x6a, 0x01, 0x01}}), "unspendable outputs should never be dust")
+
+	require.True(t, policy.IsDust(message.TxOut{Value: 545, PkScript: p2pkh}))
+	require.False(t, policy.IsDust(message.TxOut{Value: 546, PkScript: p2pkh}))
+
+	// the threshold scales with the dust relay fee rate
+	policy.DustRelayFeeRate = 1000
+	require.Equal(t, int64(182), policy.DustThreshold(message.TxOut{PkScript: p2pkh}))
+}
+
+func TestMempool_AcceptAppliesPolicy(t *testing.T) {
+	confirmed := message.OutPoint{Hash: message.Hash256{0x01}}
+
+	t.Run("transactions creating dust should be rejected without being invalid", func(t *testing.T) {
+		m := newRBFTestMempool()
+		m.setPolicy(DefaultMempoolPolicy())
+		_, err := m.Add(newSpendingTx(0xFFFFFFFF, 100, confirmed))
+		require.ErrorIs(t, err, ErrDust)
+		require.NotErrorIs(t, err, ErrInvalidTx)
+		require.Zero(t, m.Len())
+
+		_, err = m.Add(newSpendingTx(0xFFFFFFFF, 9000, confirmed))
+		require.NoError(t, err)
+	})
+
+	t.Run("transactions paying less than the minimum relay fee rate should be rejected", func(t *testing.T) {
+		m := newRBFTestMempool()
+		m.setPolicy(DefaultMempoolPolicy())
+		_, err := m.Add(newSpendingTx(0xFFFFFFFF, 9990, confirmed))
+		require.ErrorIs(t, err, ErrMinRelayFee)
+		require.NotErrorIs(t, err, ErrInvalidTx)
+
+		m.setPolicy(MempoolPolicy{MinRelayFeeRate: 100, DustRelayFeeRate: constants.DefaultDustRelayFeeRate})
+		_, err = m.Add(newSpendingTx(0xFFFFFFFF, 9990, confirmed))
+		require.NoError(t, err)
+	})
+
+	t.Run("transactions whose fee is not known should be rejected as missing inputs", func(t *testing.T) {
+		m := newRBFTestMempool()
+		m.setPolicy(DefaultMempoolPolicy())
+		_, err := m.Add(newSpendingTx(0xFFFFFFFF, 9999, message.OutPoint{Hash: message.Hash256{0x02}}))
+		require.ErrorIs(t, err, ErrMissingInputs)
+		require.Zero(t, m.Len())
+	})
+
+	t.Run("the policy should reject transactions whose fee is not known", func(t *testing.T) {
+		tx := newSpendingTx(0xFFFFFFFF, 9999, confirmed)
+		for _, policy := range []MempoolPolicy{DefaultMempoolPolicy(), {}} {
+			err := policy.check(tx, nil, 100)
+			require.ErrorIs(t, err, ErrUnknownFee)
+			require.ErrorIs(t, err, ErrMinRelayFee)
+			require.NotErrorIs(t, err, ErrInvalidTx)
+		}
+		fee := int64(0)
+		require.NoError(t, MempoolPolicy{}.check(tx, &fee, 100))
+	})
+}
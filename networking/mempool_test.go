@@ -0,0 +1,178 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// newTestTx returns a transaction spending the first output of prevTxId into a single output
+func newTestTx(prevTxId message.Hash256, value int64, pkScript []byte) *message.TxPayload {
+	return &message.TxPayload{
+		Version:              1,
+		TransactionInputs:    []message.TxIn{{PreviousOutput: message.OutPoint{Hash: prevTxId}, SignatureScript: []byte{0x51}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs:   []message.TxOut{{Value: value, PkScript: pkScript}},
+		TransactionWitnesses: []message.TxWitness{},
+	}
+}
+
+// testWitnessScript is the script of the P2WSH outputs newTestWitnessTx spends, OP_TRUE
+var testWitnessScript = []byte{0x51}
+
+// newTestMempool returns a mempool in which the first two outputs of the confirmed transactions with txids {0x01} to {0xFF} are unspent, each
+// worth 10000 satoshis, so that the transactions newTestTx and newTestWitnessTx return for them spend known outputs. The first pays to OP_TRUE
+// and the second to the P2WSH of testWitnessScript.
+func newTestMempool() *Mempool {
+	m := NewMempool()
+	useTestCoins(m)
+	return m
+}
+
+// useTestCoins makes m spend the confirmed outputs of newTestMempool
+func useTestCoins(m *Mempool) {
+	witnessScriptHash := sha256.Sum256(testWitnessScript)
+	m.setCoinView(func(outpoint message.OutPoint) (utxo.Coin, bool, error) {
+		if outpoint.Hash != (message.Hash256{outpoint.Hash[0]}) || outpoint.Index > 1 {
+			return utxo.Coin{}, false, nil
+		}
+		pkScript := []byte{0x51}
+		if outpoint.Index == 1 {
+			pkScript = append([]byte{0x00, 0x20}, witnessScriptHash[:]...)
+		}
+		return utxo.Coin{TxOut: message.TxOut{Value: 10000, PkScript: pkScript}, Height: 1}, true, nil
+	}, func() int32 { return 1 })
+}
+
+func TestMempool_Add(t *testing.T) {
+	m := newTestMempool()
+	tx := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+
+	entry, err := m.Add(tx)
+	require.NoError(t, err)
+	txId, err := tx.GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, txId, entry.TxId)
+	// without witnesses the wtxid is the txid and the virtual size is the size
+	require.Equal(t, txId, entry.WtxId)
+	encoded, err := tx.Encode()
+	require.NoError(t, err)
+	require.Equal(t, len(encoded), entry.VSize)
+	require.EqualValues(t, 9000, *entry.Fee)
+
+	_, err = m.Add(tx)
+	require.ErrorIs(t, err, ErrTxAlreadyInMempool)
+	require.Equal(t, 1, m.Len())
+}
+
+func TestMempool_AddRejectsInvalidTxs(t *testing.T) {
+	coinbase := newTestTx(message.Hash256{}, 1000, []byte{0x51})
+	coinbase.TransactionInputs[0].PreviousOutput.Index = 0xFFFFFFFF
+	tooMuch := newTestTx(message.Hash256{0x01}, constants.MaxMoney+1, []byte{0x51})
+	duplicateInputs := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	duplicateInputs.TransactionInputs = append(duplicateInputs.TransactionInputs, duplicateInputs.TransactionInputs[0])
+	noOutputs := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	noOutputs.TransactionOutputs = nil
+	spendsMore := newTestTx(message.Hash256{0x01}, 10001, []byte{0x51})
+	failingScript := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	failingScript.TransactionInputs[0].SignatureScript = []byte{0x6a}
+
+	m := newTestMempool()
+	for _, tx := range []*message.TxPayload{coinbase, tooMuch, duplicateInputs, noOutputs, spendsMore, failingScript} {
+		_, err := m.Add(tx)
+		require.ErrorIs(t, err, ErrInvalidTx)
+	}
+	_, err := m.Add(failingScript)
+	require.ErrorIs(t, err, script.ErrScriptFailed)
+	require.Zero(t, m.Len())
+}
+
+func TestMempool_AddRejectsMissingInputs(t *testing.T) {
+	m := newTestMempool()
+	parent := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	parentTxId, err := parent.GetTxId()
+	require.NoError(t, err)
+	child := newTestTx(parentTxId, 900, []byte{0x51})
+	missing := newTestTx(message.Hash256{0x02}, 1000, []byte{0x51})
+	missing.TransactionInputs[0].PreviousOutput.Index = 2
+
+	for _, tx := range []*message.TxPayload{child, missing} {
+		_, err = m.Add(tx)
+		require.ErrorIs(t, err, ErrMissingInputs)
+		require.NotErrorIs(t, err, ErrInvalidTx, "transactions may be waiting for the outputs they spend")
+	}
+	require.Zero(t, m.Len())
+
+	// the outputs of the transactions of the mempool can be spent
+	_, err = m.Add(parent)
+	require.NoError(t, err)
+	entry, err := m.Add(child)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, *entry.Fee)
+}
+
+func TestMempool_RejectsPrematureCoinbaseSpends(t *testing.T) {
+	// the coinbase {0x01} was mined at height 1
+	m := NewMempool()
+	tipHeight := int32(constants.CoinbaseMaturity - 1)
+	m.setCoinView(func(outpoint message.OutPoint) (utxo.Coin, bool, error) {
+		return utxo.Coin{TxOut: message.TxOut{Value: 1000, PkScript: []byte{0x51}}, Height: 1, Coinbase: outpoint.Hash == message.Hash256{0x01}}, true, nil
+	}, func() int32 { return tipHeight })
+
+	_, err := m.Add(newTestTx(message.Hash256{0x01}, 900, []byte{0x51}))
+	require.ErrorIs(t, err, ErrInvalidTx)
+	_, err = m.Add(newTestTx(message.Hash256{0x02}, 900, []byte{0x51}))
+	require.NoError(t, err, "outputs of other transactions should be spendable at once")
+
+	// the next block, at height 101, may spend it
+	tipHeight++
+	_, err = m.Add(newTestTx(message.Hash256{0x01}, 900, []byte{0x51}))
+	require.NoError(t, err)
+}
+
+func TestMempool_RemoveBlockTxs(t *testing.T) {
+	m := newTestMempool()
+	confirmed := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	unconfirmed := newTestTx(message.Hash256{0x02}, 1000, []byte{0x51})
+	confirmedEntry, err := m.Add(confirmed)
+	require.NoError(t, err)
+	_, err = m.Add(unconfirmed)
+	require.NoError(t, err)
+
+	doubleSpends, err := m.removeBlockTxs(&message.BlockPayload{Transactions: []message.TxPayload{*confirmed}})
+	require.NoError(t, err)
+	require.Empty(t, doubleSpends, "confirming a transaction of the mempool is no double spend")
+	require.Equal(t, 1, m.Len())
+	unconfirmedTxId, err := unconfirmed.GetTxId()
+	require.NoError(t, err)
+	_, ok := m.Get(unconfirmedTxId)
+	require.True(t, ok)
+	_, ok = m.GetByWtxId(confirmedEntry.WtxId)
+	require.False(t, ok)
+}
+
+// newTestWitnessTx returns a transaction whose wtxid differs from its txid, spending the second output of prevTxId, the P2WSH of
+// testWitnessScript
+func newTestWitnessTx(prevTxId message.Hash256) *message.TxPayload {
+	tx := newTestTx(prevTxId, 1000, []byte{0x00, 0x14})
+	tx.TransactionInputs[0].PreviousOutput.Index = 1
+	tx.TransactionInputs[0].SignatureScript = []byte{}
+	tx.TransactionWitnesses = []message.TxWitness{{ComponentDataList: []message.ComponentData{testWitnessScript}}}
+	return tx
+}
+
+func TestMempool_GetByWtxId(t *testing.T) {
+	m := newTestMempool()
+	entry, err := m.Add(newTestWitnessTx(message.Hash256{0x01}))
+	require.NoError(t, err)
+	require.NotEqual(t, entry.TxId, entry.WtxId)
+
+	found, ok := m.GetByWtxId(entry.WtxId)
+	require.True(t, ok)
+	require.Same(t, entry, found)
+	_, ok = m.GetByWtxId(entry.TxId)
+	require.False(t, ok)
+}
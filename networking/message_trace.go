@@ -0,0 +1,74 @@
+package networking
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/message"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+type MessageDirection string
+
+const (
+	MessageSent     MessageDirection = "sent"
+	MessageReceived MessageDirection = "received"
+)
+
+// TracedMessage is a message sent to or received from a peer
+type TracedMessage struct {
+	Time      time.Time        `json:"time"`
+	Peer      string           `json:"peer"`
+	Direction MessageDirection `json:"direction"`
+	Command   string           `json:"command"`
+	// size of the message on the wire, header included
+	Size int `json:"size"`
+	// hex of the payload, if payloads are traced
+	Payload string `json:"payload,omitempty"`
+}
+
+// MessageTracer is called with every message exchanged with a peer after the handshake. It is called from the peers' goroutines, so it must
+// be safe for concurrent use and should not block.
+type MessageTracer func(TracedMessage)
+
+// NewMessageTraceWriter returns a MessageTracer writing each message to w as a line of JSON
+func NewMessageTraceWriter(w io.Writer) MessageTracer {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(m TracedMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		err := encoder.Encode(m)
+		if err != nil {
+			log.Printf("⚠️ Could not write message trace due to error: %s", err)
+		}
+	}
+}
+
+// SetMessageTracer makes the node pass every message exchanged with its peers to tracer, including the payloads' hex if tracePayloads is set
+// (it must be called before any peer is added)
+func (n *Node) SetMessageTracer(tracer MessageTracer, tracePayloads bool) {
+	n.messageTracer = tracer
+	n.tracePayloads = tracePayloads
+}
+
+// traceMessage passes a message to the peer's tracer, if it has one. encoded is the whole message, which is only needed if payloads are
+// traced.
+func (p *Peer) traceMessage(direction MessageDirection, command string, size int, encoded []byte) {
+	if p.tracer == nil {
+		return
+	}
+	traced := TracedMessage{
+		Time:      time.Now(),
+		Peer:      p.conn.RemoteAddr().String(),
+		Direction: direction,
+		Command:   command,
+		Size:      size,
+	}
+	if p.tracePayloads && len(encoded) > message.HeaderLength {
+		traced.Payload = hex.EncodeToString(encoded[message.HeaderLength:])
+	}
+	p.tracer(traced)
+}
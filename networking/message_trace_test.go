@@ -0,0 +1,21 @@
+package networking
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewMessageTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewMessageTraceWriter(&buf)
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracer(TracedMessage{Time: at, Peer: "127.0.0.1:8333", Direction: MessageSent, Command: "ping", Size: 32})
+	tracer(TracedMessage{Time: at, Peer: "127.0.0.1:8333", Direction: MessageReceived, Command: "pong", Size: 32, Payload: "6400000000000000"})
+
+	require.Equal(t, `{"time":"2024-01-01T00:00:00Z","peer":"127.0.0.1:8333","direction":"sent","command":"ping","size":32}
+{"time":"2024-01-01T00:00:00Z","peer":"127.0.0.1:8333","direction":"received","command":"pong","size":32,"payload":"6400000000000000"}
+`, buf.String())
+}
@@ -0,0 +1,55 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"net"
+	"time"
+)
+
+// NetworkInfo describes the node's presence on the peer-to-peer network (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/net.cpp#L636-L707)
+type NetworkInfo struct {
+	ProtocolVersion uint32           `json:"protocolVersion"`
+	UserAgent       string           `json:"userAgent"`
+	Services        message.Services `json:"services"`
+	// Whether the node accepts inbound connections, and whether one of its bindings receives the connections of a Tor onion service
+	Listening      bool `json:"listening"`
+	OnionListening bool `json:"onionListening"`
+	ConnectionsIn  int  `json:"connectionsIn"`
+	ConnectionsOut int  `json:"connectionsOut"`
+	// Address advertised to peers if it is known, and how many peers reported seeing the node at it
+	LocalAddr      *net.TCPAddr `json:"localAddr,omitempty"`
+	LocalAddrVotes int          `json:"localAddrVotes"`
+	// Fee rates in satoshis per 1000 virtual bytes below which transactions are not relayed, and by which replacements must raise the fee rate
+	MinRelayTxFee       int64 `json:"minRelayTxFee"`
+	IncrementalRelayFee int64 `json:"incrementalRelayFee"`
+}
+
+// NetworkInfo returns what the node announces to its peers, how many peers it is connected to and the addresses it is reachable at
+func (n *Node) NetworkInfo() NetworkInfo {
+	info := NetworkInfo{
+		ProtocolVersion:     n.protocolVersion,
+		UserAgent:           constants.UserAgent,
+		Services:            n.services,
+		MinRelayTxFee:       n.mempool.info(time.Now()).MinRelayTxFee,
+		IncrementalRelayFee: constants.IncrementalRelayFeeRate,
+	}
+	for _, peer := range n.peers.Keys() {
+		if peer.direction == Inbound {
+			info.ConnectionsIn++
+		} else {
+			info.ConnectionsOut++
+		}
+	}
+	n.mu.RLock()
+	for _, l := range n.listeners {
+		info.Listening = true
+		info.OnionListening = info.OnionListening || l.binding.Onion
+	}
+	n.mu.RUnlock()
+	if addr, ok := n.ExternalAddr(); ok {
+		info.LocalAddr = addr
+		info.LocalAddrVotes = n.externalAddrs.votesFor(addr.IP)
+	}
+	return info
+}
@@ -0,0 +1,41 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNode_NetworkInfo(t *testing.T) {
+	node := newListeningNode(t, Binding{Addr: "127.0.0.1:0"}, Binding{Addr: "127.0.0.1:0", Onion: true})
+	info := node.NetworkInfo()
+	require.Equal(t, NetworkInfo{
+		ProtocolVersion:     70015,
+		UserAgent:           constants.UserAgent,
+		Services:            message.NodeNetwork,
+		Listening:           true,
+		OnionListening:      true,
+		MinRelayTxFee:       constants.DefaultMinRelayFeeRate,
+		IncrementalRelayFee: constants.IncrementalRelayFeeRate,
+	}, info)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn, _, err := performHandshake(context.Background(), &net.Dialer{Timeout: time.Second}, node.ListenAddrs()[0], constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return node.peers.Len() == 2 }, time.Second, 10*time.Millisecond)
+
+	node.SetExternalIP(net.ParseIP("203.0.113.5"))
+	info = node.NetworkInfo()
+	require.Equal(t, 1, info.ConnectionsIn)
+	require.Equal(t, 1, info.ConnectionsOut)
+	require.Equal(t, "203.0.113.5", info.LocalAddr.IP.String())
+	require.Equal(t, node.ListenAddrs()[0].Port, info.LocalAddr.Port)
+}
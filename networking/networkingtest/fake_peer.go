@@ -0,0 +1,362 @@
+// Package networkingtest provides a scriptable fake bitcoin peer for testing the networking package without connecting to real nodes
+package networkingtest
+
+import (
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// protocol version of the fake peer, which is below 70016 so that the handshake has no wtxidrelay step
+const fakePeerProtocolVersion = 70015
+
+// Handler handles a message received by a fake peer, replacing its default behaviour for the message's command
+type Handler func(c *Conn, msg *message.Message)
+
+// FakePeer is a bitcoin peer listening on an ephemeral local port. It answers pings, getblocks, getheaders, getdata and getaddr messages from the canned
+// responses it is given, and exposes every connection made to it so that tests can send arbitrary (including malformed) messages.
+type FakePeer struct {
+	t        testing.TB
+	listener *net.TCPListener
+	conns    chan *Conn
+
+	mu sync.Mutex
+	// magic value starting the messages the fake peer sends
+	magic        uint32
+	getBlocksInv []message.Inventory
+	headers      []message.BlockPayload
+	blocks       map[message.Hash256]*message.BlockPayload
+	addrs        []message.Address
+	handlers     map[message.CommandName]Handler
+}
+
+// NewFakePeer starts a fake peer, which is closed when the test finishes
+func NewFakePeer(t testing.TB) *FakePeer {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not start fake peer: %s", err)
+	}
+	f := &FakePeer{
+		t:        t,
+		listener: listener,
+		conns:    make(chan *Conn, 16),
+		magic:    constants.MainnetMagicValue,
+		blocks:   make(map[message.Hash256]*message.BlockPayload),
+		handlers: make(map[message.CommandName]Handler),
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go f.acceptLoop()
+	return f
+}
+
+// Addr returns the address the fake peer listens on
+func (f *FakePeer) Addr() *net.TCPAddr {
+	return f.listener.Addr().(*net.TCPAddr)
+}
+
+// UseMagic makes the fake peer start the messages it sends with magic (constants.MainnetMagicValue by default), as the peers of the network
+// with this magic value do
+func (f *FakePeer) UseMagic(magic uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.magic = magic
+}
+
+// AnswerGetBlocks makes the fake peer answer getblocks messages with an inv message listing inventory
+func (f *FakePeer) AnswerGetBlocks(inventory ...message.Inventory) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.getBlocksInv = inventory
+}
+
+// AnswerGetHeaders makes the fake peer answer getheaders messages with a headers message listing the headers of blocks
+func (f *FakePeer) AnswerGetHeaders(blocks ...*message.BlockPayload) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.headers = make([]message.BlockPayload, len(blocks))
+	for i, block := range blocks {
+		f.headers[i] = *block
+		f.headers[i].Transactions = nil
+	}
+}
+
+// ServeBlocks makes the fake peer send blocks when they are requested with getdata messages
+func (f *FakePeer) ServeBlocks(blocks ...*message.BlockPayload) {
+	f.t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, block := range blocks {
+		hash, err := block.GetBlockHash()
+		if err != nil {
+			f.t.Fatalf("could not hash block: %s", err)
+		}
+		f.blocks[hash] = block
+	}
+}
+
+// ServeAddrs makes the fake peer answer getaddr messages with an addr message listing addrs
+func (f *FakePeer) ServeAddrs(addrs ...message.Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addrs = addrs
+}
+
+// Handle makes the fake peer call handler for every message with command, instead of answering it
+func (f *FakePeer) Handle(command message.CommandName, handler Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[command] = handler
+}
+
+// Accept waits for the next connection made to the fake peer, and returns it once the handshake is done
+func (f *FakePeer) Accept(timeout time.Duration) *Conn {
+	f.t.Helper()
+	select {
+	case c := <-f.conns:
+		return c
+	case <-time.After(timeout):
+		f.t.Fatalf("no connection was made to fake peer %s", f.Addr())
+		return nil
+	}
+}
+
+func (f *FakePeer) acceptLoop() {
+	for {
+		tcpConn, err := f.listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go func() {
+			c := &Conn{
+				peer:     f,
+				conn:     tcpConn,
+				received: make(chan *message.Message, 100),
+				closed:   make(chan struct{}),
+			}
+			err := c.handshake()
+			if err != nil {
+				log.Printf("[FakePeer] Handshake with %s failed: %s", tcpConn.RemoteAddr(), err)
+				_ = tcpConn.Close()
+				return
+			}
+			f.conns <- c
+			c.readLoop()
+		}()
+	}
+}
+
+func (f *FakePeer) handler(command message.CommandName) (Handler, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	handler, ok := f.handlers[command]
+	return handler, ok
+}
+
+// Conn is a connection made to a fake peer
+type Conn struct {
+	peer    *FakePeer
+	conn    *net.TCPConn
+	writeMu sync.Mutex
+	// version message sent by the node in the handshake
+	Version *message.VersionPayload
+	// every message received after the handshake, dropping those that do not fit
+	received chan *message.Message
+	closed   chan struct{}
+}
+
+func (c *Conn) handshake() error {
+	msg, err := message.DecodeMessage(c.conn)
+	if err != nil {
+		return err
+	}
+	version, ok := msg.Payload.(*message.VersionPayload)
+	if !ok {
+		return errors.New("first message is not a version message")
+	}
+	c.Version = version
+
+	localAddr := c.conn.LocalAddr().(*net.TCPAddr)
+	remoteAddr := c.conn.RemoteAddr().(*net.TCPAddr)
+	msg, err = message.NewVersionMessage(
+		fakePeerProtocolVersion,
+		message.NodeNetwork,
+		time.Now().Unix(),
+		*message.NewNetworkAddress(version.Services, remoteAddr.IP, uint16(remoteAddr.Port)),
+		*message.NewNetworkAddress(message.NodeNetwork, localAddr.IP, uint16(localAddr.Port)),
+		rand.Uint64()|1,
+		"/FakePeer:0.0.1/",
+		0,
+		false)
+	if err != nil {
+		return err
+	}
+	err = c.write(msg)
+	if err != nil {
+		return err
+	}
+	msg, err = message.NewVerackMessage()
+	if err != nil {
+		return err
+	}
+	err = c.write(msg)
+	if err != nil {
+		return err
+	}
+
+	msg, err = message.DecodeMessage(c.conn)
+	if err != nil {
+		return err
+	}
+	if msg.Header.Command != message.VerackCommand {
+		return errors.New("second message is not a verack message")
+	}
+	return nil
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.closed)
+	defer c.conn.Close()
+	for {
+		msg, err := message.DecodeMessage(c.conn)
+		unknownCommandErr := &message.ErrUnknownCommandName{}
+		if errors.As(err, &unknownCommandErr) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case c.received <- msg:
+		default:
+		}
+		err = c.answer(msg)
+		if err != nil {
+			log.Printf("[FakePeer] Could not answer \"%s\" message: %s", msg.Header.Command, err)
+			return
+		}
+	}
+}
+
+// answer replies to msg with the fake peer's handler or canned response for its command, if there is one
+func (c *Conn) answer(msg *message.Message) error {
+	if handler, ok := c.peer.handler(msg.Header.Command); ok {
+		handler(c, msg)
+		return nil
+	}
+
+	c.peer.mu.Lock()
+	getBlocksInv, headers, addrs := c.peer.getBlocksInv, c.peer.headers, c.peer.addrs
+	var blocks []*message.BlockPayload
+	if getData, ok := msg.Payload.(*message.GetDataPayload); ok {
+		for _, inventory := range getData.InventoryList {
+			if block, ok := c.peer.blocks[inventory.Hash]; ok {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	c.peer.mu.Unlock()
+
+	var replies []*message.Message
+	var err error
+	switch payload := msg.Payload.(type) {
+	case *message.PingPayload:
+		var pong *message.Message
+		pong, err = message.NewPongMessage(payload.Nonce)
+		replies = append(replies, pong)
+	case *message.GetBlocksPayload:
+		if len(getBlocksInv) > 0 {
+			var inv *message.Message
+			inv, err = message.NewInvMessage(getBlocksInv)
+			replies = append(replies, inv)
+		}
+	case *message.GetHeadersPayload:
+		var headersMsg *message.Message
+		headersMsg, err = message.NewHeadersMessage(headers)
+		replies = append(replies, headersMsg)
+	case *message.GetDataPayload:
+		for _, b := range blocks {
+			var block *message.Message
+			block, err = message.NewBlockMessage(b.Version, b.PrevBlock, b.MerkleRoot, b.Timestamp, b.Bits, b.Nonce, b.Transactions)
+			if err != nil {
+				break
+			}
+			replies = append(replies, block)
+		}
+	case *message.GetAddrPayload:
+		var addr *message.Message
+		addr, err = message.NewAddrMessage(addrs)
+		replies = append(replies, addr)
+	}
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		err = c.write(reply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) write(msg *message.Message) error {
+	c.peer.mu.Lock()
+	msg.Header.Magic = c.peer.magic
+	c.peer.mu.Unlock()
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	return c.WriteRaw(encoded)
+}
+
+// Send sends msg to the node
+func (c *Conn) Send(msg *message.Message) {
+	c.peer.t.Helper()
+	err := c.write(msg)
+	if err != nil {
+		c.peer.t.Fatalf("could not send \"%s\" message: %s", msg.Header.Command, err)
+	}
+}
+
+// WriteRaw writes encoded to the connection as is, which allows sending malformed messages
+func (c *Conn) WriteRaw(encoded []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(encoded)
+	return err
+}
+
+// Expect waits for the node to send a message with command, skipping any other message
+func (c *Conn) Expect(command message.CommandName, timeout time.Duration) *message.Message {
+	c.peer.t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-c.received:
+			if msg.Header.Command == command {
+				return msg
+			}
+		case <-deadline:
+			c.peer.t.Fatalf("no \"%s\" message was received from the node", command)
+			return nil
+		}
+	}
+}
+
+// Closed is closed once the connection is closed by either side
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close closes the connection
+func (c *Conn) Close() {
+	_ = c.conn.Close()
+}
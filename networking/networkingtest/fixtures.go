@@ -0,0 +1,39 @@
+package networkingtest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/message"
+	"testing"
+)
+
+// Hexdump of the genesis block (https://en.bitcoin.it/wiki/Genesis_block)
+const genesisBlockHex = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c0101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+// GenesisBlock returns the mainnet genesis block, which is the only block with a valid proof of work that the fixtures provide
+func GenesisBlock(t testing.TB) *message.BlockPayload {
+	t.Helper()
+	encoded, err := hex.DecodeString(genesisBlockHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := message.DecodeBlockPayload(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// BlockInventory returns the inventory announcing blocks
+func BlockInventory(t testing.TB, blocks ...*message.BlockPayload) []message.Inventory {
+	t.Helper()
+	inventory := make([]message.Inventory, len(blocks))
+	for i, block := range blocks {
+		hash, err := block.GetBlockHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inventory[i] = message.Inventory{Type: message.MsgBlock, Hash: hash}
+	}
+	return inventory
+}
@@ -1,330 +1,939 @@
+// Package networking implements the node: Node connects to peers, downloads and validates the chain, keeps the mempool and relays
+// transactions. Programs embed the node by creating it with NewNode, configuring it with its Set methods (stores, indexes, policies, event
+// bus) and running it with Node.Start; the cmd/bitcoin-node command is such a program.
 package networking
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
 	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/events"
 	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/tracing"
+	"github.com/aang114/bitcoin-node/utxo"
+	"hash/crc32"
+	"io"
 	"log"
+	"math/big"
 	"net"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var ErrNodeHasNoPeersOrUnconnectedAddrs = errors.New("node has no peers or unconnected addresses")
+var (
+	ErrNodeHasNoPeersOrUnconnectedAddrs = errors.New("node has no peers or unconnected addresses")
+	ErrPeerAlreadyConnected             = errors.New("peer is already connected")
+	ErrDuplicateVersionNonce            = errors.New("peer sent a version nonce of an existing connection")
+	ErrPeerBanned                       = errors.New("peer is banned")
+	ErrMissingServices                  = errors.New("peer does not offer the required services")
+)
 
-type ErrSendGetAddrMsgFailed struct {
-	Peer *Peer
+type invPayloadWithSender struct {
+	InvPayload *message.InvPayload
+	Sender     *Peer
 }
 
-func (e ErrSendGetAddrMsgFailed) Error() string {
-	return fmt.Sprintf("sendGetAddrMsg() failed for peer : %s", e.Peer.conn.RemoteAddr())
+type getBlocksPayloadWithSender struct {
+	GetBlocksPayload *message.GetBlocksPayload
+	Sender           *Peer
 }
 
-type InvPayloadWithSender struct {
-	InvPayload *message.InvPayload
-	Sender     *Peer
+// compactFilterRequestWithSender holds a getcfilters, getcfheaders or getcfcheckpt message
+type compactFilterRequestWithSender struct {
+	Request message.Payload
+	Sender  *Peer
 }
 
-type BlockPayloadWithSender struct {
+type txPayloadWithSender struct {
+	TxPayload *message.TxPayload
+	Sender    *Peer
+	// span of the peer handling the message, which the span of the node handling it is a child of
+	Trace tracing.SpanContext
+}
+
+type blockPayloadWithSender struct {
 	BlockPayload *message.BlockPayload
 	Sender       *Peer
+	ReceivedAt   time.Time
+	// span of the peer handling the message, which the span of the node handling it is a child of
+	Trace tracing.SpanContext
 }
 
 type Node struct {
-	mu                  sync.RWMutex
-	protocolVersion     uint32
-	services            message.Services
-	minimumPeers        int
-	tickerDuration      time.Duration
-	tcpDialTimeout      time.Duration
+	mu              sync.RWMutex
+	protocolVersion uint32
+	services        message.Services
+	minimumPeers    int
+	tickerDuration  time.Duration
+	tcpDialTimeout  time.Duration
+	// network the node joins, whose checkpoints the headers and blocks must agree with
+	params constants.NetworkParams
+	dialer Dialer
+	// cancelled when the node quits, which aborts dials and handshakes and quits peers
+	ctx                 context.Context
+	cancel              context.CancelFunc
 	getAddrWaitTime     time.Duration
 	blocksFileDirectory string
-	peers               *SafeMap[*Peer, struct{}]
-	connectedAddrs      *SafeMap[TCPAddress, struct{}]
-	unconnectedAddrs    *SafeMap[TCPAddress, struct{}]
-	blocks              *SafeSlice[*message.BlockPayload]
-	blockHashes         *SafeMap[message.Hash256, struct{}]
-	HasQuit             bool
-	QuitCh              chan struct{}
-	addPeersCh          chan struct{}
-	invMsgCh            chan *InvPayloadWithSender
-	blockMsgCh          chan *BlockPayloadWithSender
-}
-
-func NewNode(
-	protocolVersion uint32,
-	services message.Services,
-	minimumPeers int,
-	blocksFileDirectory string,
-	tickerDuration time.Duration,
-	tcpDialTimeout time.Duration,
-	getAddrWaitTime time.Duration,
-) *Node {
+	// file system the blocks file and the write-ahead log are stored in
+	fs               storage.FS
+	peers            *SafeMap[*Peer, struct{}]
+	connectedAddrs   *SafeMap[TCPAddress, struct{}]
+	unconnectedAddrs *SafeMap[TCPAddress, struct{}]
+	// nonces we sent in our version messages (used to detect connections to ourselves)
+	localNonces *SafeMap[uint64, struct{}]
+	// nonces received in the version messages of connected peers
+	remoteNonces *SafeMap[uint64, *Peer]
+	banManager   *BanManager
+	// unix nanoseconds of when a block was last added, or an extra sync peer was last connected to because none was for staleTipTimeout
+	tipProgress          atomic.Int64
+	staleTipTimeout      time.Duration
+	syncingFromExtraPeer atomic.Bool
+	// how long a requested block may take to arrive before it is requested from another peer
+	blockDownloadTimeout time.Duration
+	// peer the headers of the best chain are requested from, which is replaced when its block throughput collapses
+	syncPeer       atomic.Pointer[Peer]
+	syncThroughput blockThroughput
+	// set once the node caught up with the chain, after which it never considers itself in the initial block download again
+	caughtUp atomic.Bool
+	// if set, the blocks are kept in it rather than in memory and in the blocks file
+	blockStore BlockStore
+	// unspent outputs of the active chain, if it started from a UTXO snapshot
+	snapshot atomic.Pointer[snapshotChainstate]
+	// addresses of the peers the node always keeps connected to
+	manualAddrs *SafeMap[TCPAddress, struct{}]
+	// id of the next peer registered
+	nextPeerId              atomic.Int64
+	manualPeerRetryInterval time.Duration
+	// when set, the node only connects to its manual peers
+	discoveryDisabled bool
+	// services peers must offer for the node to stay connected to them, apart from manual peers
+	requiredServices message.Services
+	// rejects peers by their user agent and protocol version, apart from manual peers
+	peerFilter    PeerFilter
+	externalAddrs *externalAddrs
+	// listeners accepting inbound connections
+	listeners []listener
+	// traces of the most recent failed handshakes
+	handshakeTraces *handshakeTraces
+	netTotals       *bandwidthCounter
+	p2pMetrics      *p2pMetricsCollector
+	churn           *churnTracker
+	addrMan         *AddrMan
+	// newly learnt addresses waiting to be relayed to peers every addrRelayInterval
+	addrRelay         *addrRelayQueue
+	addrRelayInterval time.Duration
+	// download the chain, connect to new peers and discover addresses besides the select loop, which relays transactions and serves peers
+	syncManager syncManager
+	peerManager peerManager
+	addrManager addrManager
+	// if set, every message exchanged with peers is passed to messageTracer
+	messageTracer MessageTracer
+	tracePayloads bool
+	// unconfirmed transactions received from peers
+	mempool *Mempool
+	// headers and blocks we know of, the blocks to download being chosen from the best chain
+	blockIndex *blockchain.BlockIndex
+	// which peer each block that was requested but not received yet was requested from, and when
+	blocksInFlight *SafeMap[message.Hash256, blockRequest]
+	events         *events.Bus
+	// unspent outputs of the active chain
+	chainstate atomic.Pointer[utxo.Chainstate]
+	// database the unspent outputs are flushed to, if any
+	utxoDB *utxo.DB
+	// where the transactions of the active chain are, if they are indexed
+	txIndex *TxIndex
+	// basic filters of the blocks of the active chain, if they are indexed
+	filterIndex *BlockFilterIndex
+	// write-ahead log of the blocks accepted since the blocks file was last saved
+	wal *storage.WAL
+	// number of blocks logged to wal since the blocks file was last saved
+	uncheckpointedBlocks atomic.Int64
+	// held while the blocks file is saved and wal truncated
+	checkpointMu sync.Mutex
+	// work a peer's header chain must have before its headers are added to the block index, set from the network params by LoadBlocks
+	minimumChainWork *big.Int
+	// held while the tip of the active chain is moved, which InvalidateBlock and ReconsiderBlock do besides the sync manager
+	chainMu sync.Mutex
+	// number of blocks of the active chain verified when the stored blocks are loaded (all of them if negative)
+	checkBlocks int
+	// picks the peers blocks are requested from
+	peerSelector PeerSelector
+	tuning       Tuning
+	// whether Stop was called, after which the node cannot be started again
+	stopped bool
+	// closed when the node stops, which makes the select loop return quitErr and the managers return
+	quitCh   chan struct{}
+	quitOnce sync.Once
+	// error that made the node stop by itself, nil if it was stopped
+	quitErr error
+	// counts the running Start calls, so that Stop waits for the select loop and the managers to finish handling their message
+	running sync.WaitGroup
+	// getblocks messages from peers, which are answered from our blocks
+	getBlocksMsgCh chan *getBlocksPayloadWithSender
+	txMsgCh        chan *txPayloadWithSender
+	// compact filter requests from peers, which are answered from the block filter index
+	compactFilterMsgCh chan *compactFilterRequestWithSender
+}
+
+// NewNode returns a node with the settings of config, which joins mainnet until SetNetworkParams is called
+func NewNode(config Config) *Node {
+	tuning := config.Tuning
 	n := Node{
-		protocolVersion:     protocolVersion,
-		services:            services,
-		minimumPeers:        minimumPeers,
-		tickerDuration:      tickerDuration,
-		tcpDialTimeout:      tcpDialTimeout,
-		getAddrWaitTime:     getAddrWaitTime,
-		blocksFileDirectory: blocksFileDirectory,
-		peers:               NewSafeMap[*Peer, struct{}](),
-		connectedAddrs:      NewSafeMap[TCPAddress, struct{}](),
-		unconnectedAddrs:    NewSafeMap[TCPAddress, struct{}](),
-		blocks:              NewSafeSlice[*message.BlockPayload](0),
-		blockHashes:         NewSafeMap[message.Hash256, struct{}](),
-		HasQuit:             false,
-		QuitCh:              make(chan struct{}),
-		addPeersCh:          make(chan struct{}, 1),
-		// TODO - Decide on the channel buffer length
-		invMsgCh: make(chan *InvPayloadWithSender, minimumPeers),
-		// TODO - Decide on the channel buffer length
-		blockMsgCh: make(chan *BlockPayloadWithSender, minimumPeers),
+		protocolVersion:         config.ProtocolVersion,
+		services:                config.Services,
+		minimumPeers:            config.MinimumPeers,
+		tickerDuration:          config.RequestInterval,
+		tcpDialTimeout:          config.DialTimeout,
+		dialer:                  &net.Dialer{Timeout: config.DialTimeout},
+		params:                  constants.MainnetParams,
+		getAddrWaitTime:         config.GetAddrWait,
+		blocksFileDirectory:     config.BlocksFile,
+		fs:                      config.FS,
+		peers:                   NewSafeMap[*Peer, struct{}](),
+		connectedAddrs:          NewSafeMap[TCPAddress, struct{}](),
+		unconnectedAddrs:        NewSafeMap[TCPAddress, struct{}](),
+		localNonces:             NewSafeMap[uint64, struct{}](),
+		remoteNonces:            NewSafeMap[uint64, *Peer](),
+		banManager:              NewBanManager(constants.BanDuration),
+		manualAddrs:             NewSafeMap[TCPAddress, struct{}](),
+		requiredServices:        message.NodeNetwork,
+		manualPeerRetryInterval: constants.ManualPeerRetryInterval,
+		staleTipTimeout:         constants.StaleTipTimeout,
+		blockDownloadTimeout:    constants.BlockDownloadTimeout,
+		externalAddrs:           newExternalAddrs(),
+		handshakeTraces:         newHandshakeTraces(constants.HandshakeTraceBufferSize),
+		netTotals:               newBandwidthCounter(),
+		p2pMetrics:              newP2PMetricsCollector(),
+		churn:                   newChurnTracker(),
+		addrMan:                 NewAddrMan(),
+		addrRelay:               &addrRelayQueue{},
+		addrRelayInterval:       constants.AddrRelayInterval,
+		mempool:                 NewMempool(),
+		peerSelector:            WeightedPeerSelector{},
+		blocksInFlight:          NewSafeMap[message.Hash256, blockRequest](),
+		events:                  events.NewBus(),
+		quitCh:                  make(chan struct{}),
+		tuning:                  tuning,
+		getBlocksMsgCh:          make(chan *getBlocksPayloadWithSender, tuning.MessageBufferSize),
+		txMsgCh:                 make(chan *txPayloadWithSender, tuning.MessageBufferSize),
+		compactFilterMsgCh:      make(chan *compactFilterRequestWithSender, tuning.MessageBufferSize),
 	}
 
+	n.blockIndex = blockchain.NewBlockIndex(n.checkProofOfWork, constants.MaxOrphanBlocks)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.syncManager = newHeadersFirstSync(&n, tuning.MessageBufferSize)
+	n.peerManager = newPeerDialer(&n)
+	n.addrManager = newAddrGossip(&n, tuning.MessageBufferSize)
+	n.setGenesisBlock(mainnetGenesisBlock, mainnetGenesisHash)
+	n.mempool.setCoinView(n.unspentOutput, n.tipHeight)
+	n.mempool.setMaxSize(constants.DefaultMaxMempoolMiB * 1024 * 1024)
+	n.mempool.setExpiry(constants.DefaultMempoolExpiry)
+	n.mempool.setPolicy(DefaultMempoolPolicy())
+
 	return &n
 }
 
-func (n *Node) Start() {
-	err := n.readBlocksFromDisk()
+// Start loads the node's state and runs it until ctx is cancelled or the node stops. It returns the error that made the node stop by itself,
+// if any. Stop must be called once Start returns to save the node's state.
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrNodeHasQuit
+	}
+	n.running.Add(1)
+	n.mu.Unlock()
+	defer n.running.Done()
+
+	// dials, handshakes and peers are stopped as soon as ctx is cancelled, rather than when the node gets round to quitting
+	stop := context.AfterFunc(ctx, n.cancel)
+	defer stop()
+
+	err := n.LoadBlocks()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Printf("File %s does not exist. Starting afresh...", n.blocksFileDirectory)
-		} else {
-			log.Printf("⚠️ Couldn't read the blocks in file %s due to error: %s. Quitting now...", n.blocksFileDirectory, err)
-			n.Quit()
-			return
+		return err
+	}
+
+	err = n.addrMan.Load(n.fs, n.addrManPath())
+	if err != nil {
+		log.Printf("⚠️ Couldn't read the addresses in file %s due to error: %s. Starting afresh...", n.addrManPath(), err)
+	}
+	for _, known := range n.addrMan.Addresses() {
+		if known.Services.Has(n.requiredServices) {
+			n.addUnconnectedAddrToNode(known.Addr)
 		}
-	} else {
-		log.Printf("💾 Successfully read %d blocks in file %s", n.blocks.Len(), n.blocksFileDirectory)
+	}
+
+	err = n.banManager.Load(n.fs, n.banListPath())
+	if err != nil {
+		log.Printf("⚠️ Couldn't read the bans in file %s due to error: %s. Starting afresh...", n.banListPath(), err)
+	}
+
+	err = n.churn.load(n.fs, n.peerChurnPath())
+	if err != nil {
+		log.Printf("⚠️ Couldn't read the peer churn in file %s due to error: %s. Starting it afresh...", n.peerChurnPath(), err)
 	}
 
 	if n.peers.Len() < n.minimumPeers {
 		n.notifyThatPeersIsBelowMinPeers()
 	}
+	n.tipProgress.Store(time.Now().UnixNano())
 
-	n.selectLoop()
+	// the managers stop with the select loop
+	managersCtx, stopManagers := context.WithCancel(ctx)
+	var managers sync.WaitGroup
+	for _, m := range []manager{n.syncManager, n.peerManager, n.addrManager} {
+		managers.Add(1)
+		go func() {
+			defer managers.Done()
+			m.run(managersCtx, n.quitCh)
+		}()
+	}
+	defer managers.Wait()
+	defer stopManagers()
+
+	return n.selectLoop(ctx)
 }
 
-func (n *Node) AddPeer(remoteAddr *net.TCPAddr, receivingServices message.Services) (*Peer, error) {
-	conn, err := PerformHandshake(remoteAddr, n.tcpDialTimeout, n.services, receivingServices)
+// LoadBlocks rebuilds the block index from the stored blocks, checking them against the checkpoints of the network and verifying the last blocks
+// of the active chain (see SetCheckBlocks). It is called by Start, and can be called instead of it to read the stored chain without connecting
+// to peers (e.g. to export it).
+func (n *Node) LoadBlocks() error {
+	checkpoints, err := parseCheckpoints(n.params.Checkpoints)
+	if err != nil {
+		log.Printf("⚠️ Couldn't parse the checkpoints of %s due to error: %s. Quitting now...", n.params.Name, err)
+		return err
+	}
+	n.blockIndex.SetCheckpoints(checkpoints)
+	n.minimumChainWork, err = parseMinimumChainWork(n.params.MinimumChainWork)
 	if err != nil {
+		log.Printf("⚠️ Couldn't parse the minimum chain work of %s due to error: %s. Quitting now...", n.params.Name, err)
+		return err
+	}
+
+	if n.blockStore != nil {
+		n.blockIndex.SetBlockReader(n.blockStore)
+		err = n.readBlocksFromStore()
+		if err != nil {
+			log.Printf("⚠️ Couldn't read the blocks in the block store due to error: %s. Quitting now...", err)
+			return err
+		}
+	} else {
+		err = n.readBlocksFile()
+		if err != nil {
+			return err
+		}
+	}
+	err = n.checkStoredBlocks()
+	if err != nil {
+		log.Printf("⚠️ Stored blocks failed verification due to error: %s. Quitting now...", err)
+	}
+	return err
+}
+
+// AddPeer connects and performs a handshake with the peer at remoteAddr, which must offer the node's required services unless it is a manual
+// peer.
+//
+// The address is reserved in connectedAddrs before dialing, so concurrent attempts to connect to the same address fail with ErrPeerAlreadyConnected
+// rather than racing each other.
+func (n *Node) AddPeer(remoteAddr *net.TCPAddr) (*Peer, error) {
+	tcpAddress := TCPAddress{IpAddress: [16]byte(remoteAddr.IP.To16()), Port: uint16(remoteAddr.Port)}
+	if !n.isManualAddr(tcpAddress) && n.banManager.IsBanned(remoteAddr.IP) {
+		return nil, ErrPeerBanned
+	}
+	if !n.connectedAddrs.SetIfAbsent(tcpAddress, struct{}{}) {
+		return nil, ErrPeerAlreadyConnected
+	}
+	p, err := n.connectPeer(remoteAddr, n.servicesOf(tcpAddress))
+	if err != nil {
+		n.connectedAddrs.Delete(tcpAddress)
+		return nil, err
+	}
+	go p.Start(n.ctx)
+	n.advertiseExternalAddrTo(p)
+	n.sendFeeFilterTo(p)
+	return p, nil
+}
+
+func (n *Node) connectPeer(remoteAddr *net.TCPAddr, receivingServices message.Services) (*Peer, error) {
+	nonce := NewNonce()
+	n.localNonces.Set(nonce, struct{}{})
+	defer n.localNonces.Delete(nonce)
+
+	conn, h, err := performHandshake(n.ctx, n.dialer, remoteAddr, n.params.Magic, n.services, receivingServices, nonce)
+	if err != nil {
+		var handshakeErr *ErrHandshakeFailed
+		if errors.As(err, &handshakeErr) {
+			n.handshakeTraces.add(*handshakeErr.Trace)
+		}
 		return nil, err
 	}
-	onQuitting := func(peerNode *Peer) { n.removePeerFromNode(peerNode) }
-	p, err := NewPeer(conn, onQuitting, n.invMsgCh, n.blockMsgCh)
+	manual := n.isManualAddr(TCPAddress{IpAddress: [16]byte(remoteAddr.IP.To16()), Port: uint16(remoteAddr.Port)})
+	if !manual && !h.Version.Services.Has(n.requiredServices) {
+		_ = conn.Close()
+		return nil, ErrMissingServices
+	}
+	return n.registerPeer(conn, h, func(p *Peer) {
+		p.manual = manual
+		p.noBan = manual
+	})
+}
+
+// registerPeer creates a peer for a connection whose handshake completed and adds it to the node. setup is called before the peer is added.
+func (n *Node) registerPeer(conn Conn, h *handshake, setup func(p *Peer)) (*Peer, error) {
+	if _, ok := n.localNonces.Get(h.Version.Nonce); ok {
+		_ = conn.Close()
+		return nil, ErrSelfConnection
+	}
+	onQuitting := func(peer *Peer) { n.removePeerFromNode(peer) }
+	syncChannels := n.syncManager.channels()
+	p, err := newPeer(conn, onQuitting, syncChannels.inv, syncChannels.blocks)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+	p.id = n.nextPeerId.Add(1) - 1
+	p.remoteNonce = h.Version.Nonce
+	p.magic = n.params.Magic
+	p.powLimitBits = n.params.PowLimitBits
+	p.version = h.Version
+	p.wtxidRelay = h.WtxidRelay
+	p.sendAddrV2 = h.SendAddrV2
+	n.externalAddrs.observe(h.Version.ReceivingNode.IpAddress)
+	p.addrMsgCh = n.addrManager.addrMsgs()
+	p.getBlocksMsgCh = n.getBlocksMsgCh
+	p.txMsgCh = n.txMsgCh
+	p.headersMsgCh = syncChannels.headers
+	p.compactFilterMsgCh = n.compactFilterMsgCh
+	p.mempool = n.mempool
+	p.addrMan = n.addrMan
+	p.netTotals = n.netTotals
+	p.metrics = n.p2pMetrics
+	p.tracer = n.messageTracer
+	p.tracePayloads = n.tracePayloads
+	setup(p)
+	if h.TxReconciliation && p.connectionType == FullRelay {
+		// the side that opened the connection starts the reconciliation rounds
+		p.txReconciliation = newTxReconciliation(p.direction == Outbound, h.LocalTxRcnclSalt, h.RemoteTxRcnclSalt)
+	}
+	if !p.manual {
+		err = n.peerFilter.Check(h.Version)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	// a zero nonce means the peer does not use nonces
+	if p.remoteNonce != 0 && !n.remoteNonces.SetIfAbsent(p.remoteNonce, p) {
+		_ = conn.Close()
+		return nil, ErrDuplicateVersionNonce
+	}
 	n.addPeerToNode(p)
-	go p.Start()
 	return p, nil
 }
 
-func (n *Node) Quit() {
-	n.mu.Lock()
-	defer n.mu.Unlock()
+// AddManualPeer makes the node keep the peer at remoteAddr connected, reconnecting whenever the connection fails or drops, and reports
+// whether the peer was not already a manual peer. Manual peers are exempt from banning.
+func (n *Node) AddManualPeer(remoteAddr *net.TCPAddr) bool {
+	tcpAddress := TCPAddress{IpAddress: [16]byte(remoteAddr.IP.To16()), Port: uint16(remoteAddr.Port)}
+	if !n.manualAddrs.SetIfAbsent(tcpAddress, struct{}{}) {
+		return false
+	}
+	go n.keepManualPeerConnected(remoteAddr, false)
+	return true
+}
+
+// SetNetworkParams makes the node join the network of params (constants.MainnetParams by default): its chain starts from the network's
+// genesis block, its messages and blocks are those of the network, and the network's checkpoints, minimum chain work and soft forks are
+// enforced. It must be called before SetUTXODatabase, SetBlockFilterIndex and Start.
+func (n *Node) SetNetworkParams(params constants.NetworkParams) error {
+	genesis, hash, err := parseGenesisBlock(params.GenesisBlock)
+	if err != nil {
+		return fmt.Errorf("invalid genesis block of %s: %w", params.Name, err)
+	}
+	n.params = params
+	n.setGenesisBlock(genesis, hash)
+	return nil
+}
 
-	log.Printf("Quitting Node...")
+// checkProofOfWork checks that the hash of a header meets its target, which must not be easier than the easiest target of the node's network
+func (n *Node) checkProofOfWork(header *message.BlockPayload, hash message.Hash256) error {
+	return header.CheckProofOfWorkWithLimit(hash, n.params.PowLimitBits)
+}
+
+// NetworkParams returns the parameters of the network the node joins
+func (n *Node) NetworkParams() constants.NetworkParams {
+	return n.params
+}
 
-	if n.HasQuit {
+// SetRequiredServices makes the node only connect to peers offering all of services (message.NodeNetwork by default), apart from manual peers
+// (it must be called before Start)
+func (n *Node) SetRequiredServices(services message.Services) {
+	n.requiredServices = services
+}
+
+// servicesOf returns the services the peer at tcpAddress is known to offer, assuming the required ones if its address was not learnt from
+// other peers
+func (n *Node) servicesOf(tcpAddress TCPAddress) message.Services {
+	if known, ok := n.addrMan.Get(tcpAddress); ok {
+		return known.Services
+	}
+	return n.requiredServices
+}
+
+// DisableDiscovery makes the node only connect to its manual peers (it must be called before Start)
+func (n *Node) DisableDiscovery() {
+	n.discoveryDisabled = true
+}
+
+func (n *Node) isManualAddr(tcpAddress TCPAddress) bool {
+	_, ok := n.manualAddrs.Get(tcpAddress)
+	return ok
+}
+
+// keepManualPeerConnected retries connecting to the manual peer at remoteAddr until it succeeds, the node quits or the peer is no longer a
+// manual peer
+func (n *Node) keepManualPeerConnected(remoteAddr *net.TCPAddr, waitFirst bool) {
+	tcpAddress := TCPAddress{IpAddress: [16]byte(remoteAddr.IP.To16()), Port: uint16(remoteAddr.Port)}
+	for {
+		if waitFirst {
+			select {
+			case <-n.quitCh:
+				return
+			case <-time.After(n.manualPeerRetryInterval):
+			}
+		}
+		waitFirst = true
+		if !n.isManualAddr(tcpAddress) {
+			return
+		}
+
+		_, err := n.AddPeer(remoteAddr)
+		if err == nil || errors.Is(err, ErrPeerAlreadyConnected) {
+			return
+		}
+		log.Printf("❌ Could not connect to manual peer %s due to error: %s. Retrying in %s...", remoteAddr, err, n.manualPeerRetryInterval)
+	}
+}
+
+// SetExternalIP sets the IP address advertised to peers, instead of the one they report seeing us at
+func (n *Node) SetExternalIP(ip net.IP) {
+	n.externalAddrs.setConfigured(ip)
+}
+
+// ExternalAddr returns the address advertised to peers, if it is known and the node accepts inbound connections on a clearnet listener, as
+// peers could not connect to it otherwise
+func (n *Node) ExternalAddr() (*net.TCPAddr, bool) {
+	ip, ok := n.externalAddrs.get()
+	if !ok {
+		return nil, false
+	}
+	port, ok := n.advertisedPort()
+	if !ok {
+		return nil, false
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, true
+}
+
+// advertiseExternalAddr sends our external address to every peer in an unsolicited addr message, so that it propagates through the network
+func (n *Node) advertiseExternalAddr() {
+	for _, peer := range n.peers.Keys() {
+		n.advertiseExternalAddrTo(peer)
+	}
+}
+
+// advertiseExternalAddrTo sends our external address to peer, unless the node is in the initial block download, as peers connecting to it could
+// not be served the latest blocks
+func (n *Node) advertiseExternalAddrTo(peer *Peer) {
+	addr, ok := n.ExternalAddr()
+	if !ok || n.IsInitialBlockDownload() {
 		return
 	}
-	n.HasQuit = true
+	address := message.NewAddress(uint32(time.Now().Unix()), *message.NewNetworkAddress(n.services, addr.IP, uint16(addr.Port)))
+	err := peer.sendAddrMsg([]message.Address{*address})
+	if err != nil {
+		log.Printf("⚠️ Could not advertise our address to peer %s due to error: %s", peer.conn.RemoteAddr(), err)
+	}
+}
+
+// Peers returns a snapshot of every connected peer, sorted by connection time
+func (n *Node) Peers() []PeerInfo {
+	peers := n.peers.Keys()
+	infos := make([]PeerInfo, len(peers))
+	for i, peer := range peers {
+		infos[i] = peer.Info()
+	}
+	slices.SortFunc(infos, func(a, b PeerInfo) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return infos
+}
+
+// Events returns the bus on which the node publishes its notifications
+func (n *Node) Events() *events.Bus {
+	return n.events
+}
+
+// NetTotals returns the bytes sent to and received from all peers since the node was created (excluding handshakes)
+func (n *Node) NetTotals() NetTotals {
+	return n.netTotals.snapshot()
+}
 
-	// TODO - All peers might not be quit if there is is another goroutine that is adding peers while this goroutine is running
+// FailedHandshakes returns the traces of the most recent failed handshakes from oldest to newest, optionally without message payloads
+func (n *Node) FailedHandshakes(redactPayloads bool) []HandshakeTrace {
+	return n.handshakeTraces.all(redactPayloads)
+}
+
+// P2PMetrics returns the node's peer-to-peer metrics labelled by connection direction, network and connection type
+func (n *Node) P2PMetrics() P2PMetrics {
+	metrics := n.p2pMetrics.snapshot(n.peers.Keys())
+	metrics.Churn = n.churn.snapshot()
+	return metrics
+}
+
+// Stop stops the node: it stops accepting connections and messages, waits for the select loop and the managers to finish handling their
+// message (e.g. to connect the block the sync manager is connecting), closes the connections to the peers and saves the node's state:
+// blocks, chainstate, indexes, addresses, bans and peer churn. If they are still busy once ctx is done, Stop returns ctx's error without
+// saving anything, leaving the state as it was last saved. Otherwise the state is saved even if ctx is done meanwhile, as interrupting a
+// write could corrupt it, and Stop returns the errors saving it failed with. Stop fails with ErrNodeHasQuit if the node was already
+// stopped.
+func (n *Node) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrNodeHasQuit
+	}
+	n.stopped = true
+	n.closeListeners()
+	n.mu.Unlock()
+
+	log.Printf("Stopping Node...")
+	// dials, handshakes and peers started from now on are stopped by the cancelled context
+	n.cancel()
+	n.quit(nil)
+
+	drained := make(chan struct{})
+	go func() {
+		n.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		for _, peer := range n.peers.Keys() {
+			peer.Quit()
+		}
+		log.Printf("⚠️ Stopped the node without saving its state, as it was still handling a message")
+		return fmt.Errorf("node was still handling a message: %w", ctx.Err())
+	}
 	for _, peer := range n.peers.Keys() {
 		peer.Quit()
 	}
 
-	close(n.QuitCh)
+	err := n.saveState()
+	if err != nil {
+		log.Printf("⚠️ Stopped the node, but could not save all of its state: %s", err)
+		return err
+	}
+	log.Printf("Stopped Node")
+	return nil
+}
+
+// quit makes the select loop return err, which Start then returns. The node stops by itself this way, e.g. when the blocks below a UTXO
+// snapshot do not match it.
+func (n *Node) quit(err error) {
+	n.quitOnce.Do(func() {
+		n.quitErr = err
+		close(n.quitCh)
+	})
+}
 
-	err := n.saveBlocksToDisk()
+// saveState saves the state of the node once it is stopped, and returns the errors saving it failed with
+func (n *Node) saveState() error {
+	var errs []error
+	n.savePeerChurn()
+	err := n.addrMan.Save(n.fs, n.addrManPath())
 	if err != nil {
-		log.Printf("⚠️ Could not save blocks due to error: %s", err)
-	} else {
-		log.Printf("💾 Successfully saved blocks to file %s", n.blocksFileDirectory)
+		errs = append(errs, fmt.Errorf("could not save addresses: %w", err))
+	}
+	err = n.banManager.Save(n.fs, n.banListPath())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("could not save bans: %w", err))
+	}
+
+	// the blocks were stored or logged before the chainstate connected them, so the flushed chainstate never gets ahead of the stored blocks
+	errs = append(errs, n.closeChainstate(), n.closeTxIndex(), n.closeBlockFilterIndex())
+
+	if n.blockStore != nil {
+		// the blocks were stored as they were accepted
+		err = n.blockStore.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not close the block store: %w", err))
+		}
+		return errors.Join(errs...)
 	}
+	// without blocks, there is no blocks file to write
+	if len(n.blockIndex.Blocks()) > 0 {
+		err = n.checkpointBlocks()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not save blocks: %w", err))
+		} else {
+			log.Printf("💾 Successfully saved blocks to file %s", n.blocksFileDirectory)
+		}
+	}
+	if n.wal != nil {
+		_ = n.wal.Close()
+	}
+	return errors.Join(errs...)
 }
 
-func (n *Node) selectLoop() {
-	ticker := time.NewTicker(n.tickerDuration)
+// selectLoop relays transactions and serves the blocks and filters peers ask for, while the managers download the chain, connect to new
+// peers and discover addresses
+func (n *Node) selectLoop(ctx context.Context) error {
+	churnSaveTicker := time.NewTicker(constants.PeerChurnSaveInterval)
+	defer churnSaveTicker.Stop()
+	feeFilterTicker := time.NewTicker(constants.FeeFilterUpdateInterval)
+	defer feeFilterTicker.Stop()
+	mempoolExpiryTicker := time.NewTicker(constants.MempoolExpiryCheckInterval)
+	defer mempoolExpiryTicker.Stop()
 
 	for {
 		select {
-		case <-n.QuitCh:
-			log.Printf("[selectLoop] Node's QuitCh was closed")
-			return
-		case <-ticker.C:
-			log.Printf("[selectLoop] Executing handleTickerResponse()...")
-			err := n.handleTickerResponse()
-			if err != nil {
-				log.Printf("[selectLoop] handleTickerResponse() failed with error %s", err)
-			} else {
-				log.Printf("[selectLoop] handleTickerResponse() executed successfully")
-			}
-		case _ = <-n.addPeersCh:
-			log.Printf("[selectLoop] Executing handleAddPeersChResponse()...")
-			err := n.handleAddPeersChResponse()
-			if err != nil {
-				log.Printf("[selectLoop] handleAddPeersChResponse() failed with error %s", err)
-				sendGetAddrFailed := &ErrSendGetAddrMsgFailed{}
-				if errors.As(err, sendGetAddrFailed) {
-					log.Printf("[selectLoop] Quitting peer %s because sending it did not reply to getaddr msg in time", sendGetAddrFailed.Peer.conn.RemoteAddr())
-					sendGetAddrFailed.Peer.Quit()
-				} else if errors.Is(err, ErrNodeHasNoPeersOrUnconnectedAddrs) {
-					log.Printf("[selectLoop] Quitting node due to error %s", err)
-					n.Quit()
-				}
-			} else {
-				log.Printf("[selectLoop] handleAddPeersChResponse() executed successfully")
-			}
-		case invMsg := <-n.invMsgCh:
-			log.Printf("[selectLoop] Executing handleInvMsg()...")
-			err := n.handleInvMsg(invMsg)
+		case <-ctx.Done():
+			log.Printf("[selectLoop] Node's context was cancelled")
+			return nil
+		case <-n.quitCh:
+			log.Printf("[selectLoop] Node is stopping")
+			return n.quitErr
+		case <-churnSaveTicker.C:
+			n.savePeerChurn()
+		case <-feeFilterTicker.C:
+			n.updateFeeFilters()
+		case <-mempoolExpiryTicker.C:
+			n.expireMempoolTxs()
+		case getBlocksMsg := <-n.getBlocksMsgCh:
+			err := n.handleGetBlocksMsg(getBlocksMsg)
 			if err != nil {
-				log.Printf("[selectLoop] Quitting peer %s due to error %s", invMsg.Sender.conn.RemoteAddr(), err)
-				invMsg.Sender.Quit()
-			} else {
-				log.Printf("[selectLoop] handleInvMsg() executed successfully")
+				log.Printf("[selectLoop] Could not answer getblocks message of peer %s due to error %s", getBlocksMsg.Sender.conn.RemoteAddr(), err)
 			}
-		case blockMsg := <-n.blockMsgCh:
-			log.Printf("[selectLoop] Executing handleBlockMsg()...")
-			err := n.handleBlockMsg(blockMsg)
-			if err != nil {
-				log.Printf("[selectLoop] Quitting peer %s due to error %s", blockMsg.Sender.conn.RemoteAddr(), err)
-				blockMsg.Sender.Quit()
-			} else {
-				log.Printf("[selectLoop] handleBlockMsg() executed successfully")
+		case compactFilterMsg := <-n.compactFilterMsgCh:
+			err := n.handleCompactFilterRequest(compactFilterMsg)
+			if errors.Is(err, ErrInvalidCompactFilterRequest) {
+				log.Printf("[selectLoop] Quitting peer %s due to error %s", compactFilterMsg.Sender.conn.RemoteAddr(), err)
+				compactFilterMsg.Sender.Quit()
+			} else if err != nil {
+				log.Printf("[selectLoop] Could not answer %s message of peer %s due to error %s", compactFilterMsg.Request.CommandName(),
+					compactFilterMsg.Sender.conn.RemoteAddr(), err)
 			}
+		case txMsg := <-n.txMsgCh:
+			n.handleTxMsg(txMsg)
 		}
-
 	}
 }
 
-func (n *Node) handleTickerResponse() error {
-	missingBlocksHashes, err := n.getMissingBlocksHashes()
-	if err != nil {
-		return err
-	}
-	if len(missingBlocksHashes) > 0 {
-		randomPeer, ok := n.peers.GetRandomKey()
-		if !ok {
-			return nil
-		}
-		return n.sendGetBlockDataMsg(randomPeer, missingBlocksHashes)
+// syncIntervals returns how often the sync manager requests new blocks, checks the download and saves the chain
+func (n *Node) syncIntervals() syncIntervals {
+	return syncIntervals{
+		request:         n.tickerDuration,
+		staleTipCheck:   min(constants.StaleTipCheckInterval, n.staleTipTimeout),
+		downloadCheck:   min(constants.BlockDownloadCheckInterval, n.blockDownloadTimeout),
+		checkpoint:      constants.BlocksCheckpointInterval,
+		chainstateFlush: constants.ChainstateFlushInterval,
 	}
+}
 
-	err = n.requestForNewBlocks()
-	return err
+// addrIntervals returns how often the address manager relays addresses and advertises our external address
+func (n *Node) addrIntervals() (time.Duration, time.Duration) {
+	return n.addrRelayInterval, constants.AddrAdvertiseInterval
 }
 
+// requestForNewBlocks asks the peers for the blocks of the best header chain we miss, and the sync peer for the headers following our best
+// header
 func (n *Node) requestForNewBlocks() error {
-	latestBlockHash := message.Hash256(constants.GenesisBlockHash)
-	var err error
-	if length := n.blocks.Len(); length > 0 {
-		latestBlockHash, err = n.getLatestBlockHash()
-		if err != nil {
-			return err
-		}
-	}
-	log.Printf("sending getblocks message with latest block %s", latestBlockHash.String())
-	zeroBlockHash := message.Hash256{}
-	randomPeer, ok := n.peers.GetRandomKey()
+	n.scheduleBlockDownloads()
+	peer, ok := n.currentSyncPeer()
 	if !ok {
 		return nil
 	}
-	// hashStop set to zero to get as many blocks as possible (500)
-	return n.sendGetBlocksMsg(randomPeer, []message.Hash256{latestBlockHash}, zeroBlockHash)
+	return n.requestNewBlocksFrom(peer)
 }
 
-func (n *Node) handleAddPeersChResponse() error {
-	return n.addPeersIfNecessary()
-}
-
-func (n *Node) handleInvMsg(i *InvPayloadWithSender) error {
-	blockHashes := make([]message.Hash256, 0)
+// handleInvMsg asks the sender for the headers of the blocks it announced that we do not know of, which leads to the blocks being downloaded
+// in the order of the chain once the headers are checked. Blocks already in flight from a peer, such as the missing ancestors of orphans, are
+// not asked for again, as several peers usually announce the same blocks.
+func (n *Node) handleInvMsg(i *invPayloadWithSender) error {
+	unknownBlocks := 0
 
 	for _, inventory := range i.InvPayload.InventoryList {
 		if inventory.Type == message.MsgBlock || inventory.Type == message.MsgWitnessBlock {
-			if _, ok := n.blockHashes.Get(inventory.Hash); !ok {
-				blockHashes = append(blockHashes, inventory.Hash)
+			if !n.blockIndex.HasHeader(inventory.Hash) && !n.blockInFlight(inventory.Hash) {
+				unknownBlocks++
 			}
 		}
 	}
 
-	log.Printf("%d blocks found in inv message sent by peer %s", len(blockHashes), i.Sender.conn.RemoteAddr())
+	log.Printf("%d unknown blocks found in inv message sent by peer %s", unknownBlocks, i.Sender.conn.RemoteAddr())
 
-	if len(blockHashes) == 0 {
+	if unknownBlocks == 0 {
 		return nil
 	}
 
-	return n.sendGetBlockDataMsg(i.Sender, blockHashes)
+	return n.requestNewBlocksFrom(i.Sender)
 }
 
-func (n *Node) handleBlockMsg(msg *BlockPayloadWithSender) error {
+func (n *Node) handleTxMsg(msg *txPayloadWithSender) {
+	span := tracing.Start(msg.Trace, "node.handle_tx", msg.Sender.spanAttribute())
+	defer span.End()
+	// the outputs the transaction spends may be in the blocks still missing (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L4235)
+	if n.IsInitialBlockDownload() {
+		log.Printf("Ignoring transaction from peer %s during the initial block download", msg.Sender.conn.RemoteAddr())
+		return
+	}
+	d, isDoubleSpend := n.mempool.checkDoubleSpend(msg.TxPayload)
+	entry, replaced, err := n.mempool.Accept(msg.TxPayload)
+	if isDoubleSpend && !errors.Is(err, ErrInvalidTx) {
+		d.evicted = replaced
+		n.publishDoubleSpend(d, msg.Sender, message.Hash256{})
+	}
+	span.SetError(err)
+	// a coinbase spent too early matures in later blocks, which the peer may already have, so it is not punished for it as bitcoind does not
+	if errors.Is(err, ErrInvalidTx) && !errors.Is(err, utxo.ErrPrematureCoinbaseSpend) {
+		msg.Sender.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent an invalid transaction: %s", err))
+		return
+	}
+	// the node keeps no orphan transactions, so a transaction whose parents are not known yet is dropped until the peer sends it again
+	if errors.Is(err, ErrMissingInputs) {
+		log.Printf("Ignoring transaction from peer %s as %s", msg.Sender.conn.RemoteAddr(), err)
+		return
+	}
+	if err != nil {
+		log.Printf("Could not add transaction from peer %s to the mempool due to error: %s", msg.Sender.conn.RemoteAddr(), err)
+		return
+	}
+	span.SetAttributes(tracing.String("txid", entry.TxId.String()))
+	log.Printf("➕ Added transaction %s from peer %s to the mempool", entry.TxId.String(), msg.Sender.conn.RemoteAddr())
+	for _, r := range replaced {
+		log.Printf("🔁 Transaction %s replaced transaction %s in the mempool", entry.TxId, r.TxId)
+	}
+	n.publishNewTx(entry, replaced, msg.Sender)
+	n.relayTx(entry, msg.Sender)
+}
+
+// publishNewTx publishes that entry was added to the mempool, replacing the transactions of replaced. peer is the peer that sent the
+// transaction, nil if it was submitted to the node.
+func (n *Node) publishNewTx(entry *MempoolEntry, replaced []*MempoolEntry, peer *Peer) {
+	event := events.NewTx{
+		TxId:     entry.TxId.String(),
+		WtxId:    entry.WtxId.String(),
+		VSize:    entry.VSize,
+		Fee:      entry.Fee,
+		Replaced: make([]string, len(replaced)),
+	}
+	if peer != nil {
+		event.Peer = peer.conn.RemoteAddr().String()
+	}
+	for i, r := range replaced {
+		event.Replaced[i] = r.TxId.String()
+	}
+	n.events.Publish(events.TopicNewTx, event)
+}
+
+func (n *Node) handleBlockMsg(msg *blockPayloadWithSender) error {
+	span := tracing.Start(msg.Trace, "node.handle_block", msg.Sender.spanAttribute())
+	err := n.processBlockMsg(msg, span)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// processBlockMsg stores the block of msg and adds it to the node, recording the blocks it connects as children of span
+func (n *Node) processBlockMsg(msg *blockPayloadWithSender, span *tracing.Span) error {
 	blockHash, err := msg.BlockPayload.GetBlockHash()
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(tracing.String("hash", blockHash.String()))
 	log.Printf("Received Block %s from peer %s", blockHash.String(), msg.Sender.conn.RemoteAddr())
-	err = n.addBlockToNode(msg.BlockPayload)
-	if err != nil {
-		return err
+	n.blocksInFlight.Delete(blockHash)
+	alreadyKnown := n.blockIndex.HasBlock(blockHash)
+	if !alreadyKnown {
+		err = n.persistBlock(blockHash, msg.BlockPayload)
+		if err != nil {
+			return err
+		}
 	}
-
-	missingBlockHashes, err := n.getMissingBlocksHashes()
+	err = n.addTracedBlockToNode(msg.BlockPayload, span.SpanContext())
 	if err != nil {
 		return err
 	}
-	log.Printf("There are %d missing blocks", len(missingBlockHashes))
-	if len(missingBlockHashes) == 0 {
-		return nil
+	if !alreadyKnown {
+		n.publishNewBlock(msg, blockHash)
 	}
 
-	//randomPeer, ok := n.peers.GetRandomKey()
-	//if !ok {
-	//	return nil
-	//}
-	//log.Printf("Requesting %d missing blocks from peer %s", len(missingBlockHashes), randomPeer.conn.RemoteAddr())
-	//return n.sendGetBlockDataMsg(randomPeer, missingBlockHashes)
+	if missingAncestor, ok := n.blockIndex.MissingAncestor(blockHash); ok {
+		// since we know msg.Sender has the block, let's ask it for the missing ancestors rather than a random peer
+		return n.requestOrphanAncestors(msg.Sender, blockHash, missingAncestor)
+	}
+	// keeps the peers busy with the following blocks of the best chain
+	n.scheduleBlockDownloads()
+	return nil
+}
 
-	// since we know msg.Sender is historically responsive to "inv" requests, let's ask it for the missing blocks rather than a random peer
-	return n.sendGetBlockDataMsg(msg.Sender, missingBlockHashes)
+// publishNewBlock publishes a TopicNewBlock event for the block of msg, whose hash is blockHash. Its fees are only known if the block was
+// connected to the active chain, from the outputs the chainstate kept for undoing it.
+func (n *Node) publishNewBlock(msg *blockPayloadWithSender, blockHash message.Hash256) {
+	// blocks whose parent is not known yet are not in the block index
+	height := int32(-1)
+	if node, ok := n.blockIndex.Get(blockHash); ok {
+		height = node.Height
+	} else if coinbaseHeight, ok := msg.BlockPayload.CoinbaseHeight(n.params.Deployments.BIP34Height); ok {
+		height = coinbaseHeight
+	}
+	var fees *int64
+	if spent, err := n.chainstate.Load().Undo(blockHash); err == nil {
+		blockFees := utxo.BlockFees(msg.BlockPayload, spent)
+		fees = &blockFees
+	}
+	n.events.Publish(events.TopicNewBlock, events.NewBlock{
+		Hash:       blockHash.String(),
+		Height:     height,
+		TxCount:    len(msg.BlockPayload.Transactions),
+		Fees:       fees,
+		Timestamp:  time.Unix(int64(msg.BlockPayload.Timestamp), 0),
+		ReceivedAt: msg.ReceivedAt,
+		Peer:       msg.Sender.conn.RemoteAddr().String(),
+	})
 }
 
 func (n *Node) saveBlocksToDisk() error {
-	blocks := n.blocks.GetAll()
+	blocks := n.blockIndex.Blocks()
 	if len(blocks) == 0 {
 		return errors.New("no blocks to write to file")
 	}
 
-	f, err := os.Create(fmt.Sprintf("/tmp/%s", n.blocksFileDirectory))
+	tmpPath := n.blocksFileTmpPath()
+	f, err := storage.Create(n.fs, tmpPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	w := bufio.NewWriter(f)
-
-	blocksCountEncoded, err := message.VarInt(len(blocks)).Encode()
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(blocksCountEncoded)
-	if err != nil {
-		return err
-	}
+	checksum := crc32.New(castagnoli)
+	w := bufio.NewWriter(io.MultiWriter(f, checksum))
 
 	for _, block := range blocks {
 		blockEncoded, err := block.Encode()
 		if err != nil {
 			return err
 		}
-		_, err = w.Write(blockEncoded)
+		_, err = w.Write(encodeBlocksFileRecord(n.params.Magic, blockEncoded))
 		if err != nil {
 			return err
 		}
@@ -334,44 +943,143 @@ func (n *Node) saveBlocksToDisk() error {
 	if err != nil {
 		return err
 	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
 
-	return os.Rename(fmt.Sprintf("/tmp/%s", n.blocksFileDirectory), n.blocksFileDirectory)
+	// once the manifest describes the new blocks file, a crash before the rename is completed by recoverBlocksFile
+	manifest, err := json.Marshal(blocksManifest{Blocks: len(blocks), Size: size, CRC32C: checksum.Sum32()})
+	if err != nil {
+		return err
+	}
+	err = storage.WriteFileAtomic(n.fs, n.blocksManifestPath(), manifest)
+	if err != nil {
+		return err
+	}
+	return n.fs.Rename(tmpPath, n.blocksFileDirectory)
 }
 
-func (n *Node) readBlocksFromDisk() error {
-	f, err := os.Open(n.blocksFileDirectory)
+// readBlocksFile reads the blocks in the blocks file and replays the write-ahead log of the blocks accepted since it was last saved
+func (n *Node) readBlocksFile() error {
+	err := n.recoverBlocksFile()
 	if err != nil {
+		log.Printf("⚠️ Couldn't recover the blocks file %s due to error: %s. Quitting now...", n.blocksFileDirectory, err)
 		return err
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
+	truncated, err := n.readBlocksFromDisk()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("File %s does not exist. Starting afresh...", n.blocksFileDirectory)
+		} else {
+			log.Printf("⚠️ Couldn't read the blocks in file %s due to error: %s. Quitting now...", n.blocksFileDirectory, err)
+			return err
+		}
+	} else {
+		log.Printf("💾 Successfully read %d blocks in file %s", n.blockIndex.BlockCount(), n.blocksFileDirectory)
+		err = n.verifyStoredBlocks()
+		if err != nil {
+			log.Printf("⚠️ Blocks in file %s failed checkpoint verification due to error: %s. Quitting now...", n.blocksFileDirectory, err)
+			return err
+		}
+	}
 
-	blocksCount, err := message.DecodeVarInt(r)
+	n.wal, err = storage.OpenWAL(n.fs, n.chainstateWALPath())
 	if err != nil {
+		log.Printf("⚠️ Couldn't open the write-ahead log %s due to error: %s. Quitting now...", n.chainstateWALPath(), err)
 		return err
 	}
-	blocks := make([]*message.BlockPayload, blocksCount)
-	for i := range blocksCount {
-		block, err := message.DecodeBlockPayload(r)
+	if truncated {
+		// the blocks in the log follow the dropped ones, so they are downloaded again with them
+		log.Printf("⚠️ Discarding the write-ahead log %s as the blocks file was truncated", n.chainstateWALPath())
+		err = n.wal.Truncate()
 		if err != nil {
-			return err
+			log.Printf("⚠️ Couldn't discard the write-ahead log %s due to error: %s. Quitting now...", n.chainstateWALPath(), err)
 		}
-		blocks[i] = block
+		return err
+	}
+	replayed, err := n.replayChainstateWAL()
+	n.uncheckpointedBlocks.Store(int64(replayed))
+	if err != nil {
+		log.Printf("⚠️ Couldn't replay the write-ahead log %s due to error: %s. Quitting now...", n.chainstateWALPath(), err)
+		return err
+	}
+	return nil
+}
+
+// readBlocksFromDisk adds the blocks in the blocks file to the node. Reading stops at the first record that is damaged (e.g. by a disk error or
+// a write cut short by a crash), and the file is truncated to the blocks before it so that the following ones are downloaded again. It reports
+// whether the file was truncated.
+func (n *Node) readBlocksFromDisk() (bool, error) {
+	f, err := storage.Open(n.fs, n.blocksFileDirectory)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var blocks []*message.BlockPayload
+	var size int64
+	truncated := false
+	for {
+		block, recordSize, err := readBlocksFileRecord(r, n.params.Magic)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, ErrBlocksFileRecordCorrupted) {
+			log.Printf("⚠️ Block %d of file %s (at offset %d) is damaged: %s. Truncating the file to the %d blocks before it...",
+				len(blocks), n.blocksFileDirectory, size, err, len(blocks))
+			err = n.truncateBlocksFile(size, len(blocks))
+			if err != nil {
+				return false, err
+			}
+			truncated = true
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		blocks = append(blocks, block)
+		size += recordSize
 	}
 
 	for _, block := range blocks {
 		err := n.addBlockToNode(block)
 		if err != nil {
-			return err
+			return truncated, err
 		}
 	}
 
+	return truncated, nil
+}
+
+// verifyStoredBlocks spot-checks the blocks read from disk against the embedded checkpoints, catching a tampered or corrupted blocks file
+// before the node starts using it
+func (n *Node) verifyStoredBlocks() error {
+	start := time.Now()
+	checkpoints, err := parseCheckpoints(n.params.Checkpoints)
+	if err != nil {
+		return err
+	}
+	err = verifyCheckpoints(n.blockIndex.Blocks(), n.blockIndex.Genesis().Hash, checkpoints, n.tuning.ValidationWorkers)
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ Verified stored blocks against %d checkpoints in %s", len(checkpoints), time.Since(start))
 	return nil
 }
 
 func (n *Node) addPeersIfNecessary() error {
+	// manual peers reconnect by themselves
+	if n.discoveryDisabled {
+		return nil
+	}
+
 	if n.peers.Len() == 0 && n.unconnectedAddrs.Len() == 0 {
-		n.Quit()
 		return ErrNodeHasNoPeersOrUnconnectedAddrs
 	}
 
@@ -385,23 +1093,9 @@ func (n *Node) addPeersIfNecessary() error {
 
 	log.Printf("Requesting for %d new addresses", connectionsToAdd)
 
-	if randomPeer, ok := n.peers.GetRandomKey(); ok && n.unconnectedAddrs.Len() < connectionsToAdd {
-		getAddrResponseCh, err := n.sendGetAddrMsg(randomPeer)
-		if err != nil {
-			return err
-		}
-		var addresses []message.Address
-		// times out if a response is not gotten in `n.getAddrWaitTime` seconds
-		select {
-		case a := <-getAddrResponseCh:
-			addresses = a
-		case <-time.After(n.getAddrWaitTime):
-			addresses = nil
-		}
-		for _, address := range addresses {
-			tcpAddress := TCPAddress{IpAddress: [16]byte(address.NetworkAddress.IpAddress.To16()), Port: address.NetworkAddress.Port}
-			n.addUnconnectedAddrToNode(tcpAddress)
-		}
+	if n.unconnectedAddrs.Len() < connectionsToAdd {
+		// waits at most `n.getAddrWaitTime` for the peers to answer
+		n.requestAddrsFromPeers(constants.GetAddrPeers)
 	}
 
 	log.Printf("Connecting to new peers until min peers reached (Current peers count: %d)", n.peers.Len())
@@ -429,122 +1123,150 @@ func (n *Node) sendGetAddrMsg(peer *Peer) (<-chan []message.Address, error) {
 	return getAddrResponseCh, nil
 }
 
-func (n *Node) sendGetBlocksMsg(peer *Peer, blockLocatorHashes []message.Hash256, hashStop message.Hash256) error {
-	return peer.sendGetBlocksMsg(n.protocolVersion, blockLocatorHashes, hashStop)
-}
-
+// sendGetBlockDataMsg asks peer for the blocks with blockHashes in getdata messages of at most constants.MaxGetDataInventories blocks. Callers
+// keep the blocks in flight from a peer under constants.MaxBlocksInFlightPerPeer, leaving the other blocks to be requested as blocks arrive
+// (see scheduleBlockDownloads).
 func (n *Node) sendGetBlockDataMsg(peer *Peer, blockHashes []message.Hash256) error {
 	blockInventories := make([]message.Inventory, len(blockHashes))
 	for i, blockHash := range blockHashes {
 		blockInventories[i] = message.Inventory{Type: message.MsgBlock, Hash: blockHash}
 	}
 
-	return peer.sendGetBlockDataMsg(blockInventories)
+	for chunk := range slices.Chunk(blockInventories, constants.MaxGetDataInventories) {
+		err := peer.sendGetBlockDataMsg(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// attemptAddingSomePeers tries connecting to up to maxNewPeers unconnected addresses with n.tuning.DialWorkers dials at most in flight, starting
+// them n.tuning.DialInterval apart
 func (n *Node) attemptAddingSomePeers(maxNewPeers int) uint64 {
 	var successCount atomic.Uint64
 
+	addrs := make(chan TCPAddress)
 	var wg sync.WaitGroup
-	for _ = range maxNewPeers {
-		unconnectedAddr, ok := n.unconnectedAddrs.Pop()
-		if !ok {
-			break
-		}
-
+	for range min(max(n.tuning.DialWorkers, 1), maxNewPeers) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			_, err := n.AddPeer(&net.TCPAddr{IP: unconnectedAddr.IpAddress[:], Port: int(unconnectedAddr.Port)}, message.NodeNetwork)
-			if err != nil {
-				log.Printf("❌ Could not add peer %s due to error: %s (Current peer count: %d)", unconnectedAddr.String(), err, n.peers.Len())
-			} else {
-				successCount.Add(1)
+			for unconnectedAddr := range addrs {
+				_, err := n.AddPeer(&net.TCPAddr{IP: unconnectedAddr.IpAddress[:], Port: int(unconnectedAddr.Port)})
+				if err != nil {
+					log.Printf("❌ Could not add peer %s due to error: %s (Current peer count: %d)", unconnectedAddr.String(), err, n.peers.Len())
+				} else {
+					successCount.Add(1)
+				}
 			}
 		}()
 	}
+
+dialing:
+	for i := range maxNewPeers {
+		if i > 0 && n.tuning.DialInterval > 0 {
+			select {
+			case <-time.After(n.tuning.DialInterval):
+			case <-n.ctx.Done():
+				break dialing
+			}
+		}
+		unconnectedAddr, ok := n.unconnectedAddrs.Pop()
+		if !ok {
+			break
+		}
+		addrs <- unconnectedAddr
+	}
+	close(addrs)
 	wg.Wait()
 
 	return successCount.Load()
 }
 
-func (n *Node) addPeerToNode(peerNode *Peer) {
-	n.peers.Set(peerNode, struct{}{})
-	n.connectedAddrs.Set(peerNode.tcpAddress, struct{}{})
-	n.unconnectedAddrs.Delete(peerNode.tcpAddress)
+func (n *Node) addPeerToNode(peer *Peer) {
+	n.churn.opened(peer.direction, peer.connectedAt)
+	n.peers.Set(peer, struct{}{})
+	n.connectedAddrs.Set(peer.tcpAddress, struct{}{})
+	n.unconnectedAddrs.Delete(peer.tcpAddress)
+	n.publishPeerEvent(peer, events.PeerConnected, 0)
 }
 
-func (n *Node) removePeerFromNode(peerNode *Peer) {
-	n.peers.Delete(peerNode)
-	n.connectedAddrs.Delete(peerNode.tcpAddress)
+func (n *Node) removePeerFromNode(peer *Peer) {
+	now := time.Now()
+	n.churn.closed(peer.direction, now.Sub(peer.connectedAt), now)
+	n.peers.Delete(peer)
+	n.connectedAddrs.Delete(peer.tcpAddress)
+	n.releaseBlocksInFlight(peer)
+	if peer.remoteNonce != 0 {
+		n.remoteNonces.Delete(peer.remoteNonce)
+	}
+	if peer.ShouldBan() {
+		n.banManager.Ban(peer.tcpAddress.IpAddress[:])
+	}
 
-	log.Printf("⬇️ Removing peer %s from node (Current peers count: %d)", peerNode.conn.RemoteAddr(), n.peers.Len())
+	log.Printf("⬇️ Removing peer %s from node (Current peers count: %d)", peer.conn.RemoteAddr(), n.peers.Len())
+	n.publishPeerEvent(peer, events.PeerDisconnected, now.Sub(peer.connectedAt))
+
+	if peer.manual && n.isManualAddr(peer.tcpAddress) {
+		remoteAddr := &net.TCPAddr{IP: peer.tcpAddress.IpAddress[:], Port: int(peer.tcpAddress.Port)}
+		go n.keepManualPeerConnected(remoteAddr, true)
+	}
 
 	if n.peers.Len() < n.minimumPeers {
 		n.notifyThatPeersIsBelowMinPeers()
 	}
 }
 
+// publishPeerEvent publishes that peer connected or disconnected (action), after being connected for connectedFor if it disconnected
+func (n *Node) publishPeerEvent(peer *Peer, action string, connectedFor time.Duration) {
+	n.events.Publish(events.TopicPeerEvents, events.PeerEvent{
+		Action:         action,
+		Id:             peer.id,
+		Addr:           peer.conn.RemoteAddr().String(),
+		Direction:      string(peer.direction),
+		ConnectionType: string(peer.connectionType),
+		UserAgent:      peer.Capabilities().UserAgent,
+		ConnectedFor:   connectedFor,
+	})
+}
+
 func (n *Node) addUnconnectedAddrToNode(unconnectedAddr TCPAddress) {
+	if n.banManager.IsBanned(unconnectedAddr.IpAddress[:]) {
+		return
+	}
 	if _, ok := n.connectedAddrs.Get(unconnectedAddr); !ok {
 		n.unconnectedAddrs.Set(unconnectedAddr, struct{}{})
 	}
 }
 
 func (n *Node) notifyThatPeersIsBelowMinPeers() {
-	select {
-	case n.addPeersCh <- struct{}{}:
-	default:
-		log.Println("addPeersCh has already been notified")
-	}
+	n.peerManager.notify()
 }
 
 func (n *Node) addBlockToNode(block *message.BlockPayload) error {
+	return n.addTracedBlockToNode(block, tracing.SpanContext{})
+}
+
+// addTracedBlockToNode adds block to the node like addBlockToNode, recording the blocks it connects as children of the span identified by
+// trace
+func (n *Node) addTracedBlockToNode(block *message.BlockPayload, trace tracing.SpanContext) error {
 	blockHash, err := block.GetBlockHash()
 	if err != nil {
 		return err
 	}
-	if _, ok := n.blockHashes.Get(blockHash); ok {
-		return nil
+	added, err := n.blockIndex.AddBlock(block, blockHash)
+	if err != nil || !added {
+		return err
 	}
 
-	n.blockHashes.Set(blockHash, struct{}{})
-	n.blocks.Append(block)
+	n.tipProgress.Store(time.Now().UnixNano())
 
 	log.Printf("️➕ Added block %s to node", blockHash.String())
 
-	return nil
-}
-
-func (n *Node) getMissingBlocksHashes() ([]message.Hash256, error) {
-	missingBlocks := make([]message.Hash256, 0)
-	// genesis block's previous block
-	zeroBlockHash := message.Hash256{}
-
-	for _, block := range n.blocks.GetAll() {
-		if _, ok := n.blockHashes.Get(block.PrevBlock); !ok && block.PrevBlock != zeroBlockHash {
-			missingBlocks = append(missingBlocks, block.PrevBlock)
-		}
-	}
-
-	return missingBlocks, nil
-}
-
-// TODO - Improve (this is very inefficient in the long term since it iterates over every block)
-func (n *Node) getLatestBlockHash() (message.Hash256, error) {
-	var latestBlock *message.BlockPayload
-	latestTimestamp := uint32(0)
-
-	for _, block := range n.blocks.GetAll() {
-		if block.Timestamp > latestTimestamp {
-			latestTimestamp = block.Timestamp
-			latestBlock = block
-		}
-	}
-
-	if latestBlock == nil {
-		return message.Hash256{}, errors.New("No blocks exist")
+	err = n.activateBestChain(trace)
+	if err != nil {
+		return err
 	}
-
-	return latestBlock.GetBlockHash()
+	return n.validateSnapshot()
 }
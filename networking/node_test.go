@@ -1,10 +1,17 @@
 package networking
 
 import (
+	"context"
+	"errors"
 	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/events"
 	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"net"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -33,7 +40,11 @@ func setupPeerConnectionForNodeTestSuite(s *NodeTestSuite) {
 	if err != nil {
 		s.FailNow(err.Error())
 	}
+	acceptPeerConnection(s)
+}
 
+// acceptPeerConnection accepts the next connection from the node on the peer listener and performs the peer side of the handshake
+func acceptPeerConnection(s *NodeTestSuite) {
 	var wg sync.WaitGroup
 	s.peerConnWg = &wg
 	wg.Add(1)
@@ -65,20 +76,23 @@ func setupPeerConnectionForNodeTestSuite(s *NodeTestSuite) {
 }
 
 func setupNode(s *NodeTestSuite) {
-	s.node = NewNode(
-		70015,
-		message.NodeNetwork,
-		5,
-		constants.BlocksFileDirectory,
-		20*time.Second,
-		10*time.Second,
-		10*time.Second,
-	)
+	s.node = NewNode(testConfig(storage.NewMemFS()))
+}
+
+// testConfig returns the settings of the nodes of the tests, which announce protocol version 70015 and keep their state in fs
+func testConfig(fs storage.FS) Config {
+	config := DefaultConfig()
+	config.ProtocolVersion = 70015
+	config.BlocksFile = "blocks.dat"
+	config.FS = fs
+	return config
 }
 
 func (s *NodeTestSuite) SetupTest() {
 	setupPeerConnectionForNodeTestSuite(s)
 	setupNode(s)
+	// the peer advertises a height far above the node's, which would otherwise keep the node in the initial block download
+	s.node.caughtUp.Store(true)
 }
 
 func (s *NodeTestSuite) TearDownTest() {
@@ -89,7 +103,7 @@ func (s *NodeTestSuite) TearDownTest() {
 }
 
 func (s *NodeTestSuite) TestNode_AddPeerWorks() {
-	peer, err := s.node.AddPeer(&s.peerAddr, message.NodeNetwork)
+	peer, err := s.node.AddPeer(&s.peerAddr)
 	s.NoError(err)
 	s.Equal(1, s.node.peers.Len())
 	_, ok := s.node.peers.Get(peer)
@@ -97,10 +111,10 @@ func (s *NodeTestSuite) TestNode_AddPeerWorks() {
 }
 
 func (s *NodeTestSuite) TestNode_RemovePeerIfItQuits() {
-	peer, err := s.node.AddPeer(&s.peerAddr, message.NodeNetwork)
+	peer, err := s.node.AddPeer(&s.peerAddr)
 	s.NoError(err)
 
-	go s.node.Start()
+	go s.node.Start(context.Background())
 
 	s.Equal(1, s.node.peers.Len())
 	_, ok := s.node.peers.Get(peer)
@@ -116,19 +130,17 @@ func (s *NodeTestSuite) TestNode_RemovePeerIfItQuits() {
 }
 
 func (s *NodeTestSuite) TestNode_AllPeersQuitIfNodeQuits() {
-	peer, err := s.node.AddPeer(&s.peerAddr, message.NodeNetwork)
+	peer, err := s.node.AddPeer(&s.peerAddr)
 	s.NoError(err)
 
-	go s.node.Start()
+	go s.node.Start(context.Background())
 
 	s.Equal(1, s.node.peers.Len())
 	_, ok := s.node.peers.Get(peer)
 	s.True(ok)
 
 	// node has quit
-	s.node.Quit()
-
-	<-s.node.QuitCh
+	s.NoError(s.node.Stop(context.Background()))
 
 	s.Equal(0, s.node.peers.Len())
 	_, ok = s.node.peers.Get(peer)
@@ -137,10 +149,10 @@ func (s *NodeTestSuite) TestNode_AllPeersQuitIfNodeQuits() {
 
 // TODO - Improve test
 func (s *NodeTestSuite) TestNode_PeerRemainsInNodeIfNothingHappens() {
-	peer, err := s.node.AddPeer(&s.peerAddr, message.NodeNetwork)
+	peer, err := s.node.AddPeer(&s.peerAddr)
 	s.NoError(err)
 
-	go s.node.Start()
+	go s.node.Start(context.Background())
 	// nothing happens
 	time.Sleep(5 * time.Second)
 
@@ -148,3 +160,597 @@ func (s *NodeTestSuite) TestNode_PeerRemainsInNodeIfNothingHappens() {
 	_, ok := s.node.peers.Get(peer)
 	s.True(ok)
 }
+
+func (s *NodeTestSuite) TestNode_AddPeerRejectsDuplicateAddress() {
+	peer, err := s.node.AddPeer(&s.peerAddr)
+	s.NoError(err)
+
+	// a second connection to the same address should be rejected before dialing
+	_, err = s.node.AddPeer(&s.peerAddr)
+	s.ErrorIs(err, ErrPeerAlreadyConnected)
+
+	s.Equal(1, s.node.peers.Len())
+	_, ok := s.node.peers.Get(peer)
+	s.True(ok)
+}
+
+func (s *NodeTestSuite) TestNode_ManualPeerIsReconnectedAndNeverBanned() {
+	s.node.manualPeerRetryInterval = 10 * time.Millisecond
+	defer s.node.Stop(context.Background())
+
+	s.node.AddManualPeer(&s.peerAddr)
+	s.peerConnWg.Wait()
+	s.Eventually(func() bool { return s.node.peers.Len() == 1 }, time.Second, 10*time.Millisecond)
+	peer, _ := s.node.peers.GetRandomKey()
+	s.True(peer.manual)
+
+	// misbehaving should neither quit nor ban the peer
+	peer.Misbehaving(constants.BanScoreThreshold, "test")
+	s.False(peer.ShouldBan())
+	select {
+	case <-peer.QuitCh:
+		s.Fail("manual peer quit for misbehaving")
+	default:
+	}
+
+	// the node should reconnect once the connection drops
+	firstConn := s.peerConn
+	acceptPeerConnection(s)
+	s.NoError(firstConn.Close())
+	<-peer.QuitCh
+	s.peerConnWg.Wait()
+	s.Eventually(func() bool {
+		reconnected, ok := s.node.peers.GetRandomKey()
+		return ok && reconnected != peer
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *NodeTestSuite) TestNode_ExternalAddrIsAdvertised() {
+	s.node.SetExternalIP(net.ParseIP("1.2.3.4"))
+	s.Require().NoError(s.node.Listen([]Binding{{Addr: "127.0.0.1:0"}}))
+	port := uint16(s.node.ListenAddrs()[0].Port)
+	_, err := s.node.AddPeer(&s.peerAddr)
+	s.NoError(err)
+	s.peerConnWg.Wait()
+
+	// the address is advertised as soon as the peer is connected, and then periodically
+	for range 2 {
+		msg := receiveMsg(s.T(), s.peerConn)
+		// the fee filter follows the address when the peer connects
+		if _, ok := msg.Payload.(*message.FeeFilterPayload); ok {
+			msg = receiveMsg(s.T(), s.peerConn)
+		}
+		addrPayload, ok := msg.Payload.(*message.AddrPayload)
+		s.Require().True(ok)
+		s.Require().Len(addrPayload.AddressList, 1)
+		s.True(addrPayload.AddressList[0].NetworkAddress.IpAddress.Equal(net.ParseIP("1.2.3.4")))
+		s.Equal(port, addrPayload.AddressList[0].NetworkAddress.Port)
+
+		s.node.advertiseExternalAddr()
+	}
+}
+
+func (s *NodeTestSuite) TestNode_PeersReturnsPeerInfo() {
+	_, err := s.node.AddPeer(&s.peerAddr)
+	s.NoError(err)
+	s.peerConnWg.Wait()
+
+	pingMsg, err := message.NewPingMessage(1)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, pingMsg)
+	// the fee filter sent when the peer connected comes before the pong
+	s.Equal(message.FeeFilterCommand, receiveMsg(s.T(), s.peerConn).Payload.CommandName())
+	s.Equal(message.PongCommand, receiveMsg(s.T(), s.peerConn).Payload.CommandName())
+
+	s.Eventually(func() bool { return s.node.Peers()[0].BytesSent == 64 }, time.Second, 10*time.Millisecond)
+	peers := s.node.Peers()
+	s.Require().Len(peers, 1)
+	info := peers[0]
+	s.Equal(s.peerAddr.String(), info.Addr)
+	s.Equal(Outbound, info.Direction)
+	s.Equal(message.NodeNetwork, info.Services)
+	s.Equal("/Peer:0.0.1", info.UserAgent)
+	s.Equal(int32(70015), info.ProtocolVersion)
+	s.Equal(int32(300), info.StartingHeight)
+	s.False(info.ConnectedAt.IsZero())
+	s.False(info.LastRecv.IsZero())
+	s.Equal(uint64(64), info.BytesSent)
+	s.Equal(uint64(32), info.BytesRecv)
+}
+
+func (s *NodeTestSuite) TestNode_NetTotalsIncludeAllPeers() {
+	_, err := s.node.AddPeer(&s.peerAddr)
+	s.NoError(err)
+	s.peerConnWg.Wait()
+
+	pingMsg, err := message.NewPingMessage(1)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, pingMsg)
+	receiveMsg(s.T(), s.peerConn)
+	receiveMsg(s.T(), s.peerConn)
+
+	// the fee filter sent when the peer connected is counted too
+	s.Eventually(func() bool { return s.node.NetTotals().BytesSent == 64 }, time.Second, 10*time.Millisecond)
+	netTotals := s.node.NetTotals()
+	s.Equal(uint64(32), netTotals.BytesReceived)
+	s.Equal(uint64(32), netTotals.BytesReceivedPerCommand["ping"])
+	s.Equal(uint64(32), netTotals.BytesSentPerCommand["pong"])
+	s.Equal(uint64(32), netTotals.BytesSentPerCommand["feefilter"])
+}
+
+func (s *NodeTestSuite) TestNode_P2PMetricsAreLabelled() {
+	_, err := s.node.AddPeer(&s.peerAddr)
+	s.NoError(err)
+	s.peerConnWg.Wait()
+
+	pingMsg, err := message.NewPingMessage(1)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, pingMsg)
+	receiveMsg(s.T(), s.peerConn)
+
+	labels := ConnectionLabels{Direction: Outbound, Network: NetworkIPv4, ConnectionType: FullRelay}
+	s.Eventually(func() bool {
+		return s.node.P2PMetrics().Traffic[TrafficLabels{ConnectionLabels: labels, Command: "pong"}].MessagesSent == 1
+	}, time.Second, 10*time.Millisecond)
+	metrics := s.node.P2PMetrics()
+	s.Equal(map[ConnectionLabels]int{labels: 1}, metrics.Connections)
+	s.Equal(TrafficCounters{MessagesReceived: 1, BytesReceived: 32}, metrics.Traffic[TrafficLabels{ConnectionLabels: labels, Command: "ping"}])
+}
+
+func newFakePeerNode(t *testing.T, tickerDuration time.Duration) *Node {
+	config := testConfig(storage.NewMemFS())
+	config.MinimumPeers = 1
+	config.RequestInterval = tickerDuration
+	config.DialTimeout, config.GetAddrWait = time.Second, time.Second
+	node := NewNode(config)
+	// the chains of the tests have far less work than mainnet's, so their headers would only be presynced
+	node.params.MinimumChainWork = ""
+	t.Cleanup(func() { _ = node.Stop(context.Background()) })
+	return node
+}
+
+func TestNode_StartsFromGenesisBlockOfNetwork(t *testing.T) {
+	genesis := networkingtest.GenesisBlock(t)
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.AnswerGetHeaders(genesis)
+
+	node := newFakePeerNode(t, 50*time.Millisecond)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	// the genesis block comes with the network's parameters, so only the headers following it are asked for
+	genesisHash, err := genesis.GetBlockHash()
+	require.NoError(t, err)
+	getHeaders := conn.Expect(message.GetHeadersCommand, time.Second).Payload.(*message.GetHeadersPayload)
+	require.Equal(t, []message.Hash256{genesisHash}, getHeaders.BlockLocatorHashes)
+	require.True(t, node.blockIndex.HasBlock(genesisHash))
+	require.Zero(t, node.blockIndex.BlockCount(), "the genesis block is not downloaded")
+
+	regtest := newFakePeerNode(t, 50*time.Millisecond)
+	require.NoError(t, regtest.SetNetworkParams(constants.RegtestParams))
+	tip, height := regtest.chainstate.Load().Tip()
+	require.Equal(t, "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206", tip.String())
+	require.Zero(t, height)
+	require.Equal(t, tip, regtest.blockIndex.Tip().Hash)
+	require.Equal(t, uint32(1296688602), regtest.blockIndex.Tip().Timestamp)
+
+	testnet := newFakePeerNode(t, 50*time.Millisecond)
+	require.NoError(t, testnet.SetNetworkParams(constants.TestnetParams))
+	tip, _ = testnet.chainstate.Load().Tip()
+	require.Equal(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", tip.String())
+	genesisNode, ok := testnet.blockIndex.Get(tip)
+	require.True(t, ok)
+	header := genesisNode.Header()
+	require.NoError(t, header.CheckProofOfWorkWithLimit(tip, constants.TestnetParams.PowLimitBits))
+}
+
+// mineRegtestBlocks returns length blocks following prev, each with a coinbase transaction, whose proof of work is valid on regtest, and their
+// hashes
+func mineRegtestBlocks(t *testing.T, prev *message.BlockPayload, prevHash message.Hash256, length int) ([]message.BlockPayload, []message.Hash256) {
+	blocks := make([]message.BlockPayload, length)
+	hashes := make([]message.Hash256, length)
+	for i := range blocks {
+		blocks[i], hashes[i] = mineRegtestBlock(t, prevHash, prev.Timestamp+uint32(i+1), i+1)
+		prevHash = hashes[i]
+	}
+	return blocks, hashes
+}
+
+// mineRegtestBlock returns the block at height following the block with hash prevHash, whose coinbase pays 50 satoshis to OP_TRUE and is
+// followed by txs, and its hash
+func mineRegtestBlock(t *testing.T, prevHash message.Hash256, timestamp uint32, height int, txs ...message.TxPayload) (message.BlockPayload, message.Hash256) {
+	block := message.BlockPayload{Version: 1, PrevBlock: prevHash, Timestamp: timestamp, Bits: constants.RegtestParams.PowLimitBits}
+	block.Transactions = append([]message.TxPayload{{
+		Version:            1,
+		TransactionInputs:  []message.TxIn{{SignatureScript: []byte{0x01, byte(height)}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs: []message.TxOut{{Value: 50, PkScript: []byte{0x51}}},
+	}}, txs...)
+	merkleRoot, err := block.ComputeMerkleRoot()
+	require.NoError(t, err)
+	block.MerkleRoot = merkleRoot
+	for {
+		hash, err := block.GetBlockHash()
+		require.NoError(t, err)
+		if block.CheckProofOfWorkWithLimit(hash, constants.RegtestParams.PowLimitBits) == nil {
+			return block, hash
+		}
+		block.Nonce++
+	}
+}
+
+func TestNode_SyncsRegtestBlocks(t *testing.T) {
+	genesis, genesisHash, err := parseGenesisBlock(constants.RegtestParams.GenesisBlock)
+	require.NoError(t, err)
+	blocks, hashes := mineRegtestBlocks(t, genesis, genesisHash, 3)
+	// the target of regtest blocks is far easier than mainnet allows
+	require.ErrorIs(t, blocks[0].CheckProofOfWork(hashes[0]), message.ErrInvalidTarget)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.UseMagic(constants.RegtestMagicValue)
+	fakePeer.AnswerGetHeaders(genesis, &blocks[0], &blocks[1], &blocks[2])
+	fakePeer.ServeBlocks(&blocks[0], &blocks[1], &blocks[2])
+	node := newFakePeerNode(t, 50*time.Millisecond)
+	require.NoError(t, node.SetNetworkParams(constants.RegtestParams))
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	require.Eventually(t, func() bool {
+		tip, height := node.chainstate.Load().Tip()
+		return tip == hashes[2] && height == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNode_SkipsScriptChecksOfBlocksBuriedByCheckpoint(t *testing.T) {
+	genesis, genesisHash, err := parseGenesisBlock(constants.RegtestParams.GenesisBlock)
+	require.NoError(t, err)
+	// failingSpend returns a transaction spending the coinbase of block with a signature script that fails, as OP_RETURN does
+	failingSpend := func(block message.BlockPayload) message.TxPayload {
+		txId, err := block.Transactions[0].GetTxId()
+		require.NoError(t, err)
+		return message.TxPayload{
+			Version:            1,
+			TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: txId}, SignatureScript: []byte{0x6a}, Sequence: 0xFFFFFFFF}},
+			TransactionOutputs: []message.TxOut{{Value: 40, PkScript: []byte{0x51}}},
+		}
+	}
+	// the coinbases of the first blocks can only be spent once they have matured
+	blocks, hashes := mineRegtestBlocks(t, genesis, genesisHash, constants.CoinbaseMaturity)
+	height := constants.CoinbaseMaturity + 1
+	block1, hash1 := mineRegtestBlock(t, hashes[len(hashes)-1], genesis.Timestamp+uint32(height), height, failingSpend(blocks[0]))
+	block2, hash2 := mineRegtestBlock(t, hash1, genesis.Timestamp+uint32(height+1), height+1, failingSpend(blocks[1]))
+	served := []*message.BlockPayload{}
+	for i := range blocks {
+		served = append(served, &blocks[i])
+	}
+	served = append(served, &block1, &block2)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.UseMagic(constants.RegtestMagicValue)
+	fakePeer.AnswerGetHeaders(append([]*message.BlockPayload{genesis}, served...)...)
+	fakePeer.ServeBlocks(served...)
+	node := newFakePeerNode(t, 50*time.Millisecond)
+	params := constants.RegtestParams
+	params.Checkpoints = map[int32]string{int32(height): hash1.String()}
+	require.NoError(t, node.SetNetworkParams(params))
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	// the failing script of the checkpointed block is not run, as the checkpoint buries it, but the one of the block after it is
+	require.Eventually(t, func() bool {
+		tip, tipHeight := node.chainstate.Load().Tip()
+		return tip == hash1 && tipHeight == int32(height)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return node.blockIndex.BlockCount() == len(served) }, 5*time.Second, 10*time.Millisecond)
+	require.Never(t, func() bool {
+		tip, _ := node.chainstate.Load().Tip()
+		return tip == hash2
+	}, 500*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestNode_PublishesFeesOfConnectedBlocks(t *testing.T) {
+	genesis, genesisHash, err := parseGenesisBlock(constants.RegtestParams.GenesisBlock)
+	require.NoError(t, err)
+	blocks, hashes := mineRegtestBlocks(t, genesis, genesisHash, constants.CoinbaseMaturity)
+	coinbase1, err := blocks[0].Transactions[0].GetTxId()
+	require.NoError(t, err)
+	// spends the 50 satoshis of the coinbase of block 1 once it has matured, paying a fee of 20
+	height := constants.CoinbaseMaturity + 1
+	block2, hash2 := mineRegtestBlock(t, hashes[len(hashes)-1], genesis.Timestamp+uint32(height), height, *newTestTx(coinbase1, 30, []byte{0x51}))
+	block3, hash3 := mineRegtestBlock(t, hash2, genesis.Timestamp+uint32(height+1), height+1)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.UseMagic(constants.RegtestMagicValue)
+	node := newFakePeerNode(t, 20*time.Second)
+	require.NoError(t, node.SetNetworkParams(constants.RegtestParams))
+	sub := node.Events().Subscribe(10, events.TopicNewBlock)
+	defer sub.Unsubscribe()
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	expectNewBlock := func(hash message.Hash256) events.NewBlock {
+		select {
+		case event := <-sub.C:
+			newBlock := event.Data.(events.NewBlock)
+			require.Equal(t, hash.String(), newBlock.Hash)
+			return newBlock
+		case <-time.After(time.Second):
+			require.FailNow(t, "no new block event was published")
+			return events.NewBlock{}
+		}
+	}
+	for i := range blocks {
+		sendBlock(t, conn, &blocks[i])
+		newBlock := expectNewBlock(hashes[i])
+		require.NotNil(t, newBlock.Fees)
+		require.EqualValues(t, 0, *newBlock.Fees)
+	}
+
+	// the fees of an orphan block are not known, as its inputs could not be checked
+	sendBlock(t, conn, &block3)
+	newBlock := expectNewBlock(hash3)
+	require.Nil(t, newBlock.Fees)
+
+	sendBlock(t, conn, &block2)
+	newBlock = expectNewBlock(hash2)
+	require.NotNil(t, newBlock.Fees)
+	require.EqualValues(t, 20, *newBlock.Fees)
+	require.Eventually(t, func() bool {
+		tip, _ := node.chainstate.Load().Tip()
+		return tip == hash3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNode_BansFakePeerSendingInvalidBlock(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+
+	// changing the nonce of the genesis block breaks its proof of work
+	b := networkingtest.GenesisBlock(t)
+	invalidBlock, err := message.NewBlockMessage(b.Version, b.PrevBlock, b.MerkleRoot, b.Timestamp, b.Bits, b.Nonce+1, b.Transactions)
+	require.NoError(t, err)
+	conn.Send(invalidBlock)
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		require.FailNow(t, "peer sending an invalid block was not disconnected")
+	}
+	require.Eventually(t, func() bool { return node.banManager.IsBanned(fakePeer.Addr().IP) }, time.Second, 10*time.Millisecond)
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.Error(t, err)
+}
+
+func TestNode_DisconnectsFakePeerSendingMalformedMessage(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+
+	ping, err := message.NewPingMessage(1)
+	require.NoError(t, err)
+	encoded, err := ping.Encode()
+	require.NoError(t, err)
+	// corrupting the checksum
+	encoded[20] ^= 0xff
+	require.NoError(t, conn.WriteRaw(encoded))
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		require.FailNow(t, "peer sending a malformed message was not disconnected")
+	}
+	require.Eventually(t, func() bool { return node.peers.Len() == 0 }, time.Second, 10*time.Millisecond)
+	require.False(t, node.banManager.IsBanned(fakePeer.Addr().IP))
+}
+
+// redirectingDialer dials target whatever address it is asked for, like a proxy would
+type redirectingDialer struct {
+	target string
+	dialed []string
+}
+
+func (d *redirectingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dialed = append(d.dialed, address)
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, d.target)
+}
+
+func TestNode_DialsThroughCustomDialer(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	dialer := &redirectingDialer{target: fakePeer.Addr().String()}
+	node := newFakePeerNode(t, 20*time.Second)
+	node.SetDialer(dialer)
+
+	_, err := node.AddPeer(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 8333})
+	require.NoError(t, err)
+	fakePeer.Accept(time.Second)
+	require.Equal(t, []string{"198.51.100.1:8333"}, dialer.dialed)
+}
+
+func TestNode_StartReturnsOnceContextIsCancelled(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- node.Start(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Start did not return after its context was cancelled")
+	}
+	<-conn.Closed()
+	require.NoError(t, node.Stop(context.Background()))
+	require.ErrorIs(t, node.Stop(context.Background()), ErrNodeHasQuit)
+}
+
+func TestNode_Stop(t *testing.T) {
+	t.Run("the state should be saved once the select loop is done", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		node := newBlockStoreNode(t, fs)
+		_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+		require.NoError(t, err)
+		node.BanSubnet(subnet, time.Now().Add(time.Hour))
+
+		require.NoError(t, node.Stop(context.Background()))
+		_, err = storage.Size(node.fs, node.banListPath())
+		require.NoError(t, err)
+		require.ErrorIs(t, node.Start(context.Background()), ErrNodeHasQuit)
+		require.ErrorIs(t, node.Stop(context.Background()), ErrNodeHasQuit)
+	})
+
+	t.Run("nothing should be saved if the select loop is not done by the deadline", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		node := newBlockStoreNode(t, fs)
+		// a select loop still handling a message
+		node.running.Add(1)
+		defer node.running.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, node.Stop(ctx), context.DeadlineExceeded)
+		_, err := storage.Size(node.fs, node.banListPath())
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("the error that made the node stop by itself should be returned by Start", func(t *testing.T) {
+		node := newBlockStoreNode(t, storage.NewMemFS())
+		// so that the node does not stop for lack of peers instead
+		node.minimumPeers = 0
+		node.quit(ErrSnapshotBlocksInvalid)
+		require.ErrorIs(t, node.Start(context.Background()), ErrSnapshotBlocksInvalid)
+		require.NoError(t, node.Stop(context.Background()))
+	})
+}
+
+func TestNode_PeersWithoutRequiredServicesAreRejected(t *testing.T) {
+	// the fake peer only offers message.NodeNetwork
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	node.SetRequiredServices(message.NodeNetwork | message.NodeWitness)
+
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.ErrorIs(t, err, ErrMissingServices)
+	require.Zero(t, node.peers.Len())
+
+	// manual peers are exempt
+	node.AddManualPeer(fakePeer.Addr())
+	// the first connection is the rejected one
+	fakePeer.Accept(time.Second)
+	fakePeer.Accept(time.Second)
+	require.Eventually(t, func() bool { return node.peers.Len() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestNode_RelaysNewAddrsToOtherPeers(t *testing.T) {
+	sender, receiver := networkingtest.NewFakePeer(t), networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	node.addrRelayInterval = 20 * time.Millisecond
+	_, err := node.AddPeer(sender.Addr())
+	require.NoError(t, err)
+	senderConn := sender.Accept(time.Second)
+	receiverPeer, err := node.AddPeer(receiver.Addr())
+	require.NoError(t, err)
+	receiverConn := receiver.Accept(time.Second)
+	go node.Start(context.Background())
+
+	learnt := *message.NewAddress(uint32(time.Now().Unix()), *message.NewNetworkAddress(message.NodeNetwork, net.IPv4(10, 0, 0, 1), 8333))
+	addrMsg, err := message.NewAddrMessage([]message.Address{learnt})
+	require.NoError(t, err)
+	senderConn.Send(addrMsg)
+
+	// our external address may be advertised to the receiver first
+	for relayed := false; !relayed; {
+		msg := receiverConn.Expect(message.AddrCommand, time.Second)
+		for _, address := range msg.Payload.(*message.AddrPayload).AddressList {
+			relayed = relayed || tcpAddressOf(address) == tcpAddressOf(learnt)
+		}
+	}
+	_, ok := node.addrMan.Get(tcpAddressOf(learnt))
+	require.True(t, ok)
+	require.True(t, receiverPeer.knownAddrs.contains(tcpAddressOf(learnt)))
+}
+
+func TestNode_RequestsAddrsFromSeveralPeersAtOnce(t *testing.T) {
+	answering, silent := networkingtest.NewFakePeer(t), networkingtest.NewFakePeer(t)
+	learnt := *message.NewAddress(uint32(time.Now().Unix()), *message.NewNetworkAddress(message.NodeNetwork, net.IPv4(10, 0, 0, 1), 8333))
+	answering.ServeAddrs(learnt)
+	askedSilent := make(chan struct{}, 1)
+	silent.Handle(message.GetAddrCommand, func(c *networkingtest.Conn, msg *message.Message) { askedSilent <- struct{}{} })
+	node := newFakePeerNode(t, 20*time.Second)
+	for _, fakePeer := range []*networkingtest.FakePeer{answering, silent} {
+		_, err := node.AddPeer(fakePeer.Addr())
+		require.NoError(t, err)
+		fakePeer.Accept(time.Second)
+	}
+
+	start := time.Now()
+	node.requestAddrsFromPeers(2)
+
+	// the silent peer is waited for once, while the answering peer is asked at the same time
+	require.Less(t, time.Since(start), 2*node.getAddrWaitTime)
+	require.Len(t, askedSilent, 1)
+	_, ok := node.unconnectedAddrs.Get(tcpAddressOf(learnt))
+	require.True(t, ok)
+}
+
+// slowFailingDialer fails every dial after delay, recording the most dials it saw in flight at once and when each started
+type slowFailingDialer struct {
+	delay       time.Duration
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	started     []time.Time
+}
+
+func (d *slowFailingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.inFlight++
+	d.maxInFlight = max(d.maxInFlight, d.inFlight)
+	d.started = append(d.started, time.Now())
+	d.mu.Unlock()
+
+	time.Sleep(d.delay)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	return nil, errors.New("connection refused")
+}
+
+func TestNode_DialsWithBoundedConcurrencyAndPacing(t *testing.T) {
+	dialer := &slowFailingDialer{delay: 50 * time.Millisecond}
+	node := newFakePeerNode(t, 20*time.Second)
+	node.SetDialer(dialer)
+	node.tuning.DialWorkers = 3
+	node.tuning.DialInterval = 5 * time.Millisecond
+	for i := range 20 {
+		node.addUnconnectedAddrToNode(TCPAddress{IpAddress: [16]byte(net.IPv4(198, 51, 100, byte(i+1)).To16()), Port: 8333})
+	}
+
+	require.Zero(t, node.attemptAddingSomePeers(10))
+
+	require.Len(t, dialer.started, 10)
+	require.Equal(t, 3, dialer.maxInFlight)
+	for i := 1; i < len(dialer.started); i++ {
+		require.GreaterOrEqual(t, dialer.started[i].Sub(dialer.started[i-1]), node.tuning.DialInterval)
+	}
+	require.Equal(t, 10, node.unconnectedAddrs.Len())
+}
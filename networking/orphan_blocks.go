@@ -0,0 +1,26 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"time"
+)
+
+// requestOrphanAncestors asks peer, which sent the orphan block with orphanHash, for missingAncestor, the first of the orphan's ancestors we do
+// not have, unless it is already in flight, and for the headers following our best header, which lead to the other ancestors being downloaded
+// in order. The orphan is connected as soon as its ancestors are.
+func (n *Node) requestOrphanAncestors(peer *Peer, orphanHash message.Hash256, missingAncestor message.Hash256) error {
+	if n.blockInFlight(missingAncestor) {
+		log.Printf("Block %s is an orphan (%d orphans): its missing ancestor %s is already requested", orphanHash.String(),
+			n.blockIndex.OrphanCount(), missingAncestor.String())
+		return n.requestNewBlocksFrom(peer)
+	}
+	log.Printf("Block %s is an orphan (%d orphans): requesting its missing ancestor %s from peer %s", orphanHash.String(),
+		n.blockIndex.OrphanCount(), missingAncestor.String(), peer.conn.RemoteAddr())
+	n.blocksInFlight.Set(missingAncestor, blockRequest{peer: peer, requestedAt: time.Now()})
+	err := n.sendGetBlockDataMsg(peer, []message.Hash256{missingAncestor})
+	if err != nil {
+		return err
+	}
+	return n.requestNewBlocksFrom(peer)
+}
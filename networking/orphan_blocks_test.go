@@ -0,0 +1,80 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// sendBlock sends block to the node through conn, as the peer would relay it
+func sendBlock(t *testing.T, conn *networkingtest.Conn, block *message.BlockPayload) {
+	msg, err := message.NewBlockMessage(block.Version, block.PrevBlock, block.MerkleRoot, block.Timestamp, block.Bits, block.Nonce, block.Transactions)
+	require.NoError(t, err)
+	conn.Send(msg)
+}
+
+func TestNode_RequestsAncestorsOfOrphanBlockFromSender(t *testing.T) {
+	genesis, genesisHash, err := parseGenesisBlock(constants.RegtestParams.GenesisBlock)
+	require.NoError(t, err)
+	// regtest blocks, which the peer accepts without skipping their proof of work
+	blocks, hashes := mineRegtestBlocks(t, genesis, genesisHash, 3)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	fakePeer.UseMagic(constants.RegtestMagicValue)
+	node := newFakePeerNode(t, 20*time.Second)
+	require.NoError(t, node.SetNetworkParams(constants.RegtestParams))
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+	go node.Start(context.Background())
+
+	// the blocks go through the peer, so that the node handles them once started, one at a time
+	sendBlock(t, conn, &blocks[2])
+	getData := conn.Expect(message.GetDataCommand, time.Second).Payload.(*message.GetDataPayload)
+	require.Equal(t, networkingtest.BlockInventory(t, &blocks[1]), getData.InventoryList)
+	conn.Expect(message.GetHeadersCommand, time.Second)
+	require.Equal(t, 1, node.blockIndex.OrphanCount())
+
+	sendBlock(t, conn, &blocks[1])
+	getData = conn.Expect(message.GetDataCommand, time.Second).Payload.(*message.GetDataPayload)
+	require.Equal(t, networkingtest.BlockInventory(t, &blocks[0]), getData.InventoryList)
+
+	// the parent connects the orphans waiting for it in order
+	sendBlock(t, conn, &blocks[0])
+	require.Eventually(t, func() bool {
+		return node.blockIndex.OrphanCount() == 0 && node.blockIndex.Tip().Hash == hashes[2]
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNode_DoesNotRequestAncestorOfOrphanBlockTwice(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	peers := make([]*Peer, 2)
+	conns := make([]*networkingtest.Conn, 2)
+	for i := range peers {
+		fakePeer := networkingtest.NewFakePeer(t)
+		var err error
+		peers[i], err = node.AddPeer(fakePeer.Addr())
+		require.NoError(t, err)
+		conns[i] = fakePeer.Accept(time.Second)
+	}
+
+	blocks, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 2, easyBits, 0)
+	require.NoError(t, node.handleBlockMsg(&blockPayloadWithSender{BlockPayload: &blocks[1], Sender: peers[0], ReceivedAt: time.Now()}))
+	getData := conns[0].Expect(message.GetDataCommand, time.Second).Payload.(*message.GetDataPayload)
+	require.Equal(t, networkingtest.BlockInventory(t, &blocks[0]), getData.InventoryList)
+
+	// the second peer relaying the orphan is only asked for headers, and announcing the missing ancestor does not lead to another request
+	require.NoError(t, node.handleBlockMsg(&blockPayloadWithSender{BlockPayload: &blocks[1], Sender: peers[1], ReceivedAt: time.Now()}))
+	conns[1].Expect(message.GetHeadersCommand, time.Second)
+	require.NoError(t, node.handleInvMsg(&invPayloadWithSender{
+		InvPayload: &message.InvPayload{InventoryList: []message.Inventory{{Type: message.MsgBlock, Hash: hashes[0]}}},
+		Sender:     peers[1],
+	}))
+	request, ok := node.blocksInFlight.Get(hashes[0])
+	require.True(t, ok)
+	require.Equal(t, peers[0], request.peer)
+}
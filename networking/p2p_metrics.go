@@ -0,0 +1,236 @@
+package networking
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Direction of a connection, i.e. who initiated it
+type Direction string
+
+const (
+	Inbound  Direction = "inbound"
+	Outbound Direction = "outbound"
+)
+
+// Network a peer is reached through
+type Network string
+
+const (
+	NetworkIPv4  Network = "ipv4"
+	NetworkIPv6  Network = "ipv6"
+	NetworkOnion Network = "onion"
+)
+
+// ConnectionType describes what a connection is used for (https://github.com/bitcoin/bitcoin/blob/v27.0/src/node/connection_types.h)
+type ConnectionType string
+
+const (
+	// Relays blocks, transactions and addresses
+	FullRelay ConnectionType = "full"
+	// Only relays blocks
+	BlockRelay ConnectionType = "block-relay"
+	// Short-lived connection to test whether an address is reachable
+	Feeler ConnectionType = "feeler"
+)
+
+// Tor v2 addresses are mapped into IPv6 using the OnionCat prefix fd87:d87e:eb43::/48 (https://github.com/bitcoin/bitcoin/blob/v0.20.0/src/netaddress.cpp#L15)
+var onionCatPrefix = []byte{0xFD, 0x87, 0xD8, 0x7E, 0xEB, 0x43}
+
+func networkOf(ip net.IP) Network {
+	if ip.To4() != nil {
+		return NetworkIPv4
+	}
+	if ip16 := ip.To16(); ip16 != nil && slices.Equal(ip16[:len(onionCatPrefix)], onionCatPrefix) {
+		return NetworkOnion
+	}
+	return NetworkIPv6
+}
+
+// ConnectionLabels identify the kind of connection a metric was recorded on
+type ConnectionLabels struct {
+	Direction      Direction
+	Network        Network
+	ConnectionType ConnectionType
+}
+
+// TrafficLabels identify the kind of connection and the command of the messages a traffic metric was recorded for
+type TrafficLabels struct {
+	ConnectionLabels
+	Command string
+}
+
+type TrafficCounters struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+}
+
+type LatencySummary struct {
+	Count uint64
+	Sum   time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+// P2PMetrics is a snapshot of the node's peer-to-peer metrics
+type P2PMetrics struct {
+	// Number of currently connected peers
+	Connections map[ConnectionLabels]int
+	Traffic     map[TrafficLabels]TrafficCounters
+	// Round-trip times of answered pings
+	PingLatency map[ConnectionLabels]LatencySummary
+	// Connections opened and closed, including the ones before the node was restarted
+	Churn PeerChurn
+}
+
+type p2pMetricsCollector struct {
+	mu          sync.Mutex
+	traffic     map[TrafficLabels]TrafficCounters
+	pingLatency map[ConnectionLabels]LatencySummary
+}
+
+func newP2PMetricsCollector() *p2pMetricsCollector {
+	return &p2pMetricsCollector{
+		traffic:     make(map[TrafficLabels]TrafficCounters),
+		pingLatency: make(map[ConnectionLabels]LatencySummary),
+	}
+}
+
+func (c *p2pMetricsCollector) addSent(labels ConnectionLabels, command string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := TrafficLabels{ConnectionLabels: labels, Command: command}
+	counters := c.traffic[key]
+	counters.MessagesSent++
+	counters.BytesSent += uint64(n)
+	c.traffic[key] = counters
+}
+
+func (c *p2pMetricsCollector) addReceived(labels ConnectionLabels, command string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := TrafficLabels{ConnectionLabels: labels, Command: command}
+	counters := c.traffic[key]
+	counters.MessagesReceived++
+	counters.BytesReceived += uint64(n)
+	c.traffic[key] = counters
+}
+
+func (c *p2pMetricsCollector) observePingLatency(labels ConnectionLabels, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary := c.pingLatency[labels]
+	if summary.Count == 0 || latency < summary.Min {
+		summary.Min = latency
+	}
+	summary.Max = max(summary.Max, latency)
+	summary.Count++
+	summary.Sum += latency
+	c.pingLatency[labels] = summary
+}
+
+func (c *p2pMetricsCollector) snapshot(peers []*Peer) P2PMetrics {
+	connections := make(map[ConnectionLabels]int)
+	for _, peer := range peers {
+		connections[peer.connectionLabels()]++
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return P2PMetrics{
+		Connections: connections,
+		Traffic:     maps.Clone(c.traffic),
+		PingLatency: maps.Clone(c.pingLatency),
+	}
+}
+
+func (l ConnectionLabels) prometheusLabels() string {
+	return fmt.Sprintf(`direction="%s",network="%s",conn_type="%s"`, l.Direction, l.Network, l.ConnectionType)
+}
+
+func (l TrafficLabels) prometheusLabels(flow string) string {
+	return fmt.Sprintf(`%s,command="%s",flow="%s"`, l.ConnectionLabels.prometheusLabels(), l.Command, flow)
+}
+
+func compareConnectionLabels(a, b ConnectionLabels) int {
+	return cmp.Or(cmp.Compare(a.Direction, b.Direction), cmp.Compare(a.Network, b.Network), cmp.Compare(a.ConnectionType, b.ConnectionType))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m P2PMetrics) WritePrometheus(w io.Writer) error {
+	var err error
+	printf := func(format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	connectionLabels := slices.SortedFunc(maps.Keys(m.Connections), compareConnectionLabels)
+	printf("# HELP bitcoin_node_p2p_connections Number of connected peers\n")
+	printf("# TYPE bitcoin_node_p2p_connections gauge\n")
+	for _, labels := range connectionLabels {
+		printf("bitcoin_node_p2p_connections{%s} %d\n", labels.prometheusLabels(), m.Connections[labels])
+	}
+
+	trafficLabels := slices.SortedFunc(maps.Keys(m.Traffic), func(a, b TrafficLabels) int {
+		return cmp.Or(compareConnectionLabels(a.ConnectionLabels, b.ConnectionLabels), cmp.Compare(a.Command, b.Command))
+	})
+	printf("# HELP bitcoin_node_p2p_messages_total Number of messages sent and received\n")
+	printf("# TYPE bitcoin_node_p2p_messages_total counter\n")
+	for _, labels := range trafficLabels {
+		printf("bitcoin_node_p2p_messages_total{%s} %d\n", labels.prometheusLabels("sent"), m.Traffic[labels].MessagesSent)
+		printf("bitcoin_node_p2p_messages_total{%s} %d\n", labels.prometheusLabels("received"), m.Traffic[labels].MessagesReceived)
+	}
+	printf("# HELP bitcoin_node_p2p_bytes_total Number of bytes sent and received\n")
+	printf("# TYPE bitcoin_node_p2p_bytes_total counter\n")
+	for _, labels := range trafficLabels {
+		printf("bitcoin_node_p2p_bytes_total{%s} %d\n", labels.prometheusLabels("sent"), m.Traffic[labels].BytesSent)
+		printf("bitcoin_node_p2p_bytes_total{%s} %d\n", labels.prometheusLabels("received"), m.Traffic[labels].BytesReceived)
+	}
+
+	latencyLabels := slices.SortedFunc(maps.Keys(m.PingLatency), compareConnectionLabels)
+	printf("# HELP bitcoin_node_p2p_ping_seconds Round-trip time of answered pings\n")
+	printf("# TYPE bitcoin_node_p2p_ping_seconds summary\n")
+	for _, labels := range latencyLabels {
+		summary := m.PingLatency[labels]
+		printf("bitcoin_node_p2p_ping_seconds_sum{%s} %g\n", labels.prometheusLabels(), summary.Sum.Seconds())
+		printf("bitcoin_node_p2p_ping_seconds_count{%s} %d\n", labels.prometheusLabels(), summary.Count)
+	}
+
+	printf("# HELP bitcoin_node_p2p_connections_opened_total Number of connections opened, including before restarts\n")
+	printf("# TYPE bitcoin_node_p2p_connections_opened_total counter\n")
+	for _, direction := range slices.Sorted(maps.Keys(m.Churn.Opened)) {
+		printf("bitcoin_node_p2p_connections_opened_total{direction=\"%s\"} %d\n", direction, m.Churn.Opened[direction])
+	}
+	printf("# HELP bitcoin_node_p2p_connections_closed_total Number of connections closed, including before restarts\n")
+	printf("# TYPE bitcoin_node_p2p_connections_closed_total counter\n")
+	for _, direction := range slices.Sorted(maps.Keys(m.Churn.Closed)) {
+		printf("bitcoin_node_p2p_connections_closed_total{direction=\"%s\"} %d\n", direction, m.Churn.Closed[direction])
+	}
+	printf("# HELP bitcoin_node_p2p_connection_duration_seconds Duration of closed connections, including before restarts\n")
+	printf("# TYPE bitcoin_node_p2p_connection_duration_seconds histogram\n")
+	for _, direction := range slices.Sorted(maps.Keys(m.Churn.Durations)) {
+		durations := m.Churn.Durations[direction]
+		cumulative := uint64(0)
+		for i, count := range durations.Counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(ConnectionDurationBuckets) {
+				le = fmt.Sprintf("%g", ConnectionDurationBuckets[i])
+			}
+			printf("bitcoin_node_p2p_connection_duration_seconds_bucket{direction=\"%s\",le=\"%s\"} %d\n", direction, le, cumulative)
+		}
+		printf("bitcoin_node_p2p_connection_duration_seconds_sum{direction=\"%s\"} %g\n", direction, durations.Sum)
+		printf("bitcoin_node_p2p_connection_duration_seconds_count{direction=\"%s\"} %d\n", direction, durations.Count)
+	}
+
+	return err
+}
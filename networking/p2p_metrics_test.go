@@ -0,0 +1,37 @@
+package networking
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetworkOf(t *testing.T) {
+	require.Equal(t, NetworkIPv4, networkOf(net.ParseIP("10.0.0.1")))
+	require.Equal(t, NetworkIPv4, networkOf(net.ParseIP("::ffff:10.0.0.1")))
+	require.Equal(t, NetworkIPv6, networkOf(net.ParseIP("2001:db8::1")))
+	require.Equal(t, NetworkOnion, networkOf(net.ParseIP("fd87:d87e:eb43:1234::1")))
+}
+
+func TestP2PMetrics_WritePrometheus(t *testing.T) {
+	labels := ConnectionLabels{Direction: Outbound, Network: NetworkIPv4, ConnectionType: FullRelay}
+	metrics := P2PMetrics{
+		Connections: map[ConnectionLabels]int{labels: 2},
+		Traffic: map[TrafficLabels]TrafficCounters{
+			{ConnectionLabels: labels, Command: "ping"}: {MessagesSent: 1, MessagesReceived: 2, BytesSent: 32, BytesReceived: 64},
+		},
+		PingLatency: map[ConnectionLabels]LatencySummary{labels: {Count: 2, Sum: 3 * time.Second}},
+	}
+
+	var buffer bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buffer))
+
+	output := buffer.String()
+	require.Contains(t, output, `bitcoin_node_p2p_connections{direction="outbound",network="ipv4",conn_type="full"} 2`)
+	require.Contains(t, output, `bitcoin_node_p2p_messages_total{direction="outbound",network="ipv4",conn_type="full",command="ping",flow="received"} 2`)
+	require.Contains(t, output, `bitcoin_node_p2p_bytes_total{direction="outbound",network="ipv4",conn_type="full",command="ping",flow="sent"} 32`)
+	require.Contains(t, output, `bitcoin_node_p2p_ping_seconds_sum{direction="outbound",network="ipv4",conn_type="full"} 3`)
+	require.Contains(t, output, `bitcoin_node_p2p_ping_seconds_count{direction="outbound",network="ipv4",conn_type="full"} 2`)
+}
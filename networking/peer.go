@@ -1,15 +1,45 @@
 package networking
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/internal/ratelimit"
 	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/tracing"
+	"io"
 	"log"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var ErrInvalidPayload = errors.New("invalid payload")
+var (
+	ErrInvalidPayload = errors.New("invalid payload")
+	ErrPingTimeout    = errors.New("peer did not answer ping in time")
+	ErrWriteQueueFull = errors.New("peer's write queue is full")
+	ErrPeerHasQuit    = errors.New("peer has quit")
+	ErrRateLimited    = errors.New("message exceeded its rate limit")
+)
+
+// Size of the buffer that queued messages are coalesced into before being written to the connection
+const writeBufferSize = 64 * 1024
+
+// WriteQueuePolicy decides what happens to a message that cannot be queued because a slow peer's write queue stayed full for the write queue timeout
+type WriteQueuePolicy int
+
+const (
+	// Disconnect the peer
+	DisconnectOnFullQueue WriteQueuePolicy = iota
+	// Drop the message and keep the peer
+	DropOnFullQueue
+)
 
 type TCPAddress struct {
 	IpAddress [16]byte
@@ -20,21 +50,193 @@ func (t TCPAddress) String() string {
 	return fmt.Sprintf("%s:%d", net.IP(t.IpAddress[:]), t.Port)
 }
 
+// PeerStats is a snapshot of the statistics of a connection with a peer
+type PeerStats struct {
+	// Round-trip time of the last answered ping
+	PingLatency time.Duration
+	// Lowest round-trip time seen for any ping
+	MinPingLatency time.Duration
+	// How long the outstanding ping has been waiting for a pong (zero if no ping is outstanding)
+	PingWait time.Duration
+	// Total bytes sent to the peer
+	BytesSent uint64
+	// Total bytes received from the peer
+	BytesReceived uint64
+	// Bytes sent to the peer per command
+	BytesSentPerCommand map[string]uint64
+	// Bytes received from the peer per command
+	BytesReceivedPerCommand map[string]uint64
+	// Number of messages waiting to be written to the peer
+	WriteQueueDepth int
+	// Maximum number of messages that can wait to be written to the peer
+	WriteQueueCapacity int
+	// Number of messages dropped because the write queue was full
+	DroppedWrites uint64
+	// Number of times buffered messages were flushed to the connection
+	WriteFlushes uint64
+	// Number of blocks requested from the peer in getdata messages
+	BlocksRequested uint64
+	// Number of blocks received from the peer
+	BlocksDelivered uint64
+	// Number of times blocks requested from the peer did not arrive in time
+	BlockTimeouts uint64
+	// Number of times the peer was replaced as sync peer because its block throughput collapsed
+	BlockStalls uint64
+}
+
+// PeerInfo is a snapshot of what is known about a connected peer
+type PeerInfo struct {
+	// Identifies the peer among the peers connected since the node was created
+	Id              int64            `json:"id"`
+	Addr            string           `json:"addr"`
+	Direction       Direction        `json:"direction"`
+	Network         Network          `json:"network"`
+	ConnectionType  ConnectionType   `json:"connectionType"`
+	Services        message.Services `json:"services"`
+	UserAgent       string           `json:"userAgent"`
+	ProtocolVersion int32            `json:"protocolVersion"`
+	// Height of the peer's best block when the connection was made
+	StartingHeight int32     `json:"startingHeight"`
+	ConnectedAt    time.Time `json:"connectedAt"`
+	// When a message was last sent to and received from the peer (zero if none was)
+	LastSend    time.Time     `json:"lastSend"`
+	LastRecv    time.Time     `json:"lastRecv"`
+	PingLatency time.Duration `json:"pingLatency"`
+	BytesSent   uint64        `json:"bytesSent"`
+	BytesRecv   uint64        `json:"bytesRecv"`
+	Manual      bool          `json:"manual"`
+	// Features negotiated with the peer
+	Relay       bool `json:"relay"`
+	WtxidRelay  bool `json:"wtxidRelay"`
+	SendAddrV2  bool `json:"sendAddrV2"`
+	SendHeaders bool `json:"sendHeaders"`
+	// Transactions are relayed by set reconciliation (BIP 330)
+	TxReconciliation bool `json:"txReconciliation"`
+}
+
+// PeerCapabilities is what a peer announced in its version message, together with the features negotiated with it
+type PeerCapabilities struct {
+	ProtocolVersion int32
+	Services        message.Services
+	UserAgent       string
+	// Height of the peer's best block when the connection was made
+	StartHeight int32
+	// the peer wants transactions to be announced to it (BIP 37)
+	Relay bool
+	// the peer announces transactions by wtxid (BIP 339)
+	WtxidRelay bool
+	// the peer asked for addresses to be sent in addrv2 messages (BIP 155)
+	SendAddrV2 bool
+	// the peer asked for new blocks to be announced with headers messages (BIP 130)
+	SendHeaders bool
+	// transactions are relayed to and from the peer by set reconciliation (BIP 330)
+	TxReconciliation bool
+}
+
+// HasServices reports whether the peer offers all of services
+func (c PeerCapabilities) HasServices(services message.Services) bool {
+	return c.Services.Has(services)
+}
+
 type Peer struct {
-	mu                   sync.Mutex
-	conn                 *net.TCPConn
-	tcpAddress           TCPAddress
+	mu          sync.Mutex
+	conn        Conn
+	tcpAddress  TCPAddress
+	id          int64
+	remoteNonce uint64
+	// magic value of the network the peer is on, starting every message sent and received
+	magic uint32
+	// easiest target in compact form the blocks of the network may have
+	powLimitBits         uint32
 	HasQuit              bool
 	onQuitting           func(*Peer)
 	QuitCh               chan struct{}
-	msgCh                chan *message.Message
+	msgCh                chan receivedMessage
 	writeCh              chan []byte
 	getAddrMsgResponseCh chan []message.Address
-	invMsgCh             chan<- *InvPayloadWithSender
-	blockMsgCh           chan<- *BlockPayloadWithSender
+	invMsgCh             chan<- *invPayloadWithSender
+	blockMsgCh           chan<- *blockPayloadWithSender
+	// unsolicited addr messages, getblocks messages, transactions and headers are passed to addrMsgCh, getBlocksMsgCh, txMsgCh and headersMsgCh,
+	// if set
+	addrMsgCh      chan<- *addrPayloadWithSender
+	getBlocksMsgCh chan<- *getBlocksPayloadWithSender
+	txMsgCh        chan<- *txPayloadWithSender
+	headersMsgCh   chan<- *headersPayloadWithSender
+	// compact filter requests are passed to compactFilterMsgCh, if set
+	compactFilterMsgCh chan<- *compactFilterRequestWithSender
+	// mempool messages are answered from mempool, if it is set
+	mempool *Mempool
+	// fee rate (in satoshis per 1000 bytes) below which transactions are not announced to the peer (BIP 133)
+	feeFilter atomic.Int64
+	// fee rate of the last feefilter message sent to the peer (0 if none was)
+	sentFeeFilter atomic.Int64
+	// if set, only the transactions matching bloom are announced to the peer (BIP 37)
+	bloom atomic.Pointer[bloomFilter]
+	// inbound peers asking for addresses are answered from addrMan, if it is set, once per connection
+	addrMan         *AddrMan
+	getAddrAnswered bool
+	// limits the rate of the unsolicited addresses processed, only accessed by msgChLoop()
+	addrTokens *ratelimit.TokenBucket
+	// addresses the peer sent us or was sent, which are not relayed to it
+	knownAddrs *knownAddrs
+	// transactions waiting to be announced to the peer by txAnnounceLoop()
+	txRelayMu     sync.Mutex
+	txsToAnnounce []*MempoolEntry
+	// transactions the peer sent us or was announced, which are not announced to it
+	knownTxs *knownTxs
+	// set if transactions are relayed to the peer by set reconciliation (BIP 330)
+	txReconciliation *txReconciliation
+	// average time between two announcements of transactions, which depends on the direction of the connection if it is 0
+	txAnnounceMeanInterval time.Duration
+	pingInterval           time.Duration
+	pingTimeout            time.Duration
+	statsMu                sync.RWMutex
+	pingNonce              uint64
+	pingSentAt             time.Time
+	pingLatency            time.Duration
+	minPingLatency         time.Duration
+	// headers of the peer's chain being presynced because it has less than the minimum chain work, only accessed by the node's select loop
+	headersPresync *headersPresync
+	// token buckets per command, only accessed by readLoop()
+	rateLimiters     map[message.CommandName]*ratelimit.TokenBucket
+	misbehaviorScore atomic.Int32
+	bandwidth        *bandwidthCounter
+	// node-wide counter that is also updated, if set
+	netTotals      *bandwidthCounter
+	metrics        *p2pMetricsCollector
+	direction      Direction
+	network        Network
+	connectionType ConnectionType
+	connectedAt    time.Time
+	// version message received in the handshake and the features negotiated with the peer
+	version     *message.VersionPayload
+	wtxidRelay  bool
+	sendAddrV2  bool
+	sendHeaders atomic.Bool
+	// unix nanoseconds of the last flush to and the last message read from the connection
+	lastSend atomic.Int64
+	lastRecv atomic.Int64
+	// manual peers are kept connected by the node
+	manual bool
+	// misbehavior neither quits nor bans the peer
+	noBan bool
+	// if set, every message sent and received is passed to tracer
+	tracer            MessageTracer
+	tracePayloads     bool
+	writeQueueTimeout time.Duration
+	writeQueuePolicy  WriteQueuePolicy
+	droppedWrites     atomic.Uint64
+	// buffers the connection for writeLoop(), which is its only user
+	writer       *bufio.Writer
+	writeFlushes atomic.Uint64
+	// blocks requested from and received from the peer, which peer selectors favour peers by
+	blocksRequested atomic.Uint64
+	blocksDelivered atomic.Uint64
+	blockTimeouts   atomic.Uint64
+	blockStalls     atomic.Uint64
 }
 
-func NewPeer(conn *net.TCPConn, onQuitting func(*Peer), invMsgCh chan<- *InvPayloadWithSender, blockMsgCh chan<- *BlockPayloadWithSender) (*Peer, error) {
+func newPeer(conn Conn, onQuitting func(*Peer), invMsgCh chan<- *invPayloadWithSender, blockMsgCh chan<- *blockPayloadWithSender) (*Peer, error) {
 	addr, err := getRemoteAddr(conn)
 	if err != nil {
 		return nil, err
@@ -42,29 +244,135 @@ func NewPeer(conn *net.TCPConn, onQuitting func(*Peer), invMsgCh chan<- *InvPayl
 	tcpAddress := TCPAddress{IpAddress: [16]byte(addr.IP.To16()), Port: uint16(addr.Port)}
 
 	return &Peer{
-		conn:       conn,
-		tcpAddress: tcpAddress,
-		HasQuit:    false,
-		onQuitting: onQuitting,
-		QuitCh:     make(chan struct{}),
-		// TODO - Decide on the channel buffer length
-		msgCh: make(chan *message.Message, 100),
+		conn:        conn,
+		tcpAddress:  tcpAddress,
+		connectedAt: time.Now(),
+		HasQuit:     false,
+		onQuitting:  onQuitting,
+		QuitCh:      make(chan struct{}),
 		// TODO - Decide on the channel buffer length
-		writeCh:              make(chan []byte, 100),
+		msgCh:                make(chan receivedMessage, 100),
+		writeCh:              make(chan []byte, constants.WriteQueueSize),
+		writeQueueTimeout:    constants.WriteQueueTimeout,
+		writeQueuePolicy:     DisconnectOnFullQueue,
+		writer:               bufio.NewWriterSize(conn, writeBufferSize),
 		getAddrMsgResponseCh: nil,
 		invMsgCh:             invMsgCh,
 		blockMsgCh:           blockMsgCh,
+		knownAddrs:           newKnownAddrs(constants.MaxKnownAddrsPerPeer),
+		knownTxs:             newKnownTxs(constants.MaxKnownTxsPerPeer),
+		addrTokens:           newTokenBucket(RateLimit{Rate: constants.AddrRatePerSecond, Burst: constants.MaxAddrRelayQueue}, time.Now()),
+		pingInterval:         constants.PingInterval,
+		pingTimeout:          constants.PingTimeout,
+		rateLimiters:         newTokenBuckets(DefaultRateLimits, time.Now()),
+		bandwidth:            newBandwidthCounter(),
+		direction:            Outbound,
+		network:              networkOf(addr.IP),
+		connectionType:       FullRelay,
+		magic:                constants.MainnetMagicValue,
+		powLimitBits:         constants.PowLimitBits,
 	}, nil
 }
 
-func (p *Peer) Start() {
+// Start runs the peer until it quits, which it also does once ctx is cancelled
+func (p *Peer) Start(ctx context.Context) {
 	log.Printf("Starting Peer %s", p.conn.RemoteAddr())
+	stop := context.AfterFunc(ctx, p.Quit)
+	defer stop()
 
 	go p.readLoop()
 	go p.msgChLoop()
+	go p.pingLoop()
+	go p.txAnnounceLoop()
+	if p.txReconciliation != nil && p.txReconciliation.initiator {
+		go p.reconciliationLoop()
+	}
 	p.writeLoop()
 }
 
+// Capabilities returns what the peer announced in the handshake and the features negotiated with it
+func (p *Peer) Capabilities() PeerCapabilities {
+	c := PeerCapabilities{
+		WtxidRelay:  p.wtxidRelay,
+		SendAddrV2:  p.sendAddrV2,
+		SendHeaders: p.sendHeaders.Load(),
+		// transaction reconciliation is only used with full-relay peers
+		TxReconciliation: p.txReconciliation != nil,
+	}
+	if p.version != nil {
+		c.ProtocolVersion = p.version.Version
+		c.Services = p.version.Services
+		c.UserAgent = p.version.UserAgent
+		c.StartHeight = p.version.StartHeight
+		c.Relay = p.version.Relay
+	}
+	return c
+}
+
+// Info returns a snapshot of what is known about the peer
+func (p *Peer) Info() PeerInfo {
+	stats := p.Stats()
+	capabilities := p.Capabilities()
+	return PeerInfo{
+		Id:               p.id,
+		Addr:             p.conn.RemoteAddr().String(),
+		Direction:        p.direction,
+		Network:          p.network,
+		ConnectionType:   p.connectionType,
+		ConnectedAt:      p.connectedAt,
+		LastSend:         unixNanoTime(p.lastSend.Load()),
+		LastRecv:         unixNanoTime(p.lastRecv.Load()),
+		PingLatency:      stats.PingLatency,
+		BytesSent:        stats.BytesSent,
+		BytesRecv:        stats.BytesReceived,
+		Manual:           p.manual,
+		Services:         capabilities.Services,
+		UserAgent:        capabilities.UserAgent,
+		ProtocolVersion:  capabilities.ProtocolVersion,
+		StartingHeight:   capabilities.StartHeight,
+		Relay:            capabilities.Relay,
+		WtxidRelay:       capabilities.WtxidRelay,
+		SendAddrV2:       capabilities.SendAddrV2,
+		SendHeaders:      capabilities.SendHeaders,
+		TxReconciliation: capabilities.TxReconciliation,
+	}
+}
+
+// unixNanoTime converts unix nanoseconds to a time, mapping 0 to the zero time
+func unixNanoTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (p *Peer) Stats() PeerStats {
+	p.statsMu.RLock()
+	defer p.statsMu.RUnlock()
+
+	bandwidth := p.bandwidth.snapshot()
+	stats := PeerStats{
+		PingLatency:             p.pingLatency,
+		MinPingLatency:          p.minPingLatency,
+		BytesSent:               bandwidth.BytesSent,
+		BytesReceived:           bandwidth.BytesReceived,
+		BytesSentPerCommand:     bandwidth.BytesSentPerCommand,
+		BytesReceivedPerCommand: bandwidth.BytesReceivedPerCommand,
+		WriteQueueDepth:         len(p.writeCh),
+		WriteQueueCapacity:      cap(p.writeCh),
+		DroppedWrites:           p.droppedWrites.Load(),
+		WriteFlushes:            p.writeFlushes.Load(),
+		BlocksRequested:         p.blocksRequested.Load(),
+		BlocksDelivered:         p.blocksDelivered.Load(),
+		BlockTimeouts:           p.blockTimeouts.Load(),
+		BlockStalls:             p.blockStalls.Load(),
+	}
+	if p.pingNonce != 0 {
+		stats.PingWait = time.Since(p.pingSentAt)
+	}
+	return stats
+}
+
 func (p *Peer) Quit() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -85,12 +393,42 @@ func (p *Peer) Quit() {
 	close(p.QuitCh)
 }
 
+// Misbehaving increases the peer's misbehavior score, and quits the peer once the score reaches constants.BanScoreThreshold
+// (peers exempt from banning are never quit for misbehaving).
+func (p *Peer) Misbehaving(score int32, reason string) {
+	total := p.misbehaviorScore.Add(score)
+	log.Printf("⚠️ Peer %s misbehaved: %s (score: %d)", p.conn.RemoteAddr(), reason, total)
+	if p.noBan {
+		return
+	}
+	if total >= constants.BanScoreThreshold && total-score < constants.BanScoreThreshold {
+		log.Printf("Quitting peer %s as its misbehavior score reached %d", p.conn.RemoteAddr(), total)
+		p.Quit()
+	}
+}
+
+// ShouldBan reports whether the peer misbehaved enough to be banned
+func (p *Peer) ShouldBan() bool {
+	return !p.noBan && p.misbehaviorScore.Load() >= constants.BanScoreThreshold
+}
+
 func (p *Peer) readLoop() {
+	// keeps the bytes of the message being read if payloads are traced
+	var traced bytes.Buffer
+	arrival := &arrivalReader{r: p.conn}
+	var r io.Reader = arrival
+	if p.tracer != nil && p.tracePayloads {
+		r = io.TeeReader(arrival, &traced)
+	}
 	for {
-		msg, err := message.DecodeMessage(p.conn)
+		traced.Reset()
+		arrival.reset()
+		msg, err := message.DecodeMessage(r)
 		if err != nil {
 			commandNameErr := &message.ErrUnknownCommandName{}
 			if errors.As(err, &commandNameErr) {
+				p.recordReceived(otherCommand, message.HeaderLength+int(commandNameErr.Length))
+				p.traceMessage(MessageReceived, commandNameErr.Command.String(), message.HeaderLength+int(commandNameErr.Length), traced.Bytes())
 				//log.Printf("[readLoop] Unknown Command Name: %s. Skipping...", commandNameErr.Command)
 				continue
 			} else {
@@ -99,8 +437,34 @@ func (p *Peer) readLoop() {
 				return
 			}
 		}
+		if msg.Header.Magic != p.magic {
+			log.Printf("[readLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), invalidMagic(msg))
+			p.Quit()
+			return
+		}
 		log.Printf("[readLoop] Read \"%s\" message from peer %s", msg.Header.Command, p.conn.RemoteAddr())
-		p.msgCh <- msg
+		p.recordReceived(msg.Header.Command.String(), message.HeaderLength+int(msg.Header.Length))
+		p.traceMessage(MessageReceived, msg.Header.Command.String(), message.HeaderLength+int(msg.Header.Length), traced.Bytes())
+		span := tracing.StartAt(tracing.SpanContext{}, "message.receive", arrival.first, p.spanAttribute(),
+			tracing.String("command", msg.Header.Command.String()), tracing.Int("size", message.HeaderLength+int(msg.Header.Length)))
+		if rateLimiter, ok := p.rateLimiters[msg.Header.Command]; ok && !rateLimiter.Allow(time.Now()) {
+			p.Misbehaving(1, fmt.Sprintf("\"%s\" messages exceeded their rate limit", msg.Header.Command))
+			span.SetError(ErrRateLimited)
+			span.End()
+			continue
+		}
+		// obviously invalid blocks are dropped here rather than handed to the node
+		if block, ok := msg.Payload.(*message.BlockPayload); ok {
+			err = preVerifyBlock(block, p.powLimitBits)
+			if err != nil {
+				p.Misbehaving(constants.BanScoreThreshold, fmt.Sprintf("sent an invalid block: %s", err))
+				span.SetError(err)
+				span.End()
+				continue
+			}
+		}
+		span.End()
+		p.msgCh <- receivedMessage{msg: msg, trace: span.SpanContext()}
 	}
 }
 
@@ -110,19 +474,58 @@ func (p *Peer) msgChLoop() {
 		case <-p.QuitCh:
 			log.Printf("[msgChLoop] Peer %s's QuitCh was closed", p.conn.RemoteAddr())
 			return
-		case msg := <-p.msgCh:
+		case received := <-p.msgCh:
+			msg := received.msg
+			span := tracing.Start(received.trace, "peer.handle", p.spanAttribute(), tracing.String("command", msg.Header.Command.String()))
 			var err error
 			switch msg.Header.Command {
 			case message.PingCommand:
 				err = p.handlePingMessage(msg)
+			case message.PongCommand:
+				err = p.handlePongMessage(msg)
 			case message.AddrCommand:
 				err = p.handleAddrMessage(msg)
+			case message.AddrV2Command:
+				err = p.handleAddrV2Message(msg)
+			case message.GetAddrCommand:
+				err = p.handleGetAddrMessage()
 			case message.InvCommand:
 				err = p.handleInvMessage(msg)
+			case message.GetDataCommand:
+				err = p.handleGetDataMessage(msg)
+			case message.GetBlocksCommand:
+				err = p.handleGetBlocksMessage(msg)
 			case message.BlockCommand:
-				err = p.handleBlockMessage(msg)
+				err = p.handleBlockMessage(msg, span.SpanContext())
+			case message.HeadersCommand:
+				err = p.handleHeadersMessage(msg)
+			case message.SendHeadersCommand:
+				p.sendHeaders.Store(true)
+			case message.TxCommand:
+				err = p.handleTxMessage(msg, span.SpanContext())
+			case message.MempoolCommand:
+				err = p.handleMempoolMessage()
+			case message.FeeFilterCommand:
+				p.feeFilter.Store(msg.Payload.(*message.FeeFilterPayload).FeeRate)
+			case message.FilterLoadCommand:
+				p.bloom.Store(newBloomFilter(msg.Payload.(*message.FilterLoadPayload)))
+			case message.FilterAddCommand:
+				p.handleFilterAddMessage(msg)
+			case message.FilterClearCommand:
+				p.bloom.Store(nil)
+			case message.GetCFiltersCommand, message.GetCFHeadersCommand, message.GetCFCheckptCommand:
+				p.handleCompactFilterRequest(msg)
+			case message.ReqReconCommand:
+				err = p.handleReqReconMessage(msg)
+			case message.SketchCommand:
+				err = p.handleSketchMessage(msg)
+			case message.ReconcilDiffCommand:
+				err = p.handleReconcilDiffMessage(msg)
 			}
-			if err != nil {
+			span.SetError(err)
+			span.End()
+			// a full write queue is already handled by the write queue policy
+			if err != nil && !errors.Is(err, ErrWriteQueueFull) {
 				//log.Printf("[msgChLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), err)
 				p.Quit()
 			} else {
@@ -139,9 +542,11 @@ func (p *Peer) writeLoop() {
 			//log.Printf("[writeLoop] Peer %s's QuitCh was closed", p.conn.RemoteAddr())
 			return
 		case bytes := <-p.writeCh:
-			_, err := p.conn.Write(bytes)
+			err := p.writeBatch(bytes)
 			if err != nil {
 				log.Printf("[writeLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), err)
+				p.Quit()
+				return
 			} else {
 				//log.Printf("[writeLoop] Wrote %d-bytes message to peer %s", len(bytes), p.conn.RemoteAddr())
 			}
@@ -149,6 +554,86 @@ func (p *Peer) writeLoop() {
 	}
 }
 
+// writeBatch buffers first together with any other messages already waiting in the write queue (up to the buffer's size), and then flushes
+// them to the connection at once, so bursts of small messages (pongs, getdata batches) do not cost a syscall each
+func (p *Peer) writeBatch(first []byte) error {
+	err := p.bufferWrite(first)
+	for err == nil && p.writer.Buffered() < writeBufferSize {
+		select {
+		case bytes := <-p.writeCh:
+			err = p.bufferWrite(bytes)
+			continue
+		default:
+		}
+		break
+	}
+	if err != nil {
+		return err
+	}
+	err = p.writer.Flush()
+	if err != nil {
+		return err
+	}
+	p.lastSend.Store(time.Now().UnixNano())
+	p.writeFlushes.Add(1)
+	return nil
+}
+
+// bufferWrite buffers an encoded message, starting it with the magic value of the peer's network rather than the one it was encoded with, as
+// the same encoded message may be sent to every peer
+func (p *Peer) bufferWrite(bytes []byte) error {
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], p.magic)
+	n, err := p.writer.Write(magic[:])
+	if err == nil {
+		var m int
+		m, err = p.writer.Write(bytes[len(magic):])
+		n += m
+	}
+	p.recordSent(commandOfEncodedMessage(bytes), n)
+	p.traceMessage(MessageSent, commandOfEncodedMessage(bytes), n, bytes)
+	return err
+}
+
+// pingLoop periodically pings the peer and quits it if a ping is not answered within pingTimeout.
+// Like Bitcoin Core, a new ping is not sent while another one is still outstanding.
+func (p *Peer) pingLoop() {
+	ticker := time.NewTicker(min(p.pingInterval, p.pingTimeout))
+	defer ticker.Stop()
+
+	var lastPingSentAt time.Time
+	for {
+		select {
+		case <-p.QuitCh:
+			return
+		case now := <-ticker.C:
+			p.statsMu.RLock()
+			outstanding, sentAt := p.pingNonce != 0, p.pingSentAt
+			p.statsMu.RUnlock()
+
+			if outstanding {
+				if now.Sub(sentAt) > p.pingTimeout {
+					log.Printf("[pingLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), ErrPingTimeout)
+					p.Quit()
+					return
+				}
+				continue
+			}
+			if now.Sub(lastPingSentAt) < p.pingInterval {
+				continue
+			}
+			err := p.sendPingMsg()
+			// a full write queue is already handled by the write queue policy
+			if err != nil && !errors.Is(err, ErrWriteQueueFull) {
+				log.Printf("[pingLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), err)
+				p.Quit()
+				return
+			}
+			lastPingSentAt = now
+		}
+	}
+}
+
 func (p *Peer) handlePingMessage(msg *message.Message) error {
 	pingPayload, ok := msg.Payload.(*message.PingPayload)
 	if !ok {
@@ -162,38 +647,128 @@ func (p *Peer) handlePingMessage(msg *message.Message) error {
 	if err != nil {
 		return err
 	}
-	p.write(pongMsgEncoded)
-
-	return nil
+	return p.write(pongMsgEncoded)
 }
 
-func (p *Peer) handleAddrMessage(msg *message.Message) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+func (p *Peer) handlePongMessage(msg *message.Message) error {
+	pongPayload, ok := msg.Payload.(*message.PongPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
 
-	if p.getAddrMsgResponseCh == nil {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	// unsolicited pongs or pongs answering an older ping are ignored
+	if p.pingNonce == 0 || pongPayload.Nonce != p.pingNonce {
 		return nil
 	}
+	latency := time.Since(p.pingSentAt)
+	p.pingLatency = latency
+	if p.minPingLatency == 0 || latency < p.minPingLatency {
+		p.minPingLatency = latency
+	}
+	p.pingNonce = 0
+	if p.metrics != nil {
+		p.metrics.observePingLatency(p.connectionLabels(), latency)
+	}
 
+	return nil
+}
+
+func (p *Peer) handleAddrMessage(msg *message.Message) error {
 	addrPayload, ok := msg.Payload.(*message.AddrPayload)
 	if !ok {
 		return ErrInvalidPayload
 	}
 
+	p.handleAddrs(addrPayload.AddressList)
+
+	return nil
+}
+
+func (p *Peer) handleAddrV2Message(msg *message.Message) error {
+	addrV2Payload, ok := msg.Payload.(*message.AddrV2Payload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+
+	// the node can only connect to IPv4 and IPv6 addresses
+	addresses := make([]message.Address, 0, len(addrV2Payload.AddressList))
+	for _, addressV2 := range addrV2Payload.AddressList {
+		if address, ok := addressV2.Address(); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	p.handleAddrs(addresses)
+
+	return nil
+}
+
+// handleAddrs passes addresses to the pending getaddr request, if there is one, and otherwise to the node as unsolicited addresses. Unsolicited
+// addresses are rate limited by addrTokens, and those over the limit are dropped.
+func (p *Peer) handleAddrs(addresses []message.Address) {
+	p.knownAddrs.add(addresses)
+
+	if p.answerGetAddr(addresses) || p.addrMsgCh == nil {
+		return
+	}
+
+	now := time.Now()
+	allowed := slices.DeleteFunc(slices.Clone(addresses), func(message.Address) bool {
+		return !p.addrTokens.Allow(now)
+	})
+	if dropped := len(addresses) - len(allowed); dropped > 0 {
+		log.Printf("Dropped %d unsolicited addresses from peer %s over the rate limit", dropped, p.conn.RemoteAddr())
+	}
+	if len(allowed) == 0 {
+		return
+	}
+	p.addrMsgCh <- &addrPayloadWithSender{Sender: p, AddrPayload: &message.AddrPayload{AddressList: allowed}}
+}
+
+// answerGetAddr passes the addresses to the pending getaddr request, if there is one, and reports whether it did
+func (p *Peer) answerGetAddr(addresses []message.Address) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.getAddrMsgResponseCh == nil {
+		return false
+	}
+
 	// Each peer which wants to accept incoming connections creates an “addr” or “addrv2” message providing its connection information and then sends that message to its peers unsolicited (https://developer.bitcoin.org/reference/p2p_networking.html#addr)
-	if len(addrPayload.AddressList) == 1 {
-		if a := addrPayload.AddressList[0]; [16]byte(a.NetworkAddress.IpAddress.To16()) == p.tcpAddress.IpAddress && a.NetworkAddress.Port == p.tcpAddress.Port {
-			return nil
+	if len(addresses) == 1 {
+		if a := addresses[0]; [16]byte(a.NetworkAddress.IpAddress.To16()) == p.tcpAddress.IpAddress && a.NetworkAddress.Port == p.tcpAddress.Port {
+			return false
 		}
 	}
 
-	log.Printf("Solicited addr message from peer %s has %d addresses", p.conn.RemoteAddr(), len(addrPayload.AddressList))
+	log.Printf("Solicited addr message from peer %s has %d addresses", p.conn.RemoteAddr(), len(addresses))
 
-	p.getAddrMsgResponseCh <- addrPayload.AddressList
+	p.getAddrMsgResponseCh <- addresses
 	close(p.getAddrMsgResponseCh)
 	p.getAddrMsgResponseCh = nil
 
-	return nil
+	return true
+}
+
+// handleGetAddrMessage answers the first getaddr message of an inbound peer with a sample of the recently seen addresses. Like bitcoind, getaddr
+// messages from outbound peers are ignored so that they cannot fingerprint us (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp#L4601-L4616)
+func (p *Peer) handleGetAddrMessage() error {
+	if p.addrMan == nil || p.direction != Inbound || p.getAddrAnswered {
+		return nil
+	}
+	p.getAddrAnswered = true
+
+	sample := p.addrMan.Sample(constants.MaxGetAddrResponse, time.Now().Add(-constants.AddrHorizon))
+	if len(sample) == 0 {
+		return nil
+	}
+	addresses := make([]message.Address, len(sample))
+	for i, known := range sample {
+		addresses[i] = known.address()
+	}
+	return p.sendAddrMsg(addresses)
 }
 
 func (p *Peer) handleInvMessage(msg *message.Message) error {
@@ -202,44 +777,241 @@ func (p *Peer) handleInvMessage(msg *message.Message) error {
 		return ErrInvalidPayload
 	}
 
-	p.invMsgCh <- &InvPayloadWithSender{Sender: p, InvPayload: invPayload}
+	p.invMsgCh <- &invPayloadWithSender{Sender: p, InvPayload: invPayload}
+
+	return nil
+}
+
+func (p *Peer) handleGetBlocksMessage(msg *message.Message) error {
+	getBlocksPayload, ok := msg.Payload.(*message.GetBlocksPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+
+	if p.getBlocksMsgCh != nil {
+		p.getBlocksMsgCh <- &getBlocksPayloadWithSender{Sender: p, GetBlocksPayload: getBlocksPayload}
+	}
+
+	return nil
+}
+
+func (p *Peer) handleCompactFilterRequest(msg *message.Message) {
+	if p.compactFilterMsgCh != nil {
+		p.compactFilterMsgCh <- &compactFilterRequestWithSender{Sender: p, Request: msg.Payload}
+	}
+}
+
+func (p *Peer) handleTxMessage(msg *message.Message, trace tracing.SpanContext) error {
+	txPayload, ok := msg.Payload.(*message.TxPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+
+	if p.txMsgCh != nil {
+		p.txMsgCh <- &txPayloadWithSender{Sender: p, TxPayload: txPayload, Trace: trace}
+	}
+
+	return nil
+}
+
+// handleMempoolMessage announces the transactions of the mempool to the peer, leaving out those its fee filter or bloom filter exclude. Nothing
+// is announced to peers which asked not to be sent transactions and did not set a bloom filter.
+func (p *Peer) handleMempoolMessage() error {
+	bloom := p.bloom.Load()
+	if p.mempool == nil || (!p.Capabilities().Relay && bloom == nil) {
+		return nil
+	}
+
+	feeFilter := p.feeFilter.Load()
+	inventories := make([]message.Inventory, 0)
+	for _, entry := range p.mempool.Entries() {
+		if !passesTxFilters(entry, feeFilter, bloom) {
+			continue
+		}
+		p.knownTxs.add(entry.TxId, entry.WtxId)
+		inventories = append(inventories, p.txInventory(entry))
+	}
+
+	for chunk := range slices.Chunk(inventories, constants.MaxInvPerMessage) {
+		err := p.sendInvMsg(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Peer) handleFilterAddMessage(msg *message.Message) {
+	bloom := p.bloom.Load()
+	if bloom == nil {
+		p.Misbehaving(constants.BanScoreThreshold, "sent filteradd without a bloom filter")
+		return
+	}
+	bloom.add(msg.Payload.(*message.FilterAddPayload).Data)
+}
+
+func (p *Peer) handleHeadersMessage(msg *message.Message) error {
+	headersPayload, ok := msg.Payload.(*message.HeadersPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+	if p.headersMsgCh == nil {
+		return nil
+	}
+
+	p.headersMsgCh <- &headersPayloadWithSender{HeadersPayload: headersPayload, Sender: p}
 
 	return nil
 }
 
-func (p *Peer) handleBlockMessage(msg *message.Message) error {
+func (p *Peer) handleBlockMessage(msg *message.Message, trace tracing.SpanContext) error {
 	blockPayload, ok := msg.Payload.(*message.BlockPayload)
 	if !ok {
 		return ErrInvalidPayload
 	}
 
-	p.blockMsgCh <- &BlockPayloadWithSender{Sender: p, BlockPayload: blockPayload}
+	p.blocksDelivered.Add(1)
+	p.blockMsgCh <- &blockPayloadWithSender{Sender: p, BlockPayload: blockPayload, ReceivedAt: time.Now(), Trace: trace}
 
 	return nil
 }
 
-func (p *Peer) write(bytes []byte) {
-	p.writeCh <- bytes
+// preVerifyBlock does the checks of a block that need nothing but the block itself: its hash must meet its target and its merkle root must
+// commit to its transactions. powLimitBits is the easiest target of the network in compact form.
+func preVerifyBlock(block *message.BlockPayload, powLimitBits uint32) error {
+	blockHash, err := block.GetBlockHash()
+	if err != nil {
+		return err
+	}
+	err = block.CheckProofOfWorkWithLimit(blockHash, powLimitBits)
+	if err != nil {
+		return err
+	}
+	return block.CheckMerkleRoot()
+}
+
+func (p *Peer) connectionLabels() ConnectionLabels {
+	return ConnectionLabels{Direction: p.direction, Network: p.network, ConnectionType: p.connectionType}
+}
+
+func (p *Peer) recordReceived(command string, n int) {
+	p.lastRecv.Store(time.Now().UnixNano())
+	p.bandwidth.addReceived(command, n)
+	if p.netTotals != nil {
+		p.netTotals.addReceived(command, n)
+	}
+	if p.metrics != nil {
+		p.metrics.addReceived(p.connectionLabels(), command, n)
+	}
+}
+
+func (p *Peer) recordSent(command string, n int) {
+	p.bandwidth.addSent(command, n)
+	if p.netTotals != nil {
+		p.netTotals.addSent(command, n)
+	}
+	if p.metrics != nil {
+		p.metrics.addSent(p.connectionLabels(), command, n)
+	}
+}
+
+// write queues an encoded message to be sent by writeLoop().
+//
+// If the write queue is full, write waits up to writeQueueTimeout for room (applying backpressure to the caller), after which writeQueuePolicy
+// decides whether the message is dropped or the peer is disconnected. Either way ErrWriteQueueFull is returned.
+func (p *Peer) write(bytes []byte) error {
+	select {
+	case p.writeCh <- bytes:
+		return nil
+	case <-p.QuitCh:
+		return ErrPeerHasQuit
+	default:
+	}
+
+	timer := time.NewTimer(p.writeQueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.writeCh <- bytes:
+		return nil
+	case <-p.QuitCh:
+		return ErrPeerHasQuit
+	case <-timer.C:
+	}
+
+	switch p.writeQueuePolicy {
+	case DropOnFullQueue:
+		p.droppedWrites.Add(1)
+		log.Printf("⚠️ Dropping \"%s\" message to slow peer %s as its write queue is full", commandOfEncodedMessage(bytes), p.conn.RemoteAddr())
+	default:
+		log.Printf("Quitting slow peer %s as its write queue is full", p.conn.RemoteAddr())
+		// the caller may be holding p.mu, which Quit() also locks
+		go p.Quit()
+	}
+	return ErrWriteQueueFull
 }
 
 func (p *Peer) sendGetAddrMsg() (<-chan []message.Address, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.getAddrMsgResponseCh = make(chan []message.Address)
-
 	getAddrMsg, err := message.NewGetAddrMessage()
 	if err != nil {
 		return nil, err
 	}
 	getAddrMsgEncoded, err := getAddrMsg.Encode()
-	p.write(getAddrMsgEncoded)
+	if err != nil {
+		return nil, err
+	}
+	err = p.write(getAddrMsgEncoded)
+	if err != nil {
+		return nil, err
+	}
+	p.getAddrMsgResponseCh = make(chan []message.Address)
 
 	log.Printf("╰┈➤ Sent getaddr message to peer %s", p.conn.RemoteAddr())
 
 	return p.getAddrMsgResponseCh, nil
 }
 
+func (p *Peer) sendPingMsg() error {
+	nonce := NewNonce()
+	pingMsg, err := message.NewPingMessage(nonce)
+	if err != nil {
+		return err
+	}
+	pingMsgEncoded, err := pingMsg.Encode()
+	if err != nil {
+		return err
+	}
+
+	p.statsMu.Lock()
+	p.pingNonce = nonce
+	p.pingSentAt = time.Now()
+	p.statsMu.Unlock()
+
+	return p.write(pingMsgEncoded)
+}
+
+func (p *Peer) sendAddrMsg(addresses []message.Address) error {
+	addrMsg, err := message.NewAddrMessage(addresses)
+	if err != nil {
+		return err
+	}
+	addrMsgEncoded, err := addrMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(addrMsgEncoded)
+	if err != nil {
+		return err
+	}
+	p.knownAddrs.add(addresses)
+
+	log.Printf("╰┈➤ Sent addr Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
 func (p *Peer) sendGetBlockDataMsg(blockInventories []message.Inventory) error {
 	getDataMsg, err := message.NewGetDataMessage(blockInventories)
 	if err != nil {
@@ -249,25 +1021,166 @@ func (p *Peer) sendGetBlockDataMsg(blockInventories []message.Inventory) error {
 	if err != nil {
 		return err
 	}
-	p.write(getDataMsgEncoded)
+	err = p.write(getDataMsgEncoded)
+	if err != nil {
+		return err
+	}
+	p.blocksRequested.Add(uint64(len(blockInventories)))
 
 	log.Printf("╰┈➤ Sent getdata Message to peer %s", p.conn.RemoteAddr())
 
 	return nil
 }
 
-func (p *Peer) sendGetBlocksMsg(protocolVersion uint32, blockLocatorHashes []message.Hash256, stopHash message.Hash256) error {
-	getBlocksMsg, err := message.NewGetBlocksMessage(protocolVersion, blockLocatorHashes, stopHash)
+func (p *Peer) sendGetHeadersMsg(protocolVersion uint32, blockLocatorHashes []message.Hash256, stopHash message.Hash256) error {
+	getHeadersMsg, err := message.NewGetHeadersMessage(protocolVersion, blockLocatorHashes, stopHash)
+	if err != nil {
+		return err
+	}
+	getHeadersMsgEncoded, err := getHeadersMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(getHeadersMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent getheaders Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendInvMsg(inventories []message.Inventory) error {
+	invMsg, err := message.NewInvMessage(inventories)
+	if err != nil {
+		return err
+	}
+	invMsgEncoded, err := invMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(invMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent inv Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendTxMsg(tx *message.TxPayload) error {
+	txMsg, err := message.NewTxMessage(tx.Version, tx.TransactionInputs, tx.TransactionOutputs, tx.TransactionWitnesses, tx.LockTime)
+	if err != nil {
+		return err
+	}
+	txMsgEncoded, err := txMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(txMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent tx Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendNotFoundMsg(inventories []message.Inventory) error {
+	notFoundMsg, err := message.NewNotFoundMessage(inventories)
+	if err != nil {
+		return err
+	}
+	notFoundMsgEncoded, err := notFoundMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(notFoundMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent notfound Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendReqReconMsg(setSize uint16, q uint16) error {
+	reqReconMsg, err := message.NewReqReconMessage(setSize, q)
+	if err != nil {
+		return err
+	}
+	reqReconMsgEncoded, err := reqReconMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(reqReconMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent reqrecon Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendSketchMsg(sketchData []byte) error {
+	sketchMsg, err := message.NewSketchMessage(sketchData)
+	if err != nil {
+		return err
+	}
+	sketchMsgEncoded, err := sketchMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(sketchMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent sketch Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendReconcilDiffMsg(success bool, askShortIds []uint32) error {
+	reconcilDiffMsg, err := message.NewReconcilDiffMessage(success, askShortIds)
+	if err != nil {
+		return err
+	}
+	reconcilDiffMsgEncoded, err := reconcilDiffMsg.Encode()
+	if err != nil {
+		return err
+	}
+	err = p.write(reconcilDiffMsgEncoded)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("╰┈➤ Sent reconcildiff Message to peer %s", p.conn.RemoteAddr())
+
+	return nil
+}
+
+func (p *Peer) sendFeeFilterMsg(feeRate int64) error {
+	feeFilterMsg, err := message.NewFeeFilterMessage(feeRate)
+	if err != nil {
+		return err
+	}
+	feeFilterMsgEncoded, err := feeFilterMsg.Encode()
 	if err != nil {
 		return err
 	}
-	getBlocksMsgEncoded, err := getBlocksMsg.Encode()
+	err = p.write(feeFilterMsgEncoded)
 	if err != nil {
 		return err
 	}
-	p.write(getBlocksMsgEncoded)
+	p.sentFeeFilter.Store(feeRate)
 
-	log.Printf("╰┈➤ Sent getblocks Message to peer %s", p.conn.RemoteAddr())
+	log.Printf("╰┈➤ Sent feefilter Message to peer %s", p.conn.RemoteAddr())
 
 	return nil
 }
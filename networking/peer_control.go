@@ -0,0 +1,73 @@
+package networking
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// RemoveManualPeer stops the node from keeping the peer at remoteAddr connected, and reports whether it was a manual peer. A connection to
+// the peer is not closed, but it is not reopened once it drops.
+func (n *Node) RemoveManualPeer(remoteAddr *net.TCPAddr) bool {
+	tcpAddress := TCPAddress{IpAddress: [16]byte(remoteAddr.IP.To16()), Port: uint16(remoteAddr.Port)}
+	if !n.isManualAddr(tcpAddress) {
+		return false
+	}
+	n.manualAddrs.Delete(tcpAddress)
+	return true
+}
+
+// DisconnectPeer quits the connected peer whose address is addr, as PeerInfo.Addr formats it, and reports whether there was one
+func (n *Node) DisconnectPeer(addr string) bool {
+	return n.disconnectPeerMatching(func(peer *Peer) bool {
+		return peer.conn.RemoteAddr().String() == addr
+	})
+}
+
+// DisconnectPeerById quits the connected peer whose PeerInfo.Id is id, and reports whether there was one
+func (n *Node) DisconnectPeerById(id int64) bool {
+	return n.disconnectPeerMatching(func(peer *Peer) bool {
+		return peer.id == id
+	})
+}
+
+// disconnectPeerMatching quits the connected peers matching match. Manual peers are reconnected once they quit.
+func (n *Node) disconnectPeerMatching(match func(peer *Peer) bool) bool {
+	disconnected := false
+	for _, peer := range n.peers.Keys() {
+		if match(peer) {
+			log.Printf("✂️ Disconnecting peer %s as requested", peer.conn.RemoteAddr())
+			peer.Quit()
+			disconnected = true
+		}
+	}
+	return disconnected
+}
+
+// BanSubnet bans every IP address of subnet until the given time and disconnects the connected peers it contains, and reports whether subnet
+// was not banned already. Single addresses are banned as subnets of one address.
+func (n *Node) BanSubnet(subnet *net.IPNet, until time.Time) bool {
+	if !n.banManager.BanSubnet(subnet, until) {
+		return false
+	}
+	subnet = canonicalSubnet(subnet)
+	n.disconnectPeerMatching(func(peer *Peer) bool {
+		return subnet.Contains(peer.tcpAddress.IpAddress[:])
+	})
+	return true
+}
+
+// UnbanSubnet lifts the ban of subnet, and reports whether it was banned
+func (n *Node) UnbanSubnet(subnet *net.IPNet) bool {
+	return n.banManager.UnbanSubnet(subnet)
+}
+
+// Bans returns the bans which have not expired yet, whether they were set with BanSubnet or because peers misbehaved
+func (n *Node) Bans() []Ban {
+	return n.banManager.Bans()
+}
+
+// ClearBans lifts every ban
+func (n *Node) ClearBans() {
+	n.banManager.Clear()
+}
@@ -0,0 +1,96 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNode_DisconnectPeerByAddressOrId(t *testing.T) {
+	first, second := networkingtest.NewFakePeer(t), networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	peerEvents := node.Events().Subscribe(10, events.TopicPeerEvents)
+	defer peerEvents.Unsubscribe()
+	firstPeer, err := node.AddPeer(first.Addr())
+	require.NoError(t, err)
+	firstConn := first.Accept(time.Second)
+	secondPeer, err := node.AddPeer(second.Addr())
+	require.NoError(t, err)
+	secondConn := second.Accept(time.Second)
+
+	infos := node.Peers()
+	require.Len(t, infos, 2)
+	require.Equal(t, int64(0), infos[0].Id)
+	require.Equal(t, int64(1), infos[1].Id)
+
+	require.False(t, node.DisconnectPeerById(2))
+	require.True(t, node.DisconnectPeerById(1))
+	<-secondPeer.QuitCh
+	<-secondConn.Closed()
+	for _, expected := range []events.PeerEvent{
+		{Action: events.PeerConnected, Id: 0, Addr: infos[0].Addr},
+		{Action: events.PeerConnected, Id: 1, Addr: infos[1].Addr},
+		{Action: events.PeerDisconnected, Id: 1, Addr: infos[1].Addr},
+	} {
+		event := (<-peerEvents.C).Data.(events.PeerEvent)
+		require.Equal(t, expected.Action, event.Action)
+		require.Equal(t, expected.Id, event.Id)
+		require.Equal(t, expected.Addr, event.Addr)
+		require.Equal(t, string(Outbound), event.Direction)
+		require.Equal(t, expected.Action == events.PeerDisconnected, event.ConnectedFor > 0)
+	}
+
+	require.False(t, node.DisconnectPeer("127.0.0.1:1"))
+	require.True(t, node.DisconnectPeer(infos[0].Addr))
+	<-firstPeer.QuitCh
+	<-firstConn.Closed()
+	require.Zero(t, node.peers.Len())
+}
+
+func TestNode_RemovedManualPeerIsNotReconnected(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	node.manualPeerRetryInterval = 10 * time.Millisecond
+
+	require.True(t, node.AddManualPeer(fakePeer.Addr()))
+	require.False(t, node.AddManualPeer(fakePeer.Addr()))
+	fakePeer.Accept(time.Second)
+	require.Eventually(t, func() bool { return node.peers.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+	require.True(t, node.RemoveManualPeer(fakePeer.Addr()))
+	require.False(t, node.RemoveManualPeer(fakePeer.Addr()))
+	// the connection is kept, but not reopened once it is closed
+	require.Equal(t, 1, node.peers.Len())
+	require.True(t, node.DisconnectPeerById(0))
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, node.peers.Len())
+}
+
+func TestNode_BanSubnetDisconnectsPeers(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	peer, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	conn := fakePeer.Accept(time.Second)
+
+	_, subnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	require.True(t, node.BanSubnet(subnet, time.Now().Add(time.Hour)))
+	require.False(t, node.BanSubnet(subnet, time.Now().Add(time.Hour)))
+	<-peer.QuitCh
+	<-conn.Closed()
+	require.Len(t, node.Bans(), 1)
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.ErrorIs(t, err, ErrPeerBanned)
+
+	require.True(t, node.UnbanSubnet(subnet))
+	require.False(t, node.UnbanSubnet(subnet))
+	node.BanSubnet(subnet, time.Now().Add(time.Hour))
+	node.ClearBans()
+	require.Empty(t, node.Bans())
+	_, err = node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+}
@@ -0,0 +1,51 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"regexp"
+)
+
+var (
+	ErrUserAgentNotAllowed       = errors.New("peer's user agent is not allowed")
+	ErrProtocolVersionNotAllowed = errors.New("peer's protocol version is not allowed")
+)
+
+// PeerFilter rejects peers by what they announced in their version message, e.g. implementations known to be broken or to spy on their peers
+type PeerFilter struct {
+	// if not empty, user agents must match one of them
+	AllowUserAgents []*regexp.Regexp
+	// user agents matching any of them are rejected, even if they are allowed
+	DenyUserAgents []*regexp.Regexp
+	// protocol versions below MinProtocolVersion or above MaxProtocolVersion are rejected (a zero bound is not checked)
+	MinProtocolVersion int32
+	MaxProtocolVersion int32
+}
+
+// Check returns why the peer which sent version is rejected, or nil if it is not
+func (f PeerFilter) Check(version *message.VersionPayload) error {
+	if f.MinProtocolVersion != 0 && version.Version < f.MinProtocolVersion || f.MaxProtocolVersion != 0 && version.Version > f.MaxProtocolVersion {
+		return fmt.Errorf("%w: %d", ErrProtocolVersionNotAllowed, version.Version)
+	}
+	for _, deny := range f.DenyUserAgents {
+		if deny.MatchString(version.UserAgent) {
+			return fmt.Errorf("%w: %q matches %s", ErrUserAgentNotAllowed, version.UserAgent, deny)
+		}
+	}
+	if len(f.AllowUserAgents) == 0 {
+		return nil
+	}
+	for _, allow := range f.AllowUserAgents {
+		if allow.MatchString(version.UserAgent) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q matches none of the allowed user agents", ErrUserAgentNotAllowed, version.UserAgent)
+}
+
+// SetPeerFilter makes the node disconnect from the peers filter rejects right after the handshake, apart from manual peers (it must be called
+// before any peer is added)
+func (n *Node) SetPeerFilter(filter PeerFilter) {
+	n.peerFilter = filter
+}
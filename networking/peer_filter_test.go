@@ -0,0 +1,53 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPeerFilter_Check(t *testing.T) {
+	filter := PeerFilter{
+		AllowUserAgents:    []*regexp.Regexp{regexp.MustCompile(`^/Satoshi:`), regexp.MustCompile(`^/btcd:`)},
+		DenyUserAgents:     []*regexp.Regexp{regexp.MustCompile(`^/Satoshi:0\.1[0-5]\.`)},
+		MinProtocolVersion: 70001,
+		MaxProtocolVersion: 70016,
+	}
+	tests := []struct {
+		userAgent string
+		version   int32
+		err       error
+	}{
+		{"/Satoshi:27.0.0/", 70016, nil},
+		{"/btcd:0.24.0/", 70013, nil},
+		{"/Satoshi:0.13.2/", 70015, ErrUserAgentNotAllowed},
+		{"/bitnodes.io:0.3/", 70015, ErrUserAgentNotAllowed},
+		{"/Satoshi:27.0.0/", 70000, ErrProtocolVersionNotAllowed},
+		{"/Satoshi:27.0.0/", 70017, ErrProtocolVersionNotAllowed},
+	}
+	for _, test := range tests {
+		err := filter.Check(&message.VersionPayload{Version: test.version, UserAgent: test.userAgent})
+		require.ErrorIs(t, err, test.err, "%s at version %d", test.userAgent, test.version)
+	}
+
+	require.NoError(t, PeerFilter{}.Check(&message.VersionPayload{Version: 1, UserAgent: "/anything/"}))
+}
+
+func TestNode_RejectsPeersDeniedByPeerFilter(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	node.SetPeerFilter(PeerFilter{DenyUserAgents: []*regexp.Regexp{regexp.MustCompile(`^/FakePeer:`)}})
+
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.ErrorIs(t, err, ErrUserAgentNotAllowed)
+	require.Zero(t, node.peers.Len())
+
+	// manual peers are exempt
+	node.AddManualPeer(fakePeer.Addr())
+	require.Eventually(t, func() bool { return node.peers.Len() == 1 }, time.Second, 10*time.Millisecond)
+	_, ok := node.connectedAddrs.Get(TCPAddress{IpAddress: [16]byte(fakePeer.Addr().IP.To16()), Port: uint16(fakePeer.Addr().Port)})
+	require.True(t, ok)
+}
@@ -0,0 +1,74 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// manager is a part of the node running in its own goroutine, started by Node.Start, until ctx is cancelled or quitCh is closed
+type manager interface {
+	run(ctx context.Context, quitCh <-chan struct{})
+}
+
+// peerManager keeps the node connected to its minimum number of peers
+type peerManager interface {
+	manager
+	// notify makes the peer manager connect to new peers, as the node has fewer than its minimum
+	notify()
+}
+
+// peerConnector connects to new peers for the peer manager, which Node does
+type peerConnector interface {
+	// addPeersIfNecessary connects to new peers until the node has its minimum number of peers
+	addPeersIfNecessary() error
+	// quit makes the node stop by itself with err
+	quit(err error)
+}
+
+// peerDialer is the peerManager connecting to new peers in its own goroutine, so that waiting for the addresses of peers and dialing new ones
+// does not hold up the download of blocks and the relay of transactions
+type peerDialer struct {
+	connector peerConnector
+	// notified when the node has fewer peers than its minimum
+	addPeersCh chan struct{}
+}
+
+func newPeerDialer(connector peerConnector) *peerDialer {
+	return &peerDialer{connector: connector, addPeersCh: make(chan struct{}, 1)}
+}
+
+// notify makes the peer dialer connect to new peers, unless it was already notified and has not started to
+func (d *peerDialer) notify() {
+	select {
+	case d.addPeersCh <- struct{}{}:
+	default:
+		log.Println("addPeersCh has already been notified")
+	}
+}
+
+// run connects to new peers whenever the peer dialer is notified. It stops the node if the node has neither peers nor addresses left to
+// connect to.
+func (d *peerDialer) run(ctx context.Context, quitCh <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-quitCh:
+			return
+		case <-d.addPeersCh:
+			log.Printf("[peerDialer] Executing addPeersIfNecessary()...")
+			err := d.connector.addPeersIfNecessary()
+			if errors.Is(err, ErrNodeHasNoPeersOrUnconnectedAddrs) {
+				log.Printf("[peerDialer] Stopping node due to error %s", err)
+				d.connector.quit(err)
+				return
+			}
+			if err != nil {
+				log.Printf("[peerDialer] addPeersIfNecessary() failed with error %s", err)
+			} else {
+				log.Printf("[peerDialer] addPeersIfNecessary() executed successfully")
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// fakePeerConnector counts the attempts to add peers, which fail with err
+type fakePeerConnector struct {
+	calls    chan struct{}
+	err      error
+	quitErrs chan error
+}
+
+func newFakePeerConnector(err error) *fakePeerConnector {
+	return &fakePeerConnector{calls: make(chan struct{}, 10), err: err, quitErrs: make(chan error, 1)}
+}
+
+func (f *fakePeerConnector) addPeersIfNecessary() error {
+	f.calls <- struct{}{}
+	return f.err
+}
+
+func (f *fakePeerConnector) quit(err error) {
+	f.quitErrs <- err
+}
+
+func TestPeerDialer_AddsPeersWhenNotified(t *testing.T) {
+	connector := newFakePeerConnector(errors.New("dial failed"))
+	dialer := newPeerDialer(connector)
+	// notifications are coalesced until the dialer gets round to them
+	dialer.notify()
+	dialer.notify()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dialer.run(ctx, make(chan struct{}))
+	}()
+
+	<-connector.calls
+	select {
+	case <-connector.calls:
+		t.Fatal("peers added twice for coalesced notifications")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// failing to add peers does not stop the node
+	dialer.notify()
+	<-connector.calls
+	require.Empty(t, connector.quitErrs)
+
+	cancel()
+	<-done
+}
+
+func TestPeerDialer_StopsNodeWithoutPeersOrAddrs(t *testing.T) {
+	connector := newFakePeerConnector(ErrNodeHasNoPeersOrUnconnectedAddrs)
+	dialer := newPeerDialer(connector)
+	dialer.notify()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dialer.run(context.Background(), make(chan struct{}))
+	}()
+
+	require.ErrorIs(t, <-connector.quitErrs, ErrNodeHasNoPeersOrUnconnectedAddrs)
+	<-done
+}
@@ -0,0 +1,89 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// PeerSelector picks the peer blocks are requested from among candidates, which offer the services needed to serve them and are never empty
+type PeerSelector interface {
+	SelectPeer(candidates []*Peer) *Peer
+}
+
+// RandomPeerSelector picks any candidate with equal probability
+type RandomPeerSelector struct{}
+
+func (RandomPeerSelector) SelectPeer(candidates []*Peer) *Peer {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// WeightedPeerSelector picks candidates at random, favouring the ones with a low ping latency, which delivered most of the blocks requested from
+// them without timing out or stalling and which announced the highest start height
+type WeightedPeerSelector struct{}
+
+func (WeightedPeerSelector) SelectPeer(candidates []*Peer) *Peer {
+	var maxStartHeight int32
+	for _, candidate := range candidates {
+		maxStartHeight = max(maxStartHeight, candidate.Capabilities().StartHeight)
+	}
+	weights := make([]float64, len(candidates))
+	var total float64
+	for i, candidate := range candidates {
+		stats := candidate.Stats()
+		weights[i] = blockRequestWeight(stats.PingLatency, stats.BlocksRequested, stats.BlocksDelivered, stats.BlockTimeouts+stats.BlockStalls, candidate.Capabilities().StartHeight,
+			maxStartHeight)
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return candidates[i]
+		}
+		r -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// Latency assumed for peers which did not answer a ping yet
+const unknownPingLatency = time.Second
+
+// blockRequestWeight is the product of four factors in (0, 1]: one halving every 100ms of ping latency, the share of the requested blocks the
+// peer delivered (counting as one delivered out of two before anything is requested), one dividing by the number of times its blocks timed out or
+// stalled plus one and the peer's start height relative to the highest one
+func blockRequestWeight(pingLatency time.Duration, blocksRequested uint64, blocksDelivered uint64, slowDeliveries uint64, startHeight int32,
+	maxStartHeight int32) float64 {
+	if pingLatency <= 0 {
+		pingLatency = unknownPingLatency
+	}
+	latency := 1 / (1 + float64(pingLatency)/float64(100*time.Millisecond))
+	delivery := float64(min(blocksDelivered, blocksRequested)+1) / float64(blocksRequested+2)
+	timeliness := 1 / float64(slowDeliveries+1)
+	height := 1.0
+	if maxStartHeight > 0 {
+		height = float64(max(startHeight, 0)+1) / float64(maxStartHeight+1)
+	}
+	return latency * delivery * timeliness * height
+}
+
+// SetPeerSelector makes the node request blocks from the peers selector picks, rather than from peers picked with WeightedPeerSelector
+func (n *Node) SetPeerSelector(selector PeerSelector) {
+	n.peerSelector = selector
+}
+
+// selectPeerWithServices returns the peer the node's peer selector picks among the peers offering all of services, e.g. message.NodeNetwork,
+// except the excluded ones
+// for peers that can serve any block
+func (n *Node) selectPeerWithServices(services message.Services, excluded ...*Peer) (*Peer, bool) {
+	candidates := make([]*Peer, 0)
+	for _, peer := range n.peers.Keys() {
+		if peer.Capabilities().HasServices(services) && !slices.Contains(excluded, peer) {
+			candidates = append(candidates, peer)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	return n.peerSelector.SelectPeer(candidates), true
+}
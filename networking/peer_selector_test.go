@@ -0,0 +1,49 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBlockRequestWeight(t *testing.T) {
+	base := blockRequestWeight(50*time.Millisecond, 10, 10, 0, 800000, 800000)
+
+	require.Greater(t, base, blockRequestWeight(500*time.Millisecond, 10, 10, 0, 800000, 800000), "slower peers weigh less")
+	require.Greater(t, base, blockRequestWeight(50*time.Millisecond, 10, 2, 0, 800000, 800000), "peers delivering fewer blocks weigh less")
+	require.Greater(t, base, blockRequestWeight(50*time.Millisecond, 10, 10, 1, 800000, 800000), "peers whose blocks timed out weigh less")
+	require.Greater(t, base, blockRequestWeight(50*time.Millisecond, 10, 10, 0, 400000, 800000), "peers behind weigh less")
+	require.Equal(t, blockRequestWeight(time.Second, 0, 0, 0, 0, 0), blockRequestWeight(0, 0, 0, 0, 0, 0), "unknown latency counts as a second")
+	require.Equal(t, blockRequestWeight(time.Second, 4, 4, 0, 0, 0), blockRequestWeight(time.Second, 4, 9, 0, 0, 0), "unsolicited blocks don't count")
+	require.Greater(t, blockRequestWeight(time.Second, 0, 0, 0, 0, 0), 0.0)
+}
+
+// lastPeerSelector records the candidates it was given and picks the last one
+type lastPeerSelector struct {
+	candidates []*Peer
+}
+
+func (s *lastPeerSelector) SelectPeer(candidates []*Peer) *Peer {
+	s.candidates = candidates
+	return candidates[len(candidates)-1]
+}
+
+func TestNode_RequestsBlocksFromPeerPickedBySelector(t *testing.T) {
+	fakePeers := []*networkingtest.FakePeer{networkingtest.NewFakePeer(t), networkingtest.NewFakePeer(t)}
+	node := newFakePeerNode(t, 20*time.Second)
+	selector := &lastPeerSelector{}
+	node.SetPeerSelector(selector)
+	conns := make(map[*Peer]*networkingtest.Conn)
+	for _, fakePeer := range fakePeers {
+		peer, err := node.AddPeer(fakePeer.Addr())
+		require.NoError(t, err)
+		conns[peer] = fakePeer.Accept(time.Second)
+	}
+
+	require.NoError(t, node.requestForNewBlocks())
+
+	require.Len(t, selector.candidates, 2)
+	conns[selector.candidates[1]].Expect(message.GetHeadersCommand, time.Second)
+}
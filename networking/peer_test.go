@@ -2,6 +2,7 @@ package networking
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"github.com/aang114/bitcoin-node/constants"
 	"github.com/aang114/bitcoin-node/message"
@@ -10,6 +11,7 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 type PeerTestSuite struct {
@@ -18,8 +20,8 @@ type PeerTestSuite struct {
 	nodeConn   net.Conn
 	peerConn   net.Conn
 	peer       *Peer
-	invMsgCh   chan *InvPayloadWithSender
-	blockMsgCh chan *BlockPayloadWithSender
+	invMsgCh   chan *invPayloadWithSender
+	blockMsgCh chan *blockPayloadWithSender
 	pingMsg    *message.Message
 	invMsg     *message.Message
 	blockMsg   *message.Message
@@ -46,8 +48,8 @@ func (s *PeerTestSuite) SetupSuite() {
 	}
 	s.invMsg, err = message.DecodeMessage(bytes.NewReader(encodedInvMsg))
 
-	// Hexdump example of block message taken from https://developer.bitcoin.org/reference/block_chain.html#block-headers
-	encodedBlockMsg, err := hex.DecodeString("F9BEB4D9626C6F636B00000000000000510000009184952902000000B6FF0B1B1680A2862A30CA44D346D9E8910D334BEB48CA0C00000000000000009D10AA52EE949386CA9385695F04EDE270DDA20810DECD12BC9B048AAAB3147124D95A5430C31B18FE9F086400")
+	// Hexdump of a block message carrying the genesis block (https://en.bitcoin.it/wiki/Genesis_block)
+	encodedBlockMsg, err := hex.DecodeString("f9beb4d9626c6f636b000000000000001d010000f71a24030100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c0101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000")
 	if err != nil {
 		s.FailNow(err.Error())
 	}
@@ -64,7 +66,7 @@ func (s *PeerTestSuite) SetupSuite() {
 	}
 }
 
-func performHandshake(s *PeerTestSuite) {
+func performHandshakeForPeerTestSuite(s *PeerTestSuite) {
 	var err error
 	ln, err := net.Listen("tcp", s.peerAddr.String())
 	defer ln.Close()
@@ -100,7 +102,7 @@ func performHandshake(s *PeerTestSuite) {
 		sendMsg(s.T(), s.peerConn, s.verackMsg)
 	}()
 
-	s.nodeConn, err = PerformHandshake(&s.peerAddr, s.tcpTimeout, message.NodeNetwork, message.NodeNetwork)
+	s.nodeConn, _, err = performHandshake(context.Background(), &net.Dialer{Timeout: s.tcpTimeout}, &s.peerAddr, constants.MainnetMagicValue, message.NodeNetwork, message.NodeNetwork, NewNonce())
 	if err != nil {
 		s.FailNow(err.Error())
 	}
@@ -112,15 +114,11 @@ func performHandshake(s *PeerTestSuite) {
 }
 
 func setupPeer(s *PeerTestSuite, conn net.Conn) {
-	s.invMsgCh = make(chan *InvPayloadWithSender, 100)
-	s.blockMsgCh = make(chan *BlockPayloadWithSender, 100)
-	tcpConn, ok := conn.(*net.TCPConn)
-	if !ok {
-		s.FailNow("peer conn is not tcp connection")
-	}
+	s.invMsgCh = make(chan *invPayloadWithSender, 100)
+	s.blockMsgCh = make(chan *blockPayloadWithSender, 100)
 	var err error
-	s.peer, err = NewPeer(
-		tcpConn,
+	s.peer, err = newPeer(
+		conn,
 		nil,
 		s.invMsgCh,
 		s.blockMsgCh,
@@ -131,7 +129,7 @@ func setupPeer(s *PeerTestSuite, conn net.Conn) {
 }
 
 func (s *PeerTestSuite) SetupTest() {
-	performHandshake(s)
+	performHandshakeForPeerTestSuite(s)
 	setupPeer(s, s.nodeConn)
 }
 
@@ -144,7 +142,7 @@ func (s *PeerTestSuite) TearDownTest() {
 }
 
 func (s *PeerTestSuite) TestPeer_PingPongWorks() {
-	go s.peer.Start()
+	go s.peer.Start(context.Background())
 
 	sendMsg(s.T(), s.peerConn, s.pingMsg)
 	msg := receiveMsg(s.T(), s.peerConn)
@@ -158,7 +156,7 @@ func (s *PeerTestSuite) TestPeer_PingPongWorks() {
 }
 
 func (s *PeerTestSuite) TestPeer_InvMsgChWorks() {
-	go s.peer.Start()
+	go s.peer.Start(context.Background())
 
 	sendMsg(s.T(), s.peerConn, s.invMsg)
 
@@ -169,7 +167,7 @@ func (s *PeerTestSuite) TestPeer_InvMsgChWorks() {
 }
 
 func (s *PeerTestSuite) TestPeer_BlockMsgChWorks() {
-	go s.peer.Start()
+	go s.peer.Start(context.Background())
 
 	sendMsg(s.T(), s.peerConn, s.blockMsg)
 
@@ -179,8 +177,27 @@ func (s *PeerTestSuite) TestPeer_BlockMsgChWorks() {
 	s.Equal(s.blockMsg.Payload, blockMsgWithSender.BlockPayload)
 }
 
+func (s *PeerTestSuite) TestPeer_InvalidBlockIsDroppedAndPeerShouldBeBanned() {
+	go s.peer.Start(context.Background())
+
+	// changing the nonce makes the hash miss the target
+	block := *s.blockMsg.Payload.(*message.BlockPayload)
+	block.Nonce++
+	invalidBlockMsg, err := message.NewBlockMessage(block.Version, block.PrevBlock, block.MerkleRoot, block.Timestamp, block.Bits, block.Nonce, block.Transactions)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, invalidBlockMsg)
+
+	select {
+	case <-s.peer.QuitCh:
+	case <-time.After(2 * time.Second):
+		s.FailNow("peer sending an invalid block was not quit")
+	}
+	s.True(s.peer.ShouldBan())
+	s.Empty(s.blockMsgCh)
+}
+
 func (s *PeerTestSuite) TestPeer_GetAddrMsgResponseChWorks() {
-	go s.peer.Start()
+	go s.peer.Start(context.Background())
 
 	getAddrMsgResponseCh, err := s.peer.sendGetAddrMsg()
 	s.NoError(err)
@@ -195,10 +212,259 @@ func (s *PeerTestSuite) TestPeer_GetAddrMsgResponseChWorks() {
 }
 
 func (s *PeerTestSuite) TestPeer_Quit() {
-	go s.peer.Start()
+	go s.peer.Start(context.Background())
 
 	s.peerConn.Close()
 
 	<-s.peer.QuitCh
 	s.True(s.peer.HasQuit)
 }
+
+func (s *PeerTestSuite) TestPeer_PingLatencyIsTracked() {
+	s.peer.pingInterval = 100 * time.Millisecond
+	go s.peer.Start(context.Background())
+
+	msg := receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.PingCommand, msg.Payload.CommandName())
+	pingPayload, ok := msg.Payload.(*message.PingPayload)
+	s.True(ok)
+	s.NotZero(s.peer.Stats().PingWait)
+
+	pongMsg, err := message.NewPongMessage(pingPayload.Nonce)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, pongMsg)
+
+	s.Eventually(func() bool { return s.peer.Stats().PingLatency > 0 }, time.Second, 10*time.Millisecond)
+	s.Zero(s.peer.Stats().PingWait)
+	s.Equal(s.peer.Stats().PingLatency, s.peer.Stats().MinPingLatency)
+}
+
+func (s *PeerTestSuite) TestPeer_QuitsIfPingIsNotAnswered() {
+	s.peer.pingInterval = 50 * time.Millisecond
+	s.peer.pingTimeout = 200 * time.Millisecond
+	go s.peer.Start(context.Background())
+
+	select {
+	case <-s.peer.QuitCh:
+	case <-time.After(2 * time.Second):
+		s.FailNow("peer did not quit after ping timeout")
+	}
+}
+
+func (s *PeerTestSuite) TestPeer_QuitsAndShouldBeBannedIfItFloods() {
+	s.peer.rateLimiters = newTokenBuckets(map[message.CommandName]RateLimit{message.PingCommand: {Rate: 0, Burst: 1}}, time.Now())
+	go s.peer.Start(context.Background())
+
+	// the first ping is allowed, every following one adds 1 to the misbehavior score
+	for range constants.BanScoreThreshold + 1 {
+		encoded, err := s.pingMsg.Encode()
+		s.NoError(err)
+		_, err = s.peerConn.Write(encoded)
+		if err != nil {
+			break
+		}
+	}
+
+	select {
+	case <-s.peer.QuitCh:
+	case <-time.After(2 * time.Second):
+		s.FailNow("flooding peer was not quit")
+	}
+	s.True(s.peer.ShouldBan())
+}
+
+func (s *PeerTestSuite) TestPeer_BandwidthIsAccountedPerCommand() {
+	go s.peer.Start(context.Background())
+
+	sendMsg(s.T(), s.peerConn, s.pingMsg)
+	receiveMsg(s.T(), s.peerConn)
+
+	// ping and pong messages are a 24-byte header and an 8-byte nonce
+	s.Eventually(func() bool { return s.peer.Stats().BytesSent == 32 }, time.Second, 10*time.Millisecond)
+	stats := s.peer.Stats()
+	s.Equal(uint64(32), stats.BytesReceived)
+	s.Equal(map[string]uint64{"ping": 32}, stats.BytesReceivedPerCommand)
+	s.Equal(map[string]uint64{"pong": 32}, stats.BytesSentPerCommand)
+}
+
+func (s *PeerTestSuite) TestPeer_SlowPeerIsDisconnectedWhenWriteQueueIsFull() {
+	// writeLoop is not started, so nothing drains the write queue
+	s.peer.writeCh = make(chan []byte, 1)
+	s.peer.writeQueueTimeout = 50 * time.Millisecond
+
+	s.NoError(s.peer.sendPingMsg())
+	s.Equal(1, s.peer.Stats().WriteQueueDepth)
+	s.Equal(1, s.peer.Stats().WriteQueueCapacity)
+
+	s.ErrorIs(s.peer.sendPingMsg(), ErrWriteQueueFull)
+	select {
+	case <-s.peer.QuitCh:
+	case <-time.After(time.Second):
+		s.FailNow("slow peer was not quit")
+	}
+	s.ErrorIs(s.peer.sendPingMsg(), ErrPeerHasQuit)
+}
+
+func (s *PeerTestSuite) TestPeer_MessagesAreDroppedWhenWriteQueueIsFull() {
+	s.peer.writeCh = make(chan []byte, 1)
+	s.peer.writeQueueTimeout = 50 * time.Millisecond
+	s.peer.writeQueuePolicy = DropOnFullQueue
+
+	s.NoError(s.peer.sendPingMsg())
+	s.ErrorIs(s.peer.sendPingMsg(), ErrWriteQueueFull)
+	s.Equal(uint64(1), s.peer.Stats().DroppedWrites)
+	s.False(s.peer.HasQuit)
+}
+
+func (s *PeerTestSuite) TestPeer_QueuedMessagesAreFlushedTogether() {
+	encoded, err := s.pingMsg.Encode()
+	s.NoError(err)
+	for range 3 {
+		s.NoError(s.peer.write(encoded))
+	}
+
+	go s.peer.Start(context.Background())
+
+	for range 3 {
+		msg := receiveMsg(s.T(), s.peerConn)
+		s.Equal(message.PingCommand, msg.Payload.CommandName())
+	}
+	s.Equal(uint64(1), s.peer.Stats().WriteFlushes)
+	s.Equal(uint64(3*len(encoded)), s.peer.Stats().BytesSent)
+}
+
+func (s *PeerTestSuite) TestPeer_MessagesAreTraced() {
+	traced := make(chan TracedMessage, 10)
+	s.peer.tracer = func(m TracedMessage) { traced <- m }
+	s.peer.tracePayloads = true
+	go s.peer.Start(context.Background())
+
+	sendMsg(s.T(), s.peerConn, s.pingMsg)
+	receiveMsg(s.T(), s.peerConn)
+
+	// the ping's and pong's payload is the nonce 100
+	received := <-traced
+	s.Equal(MessageReceived, received.Direction)
+	s.Equal("ping", received.Command)
+	s.Equal(32, received.Size)
+	s.Equal("6400000000000000", received.Payload)
+	sent := <-traced
+	s.Equal(MessageSent, sent.Direction)
+	s.Equal("pong", sent.Command)
+	s.Equal(32, sent.Size)
+	s.Equal("6400000000000000", sent.Payload)
+	s.Equal(s.nodeConn.RemoteAddr().String(), sent.Peer)
+}
+
+func (s *PeerTestSuite) TestPeer_SendHeadersIsRecorded() {
+	go s.peer.Start(context.Background())
+	s.False(s.peer.Capabilities().SendHeaders)
+
+	sendHeadersMsg, err := message.NewSendHeadersMessage()
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, sendHeadersMsg)
+
+	s.Eventually(func() bool { return s.peer.Capabilities().SendHeaders }, time.Second, 10*time.Millisecond)
+}
+
+func (s *PeerTestSuite) TestPeer_MempoolIsAnsweredWithTxsMatchingBloomFilter() {
+	s.peer.version = &message.VersionPayload{Relay: true}
+	s.peer.mempool = newTestMempool()
+	matching, err := s.peer.mempool.Add(newTestTx(message.Hash256{0x01}, 1000, []byte{0x51}))
+	s.NoError(err)
+	_, err = s.peer.mempool.Add(newTestTx(message.Hash256{0x02}, 1000, []byte{0x51}))
+	s.NoError(err)
+	go s.peer.Start(context.Background())
+
+	bloom := newBloomFilter(&message.FilterLoadPayload{Filter: make([]byte, 64), HashFuncs: 5})
+	bloom.add(matching.TxId[:])
+	filterLoadMsg, err := message.NewFilterLoadMessage(bloom.filter, bloom.hashFuncs, bloom.tweak, bloom.flags)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, filterLoadMsg)
+	mempoolMsg, err := message.NewMempoolMessage()
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, mempoolMsg)
+
+	msg := receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.InvCommand, msg.Payload.CommandName())
+	s.Equal([]message.Inventory{{Type: message.MsgTx, Hash: matching.TxId}}, msg.Payload.(*message.InvPayload).InventoryList)
+}
+
+func (s *PeerTestSuite) TestPeer_QueuedTxsAreAnnouncedAndServed() {
+	s.peer.version = &message.VersionPayload{Relay: true}
+	s.peer.wtxidRelay = true
+	s.peer.txAnnounceMeanInterval = 10 * time.Millisecond
+	s.peer.mempool = newTestMempool()
+	announced, err := s.peer.mempool.Add(newTestWitnessTx(message.Hash256{0x01}))
+	s.NoError(err)
+	unannounced, err := s.peer.mempool.Add(newTestWitnessTx(message.Hash256{0x02}))
+	s.NoError(err)
+	s.peer.queueTx(announced)
+	go s.peer.Start(context.Background())
+
+	msg := receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.InvCommand, msg.Payload.CommandName())
+	s.Equal([]message.Inventory{{Type: message.MsgWtx, Hash: announced.WtxId}}, msg.Payload.(*message.InvPayload).InventoryList)
+
+	getDataMsg, err := message.NewGetDataMessage([]message.Inventory{
+		{Type: message.MsgWtx, Hash: announced.WtxId},
+		{Type: message.MsgTx, Hash: announced.TxId},
+		// transactions which were not announced to the peer are not sent to it until they have been in the mempool for a while
+		{Type: message.MsgWtx, Hash: unannounced.WtxId},
+		{Type: message.MsgWtx, Hash: message.Hash256{0xFF}},
+	})
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, getDataMsg)
+
+	msg = receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.TxCommand, msg.Payload.CommandName())
+	s.Equal(announced.Tx, msg.Payload)
+	msg = receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.TxCommand, msg.Payload.CommandName())
+	s.Empty(msg.Payload.(*message.TxPayload).TransactionWitnesses, "transactions asked for with MsgTx should be sent without witnesses")
+	msg = receiveMsg(s.T(), s.peerConn)
+	s.Equal(message.NotFoundCommand, msg.Payload.CommandName())
+	s.Equal([]message.Inventory{
+		{Type: message.MsgWtx, Hash: unannounced.WtxId},
+		{Type: message.MsgWtx, Hash: message.Hash256{0xFF}},
+	}, msg.Payload.(*message.NotFoundPayload).InventoryList)
+}
+
+func (s *PeerTestSuite) TestPeer_QueuedTxsAreNotAnnouncedToPeersNotRelaying() {
+	s.peer.version = &message.VersionPayload{Relay: false}
+	s.peer.mempool = newTestMempool()
+	entry, err := s.peer.mempool.Add(newTestTx(message.Hash256{0x01}, 1000, []byte{0x51}))
+	s.NoError(err)
+	s.peer.queueTx(entry)
+
+	s.NoError(s.peer.announceQueuedTxs())
+	s.False(s.peer.knownTxs.contains(entry.TxId))
+	s.Empty(s.peer.txsToAnnounce)
+}
+
+func (s *PeerTestSuite) TestPeer_UnsolicitedAddrsAreRateLimited() {
+	addrMsgCh := make(chan *addrPayloadWithSender, 1)
+	s.peer.addrMsgCh = addrMsgCh
+	s.peer.addrTokens = newTokenBucket(RateLimit{Rate: 0, Burst: 2}, time.Now())
+	go s.peer.Start(context.Background())
+
+	addresses := []message.AddressV2{
+		{Timestamp: 1700000000, Services: message.NodeNetwork, NetworkID: message.NetworkIDIPv4, Addr: []byte{10, 0, 0, 1}, Port: 8333},
+		// only IPv4 and IPv6 addresses are processed
+		{Timestamp: 1700000000, Services: message.NodeNetwork, NetworkID: message.NetworkIDTorV3, Addr: make([]byte, 32), Port: 8333},
+		{Timestamp: 1700000000, Services: message.NodeNetwork, NetworkID: message.NetworkIDIPv4, Addr: []byte{10, 0, 0, 2}, Port: 8333},
+		{Timestamp: 1700000000, Services: message.NodeNetwork, NetworkID: message.NetworkIDIPv4, Addr: []byte{10, 0, 0, 3}, Port: 8333},
+	}
+	addrV2Msg, err := message.NewAddrV2Message(addresses)
+	s.NoError(err)
+	sendMsg(s.T(), s.peerConn, addrV2Msg)
+
+	select {
+	case addrMsg := <-addrMsgCh:
+		s.Len(addrMsg.AddrPayload.AddressList, 2)
+		s.Equal(net.IPv4(10, 0, 0, 1).To16(), addrMsg.AddrPayload.AddressList[0].NetworkAddress.IpAddress)
+		s.Equal(net.IPv4(10, 0, 0, 2).To16(), addrMsg.AddrPayload.AddressList[1].NetworkAddress.IpAddress)
+	case <-time.After(time.Second):
+		s.FailNow("unsolicited addresses were not passed on")
+	}
+}
@@ -0,0 +1,41 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/internal/ratelimit"
+	"github.com/aang114/bitcoin-node/message"
+	"time"
+)
+
+// RateLimit limits how many messages of a command a peer may send: Burst messages at once, refilled at Rate messages per second. It also
+// limits the requests of RPC clients (see rpc.Limits).
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Default receive rate limits per command. Commands without an entry are not rate limited.
+var DefaultRateLimits = map[message.CommandName]RateLimit{
+	message.AddrCommand:    {Rate: 0.5, Burst: 10},
+	message.AddrV2Command:  {Rate: 0.5, Burst: 10},
+	message.GetAddrCommand: {Rate: 0.01, Burst: 3},
+	message.MempoolCommand: {Rate: 0.01, Burst: 3},
+	message.InvCommand:     {Rate: 10, Burst: 200},
+	message.GetDataCommand: {Rate: 10, Burst: 200},
+	message.TxCommand:      {Rate: 100, Burst: 1000},
+	message.BlockCommand:   {Rate: 50, Burst: 1000},
+	message.PingCommand:    {Rate: 1, Burst: 10},
+	message.PongCommand:    {Rate: 1, Burst: 10},
+}
+
+// newTokenBucket returns a token bucket enforcing limit from now
+func newTokenBucket(limit RateLimit, now time.Time) *ratelimit.TokenBucket {
+	return ratelimit.NewTokenBucket(limit.Rate, limit.Burst, now)
+}
+
+func newTokenBuckets(limits map[message.CommandName]RateLimit, now time.Time) map[message.CommandName]*ratelimit.TokenBucket {
+	buckets := make(map[message.CommandName]*ratelimit.TokenBucket, len(limits))
+	for command, limit := range limits {
+		buckets[command] = newTokenBucket(limit, now)
+	}
+	return buckets
+}
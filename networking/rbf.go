@@ -0,0 +1,122 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+)
+
+// Highest sequence number of an input signalling that its transaction may be replaced (https://github.com/bitcoin/bips/blob/master/bip-0125.mediawiki)
+const maxReplaceableSequence = 0xFFFFFFFD
+
+// conflicts returns the transactions of the mempool spending the outputs tx spends, without duplicates
+func (m *Mempool) conflicts(tx *message.TxPayload) []*MempoolEntry {
+	conflicts := make([]*MempoolEntry, 0)
+	seen := make(map[message.Hash256]struct{})
+	for _, txIn := range tx.TransactionInputs {
+		txId, ok := m.spends[txIn.PreviousOutput]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[txId]; ok {
+			continue
+		}
+		seen[txId] = struct{}{}
+		if entry, ok := m.txs.Get(txId); ok {
+			conflicts = append(conflicts, entry)
+		}
+	}
+	return conflicts
+}
+
+// signalsReplaceability reports whether entry, or one of its ancestors in the mempool, opted in to being replaced by having an input with a
+// sequence number of at most maxReplaceableSequence (BIP 125)
+func (m *Mempool) signalsReplaceability(entry *MempoolEntry) bool {
+	for _, e := range append(m.ancestors(entry), entry) {
+		for _, txIn := range e.Tx.TransactionInputs {
+			if txIn.Sequence <= maxReplaceableSequence {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkReplacement checks that replacement may replace conflicts, the transactions of the mempool spending the same outputs as it, following the
+// rules of BIP 125 as Bitcoin Core applies them (https://github.com/bitcoin/bitcoin/blob/v27.0/doc/policy/mempool-replacements.md), and returns
+// the transactions it replaces: conflicts and their descendants. An error wrapping ErrTxConflict is returned if it may not.
+func (m *Mempool) checkReplacement(replacement *MempoolEntry, conflicts []*MempoolEntry) ([]*MempoolEntry, error) {
+	// rule 1: the transactions replaced directly signal replaceability, explicitly or through their ancestors
+	for _, conflict := range conflicts {
+		if !m.signalsReplaceability(conflict) {
+			return nil, fmt.Errorf("%w: transaction %s does not signal replaceability", ErrTxConflict, conflict.TxId)
+		}
+	}
+
+	// rule 5: at most constants.MaxReplacedTxs transactions are replaced
+	replacedTxIds := make(map[message.Hash256]struct{})
+	replaced := make([]*MempoolEntry, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		for _, entry := range append([]*MempoolEntry{conflict}, m.descendants(conflict)...) {
+			if _, ok := replacedTxIds[entry.TxId]; ok {
+				continue
+			}
+			replacedTxIds[entry.TxId] = struct{}{}
+			replaced = append(replaced, entry)
+		}
+	}
+	if len(replaced) > constants.MaxReplacedTxs {
+		return nil, fmt.Errorf("%w: replacing %d transactions, more than %d", ErrTxConflict, len(replaced), constants.MaxReplacedTxs)
+	}
+
+	// rule 2: the only unconfirmed outputs the replacement spends are outputs the transactions replaced directly already spent, and none of
+	// them are outputs of the transactions it replaces
+	conflictParents := make(map[message.Hash256]struct{})
+	for _, conflict := range conflicts {
+		for _, txIn := range conflict.Tx.TransactionInputs {
+			conflictParents[txIn.PreviousOutput.Hash] = struct{}{}
+		}
+	}
+	for _, txIn := range replacement.Tx.TransactionInputs {
+		parentTxId := txIn.PreviousOutput.Hash
+		if _, ok := replacedTxIds[parentTxId]; ok {
+			return nil, fmt.Errorf("%w: replacement spends an output of transaction %s, which it replaces", ErrTxConflict, parentTxId)
+		}
+		if _, ok := m.txs.Get(parentTxId); !ok {
+			continue
+		}
+		if _, ok := conflictParents[parentTxId]; !ok {
+			return nil, fmt.Errorf("%w: replacement spends an output of unconfirmed transaction %s", ErrTxConflict, parentTxId)
+		}
+	}
+
+	if replacement.Fee == nil {
+		return nil, fmt.Errorf("%w: the fee of the replacement is not known", ErrTxConflict)
+	}
+	fee := *replacement.Fee
+	// rule 6: the replacement pays a higher fee rate than each transaction it replaces directly
+	for _, conflict := range conflicts {
+		if conflict.Fee == nil {
+			return nil, fmt.Errorf("%w: the fee of transaction %s is not known", ErrTxConflict, conflict.TxId)
+		}
+		if fee*int64(conflict.VSize) <= *conflict.Fee*int64(replacement.VSize) {
+			return nil, fmt.Errorf("%w: replacement does not pay a higher fee rate than transaction %s", ErrTxConflict, conflict.TxId)
+		}
+	}
+	// rule 3: the replacement pays at least the fees of all the transactions it replaces
+	replacedFees := int64(0)
+	for _, entry := range replaced {
+		if entry.Fee == nil {
+			return nil, fmt.Errorf("%w: the fee of transaction %s is not known", ErrTxConflict, entry.TxId)
+		}
+		replacedFees += *entry.Fee
+	}
+	if fee < replacedFees {
+		return nil, fmt.Errorf("%w: replacement pays %d satoshis of fees, less than the %d of the transactions it replaces", ErrTxConflict, fee, replacedFees)
+	}
+	// rule 4: the additional fees pay for the relay of the replacement at the incremental relay fee rate
+	if minimum := constants.IncrementalRelayFeeRate * int64(replacement.VSize) / 1000; fee-replacedFees < minimum {
+		return nil, fmt.Errorf("%w: replacement pays %d satoshis more than the transactions it replaces, less than %d", ErrTxConflict, fee-replacedFees, minimum)
+	}
+	return replaced, nil
+}
@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// newRBFTestMempool returns a mempool whose transactions can spend outputs 0 to 199 of the confirmed transaction {0x01}, each worth 10000
+// satoshis
+func newRBFTestMempool() *Mempool {
+	m := NewMempool()
+	m.setCoinView(func(outpoint message.OutPoint) (utxo.Coin, bool, error) {
+		if outpoint.Hash != (message.Hash256{0x01}) || outpoint.Index >= 200 {
+			return utxo.Coin{}, false, nil
+		}
+		return utxo.Coin{TxOut: message.TxOut{Value: 10000, PkScript: []byte{0x51}}, Height: 1}, true, nil
+	}, func() int32 { return 1 })
+	return m
+}
+
+// newSpendingTx returns a transaction spending outpoints with sequence number sequence into a single output worth value
+func newSpendingTx(sequence uint32, value int64, outpoints ...message.OutPoint) *message.TxPayload {
+	tx := &message.TxPayload{
+		Version:            2,
+		TransactionOutputs: []message.TxOut{{Value: value, PkScript: []byte{0x51}}},
+	}
+	for _, outpoint := range outpoints {
+		tx.TransactionInputs = append(tx.TransactionInputs, message.TxIn{PreviousOutput: outpoint, SignatureScript: []byte{0x51}, Sequence: sequence})
+	}
+	return tx
+}
+
+func TestMempool_ReplaceByFee(t *testing.T) {
+	confirmed := message.OutPoint{Hash: message.Hash256{0x01}}
+
+	t.Run("a transaction signalling replaceability should be replaced with its descendants by one paying more", func(t *testing.T) {
+		m := newRBFTestMempool()
+		original, err := m.Add(newSpendingTx(maxReplaceableSequence, 9000, confirmed))
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), *original.Fee)
+		child, err := m.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: original.TxId}))
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), *child.Fee, "the fee of a transaction spending the mempool should be known")
+
+		replacement, replaced, err := m.Accept(newSpendingTx(0xFFFFFFFF, 7000, confirmed))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []*MempoolEntry{original, child}, replaced)
+		require.Equal(t, 1, m.Len())
+		_, ok := m.Get(replacement.TxId)
+		require.True(t, ok)
+		_, ok = m.GetByWtxId(original.WtxId)
+		require.False(t, ok)
+	})
+
+	t.Run("replaceability should be inherited from unconfirmed ancestors", func(t *testing.T) {
+		m := newRBFTestMempool()
+		parent, err := m.Add(newSpendingTx(maxReplaceableSequence, 9000, confirmed))
+		require.NoError(t, err)
+		child, err := m.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: parent.TxId}))
+		require.NoError(t, err)
+
+		_, replaced, err := m.Accept(newSpendingTx(0xFFFFFFFF, 6000, message.OutPoint{Hash: parent.TxId}))
+		require.NoError(t, err)
+		require.Equal(t, []*MempoolEntry{child}, replaced)
+	})
+
+	t.Run("replacements breaking the rules should be rejected", func(t *testing.T) {
+		m := newRBFTestMempool()
+		notSignalling, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, confirmed))
+		require.NoError(t, err)
+		signalling, err := m.Add(newSpendingTx(maxReplaceableSequence, 9000, message.OutPoint{Hash: confirmed.Hash, Index: 1}))
+		require.NoError(t, err)
+		unrelated, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: confirmed.Hash, Index: 2}))
+		require.NoError(t, err)
+
+		for name, tx := range map[string]*message.TxPayload{
+			"not signalling":               newSpendingTx(0xFFFFFFFF, 5000, confirmed),
+			"lower fee":                    newSpendingTx(0xFFFFFFFF, 9500, signalling.Tx.TransactionInputs[0].PreviousOutput),
+			"fee increase below relay fee": newSpendingTx(0xFFFFFFFF, 8990, signalling.Tx.TransactionInputs[0].PreviousOutput),
+			"new unconfirmed input": newSpendingTx(0xFFFFFFFF, 5000, signalling.Tx.TransactionInputs[0].PreviousOutput,
+				message.OutPoint{Hash: unrelated.TxId}),
+			"spending the replaced transaction": newSpendingTx(0xFFFFFFFF, 5000, signalling.Tx.TransactionInputs[0].PreviousOutput,
+				message.OutPoint{Hash: signalling.TxId}),
+		} {
+			_, err = m.Add(tx)
+			require.ErrorIs(t, err, ErrTxConflict, name)
+		}
+		for _, entry := range []*MempoolEntry{notSignalling, signalling, unrelated} {
+			_, ok := m.Get(entry.TxId)
+			require.True(t, ok)
+		}
+	})
+
+	t.Run("replacing too many transactions should be rejected", func(t *testing.T) {
+		m := newRBFTestMempool()
+		outpoints := make([]message.OutPoint, 0, constants.MaxReplacedTxs+1)
+		for i := range constants.MaxReplacedTxs + 1 {
+			outpoint := message.OutPoint{Hash: confirmed.Hash, Index: uint32(i)}
+			_, err := m.Add(newSpendingTx(maxReplaceableSequence, 9900, outpoint))
+			require.NoError(t, err)
+			outpoints = append(outpoints, outpoint)
+		}
+
+		_, err := m.Add(newSpendingTx(0xFFFFFFFF, 1000, outpoints...))
+		require.ErrorIs(t, err, ErrTxConflict)
+		require.Equal(t, constants.MaxReplacedTxs+1, m.Len())
+	})
+}
+
+func TestMempool_RemoveBlockTxsRemovesConflicts(t *testing.T) {
+	m := newRBFTestMempool()
+	confirmed := message.OutPoint{Hash: message.Hash256{0x01}}
+	conflict, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, confirmed))
+	require.NoError(t, err)
+	child, err := m.Add(newSpendingTx(0xFFFFFFFF, 8000, message.OutPoint{Hash: conflict.TxId}))
+	require.NoError(t, err)
+	unrelated, err := m.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: confirmed.Hash, Index: 1}))
+	require.NoError(t, err)
+
+	block := &message.BlockPayload{Transactions: []message.TxPayload{*newSpendingTx(0xFFFFFFFF, 5000, confirmed)}}
+	doubleSpends, err := m.removeBlockTxs(block)
+	require.NoError(t, err)
+	require.Len(t, doubleSpends, 1)
+	require.Equal(t, []*MempoolEntry{conflict}, doubleSpends[0].conflicts)
+	require.Equal(t, []message.OutPoint{confirmed}, doubleSpends[0].outpoints)
+	require.ElementsMatch(t, []*MempoolEntry{conflict, child}, doubleSpends[0].evicted)
+	require.Equal(t, 1, m.Len())
+	_, ok := m.Get(unrelated.TxId)
+	require.True(t, ok)
+	// the outputs spent by the removed transactions can be spent again
+	_, err = m.Add(newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: confirmed.Hash, Index: 3}, confirmed))
+	require.NoError(t, err)
+}
@@ -0,0 +1,123 @@
+package networking
+
+import (
+	"cmp"
+	"log"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// RuntimeStats is a snapshot of the state of the node, for debugging a running node without attaching a debugger
+type RuntimeStats struct {
+	Time    time.Time   `json:"time"`
+	Chain   ChainInfo   `json:"chain"`
+	Mempool MempoolInfo `json:"mempool"`
+	Memory  MemoryStats `json:"memory"`
+	// Messages waiting in the channels carrying them from the peers to the node
+	Channels []ChannelDepth   `json:"channels"`
+	Peers    []PeerQueueStats `json:"peers"`
+}
+
+// MemoryStats describes the memory of the process, as the Go runtime reports it
+type MemoryStats struct {
+	// Bytes of the heap objects which are allocated, and number of those objects
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapObjects uint64 `json:"heapObjects"`
+	// Bytes of memory obtained from the operating system
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"numGC"`
+	Goroutines int    `json:"goroutines"`
+}
+
+// ChannelDepth is the number of messages waiting in a channel, and how many it can hold
+type ChannelDepth struct {
+	Name string `json:"name"`
+	Len  int    `json:"len"`
+	Cap  int    `json:"cap"`
+}
+
+// PeerQueueStats describes a connected peer and the messages waiting to be handled by it or written to it
+type PeerQueueStats struct {
+	Id             int64          `json:"id"`
+	Addr           string         `json:"addr"`
+	ConnectionType ConnectionType `json:"connectionType"`
+	PingLatency    time.Duration  `json:"pingLatency"`
+	BytesSent      uint64         `json:"bytesSent"`
+	BytesRecv      uint64         `json:"bytesRecv"`
+	MsgQueue       int            `json:"msgQueue"`
+	WriteQueue     int            `json:"writeQueue"`
+}
+
+// RuntimeStats returns a snapshot of the peers, the active chain, the mempool, the memory of the process and the depths of the node's
+// channels
+func (n *Node) RuntimeStats() (RuntimeStats, error) {
+	chain, err := n.ChainInfo()
+	if err != nil {
+		return RuntimeStats{}, err
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	syncChannels, addrMsgCh := n.syncManager.channels(), n.addrManager.addrMsgs()
+	stats := RuntimeStats{
+		Time:    time.Now(),
+		Chain:   chain,
+		Mempool: n.MempoolInfo(),
+		Memory: MemoryStats{
+			HeapAlloc:   memStats.HeapAlloc,
+			HeapObjects: memStats.HeapObjects,
+			Sys:         memStats.Sys,
+			NumGC:       memStats.NumGC,
+			Goroutines:  runtime.NumGoroutine(),
+		},
+		Channels: []ChannelDepth{
+			{Name: "inv", Len: len(syncChannels.inv), Cap: cap(syncChannels.inv)},
+			{Name: "block", Len: len(syncChannels.blocks), Cap: cap(syncChannels.blocks)},
+			{Name: "headers", Len: len(syncChannels.headers), Cap: cap(syncChannels.headers)},
+			{Name: "tx", Len: len(n.txMsgCh), Cap: cap(n.txMsgCh)},
+			{Name: "addr", Len: len(addrMsgCh), Cap: cap(addrMsgCh)},
+			{Name: "getblocks", Len: len(n.getBlocksMsgCh), Cap: cap(n.getBlocksMsgCh)},
+			{Name: "compactfilter", Len: len(n.compactFilterMsgCh), Cap: cap(n.compactFilterMsgCh)},
+		},
+		Peers: []PeerQueueStats{},
+	}
+	for _, peer := range n.peers.Keys() {
+		info := peer.Info()
+		stats.Peers = append(stats.Peers, PeerQueueStats{
+			Id:             info.Id,
+			Addr:           info.Addr,
+			ConnectionType: info.ConnectionType,
+			PingLatency:    info.PingLatency,
+			BytesSent:      info.BytesSent,
+			BytesRecv:      info.BytesRecv,
+			MsgQueue:       len(peer.msgCh),
+			WriteQueue:     len(peer.writeCh),
+		})
+	}
+	slices.SortFunc(stats.Peers, func(a, b PeerQueueStats) int { return cmp.Compare(a.Id, b.Id) })
+	return stats, nil
+}
+
+// LogRuntimeStats logs the RuntimeStats of the node, and returns them
+func (n *Node) LogRuntimeStats() (RuntimeStats, error) {
+	stats, err := n.RuntimeStats()
+	if err != nil {
+		log.Printf("⚠️ Could not take runtime stats due to error: %s", err)
+		return RuntimeStats{}, err
+	}
+	log.Printf("📊 Chain: height %d (%s), %d headers, initial block download: %t",
+		stats.Chain.Height, stats.Chain.BestBlockHash, stats.Chain.Headers, stats.Chain.InitialBlockDownload)
+	log.Printf("📊 Mempool: %d transactions, %d virtual bytes, %d bytes of memory, minimum fee rate %d sat/kvB",
+		stats.Mempool.Size, stats.Mempool.Bytes, stats.Mempool.Usage, stats.Mempool.MempoolMinFee)
+	log.Printf("📊 Memory: %d MiB of heap in %d objects, %d MiB obtained from the OS, %d garbage collections, %d goroutines",
+		stats.Memory.HeapAlloc/(1024*1024), stats.Memory.HeapObjects, stats.Memory.Sys/(1024*1024), stats.Memory.NumGC, stats.Memory.Goroutines)
+	for _, channel := range stats.Channels {
+		log.Printf("📊 Channel %s: %d/%d messages", channel.Name, channel.Len, channel.Cap)
+	}
+	log.Printf("📊 %d peers", len(stats.Peers))
+	for _, peer := range stats.Peers {
+		log.Printf("📊 Peer %d %s (%s): ping %s, %d bytes sent, %d bytes received, %d messages to handle, %d to write", peer.Id, peer.Addr,
+			peer.ConnectionType, peer.PingLatency, peer.BytesSent, peer.BytesRecv, peer.MsgQueue, peer.WriteQueue)
+	}
+	return stats, nil
+}
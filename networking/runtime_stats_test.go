@@ -0,0 +1,39 @@
+package networking
+
+import (
+	"bytes"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNode_LogRuntimeStats(t *testing.T) {
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	require.NoError(t, node.addBlockToNode(networkingtest.GenesisBlock(t)))
+	_, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	fakePeer.Accept(time.Second)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	stats, err := node.LogRuntimeStats()
+	require.NoError(t, err)
+
+	require.Equal(t, int32(0), stats.Chain.Height)
+	require.Zero(t, stats.Mempool.Size)
+	require.NotZero(t, stats.Memory.HeapAlloc)
+	require.NotZero(t, stats.Memory.Goroutines)
+	require.Len(t, stats.Channels, 7)
+	require.Equal(t, ChannelDepth{Name: "block", Len: 0, Cap: node.tuning.MessageBufferSize}, stats.Channels[1])
+	require.Len(t, stats.Peers, 1)
+	require.Equal(t, int64(0), stats.Peers[0].Id)
+	require.Equal(t, fakePeer.Addr().String(), stats.Peers[0].Addr)
+	require.Contains(t, logged.String(), "📊 Chain: height 0")
+	require.Contains(t, logged.String(), "📊 Channel block: 0/")
+	require.Contains(t, logged.String(), "📊 Peer 0 "+fakePeer.Addr().String())
+}
@@ -30,6 +30,17 @@ func (s *SafeMap[K, V]) Set(k K, v V) {
 	s.m[k] = v
 }
 
+// SetIfAbsent sets k to v only if k is not already present, and reports whether it did so
+func (s *SafeMap[K, V]) SetIfAbsent(k K, v V) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[k]; ok {
+		return false
+	}
+	s.m[k] = v
+	return true
+}
+
 func (s *SafeMap[K, V]) Delete(k K) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
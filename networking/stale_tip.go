@@ -0,0 +1,50 @@
+package networking
+
+import (
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"log"
+	"net"
+	"time"
+)
+
+var ErrNoExtraSyncPeer = errors.New("could not connect to an extra peer to sync from")
+
+// checkForStaleTip connects to an extra peer and asks it for new blocks if no block was added for staleTipTimeout, in case the peers we sync
+// from are stalling or withholding blocks. Another extra peer is connected to if the tip still has not advanced staleTipTimeout later.
+func (n *Node) checkForStaleTip() {
+	lastProgress := time.Unix(0, n.tipProgress.Load())
+	if n.discoveryDisabled || time.Since(lastProgress) < n.staleTipTimeout {
+		return
+	}
+	if !n.syncingFromExtraPeer.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("⚠️ Chain tip has not advanced since %s. Connecting to an extra peer to sync from...", lastProgress.Format(time.RFC3339))
+	go func() {
+		defer n.syncingFromExtraPeer.Store(false)
+		err := n.syncFromExtraPeer()
+		if err != nil {
+			log.Printf("⚠️ Could not sync from an extra peer due to error: %s", err)
+		}
+	}()
+}
+
+// syncFromExtraPeer connects to a peer we are not connected to and sends it a getblocks message
+func (n *Node) syncFromExtraPeer() error {
+	for range constants.MaxExtraSyncPeerAttempts {
+		unconnectedAddr, ok := n.unconnectedAddrs.Pop()
+		if !ok {
+			break
+		}
+		peer, err := n.AddPeer(&net.TCPAddr{IP: unconnectedAddr.IpAddress[:], Port: int(unconnectedAddr.Port)})
+		if err != nil {
+			log.Printf("❌ Could not add extra sync peer %s due to error: %s", unconnectedAddr.String(), err)
+			continue
+		}
+		log.Printf("Syncing from extra peer %s", peer.conn.RemoteAddr())
+		n.tipProgress.Store(time.Now().UnixNano())
+		return n.requestNewBlocksFrom(peer)
+	}
+	return ErrNoExtraSyncPeer
+}
@@ -0,0 +1,39 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_SyncsFromExtraPeerWhenTipIsStale(t *testing.T) {
+	syncPeer, extraPeer := networkingtest.NewFakePeer(t), networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	node.staleTipTimeout = 50 * time.Millisecond
+	_, err := node.AddPeer(syncPeer.Addr())
+	require.NoError(t, err)
+	syncPeer.Accept(time.Second)
+	node.addUnconnectedAddrToNode(TCPAddress{IpAddress: [16]byte(extraPeer.Addr().IP.To16()), Port: uint16(extraPeer.Addr().Port)})
+	go node.Start(context.Background())
+
+	conn := extraPeer.Accept(time.Second)
+	getHeaders := conn.Expect(message.GetHeadersCommand, time.Second).Payload.(*message.GetHeadersPayload)
+	require.Equal(t, []message.Hash256{message.Hash256(constants.GenesisBlockHash)}, getHeaders.BlockLocatorHashes)
+	require.Equal(t, 2, node.peers.Len())
+}
+
+func TestNode_TipIsNotStaleWhileBlocksAreAdded(t *testing.T) {
+	node := newFakePeerNode(t, 20*time.Second)
+	node.staleTipTimeout = time.Hour
+	skipProofOfWork(node)
+	node.tipProgress.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	blocks, _ := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 1, easyBits, 0)
+	require.NoError(t, node.addBlockToNode(&blocks[0]))
+
+	node.checkForStaleTip()
+	require.False(t, node.syncingFromExtraPeer.Load())
+}
@@ -0,0 +1,135 @@
+package networking
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// syncManager downloads the headers and blocks of the best chain from the peers and stores them
+type syncManager interface {
+	manager
+	// channels returns the channels peers pass the inv, headers and block messages they receive to
+	channels() syncChannels
+}
+
+type syncChannels struct {
+	inv     chan<- *invPayloadWithSender
+	headers chan<- *headersPayloadWithSender
+	blocks  chan<- *blockPayloadWithSender
+}
+
+// chainSyncer handles the messages and timers of the sync manager, which Node does
+type chainSyncer interface {
+	handleInvMsg(msg *invPayloadWithSender) error
+	handleHeadersMsg(msg *headersPayloadWithSender) error
+	handleBlockMsg(msg *blockPayloadWithSender) error
+	// requestForNewBlocks asks the peers for the missing blocks of the best header chain and the headers following it
+	requestForNewBlocks() error
+	checkForStaleTip()
+	checkBlockDownloadTimeouts()
+	checkForDownloadStall()
+	checkpointBlocksIfNeeded()
+	flushChainstate()
+	syncIntervals() syncIntervals
+}
+
+// syncIntervals are how often the sync manager does the periodic work of the download
+type syncIntervals struct {
+	// between two requests for new headers and blocks
+	request time.Duration
+	// between two checks for a stale tip
+	staleTipCheck time.Duration
+	// between two checks for blocks in flight for too long and for a stalled download
+	downloadCheck time.Duration
+	// between two checkpoints of the blocks file
+	checkpoint time.Duration
+	// between two flushes of the chainstate
+	chainstateFlush time.Duration
+}
+
+// headersFirstSync is the syncManager downloading the chain headers first in its own goroutine, one message at a time, so that the blocks
+// are connected in the order they are handled. The peers sending invalid messages are disconnected.
+type headersFirstSync struct {
+	syncer       chainSyncer
+	invMsgCh     chan *invPayloadWithSender
+	headersMsgCh chan *headersPayloadWithSender
+	blockMsgCh   chan *blockPayloadWithSender
+}
+
+func newHeadersFirstSync(syncer chainSyncer, bufferSize int) *headersFirstSync {
+	return &headersFirstSync{
+		syncer:       syncer,
+		invMsgCh:     make(chan *invPayloadWithSender, bufferSize),
+		headersMsgCh: make(chan *headersPayloadWithSender, bufferSize),
+		blockMsgCh:   make(chan *blockPayloadWithSender, bufferSize),
+	}
+}
+
+func (s *headersFirstSync) channels() syncChannels {
+	return syncChannels{inv: s.invMsgCh, headers: s.headersMsgCh, blocks: s.blockMsgCh}
+}
+
+func (s *headersFirstSync) run(ctx context.Context, quitCh <-chan struct{}) {
+	intervals := s.syncer.syncIntervals()
+	requestTicker := time.NewTicker(intervals.request)
+	defer requestTicker.Stop()
+	staleTipTicker := time.NewTicker(intervals.staleTipCheck)
+	defer staleTipTicker.Stop()
+	downloadTicker := time.NewTicker(intervals.downloadCheck)
+	defer downloadTicker.Stop()
+	checkpointTicker := time.NewTicker(intervals.checkpoint)
+	defer checkpointTicker.Stop()
+	chainstateFlushTicker := time.NewTicker(intervals.chainstateFlush)
+	defer chainstateFlushTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-quitCh:
+			return
+		case <-requestTicker.C:
+			log.Printf("[headersFirstSync] Executing requestForNewBlocks()...")
+			err := s.syncer.requestForNewBlocks()
+			if err != nil {
+				log.Printf("[headersFirstSync] requestForNewBlocks() failed with error %s", err)
+			} else {
+				log.Printf("[headersFirstSync] requestForNewBlocks() executed successfully")
+			}
+		case <-staleTipTicker.C:
+			s.syncer.checkForStaleTip()
+		case <-downloadTicker.C:
+			s.syncer.checkBlockDownloadTimeouts()
+			s.syncer.checkForDownloadStall()
+		case <-checkpointTicker.C:
+			s.syncer.checkpointBlocksIfNeeded()
+		case <-chainstateFlushTicker.C:
+			s.syncer.flushChainstate()
+		case invMsg := <-s.invMsgCh:
+			log.Printf("[headersFirstSync] Executing handleInvMsg()...")
+			err := s.syncer.handleInvMsg(invMsg)
+			if err != nil {
+				log.Printf("[headersFirstSync] Quitting peer %s due to error %s", invMsg.Sender.conn.RemoteAddr(), err)
+				invMsg.Sender.Quit()
+			} else {
+				log.Printf("[headersFirstSync] handleInvMsg() executed successfully")
+			}
+		case headersMsg := <-s.headersMsgCh:
+			err := s.syncer.handleHeadersMsg(headersMsg)
+			if err != nil {
+				log.Printf("[headersFirstSync] Quitting peer %s due to error %s", headersMsg.Sender.conn.RemoteAddr(), err)
+				headersMsg.Sender.Quit()
+			}
+		case blockMsg := <-s.blockMsgCh:
+			log.Printf("[headersFirstSync] Executing handleBlockMsg()...")
+			err := s.syncer.handleBlockMsg(blockMsg)
+			if err != nil {
+				log.Printf("[headersFirstSync] Quitting peer %s due to error %s", blockMsg.Sender.conn.RemoteAddr(), err)
+				blockMsg.Sender.Quit()
+			} else {
+				log.Printf("[headersFirstSync] handleBlockMsg() executed successfully")
+			}
+		}
+	}
+}
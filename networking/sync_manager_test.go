@@ -0,0 +1,125 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeChainSyncer records the messages and the periodic work it is passed, and fails to handle the messages of the peers in invalid
+type fakeChainSyncer struct {
+	handled   chan any
+	requested chan struct{}
+	checked   chan string
+	invalid   map[*Peer]bool
+}
+
+func newFakeChainSyncer() *fakeChainSyncer {
+	return &fakeChainSyncer{
+		handled:   make(chan any, 10),
+		requested: make(chan struct{}, 1),
+		checked:   make(chan string, 10),
+		invalid:   make(map[*Peer]bool),
+	}
+}
+
+func (f *fakeChainSyncer) handle(msg any, sender *Peer) error {
+	f.handled <- msg
+	if f.invalid[sender] {
+		return errors.New("invalid message")
+	}
+	return nil
+}
+
+func (f *fakeChainSyncer) handleInvMsg(msg *invPayloadWithSender) error {
+	return f.handle(msg, msg.Sender)
+}
+
+func (f *fakeChainSyncer) handleHeadersMsg(msg *headersPayloadWithSender) error {
+	return f.handle(msg, msg.Sender)
+}
+
+func (f *fakeChainSyncer) handleBlockMsg(msg *blockPayloadWithSender) error {
+	return f.handle(msg, msg.Sender)
+}
+
+func (f *fakeChainSyncer) requestForNewBlocks() error {
+	select {
+	case f.requested <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (f *fakeChainSyncer) check(name string) {
+	select {
+	case f.checked <- name:
+	default:
+	}
+}
+
+func (f *fakeChainSyncer) checkForStaleTip()           { f.check("stale tip") }
+func (f *fakeChainSyncer) checkBlockDownloadTimeouts() { f.check("download timeouts") }
+func (f *fakeChainSyncer) checkForDownloadStall()      { f.check("download stall") }
+func (f *fakeChainSyncer) checkpointBlocksIfNeeded()   { f.check("checkpoint") }
+func (f *fakeChainSyncer) flushChainstate()            { f.check("flush") }
+
+func (f *fakeChainSyncer) syncIntervals() syncIntervals {
+	return syncIntervals{request: 10 * time.Millisecond, staleTipCheck: time.Hour, downloadCheck: time.Hour, checkpoint: time.Hour,
+		chainstateFlush: time.Hour}
+}
+
+// newUnstartedPeer returns a peer connected to a local listener, which nothing is read from or written to
+func newUnstartedPeer(t *testing.T) *Peer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	peer, err := newPeer(conn, nil, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(peer.Quit)
+	return peer
+}
+
+func TestHeadersFirstSync_Run(t *testing.T) {
+	syncer := newFakeChainSyncer()
+	sync := newHeadersFirstSync(syncer, 10)
+	valid, invalid := newUnstartedPeer(t), newUnstartedPeer(t)
+	syncer.invalid[invalid] = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sync.run(ctx, make(chan struct{}))
+	}()
+
+	<-syncer.requested
+	channels := sync.channels()
+	inv := &invPayloadWithSender{InvPayload: &message.InvPayload{}, Sender: valid}
+	headers := &headersPayloadWithSender{HeadersPayload: &message.HeadersPayload{}, Sender: valid}
+	block := &blockPayloadWithSender{BlockPayload: &message.BlockPayload{}, Sender: invalid}
+	channels.inv <- inv
+	require.Equal(t, any(inv), <-syncer.handled)
+	channels.headers <- headers
+	require.Equal(t, any(headers), <-syncer.handled)
+	channels.blocks <- block
+	require.Equal(t, any(block), <-syncer.handled)
+
+	// the peer sending an invalid message is disconnected
+	select {
+	case <-invalid.QuitCh:
+	case <-time.After(time.Second):
+		t.Fatal("peer sending an invalid block was not disconnected")
+	}
+	require.False(t, valid.HasQuit)
+	require.Empty(t, syncer.checked)
+
+	cancel()
+	<-done
+}
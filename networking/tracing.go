@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/tracing"
+	"io"
+	"time"
+)
+
+// receivedMessage is a message read from a peer, with the span of its reception which the spans of its processing are children of
+type receivedMessage struct {
+	msg   *message.Message
+	trace tracing.SpanContext
+}
+
+// arrivalReader records when the first byte of each message is read, so that the span of its reception does not include the time waited for
+// it
+type arrivalReader struct {
+	r     io.Reader
+	first time.Time
+}
+
+func (a *arrivalReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 && a.first.IsZero() {
+		a.first = time.Now()
+	}
+	return n, err
+}
+
+// reset prepares the reader for the next message
+func (a *arrivalReader) reset() {
+	a.first = time.Time{}
+}
+
+func (p *Peer) spanAttribute() tracing.Attribute {
+	return tracing.String("peer", p.conn.RemoteAddr().String())
+}
+
+func blockSpanAttributes(hash message.Hash256, height int32) []tracing.Attribute {
+	return []tracing.Attribute{tracing.String("hash", hash.String()), tracing.Int64("height", int64(height))}
+}
@@ -0,0 +1,55 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/aang114/bitcoin-node/tracing"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the spans it is asked to export
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *recordingExporter) Export(spans []*tracing.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestNode_TracesBlockProcessing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer(exporter)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	fakePeer := networkingtest.NewFakePeer(t)
+	node := newFakePeerNode(t, 20*time.Second)
+	peer, err := node.AddPeer(fakePeer.Addr())
+	require.NoError(t, err)
+	fakePeer.Accept(time.Second)
+	blocks, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), 1, easyBits, 0)
+
+	handle := tracing.Start(tracing.SpanContext{}, "peer.handle")
+	require.NoError(t, node.handleBlockMsg(&blockPayloadWithSender{BlockPayload: &blocks[0], Sender: peer, ReceivedAt: time.Now(), Trace: handle.SpanContext()}))
+	handle.End()
+	tracer.Shutdown()
+
+	spans := make(map[string]*tracing.Span)
+	for _, span := range exporter.spans {
+		spans[span.Name] = span
+	}
+	require.Equal(t, handle.Context.SpanID, spans["node.handle_block"].Parent)
+	require.Equal(t, []tracing.Attribute{tracing.String("peer", fakePeer.Addr().String()), tracing.String("hash", hashes[0].String())},
+		spans["node.handle_block"].Attributes)
+	require.Equal(t, spans["node.handle_block"].Context.SpanID, spans["chain.connect_block"].Parent)
+	require.Equal(t, handle.Context.TraceID, spans["chain.connect_block"].Context.TraceID)
+	require.Equal(t, blockSpanAttributes(hashes[0], 1), spans["chain.connect_block"].Attributes)
+}
@@ -0,0 +1,23 @@
+package networking
+
+import (
+	"context"
+	"net"
+)
+
+// Conn is the transport messages are exchanged with a peer over. Besides TCP connections, it can be a connection through a proxy, an
+// encrypted transport or an in-memory connection, as long as its addresses are *net.TCPAddr. Peers are identified by their remote address, so
+// connections through a proxy should report the address that was dialed rather than the proxy's.
+type Conn interface {
+	net.Conn
+}
+
+// Dialer opens outbound connections to peers. *net.Dialer is one, and proxies and custom resolvers can be plugged in by implementing it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// SetDialer makes the node open outbound connections with dialer instead of dialing TCP directly (it must be called before any peer is added)
+func (n *Node) SetDialer(dialer Dialer) {
+	n.dialer = dialer
+}
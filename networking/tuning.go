@@ -0,0 +1,104 @@
+package networking
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resources are the CPU and memory available to the node
+type Resources struct {
+	CPUs int
+	// available memory in bytes (0 if unknown)
+	MemoryBytes uint64
+}
+
+// assumed when the available memory cannot be detected
+const defaultMemoryBytes = 2 * 1024 * 1024 * 1024
+
+// largest block the node expects to hold in memory while it is in flight
+const maxBlockSize = 4 * 1024 * 1024
+
+// DetectResources returns the number of usable CPUs and the available memory (read from /proc/meminfo where it exists)
+func DetectResources() Resources {
+	return Resources{CPUs: runtime.GOMAXPROCS(0), MemoryBytes: availableMemory()}
+}
+
+func availableMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemAvailable:    8040864 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemAvailable:" && fields[2] == "kB" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// Tuning holds the node's concurrency knobs
+type Tuning struct {
+	// goroutines hashing and verifying blocks in parallel
+	ValidationWorkers int
+	// buffer length of the channels carrying inv and block messages from the peers to the node
+	MessageBufferSize int
+	// maximum number of blocks in flight across all peers, which is also how far past the first missing block of the best chain blocks are
+	// downloaded
+	MaxBlocksInFlight int
+	// maximum number of outbound connections being dialed at once
+	DialWorkers int
+	// time waited between starting two dials (0 to dial as fast as the workers allow)
+	DialInterval time.Duration
+}
+
+// AutoTuning sizes the knobs for the given resources: validation scales with the CPUs, and the blocks in flight may use up to 1/16 of the memory.
+// Dialing does not depend on the resources, so it is paced the same way everywhere.
+func AutoTuning(r Resources) Tuning {
+	cpus := max(r.CPUs, 1)
+	memory := r.MemoryBytes
+	if memory == 0 {
+		memory = defaultMemoryBytes
+	}
+	return Tuning{
+		ValidationWorkers: cpus,
+		MessageBufferSize: min(max(8*cpus, 16), 256),
+		MaxBlocksInFlight: int(min(max(memory/16/maxBlockSize, 16), 1024)),
+		DialWorkers:       constants.DefaultDialWorkers,
+		DialInterval:      constants.DefaultDialInterval,
+	}
+}
+
+// bounds of the memory the cached unspent outputs may take, in MiB (https://github.com/bitcoin/bitcoin/blob/v27.0/src/txdb.h#L32-L36)
+const (
+	minDBCacheMiB = 4
+	maxDBCacheMiB = 16384
+)
+
+// AutoDBCacheMiB returns the memory, in MiB, the unspent outputs cached in memory may take on a machine with the given resources: a quarter of
+// the memory, or constants.DefaultDBCacheMiB if the memory is not known
+func AutoDBCacheMiB(r Resources) int {
+	if r.MemoryBytes == 0 {
+		return constants.DefaultDBCacheMiB
+	}
+	return int(min(max(r.MemoryBytes/4/(1024*1024), minDBCacheMiB), maxDBCacheMiB))
+}
+
+func (t Tuning) String() string {
+	return fmt.Sprintf("%d validation workers, message buffers of %d, up to %d blocks in flight, %d dial workers starting a dial every %s", t.ValidationWorkers,
+		t.MessageBufferSize, t.MaxBlocksInFlight, t.DialWorkers, t.DialInterval)
+}
@@ -0,0 +1,40 @@
+package networking
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestAutoTuning(t *testing.T) {
+	t.Run("knobs should scale with the resources", func(t *testing.T) {
+		tuning := AutoTuning(Resources{CPUs: 8, MemoryBytes: 8 * 1024 * 1024 * 1024})
+		require.Equal(t, Tuning{ValidationWorkers: 8, MessageBufferSize: 64, MaxBlocksInFlight: 128, DialWorkers: 8, DialInterval: 100 * time.Millisecond}, tuning)
+	})
+
+	t.Run("knobs should be clamped on tiny and huge machines", func(t *testing.T) {
+		require.Equal(t, Tuning{ValidationWorkers: 1, MessageBufferSize: 16, MaxBlocksInFlight: 16, DialWorkers: 8, DialInterval: 100 * time.Millisecond}, AutoTuning(Resources{CPUs: 1, MemoryBytes: 256 * 1024 * 1024}))
+		require.Equal(t, Tuning{ValidationWorkers: 128, MessageBufferSize: 256, MaxBlocksInFlight: 1024, DialWorkers: 8, DialInterval: 100 * time.Millisecond}, AutoTuning(Resources{CPUs: 128, MemoryBytes: 1024 * 1024 * 1024 * 1024}))
+	})
+
+	t.Run("unknown memory should fall back to a default", func(t *testing.T) {
+		require.Equal(t, 32, AutoTuning(Resources{CPUs: 0}).MaxBlocksInFlight)
+	})
+}
+
+func TestAutoDBCacheMiB(t *testing.T) {
+	require.Equal(t, 2048, AutoDBCacheMiB(Resources{MemoryBytes: 8 * 1024 * 1024 * 1024}))
+	require.Equal(t, 4, AutoDBCacheMiB(Resources{MemoryBytes: 8 * 1024 * 1024}))
+	require.Equal(t, 16384, AutoDBCacheMiB(Resources{MemoryBytes: 1024 * 1024 * 1024 * 1024}))
+	// unknown memory falls back to Bitcoin Core's default
+	require.Equal(t, 450, AutoDBCacheMiB(Resources{}))
+}
+
+func TestHashBlocks(t *testing.T) {
+	blocks, expected := createChain(t, 10)
+	for _, workers := range []int{0, 1, 3, 16} {
+		hashes, err := hashBlocks(blocks, workers)
+		require.NoError(t, err)
+		require.Equal(t, expected, hashes)
+	}
+}
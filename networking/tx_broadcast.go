@@ -0,0 +1,129 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"log"
+)
+
+// ErrMaxFeeExceeded is the error a transaction submitted to the node is rejected with when it pays a higher fee rate than the submitter
+// allowed, which usually means that it was built with a wrong amount
+var ErrMaxFeeExceeded = errors.New("fee exceeds maximum")
+
+// TxRejectReason tells why a transaction submitted to the node was rejected, in the words of the reject reasons of bitcoind where it has one
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp)
+type TxRejectReason string
+
+const (
+	// some of the outputs the transaction spends are neither unspent outputs of the active chain nor outputs of the mempool
+	RejectMissingInputs TxRejectReason = "bad-txns-inputs-missingorspent"
+	// the script of an input failed
+	RejectScriptFailure TxRejectReason = "mandatory-script-verify-flag-failed"
+	// the fee of the transaction could not be worked out, so it cannot be shown to pay enough
+	RejectUnknownFee TxRejectReason = "fee-unknown"
+	// the transaction pays a higher fee rate than the submitter allowed
+	RejectMaxFeeExceeded TxRejectReason = "max-fee-exceeded"
+	// the transaction breaks the consensus rules in other ways
+	RejectInvalid TxRejectReason = "invalid-transaction"
+	// the transaction is valid but the mempool does not accept it, e.g. as it creates dust or conflicts with its transactions
+	RejectPolicy TxRejectReason = "mempool-policy"
+)
+
+// ErrTxRejected is the error SubmitTransaction fails with when it rejects the transaction, which is then not broadcast
+type ErrTxRejected struct {
+	TxId   message.Hash256
+	Reason TxRejectReason
+	// the error of the check the transaction failed, like ErrMissingInputs or script.ErrScriptFailed
+	Err error
+}
+
+func (e *ErrTxRejected) Error() string {
+	return fmt.Sprintf("transaction %s rejected (%s): %s", e.TxId, e.Reason, e.Err)
+}
+
+func (e *ErrTxRejected) Unwrap() error {
+	return e.Err
+}
+
+// rejectTx returns the error SubmitTransaction rejects the transaction with txId with when it failed a check with err, or err itself if it
+// did not fail a check but could not be checked, e.g. as the outputs it spends could not be read
+func rejectTx(txId message.Hash256, err error) error {
+	var reason TxRejectReason
+	switch {
+	case errors.Is(err, ErrMissingInputs):
+		reason = RejectMissingInputs
+	case errors.Is(err, script.ErrScriptFailed):
+		reason = RejectScriptFailure
+	case errors.Is(err, ErrUnknownFee):
+		reason = RejectUnknownFee
+	case errors.Is(err, ErrMaxFeeExceeded):
+		reason = RejectMaxFeeExceeded
+	case errors.Is(err, ErrInvalidTx):
+		reason = RejectInvalid
+	case errors.Is(err, ErrTxConflict), errors.Is(err, ErrMempoolMinFee), errors.Is(err, ErrMempoolFull), errors.Is(err, ErrTooLongMempoolChain),
+		errors.Is(err, ErrDust), errors.Is(err, ErrMinRelayFee):
+		reason = RejectPolicy
+	default:
+		return err
+	}
+	return &ErrTxRejected{TxId: txId, Reason: reason, Err: err}
+}
+
+// SubmitTransaction adds tx, which was submitted to the node rather than sent by a peer, to the mempool, announces it to the peers and
+// returns its txid. A transaction already in the mempool is announced again rather than rejected
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/node/transaction.cpp#L33-L148). It is rejected with an ErrTxRejected, wrapping
+// ErrMaxFeeExceeded if it pays a fee rate above maxFeeRate satoshis per 1000 virtual bytes (0 for no limit) and otherwise the error the
+// mempool rejects it with, and only transactions the mempool accepted are broadcast.
+func (n *Node) SubmitTransaction(tx *message.TxPayload, maxFeeRate int64) (message.Hash256, error) {
+	txId, err := tx.GetTxId()
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	if entry, ok := n.mempool.Get(txId); ok {
+		n.relayTx(entry, nil)
+		return txId, nil
+	}
+	if maxFeeRate > 0 {
+		err = n.mempool.checkMaxFeeRate(tx, maxFeeRate)
+		if err != nil {
+			return message.Hash256{}, rejectTx(txId, err)
+		}
+	}
+	d, isDoubleSpend := n.mempool.checkDoubleSpend(tx)
+	entry, replaced, err := n.mempool.Accept(tx)
+	if isDoubleSpend && !errors.Is(err, ErrInvalidTx) {
+		d.evicted = replaced
+		n.publishDoubleSpend(d, nil, message.Hash256{})
+	}
+	if err != nil {
+		return message.Hash256{}, rejectTx(txId, err)
+	}
+	log.Printf("➕ Added transaction %s submitted to the node to the mempool", entry.TxId)
+	for _, r := range replaced {
+		log.Printf("🔁 Transaction %s replaced transaction %s in the mempool", entry.TxId, r.TxId)
+	}
+	n.publishNewTx(entry, replaced, nil)
+	n.relayTx(entry, nil)
+	return txId, nil
+}
+
+// checkMaxFeeRate fails with ErrMaxFeeExceeded if tx pays a fee rate above maxFeeRate satoshis per 1000 virtual bytes, and with the error of
+// Mempool.checkInputs if its fee cannot be worked out
+func (m *Mempool) checkMaxFeeRate(tx *message.TxPayload, maxFeeRate int64) error {
+	_, vSize, err := txSizes(tx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	_, fee, err := m.checkInputs(tx)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if maxFee := maxFeeRate * int64(vSize) / 1000; fee > maxFee {
+		return fmt.Errorf("%w: fee of %d satoshis is above %d satoshis", ErrMaxFeeExceeded, fee, maxFee)
+	}
+	return nil
+}
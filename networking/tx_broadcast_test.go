@@ -0,0 +1,104 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/events"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_SubmitTransaction(t *testing.T) {
+	node, _, peers, conns := newDownloadTestNode(t, 0)
+	node.mempool = newRBFTestMempool()
+	for _, peer := range peers {
+		peer.version.Relay = true
+		peer.mempool = node.mempool
+	}
+	confirmed := message.OutPoint{Hash: message.Hash256{0x01}}
+	newTxs := node.Events().Subscribe(10, events.TopicNewTx)
+	defer newTxs.Unsubscribe()
+
+	tx := newSpendingTx(maxReplaceableSequence, 9000, confirmed)
+	txId, err := node.SubmitTransaction(tx, 0)
+	require.NoError(t, err)
+	expectedTxId, err := tx.GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, expectedTxId, txId)
+	require.Equal(t, 1, node.mempool.Len())
+	event := <-newTxs.C
+	newTx := event.Data.(events.NewTx)
+	require.Equal(t, txId.String(), newTx.TxId)
+	require.Empty(t, newTx.Peer)
+	require.Empty(t, newTx.Replaced)
+	for _, peer := range peers {
+		require.NoError(t, peer.announceQueuedTxs())
+		inv := conns[peer].Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+		require.Equal(t, []message.Inventory{{Type: message.MsgTx, Hash: txId}}, inv.InventoryList, "every peer should be announced the transaction")
+	}
+
+	t.Run("a transaction already in the mempool should be accepted again", func(t *testing.T) {
+		resubmitted, err := node.SubmitTransaction(tx, 0)
+		require.NoError(t, err)
+		require.Equal(t, txId, resubmitted)
+		require.Equal(t, 1, node.mempool.Len())
+	})
+
+	t.Run("a transaction paying more than the maximum fee rate should be rejected", func(t *testing.T) {
+		expensive := newSpendingTx(0xFFFFFFFF, 1000, message.OutPoint{Hash: message.Hash256{0x01}, Index: 1})
+		_, err := node.SubmitTransaction(expensive, 1000)
+		require.ErrorIs(t, err, ErrMaxFeeExceeded)
+		var rejected *ErrTxRejected
+		require.ErrorAs(t, err, &rejected)
+		require.Equal(t, RejectMaxFeeExceeded, rejected.Reason)
+		require.Equal(t, 1, node.mempool.Len())
+		_, err = node.SubmitTransaction(expensive, 0)
+		require.NoError(t, err)
+	})
+
+	t.Run("transactions failing the checks of the mempool should be rejected with the reason and not broadcast", func(t *testing.T) {
+		for _, peer := range peers {
+			require.NoError(t, peer.announceQueuedTxs())
+		}
+		failingScript := newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x01}, Index: 2})
+		failingScript.TransactionInputs[0].SignatureScript = []byte{0x6a}
+		for reason, tx := range map[TxRejectReason]*message.TxPayload{
+			RejectMissingInputs: newSpendingTx(0xFFFFFFFF, 9000, message.OutPoint{Hash: message.Hash256{0x02}}),
+			RejectScriptFailure: failingScript,
+			RejectInvalid:       newSpendingTx(0xFFFFFFFF, 20000, message.OutPoint{Hash: message.Hash256{0x01}, Index: 2}),
+			RejectPolicy:        newSpendingTx(0xFFFFFFFF, 9000, confirmed),
+		} {
+			// with a maximum fee rate, the fee is worked out before the mempool checks the transaction
+			_, err := node.SubmitTransaction(tx, 1_000_000)
+			var rejected *ErrTxRejected
+			require.ErrorAs(t, err, &rejected, reason)
+			require.Equal(t, reason, rejected.Reason)
+			txId, err := tx.GetTxId()
+			require.NoError(t, err)
+			require.Equal(t, txId, rejected.TxId)
+			_, ok := node.mempool.Get(txId)
+			require.False(t, ok)
+		}
+		for _, peer := range peers {
+			peer.txRelayMu.Lock()
+			require.Empty(t, peer.txsToAnnounce, "rejected transactions should not be announced")
+			peer.txRelayMu.Unlock()
+		}
+	})
+
+	t.Run("double spends submitted to the node should be published and rejected with the mempool's error", func(t *testing.T) {
+		subscription := node.Events().Subscribe(10, events.TopicDoubleSpend)
+		defer subscription.Unsubscribe()
+		_, err := node.SubmitTransaction(newSpendingTx(0xFFFFFFFF, 8999, confirmed), 0)
+		require.ErrorIs(t, err, ErrTxConflict)
+		select {
+		case event := <-subscription.C:
+			doubleSpend := event.Data.(events.DoubleSpend)
+			require.Equal(t, []string{txId.String()}, doubleSpend.Conflicts)
+			require.Empty(t, doubleSpend.Peer)
+			require.Empty(t, doubleSpend.BlockHash)
+		case <-time.After(time.Second):
+			t.Fatal("no double spend event was published")
+		}
+	})
+}
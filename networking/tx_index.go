@@ -0,0 +1,170 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+)
+
+var (
+	ErrTxIndexDisabled = errors.New("transaction index is disabled")
+	ErrTxNotFound      = errors.New("transaction is not in the active chain")
+)
+
+// Key prefixes of the TxIndex
+const (
+	// followed by a txid, the hash of the block of the active chain holding the transaction, followed by the offset and length of the
+	// transaction in the serialized block as little-endian uint32s
+	txIndexTxPrefix byte = 't'
+	// followed by the hash of a block whose transactions are indexed
+	txIndexBlockPrefix byte = 'b'
+)
+
+// Size of the header of a serialized block, which is followed by the number of transactions
+const blockHeaderSize = 80
+
+// TxIndex maps the txid of every transaction of the active chain to the block holding it and the position of the transaction in the
+// serialized block (https://github.com/bitcoin/bitcoin/blob/v27.0/src/index/txindex.cpp), so that any transaction can be looked up
+type TxIndex struct {
+	kv *storage.KV
+}
+
+// TxLocation is where a transaction is in the serialized block holding it
+type TxLocation struct {
+	Block  message.Hash256
+	Offset uint32
+	Length uint32
+}
+
+// OpenTxIndex opens (or creates) the transaction index whose key-value store is at path in fsys
+func OpenTxIndex(fsys storage.FS, path string) (*TxIndex, error) {
+	kv, err := storage.OpenKV(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return &TxIndex{kv: kv}, nil
+}
+
+func txIndexKey(prefix byte, hash message.Hash256) []byte {
+	return append([]byte{prefix}, hash[:]...)
+}
+
+// HasBlock reports whether the transactions of the block with hash hash are indexed
+func (x *TxIndex) HasBlock(hash message.Hash256) bool {
+	return x.kv.Has(txIndexKey(txIndexBlockPrefix, hash))
+}
+
+// ConnectBlock indexes the transactions of block, whose hash is hash
+func (x *TxIndex) ConnectBlock(hash message.Hash256, block *message.BlockPayload) error {
+	encodedCount, err := message.VarInt(len(block.Transactions)).Encode()
+	if err != nil {
+		return err
+	}
+	offset := blockHeaderSize + len(encodedCount)
+	batch := &storage.KVBatch{}
+	for _, tx := range block.Transactions {
+		encodedTx, err := tx.Encode()
+		if err != nil {
+			return err
+		}
+		txId, err := tx.GetTxId()
+		if err != nil {
+			return err
+		}
+		location := binary.LittleEndian.AppendUint32(append([]byte{}, hash[:]...), uint32(offset))
+		location = binary.LittleEndian.AppendUint32(location, uint32(len(encodedTx)))
+		batch.Put(txIndexKey(txIndexTxPrefix, txId), location)
+		offset += len(encodedTx)
+	}
+	batch.Put(txIndexKey(txIndexBlockPrefix, hash), nil)
+	return x.kv.Write(batch)
+}
+
+// DisconnectBlock removes the transactions of block, whose hash is hash, from the index
+func (x *TxIndex) DisconnectBlock(hash message.Hash256, block *message.BlockPayload) error {
+	batch := &storage.KVBatch{}
+	for _, tx := range block.Transactions {
+		txId, err := tx.GetTxId()
+		if err != nil {
+			return err
+		}
+		batch.Delete(txIndexKey(txIndexTxPrefix, txId))
+	}
+	batch.Delete(txIndexKey(txIndexBlockPrefix, hash))
+	return x.kv.Write(batch)
+}
+
+// Get returns where the transaction with txid txId is, or ErrTxNotFound
+func (x *TxIndex) Get(txId message.Hash256) (TxLocation, error) {
+	encoded, err := x.kv.Get(txIndexKey(txIndexTxPrefix, txId))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return TxLocation{}, fmt.Errorf("%w: %s", ErrTxNotFound, txId)
+	}
+	if err != nil {
+		return TxLocation{}, err
+	}
+	if len(encoded) != 40 {
+		return TxLocation{}, fmt.Errorf("invalid location of transaction %s: %x", txId, encoded)
+	}
+	return TxLocation{
+		Block:  message.Hash256(encoded[:32]),
+		Offset: binary.LittleEndian.Uint32(encoded[32:36]),
+		Length: binary.LittleEndian.Uint32(encoded[36:40]),
+	}, nil
+}
+
+func (x *TxIndex) Close() error {
+	return x.kv.Close()
+}
+
+// SetTxIndex makes the node index the transactions of the active chain in index, so that they can be looked up with GetTransaction. It must
+// be called before Start.
+func (n *Node) SetTxIndex(index *TxIndex) {
+	n.txIndex = index
+}
+
+// GetTransaction returns the transaction of the active chain with txid txId and the hash of the block holding it. It fails with
+// ErrTxIndexDisabled if the node has no transaction index.
+func (n *Node) GetTransaction(txId message.Hash256) (*message.TxPayload, message.Hash256, error) {
+	if n.txIndex == nil {
+		return nil, message.Hash256{}, ErrTxIndexDisabled
+	}
+	location, err := n.txIndex.Get(txId)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	node, ok := n.blockIndex.Get(location.Block)
+	if !ok {
+		return nil, message.Hash256{}, fmt.Errorf("%w: block %s of transaction %s is not known", ErrTxNotFound, location.Block, txId)
+	}
+	block, err := n.blockIndex.BlockData(node)
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	encoded, err := block.Encode()
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	if int(location.Offset)+int(location.Length) > len(encoded) {
+		return nil, message.Hash256{}, fmt.Errorf("%w: transaction %s is past the end of block %s", ErrTxNotFound, txId, location.Block)
+	}
+	tx, err := message.DecodeTxPayload(bytes.NewReader(encoded[location.Offset : location.Offset+location.Length]))
+	if err != nil {
+		return nil, message.Hash256{}, err
+	}
+	return tx, location.Block, nil
+}
+
+func (n *Node) closeTxIndex() error {
+	if n.txIndex == nil {
+		return nil
+	}
+	err := n.txIndex.Close()
+	if err != nil {
+		return fmt.Errorf("could not close the transaction index: %w", err)
+	}
+	return nil
+}
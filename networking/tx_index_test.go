@@ -0,0 +1,68 @@
+package networking
+
+import (
+	"context"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNode_GetTransaction(t *testing.T) {
+	blocks, hashes := createSnapshotChain(t, constants.CoinbaseMaturity+2)
+	last := len(blocks) - 1
+	coinbase := blocks[0].Transactions[0]
+	coinbaseId, err := coinbase.GetTxId()
+	require.NoError(t, err)
+	// spends the coinbase of the first block in the last, once it has matured, so that a transaction after the first one of a block is looked up
+	spend := message.TxPayload{
+		Version:              1,
+		TransactionInputs:    []message.TxIn{{PreviousOutput: message.OutPoint{Hash: coinbaseId}, SignatureScript: []byte{}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs:   []message.TxOut{{Value: 50, PkScript: []byte{0x51}}},
+		TransactionWitnesses: []message.TxWitness{},
+	}
+	blocks[last].Transactions = append(blocks[last].Transactions, spend)
+	spendId, err := spend.GetTxId()
+	require.NoError(t, err)
+
+	fs := storage.NewMemFS()
+	node := newBlockStoreNode(t, fs)
+	for i := range blocks {
+		require.NoError(t, node.persistBlock(hashes[i], &blocks[i]))
+		require.NoError(t, node.addBlockToNode(&blocks[i]))
+	}
+	_, _, err = node.GetTransaction(spendId)
+	require.ErrorIs(t, err, ErrTxIndexDisabled)
+	require.NoError(t, node.Stop(context.Background()))
+
+	t.Run("the transactions of the stored blocks should be indexed when the node is restarted with a transaction index", func(t *testing.T) {
+		restarted := newBlockStoreNode(t, fs)
+		index, err := OpenTxIndex(fs, "txindex.kv")
+		require.NoError(t, err)
+		restarted.SetTxIndex(index)
+		require.NoError(t, restarted.readBlocksFromStore())
+
+		tx, block, err := restarted.GetTransaction(spendId)
+		require.NoError(t, err)
+		require.Equal(t, hashes[last], block)
+		require.Equal(t, &spend, tx)
+		_, block, err = restarted.GetTransaction(coinbaseId)
+		require.NoError(t, err)
+		require.Equal(t, hashes[0], block)
+
+		_, _, err = restarted.GetTransaction(message.Hash256{})
+		require.ErrorIs(t, err, ErrTxNotFound)
+	})
+
+	t.Run("the transactions of a disconnected block should leave the index", func(t *testing.T) {
+		index, err := OpenTxIndex(storage.NewMemFS(), "txindex.kv")
+		require.NoError(t, err)
+		require.NoError(t, index.ConnectBlock(hashes[last], &blocks[last]))
+		require.True(t, index.HasBlock(hashes[last]))
+		require.NoError(t, index.DisconnectBlock(hashes[last], &blocks[last]))
+		require.False(t, index.HasBlock(hashes[last]))
+		_, err = index.Get(spendId)
+		require.ErrorIs(t, err, ErrTxNotFound)
+	})
+}
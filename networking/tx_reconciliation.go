@@ -0,0 +1,256 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/aang114/bitcoin-node/blockfilter"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/internal/minisketch"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrUnexpectedReconciliation = errors.New("unexpected transaction reconciliation message")
+
+// Coefficient the initiator of a reconciliation round estimates the difference between the sets of the peers with (0.25, multiplied by
+// message.QPrecision), and number of elements added to the capacity of sketches on top of the estimate
+// (https://github.com/bitcoin/bips/blob/master/bip-0330.mediawiki)
+const (
+	reconciliationQ            = message.QPrecision / 4
+	reconciliationExtraElement = 1
+)
+
+// txReconciliation is the state of the transaction reconciliation with a peer (BIP 330): instead of being announced, the transactions the
+// peer would be announced are added to a set, which is reconciled with the set of the transactions the peer would announce to us in rounds
+// started by the side that opened the connection. Each side then announces the transactions of its set the other is missing.
+type txReconciliation struct {
+	initiator bool
+	// key of the short ids of the transactions, derived from the salts exchanged in the handshake
+	k0, k1 uint64
+
+	mu sync.Mutex
+	// transactions to be reconciled, by short id
+	set map[uint32]*MempoolEntry
+	// the initiator sent a reqrecon message and is waiting for its sketch
+	requested bool
+	// transactions of the set the responder sent a sketch of, until the round ends with a reconcildiff message
+	snapshot map[uint32]*MempoolEntry
+}
+
+// newTxReconciliation returns the reconciliation state with a peer, with the salts of the sendtxrcncl messages exchanged with it
+func newTxReconciliation(initiator bool, localSalt, remoteSalt uint64) *txReconciliation {
+	// the key is the tagged hash of both salts, the lower first (https://github.com/bitcoin/bips/blob/master/bip-0330.mediawiki)
+	tag := sha256.Sum256([]byte("Tx Relay Salting"))
+	data := append(tag[:], tag[:]...)
+	data = binary.LittleEndian.AppendUint64(data, min(localSalt, remoteSalt))
+	data = binary.LittleEndian.AppendUint64(data, max(localSalt, remoteSalt))
+	key := sha256.Sum256(data)
+	return &txReconciliation{
+		initiator: initiator,
+		k0:        binary.LittleEndian.Uint64(key[0:8]),
+		k1:        binary.LittleEndian.Uint64(key[8:16]),
+		set:       make(map[uint32]*MempoolEntry),
+	}
+}
+
+// shortId returns the 32-bit short id of the transaction whose wtxid is wtxId, which is never 0
+func (r *txReconciliation) shortId(wtxId message.Hash256) uint32 {
+	return uint32(1 + blockfilter.SipHash(r.k0, r.k1, wtxId[:])%math.MaxUint32)
+}
+
+func (r *txReconciliation) add(entry *MempoolEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.set[r.shortId(entry.WtxId)] = entry
+}
+
+// sketch returns the sketch with the given capacity of the short ids of set
+func sketch(set map[uint32]*MempoolEntry, capacity int) *minisketch.Sketch {
+	s := minisketch.New(capacity)
+	for shortId := range set {
+		s.Add(shortId)
+	}
+	return s
+}
+
+// sketchCapacity returns the capacity of the sketch the responder answers a reqrecon message with, which is the estimated size of the difference
+// between the sets of both sides, and 0 if the responder's set is empty so that the initiator announces its whole set
+func sketchCapacity(localSetSize int, remoteSetSize int, q uint16) int {
+	if localSetSize == 0 {
+		return 0
+	}
+	difference := max(localSetSize, remoteSetSize) - min(localSetSize, remoteSetSize)
+	estimate := float64(q) / message.QPrecision * float64(min(localSetSize, remoteSetSize))
+	return min(difference+int(estimate)+reconciliationExtraElement, message.MaxSketchCapacity)
+}
+
+// reconciliationLoop starts a reconciliation round with the peer every constants.TxReconciliationInterval, while the previous round is over
+func (p *Peer) reconciliationLoop() {
+	ticker := time.NewTicker(constants.TxReconciliationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.QuitCh:
+			return
+		case <-ticker.C:
+			err := p.requestReconciliation()
+			// a full write queue is already handled by the write queue policy
+			if err != nil && !errors.Is(err, ErrWriteQueueFull) {
+				log.Printf("[reconciliationLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), err)
+				p.Quit()
+				return
+			}
+		}
+	}
+}
+
+// requestReconciliation starts a reconciliation round, sending the peer a reqrecon message with the size of our set, unless a round is
+// already in progress
+func (p *Peer) requestReconciliation() error {
+	r := p.txReconciliation
+	r.mu.Lock()
+	if r.requested {
+		r.mu.Unlock()
+		return nil
+	}
+	r.requested = true
+	setSize := min(len(r.set), math.MaxUint16)
+	r.mu.Unlock()
+
+	return p.sendReqReconMsg(uint16(setSize), reconciliationQ)
+}
+
+// handleReqReconMessage answers the initiator's reqrecon message with the sketch of our set, which is kept until the round ends
+func (p *Peer) handleReqReconMessage(msg *message.Message) error {
+	payload, ok := msg.Payload.(*message.ReqReconPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+	r := p.txReconciliation
+	if r == nil || r.initiator {
+		return ErrUnexpectedReconciliation
+	}
+	r.mu.Lock()
+	if r.snapshot != nil {
+		r.mu.Unlock()
+		return ErrUnexpectedReconciliation
+	}
+	r.snapshot = r.set
+	r.set = make(map[uint32]*MempoolEntry)
+	snapshot := r.snapshot
+	r.mu.Unlock()
+
+	capacity := sketchCapacity(len(snapshot), int(payload.SetSize), payload.Q)
+	return p.sendSketchMsg(sketch(snapshot, capacity).Bytes())
+}
+
+// handleSketchMessage reconciles our set with the responder's sketch: if the difference between the sets can be decoded from the sketches, the
+// transactions the responder is missing are announced to it and the transactions we are missing are asked for in a reconcildiff message.
+// Otherwise our whole set is announced, and the reconcildiff message reports the failure so that the responder announces its whole set.
+func (p *Peer) handleSketchMessage(msg *message.Message) error {
+	payload, ok := msg.Payload.(*message.SketchPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+	r := p.txReconciliation
+	if r == nil || !r.initiator {
+		return ErrUnexpectedReconciliation
+	}
+	theirs, err := minisketch.FromBytes(payload.SketchData)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if !r.requested {
+		r.mu.Unlock()
+		return ErrUnexpectedReconciliation
+	}
+	r.requested = false
+	set := r.set
+	r.set = make(map[uint32]*MempoolEntry)
+	r.mu.Unlock()
+
+	var difference []uint32
+	if theirs.Capacity() > 0 {
+		ours := sketch(set, theirs.Capacity())
+		err = ours.Merge(theirs)
+		if err != nil {
+			return err
+		}
+		difference, err = ours.Decode()
+	}
+	if theirs.Capacity() == 0 || err != nil {
+		if err != nil {
+			log.Printf("⚠️ Could not reconcile transactions with peer %s, announcing %d transactions", p.conn.RemoteAddr(), len(set))
+		}
+		err = p.sendReconcilDiffMsg(false, nil)
+		if err != nil {
+			return err
+		}
+		return p.announceTxs(slices.Collect(maps.Values(set)))
+	}
+
+	missing := make([]*MempoolEntry, 0)
+	askShortIds := make([]uint32, 0)
+	for _, shortId := range difference {
+		if entry, ok := set[shortId]; ok {
+			missing = append(missing, entry)
+			delete(set, shortId)
+		} else {
+			askShortIds = append(askShortIds, shortId)
+		}
+	}
+	// the transactions left are in the responder's set too
+	for _, entry := range set {
+		p.knownTxs.add(entry.TxId, entry.WtxId)
+	}
+	err = p.sendReconcilDiffMsg(true, askShortIds)
+	if err != nil {
+		return err
+	}
+	return p.announceTxs(missing)
+}
+
+// handleReconcilDiffMessage ends the round: the transactions of our sketched set the initiator asked for are announced to it, or all of them if
+// the initiator could not decode the difference
+func (p *Peer) handleReconcilDiffMessage(msg *message.Message) error {
+	payload, ok := msg.Payload.(*message.ReconcilDiffPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+	r := p.txReconciliation
+	if r == nil || r.initiator {
+		return ErrUnexpectedReconciliation
+	}
+	r.mu.Lock()
+	snapshot := r.snapshot
+	r.snapshot = nil
+	r.mu.Unlock()
+	if snapshot == nil {
+		return ErrUnexpectedReconciliation
+	}
+
+	if !payload.Success {
+		return p.announceTxs(slices.Collect(maps.Values(snapshot)))
+	}
+	asked := make([]*MempoolEntry, 0, len(payload.AskShortIds))
+	for _, shortId := range payload.AskShortIds {
+		// short ids that are not in the set may have been decoded by chance
+		if entry, ok := snapshot[shortId]; ok {
+			asked = append(asked, entry)
+			delete(snapshot, shortId)
+		}
+	}
+	// the transactions left are in the initiator's set too
+	for _, entry := range snapshot {
+		p.knownTxs.add(entry.TxId, entry.WtxId)
+	}
+	return p.announceTxs(asked)
+}
@@ -0,0 +1,128 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/internal/minisketch"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTxReconciliation_ShortIdsAreSharedByBothSides(t *testing.T) {
+	initiator := newTxReconciliation(true, 1, 2)
+	responder := newTxReconciliation(false, 2, 1)
+	other := newTxReconciliation(false, 2, 3)
+	wtxId := message.Hash256{0x01, 0x02}
+	require.NotZero(t, initiator.shortId(wtxId))
+	require.Equal(t, initiator.shortId(wtxId), responder.shortId(wtxId))
+	require.NotEqual(t, initiator.shortId(wtxId), other.shortId(wtxId))
+}
+
+// newReconciliationTestPeer returns a node with two transactions in its mempool and a peer it reconciles transactions with, whose side of the
+// reconciliation is played by the returned connection
+func newReconciliationTestPeer(t *testing.T, initiator bool) (*Peer, *networkingtest.Conn, *txReconciliation, []*MempoolEntry) {
+	node, _, peers, conns := newDownloadTestNode(t, 0)
+	useTestCoins(node.mempool)
+	peer := peers[0]
+	peer.version.Relay = true
+	peer.wtxidRelay = true
+	peer.txReconciliation = newTxReconciliation(initiator, 1, 2)
+	entries := make([]*MempoolEntry, 2)
+	for i := range entries {
+		var err error
+		entries[i], err = node.mempool.Add(newTestWitnessTx(message.Hash256{byte(i + 1)}))
+		require.NoError(t, err)
+	}
+	return peer, conns[peer], newTxReconciliation(!initiator, 2, 1), entries
+}
+
+func TestPeer_InitiatesTxReconciliation(t *testing.T) {
+	peer, conn, remote, entries := newReconciliationTestPeer(t, true)
+	peer.queueTx(entries[0])
+	peer.queueTx(entries[1])
+	require.Empty(t, peer.txsToAnnounce, "transactions should be reconciled rather than announced")
+
+	require.NoError(t, peer.requestReconciliation())
+	reqRecon := conn.Expect(message.ReqReconCommand, time.Second).Payload.(*message.ReqReconPayload)
+	require.Equal(t, uint16(2), reqRecon.SetSize)
+
+	// the peer has the second transaction and one we do not have
+	unknown := remote.shortId(message.Hash256{0x03})
+	theirs := minisketch.New(4)
+	theirs.Add(remote.shortId(entries[1].WtxId))
+	theirs.Add(unknown)
+	sketchMsg, err := message.NewSketchMessage(theirs.Bytes())
+	require.NoError(t, err)
+	conn.Send(sketchMsg)
+
+	diff := conn.Expect(message.ReconcilDiffCommand, time.Second).Payload.(*message.ReconcilDiffPayload)
+	require.True(t, diff.Success)
+	require.Equal(t, []uint32{unknown}, diff.AskShortIds)
+	inv := conn.Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+	require.Equal(t, []message.Inventory{{Type: message.MsgWtx, Hash: entries[0].WtxId}}, inv.InventoryList)
+	require.True(t, peer.knownTxs.contains(entries[1].WtxId))
+}
+
+func TestPeer_RespondsToTxReconciliation(t *testing.T) {
+	t.Run("transactions asked for should be announced", func(t *testing.T) {
+		peer, conn, remote, entries := newReconciliationTestPeer(t, false)
+		peer.queueTx(entries[0])
+		peer.queueTx(entries[1])
+
+		reqReconMsg, err := message.NewReqReconMessage(1, message.QPrecision/4)
+		require.NoError(t, err)
+		conn.Send(reqReconMsg)
+		sketchData := conn.Expect(message.SketchCommand, time.Second).Payload.(*message.SketchPayload).SketchData
+		ours, err := minisketch.FromBytes(sketchData)
+		require.NoError(t, err)
+		// the peer has the first transaction
+		theirs := minisketch.New(ours.Capacity())
+		theirs.Add(remote.shortId(entries[0].WtxId))
+		require.NoError(t, ours.Merge(theirs))
+		difference, err := ours.Decode()
+		require.NoError(t, err)
+		require.Equal(t, []uint32{remote.shortId(entries[1].WtxId)}, difference)
+
+		// transactions added after the sketch wait for the next round
+		entry, err := peer.mempool.Add(newTestWitnessTx(message.Hash256{0x03}))
+		require.NoError(t, err)
+		peer.queueTx(entry)
+		reconcilDiffMsg, err := message.NewReconcilDiffMessage(true, difference)
+		require.NoError(t, err)
+		conn.Send(reconcilDiffMsg)
+		inv := conn.Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+		require.Equal(t, []message.Inventory{{Type: message.MsgWtx, Hash: entries[1].WtxId}}, inv.InventoryList)
+		require.True(t, peer.knownTxs.contains(entries[0].WtxId))
+		require.Len(t, peer.txReconciliation.set, 1)
+	})
+
+	t.Run("the whole set should be announced if the difference could not be decoded", func(t *testing.T) {
+		peer, conn, _, entries := newReconciliationTestPeer(t, false)
+		peer.queueTx(entries[0])
+		peer.queueTx(entries[1])
+
+		reqReconMsg, err := message.NewReqReconMessage(0, message.QPrecision/4)
+		require.NoError(t, err)
+		conn.Send(reqReconMsg)
+		conn.Expect(message.SketchCommand, time.Second)
+		reconcilDiffMsg, err := message.NewReconcilDiffMessage(false, nil)
+		require.NoError(t, err)
+		conn.Send(reconcilDiffMsg)
+		inv := conn.Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+		require.ElementsMatch(t, []message.Inventory{{Type: message.MsgWtx, Hash: entries[0].WtxId}, {Type: message.MsgWtx, Hash: entries[1].WtxId}},
+			inv.InventoryList)
+	})
+
+	t.Run("a reconcildiff message outside of a round should quit the peer", func(t *testing.T) {
+		_, conn, _, _ := newReconciliationTestPeer(t, false)
+		reconcilDiffMsg, err := message.NewReconcilDiffMessage(true, nil)
+		require.NoError(t, err)
+		conn.Send(reconcilDiffMsg)
+		select {
+		case <-conn.Closed():
+		case <-time.After(time.Second):
+			t.Fatal("peer was not quit")
+		}
+	})
+}
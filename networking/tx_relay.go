@@ -0,0 +1,221 @@
+package networking
+
+import (
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// knownTxs remembers the txids and wtxids of the transactions a peer sent us or was announced, so that they are not announced to it again.
+// Once capacity hashes were added, the oldest half is forgotten.
+type knownTxs struct {
+	mu       sync.Mutex
+	capacity int
+	current  map[message.Hash256]struct{}
+	previous map[message.Hash256]struct{}
+}
+
+func newKnownTxs(capacity int) *knownTxs {
+	return &knownTxs{
+		capacity: capacity,
+		current:  make(map[message.Hash256]struct{}),
+		previous: make(map[message.Hash256]struct{}),
+	}
+}
+
+func (k *knownTxs) add(hashes ...message.Hash256) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, hash := range hashes {
+		if len(k.current) >= k.capacity/2 {
+			k.previous = k.current
+			k.current = make(map[message.Hash256]struct{})
+		}
+		k.current[hash] = struct{}{}
+	}
+}
+
+func (k *knownTxs) contains(hash message.Hash256) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.current[hash]; ok {
+		return true
+	}
+	_, ok := k.previous[hash]
+	return ok
+}
+
+// relayTx queues the transaction the mempool accepted from source (nil if it was submitted to the node) to be announced to the other peers,
+// which is only called once the mempool found the outputs it spends and ran the scripts of its inputs (see Mempool.Accept). Block-relay-only
+// and feeler connections are never announced transactions.
+func (n *Node) relayTx(entry *MempoolEntry, source *Peer) {
+	if source != nil {
+		source.knownTxs.add(entry.TxId, entry.WtxId)
+	}
+	for _, peer := range n.peers.Keys() {
+		if peer == source || peer.connectionType != FullRelay {
+			continue
+		}
+		peer.queueTx(entry)
+	}
+}
+
+// queueTx queues the transaction to be announced to the peer, or adds it to the set reconciled with the peer if it relays transactions by set
+// reconciliation
+func (p *Peer) queueTx(entry *MempoolEntry) {
+	if p.txReconciliation != nil {
+		p.txReconciliation.add(entry)
+		return
+	}
+	p.txRelayMu.Lock()
+	defer p.txRelayMu.Unlock()
+	p.txsToAnnounce = append(p.txsToAnnounce, entry)
+}
+
+// txInventory returns the inventory the transaction is announced to the peer with, which is its wtxid if the peer negotiated wtxid relay
+func (p *Peer) txInventory(entry *MempoolEntry) message.Inventory {
+	if p.wtxidRelay {
+		return message.Inventory{Type: message.MsgWtx, Hash: entry.WtxId}
+	}
+	return message.Inventory{Type: message.MsgTx, Hash: entry.TxId}
+}
+
+// passesTxFilters reports whether the transaction pays at least the fee rate of the peer's fee filter, if it set one, and matches its bloom
+// filter, if it loaded one
+func passesTxFilters(entry *MempoolEntry, feeFilter int64, bloom *bloomFilter) bool {
+	if feeFilter > 0 {
+		// transactions whose fee is not known cannot be shown to pay enough
+		if feeRate, ok := entry.FeeRate(); !ok || feeRate < feeFilter {
+			return false
+		}
+	}
+	return bloom == nil || bloom.matchesTx(entry.Tx, entry.TxId)
+}
+
+// txAnnounceInterval returns the average time between two announcements of transactions to the peer
+func (p *Peer) txAnnounceInterval() time.Duration {
+	if p.txAnnounceMeanInterval > 0 {
+		return p.txAnnounceMeanInterval
+	}
+	if p.direction == Inbound {
+		return constants.InboundTxAnnounceInterval
+	}
+	return constants.OutboundTxAnnounceInterval
+}
+
+// txAnnounceLoop announces the queued transactions to the peer at exponentially distributed intervals, so that the peers cannot tell which node
+// a transaction came from by the order it reached them in (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp)
+func (p *Peer) txAnnounceLoop() {
+	interval := p.txAnnounceInterval()
+	timer := time.NewTimer(randomTxAnnounceDelay(interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.QuitCh:
+			return
+		case <-timer.C:
+			err := p.announceQueuedTxs()
+			// a full write queue is already handled by the write queue policy
+			if err != nil && !errors.Is(err, ErrWriteQueueFull) {
+				log.Printf("[txAnnounceLoop] Quitting peer %s due to error: %s", p.conn.RemoteAddr(), err)
+				p.Quit()
+				return
+			}
+			timer.Reset(randomTxAnnounceDelay(interval))
+		}
+	}
+}
+
+func randomTxAnnounceDelay(interval time.Duration) time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(interval))
+}
+
+// announceQueuedTxs sends the peer an inv message with up to constants.MaxTxAnnouncements of the queued transactions, the rest waiting for the
+// next announcement
+func (p *Peer) announceQueuedTxs() error {
+	p.txRelayMu.Lock()
+	queued := p.txsToAnnounce
+	if len(queued) > constants.MaxTxAnnouncements {
+		p.txsToAnnounce = queued[constants.MaxTxAnnouncements:]
+		queued = queued[:constants.MaxTxAnnouncements]
+	} else {
+		p.txsToAnnounce = nil
+	}
+	p.txRelayMu.Unlock()
+	return p.announceTxs(queued)
+}
+
+// announceTxs sends the peer an inv message announcing entries. The transactions which left the mempool, which the peer already knows or which
+// its filters exclude are dropped, and so are all of them if the peer asked not to be sent transactions and did not set a bloom filter.
+func (p *Peer) announceTxs(entries []*MempoolEntry) error {
+	bloom := p.bloom.Load()
+	if p.mempool == nil || len(entries) == 0 || (!p.Capabilities().Relay && bloom == nil) {
+		return nil
+	}
+	feeFilter := p.feeFilter.Load()
+	inventories := make([]message.Inventory, 0, len(entries))
+	for _, entry := range entries {
+		if _, ok := p.mempool.Get(entry.TxId); !ok {
+			continue
+		}
+		inventory := p.txInventory(entry)
+		if p.knownTxs.contains(inventory.Hash) || !passesTxFilters(entry, feeFilter, bloom) {
+			continue
+		}
+		p.knownTxs.add(entry.TxId, entry.WtxId)
+		inventories = append(inventories, inventory)
+	}
+	if len(inventories) == 0 {
+		return nil
+	}
+	return p.sendInvMsg(inventories)
+}
+
+// handleGetDataMessage sends the peer the transactions it asks for that are in the mempool, with their witnesses unless they are asked for by
+// txid with MsgTx, and a notfound message listing the other transactions. Transactions are only sent if they were announced to the peer or
+// entered the mempool more than constants.UnconditionalRelayDelay ago, so that the peer cannot find out which transactions the node has before
+// they are announced (https://github.com/bitcoin/bitcoin/blob/v27.0/src/net_processing.cpp). Requests for blocks are ignored.
+func (p *Peer) handleGetDataMessage(msg *message.Message) error {
+	getDataPayload, ok := msg.Payload.(*message.GetDataPayload)
+	if !ok {
+		return ErrInvalidPayload
+	}
+	if p.mempool == nil {
+		return nil
+	}
+
+	notFound := make([]message.Inventory, 0)
+	for _, inventory := range getDataPayload.InventoryList {
+		var entry *MempoolEntry
+		switch inventory.Type {
+		case message.MsgTx, message.MsgWitnessTx:
+			entry, _ = p.mempool.Get(inventory.Hash)
+		case message.MsgWtx:
+			entry, _ = p.mempool.GetByWtxId(inventory.Hash)
+		default:
+			continue
+		}
+		if entry == nil || (!p.knownTxs.contains(inventory.Hash) && time.Since(entry.AddedAt) <= constants.UnconditionalRelayDelay) {
+			notFound = append(notFound, inventory)
+			continue
+		}
+		tx := *entry.Tx
+		if inventory.Type == message.MsgTx {
+			tx.TransactionWitnesses = nil
+		}
+		err := p.sendTxMsg(&tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(notFound) > 0 {
+		return p.sendNotFoundMsg(notFound)
+	}
+	return nil
+}
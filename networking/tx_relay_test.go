@@ -0,0 +1,57 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNode_RelaysAcceptedTxsToOtherPeers(t *testing.T) {
+	node, _, peers, conns := newDownloadTestNode(t, 0)
+	useTestCoins(node.mempool)
+	for _, peer := range peers {
+		peer.version.Relay = true
+	}
+
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})})
+	require.Equal(t, 1, node.mempool.Len())
+	entry := node.mempool.Entries()[0]
+	// the transaction is not announced back to the peer it came from
+	require.True(t, peers[0].knownTxs.contains(entry.TxId))
+
+	require.NoError(t, peers[1].announceQueuedTxs())
+	inv := conns[peers[1]].Expect(message.InvCommand, time.Second).Payload.(*message.InvPayload)
+	require.Equal(t, []message.Inventory{{Type: message.MsgTx, Hash: entry.TxId}}, inv.InventoryList)
+
+	getDataMsg, err := message.NewGetDataMessage(inv.InventoryList)
+	require.NoError(t, err)
+	conns[peers[1]].Send(getDataMsg)
+	tx := conns[peers[1]].Expect(message.TxCommand, time.Second).Payload.(*message.TxPayload)
+	txId, err := tx.GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, entry.TxId, txId)
+}
+
+func TestNode_RelaysOnlyValidatedTxs(t *testing.T) {
+	node, _, peers, _ := newDownloadTestNode(t, 0)
+	useTestCoins(node.mempool)
+	for _, peer := range peers {
+		peer.version.Relay = true
+	}
+	failingScript := newTestTx(message.Hash256{0x01}, 1000, []byte{0x51})
+	failingScript.TransactionInputs[0].SignatureScript = []byte{0x6a}
+	missingInputs := newTestTx(message.Hash256{0x01, 0x02}, 1000, []byte{0x51})
+
+	// a peer sending a transaction whose parents are not known is not punished, as it may send them later
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: missingInputs})
+	require.Zero(t, node.mempool.Len())
+	require.False(t, peers[0].ShouldBan())
+	node.handleTxMsg(&txPayloadWithSender{Sender: peers[0], TxPayload: failingScript})
+	require.Zero(t, node.mempool.Len())
+	require.True(t, peers[0].ShouldBan())
+
+	peers[1].txRelayMu.Lock()
+	defer peers[1].txRelayMu.Unlock()
+	require.Empty(t, peers[1].txsToAnnounce)
+}
@@ -0,0 +1,158 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/blockchain"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/utxo"
+	"log"
+	"sync"
+)
+
+var (
+	ErrSnapshotAlreadyLoaded = errors.New("a UTXO snapshot was already loaded")
+	ErrUntrustedSnapshot     = errors.New("UTXO snapshot is not one of the trusted snapshots of the network")
+	ErrSnapshotHashMismatch  = errors.New("unspent outputs of the UTXO snapshot do not have the trusted hash")
+	ErrSnapshotBlocksInvalid = errors.New("blocks below the UTXO snapshot do not match it")
+)
+
+// snapshotChainstate holds the unspent outputs of the chain ending with the base block of a UTXO snapshot, rebuilt from the genesis block to
+// validate the snapshot, which the chainstate of the active chain started from
+type snapshotChainstate struct {
+	mu   sync.Mutex
+	base blockchain.BlockNode
+	// trusted hash of the unspent outputs at the base block
+	utxoSetHash message.Hash256
+	// nil once the snapshot is validated
+	background       *utxo.Set
+	backgroundHeight int32
+}
+
+// LoadUTXOSnapshot makes the active chain jump to the base block of the UTXO snapshot at path, written by utxo.WriteSnapshot, so that
+// the node follows the tip of the network without waiting for the whole chain to be downloaded. The snapshot must be one of the trusted
+// snapshots of the network and the header of its base block must be known. The blocks below the base block are then downloaded in the
+// background, after the ones following it, and the node stops with ErrSnapshotBlocksInvalid if the unspent outputs they create do not match
+// the snapshot.
+//
+// The snapshot is not stored: after a restart, the active chain only moves past the blocks downloaded so far once they are all stored.
+func (n *Node) LoadUTXOSnapshot(path string) error {
+	if n.snapshot.Load() != nil {
+		return ErrSnapshotAlreadyLoaded
+	}
+	f, err := storage.Open(n.fs, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	base, coins, err := utxo.ReadSnapshot(f)
+	if err != nil {
+		return err
+	}
+
+	trusted, err := n.trustedSnapshot(base)
+	if err != nil {
+		return err
+	}
+	utxoSetHash, err := coins.Hash()
+	if err != nil {
+		return err
+	}
+	if utxoSetHash != trusted {
+		return fmt.Errorf("%w: %s is not %s", ErrSnapshotHashMismatch, utxoSetHash, trusted)
+	}
+
+	baseNode, ok := n.blockIndex.Get(base)
+	if !ok {
+		return fmt.Errorf("%w: %s", blockchain.ErrUnknownSnapshotBase, base)
+	}
+	s := &snapshotChainstate{
+		base:        baseNode,
+		utxoSetHash: trusted,
+		background:  utxo.NewSet(),
+	}
+	if !n.snapshot.CompareAndSwap(nil, s) {
+		return ErrSnapshotAlreadyLoaded
+	}
+	chainstate := utxo.NewChainstate(coins, base, baseNode.Height)
+	n.configureChainstate(chainstate)
+	// stored first, so that the blocks connected after the base block once it is set are applied to the snapshot
+	previous := n.chainstate.Swap(chainstate)
+	err = n.blockIndex.SetSnapshotBase(base)
+	if err != nil {
+		n.chainstate.Store(previous)
+		n.snapshot.Store(nil)
+		return err
+	}
+	log.Printf("📸 Loaded a UTXO snapshot of %d unspent outputs at block %s (height %d)", coins.Len(), base, baseNode.Height)
+	return nil
+}
+
+// trustedSnapshot returns the trusted hash of the unspent outputs of the snapshot whose base block is base
+func (n *Node) trustedSnapshot(base message.Hash256) (message.Hash256, error) {
+	for _, trusted := range n.params.AssumeUTXO {
+		blockHash, err := parseHash(trusted.BlockHash)
+		if err != nil {
+			return message.Hash256{}, err
+		}
+		if blockHash == base {
+			return parseHash(trusted.UTXOSetHash)
+		}
+	}
+	return message.Hash256{}, fmt.Errorf("%w: base block %s", ErrUntrustedSnapshot, base)
+}
+
+// validateSnapshot connects the stored blocks below the base block of the snapshot to the unspent outputs rebuilt from the genesis block. Once
+// the base block is connected, the snapshot is validated if they match it, and the node stops otherwise.
+func (n *Node) validateSnapshot() error {
+	s := n.snapshot.Load()
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.background == nil {
+		return nil
+	}
+
+	for s.backgroundHeight < s.base.Height {
+		node, ok := n.blockIndex.ActiveBlock(s.backgroundHeight + 1)
+		if !ok || !node.Status.Has(blockchain.StatusHaveData) {
+			return nil
+		}
+		block, err := n.blockIndex.BlockData(node)
+		if err != nil {
+			return err
+		}
+		spent, err := s.background.ConnectBlock(block, node.Height)
+		if err == nil {
+			err = utxo.CheckBlockInputs(block, node.Height, spent, n.params.Deployments, n.tuning.ValidationWorkers,
+				!n.blockIndex.BuriedByCheckpoint(node.Height))
+		}
+		if errors.Is(err, utxo.ErrMissingCoin) || errors.Is(err, utxo.ErrInvalidTransaction) {
+			log.Printf("⚠️ Block %s below the UTXO snapshot at block %s does not connect: %s. Quitting now...", node.Hash, s.base.Hash, err)
+			n.quit(fmt.Errorf("%w: block %s does not connect: %w", ErrSnapshotBlocksInvalid, node.Hash, err))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.backgroundHeight++
+	}
+
+	utxoSetHash, err := s.background.Hash()
+	if err != nil {
+		return err
+	}
+	if utxoSetHash != s.utxoSetHash {
+		log.Printf("⚠️ The blocks below the UTXO snapshot at block %s create unspent outputs with hash %s instead of %s. Quitting now...",
+			s.base.Hash, utxoSetHash, s.utxoSetHash)
+		n.quit(fmt.Errorf("%w: unspent outputs have hash %s instead of %s", ErrSnapshotBlocksInvalid, utxoSetHash, s.utxoSetHash))
+		return nil
+	}
+	log.Printf("✅ Validated the UTXO snapshot at block %s", s.base.Hash)
+	s.background = nil
+	n.blockIndex.SnapshotValidated()
+	return nil
+}
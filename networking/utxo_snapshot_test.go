@@ -0,0 +1,102 @@
+package networking
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// createSnapshotChain returns length blocks following the genesis block, each with a coinbase transaction, and their hashes
+func createSnapshotChain(t *testing.T, length int) ([]message.BlockPayload, []message.Hash256) {
+	blocks, hashes := createHeaders(t, message.Hash256(constants.GenesisBlockHash), length, easyBits, 0)
+	for i := range blocks {
+		blocks[i].Transactions = []message.TxPayload{{
+			Version:            1,
+			TransactionInputs:  []message.TxIn{{SignatureScript: []byte{0x01, byte(i + 1)}, Sequence: 0xFFFFFFFF}},
+			TransactionOutputs: []message.TxOut{{Value: 50, PkScript: []byte{0x51}}},
+		}}
+	}
+	return blocks, hashes
+}
+
+// writeSnapshot writes a snapshot of utxos at base to the node's file system and makes the node trust it
+func writeSnapshot(t *testing.T, node *Node, base message.Hash256, utxos *utxo.Set) string {
+	f, err := storage.Create(node.fs, "utxo.dat")
+	require.NoError(t, err)
+	require.NoError(t, utxo.WriteSnapshot(f, base, utxos))
+	require.NoError(t, f.Close())
+	utxoSetHash, err := utxos.Hash()
+	require.NoError(t, err)
+	params := constants.MainnetParams
+	params.Name = "test"
+	params.Checkpoints = map[int32]string{}
+	params.MinimumChainWork = ""
+	params.AssumeUTXO = []constants.AssumeUTXOParams{{Height: 2, BlockHash: base.String(), UTXOSetHash: utxoSetHash.String()}}
+	require.NoError(t, node.SetNetworkParams(params))
+	return "utxo.dat"
+}
+
+func TestNode_LoadUTXOSnapshot(t *testing.T) {
+	blocks, hashes := createSnapshotChain(t, 4)
+	utxos := utxo.NewSet()
+	for i := range 2 {
+		_, err := utxos.ConnectBlock(&blocks[i], int32(i+1))
+		require.NoError(t, err)
+	}
+
+	t.Run("the active chain should follow the snapshot until the blocks below it validate it", func(t *testing.T) {
+		node := newFakePeerNode(t, 20*time.Second)
+		skipProofOfWork(node)
+		path := writeSnapshot(t, node, hashes[1], utxos)
+		_, err := node.blockIndex.AddHeaders(blocks)
+		require.NoError(t, err)
+
+		require.NoError(t, node.LoadUTXOSnapshot(path))
+		require.ErrorIs(t, node.LoadUTXOSnapshot(path), ErrSnapshotAlreadyLoaded)
+		require.Equal(t, hashes[1], node.blockIndex.Tip().Hash)
+		require.NoError(t, node.addBlockToNode(&blocks[2]))
+		require.NoError(t, node.addBlockToNode(&blocks[3]))
+		require.Equal(t, hashes[3], node.blockIndex.Tip().Hash)
+		require.Equal(t, 4, node.chainstate.Load().Coins().Len())
+
+		require.NoError(t, node.addBlockToNode(&blocks[0]))
+		_, ok := node.blockIndex.SnapshotBase()
+		require.True(t, ok)
+		require.NoError(t, node.addBlockToNode(&blocks[1]))
+		_, ok = node.blockIndex.SnapshotBase()
+		require.False(t, ok, "snapshot should be validated")
+		require.NoError(t, node.quitErr)
+	})
+
+	t.Run("the node should stop if the blocks below the snapshot do not match it", func(t *testing.T) {
+		node := newFakePeerNode(t, 20*time.Second)
+		skipProofOfWork(node)
+		forged := utxo.NewSet()
+		_, err := forged.ConnectBlock(&blocks[3], 2)
+		require.NoError(t, err)
+		path := writeSnapshot(t, node, hashes[1], forged)
+		_, err = node.blockIndex.AddHeaders(blocks)
+		require.NoError(t, err)
+		require.NoError(t, node.LoadUTXOSnapshot(path))
+
+		require.NoError(t, node.addBlockToNode(&blocks[0]))
+		require.NoError(t, node.addBlockToNode(&blocks[1]))
+		require.ErrorIs(t, node.quitErr, ErrSnapshotBlocksInvalid)
+	})
+
+	t.Run("a snapshot that is not trusted should be rejected", func(t *testing.T) {
+		node := newFakePeerNode(t, 20*time.Second)
+		skipProofOfWork(node)
+		path := writeSnapshot(t, node, hashes[1], utxos)
+		require.NoError(t, node.SetNetworkParams(constants.MainnetParams))
+		_, err := node.blockIndex.AddHeaders(blocks)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, node.LoadUTXOSnapshot(path), ErrUntrustedSnapshot)
+		require.Equal(t, message.Hash256(constants.GenesisBlockHash), node.blockIndex.Tip().Hash)
+	})
+}
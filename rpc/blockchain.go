@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+)
+
+// Size of a serialized block header, which is followed by the number of transactions of the block
+const blockHeaderSize = 80
+
+// Names bitcoind gives the networks whose names differ
+var chainNames = map[string]string{"mainnet": "main", "testnet": "test"}
+
+// BlockchainInfo is the result of getblockchaininfo (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp)
+type BlockchainInfo struct {
+	Chain                string  `json:"chain"`
+	Blocks               int32   `json:"blocks"`
+	Headers              int32   `json:"headers"`
+	BestBlockHash        string  `json:"bestblockhash"`
+	Difficulty           float64 `json:"difficulty"`
+	Time                 uint32  `json:"time"`
+	MedianTime           uint32  `json:"mediantime"`
+	VerificationProgress float64 `json:"verificationprogress"`
+	InitialBlockDownload bool    `json:"initialblockdownload"`
+	ChainWork            string  `json:"chainwork"`
+	SizeOnDisk           int64   `json:"size_on_disk"`
+	Pruned               bool    `json:"pruned"`
+	Warnings             string  `json:"warnings"`
+}
+
+// BlockHeader is the result of getblockheader, and describes the block getblock returns
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L134-L159)
+type BlockHeader struct {
+	Hash string `json:"hash"`
+	// -1 if the block is not in the active chain
+	Confirmations     int32   `json:"confirmations"`
+	Height            int32   `json:"height"`
+	Version           int32   `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	MedianTime        uint32  `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	ChainWork         string  `json:"chainwork"`
+	NTx               int     `json:"nTx"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+	NextBlockHash     string  `json:"nextblockhash,omitempty"`
+}
+
+// Block is the result of getblock with verbosity 1 or 2 (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L161-L203)
+type Block struct {
+	BlockHeader
+	StrippedSize int `json:"strippedsize"`
+	Size         int `json:"size"`
+	Weight       int `json:"weight"`
+	// The txids of the transactions with verbosity 1, the decoded transactions (Tx) with verbosity 2
+	Tx []any `json:"tx"`
+}
+
+func (s *Server) getBlockCount(_ []json.RawMessage) (any, error) {
+	info, err := s.node.ChainInfo()
+	if err != nil {
+		return nil, err
+	}
+	return info.Height, nil
+}
+
+func (s *Server) getBestBlockHash(_ []json.RawMessage) (any, error) {
+	info, err := s.node.ChainInfo()
+	if err != nil {
+		return nil, err
+	}
+	return info.BestBlockHash, nil
+}
+
+func (s *Server) getBlockchainInfo(_ []json.RawMessage) (any, error) {
+	info, err := s.node.ChainInfo()
+	if err != nil {
+		return nil, err
+	}
+	return BlockchainInfo{
+		Chain:                chainName(info),
+		Blocks:               info.Height,
+		Headers:              info.Headers,
+		BestBlockHash:        info.BestBlockHash,
+		Difficulty:           info.Difficulty,
+		Time:                 info.Time,
+		MedianTime:           info.MedianTime,
+		VerificationProgress: info.VerificationProgress,
+		InitialBlockDownload: info.InitialBlockDownload,
+		ChainWork:            info.ChainWork,
+		SizeOnDisk:           info.SizeOnDisk,
+		Pruned:               info.Pruned,
+	}, nil
+}
+
+// getBlock returns the block with the hash of the first parameter: in hex with verbosity 0 (or false), decoded with the txids of its
+// transactions with verbosity 1 (or true, the default) and decoded with its transactions decoded with verbosity 2 or more
+func (s *Server) getBlock(params []json.RawMessage) (any, error) {
+	hash, err := hashParam(params, 0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := verbosityParam(params, 1, "verbosity", 1)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.node.GetBlock(hash)
+	if err != nil {
+		return nil, blockError(err)
+	}
+	if verbosity <= 0 {
+		encoded, err := block.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(encoded), nil
+	}
+	info, err := s.node.BlockHeaderInfo(hash)
+	if err != nil {
+		return nil, blockError(err)
+	}
+	return decodeBlock(block, info, verbosity, s.node.NetworkParams())
+}
+
+// decodeBlock decodes block, whose header is described by info, for display on the network of params: with the txids of its transactions
+// with verbosity 1 and with its transactions decoded with verbosity 2 or more
+func decodeBlock(block *message.BlockPayload, info networking.BlockHeaderInfo, verbosity int, params constants.NetworkParams) (Block, error) {
+	encoded, err := block.Encode()
+	if err != nil {
+		return Block{}, err
+	}
+	header, err := decodeHeader(info)
+	if err != nil {
+		return Block{}, err
+	}
+	stripped := len(encoded)
+	decoded := Block{BlockHeader: header, Size: len(encoded), Tx: make([]any, len(block.Transactions))}
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		size, err := strippedSize(tx)
+		if err != nil {
+			return Block{}, err
+		}
+		encodedTx, err := tx.Encode()
+		if err != nil {
+			return Block{}, err
+		}
+		stripped -= len(encodedTx) - size
+		if verbosity == 1 {
+			txId, err := tx.GetTxId()
+			if err != nil {
+				return Block{}, err
+			}
+			decoded.Tx[i] = txId.String()
+			continue
+		}
+		decoded.Tx[i], err = decodeTx(tx, params)
+		if err != nil {
+			return Block{}, err
+		}
+	}
+	decoded.StrippedSize = stripped
+	decoded.Weight = stripped*3 + len(encoded)
+	return decoded, nil
+}
+
+// getBlockHeader returns the header of the block with the hash of the first parameter, decoded unless the second parameter is false, in
+// which case it is returned in hex
+func (s *Server) getBlockHeader(params []json.RawMessage) (any, error) {
+	hash, err := hashParam(params, 0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbose := true
+	err = param(params, 1, "verbose", &verbose, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.node.BlockHeaderInfo(hash)
+	if err != nil {
+		return nil, blockError(err)
+	}
+	if !verbose {
+		encoded, err := info.Header.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(encoded[:blockHeaderSize]), nil
+	}
+	return decodeHeader(info)
+}
+
+func decodeHeader(info networking.BlockHeaderInfo) (BlockHeader, error) {
+	difficulty, err := message.Difficulty(info.Header.Bits)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	header := BlockHeader{
+		Hash:          info.Hash.String(),
+		Confirmations: info.Confirmations,
+		Height:        info.Height,
+		Version:       info.Header.Version,
+		VersionHex:    fmt.Sprintf("%08x", uint32(info.Header.Version)),
+		MerkleRoot:    info.Header.MerkleRoot.String(),
+		Time:          info.Header.Timestamp,
+		MedianTime:    info.MedianTime,
+		Nonce:         info.Header.Nonce,
+		Bits:          fmt.Sprintf("%08x", info.Header.Bits),
+		Difficulty:    difficulty,
+		ChainWork:     info.ChainWork,
+		NTx:           info.TxCount,
+	}
+	if info.Height > 0 {
+		header.PreviousBlockHash = info.Header.PrevBlock.String()
+	}
+	if info.NextBlockHash != nil {
+		header.NextBlockHash = info.NextBlockHash.String()
+	}
+	return header, nil
+}
+
+// getBlockFromPeer asks the peer whose id is the second parameter for the block with the hash of the first parameter, whose header must be
+// known, and returns an empty object without waiting for the block to arrive
+func (s *Server) getBlockFromPeer(params []json.RawMessage) (any, error) {
+	hash, err := hashParam(params, 0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	var peerId int64
+	err = param(params, 1, "peer_id", &peerId, true)
+	if err != nil {
+		return nil, err
+	}
+	err = s.node.RequestBlock(hash, peerId)
+	switch {
+	case errors.Is(err, networking.ErrBlockNotFound):
+		return nil, newError(CodeMiscError, "Block header missing")
+	case errors.Is(err, networking.ErrBlockAlreadyStored):
+		return nil, newError(CodeMiscError, "Block already downloaded")
+	case errors.Is(err, networking.ErrPeerNotFound):
+		return nil, newError(CodeMiscError, "Peer does not exist")
+	case err != nil:
+		return nil, err
+	}
+	return struct{}{}, nil
+}
+
+// verbosityParam decodes the parameter at index i of params as a verbosity level, which can also be passed as a boolean for 0 or 1
+func verbosityParam(params []json.RawMessage, i int, name string, defaultValue int) (int, error) {
+	var value any
+	err := param(params, i, name, &value, false)
+	if err != nil {
+		return 0, err
+	}
+	switch value := value.(type) {
+	case nil:
+		return defaultValue, nil
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	case float64:
+		if value == float64(int(value)) {
+			return int(value), nil
+		}
+	}
+	return 0, newError(CodeTypeError, "Invalid parameter %s: %s", name, params[i])
+}
+
+// blockError returns the error bitcoind fails with when the block a call is about is not known, or only by its header
+func blockError(err error) error {
+	switch {
+	case errors.Is(err, networking.ErrBlockNotFound):
+		return newError(CodeInvalidAddressOrKey, "Block not found")
+	case errors.Is(err, networking.ErrBlockNotStored):
+		return newError(CodeMiscError, "Block not available (not fully downloaded)")
+	}
+	return err
+}
+
+func chainName(info networking.ChainInfo) string {
+	if name, ok := chainNames[info.Chain]; ok {
+		return name
+	}
+	return info.Chain
+}
@@ -0,0 +1,177 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/networking/networkingtest"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestServer_ChainQueries(t *testing.T) {
+	s, node := newTestServer()
+
+	var count int32
+	call(t, s, "getblockcount", &count)
+	require.EqualValues(t, 2, count)
+
+	var hash string
+	call(t, s, "getbestblockhash", &hash)
+	require.Equal(t, strings.Repeat("ab", 32), hash)
+
+	node.chainInfo.ChainWork = strings.Repeat("0", 63) + "3"
+	node.chainInfo.InitialBlockDownload = true
+	var info BlockchainInfo
+	call(t, s, "getblockchaininfo", &info)
+	require.Equal(t, BlockchainInfo{
+		Chain:                "main",
+		Blocks:               2,
+		Headers:              3,
+		BestBlockHash:        strings.Repeat("ab", 32),
+		InitialBlockDownload: true,
+		ChainWork:            strings.Repeat("0", 63) + "3",
+	}, info)
+
+	node.chainInfo.Chain = "regtest"
+	call(t, s, "getblockchaininfo", &info)
+	require.Equal(t, "regtest", info.Chain)
+}
+
+// addGenesisBlock makes node store the mainnet genesis block as the first of 3 blocks of the active chain, and returns its hash
+func addGenesisBlock(t *testing.T, node *fakeNode) message.Hash256 {
+	genesis := networkingtest.GenesisBlock(t)
+	hash, err := genesis.GetBlockHash()
+	require.NoError(t, err)
+	next := message.Hash256{0x02}
+	node.blocks[hash] = genesis
+	header := *genesis
+	header.Transactions = nil
+	node.headers[hash] = networking.BlockHeaderInfo{
+		Hash:          hash,
+		Header:        header,
+		Confirmations: 3,
+		MedianTime:    genesis.Timestamp,
+		ChainWork:     strings.Repeat("0", 55) + "100010001",
+		TxCount:       1,
+		NextBlockHash: &next,
+	}
+	return hash
+}
+
+func TestServer_GetBlock(t *testing.T) {
+	s, node := newTestServer()
+	hash := addGenesisBlock(t, node)
+	const (
+		genesisHash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+		coinbaseId  = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	)
+
+	var encoded string
+	call(t, s, "getblock", &encoded, genesisHash, 0)
+	require.Equal(t, constants.MainnetParams.GenesisBlock, encoded)
+	call(t, s, "getblock", &encoded, genesisHash, false)
+	require.Equal(t, constants.MainnetParams.GenesisBlock, encoded)
+
+	// what bitcoind returns for the genesis block, apart from the fields set by addGenesisBlock
+	expectedHeader := BlockHeader{
+		Hash:          genesisHash,
+		Confirmations: 3,
+		Height:        0,
+		Version:       1,
+		VersionHex:    "00000001",
+		MerkleRoot:    coinbaseId,
+		Time:          1231006505,
+		MedianTime:    1231006505,
+		Nonce:         2083236893,
+		Bits:          "1d00ffff",
+		Difficulty:    1,
+		ChainWork:     strings.Repeat("0", 55) + "100010001",
+		NTx:           1,
+		NextBlockHash: strings.Repeat("0", 62) + "02",
+	}
+	var block struct {
+		Block
+		Tx []json.RawMessage `json:"tx"`
+	}
+	call(t, s, "getblock", &block, genesisHash)
+	require.Equal(t, expectedHeader, block.BlockHeader)
+	require.Equal(t, 285, block.Size)
+	require.Equal(t, 285, block.StrippedSize)
+	require.Equal(t, 1140, block.Weight)
+	require.Equal(t, []json.RawMessage{json.RawMessage(`"` + coinbaseId + `"`)}, block.Tx)
+
+	call(t, s, "getblock", &block, genesisHash, 2)
+	require.Len(t, block.Tx, 1)
+	var tx Tx
+	require.NoError(t, json.Unmarshal(block.Tx[0], &tx))
+	require.Equal(t, coinbaseId, tx.TxId)
+	require.Equal(t, 204, tx.Size)
+	require.Equal(t, 816, tx.Weight)
+	require.Equal(t, "04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73", tx.Vin[0].Coinbase)
+	require.Nil(t, tx.Vin[0].ScriptSig)
+	require.Equal(t, Amount(50*satoshisPerBitcoin), tx.Vout[0].Value)
+	require.Contains(t, string(block.Tx[0]), `"value":50.00000000`)
+	require.Equal(t, "pubkey", string(tx.Vout[0].ScriptPubKey.Type))
+	require.Empty(t, tx.Vout[0].ScriptPubKey.Address)
+	require.Equal(t, "04678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5f OP_CHECKSIG",
+		tx.Vout[0].ScriptPubKey.Asm)
+
+	var header BlockHeader
+	call(t, s, "getblockheader", &header, genesisHash)
+	require.Equal(t, expectedHeader, header)
+	call(t, s, "getblockheader", &encoded, genesisHash, false)
+	require.Equal(t, constants.MainnetParams.GenesisBlock[:2*blockHeaderSize], encoded)
+
+	t.Run("blocks not in the active chain should have no next block", func(t *testing.T) {
+		info := node.headers[hash]
+		info.Confirmations = -1
+		info.NextBlockHash = nil
+		node.headers[hash] = info
+		var header BlockHeader
+		call(t, s, "getblockheader", &header, genesisHash)
+		require.EqualValues(t, -1, header.Confirmations)
+		require.Empty(t, header.NextBlockHash)
+	})
+
+	t.Run("unknown and header-only blocks and invalid hashes should fail", func(t *testing.T) {
+		unknown := strings.Repeat("01", 32)
+		require.Equal(t, &Error{Code: CodeInvalidAddressOrKey, Message: "Block not found"}, callError(t, s, "getblock", unknown))
+		require.Equal(t, CodeInvalidAddressOrKey, callError(t, s, "getblockheader", unknown).Code)
+		headerOnly := message.Hash256{0x03}
+		node.headers[headerOnly] = networking.BlockHeaderInfo{
+			Hash:   headerOnly,
+			Header: message.BlockPayload{PrevBlock: hash, Bits: 0x1d00ffff},
+			Height: 1,
+		}
+		require.Equal(t, CodeMiscError, callError(t, s, "getblock", headerOnly.String()).Code)
+		call(t, s, "getblockheader", &header, headerOnly.String())
+		require.Equal(t, genesisHash, header.PreviousBlockHash)
+
+		require.Equal(t, CodeInvalidParameter, callError(t, s, "getblock", "abc").Code)
+		require.Equal(t, CodeInvalidParameter, callError(t, s, "getblock", strings.Repeat("zz", 32)).Code)
+		require.Equal(t, CodeTypeError, callError(t, s, "getblock", 1).Code)
+		require.Equal(t, CodeTypeError, callError(t, s, "getblock", genesisHash, "1").Code)
+		require.Equal(t, CodeMiscError, callError(t, s, "getblock").Code)
+	})
+}
+
+func TestServer_GetBlockFromPeer(t *testing.T) {
+	s, node := newTestServer()
+	genesisHash := addGenesisBlock(t, node)
+	hash := message.Hash256{0x02}
+	node.headers[hash] = networking.BlockHeaderInfo{Hash: hash}
+	node.peers = []networking.PeerInfo{{Id: 3, Addr: "10.0.0.1:8333"}}
+
+	var result map[string]any
+	call(t, s, "getblockfrompeer", &result, hash.String(), 3)
+	require.Empty(t, result)
+	require.Equal(t, []message.Hash256{hash}, node.requestedBlocks)
+
+	require.Equal(t, &Error{Code: CodeMiscError, Message: "Block header missing"}, callError(t, s, "getblockfrompeer", strings.Repeat("01", 32), 3))
+	require.Equal(t, &Error{Code: CodeMiscError, Message: "Block already downloaded"}, callError(t, s, "getblockfrompeer", genesisHash.String(), 3))
+	require.Equal(t, &Error{Code: CodeMiscError, Message: "Peer does not exist"}, callError(t, s, "getblockfrompeer", hash.String(), 4))
+	require.Equal(t, CodeMiscError, callError(t, s, "getblockfrompeer", hash.String()).Code)
+}
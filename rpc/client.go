@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrUnauthorized is returned by Client.Call when the server rejects the credentials of the client
+var ErrUnauthorized = errors.New("incorrect rpcuser or rpcpassword (authorization failed)")
+
+// Client calls the methods of a JSON-RPC server, such as Server or bitcoind's, authenticating with HTTP basic authentication
+type Client struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+	lastId   atomic.Int64
+}
+
+// NewClient returns a client calling the server at url (e.g. http://127.0.0.1:8332) with the credentials user and password
+func NewClient(url string, user string, password string) *Client {
+	return &Client{url: url, user: user, password: password, client: &http.Client{}}
+}
+
+// Call calls method with params, which are either a slice of the parameters in order or a map of the parameters by name (nil for none),
+// and returns the encoded result. It returns an *Error if the server answered with an error.
+func (c *Client) Call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "1.0", "id": c.lastId.Add(1), "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.user, c.password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var decoded struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	// errors are answered with statuses other than 200 along with a JSON-RPC error, which is the one to report
+	err = json.Unmarshal(respBody, &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("server answered with status %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if decoded.Error != nil {
+		return nil, decoded.Error
+	}
+	return decoded.Result, nil
+}
@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_Call(t *testing.T) {
+	s, node := newTestServer()
+	server := httptest.NewServer(s)
+	defer server.Close()
+	client := NewClient(server.URL, "user", "password")
+
+	result, err := client.Call(context.Background(), "getblockcount", nil)
+	require.NoError(t, err)
+	require.JSONEq(t, "2", string(result))
+
+	// parameters by name
+	node.manualPeers["10.0.0.1:8333"] = true
+	_, err = client.Call(context.Background(), "addnode", map[string]any{"node": "10.0.0.1:8333", "command": "remove"})
+	require.NoError(t, err)
+	require.False(t, node.manualPeers["10.0.0.1:8333"])
+
+	_, err = client.Call(context.Background(), "getblock", []any{"zz"})
+	var rpcErr *Error
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, CodeInvalidParameter, rpcErr.Code)
+
+	_, err = client.Call(context.Background(), "nosuchmethod", []any{})
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, CodeMethodNotFound, rpcErr.Code)
+
+	_, err = NewClient(server.URL, "user", "wrong").Call(context.Background(), "getblockcount", nil)
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestClient_CallFailsOnNonJSONResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no JSON here", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "user", "password").Call(context.Background(), "getblockcount", nil)
+	require.ErrorContains(t, err, "502 Bad Gateway: no JSON here")
+}
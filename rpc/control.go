@@ -0,0 +1,22 @@
+package rpc
+
+import (
+	"encoding/json"
+)
+
+// dumpStats logs a snapshot of the peers, the chain, the mempool, the memory and the channel depths of the node, as SIGUSR1 does, and
+// returns it. It is not a bitcoind method.
+func (s *Server) dumpStats(params []json.RawMessage) (any, error) {
+	return s.node.LogRuntimeStats()
+}
+
+// ResyncResult is the result of resync
+type ResyncResult struct {
+	// Number of peers asked for headers
+	Peers int `json:"peers"`
+}
+
+// resync starts a new round of synchronization without restarting the node (see networking.Node.Resync). It is not a bitcoind method.
+func (s *Server) resync(params []json.RawMessage) (any, error) {
+	return ResyncResult{Peers: s.node.Resync()}, nil
+}
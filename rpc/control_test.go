@@ -0,0 +1,32 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestServer_DumpStats(t *testing.T) {
+	s, node := newTestServer()
+	node.runtimeStats = networking.RuntimeStats{
+		Time:     time.Unix(1_700_000_000, 0).UTC(),
+		Chain:    node.chainInfo,
+		Memory:   networking.MemoryStats{HeapAlloc: 1 << 20, Goroutines: 12},
+		Channels: []networking.ChannelDepth{{Name: "block", Len: 3, Cap: 100}},
+		Peers:    []networking.PeerQueueStats{{Id: 1, Addr: "10.0.0.1:8333", MsgQueue: 2}},
+	}
+
+	var stats networking.RuntimeStats
+	call(t, s, "dumpstats", &stats)
+	require.Equal(t, node.runtimeStats, stats)
+}
+
+func TestServer_Resync(t *testing.T) {
+	s, node := newTestServer()
+	node.resyncPeers = 2
+
+	var result ResyncResult
+	call(t, s, "resync", &result)
+	require.Equal(t, ResyncResult{Peers: 2}, result)
+}
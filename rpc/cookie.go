@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"os"
+	"strings"
+)
+
+// User name of cookie authentication (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/request.cpp)
+const CookieUser = "__cookie__"
+
+// WriteCookie generates a random password for cookie authentication and writes the credentials to the file at path as user:password, readable
+// only by the user running the node so that the clients it runs can authenticate. The file should be removed when the server stops.
+func WriteCookie(fsys storage.FS, path string) (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(secret)
+	f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write([]byte(CookieUser + ":" + password))
+	closeErr := f.Close()
+	if err != nil {
+		return "", err
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return password, nil
+}
+
+// ReadCookie reads the credentials WriteCookie wrote to the file at path
+func ReadCookie(fsys storage.FS, path string) (string, string, error) {
+	f, err := storage.Open(fsys, path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		return "", "", err
+	}
+	user, password, ok := strings.Cut(strings.TrimSpace(string(contents)), ":")
+	if !ok {
+		return "", "", fmt.Errorf("cookie file %s is not of the form user:password", path)
+	}
+	return user, password, nil
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestWriteCookie(t *testing.T) {
+	fs := storage.NewMemFS()
+	password, err := WriteCookie(fs, ".cookie")
+	require.NoError(t, err)
+	require.Len(t, password, 64)
+
+	f, err := storage.Open(fs, ".cookie")
+	require.NoError(t, err)
+	contents, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "__cookie__:"+password, string(contents))
+
+	// every cookie has a new password
+	other, err := WriteCookie(fs, ".cookie")
+	require.NoError(t, err)
+	require.NotEqual(t, password, other)
+}
+
+func TestReadCookie(t *testing.T) {
+	fsys := storage.NewMemFS()
+	password, err := WriteCookie(fsys, ".cookie")
+	require.NoError(t, err)
+	user, readPassword, err := ReadCookie(fsys, ".cookie")
+	require.NoError(t, err)
+	require.Equal(t, CookieUser, user)
+	require.Equal(t, password, readPassword)
+
+	f, err := fsys.OpenFile("bad", os.O_WRONLY|os.O_CREATE, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("no separator"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, _, err = ReadCookie(fsys, "bad")
+	require.Error(t, err)
+
+	_, _, err = ReadCookie(fsys, "missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
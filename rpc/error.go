@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error codes of bitcoind (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/protocol.h)
+const (
+	// JSON-RPC 2.0 errors
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeParseError     = -32700
+
+	// General application errors
+	CodeMiscError            = -1
+	CodeTypeError            = -3
+	CodeInvalidAddressOrKey  = -5
+	CodeInvalidParameter     = -8
+	CodeDeserializationError = -22
+	CodeVerifyError          = -25
+	CodeVerifyRejected       = -26
+
+	// P2P client errors
+	CodeClientNodeAlreadyAdded  = -23
+	CodeClientNodeNotAdded      = -24
+	CodeClientNodeNotConnected  = -29
+	CodeClientInvalidIPOrSubnet = -30
+)
+
+// Error is the error object of a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func newError(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// httpStatus returns the HTTP status bitcoind answers a JSON-RPC 1.0 request failing with e with
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/httprpc.cpp)
+func (e *Error) httpStatus() int {
+	switch e.Code {
+	case CodeInvalidRequest:
+		return http.StatusBadRequest
+	case CodeMethodNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
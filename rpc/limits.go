@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/internal/ratelimit"
+	"github.com/aang114/bitcoin-node/networking"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often the clients whose rate limit has been refilled are forgotten
+const clientPruneInterval = time.Minute
+
+// Limits protects the JSON-RPC and REST servers from clients sending too many or too large requests, so that a misbehaving client cannot
+// keep the node busy serving it (see Limit)
+type Limits struct {
+	// Requests each client, identified by its IP address, may make: Burst at once, refilled at Rate requests per second. A zero Burst
+	// disables the limit.
+	PerClient networking.RateLimit
+	// Number of requests handled at once (bitcoind's -rpcthreads), 0 for no limit
+	MaxConcurrent int
+	// Number of requests which may wait for one of the handled requests to finish (bitcoind's -rpcworkqueue). Requests arriving while the
+	// queue is full are rejected.
+	MaxQueued int
+	// Size in bytes of the largest request body which may be read, 0 for no limit
+	MaxBodySize int64
+}
+
+// Limit returns a handler serving requests with handler within limits. Requests exceeding the rate of their client are answered with status
+// 429 Too Many Requests, and requests arriving while the work queue is full with status 503 Service Unavailable. Reading a body larger than
+// the maximum size fails with an *http.MaxBytesError.
+func Limit(handler http.Handler, limits Limits) http.Handler {
+	l := &limiter{handler: handler, limits: limits, clients: make(map[string]*client), now: time.Now}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return l
+}
+
+type limiter struct {
+	handler http.Handler
+	limits  Limits
+	// Holds a value for each request being handled, nil if their number is not limited
+	slots chan struct{}
+	// Number of requests being handled or waiting to be
+	pending atomic.Int64
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// client is the rate limit of the requests coming from an IP address
+type client struct {
+	tokens   *ratelimit.TokenBucket
+	lastSeen time.Time
+}
+
+func (l *limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !l.allow(clientIP(r)) {
+		if rate := l.limits.PerClient.Rate; rate > 0 {
+			// seconds until the client has a request to make again
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/rate))))
+		}
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if l.slots != nil {
+		defer l.pending.Add(-1)
+		if l.pending.Add(1) > int64(l.limits.MaxConcurrent+l.limits.MaxQueued) {
+			log.Printf("⚠️ Rejected RPC request from %s as the work queue is full", r.RemoteAddr)
+			http.Error(w, "Work queue depth exceeded", http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case l.slots <- struct{}{}:
+			defer func() { <-l.slots }()
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if l.limits.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, l.limits.MaxBodySize)
+	}
+	l.handler.ServeHTTP(w, r)
+}
+
+// allow takes a token from the rate limit of the client at ip, and reports whether there was one to take
+func (l *limiter) allow(ip string) bool {
+	if l.limits.PerClient.Burst <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastPrune) >= clientPruneInterval {
+		l.pruneClients(now)
+	}
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &client{tokens: ratelimit.NewTokenBucket(l.limits.PerClient.Rate, l.limits.PerClient.Burst, now)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+	return c.tokens.Allow(now)
+}
+
+// pruneClients forgets the clients which have not made a request for long enough to refill their rate limit, as a new limit is the same
+func (l *limiter) pruneClients(now time.Time) {
+	l.lastPrune = now
+	if l.limits.PerClient.Rate <= 0 {
+		return
+	}
+	refill := time.Duration(float64(l.limits.PerClient.Burst) / l.limits.PerClient.Rate * float64(time.Second))
+	for ip, c := range l.clients {
+		if now.Sub(c.lastSeen) >= refill {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// clientIP returns the IP address a request comes from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
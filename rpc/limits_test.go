@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// getFrom sends a GET request to handler from the client at remoteAddr, and returns the response
+func getFrom(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestLimit_PerClientRate(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Limit(ok, Limits{PerClient: networking.RateLimit{Rate: 0.5, Burst: 2}}).(*limiter)
+	now := time.Unix(1_700_000_000, 0)
+	handler.now = func() time.Time { return now }
+
+	require.Equal(t, http.StatusOK, getFrom(handler, "10.0.0.1:1000").Code)
+	require.Equal(t, http.StatusOK, getFrom(handler, "10.0.0.1:1001").Code)
+	w := getFrom(handler, "10.0.0.1:1002")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+	// other clients have their own limit
+	require.Equal(t, http.StatusOK, getFrom(handler, "10.0.0.2:1000").Code)
+
+	now = now.Add(2 * time.Second)
+	require.Equal(t, http.StatusOK, getFrom(handler, "10.0.0.1:1003").Code)
+	require.Equal(t, http.StatusTooManyRequests, getFrom(handler, "10.0.0.1:1004").Code)
+
+	// clients are forgotten once their limit is refilled
+	now = now.Add(clientPruneInterval)
+	require.Equal(t, http.StatusOK, getFrom(handler, "10.0.0.3:1000").Code)
+	require.Len(t, handler.clients, 1)
+}
+
+func TestLimit_WorkQueue(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	handler := Limit(blocking, Limits{MaxConcurrent: 1, MaxQueued: 1}).(*limiter)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- getFrom(handler, "10.0.0.1:1000").Code
+		}()
+	}
+	// one request is handled and the other waits for it
+	<-started
+	require.Eventually(t, func() bool { return handler.pending.Load() == 2 }, time.Second, time.Millisecond)
+	select {
+	case <-started:
+		t.Fatal("second request handled while the first one is")
+	default:
+	}
+	require.Equal(t, http.StatusServiceUnavailable, getFrom(handler, "10.0.0.2:1000").Code)
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, http.StatusOK, <-codes)
+	require.Equal(t, http.StatusOK, <-codes)
+	require.Zero(t, handler.pending.Load())
+}
+
+func TestLimit_MaxBodySize(t *testing.T) {
+	s, _ := newTestServer()
+	handler := Limit(s, Limits{MaxBodySize: 64})
+
+	body := `{"id":1,"method":"getblockcount","params":[]}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.SetBasicAuth("user", "password")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body+strings.Repeat(" ", 64)))
+	r.SetBasicAuth("user", "password")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
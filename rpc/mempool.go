@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/networking"
+)
+
+// MempoolInfo describes the mempool, as getmempoolinfo returns it (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/mempool.cpp#L671-L706)
+type MempoolInfo struct {
+	Loaded     bool   `json:"loaded"`
+	Size       int    `json:"size"`
+	Bytes      int    `json:"bytes"`
+	Usage      int    `json:"usage"`
+	TotalFee   Amount `json:"total_fee"`
+	MaxMempool int    `json:"maxmempool"`
+	// Fee rates in BTC per 1000 virtual bytes
+	MempoolMinFee       Amount `json:"mempoolminfee"`
+	MinRelayTxFee       Amount `json:"minrelaytxfee"`
+	IncrementalRelayFee Amount `json:"incrementalrelayfee"`
+	UnbroadcastCount    int    `json:"unbroadcastcount"`
+	FullRBF             bool   `json:"fullrbf"`
+}
+
+// MempoolEntry describes a transaction of the mempool, as getmempoolentry returns it
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/mempool.cpp#L251-L297)
+type MempoolEntry struct {
+	VSize int `json:"vsize"`
+	// Unix time the transaction entered the mempool at
+	Time            int64       `json:"time"`
+	DescendantCount int         `json:"descendantcount"`
+	DescendantSize  int         `json:"descendantsize"`
+	AncestorCount   int         `json:"ancestorcount"`
+	AncestorSize    int         `json:"ancestorsize"`
+	WtxId           string      `json:"wtxid"`
+	Fees            MempoolFees `json:"fees"`
+	Depends         []string    `json:"depends"`
+	SpentBy         []string    `json:"spentby"`
+	Replaceable     bool        `json:"bip125-replaceable"`
+	Unbroadcast     bool        `json:"unbroadcast"`
+}
+
+// MempoolFees are the fees of a transaction of the mempool, of its ancestors and of its descendants, fees that are not known counting as 0
+type MempoolFees struct {
+	Base       Amount `json:"base"`
+	Modified   Amount `json:"modified"`
+	Ancestor   Amount `json:"ancestor"`
+	Descendant Amount `json:"descendant"`
+}
+
+func mempoolInfo(info networking.MempoolInfo) MempoolInfo {
+	return MempoolInfo{
+		Loaded:              true,
+		Size:                info.Size,
+		Bytes:               info.Bytes,
+		Usage:               info.Usage,
+		TotalFee:            Amount(info.TotalFee),
+		MaxMempool:          info.MaxMempool,
+		MempoolMinFee:       Amount(info.MempoolMinFee),
+		MinRelayTxFee:       Amount(info.MinRelayTxFee),
+		IncrementalRelayFee: Amount(info.IncrementalRelayFee),
+	}
+}
+
+func mempoolEntry(info networking.MempoolEntryInfo) MempoolEntry {
+	var fee int64
+	if info.Fee != nil {
+		fee = *info.Fee
+	}
+	return MempoolEntry{
+		VSize:           info.VSize,
+		Time:            info.Time.Unix(),
+		DescendantCount: info.Descendants.Count,
+		DescendantSize:  info.Descendants.VSize,
+		AncestorCount:   info.Ancestors.Count,
+		AncestorSize:    info.Ancestors.VSize,
+		WtxId:           info.WtxId,
+		Fees: MempoolFees{
+			Base:       Amount(fee),
+			Modified:   Amount(fee),
+			Ancestor:   Amount(info.Ancestors.Fees),
+			Descendant: Amount(info.Descendants.Fees),
+		},
+		Depends:     info.Depends,
+		SpentBy:     info.SpentBy,
+		Replaceable: info.BIP125Replaceable,
+	}
+}
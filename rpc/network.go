@@ -0,0 +1,359 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/networking"
+	"net"
+	"strings"
+	"time"
+)
+
+// PeerInfo describes a connected peer, as getpeerinfo returns it (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/net.cpp#L107-L188)
+type PeerInfo struct {
+	Id            int64              `json:"id"`
+	Addr          string             `json:"addr"`
+	Network       networking.Network `json:"network"`
+	Services      string             `json:"services"`
+	ServicesNames []string           `json:"servicesnames"`
+	// Whether the peer asked for transactions to be announced to it
+	RelayTxes bool `json:"relaytxes"`
+	// Unix times of the last message sent and received, 0 if none was
+	LastSend  int64  `json:"lastsend"`
+	LastRecv  int64  `json:"lastrecv"`
+	BytesSent uint64 `json:"bytessent"`
+	BytesRecv uint64 `json:"bytesrecv"`
+	ConnTime  int64  `json:"conntime"`
+	// Round trip of the last ping in seconds, only returned once a ping was answered
+	PingTime       float64 `json:"pingtime,omitempty"`
+	Version        int32   `json:"version"`
+	SubVer         string  `json:"subver"`
+	Inbound        bool    `json:"inbound"`
+	StartingHeight int32   `json:"startingheight"`
+	ConnectionType string  `json:"connection_type"`
+}
+
+// getPeerInfo describes the connected peers, from the longest connected
+func (s *Server) getPeerInfo(params []json.RawMessage) (any, error) {
+	peers := s.node.Peers()
+	infos := make([]PeerInfo, len(peers))
+	for i, peer := range peers {
+		infos[i] = PeerInfo{
+			Id:             peer.Id,
+			Addr:           peer.Addr,
+			Network:        peer.Network,
+			Services:       fmt.Sprintf("%016x", uint64(peer.Services)),
+			ServicesNames:  peer.Services.Names(),
+			RelayTxes:      peer.Relay,
+			LastSend:       unixTime(peer.LastSend),
+			LastRecv:       unixTime(peer.LastRecv),
+			BytesSent:      peer.BytesSent,
+			BytesRecv:      peer.BytesRecv,
+			ConnTime:       unixTime(peer.ConnectedAt),
+			PingTime:       peer.PingLatency.Seconds(),
+			Version:        peer.ProtocolVersion,
+			SubVer:         peer.UserAgent,
+			Inbound:        peer.Direction == networking.Inbound,
+			StartingHeight: peer.StartingHeight,
+			ConnectionType: connectionType(peer),
+		}
+	}
+	return infos, nil
+}
+
+// connectionType names the type of the connection to peer the way bitcoind does
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/node/connection_types.cpp)
+func connectionType(peer networking.PeerInfo) string {
+	switch {
+	case peer.Direction == networking.Inbound:
+		return "inbound"
+	case peer.Manual:
+		return "manual"
+	case peer.ConnectionType == networking.BlockRelay:
+		return "block-relay-only"
+	case peer.ConnectionType == networking.Feeler:
+		return "feeler"
+	}
+	return "outbound-full-relay"
+}
+
+// unixTime returns t in unix seconds, 0 for the zero time
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// addNode applies the command of the second parameter to the peer at the address of the first: "add" makes it a manual peer, which the node
+// keeps connected, "remove" makes it an ordinary peer again and "onetry" connects to it once
+func (s *Server) addNode(params []json.RawMessage) (any, error) {
+	var node, command string
+	err := param(params, 0, "node", &node, true)
+	if err != nil {
+		return nil, err
+	}
+	err = param(params, 1, "command", &command, true)
+	if err != nil {
+		return nil, err
+	}
+	if command != "add" && command != "remove" && command != "onetry" {
+		return nil, newError(CodeMiscError, "Invalid command %q, expected add, remove or onetry", command)
+	}
+	addr, err := net.ResolveTCPAddr("tcp", node)
+	if err != nil {
+		return nil, newError(CodeInvalidParameter, "Invalid node address %s: %s", node, err)
+	}
+
+	switch command {
+	case "add":
+		if !s.node.AddManualPeer(addr) {
+			return nil, newError(CodeClientNodeAlreadyAdded, "Error: Node already added")
+		}
+	case "remove":
+		if !s.node.RemoveManualPeer(addr) {
+			return nil, newError(CodeClientNodeNotAdded, "Error: Node could not be removed. It has not been added previously.")
+		}
+	case "onetry":
+		_, err = s.node.AddPeer(addr)
+		if err != nil {
+			return nil, newError(CodeClientNodeNotConnected, "Could not connect to %s: %s", node, err)
+		}
+	}
+	return nil, nil
+}
+
+// disconnectNode disconnects the peer with the address of the first parameter or, if it is empty or not passed, the peer with the id of the
+// second parameter
+func (s *Server) disconnectNode(params []json.RawMessage) (any, error) {
+	var address string
+	err := param(params, 0, "address", &address, false)
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	err = param(params, 1, "nodeid", &id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var disconnected bool
+	switch {
+	case passed(params, 0) && !passed(params, 1):
+		disconnected = s.node.DisconnectPeer(address)
+	case passed(params, 1) && address == "":
+		disconnected = s.node.DisconnectPeerById(id)
+	default:
+		return nil, newError(CodeInvalidParameter, "Only one of address and nodeid should be provided.")
+	}
+	if !disconnected {
+		return nil, newError(CodeClientNodeNotConnected, "Node not found in connected nodes")
+	}
+	return nil, nil
+}
+
+// BannedSubnet describes a ban, as listbanned returns it (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/net.cpp#L802-L837)
+type BannedSubnet struct {
+	Address string `json:"address"`
+	// Unix times of the ban and of its end, and their difference and the seconds left in seconds
+	BanCreated    int64 `json:"ban_created"`
+	BannedUntil   int64 `json:"banned_until"`
+	BanDuration   int64 `json:"ban_duration"`
+	TimeRemaining int64 `json:"time_remaining"`
+}
+
+// setBan applies the command of the second parameter to the IP address or subnet (in CIDR notation) of the first: "add" bans it, for the
+// seconds of the third parameter (24 hours if it is 0 or not passed) or until the unix time of the third parameter if the fourth is true, and
+// "remove" lifts its ban
+func (s *Server) setBan(params []json.RawMessage) (any, error) {
+	var subnetParam, command string
+	err := param(params, 0, "subnet", &subnetParam, true)
+	if err != nil {
+		return nil, err
+	}
+	err = param(params, 1, "command", &command, true)
+	if err != nil {
+		return nil, err
+	}
+	var banTime int64
+	err = param(params, 2, "bantime", &banTime, false)
+	if err != nil {
+		return nil, err
+	}
+	var absolute bool
+	err = param(params, 3, "absolute", &absolute, false)
+	if err != nil {
+		return nil, err
+	}
+	if command != "add" && command != "remove" {
+		return nil, newError(CodeMiscError, "Invalid command %q, expected add or remove", command)
+	}
+	subnet, ok := parseSubnet(subnetParam)
+	if !ok {
+		return nil, newError(CodeClientInvalidIPOrSubnet, "Error: Invalid IP/Subnet")
+	}
+
+	if command == "remove" {
+		if !s.node.UnbanSubnet(subnet) {
+			return nil, newError(CodeClientInvalidIPOrSubnet, "Error: Unban failed. Requested address/subnet was not previously manually banned.")
+		}
+		return nil, nil
+	}
+	until := time.Now().Add(constants.BanDuration)
+	switch {
+	case absolute:
+		until = time.Unix(banTime, 0)
+	case banTime > 0:
+		until = time.Now().Add(time.Duration(banTime) * time.Second)
+	}
+	if !s.node.BanSubnet(subnet, until) {
+		return nil, newError(CodeClientNodeAlreadyAdded, "Error: IP/Subnet already banned")
+	}
+	return nil, nil
+}
+
+// parseSubnet parses s as a subnet in CIDR notation, or as an IP address making up a subnet on its own
+func parseSubnet(s string) (*net.IPNet, bool) {
+	if strings.Contains(s, "/") {
+		_, subnet, err := net.ParseCIDR(s)
+		return subnet, err == nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, true
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, true
+}
+
+// listBanned describes the bans which have not expired yet
+func (s *Server) listBanned(params []json.RawMessage) (any, error) {
+	now := time.Now()
+	bans := s.node.Bans()
+	banned := make([]BannedSubnet, len(bans))
+	for i, ban := range bans {
+		banned[i] = BannedSubnet{
+			Address:       ban.Subnet.String(),
+			BanCreated:    unixTime(ban.Created),
+			BannedUntil:   ban.Until.Unix(),
+			BanDuration:   ban.Until.Unix() - unixTime(ban.Created),
+			TimeRemaining: ban.Until.Unix() - now.Unix(),
+		}
+	}
+	return banned, nil
+}
+
+// clearBanned lifts every ban
+func (s *Server) clearBanned(params []json.RawMessage) (any, error) {
+	s.node.ClearBans()
+	return nil, nil
+}
+
+// NetworkInfo describes the node's presence on the peer-to-peer network, as getnetworkinfo returns it
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/net.cpp#L636-L707)
+type NetworkInfo struct {
+	Version            int32    `json:"version"`
+	SubVersion         string   `json:"subversion"`
+	ProtocolVersion    uint32   `json:"protocolversion"`
+	LocalServices      string   `json:"localservices"`
+	LocalServicesNames []string `json:"localservicesnames"`
+	// Whether transactions are relayed, which they always are
+	LocalRelay bool `json:"localrelay"`
+	// Offset of the node's clock from its peers' clocks in seconds, which the node does not estimate
+	TimeOffset     int64           `json:"timeoffset"`
+	NetworkActive  bool            `json:"networkactive"`
+	Connections    int             `json:"connections"`
+	ConnectionsIn  int             `json:"connections_in"`
+	ConnectionsOut int             `json:"connections_out"`
+	Networks       []NetworkStatus `json:"networks"`
+	// Minimum relay fee rate, and the fee rate replacements must add, in BTC per 1000 virtual bytes
+	RelayFee       Amount         `json:"relayfee"`
+	IncrementalFee Amount         `json:"incrementalfee"`
+	LocalAddresses []LocalAddress `json:"localaddresses"`
+	Warnings       string         `json:"warnings"`
+}
+
+// NetworkStatus describes whether peers are reached through a network
+type NetworkStatus struct {
+	Name networking.Network `json:"name"`
+	// Whether the node only connects to peers of the network
+	Limited bool `json:"limited"`
+	// Whether the node can connect to peers of the network
+	Reachable                 bool   `json:"reachable"`
+	Proxy                     string `json:"proxy"`
+	ProxyRandomizeCredentials bool   `json:"proxy_randomize_credentials"`
+}
+
+// LocalAddress is an address the node advertises to its peers, whose score is how many peers reported seeing the node at it
+type LocalAddress struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Score   int    `json:"score"`
+}
+
+// NetTotals describes the traffic with the peers, as getnettotals returns it (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/net.cpp#L540-L575)
+type NetTotals struct {
+	TotalBytesRecv uint64       `json:"totalbytesrecv"`
+	TotalBytesSent uint64       `json:"totalbytessent"`
+	TimeMillis     int64        `json:"timemillis"`
+	UploadTarget   UploadTarget `json:"uploadtarget"`
+}
+
+// UploadTarget describes the limit of the bytes sent to peers per timeframe, which the node does not enforce
+type UploadTarget struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"target_reached"`
+	ServeHistoricalBlocks bool   `json:"serve_historical_blocks"`
+	BytesLeftInCycle      uint64 `json:"bytes_left_in_cycle"`
+	TimeLeftInCycle       int64  `json:"time_left_in_cycle"`
+}
+
+// getNetworkInfo describes the node's version, services, connections, relay fees and local addresses
+func (s *Server) getNetworkInfo(params []json.RawMessage) (any, error) {
+	info := s.node.NetworkInfo()
+	result := NetworkInfo{
+		Version:            constants.ClientVersion,
+		SubVersion:         info.UserAgent,
+		ProtocolVersion:    info.ProtocolVersion,
+		LocalServices:      fmt.Sprintf("%016x", uint64(info.Services)),
+		LocalServicesNames: info.Services.Names(),
+		LocalRelay:         true,
+		NetworkActive:      true,
+		Connections:        info.ConnectionsIn + info.ConnectionsOut,
+		ConnectionsIn:      info.ConnectionsIn,
+		ConnectionsOut:     info.ConnectionsOut,
+		// the node dials IPv4 and IPv6 peers directly, and has no proxy to dial onion peers, which can only connect to it
+		Networks: []NetworkStatus{
+			{Name: networking.NetworkIPv4, Reachable: true},
+			{Name: networking.NetworkIPv6, Reachable: true},
+			{Name: networking.NetworkOnion, Limited: true},
+		},
+		RelayFee:       Amount(info.MinRelayTxFee),
+		IncrementalFee: Amount(info.IncrementalRelayFee),
+		LocalAddresses: []LocalAddress{},
+	}
+	if info.LocalAddr != nil {
+		result.LocalAddresses = append(result.LocalAddresses, LocalAddress{
+			Address: info.LocalAddr.IP.String(),
+			Port:    info.LocalAddr.Port,
+			Score:   info.LocalAddrVotes,
+		})
+	}
+	return result, nil
+}
+
+// getNetTotals returns the bytes sent to and received from all peers since the node started
+func (s *Server) getNetTotals(params []json.RawMessage) (any, error) {
+	totals := s.node.NetTotals()
+	return NetTotals{
+		TotalBytesRecv: totals.BytesReceived,
+		TotalBytesSent: totals.BytesSent,
+		TimeMillis:     totals.Time.UnixMilli(),
+		// like bitcoind without -maxuploadtarget
+		UploadTarget: UploadTarget{TimeFrame: 24 * 60 * 60, ServeHistoricalBlocks: true},
+	}, nil
+}
@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_GetPeerInfo(t *testing.T) {
+	s, node := newTestServer()
+	connectedAt := time.Unix(1_700_000_000, 0)
+	node.peers = []networking.PeerInfo{
+		{
+			Id:              0,
+			Addr:            "10.0.0.1:8333",
+			Direction:       networking.Outbound,
+			Network:         networking.NetworkIPv4,
+			ConnectionType:  networking.FullRelay,
+			Services:        message.NodeNetwork | message.NodeWitness,
+			UserAgent:       "/Satoshi:27.0.0/",
+			ProtocolVersion: 70016,
+			StartingHeight:  840_000,
+			ConnectedAt:     connectedAt,
+			LastSend:        connectedAt.Add(time.Minute),
+			LastRecv:        connectedAt.Add(2 * time.Minute),
+			PingLatency:     250 * time.Millisecond,
+			BytesSent:       100,
+			BytesRecv:       200,
+			Relay:           true,
+		},
+		{Id: 3, Addr: "[2001:db8::1]:8333", Direction: networking.Inbound, Network: networking.NetworkIPv6, ConnectedAt: connectedAt},
+		{Id: 4, Addr: "10.0.0.2:8333", Direction: networking.Outbound, ConnectionType: networking.BlockRelay, Manual: true},
+		{Id: 5, Addr: "10.0.0.3:8333", Direction: networking.Outbound, ConnectionType: networking.BlockRelay},
+	}
+
+	var infos []PeerInfo
+	call(t, s, "getpeerinfo", &infos)
+	require.Len(t, infos, 4)
+	require.Equal(t, PeerInfo{
+		Id:             0,
+		Addr:           "10.0.0.1:8333",
+		Network:        networking.NetworkIPv4,
+		Services:       "0000000000000009",
+		ServicesNames:  []string{"NETWORK", "WITNESS"},
+		RelayTxes:      true,
+		LastSend:       1_700_000_060,
+		LastRecv:       1_700_000_120,
+		BytesSent:      100,
+		BytesRecv:      200,
+		ConnTime:       1_700_000_000,
+		PingTime:       0.25,
+		Version:        70016,
+		SubVer:         "/Satoshi:27.0.0/",
+		StartingHeight: 840_000,
+		ConnectionType: "outbound-full-relay",
+	}, infos[0])
+	require.True(t, infos[1].Inbound)
+	require.Equal(t, "inbound", infos[1].ConnectionType)
+	require.Zero(t, infos[1].LastSend)
+	require.Equal(t, []string{}, infos[1].ServicesNames)
+	require.Equal(t, "manual", infos[2].ConnectionType)
+	require.Equal(t, "block-relay-only", infos[3].ConnectionType)
+}
+
+func TestServer_AddNode(t *testing.T) {
+	s, node := newTestServer()
+	node.peers = []networking.PeerInfo{{Id: 0, Addr: "10.0.0.1:8333"}}
+
+	var result any
+	call(t, s, "addnode", &result, "10.0.0.2:8333", "add")
+	require.Nil(t, result)
+	require.True(t, node.manualPeers["10.0.0.2:8333"])
+	require.Equal(t, CodeClientNodeAlreadyAdded, callError(t, s, "addnode", "10.0.0.2:8333", "add").Code)
+
+	call(t, s, "addnode", &result, "10.0.0.2:8333", "remove")
+	require.Empty(t, node.manualPeers)
+	require.Equal(t, CodeClientNodeNotAdded, callError(t, s, "addnode", "10.0.0.2:8333", "remove").Code)
+
+	call(t, s, "addnode", &result, "10.0.0.3:8333", "onetry")
+	require.Equal(t, []string{"10.0.0.3:8333"}, node.addedPeers)
+	require.Equal(t, CodeClientNodeNotConnected, callError(t, s, "addnode", "10.0.0.1:8333", "onetry").Code)
+
+	require.Equal(t, CodeMiscError, callError(t, s, "addnode", "10.0.0.2:8333", "connect").Code)
+	require.Equal(t, CodeInvalidParameter, callError(t, s, "addnode", "10.0.0.2", "add").Code)
+}
+
+func TestServer_DisconnectNode(t *testing.T) {
+	s, node := newTestServer()
+	node.peers = []networking.PeerInfo{{Id: 0, Addr: "10.0.0.1:8333"}, {Id: 1, Addr: "10.0.0.2:8333"}, {Id: 2, Addr: "10.0.0.3:8333"}}
+
+	var result any
+	call(t, s, "disconnectnode", &result, "10.0.0.1:8333")
+	call(t, s, "disconnectnode", &result, "", 1)
+	call(t, s, "disconnectnode", &result, nil, 2)
+	require.Empty(t, node.peers)
+
+	require.Equal(t, CodeClientNodeNotConnected, callError(t, s, "disconnectnode", "10.0.0.1:8333").Code)
+	require.Equal(t, CodeClientNodeNotConnected, callError(t, s, "disconnectnode", "", 0).Code)
+	err := callError(t, s, "disconnectnode", "10.0.0.1:8333", 0)
+	require.Equal(t, &Error{Code: CodeInvalidParameter, Message: "Only one of address and nodeid should be provided."}, err)
+	require.Equal(t, CodeInvalidParameter, callError(t, s, "disconnectnode").Code)
+}
+
+func TestServer_SetBanListBannedAndClearBanned(t *testing.T) {
+	s, node := newTestServer()
+
+	var result any
+	call(t, s, "setban", &result, "10.1.0.0/16", "add")
+	call(t, s, "setban", &result, "2001:db8::1", "add", 3600)
+	call(t, s, "setban", &result, "10.2.0.1", "add", 2_000_000_000, true)
+	require.True(t, node.bans.IsBanned(net.ParseIP("10.1.2.3")))
+	require.Equal(t, CodeClientNodeAlreadyAdded, callError(t, s, "setban", "10.1.2.3/16", "add").Code)
+	require.Equal(t, CodeClientInvalidIPOrSubnet, callError(t, s, "setban", "10.1.0.0/33", "add").Code)
+	require.Equal(t, CodeClientInvalidIPOrSubnet, callError(t, s, "setban", "example.com", "add").Code)
+	require.Equal(t, CodeMiscError, callError(t, s, "setban", "10.3.0.1", "ban").Code)
+
+	var banned []BannedSubnet
+	call(t, s, "listbanned", &banned)
+	require.Len(t, banned, 3)
+	require.Equal(t, "10.1.0.0/16", banned[0].Address)
+	require.InDelta(t, 24*60*60, banned[0].BanDuration, 1)
+	require.InDelta(t, 24*60*60, banned[0].TimeRemaining, 1)
+	require.Equal(t, "10.2.0.1/32", banned[1].Address)
+	require.EqualValues(t, 2_000_000_000, banned[1].BannedUntil)
+	require.Equal(t, "2001:db8::1/128", banned[2].Address)
+	require.InDelta(t, time.Now().Unix(), banned[2].BanCreated, 1)
+	require.InDelta(t, 3600, banned[2].BanDuration, 1)
+
+	call(t, s, "setban", &result, "10.2.0.1", "remove")
+	require.False(t, node.bans.IsBanned(net.ParseIP("10.2.0.1")))
+	require.Equal(t, CodeClientInvalidIPOrSubnet, callError(t, s, "setban", "10.2.0.1", "remove").Code)
+
+	call(t, s, "clearbanned", &result)
+	call(t, s, "listbanned", &banned)
+	require.Empty(t, banned)
+}
+
+func TestServer_GetNetworkInfo(t *testing.T) {
+	s, node := newTestServer()
+	node.networkInfo = networking.NetworkInfo{
+		ProtocolVersion:     70016,
+		UserAgent:           "/bitcoin-node-go:0.0.1/",
+		Services:            message.NodeNetwork | message.NodeWitness,
+		ConnectionsIn:       2,
+		ConnectionsOut:      8,
+		MinRelayTxFee:       1000,
+		IncrementalRelayFee: 1000,
+	}
+
+	var info NetworkInfo
+	call(t, s, "getnetworkinfo", &info)
+	require.Equal(t, NetworkInfo{
+		Version:            1,
+		SubVersion:         "/bitcoin-node-go:0.0.1/",
+		ProtocolVersion:    70016,
+		LocalServices:      "0000000000000009",
+		LocalServicesNames: []string{"NETWORK", "WITNESS"},
+		LocalRelay:         true,
+		NetworkActive:      true,
+		Connections:        10,
+		ConnectionsIn:      2,
+		ConnectionsOut:     8,
+		Networks: []NetworkStatus{
+			{Name: networking.NetworkIPv4, Reachable: true},
+			{Name: networking.NetworkIPv6, Reachable: true},
+			{Name: networking.NetworkOnion, Limited: true},
+		},
+		RelayFee:       1000,
+		IncrementalFee: 1000,
+		LocalAddresses: []LocalAddress{},
+	}, info)
+
+	node.networkInfo.LocalAddr = &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 8333}
+	node.networkInfo.LocalAddrVotes = 3
+	call(t, s, "getnetworkinfo", &info)
+	require.Equal(t, []LocalAddress{{Address: "203.0.113.5", Port: 8333, Score: 3}}, info.LocalAddresses)
+
+	// the fee rates are in BTC per 1000 virtual bytes
+	_, body := post(t, s, `{"method":"getnetworkinfo","id":1}`)
+	require.Contains(t, body, `"relayfee":0.00001000`)
+}
+
+func TestServer_GetNetTotals(t *testing.T) {
+	s, node := newTestServer()
+	node.netTotals = networking.NetTotals{BytesSent: 1000, BytesReceived: 5000, Time: time.UnixMilli(1_700_000_000_123)}
+
+	var totals NetTotals
+	call(t, s, "getnettotals", &totals)
+	require.Equal(t, NetTotals{
+		TotalBytesRecv: 5000,
+		TotalBytesSent: 1000,
+		TimeMillis:     1_700_000_000_123,
+		UploadTarget:   UploadTarget{TimeFrame: 86400, ServeHistoricalBlocks: true},
+	}, totals)
+}
@@ -0,0 +1,224 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/script"
+)
+
+// Maximum fee rate of the transactions sent with sendrawtransaction, unless the client sets one (0.10 BTC per 1000 virtual bytes, like
+// bitcoind)
+const defaultMaxRawTxFeeRate Amount = satoshisPerBitcoin / 10
+
+// Errors the mempool rejects transactions with, which are reported to clients as rejections rather than failures
+var mempoolRejections = []error{
+	networking.ErrInvalidTx,
+	networking.ErrTxConflict,
+	networking.ErrMempoolMinFee,
+	networking.ErrMempoolFull,
+	networking.ErrTooLongMempoolChain,
+	networking.ErrDust,
+	networking.ErrMinRelayFee,
+}
+
+// RawTransaction is the result of getrawtransaction with verbosity 1: the decoded transaction and, if it is in a block, the block
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/rawtransaction.cpp#L56-L91)
+type RawTransaction struct {
+	// Whether the block is in the active chain, only returned when the block was given
+	InActiveChain *bool `json:"in_active_chain,omitempty"`
+	Tx
+	BlockHash string `json:"blockhash,omitempty"`
+	// Number of blocks of the active chain from the block to the tip, 0 if the block is not in the active chain
+	Confirmations *int32 `json:"confirmations,omitempty"`
+	// Timestamp of the block, only returned for blocks of the active chain
+	Time      uint32 `json:"time,omitempty"`
+	BlockTime uint32 `json:"blocktime,omitempty"`
+}
+
+// getRawTransaction returns the transaction with the txid of the first parameter, in hex with verbosity 0 (or false, the default) and
+// decoded with verbosity 1 or more (or true). It is looked for in the block with the hash of the third parameter if one is given, and
+// otherwise in the mempool and then, if the node has a transaction index, in the active chain.
+func (s *Server) getRawTransaction(params []json.RawMessage) (any, error) {
+	txId, err := hashParam(params, 0, "txid")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := verbosityParam(params, 1, "verbose", 0)
+	if err != nil {
+		return nil, err
+	}
+	var givenBlock *message.Hash256
+	if passed(params, 2) {
+		hash, err := hashParam(params, 2, "blockhash")
+		if err != nil {
+			return nil, err
+		}
+		givenBlock = &hash
+	}
+
+	tx, blockHash, err := s.findTransaction(txId, givenBlock)
+	if err != nil {
+		return nil, err
+	}
+	if verbosity <= 0 {
+		encoded, err := tx.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(encoded), nil
+	}
+	decoded, err := decodeTx(tx, s.node.NetworkParams())
+	if err != nil {
+		return nil, err
+	}
+	result := RawTransaction{Tx: decoded}
+	if blockHash == nil {
+		return result, nil
+	}
+	info, err := s.node.BlockHeaderInfo(*blockHash)
+	if err != nil {
+		return nil, blockError(err)
+	}
+	result.BlockHash = blockHash.String()
+	confirmations := max(info.Confirmations, 0)
+	result.Confirmations = &confirmations
+	if confirmations > 0 {
+		result.Time = info.Header.Timestamp
+		result.BlockTime = info.Header.Timestamp
+	}
+	if givenBlock != nil {
+		inActiveChain := confirmations > 0
+		result.InActiveChain = &inActiveChain
+	}
+	return result, nil
+}
+
+// findTransaction returns the transaction with txid txId and the hash of the block holding it, nil if it is in the mempool. The transaction
+// is looked for in the block with hash blockHash if it is not nil, and otherwise in the mempool and then in the transaction index.
+func (s *Server) findTransaction(txId message.Hash256, blockHash *message.Hash256) (*message.TxPayload, *message.Hash256, error) {
+	if blockHash != nil {
+		block, err := s.node.GetBlock(*blockHash)
+		if errors.Is(err, networking.ErrBlockNotFound) {
+			return nil, nil, newError(CodeInvalidAddressOrKey, "Block hash not found")
+		}
+		if err != nil {
+			return nil, nil, blockError(err)
+		}
+		for i := range block.Transactions {
+			id, err := block.Transactions[i].GetTxId()
+			if err != nil {
+				return nil, nil, err
+			}
+			if id == txId {
+				return &block.Transactions[i], blockHash, nil
+			}
+		}
+		return nil, nil, newError(CodeInvalidAddressOrKey, "No such transaction found in the provided block")
+	}
+	if tx, ok := s.node.MempoolTx(txId); ok {
+		return tx, nil, nil
+	}
+	tx, hash, err := s.node.GetTransaction(txId)
+	switch {
+	case errors.Is(err, networking.ErrTxIndexDisabled):
+		return nil, nil, newError(CodeInvalidAddressOrKey,
+			"No such mempool transaction. Use -txindex or provide a block hash to enable blockchain transaction queries")
+	case errors.Is(err, networking.ErrTxNotFound):
+		return nil, nil, newError(CodeInvalidAddressOrKey, "No such mempool or blockchain transaction")
+	case err != nil:
+		return nil, nil, err
+	}
+	return tx, &hash, nil
+}
+
+// decodeRawTransaction decodes the transaction serialized in hex in the first parameter, without looking it up
+func (s *Server) decodeRawTransaction(params []json.RawMessage) (any, error) {
+	tx, err := txParam(params, 0, "hexstring")
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeTx(tx, s.node.NetworkParams())
+	if err != nil {
+		return nil, err
+	}
+	// like bitcoind, the transaction the client already has is not sent back
+	decoded.Hex = ""
+	return decoded, nil
+}
+
+// sendRawTransaction adds the transaction serialized in hex in the first parameter to the mempool and announces it to the peers, and returns
+// its txid. The transaction is rejected if it pays a higher fee rate than the second parameter, in BTC per 1000 virtual bytes (0 for no
+// limit), or burns more than the third parameter, in BTC, in unspendable outputs.
+func (s *Server) sendRawTransaction(params []json.RawMessage) (any, error) {
+	tx, err := txParam(params, 0, "hexstring")
+	if err != nil {
+		return nil, err
+	}
+	maxFeeRate := defaultMaxRawTxFeeRate
+	err = param(params, 1, "maxfeerate", &maxFeeRate, false)
+	if err != nil {
+		return nil, err
+	}
+	var maxBurnAmount Amount
+	err = param(params, 2, "maxburnamount", &maxBurnAmount, false)
+	if err != nil {
+		return nil, err
+	}
+	if maxFeeRate < 0 || maxBurnAmount < 0 {
+		return nil, newError(CodeTypeError, "Amount out of range")
+	}
+	for _, txOut := range tx.TransactionOutputs {
+		if script.IsUnspendable(txOut.PkScript) && Amount(txOut.Value) > maxBurnAmount {
+			return nil, newError(CodeVerifyError, "Unspendable output exceeds maximum configured by user (maxburnamount)")
+		}
+	}
+
+	txId, err := s.node.SubmitTransaction(tx, int64(maxFeeRate))
+	if errors.Is(err, networking.ErrMaxFeeExceeded) {
+		return nil, newError(CodeVerifyError, "Fee exceeds maximum configured by user (e.g. -maxtxfee, maxfeerate)")
+	}
+	// bitcoind fails rather than rejects transactions whose inputs are missing (https://github.com/bitcoin/bitcoin/blob/v27.0/src/util/error.cpp)
+	if errors.Is(err, networking.ErrMissingInputs) {
+		return nil, newError(CodeVerifyError, "Inputs missing or spent")
+	}
+	var rejected *networking.ErrTxRejected
+	if errors.As(err, &rejected) {
+		return nil, newError(CodeVerifyRejected, "%s (%s)", rejected.Reason, rejected.Err)
+	}
+	for _, rejection := range mempoolRejections {
+		if errors.Is(err, rejection) {
+			return nil, newError(CodeVerifyRejected, "%s", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return txId.String(), nil
+}
+
+// txParam decodes the required parameter at index i of params, whose name is name, as a transaction serialized in hex
+func txParam(params []json.RawMessage, i int, name string) (*message.TxPayload, error) {
+	var s string
+	err := param(params, i, name, &s, true)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, newError(CodeDeserializationError, "TX decode failed")
+	}
+	tx, err := message.DecodeTxPayload(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, newError(CodeDeserializationError, "TX decode failed")
+	}
+	// the decoder reads ahead, so trailing bytes are detected by encoding the transaction back
+	reencoded, err := tx.Encode()
+	if err != nil || len(reencoded) != len(encoded) {
+		return nil, newError(CodeDeserializationError, "TX decode failed")
+	}
+	return tx, nil
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestServer_GetRawTransaction(t *testing.T) {
+	s, node := newTestServer()
+	blockHash := addGenesisBlock(t, node)
+	coinbase := &node.blocks[blockHash].Transactions[0]
+	coinbaseId, err := coinbase.GetTxId()
+	require.NoError(t, err)
+	encodedCoinbase, err := coinbase.Encode()
+	require.NoError(t, err)
+
+	mempoolTx := &message.TxPayload{
+		Version:            2,
+		TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: coinbaseId}, Sequence: 0xffffffff}},
+		TransactionOutputs: []message.TxOut{{Value: 1000, PkScript: []byte{0x51}}},
+	}
+	mempoolTxId, err := mempoolTx.GetTxId()
+	require.NoError(t, err)
+	node.mempool[mempoolTxId] = mempoolTx
+	encodedMempoolTx, err := mempoolTx.Encode()
+	require.NoError(t, err)
+
+	var encoded string
+	call(t, s, "getrawtransaction", &encoded, mempoolTxId.String())
+	require.Equal(t, hex.EncodeToString(encodedMempoolTx), encoded)
+	var tx RawTransaction
+	call(t, s, "getrawtransaction", &tx, mempoolTxId.String(), true)
+	require.Equal(t, mempoolTxId.String(), tx.TxId)
+	require.Equal(t, hex.EncodeToString(encodedMempoolTx), tx.Hex)
+	require.Empty(t, tx.BlockHash, "transactions of the mempool should not be in a block")
+	require.Nil(t, tx.Confirmations)
+
+	t.Run("confirmed transactions should be found with the transaction index or in the given block", func(t *testing.T) {
+		require.Equal(t, CodeInvalidAddressOrKey, callError(t, s, "getrawtransaction", coinbaseId.String()).Code, "the node has no transaction index")
+		var tx RawTransaction
+		call(t, s, "getrawtransaction", &tx, coinbaseId.String(), 1, blockHash.String())
+		require.Equal(t, coinbaseId.String(), tx.TxId)
+		require.Equal(t, blockHash.String(), tx.BlockHash)
+		require.EqualValues(t, 3, *tx.Confirmations)
+		require.Equal(t, node.blocks[blockHash].Timestamp, tx.BlockTime)
+		require.True(t, *tx.InActiveChain)
+
+		node.txIndex = map[message.Hash256]message.Hash256{coinbaseId: blockHash}
+		call(t, s, "getrawtransaction", &encoded, coinbaseId.String(), 0)
+		require.Equal(t, hex.EncodeToString(encodedCoinbase), encoded)
+		tx = RawTransaction{}
+		call(t, s, "getrawtransaction", &tx, coinbaseId.String(), true)
+		require.Equal(t, blockHash.String(), tx.BlockHash)
+		require.EqualValues(t, 3, *tx.Confirmations)
+		require.Nil(t, tx.InActiveChain, "in_active_chain should only be returned when the block is given")
+	})
+
+	t.Run("unknown transactions and blocks should not be found", func(t *testing.T) {
+		unknown := message.Hash256{0x09}.String()
+		require.Equal(t, &Error{Code: CodeInvalidAddressOrKey, Message: "No such mempool or blockchain transaction"},
+			callError(t, s, "getrawtransaction", unknown))
+		require.Equal(t, &Error{Code: CodeInvalidAddressOrKey, Message: "No such transaction found in the provided block"},
+			callError(t, s, "getrawtransaction", unknown, false, blockHash.String()))
+		require.Equal(t, &Error{Code: CodeInvalidAddressOrKey, Message: "Block hash not found"},
+			callError(t, s, "getrawtransaction", coinbaseId.String(), false, unknown))
+	})
+}
+
+func TestServer_DecodeRawTransaction(t *testing.T) {
+	s, node := newTestServer()
+	blockHash := addGenesisBlock(t, node)
+	encoded, err := node.blocks[blockHash].Transactions[0].Encode()
+	require.NoError(t, err)
+
+	var tx Tx
+	call(t, s, "decoderawtransaction", &tx, hex.EncodeToString(encoded))
+	require.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", tx.TxId)
+	require.Empty(t, tx.Hex, "the hex of the transaction should not be sent back")
+	require.Len(t, tx.Vout, 1)
+
+	for _, invalid := range []string{"zz", hex.EncodeToString(encoded[:len(encoded)-1]), hex.EncodeToString(append(encoded, 0x00))} {
+		require.Equal(t, &Error{Code: CodeDeserializationError, Message: "TX decode failed"}, callError(t, s, "decoderawtransaction", invalid))
+	}
+}
+
+func TestServer_SendRawTransaction(t *testing.T) {
+	s, node := newTestServer()
+	tx := &message.TxPayload{
+		Version:            2,
+		TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: message.Hash256{0x01}}, Sequence: 0xffffffff}},
+		TransactionOutputs: []message.TxOut{{Value: 1000, PkScript: []byte{0x51}}, {Value: 0, PkScript: []byte{0x6a, 0x01, 0x07}}},
+	}
+	encoded, err := tx.Encode()
+	require.NoError(t, err)
+	txId, err := tx.GetTxId()
+	require.NoError(t, err)
+
+	var result string
+	call(t, s, "sendrawtransaction", &result, hex.EncodeToString(encoded))
+	require.Equal(t, txId.String(), result)
+	require.Contains(t, node.mempool, txId)
+	require.EqualValues(t, 10_000_000, node.maxFeeRate, "the default maximum fee rate should be 0.10 BTC/kvB")
+	call(t, s, "sendrawtransaction", &result, hex.EncodeToString(encoded), 0)
+	require.Zero(t, node.maxFeeRate)
+	call(t, s, "sendrawtransaction", &result, hex.EncodeToString(encoded), 0.0002)
+	require.EqualValues(t, 20_000, node.maxFeeRate)
+
+	t.Run("transactions burning more than the maximum burn amount should be rejected", func(t *testing.T) {
+		burning := *tx
+		burning.TransactionOutputs = []message.TxOut{{Value: 1000, PkScript: []byte{0x6a}}}
+		encoded, err := burning.Encode()
+		require.NoError(t, err)
+		require.Equal(t, CodeVerifyError, callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded)).Code)
+		call(t, s, "sendrawtransaction", &result, hex.EncodeToString(encoded), nil, 0.00001)
+	})
+
+	t.Run("rejections should be reported with the reason", func(t *testing.T) {
+		node.submitErr = fmt.Errorf("%w: output 0 is worth 1 satoshis, less than 546", networking.ErrDust)
+		require.Equal(t, &Error{Code: CodeVerifyRejected, Message: "dust output: output 0 is worth 1 satoshis, less than 546"},
+			callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded)))
+		node.submitErr = fmt.Errorf("%w: fee of 2000 satoshis is above 1000 satoshis", networking.ErrMaxFeeExceeded)
+		require.Equal(t, &Error{Code: CodeVerifyError, Message: "Fee exceeds maximum configured by user (e.g. -maxtxfee, maxfeerate)"},
+			callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded)))
+		node.submitErr = &networking.ErrTxRejected{Reason: networking.RejectMissingInputs, Err: fmt.Errorf("%w: input 0 spends unknown output", networking.ErrMissingInputs)}
+		require.Equal(t, &Error{Code: CodeVerifyError, Message: "Inputs missing or spent"}, callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded)))
+		node.submitErr = &networking.ErrTxRejected{Reason: networking.RejectScriptFailure,
+			Err: fmt.Errorf("%w: input 0: %w: output script evaluated to false", networking.ErrInvalidTx, script.ErrScriptFailed)}
+		require.Equal(t, &Error{Code: CodeVerifyRejected,
+			Message: "mandatory-script-verify-flag-failed (invalid transaction: input 0: script failed: output script evaluated to false)"},
+			callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded)))
+		require.Equal(t, CodeDeserializationError, callError(t, s, "sendrawtransaction", "00").Code)
+		require.Equal(t, CodeTypeError, callError(t, s, "sendrawtransaction", hex.EncodeToString(encoded), -1).Code)
+	})
+}
@@ -0,0 +1,234 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Formats the REST resources are served in, named by the extension of their path
+const (
+	formatBin  = "bin"
+	formatHex  = "hex"
+	formatJSON = "json"
+)
+
+// Number of headers /rest/headers returns by default, and at most (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rest.cpp#L37)
+const (
+	defaultRESTHeaderCount = 5
+	maxRESTHeaderCount     = 2000
+)
+
+// REST serves the unauthenticated, read-only REST interface of bitcoind, at the same paths and in the same formats
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/doc/REST-interface.md)
+type REST struct {
+	node Node
+	mux  *http.ServeMux
+}
+
+func NewREST(node Node) *REST {
+	r := &REST{node: node, mux: http.NewServeMux()}
+	r.mux.HandleFunc("GET /rest/block/{resource}", func(w http.ResponseWriter, req *http.Request) { r.block(w, req, 2) })
+	r.mux.HandleFunc("GET /rest/block/notxdetails/{resource}", func(w http.ResponseWriter, req *http.Request) { r.block(w, req, 1) })
+	r.mux.HandleFunc("GET /rest/headers/{resource}", r.headers)
+	r.mux.HandleFunc("GET /rest/mempool/{resource}", r.mempool)
+	return r
+}
+
+func (r *REST) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// block serves the block whose hash the path names, serialized or, in JSON, decoded with verbosity (see getblock)
+func (r *REST) block(w http.ResponseWriter, req *http.Request, verbosity int) {
+	hashStr, format, ok := parseResource(w, req)
+	if !ok {
+		return
+	}
+	hash, ok := parseRESTHash(w, hashStr)
+	if !ok {
+		return
+	}
+	block, err := r.node.GetBlock(hash)
+	switch {
+	case errors.Is(err, networking.ErrBlockNotFound):
+		restError(w, http.StatusNotFound, "%s not found", hashStr)
+		return
+	case errors.Is(err, networking.ErrBlockNotStored):
+		restError(w, http.StatusNotFound, "%s not available (not fully downloaded)", hashStr)
+		return
+	case err != nil:
+		restError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	if format != formatJSON {
+		encoded, err := block.Encode()
+		if err != nil {
+			restError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		writeRESTData(w, format, encoded)
+		return
+	}
+	info, err := r.node.BlockHeaderInfo(hash)
+	if err != nil {
+		restError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	decoded, err := decodeBlock(block, info, verbosity, r.node.NetworkParams())
+	if err != nil {
+		restError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	writeRESTJSON(w, decoded)
+}
+
+// headers serves the headers of the active chain from the block whose hash the path names, as many as the count query parameter asks for
+// (5 by default). No header is served if the block is not in the active chain.
+func (r *REST) headers(w http.ResponseWriter, req *http.Request) {
+	hashStr, format, ok := parseResource(w, req)
+	if !ok {
+		return
+	}
+	hash, ok := parseRESTHash(w, hashStr)
+	if !ok {
+		return
+	}
+	count := defaultRESTHeaderCount
+	if countStr := req.URL.Query().Get("count"); countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 || count > maxRESTHeaderCount {
+			restError(w, http.StatusBadRequest, "Header count is invalid or out of acceptable range (1-%d): %s", maxRESTHeaderCount, countStr)
+			return
+		}
+	}
+
+	var infos []networking.BlockHeaderInfo
+	for next := &hash; next != nil && len(infos) < count; {
+		info, err := r.node.BlockHeaderInfo(*next)
+		if err != nil || info.Confirmations < 0 {
+			break
+		}
+		infos = append(infos, info)
+		next = info.NextBlockHash
+	}
+
+	if format != formatJSON {
+		var encoded bytes.Buffer
+		for _, info := range infos {
+			header, err := info.Header.Encode()
+			if err != nil {
+				restError(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+			encoded.Write(header[:blockHeaderSize])
+		}
+		writeRESTData(w, format, encoded.Bytes())
+		return
+	}
+	headers := make([]BlockHeader, len(infos))
+	for i, info := range infos {
+		var err error
+		headers[i], err = decodeHeader(info)
+		if err != nil {
+			restError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
+	writeRESTJSON(w, headers)
+}
+
+// mempool serves info.json, which describes the mempool like getmempoolinfo, and contents.json, which describes its transactions by txid
+// like getrawmempool, or only lists their txids if the verbose query parameter is false
+func (r *REST) mempool(w http.ResponseWriter, req *http.Request) {
+	resource, format, ok := parseResource(w, req)
+	if !ok {
+		return
+	}
+	if format != formatJSON {
+		restError(w, http.StatusNotFound, "output format not found (available: json)")
+		return
+	}
+	switch resource {
+	case "info":
+		writeRESTJSON(w, mempoolInfo(r.node.MempoolInfo()))
+	case "contents":
+		verbose := req.URL.Query().Get("verbose") != "false"
+		entries := r.node.MempoolContents()
+		if !verbose {
+			txIds := make([]string, len(entries))
+			for i, entry := range entries {
+				txIds[i] = entry.TxId
+			}
+			writeRESTJSON(w, txIds)
+			return
+		}
+		contents := make(map[string]MempoolEntry, len(entries))
+		for _, entry := range entries {
+			contents[entry.TxId] = mempoolEntry(entry)
+		}
+		writeRESTJSON(w, contents)
+	default:
+		restError(w, http.StatusBadRequest, "Invalid URI format. Expected /rest/mempool/<info|contents>.json")
+	}
+}
+
+// parseResource splits the last segment of the path of req into the resource it names and the format it asks for, or answers that the format
+// is not supported
+func parseResource(w http.ResponseWriter, req *http.Request) (string, string, bool) {
+	resource := req.PathValue("resource")
+	dot := strings.LastIndexByte(resource, '.')
+	if dot >= 0 && slices.Contains([]string{formatBin, formatHex, formatJSON}, resource[dot+1:]) {
+		return resource[:dot], resource[dot+1:], true
+	}
+	restError(w, http.StatusNotFound, "output format not found (available: .bin, .hex, .json)")
+	return "", "", false
+}
+
+// parseRESTHash parses s as a hash in big-endian hex, or answers that it is invalid
+func parseRESTHash(w http.ResponseWriter, s string) (message.Hash256, bool) {
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) != len(message.Hash256{}) {
+		restError(w, http.StatusBadRequest, "Invalid hash: %s", s)
+		return message.Hash256{}, false
+	}
+	slices.Reverse(decoded)
+	return message.Hash256(decoded), true
+}
+
+// writeRESTData answers with data, as is with formatBin and in hex followed by a newline with formatHex
+func writeRESTData(w http.ResponseWriter, format string, data []byte) {
+	if format == formatBin {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = fmt.Fprintln(w, hex.EncodeToString(data))
+}
+
+func writeRESTJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("⚠️ Could not write REST response due to error: %s", err)
+	}
+}
+
+// restError answers with status and a plain text message, like bitcoind
+func restError(w http.ResponseWriter, status int, format string, args ...any) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, format+"\r\n", args...)
+}
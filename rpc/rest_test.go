@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const genesisHash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+// get requests path from r, and returns the status and body of the response
+func get(t *testing.T, r *REST, path string) (int, string) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestREST_Block(t *testing.T) {
+	_, node := newTestServer()
+	addGenesisBlock(t, node)
+	r := NewREST(node)
+
+	status, body := get(t, r, "/rest/block/"+genesisHash+".hex")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, constants.MainnetParams.GenesisBlock+"\n", body)
+	status, body = get(t, r, "/rest/block/"+genesisHash+".bin")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, constants.MainnetParams.GenesisBlock, hex.EncodeToString([]byte(body)))
+
+	var block Block
+	status, body = get(t, r, "/rest/block/"+genesisHash+".json")
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &block))
+	require.Equal(t, genesisHash, block.Hash)
+	require.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", block.Tx[0].(map[string]any)["txid"])
+	status, body = get(t, r, "/rest/block/notxdetails/"+genesisHash+".json")
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &block))
+	require.Equal(t, []any{"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"}, block.Tx)
+
+	status, body = get(t, r, "/rest/block/"+strings.Repeat("00", 32)+".json")
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, strings.Repeat("00", 32)+" not found\r\n", body)
+	status, _ = get(t, r, "/rest/block/"+genesisHash+".xml")
+	require.Equal(t, http.StatusNotFound, status)
+	status, body = get(t, r, "/rest/block/1234.json")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "Invalid hash: 1234\r\n", body)
+
+	// the interface is read-only
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rest/block/"+genesisHash+".json", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestREST_Headers(t *testing.T) {
+	_, node := newTestServer()
+	hash := addGenesisBlock(t, node)
+	// the genesis block is followed by a block whose header is known, and which is followed by a block outside the active chain
+	genesis := node.headers[hash]
+	next := *genesis.NextBlockHash
+	header := genesis.Header
+	header.PrevBlock = hash
+	stale := message.Hash256{0x03}
+	node.headers[next] = networking.BlockHeaderInfo{Hash: next, Header: header, Height: 1, Confirmations: 2, NextBlockHash: &stale}
+	node.headers[stale] = networking.BlockHeaderInfo{Hash: stale, Header: header, Height: 2, Confirmations: -1}
+	rest := NewREST(node)
+
+	status, body := get(t, rest, "/rest/headers/"+genesisHash+".hex")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, strings.TrimSpace(body), 2*2*blockHeaderSize)
+	require.True(t, strings.HasPrefix(constants.MainnetParams.GenesisBlock, body[:2*blockHeaderSize]))
+
+	status, body = get(t, rest, "/rest/headers/"+genesisHash+".bin?count=1")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, body, blockHeaderSize)
+
+	var headers []BlockHeader
+	status, body = get(t, rest, "/rest/headers/"+genesisHash+".json?count=10")
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &headers))
+	require.Len(t, headers, 2)
+	require.Equal(t, genesisHash, headers[0].Hash)
+	require.Equal(t, next.String(), headers[1].Hash)
+
+	// blocks outside the active chain have no headers served
+	status, body = get(t, rest, "/rest/headers/"+stale.String()+".json")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "[]\n", body)
+
+	for _, count := range []string{"0", "2001", "five"} {
+		status, _ = get(t, rest, "/rest/headers/"+genesisHash+".json?count="+count)
+		require.Equal(t, http.StatusBadRequest, status, count)
+	}
+}
+
+func TestREST_Mempool(t *testing.T) {
+	_, node := newTestServer()
+	fee := int64(2000)
+	node.mempoolInfo = networking.MempoolInfo{Size: 1, Bytes: 200, Usage: 1024, MaxMempool: 300_000_000, TotalFee: fee, MempoolMinFee: 1000,
+		MinRelayTxFee: 1000, IncrementalRelayFee: 1000}
+	node.mempoolEntries = []networking.MempoolEntryInfo{{
+		TxId:        strings.Repeat("11", 32),
+		WtxId:       strings.Repeat("22", 32),
+		VSize:       200,
+		Fee:         &fee,
+		Time:        time.Unix(1_700_000_000, 0),
+		Ancestors:   networking.PackageStats{Count: 1, VSize: 200, Fees: fee},
+		Descendants: networking.PackageStats{Count: 1, VSize: 200, Fees: fee},
+		Depends:     []string{},
+		SpentBy:     []string{},
+	}}
+	rest := NewREST(node)
+
+	status, body := get(t, rest, "/rest/mempool/info.json")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"loaded":true,"size":1,"bytes":200,"usage":1024,"total_fee":0.00002,"maxmempool":300000000,"mempoolminfee":0.00001,
+		"minrelaytxfee":0.00001,"incrementalrelayfee":0.00001,"unbroadcastcount":0,"fullrbf":false}`, body)
+
+	status, body = get(t, rest, "/rest/mempool/contents.json")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"`+strings.Repeat("11", 32)+`":{"vsize":200,"time":1700000000,"descendantcount":1,"descendantsize":200,"ancestorcount":1,
+		"ancestorsize":200,"wtxid":"`+strings.Repeat("22", 32)+`","fees":{"base":0.00002,"modified":0.00002,"ancestor":0.00002,"descendant":0.00002},
+		"depends":[],"spentby":[],"bip125-replaceable":false,"unbroadcast":false}}`, body)
+	status, body = get(t, rest, "/rest/mempool/contents.json?verbose=false")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `["`+strings.Repeat("11", 32)+`"]`, body)
+
+	status, _ = get(t, rest, "/rest/mempool/info.hex")
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = get(t, rest, "/rest/mempool/entries.json")
+	require.Equal(t, http.StatusBadRequest, status)
+}
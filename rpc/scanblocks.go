@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/aang114/bitcoin-node/script"
+	"strings"
+)
+
+// BlockScan is the result of scanblocks with the start action (https://github.com/bitcoin/bitcoin/blob/v27.0/src/rpc/blockchain.cpp#L2324-L2336)
+type BlockScan struct {
+	FromHeight     int32    `json:"from_height"`
+	ToHeight       int32    `json:"to_height"`
+	RelevantBlocks []string `json:"relevant_blocks"`
+	Completed      bool     `json:"completed"`
+}
+
+// scanBlocks returns the blocks of the active chain whose basic filter matches one of the scan objects, like bitcoind's scanblocks. Scans run
+// while the call waits for them, so the status action always returns null and the abort action false. Scan objects are addr() and raw()
+// output descriptors, as strings or as objects with a desc field.
+func (s *Server) scanBlocks(params []json.RawMessage) (any, error) {
+	var action string
+	err := param(params, 0, "action", &action, true)
+	if err != nil {
+		return nil, err
+	}
+	switch action {
+	case "start":
+	case "status":
+		return nil, nil
+	case "abort":
+		return false, nil
+	default:
+		return nil, newError(CodeInvalidParameter, "Invalid action '%s'", action)
+	}
+
+	if !passed(params, 1) {
+		return nil, newError(CodeMiscError, "scanobjects argument is required for the start action")
+	}
+	var objects []json.RawMessage
+	err = param(params, 1, "scanobjects", &objects, true)
+	if err != nil {
+		return nil, err
+	}
+	scripts := make([][]byte, len(objects))
+	for i, object := range objects {
+		scripts[i], err = scanObjectScript(object, s.node.NetworkParams())
+		if err != nil {
+			return nil, err
+		}
+	}
+	startHeight, stopHeight := int32(0), int32(-1)
+	err = param(params, 2, "start_height", &startHeight, false)
+	if err != nil {
+		return nil, err
+	}
+	err = param(params, 3, "stop_height", &stopHeight, false)
+	if err != nil {
+		return nil, err
+	}
+	filterType := "basic"
+	err = param(params, 4, "filtertype", &filterType, false)
+	if err != nil {
+		return nil, err
+	}
+	if filterType != "basic" {
+		return nil, newError(CodeInvalidAddressOrKey, "Unknown filtertype")
+	}
+
+	scan, err := s.node.ScanBlocks(scripts, startHeight, stopHeight)
+	switch {
+	case errors.Is(err, networking.ErrBlockFilterIndexDisabled):
+		return nil, newError(CodeMiscError, "Index is not enabled for filtertype basic")
+	case errors.Is(err, networking.ErrInvalidScanRange):
+		return nil, newError(CodeInvalidParameter, "Invalid start_height or stop_height")
+	case err != nil:
+		return nil, err
+	}
+	relevant := make([]string, len(scan.RelevantBlocks))
+	for i, hash := range scan.RelevantBlocks {
+		relevant[i] = hash.String()
+	}
+	return BlockScan{FromHeight: scan.FromHeight, ToHeight: scan.ToHeight, RelevantBlocks: relevant, Completed: true}, nil
+}
+
+// scanObjectScript returns the output script of a scan object, an addr(ADDRESS) or raw(HEX) descriptor optionally followed by its checksum,
+// passed as a string or as an object with a desc field
+func scanObjectScript(object json.RawMessage, params constants.NetworkParams) ([]byte, error) {
+	var desc string
+	if json.Unmarshal(object, &desc) != nil {
+		var withDesc struct {
+			Desc string `json:"desc"`
+		}
+		if json.Unmarshal(object, &withDesc) != nil || withDesc.Desc == "" {
+			return nil, newError(CodeInvalidParameter, "Scan object needs to be either a string or an object")
+		}
+		desc = withDesc.Desc
+	}
+
+	// the checksum only guards against typos, which decoding the address or script catches as well
+	expr, _, _ := strings.Cut(desc, "#")
+	switch {
+	case strings.HasPrefix(expr, "addr(") && strings.HasSuffix(expr, ")"):
+		pkScript, err := script.PkScript(expr[len("addr("):len(expr)-1], params)
+		if err != nil {
+			return nil, newError(CodeInvalidAddressOrKey, "Address is not valid: %s", desc)
+		}
+		return pkScript, nil
+	case strings.HasPrefix(expr, "raw(") && strings.HasSuffix(expr, ")"):
+		pkScript, err := hex.DecodeString(expr[len("raw(") : len(expr)-1])
+		if err != nil || len(pkScript) == 0 {
+			return nil, newError(CodeInvalidAddressOrKey, "Raw script is not hex: %s", desc)
+		}
+		return pkScript, nil
+	}
+	return nil, newError(CodeInvalidAddressOrKey, "Unsupported descriptor, only addr() and raw() can be scanned for: %s", desc)
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestServer_ScanBlocks(t *testing.T) {
+	s, node := newTestServer()
+	node.blockScan = networking.BlockScan{FromHeight: 0, ToHeight: 2, RelevantBlocks: []message.Hash256{{0x01}}}
+	const address = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	pkScript, err := hex.DecodeString("0014751e76e8199196d454941c45d1b3a323f1433bd6")
+	require.NoError(t, err)
+
+	var scan BlockScan
+	call(t, s, "scanblocks", &scan, "start", []any{"addr(" + address + ")", map[string]any{"desc": "raw(51)#checksum"}})
+	require.Equal(t, BlockScan{FromHeight: 0, ToHeight: 2, RelevantBlocks: []string{message.Hash256{0x01}.String()}, Completed: true}, scan)
+	require.Equal(t, [][]byte{pkScript, {0x51}}, node.scanScripts)
+	require.Equal(t, [2]int32{0, -1}, node.scanHeights)
+
+	call(t, s, "scanblocks", &scan, "start", []string{"raw(51)"}, 1, 2, "basic")
+	require.Equal(t, [2]int32{1, 2}, node.scanHeights)
+
+	var status any
+	call(t, s, "scanblocks", &status, "status")
+	require.Nil(t, status)
+	var aborted bool
+	call(t, s, "scanblocks", &aborted, "abort")
+	require.False(t, aborted)
+
+	for _, test := range []struct {
+		params []any
+		code   int
+	}{
+		{[]any{"stop"}, CodeInvalidParameter},
+		{[]any{"start"}, CodeMiscError},
+		{[]any{"start", []string{"addr(tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx)"}}, CodeInvalidAddressOrKey},
+		{[]any{"start", []string{"addr(" + address + ")"}, 0, -1, "extended"}, CodeInvalidAddressOrKey},
+		{[]any{"start", []string{"raw(zz)"}}, CodeInvalidAddressOrKey},
+		{[]any{"start", []string{"pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)"}}, CodeInvalidAddressOrKey},
+		{[]any{"start", []any{1}}, CodeInvalidParameter},
+	} {
+		t.Run(fmt.Sprint(test.params), func(t *testing.T) {
+			require.Equal(t, test.code, callError(t, s, "scanblocks", test.params...).Code)
+		})
+	}
+
+	node.scanErr = networking.ErrBlockFilterIndexDisabled
+	require.Equal(t, &Error{Code: CodeMiscError, Message: "Index is not enabled for filtertype basic"}, callError(t, s, "scanblocks", "start", []string{"raw(51)"}))
+	node.scanErr = networking.ErrInvalidScanRange
+	require.Equal(t, CodeInvalidParameter, callError(t, s, "scanblocks", "start", []string{"raw(51)"}, 5).Code)
+}
@@ -0,0 +1,263 @@
+// Package rpc implements the JSON-RPC interface of the node, with the method names, parameters, results and errors of bitcoind so that
+// existing Bitcoin tooling can be pointed at the node (https://github.com/bitcoin/bitcoin/blob/v27.0/doc/JSON-RPC-interface.md).
+package rpc
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Node is the node the server answers for, which *networking.Node implements
+type Node interface {
+	ChainInfo() (networking.ChainInfo, error)
+	GetBlock(hash message.Hash256) (*message.BlockPayload, error)
+	BlockHeaderInfo(hash message.Hash256) (networking.BlockHeaderInfo, error)
+	NetworkParams() constants.NetworkParams
+	MempoolInfo() networking.MempoolInfo
+	MempoolContents() []networking.MempoolEntryInfo
+	MempoolTx(txId message.Hash256) (*message.TxPayload, bool)
+	GetTransaction(txId message.Hash256) (*message.TxPayload, message.Hash256, error)
+	SubmitTransaction(tx *message.TxPayload, maxFeeRate int64) (message.Hash256, error)
+	Peers() []networking.PeerInfo
+	AddPeer(remoteAddr *net.TCPAddr) (*networking.Peer, error)
+	AddManualPeer(remoteAddr *net.TCPAddr) bool
+	RemoveManualPeer(remoteAddr *net.TCPAddr) bool
+	DisconnectPeer(addr string) bool
+	DisconnectPeerById(id int64) bool
+	BanSubnet(subnet *net.IPNet, until time.Time) bool
+	UnbanSubnet(subnet *net.IPNet) bool
+	Bans() []networking.Ban
+	ClearBans()
+	NetworkInfo() networking.NetworkInfo
+	NetTotals() networking.NetTotals
+	LogRuntimeStats() (networking.RuntimeStats, error)
+	Resync() int
+	RequestBlock(hash message.Hash256, peerId int64) error
+	ScanBlocks(scripts [][]byte, startHeight int32, stopHeight int32) (networking.BlockScan, error)
+}
+
+// method is a JSON-RPC method, called with its parameters in order
+type method struct {
+	// names of the parameters in order, so that they can also be passed by name
+	params []string
+	call   func(s *Server, params []json.RawMessage) (any, error)
+}
+
+var methods = map[string]method{
+	"addnode":              {params: []string{"node", "command"}, call: (*Server).addNode},
+	"clearbanned":          {call: (*Server).clearBanned},
+	"decoderawtransaction": {params: []string{"hexstring"}, call: (*Server).decodeRawTransaction},
+	"disconnectnode":       {params: []string{"address", "nodeid"}, call: (*Server).disconnectNode},
+	"dumpstats":            {call: (*Server).dumpStats},
+	"getbestblockhash":     {call: (*Server).getBestBlockHash},
+	"getblock":             {params: []string{"blockhash", "verbosity"}, call: (*Server).getBlock},
+	"getblockchaininfo":    {call: (*Server).getBlockchainInfo},
+	"getblockcount":        {call: (*Server).getBlockCount},
+	"getblockfrompeer":     {params: []string{"blockhash", "peer_id"}, call: (*Server).getBlockFromPeer},
+	"getblockheader":       {params: []string{"blockhash", "verbose"}, call: (*Server).getBlockHeader},
+	"getnettotals":         {call: (*Server).getNetTotals},
+	"getnetworkinfo":       {call: (*Server).getNetworkInfo},
+	"getpeerinfo":          {call: (*Server).getPeerInfo},
+	"getrawtransaction":    {params: []string{"txid", "verbose", "blockhash"}, call: (*Server).getRawTransaction},
+	"listbanned":           {call: (*Server).listBanned},
+	"resync":               {call: (*Server).resync},
+	"scanblocks":           {params: []string{"action", "scanobjects", "start_height", "stop_height", "filtertype"}, call: (*Server).scanBlocks},
+	"sendrawtransaction":   {params: []string{"hexstring", "maxfeerate", "maxburnamount"}, call: (*Server).sendRawTransaction},
+	"setban":               {params: []string{"subnet", "command", "bantime", "absolute"}, call: (*Server).setBan},
+}
+
+type request struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	// Parameters in order as an array, or by name as an object
+	Params json.RawMessage `json:"params"`
+}
+
+// response answers a request the way bitcoind answers JSON-RPC 1.0 requests, with both a result and an error, one of which is null
+type response struct {
+	Result any             `json:"result"`
+	Error  *Error          `json:"error"`
+	Id     json.RawMessage `json:"id"`
+}
+
+// Server answers the JSON-RPC requests of the clients presenting its credentials with HTTP basic authentication, one at a time or in batches
+type Server struct {
+	node     Node
+	user     string
+	password string
+}
+
+func NewServer(node Node, user string, password string) *Server {
+	return &Server{node: node, user: user, password: password}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		err = json.Unmarshal(body, &batch)
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, response{Error: newError(CodeParseError, "Parse error")})
+			return
+		}
+		responses := make([]response, len(batch))
+		for i, raw := range batch {
+			responses[i] = s.handle(raw)
+		}
+		writeResponse(w, http.StatusOK, responses)
+		return
+	}
+	resp := s.handle(body)
+	status := http.StatusOK
+	if resp.Error != nil {
+		status = resp.Error.httpStatus()
+	}
+	writeResponse(w, status, resp)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+// handle answers the request encoded as raw
+func (s *Server) handle(raw json.RawMessage) response {
+	var req request
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		return response{Error: newError(CodeParseError, "Parse error")}
+	}
+	if req.Method == "" {
+		return response{Id: req.Id, Error: newError(CodeInvalidRequest, "Method must be a string")}
+	}
+	m, ok := methods[req.Method]
+	if !ok {
+		return response{Id: req.Id, Error: newError(CodeMethodNotFound, "Method not found")}
+	}
+	params, err := m.positional(req.Params)
+	if err == nil {
+		var result any
+		result, err = m.call(s, params)
+		if err == nil {
+			return response{Id: req.Id, Result: result}
+		}
+	}
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		rpcErr = &Error{Code: CodeMiscError, Message: err.Error()}
+	}
+	return response{Id: req.Id, Error: rpcErr}
+}
+
+// positional returns the parameters of a call to m in order, the ones passed by name put in their place and the missing ones left nil
+func (m method) positional(raw json.RawMessage) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '{' {
+		var named map[string]json.RawMessage
+		err := json.Unmarshal(raw, &named)
+		if err != nil {
+			return nil, newError(CodeInvalidRequest, "Params must be an array or object")
+		}
+		params := make([]json.RawMessage, len(m.params))
+		for name, value := range named {
+			i := slices.Index(m.params, name)
+			if i < 0 {
+				return nil, newError(CodeMiscError, "Unknown named parameter %s", name)
+			}
+			params[i] = value
+		}
+		return params, nil
+	}
+	var params []json.RawMessage
+	err := json.Unmarshal(raw, &params)
+	if err != nil {
+		return nil, newError(CodeInvalidRequest, "Params must be an array or object")
+	}
+	if len(params) > len(m.params) {
+		return nil, newError(CodeMiscError, "Too many parameters: %d given, at most %d expected", len(params), len(m.params))
+	}
+	return params, nil
+}
+
+// param decodes the parameter at index i of params, whose name is name, into value, leaving value as it is if the parameter was not passed.
+// It fails if the parameter is required but was not passed.
+func param(params []json.RawMessage, i int, name string, value any, required bool) error {
+	if !passed(params, i) {
+		if required {
+			return newError(CodeMiscError, "Missing required parameter %s", name)
+		}
+		return nil
+	}
+	err := json.Unmarshal(params[i], value)
+	if err != nil {
+		return newError(CodeTypeError, "Invalid parameter %s: %s", name, params[i])
+	}
+	return nil
+}
+
+// passed reports whether the parameter at index i of params was passed, null counting as not passed
+func passed(params []json.RawMessage, i int) bool {
+	return i < len(params) && params[i] != nil && string(params[i]) != "null"
+}
+
+// hashParam decodes the required parameter at index i of params, whose name is name, as a hash in big-endian hex
+func hashParam(params []json.RawMessage, i int, name string) (message.Hash256, error) {
+	var s string
+	err := param(params, i, name, &s, true)
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	if len(s) != 2*len(message.Hash256{}) {
+		return message.Hash256{}, newError(CodeInvalidParameter, "%s must be of length %d (not %d, for '%s')", name, 2*len(message.Hash256{}), len(s), s)
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return message.Hash256{}, newError(CodeInvalidParameter, "%s must be hexadecimal string (not '%s')", name, s)
+	}
+	slices.Reverse(decoded)
+	return message.Hash256(decoded), nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Printf("⚠️ Could not write RPC response due to error: %s", err)
+	}
+}
@@ -0,0 +1,317 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/networking"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ Node = (*networking.Node)(nil)
+
+// fakeNode answers the queries of the server from its fields
+type fakeNode struct {
+	chainInfo networking.ChainInfo
+	// stored blocks, and the headers of the known blocks
+	blocks  map[message.Hash256]*message.BlockPayload
+	headers map[message.Hash256]networking.BlockHeaderInfo
+	mempool map[message.Hash256]*message.TxPayload
+	// what MempoolInfo and MempoolContents return
+	mempoolInfo    networking.MempoolInfo
+	mempoolEntries []networking.MempoolEntryInfo
+	// hashes of the blocks holding the indexed transactions, nil if the node has no transaction index
+	txIndex map[message.Hash256]message.Hash256
+	// error SubmitTransaction fails with, and the maximum fee rate of the last transaction submitted
+	submitErr  error
+	maxFeeRate int64
+	peers      []networking.PeerInfo
+	// addresses of the manual peers, and of the peers connected to with AddPeer
+	manualPeers map[string]bool
+	addedPeers  []string
+	bans        *networking.BanManager
+	networkInfo networking.NetworkInfo
+	netTotals   networking.NetTotals
+	// what LogRuntimeStats returns
+	runtimeStats networking.RuntimeStats
+	// number of peers Resync asks for headers, and the blocks requested with RequestBlock
+	resyncPeers     int
+	requestedBlocks []message.Hash256
+	// what ScanBlocks returns, and the arguments of its last call
+	blockScan   networking.BlockScan
+	scanErr     error
+	scanScripts [][]byte
+	scanHeights [2]int32
+}
+
+func (f *fakeNode) ChainInfo() (networking.ChainInfo, error) {
+	return f.chainInfo, nil
+}
+
+func (f *fakeNode) GetBlock(hash message.Hash256) (*message.BlockPayload, error) {
+	if block, ok := f.blocks[hash]; ok {
+		return block, nil
+	}
+	if _, ok := f.headers[hash]; ok {
+		return nil, networking.ErrBlockNotStored
+	}
+	return nil, networking.ErrBlockNotFound
+}
+
+func (f *fakeNode) BlockHeaderInfo(hash message.Hash256) (networking.BlockHeaderInfo, error) {
+	if info, ok := f.headers[hash]; ok {
+		return info, nil
+	}
+	return networking.BlockHeaderInfo{}, networking.ErrBlockNotFound
+}
+
+func (f *fakeNode) NetworkParams() constants.NetworkParams {
+	return constants.MainnetParams
+}
+
+func (f *fakeNode) MempoolInfo() networking.MempoolInfo {
+	return f.mempoolInfo
+}
+
+func (f *fakeNode) MempoolContents() []networking.MempoolEntryInfo {
+	return f.mempoolEntries
+}
+
+func (f *fakeNode) MempoolTx(txId message.Hash256) (*message.TxPayload, bool) {
+	tx, ok := f.mempool[txId]
+	return tx, ok
+}
+
+func (f *fakeNode) GetTransaction(txId message.Hash256) (*message.TxPayload, message.Hash256, error) {
+	if f.txIndex == nil {
+		return nil, message.Hash256{}, networking.ErrTxIndexDisabled
+	}
+	hash, ok := f.txIndex[txId]
+	if !ok {
+		return nil, message.Hash256{}, networking.ErrTxNotFound
+	}
+	for i, tx := range f.blocks[hash].Transactions {
+		if id, _ := tx.GetTxId(); id == txId {
+			return &f.blocks[hash].Transactions[i], hash, nil
+		}
+	}
+	return nil, message.Hash256{}, networking.ErrTxNotFound
+}
+
+func (f *fakeNode) SubmitTransaction(tx *message.TxPayload, maxFeeRate int64) (message.Hash256, error) {
+	f.maxFeeRate = maxFeeRate
+	if f.submitErr != nil {
+		return message.Hash256{}, f.submitErr
+	}
+	txId, err := tx.GetTxId()
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	f.mempool[txId] = tx
+	return txId, nil
+}
+
+func (f *fakeNode) Peers() []networking.PeerInfo {
+	return f.peers
+}
+
+func (f *fakeNode) AddPeer(remoteAddr *net.TCPAddr) (*networking.Peer, error) {
+	for _, peer := range f.peers {
+		if peer.Addr == remoteAddr.String() {
+			return nil, networking.ErrPeerAlreadyConnected
+		}
+	}
+	f.addedPeers = append(f.addedPeers, remoteAddr.String())
+	return nil, nil
+}
+
+func (f *fakeNode) AddManualPeer(remoteAddr *net.TCPAddr) bool {
+	if f.manualPeers[remoteAddr.String()] {
+		return false
+	}
+	f.manualPeers[remoteAddr.String()] = true
+	return true
+}
+
+func (f *fakeNode) RemoveManualPeer(remoteAddr *net.TCPAddr) bool {
+	if !f.manualPeers[remoteAddr.String()] {
+		return false
+	}
+	delete(f.manualPeers, remoteAddr.String())
+	return true
+}
+
+func (f *fakeNode) DisconnectPeer(addr string) bool {
+	return f.disconnectPeerMatching(func(peer networking.PeerInfo) bool { return peer.Addr == addr })
+}
+
+func (f *fakeNode) DisconnectPeerById(id int64) bool {
+	return f.disconnectPeerMatching(func(peer networking.PeerInfo) bool { return peer.Id == id })
+}
+
+func (f *fakeNode) disconnectPeerMatching(match func(peer networking.PeerInfo) bool) bool {
+	n := len(f.peers)
+	f.peers = slices.DeleteFunc(f.peers, match)
+	return len(f.peers) < n
+}
+
+func (f *fakeNode) BanSubnet(subnet *net.IPNet, until time.Time) bool {
+	return f.bans.BanSubnet(subnet, until)
+}
+
+func (f *fakeNode) UnbanSubnet(subnet *net.IPNet) bool {
+	return f.bans.UnbanSubnet(subnet)
+}
+
+func (f *fakeNode) Bans() []networking.Ban {
+	return f.bans.Bans()
+}
+
+func (f *fakeNode) ClearBans() {
+	f.bans.Clear()
+}
+
+func (f *fakeNode) NetworkInfo() networking.NetworkInfo {
+	return f.networkInfo
+}
+
+func (f *fakeNode) NetTotals() networking.NetTotals {
+	return f.netTotals
+}
+
+func (f *fakeNode) LogRuntimeStats() (networking.RuntimeStats, error) {
+	return f.runtimeStats, nil
+}
+
+func (f *fakeNode) Resync() int {
+	return f.resyncPeers
+}
+
+func (f *fakeNode) RequestBlock(hash message.Hash256, peerId int64) error {
+	if _, ok := f.headers[hash]; !ok {
+		return networking.ErrBlockNotFound
+	}
+	if _, ok := f.blocks[hash]; ok {
+		return networking.ErrBlockAlreadyStored
+	}
+	if !slices.ContainsFunc(f.peers, func(peer networking.PeerInfo) bool { return peer.Id == peerId }) {
+		return networking.ErrPeerNotFound
+	}
+	f.requestedBlocks = append(f.requestedBlocks, hash)
+	return nil
+}
+
+func (f *fakeNode) ScanBlocks(scripts [][]byte, startHeight int32, stopHeight int32) (networking.BlockScan, error) {
+	f.scanScripts = scripts
+	f.scanHeights = [2]int32{startHeight, stopHeight}
+	return f.blockScan, f.scanErr
+}
+
+func newTestServer() (*Server, *fakeNode) {
+	node := &fakeNode{
+		chainInfo:   networking.ChainInfo{Chain: "mainnet", Height: 2, BestBlockHash: strings.Repeat("ab", 32), Headers: 3},
+		blocks:      make(map[message.Hash256]*message.BlockPayload),
+		headers:     make(map[message.Hash256]networking.BlockHeaderInfo),
+		mempool:     make(map[message.Hash256]*message.TxPayload),
+		manualPeers: make(map[string]bool),
+		bans:        networking.NewBanManager(constants.BanDuration),
+	}
+	return NewServer(node, "user", "password"), node
+}
+
+// post sends body to s as an authenticated client, and returns the status and body of the response
+func post(t *testing.T, s *Server, body string) (int, string) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.SetBasicAuth("user", "password")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	return w.Code, w.Body.String()
+}
+
+// call calls method on s with params, and decodes its result into result
+func call(t *testing.T, s *Server, method string, result any, params ...any) {
+	encoded, err := json.Marshal(map[string]any{"jsonrpc": "1.0", "id": "test", "method": method, "params": params})
+	require.NoError(t, err)
+	status, body := post(t, s, string(encoded))
+	require.Equal(t, http.StatusOK, status, body)
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+		Id     string          `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	require.Nil(t, resp.Error)
+	require.Equal(t, "test", resp.Id)
+	require.NoError(t, json.Unmarshal(resp.Result, result))
+}
+
+// callError calls method on s with params, and returns the error it fails with
+func callError(t *testing.T, s *Server, method string, params ...any) *Error {
+	encoded, err := json.Marshal(map[string]any{"id": 1, "method": method, "params": params})
+	require.NoError(t, err)
+	_, body := post(t, s, string(encoded))
+	var resp struct {
+		Error *Error `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &resp))
+	require.NotNil(t, resp.Error, body)
+	return resp.Error
+}
+
+func TestServer_RequiresAuthentication(t *testing.T) {
+	s, _ := newTestServer()
+	for _, password := range []string{"", "wrong"} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"method":"getblockcount"}`))
+		if password != "" {
+			r.SetBasicAuth("user", password)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("user", "password")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServer_Errors(t *testing.T) {
+	s, _ := newTestServer()
+
+	status, body := post(t, s, `{"id":1,"method":"nosuchmethod"}`)
+	require.Equal(t, http.StatusNotFound, status)
+	require.JSONEq(t, `{"result":null,"error":{"code":-32601,"message":"Method not found"},"id":1}`, body)
+
+	status, body = post(t, s, `{"id":1,`)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.JSONEq(t, `{"result":null,"error":{"code":-32700,"message":"Parse error"},"id":null}`, body)
+
+	status, _ = post(t, s, `{"id":1}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	require.Equal(t, CodeMiscError, callError(t, s, "getblockcount", 1).Code, "getblockcount takes no parameters")
+	status, body = post(t, s, `{"id":1,"method":"getblockcount","params":{"verbose":true}}`)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Contains(t, body, "Unknown named parameter verbose")
+}
+
+func TestServer_Batch(t *testing.T) {
+	s, _ := newTestServer()
+	status, body := post(t, s, `[{"id":1,"method":"getblockcount"},{"id":2,"method":"nosuchmethod"},{"id":3,"method":"getbestblockhash","params":[]}]`)
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `[
+		{"result":2,"error":null,"id":1},
+		{"result":null,"error":{"code":-32601,"message":"Method not found"},"id":2},
+		{"result":"`+strings.Repeat("ab", 32)+`","error":null,"id":3}
+	]`, body)
+}
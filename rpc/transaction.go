@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"math"
+	"strconv"
+)
+
+const satoshisPerBitcoin = 100_000_000
+
+// Amount is a value in satoshis, which is encoded in JSON as a number of bitcoins with 8 decimals, the way bitcoind encodes values
+type Amount int64
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	sign, value := "", int64(a)
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	return []byte(fmt.Sprintf("%s%d.%08d", sign, value/satoshisPerBitcoin, value%satoshisPerBitcoin)), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	bitcoins, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+	*a = Amount(math.Round(bitcoins * satoshisPerBitcoin))
+	return nil
+}
+
+// Tx is a decoded transaction, as getblock, getrawtransaction and decoderawtransaction return it
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/core_write.cpp#L171-L268)
+type Tx struct {
+	TxId     string  `json:"txid"`
+	Hash     string  `json:"hash"`
+	Version  int32   `json:"version"`
+	Size     int     `json:"size"`
+	VSize    int     `json:"vsize"`
+	Weight   int     `json:"weight"`
+	LockTime uint32  `json:"locktime"`
+	Vin      []TxIn  `json:"vin"`
+	Vout     []TxOut `json:"vout"`
+	Hex      string  `json:"hex,omitempty"`
+}
+
+// TxIn is a decoded input, which spends the output Vout of TxId unless the transaction is a coinbase transaction
+type TxIn struct {
+	Coinbase    string     `json:"coinbase,omitempty"`
+	TxId        string     `json:"txid,omitempty"`
+	Vout        *uint32    `json:"vout,omitempty"`
+	ScriptSig   *ScriptSig `json:"scriptSig,omitempty"`
+	TxInWitness []string   `json:"txinwitness,omitempty"`
+	Sequence    uint32     `json:"sequence"`
+}
+
+type ScriptSig struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+type TxOut struct {
+	Value        Amount       `json:"value"`
+	N            int          `json:"n"`
+	ScriptPubKey ScriptPubKey `json:"scriptPubKey"`
+}
+
+type ScriptPubKey struct {
+	Asm     string      `json:"asm"`
+	Hex     string      `json:"hex"`
+	Address string      `json:"address,omitempty"`
+	Type    script.Type `json:"type"`
+}
+
+// decodeTx decodes tx for display on the network of params
+func decodeTx(tx *message.TxPayload, params constants.NetworkParams) (Tx, error) {
+	txId, err := tx.GetTxId()
+	if err != nil {
+		return Tx{}, err
+	}
+	wtxId, err := tx.GetWtxId()
+	if err != nil {
+		return Tx{}, err
+	}
+	encoded, err := tx.Encode()
+	if err != nil {
+		return Tx{}, err
+	}
+	stripped, err := strippedSize(tx)
+	if err != nil {
+		return Tx{}, err
+	}
+	weight := stripped*3 + len(encoded)
+	decoded := Tx{
+		TxId:     txId.String(),
+		Hash:     wtxId.String(),
+		Version:  int32(tx.Version),
+		Size:     len(encoded),
+		VSize:    (weight + 3) / 4,
+		Weight:   weight,
+		LockTime: tx.LockTime,
+		Vin:      make([]TxIn, len(tx.TransactionInputs)),
+		Vout:     make([]TxOut, len(tx.TransactionOutputs)),
+		Hex:      hex.EncodeToString(encoded),
+	}
+	coinbase := isCoinbase(tx)
+	for i, txIn := range tx.TransactionInputs {
+		in := TxIn{Sequence: txIn.Sequence}
+		if coinbase {
+			in.Coinbase = hex.EncodeToString(txIn.SignatureScript)
+		} else {
+			in.TxId = txIn.PreviousOutput.Hash.String()
+			in.Vout = &txIn.PreviousOutput.Index
+			in.ScriptSig = &ScriptSig{Asm: script.Disassemble(txIn.SignatureScript, true), Hex: hex.EncodeToString(txIn.SignatureScript)}
+		}
+		if i < len(tx.TransactionWitnesses) {
+			for _, item := range tx.TransactionWitnesses[i].ComponentDataList {
+				in.TxInWitness = append(in.TxInWitness, hex.EncodeToString(item))
+			}
+		}
+		decoded.Vin[i] = in
+	}
+	for i, txOut := range tx.TransactionOutputs {
+		address, _ := script.Address(txOut.PkScript, params)
+		decoded.Vout[i] = TxOut{
+			Value: Amount(txOut.Value),
+			N:     i,
+			ScriptPubKey: ScriptPubKey{
+				Asm:     script.Disassemble(txOut.PkScript, false),
+				Hex:     hex.EncodeToString(txOut.PkScript),
+				Address: address,
+				Type:    script.Classify(txOut.PkScript),
+			},
+		}
+	}
+	return decoded, nil
+}
+
+// strippedSize returns the size of tx without its witnesses
+func strippedSize(tx *message.TxPayload) (int, error) {
+	withoutWitnesses := *tx
+	withoutWitnesses.TransactionWitnesses = nil
+	encoded, err := withoutWitnesses.Encode()
+	if err != nil {
+		return 0, err
+	}
+	return len(encoded), nil
+}
+
+func isCoinbase(tx *message.TxPayload) bool {
+	return len(tx.TransactionInputs) == 1 && tx.TransactionInputs[0].PreviousOutput == (message.OutPoint{Index: 0xFFFFFFFF})
+}
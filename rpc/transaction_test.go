@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAmount(t *testing.T) {
+	for amount, expected := range map[Amount]string{0: "0.00000000", 1: "0.00000001", 5_000_000_000: "50.00000000", -150_000_000: "-1.50000000"} {
+		encoded, err := json.Marshal(amount)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(encoded))
+		var decoded Amount
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		require.Equal(t, amount, decoded)
+	}
+	var decoded Amount
+	require.NoError(t, json.Unmarshal([]byte("0.1"), &decoded))
+	require.Equal(t, Amount(10_000_000), decoded)
+	require.Error(t, json.Unmarshal([]byte(`"1"`), &decoded))
+}
+
+func TestDecodeTx(t *testing.T) {
+	signature := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01, 0x01}
+	witnessKeyHash := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	tx := &message.TxPayload{
+		Version: 2,
+		TransactionInputs: []message.TxIn{
+			{PreviousOutput: message.OutPoint{Hash: message.Hash256{0x01}, Index: 3}, SignatureScript: append([]byte{0x09}, signature...), Sequence: 0xfffffffd},
+			{PreviousOutput: message.OutPoint{Hash: message.Hash256{0x02}}, Sequence: 0xffffffff},
+		},
+		TransactionOutputs: []message.TxOut{{Value: 12345, PkScript: witnessKeyHash}, {Value: 0, PkScript: []byte{0x6a, 0x01, 0x07}}},
+		TransactionWitnesses: []message.TxWitness{
+			{},
+			{ComponentDataList: []message.ComponentData{signature, {0x02, 0x03}}},
+		},
+		LockTime: 100,
+	}
+
+	decoded, err := decodeTx(tx, constants.MainnetParams)
+	require.NoError(t, err)
+	txId, err := tx.GetTxId()
+	require.NoError(t, err)
+	require.Equal(t, txId.String(), decoded.TxId)
+	require.NotEqual(t, decoded.TxId, decoded.Hash, "the hash should cover the witnesses")
+	require.Greater(t, decoded.Size, decoded.VSize)
+	stripped, err := strippedSize(tx)
+	require.NoError(t, err)
+	require.Equal(t, stripped*3+decoded.Size, decoded.Weight, "witness bytes should count once, other bytes 4 times")
+	require.EqualValues(t, 2, decoded.Version)
+	require.EqualValues(t, 100, decoded.LockTime)
+
+	require.Equal(t, TxIn{
+		TxId:      message.Hash256{0x01}.String(),
+		Vout:      &tx.TransactionInputs[0].PreviousOutput.Index,
+		ScriptSig: &ScriptSig{Asm: "3006020101020101[ALL]", Hex: "09300602010102010101"},
+		Sequence:  0xfffffffd,
+	}, decoded.Vin[0])
+	require.Equal(t, []string{"300602010102010101", "0203"}, decoded.Vin[1].TxInWitness)
+	require.Equal(t, "", decoded.Vin[1].ScriptSig.Asm)
+
+	require.Equal(t, TxOut{Value: 12345, N: 0, ScriptPubKey: ScriptPubKey{
+		Asm:     "0 0000000000000000000000000000000000000000",
+		Hex:     "00140000000000000000000000000000000000000000",
+		Address: "bc1qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq9e75rs",
+		Type:    "witness_v0_keyhash",
+	}}, decoded.Vout[0])
+	require.Equal(t, ScriptPubKey{Asm: "OP_RETURN 7", Hex: "6a0107", Type: "nulldata"}, decoded.Vout[1].ScriptPubKey)
+}
@@ -0,0 +1,235 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"math/big"
+	"strings"
+)
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Constants the checksums of bech32 (BIP 173) and bech32m (BIP 350) addresses are xored with
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// Address returns the address pkScript pays to on the network of params, if it has one: base58 addresses for pay-to-pubkey-hash and
+// pay-to-script-hash outputs and bech32 or bech32m addresses for witness outputs. Pay-to-pubkey, multisig, null data and non-standard outputs
+// have no address (https://github.com/bitcoin/bitcoin/blob/v27.0/src/addresstype.cpp#L49-L97).
+func Address(pkScript []byte, params constants.NetworkParams) (string, bool) {
+	switch Classify(pkScript) {
+	case PubKeyHash:
+		return base58Check(params.PubKeyHashAddrID, pkScript[3:23]), true
+	case ScriptHash:
+		return base58Check(params.ScriptHashAddrID, pkScript[2:22]), true
+	case WitnessV0KeyHash, WitnessV0ScriptHash, WitnessV1Taproot, WitnessUnknown:
+		version, program, _ := WitnessProgram(pkScript)
+		return segwitAddress(params.Bech32HRP, version, program), true
+	}
+	return "", false
+}
+
+// PkScript returns the output script paying to address on the network of params, the inverse of Address. It fails with ErrInvalidAddress if
+// address is not a base58 or bech32 address of that network, or its checksum does not match.
+func PkScript(address string, params constants.NetworkParams) ([]byte, error) {
+	if hrp, _, ok := strings.Cut(strings.ToLower(address), "1"); ok && hrp == params.Bech32HRP {
+		version, program, err := decodeSegwitAddress(address, params.Bech32HRP)
+		if err != nil {
+			return nil, err
+		}
+		opcode := byte(Op0)
+		if version > 0 {
+			opcode = Op1 + byte(version) - 1
+		}
+		return append([]byte{opcode, byte(len(program))}, program...), nil
+	}
+
+	version, payload, err := decodeBase58Check(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("%w: %s has a payload of %d bytes", ErrInvalidAddress, address, len(payload))
+	}
+	switch version {
+	case params.PubKeyHashAddrID:
+		return append(append([]byte{OpDup, OpHash160, 20}, payload...), OpEqualVerify, OpCheckSig), nil
+	case params.ScriptHashAddrID:
+		return append(append([]byte{OpHash160, 20}, payload...), OpEqual), nil
+	}
+	return nil, fmt.Errorf("%w: %s is not an address of this network", ErrInvalidAddress, address)
+}
+
+// base58Check encodes version followed by payload and the first 4 bytes of their double SHA256 in base58, each leading zero byte becoming a
+// leading 1 (https://en.bitcoin.it/wiki/Base58Check_encoding)
+func base58Check(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	hash := sha256.Sum256(data)
+	hash = sha256.Sum256(hash[:])
+	data = append(data, hash[:4]...)
+
+	var digits []byte
+	n := new(big.Int).SetBytes(data)
+	base, digit := big.NewInt(58), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, base, digit)
+		digits = append(digits, base58Alphabet[digit.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		digits = append(digits, base58Alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// decodeBase58Check returns the version and payload base58Check encoded as address, checking its checksum
+func decodeBase58Check(address string) (byte, []byte, error) {
+	n := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range []byte(address) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return 0, nil, fmt.Errorf("%w: %s has an invalid base58 character %q", ErrInvalidAddress, address, c)
+		}
+		n.Mul(n, base).Add(n, big.NewInt(int64(digit)))
+	}
+	leadingZeros := len(address) - len(strings.TrimLeft(address, base58Alphabet[:1]))
+	data := append(make([]byte, leadingZeros), n.Bytes()...)
+	if len(data) < 5 {
+		return 0, nil, fmt.Errorf("%w: %s is too short", ErrInvalidAddress, address)
+	}
+	hash := sha256.Sum256(data[:len(data)-4])
+	hash = sha256.Sum256(hash[:])
+	if !bytes.Equal(hash[:4], data[len(data)-4:]) {
+		return 0, nil, fmt.Errorf("%w: %s has an invalid checksum", ErrInvalidAddress, address)
+	}
+	return data[0], data[1 : len(data)-4], nil
+}
+
+// decodeSegwitAddress returns the witness version and program encoded in address, whose human-readable part must be hrp, checking that
+// version 0 programs are encoded with bech32 and later ones with bech32m (https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki)
+func decodeSegwitAddress(address string, hrp string) (int, []byte, error) {
+	invalid := func(reason string) (int, []byte, error) {
+		return 0, nil, fmt.Errorf("%w: %s %s", ErrInvalidAddress, address, reason)
+	}
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return invalid("mixes upper and lower case")
+	}
+	address = strings.ToLower(address)
+	separator := strings.LastIndexByte(address, '1')
+	// a version, at least one group of 5 bits of the program and the 6 groups of the checksum
+	if address[:separator] != hrp || len(address)-separator-1 < 8 || len(address) > 90 {
+		return invalid("has an invalid length")
+	}
+	data := make([]byte, 0, len(address)-separator-1)
+	for _, c := range []byte(address[separator+1:]) {
+		value := strings.IndexByte(bech32Alphabet, c)
+		if value < 0 {
+			return invalid(fmt.Sprintf("has an invalid bech32 character %q", c))
+		}
+		data = append(data, byte(value))
+	}
+
+	version := int(data[0])
+	checksumConst := uint32(bech32Const)
+	if version > 0 {
+		checksumConst = bech32mConst
+	}
+	if bech32Polymod(append(hrpExpand(hrp), data...)) != checksumConst {
+		return invalid("has an invalid checksum")
+	}
+	converted := data[1 : len(data)-6]
+	// the groups of 5 bits may only be padded with less than 8 zero bits
+	if len(converted)*5%8 >= 5 || converted[len(converted)-1]&(1<<(len(converted)*5%8)-1) != 0 {
+		return invalid("has invalid padding")
+	}
+	program := convertBits(converted, 5, 8)
+	program = program[:len(converted)*5/8]
+	if version > 16 || len(program) < 2 || len(program) > maxWitnessProgramSize || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return invalid("has an invalid witness program")
+	}
+	return version, program, nil
+}
+
+// segwitAddress encodes a witness program as an address: with bech32 for version 0 (BIP 173) and with bech32m for later versions (BIP 350)
+func segwitAddress(hrp string, version int, program []byte) string {
+	data := append([]byte{byte(version)}, convertBits(program, 8, 5)...)
+	checksumConst := uint32(bech32Const)
+	if version > 0 {
+		checksumConst = bech32mConst
+	}
+	values := append(hrpExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ checksumConst
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(polymod>>(5*(5-i)))&31)
+	}
+
+	var address strings.Builder
+	address.WriteString(hrp)
+	address.WriteByte('1')
+	for _, d := range data {
+		address.WriteByte(bech32Alphabet[d])
+	}
+	return address.String()
+}
+
+// https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki#checksum
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// convertBits regroups data from groups of fromBits bits to groups of toBits bits, padding the last group with zeros
+func convertBits(data []byte, fromBits uint, toBits uint) []byte {
+	var converted []byte
+	acc, bits := uint32(0), uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	for _, b := range data {
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxValue))
+		}
+	}
+	if bits > 0 {
+		converted = append(converted, byte(acc<<(toBits-bits)&maxValue))
+	}
+	return converted
+}
@@ -0,0 +1,82 @@
+package script_test
+
+import (
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		params   constants.NetworkParams
+		expected string
+	}{
+		// the hash of the genesis block's public key
+		{"pay to pubkey hash", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", constants.MainnetParams, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		{"regtest pay to pubkey hash", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", constants.RegtestParams,
+			"mpXwg4jMtRhuSpVq4xS3HFHmCmWp9NyGKt"},
+		{"pay to script hash", "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87", constants.MainnetParams, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"},
+		// https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki#test-vectors
+		{"pay to witness pubkey hash", "0014751e76e8199196d454941c45d1b3a323f1433bd6", constants.MainnetParams,
+			"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		// https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki#test-vectors-for-v0-v16-native-segregated-witness-addresses
+		{"taproot", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", constants.MainnetParams,
+			"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"},
+		{"unknown witness version", "6002751e", constants.MainnetParams, "bc1sw50qgdz25j"},
+		{"regtest pay to witness pubkey hash", "0014751e76e8199196d454941c45d1b3a323f1433bd6", constants.RegtestParams,
+			"bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, ok := script.Address(decodeHex(t, tt.script), tt.params)
+			require.True(t, ok)
+			require.Equal(t, tt.expected, address)
+
+			pkScript, err := script.PkScript(tt.expected, tt.params)
+			require.NoError(t, err)
+			require.Equal(t, tt.script, hex.EncodeToString(pkScript))
+		})
+	}
+
+	t.Run("pay to pubkey, multisig, null data and non-standard outputs should have no address", func(t *testing.T) {
+		const key = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+		for _, s := range []string{"21" + key + "ac", "5121" + key + "51ae", "6a0100", "51"} {
+			_, ok := script.Address(decodeHex(t, s), constants.MainnetParams)
+			require.False(t, ok, s)
+		}
+	})
+}
+
+func TestPkScript(t *testing.T) {
+	pkScript, err := script.PkScript("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", constants.MainnetParams)
+	require.NoError(t, err)
+	require.Equal(t, "0014751e76e8199196d454941c45d1b3a323f1433bd6", hex.EncodeToString(pkScript))
+
+	invalid := []string{
+		// base58 addresses with an invalid checksum, an invalid character and the version of another network
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb",
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfN0",
+		"mpXwg4jMtRhuSpVq4xS3HFHmCmWp9NyGKt",
+		// https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki#test-vectors-for-v0-v16-native-segregated-witness-addresses
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4",
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R",
+		"bc1pw5dgrnzv",
+		"bc1r0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav",
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du",
+		"bc1Qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"bc1gmk9yu",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+	}
+	for _, address := range invalid {
+		_, err := script.PkScript(address, constants.MainnetParams)
+		require.ErrorIs(t, err, script.ErrInvalidAddress, address)
+	}
+}
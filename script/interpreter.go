@@ -0,0 +1,921 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/internal/ripemd160"
+	"github.com/aang114/bitcoin-node/internal/secp256k1"
+	"github.com/aang114/bitcoin-node/message"
+	"math/big"
+	"slices"
+)
+
+// Opcodes the interpreter runs besides the ones the package matches scripts against
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L65-L206)
+const (
+	opNop                 = 0x61
+	opIf                  = 0x63
+	opNotIf               = 0x64
+	opElse                = 0x67
+	opEndIf               = 0x68
+	opVerify              = 0x69
+	opToAltStack          = 0x6b
+	opFromAltStack        = 0x6c
+	op2Drop               = 0x6d
+	op2Dup                = 0x6e
+	op3Dup                = 0x6f
+	op2Over               = 0x70
+	op2Rot                = 0x71
+	op2Swap               = 0x72
+	opIfDup               = 0x73
+	opDepth               = 0x74
+	opDrop                = 0x75
+	opNip                 = 0x77
+	opOver                = 0x78
+	opPick                = 0x79
+	opRoll                = 0x7a
+	opRot                 = 0x7b
+	opSwap                = 0x7c
+	opTuck                = 0x7d
+	opSize                = 0x82
+	op1Add                = 0x8b
+	op1Sub                = 0x8c
+	opNegate              = 0x8f
+	opAbs                 = 0x90
+	opNot                 = 0x91
+	op0NotEqual           = 0x92
+	opAdd                 = 0x93
+	opSub                 = 0x94
+	opBoolAnd             = 0x9a
+	opBoolOr              = 0x9b
+	opNumEqual            = 0x9c
+	opNumEqualVerify      = 0x9d
+	opNumNotEqual         = 0x9e
+	opLessThan            = 0x9f
+	opGreaterThan         = 0xa0
+	opLessThanOrEqual     = 0xa1
+	opGreaterThanOrEqual  = 0xa2
+	opMin                 = 0xa3
+	opMax                 = 0xa4
+	opWithin              = 0xa5
+	opRipemd160           = 0xa6
+	opSha1                = 0xa7
+	opSha256              = 0xa8
+	opHash256             = 0xaa
+	opCodeSeparator       = 0xab
+	opCheckSigVerify      = 0xad
+	opCheckMultiSigVerify = 0xaf
+	opNop1                = 0xb0
+	opCheckLockTimeVerify = 0xb1
+	opCheckSequenceVerify = 0xb2
+	opNop10               = 0xb9
+	opCheckSigAdd         = 0xba
+)
+
+// Limits of the scripts (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L24-L36)
+const (
+	// bytes an item of the stack may have
+	maxElementSize = 520
+	// operations other than pushes a script may run, counting the public keys of OP_CHECKMULTISIG
+	maxOpsPerScript = 201
+	// public keys an OP_CHECKMULTISIG may check signatures against
+	maxPubKeysPerMultiSig = 20
+	// items the stack and the alt stack may hold together
+	maxStackSize = 1000
+	// bytes of the numbers arithmetic opcodes take
+	maxNumSize = 4
+	// lock times below it are heights, the others timestamps
+	lockTimeThreshold = 500000000
+)
+
+// Bits of the sequence of an input giving it a relative lock time (BIP 68)
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/primitives/transaction.h#L83-L107)
+const (
+	sequenceLockTimeDisableFlag = 1 << 31
+	sequenceLockTimeTypeFlag    = 1 << 22
+	sequenceLockTimeMask        = 0x0000ffff
+)
+
+var ErrScriptFailed = errors.New("script failed")
+
+func scriptFailed(reason string) error {
+	return fmt.Errorf("%w: %s", ErrScriptFailed, reason)
+}
+
+// isDisabled reports whether opcode was disabled, which fails the scripts that have it even in a branch that is not executed
+func isDisabled(opcode byte) bool {
+	switch opcode {
+	// OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR, OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT
+	case 0x7e, 0x7f, 0x80, 0x81, 0x83, 0x84, 0x85, 0x86, 0x8d, 0x8e, 0x95, 0x96, 0x97, 0x98, 0x99:
+		return true
+	}
+	return false
+}
+
+// stack holds the items of a script, the last one being the top
+type stack [][]byte
+
+// require fails unless the stack holds at least n items
+func (s *stack) require(n int) error {
+	if len(*s) < n {
+		return scriptFailed("operation on too few stack items")
+	}
+	return nil
+}
+
+// at returns the item depth items below the top
+func (s *stack) at(depth int) []byte {
+	return (*s)[len(*s)-1-depth]
+}
+
+func (s *stack) push(item []byte) {
+	*s = append(*s, item)
+}
+
+func (s *stack) pop() []byte {
+	item := s.at(0)
+	*s = (*s)[:len(*s)-1]
+	return item
+}
+
+// remove removes the item depth items below the top and returns it
+func (s *stack) remove(depth int) []byte {
+	item := s.at(depth)
+	*s = slices.Delete(*s, len(*s)-1-depth, len(*s)-depth)
+	return item
+}
+
+// popNum pops the top item as a number of at most maxSize bytes
+func (s *stack) popNum(maxSize int) (int64, error) {
+	err := s.require(1)
+	if err != nil {
+		return 0, err
+	}
+	return decodeNum(s.pop(), maxSize)
+}
+
+// decodeNum decodes item as a number of the script language, failing if it has more than maxSize bytes
+func decodeNum(item []byte, maxSize int) (int64, error) {
+	if len(item) > maxSize {
+		return 0, scriptFailed("number too long")
+	}
+	return scriptNum(item), nil
+}
+
+// encodeNum encodes n as a number of the script language in as few bytes as possible
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L352-L388)
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+	var encoded []byte
+	for ; abs > 0; abs >>= 8 {
+		encoded = append(encoded, byte(abs))
+	}
+	// the most significant bit of the last byte is the sign, which takes a byte of its own if the bit is already set
+	last := len(encoded) - 1
+	switch {
+	case encoded[last]&0x80 != 0 && negative:
+		encoded = append(encoded, 0x80)
+	case encoded[last]&0x80 != 0:
+		encoded = append(encoded, 0x00)
+	case negative:
+		encoded[last] |= 0x80
+	}
+	return encoded
+}
+
+func encodeBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return nil
+}
+
+// castToBool reports whether item is true: any number other than zero or negative zero
+func castToBool(item []byte) bool {
+	for i, b := range item {
+		if b != 0 {
+			return i != len(item)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+// engine runs the scripts spending an output with an input of a transaction
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L406-L1239)
+type engine struct {
+	tx        *message.TxPayload
+	input     int
+	amount    int64
+	flags     VerifyFlags
+	sigHashes *TxSigHashes
+	stack     stack
+
+	// what the taproot signatures of the input commit to besides the transaction: the annex of the witness, and the hash of the tapscript
+	// run and the position of the last OP_CODESEPARATOR run in it, 0xffffffff for none
+	annex       []byte
+	tapLeafHash []byte
+	codeSepPos  uint32
+	// what is left of the budget of the signature checks of the tapscript
+	validationWeight int64
+}
+
+// eval runs script on the stack of the engine with the rules of version
+func (e *engine) eval(script []byte, version sigVersion) error {
+	legacy := version == sigVersionBase || version == sigVersionWitnessV0
+	// tapscripts are bounded by the size of the block rather than by limits of their own
+	if legacy && len(script) > maxScriptSize {
+		return scriptFailed("script too long")
+	}
+	var altStack stack
+	// whether the branch of each enclosing OP_IF is executed
+	var conditions []bool
+	// where the script code signatures commit to starts: after the last OP_CODESEPARATOR run
+	codeStart := 0
+	e.codeSepPos = 0xffffffff
+	opCount := 0
+	for opPos, pc := uint32(0), 0; pc < len(script); opPos++ {
+		op, next, err := nextOp(script, pc)
+		if err != nil {
+			return scriptFailed(err.Error())
+		}
+		pc = next
+		executing := !slices.Contains(conditions, false)
+
+		if len(op.Data) > maxElementSize {
+			return scriptFailed("push too long")
+		}
+		if legacy && op.Opcode > Op16 {
+			opCount++
+			if opCount > maxOpsPerScript {
+				return scriptFailed("too many operations")
+			}
+		}
+		if isDisabled(op.Opcode) {
+			return scriptFailed(OpcodeName(op.Opcode) + " is disabled")
+		}
+		// the conditionals are run in branches that are not executed too, so that they stay balanced, and OP_VERIF and OP_VERNOTIF among
+		// them then fail
+		if !executing && (op.Opcode < opIf || op.Opcode > opEndIf) {
+			continue
+		}
+		if op.IsPush() {
+			e.stack.push(op.Data)
+			if len(e.stack)+len(altStack) > maxStackSize {
+				return scriptFailed("stack too big")
+			}
+			continue
+		}
+
+		s := &e.stack
+		switch op.Opcode {
+		case Op1Negate:
+			s.push(encodeNum(-1))
+		case opNop, opNop1, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, opNop10:
+		case opCheckLockTimeVerify:
+			if e.flags&VerifyCheckLockTimeVerify == 0 {
+				break
+			}
+			err = e.checkLockTime()
+		case opCheckSequenceVerify:
+			if e.flags&VerifyCheckSequenceVerify == 0 {
+				break
+			}
+			err = e.checkSequence()
+
+		case opIf, opNotIf:
+			value := false
+			if executing {
+				err = s.require(1)
+				if err != nil {
+					return err
+				}
+				// tapscripts take nothing but 1 for true and an empty item for false
+				if version == sigVersionTapscript && (len(s.at(0)) > 1 || len(s.at(0)) == 1 && s.at(0)[0] != 1) {
+					return scriptFailed(OpcodeName(op.Opcode) + " argument neither empty nor 1")
+				}
+				value = castToBool(s.pop()) == (op.Opcode == opIf)
+			}
+			conditions = append(conditions, value)
+		case opElse:
+			if len(conditions) == 0 {
+				return scriptFailed("OP_ELSE without OP_IF")
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+		case opEndIf:
+			if len(conditions) == 0 {
+				return scriptFailed("OP_ENDIF without OP_IF")
+			}
+			conditions = conditions[:len(conditions)-1]
+		case opVerify:
+			err = verifyTop(s, "OP_VERIFY")
+		case OpReturn:
+			return scriptFailed("OP_RETURN")
+
+		case opToAltStack:
+			err = s.require(1)
+			if err == nil {
+				altStack.push(s.pop())
+			}
+		case opFromAltStack:
+			if len(altStack) == 0 {
+				return scriptFailed("OP_FROMALTSTACK with an empty alt stack")
+			}
+			s.push(altStack.pop())
+		case op2Drop:
+			err = s.require(2)
+			if err == nil {
+				*s = (*s)[:len(*s)-2]
+			}
+		case op2Dup:
+			err = s.require(2)
+			if err == nil {
+				*s = append(*s, s.at(1), s.at(0))
+			}
+		case op3Dup:
+			err = s.require(3)
+			if err == nil {
+				*s = append(*s, s.at(2), s.at(1), s.at(0))
+			}
+		case op2Over:
+			err = s.require(4)
+			if err == nil {
+				*s = append(*s, s.at(3), s.at(2))
+			}
+		case op2Rot:
+			err = s.require(6)
+			if err == nil {
+				first, second := s.remove(5), s.remove(4)
+				*s = append(*s, first, second)
+			}
+		case op2Swap:
+			err = s.require(4)
+			if err == nil {
+				first, second := s.remove(3), s.remove(2)
+				*s = append(*s, first, second)
+			}
+		case opIfDup:
+			err = s.require(1)
+			if err == nil && castToBool(s.at(0)) {
+				s.push(s.at(0))
+			}
+		case opDepth:
+			s.push(encodeNum(int64(len(*s))))
+		case opDrop:
+			err = s.require(1)
+			if err == nil {
+				s.pop()
+			}
+		case OpDup:
+			err = s.require(1)
+			if err == nil {
+				s.push(s.at(0))
+			}
+		case opNip:
+			err = s.require(2)
+			if err == nil {
+				s.remove(1)
+			}
+		case opOver:
+			err = s.require(2)
+			if err == nil {
+				s.push(s.at(1))
+			}
+		case opPick, opRoll:
+			var n int64
+			n, err = s.popNum(maxNumSize)
+			if err != nil {
+				return err
+			}
+			if n < 0 || n >= int64(len(*s)) {
+				return scriptFailed(OpcodeName(op.Opcode) + " past the bottom of the stack")
+			}
+			item := s.at(int(n))
+			if op.Opcode == opRoll {
+				s.remove(int(n))
+			}
+			s.push(item)
+		case opRot:
+			err = s.require(3)
+			if err == nil {
+				s.push(s.remove(2))
+			}
+		case opSwap:
+			err = s.require(2)
+			if err == nil {
+				s.push(s.remove(1))
+			}
+		case opTuck:
+			err = s.require(2)
+			if err == nil {
+				top := s.at(0)
+				*s = slices.Insert(*s, len(*s)-2, top)
+			}
+		case opSize:
+			err = s.require(1)
+			if err == nil {
+				s.push(encodeNum(int64(len(s.at(0)))))
+			}
+
+		case OpEqual, OpEqualVerify:
+			err = s.require(2)
+			if err != nil {
+				return err
+			}
+			s.push(encodeBool(bytes.Equal(s.pop(), s.pop())))
+			if op.Opcode == OpEqualVerify {
+				err = verifyTop(s, "OP_EQUALVERIFY")
+			}
+
+		case op1Add, op1Sub, opNegate, opAbs, opNot, op0NotEqual:
+			var n int64
+			n, err = s.popNum(maxNumSize)
+			if err != nil {
+				return err
+			}
+			s.push(unaryOp(op.Opcode, n))
+		case opAdd, opSub, opBoolAnd, opBoolOr, opNumEqual, opNumEqualVerify, opNumNotEqual, opLessThan, opGreaterThan, opLessThanOrEqual,
+			opGreaterThanOrEqual, opMin, opMax:
+			var a, b int64
+			err = s.require(2)
+			if err != nil {
+				return err
+			}
+			b, err = s.popNum(maxNumSize)
+			if err != nil {
+				return err
+			}
+			a, err = s.popNum(maxNumSize)
+			if err != nil {
+				return err
+			}
+			s.push(binaryOp(op.Opcode, a, b))
+			if op.Opcode == opNumEqualVerify {
+				err = verifyTop(s, "OP_NUMEQUALVERIFY")
+			}
+		case opWithin:
+			err = s.require(3)
+			if err != nil {
+				return err
+			}
+			var n, low, high int64
+			high, err = s.popNum(maxNumSize)
+			if err == nil {
+				low, err = s.popNum(maxNumSize)
+			}
+			if err == nil {
+				n, err = s.popNum(maxNumSize)
+			}
+			if err != nil {
+				return err
+			}
+			s.push(encodeBool(low <= n && n < high))
+
+		case opRipemd160, opSha1, opSha256, OpHash160, opHash256:
+			err = s.require(1)
+			if err == nil {
+				s.push(hash(op.Opcode, s.pop()))
+			}
+		case opCodeSeparator:
+			codeStart = pc
+			e.codeSepPos = opPos
+		case OpCheckSig, opCheckSigVerify:
+			err = s.require(2)
+			if err != nil {
+				return err
+			}
+			pubKey, sig := s.pop(), s.pop()
+			var ok bool
+			if version == sigVersionTapscript {
+				ok, err = e.checkSigTapscript(sig, pubKey)
+			} else {
+				scriptCode := script[codeStart:]
+				if version == sigVersionBase {
+					scriptCode = findAndDelete(scriptCode, pushOf(sig))
+				}
+				ok, err = e.checkSig(sig, pubKey, scriptCode, version)
+			}
+			if err != nil {
+				return err
+			}
+			s.push(encodeBool(ok))
+			if op.Opcode == opCheckSigVerify {
+				err = verifyTop(s, "OP_CHECKSIGVERIFY")
+			}
+		case opCheckSigAdd:
+			// OP_CHECKSIGADD adds 1 to the number below the top if the signature below it is valid, which replaces OP_CHECKMULTISIG in
+			// tapscripts (https://github.com/bitcoin/bips/blob/master/bip-0342.mediawiki#rules-for-signature-opcodes)
+			if version != sigVersionTapscript {
+				return scriptFailed("bad opcode " + OpcodeName(op.Opcode))
+			}
+			err = s.require(3)
+			if err != nil {
+				return err
+			}
+			var n int64
+			n, err = decodeNum(s.at(1), maxNumSize)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			ok, err = e.checkSigTapscript(s.at(2), s.at(0))
+			if err != nil {
+				return err
+			}
+			*s = (*s)[:len(*s)-3]
+			if ok {
+				n++
+			}
+			s.push(encodeNum(n))
+		case OpCheckMultiSig, opCheckMultiSigVerify:
+			if version == sigVersionTapscript {
+				return scriptFailed(OpcodeName(op.Opcode) + " in a tapscript")
+			}
+			var ok bool
+			ok, err = e.checkMultiSig(script[codeStart:], version, &opCount)
+			if err != nil {
+				return err
+			}
+			s.push(encodeBool(ok))
+			if op.Opcode == opCheckMultiSigVerify {
+				err = verifyTop(s, "OP_CHECKMULTISIGVERIFY")
+			}
+
+		default:
+			n, ok := smallInt(op)
+			if !ok {
+				return scriptFailed("bad opcode " + OpcodeName(op.Opcode))
+			}
+			s.push(encodeNum(int64(n)))
+		}
+		if err != nil {
+			return err
+		}
+		if len(*s)+len(altStack) > maxStackSize {
+			return scriptFailed("stack too big")
+		}
+	}
+	if len(conditions) > 0 {
+		return scriptFailed("OP_IF without OP_ENDIF")
+	}
+	return nil
+}
+
+// verifyTop pops the top item and fails unless it is true, which opcode checks
+func verifyTop(s *stack, opcode string) error {
+	err := s.require(1)
+	if err != nil {
+		return err
+	}
+	if !castToBool(s.pop()) {
+		return scriptFailed(opcode + " failed")
+	}
+	return nil
+}
+
+func unaryOp(opcode byte, n int64) []byte {
+	switch opcode {
+	case op1Add:
+		return encodeNum(n + 1)
+	case op1Sub:
+		return encodeNum(n - 1)
+	case opNegate:
+		return encodeNum(-n)
+	case opAbs:
+		return encodeNum(max(n, -n))
+	case opNot:
+		return encodeBool(n == 0)
+	default:
+		return encodeBool(n != 0)
+	}
+}
+
+func binaryOp(opcode byte, a, b int64) []byte {
+	switch opcode {
+	case opAdd:
+		return encodeNum(a + b)
+	case opSub:
+		return encodeNum(a - b)
+	case opBoolAnd:
+		return encodeBool(a != 0 && b != 0)
+	case opBoolOr:
+		return encodeBool(a != 0 || b != 0)
+	case opNumEqual, opNumEqualVerify:
+		return encodeBool(a == b)
+	case opNumNotEqual:
+		return encodeBool(a != b)
+	case opLessThan:
+		return encodeBool(a < b)
+	case opGreaterThan:
+		return encodeBool(a > b)
+	case opLessThanOrEqual:
+		return encodeBool(a <= b)
+	case opGreaterThanOrEqual:
+		return encodeBool(a >= b)
+	case opMin:
+		return encodeNum(min(a, b))
+	default:
+		return encodeNum(max(a, b))
+	}
+}
+
+func hash(opcode byte, item []byte) []byte {
+	switch opcode {
+	case opRipemd160:
+		sum := ripemd160.Sum(item)
+		return sum[:]
+	case opSha1:
+		sum := sha1.Sum(item)
+		return sum[:]
+	case opSha256:
+		sum := sha256.Sum256(item)
+		return sum[:]
+	case OpHash160:
+		sha := sha256.Sum256(item)
+		sum := ripemd160.Sum(sha[:])
+		return sum[:]
+	default:
+		sum := doubleSHA256(item)
+		return sum[:]
+	}
+}
+
+// checkMultiSig runs OP_CHECKMULTISIG on the stack, whose top is the number of public keys, preceded by the keys, the number of signatures,
+// the signatures and an extra item a bug of bitcoind pops. The signatures must be in the order of the keys they are of.
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1109-L1201)
+func (e *engine) checkMultiSig(scriptCode []byte, version sigVersion, opCount *int) (bool, error) {
+	s := &e.stack
+	err := s.require(1)
+	if err != nil {
+		return false, err
+	}
+	keys, err := decodeNum(s.at(0), maxNumSize)
+	if err != nil {
+		return false, err
+	}
+	if keys < 0 || keys > maxPubKeysPerMultiSig {
+		return false, scriptFailed("bad public key count")
+	}
+	*opCount += int(keys)
+	if *opCount > maxOpsPerScript {
+		return false, scriptFailed("too many operations")
+	}
+	// depth of the last key, which is checked first
+	keyDepth := 1
+	sigsDepth := 1 + int(keys)
+	err = s.require(sigsDepth + 1)
+	if err != nil {
+		return false, err
+	}
+	sigs, err := decodeNum(s.at(sigsDepth), maxNumSize)
+	if err != nil {
+		return false, err
+	}
+	if sigs < 0 || sigs > keys {
+		return false, scriptFailed("bad signature count")
+	}
+	sigDepth := sigsDepth + 1
+	// the signatures, the keys, their numbers and the extra item
+	items := sigsDepth + 1 + int(sigs) + 1
+	err = s.require(items)
+	if err != nil {
+		return false, err
+	}
+
+	if version == sigVersionBase {
+		for i := range int(sigs) {
+			scriptCode = findAndDelete(scriptCode, pushOf(s.at(sigDepth+i)))
+		}
+	}
+	success := true
+	for success && sigs > 0 {
+		ok, err := e.checkSig(s.at(sigDepth), s.at(keyDepth), scriptCode, version)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			sigDepth++
+			sigs--
+		}
+		keyDepth++
+		keys--
+		// there are more signatures left than keys to match them
+		if sigs > keys {
+			success = false
+		}
+	}
+
+	dummy := s.at(items - 1)
+	if e.flags&VerifyNullDummy != 0 && len(dummy) != 0 {
+		return false, scriptFailed("OP_CHECKMULTISIG dummy is not empty")
+	}
+	*s = (*s)[:len(*s)-items]
+	return success, nil
+}
+
+// checkSig reports whether sig, followed by its sighash type, is a signature by pubKey of the transaction, scriptCode being the script it
+// commits to. It fails with the script if sig is not strictly DER encoded once BIP 66 is enforced, but only reports invalid signatures and
+// public keys otherwise. (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1642-L1677)
+func (e *engine) checkSig(sig, pubKey, scriptCode []byte, version sigVersion) (bool, error) {
+	if len(sig) == 0 {
+		return false, nil
+	}
+	if e.flags&VerifyDERSig != 0 && !isValidSignatureEncoding(sig) {
+		return false, scriptFailed("signature is not strictly DER encoded")
+	}
+	key, err := secp256k1.ParsePublicKey(pubKey)
+	if err != nil {
+		return false, nil
+	}
+	r, s, ok := parseDERLax(sig[:len(sig)-1])
+	if !ok {
+		return false, nil
+	}
+	hashType := uint32(sig[len(sig)-1])
+	var sigHash [32]byte
+	if version == sigVersionBase {
+		sigHash = SignatureHash(e.tx, e.input, scriptCode, hashType)
+	} else {
+		sigHash = WitnessSignatureHash(e.tx, e.input, scriptCode, hashType, e.amount, e.sigHashes)
+	}
+	return secp256k1.Verify(key, sigHash[:], r, s), nil
+}
+
+// checkLockTime runs OP_CHECKLOCKTIMEVERIFY, which fails unless the transaction is locked until at least the height or time on top of the
+// stack (BIP 65)
+func (e *engine) checkLockTime() error {
+	err := e.stack.require(1)
+	if err != nil {
+		return err
+	}
+	// lock times are unsigned 32-bit numbers, which take 5 bytes
+	lockTime, err := decodeNum(e.stack.at(0), 5)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return scriptFailed("negative lock time")
+	}
+	txLockTime := int64(e.tx.LockTime)
+	if (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold) || lockTime > txLockTime {
+		return scriptFailed("unsatisfied lock time")
+	}
+	// the lock time of a transaction is ignored if its inputs are all final
+	if e.tx.TransactionInputs[e.input].Sequence == 0xffffffff {
+		return scriptFailed("unsatisfied lock time")
+	}
+	return nil
+}
+
+// checkSequence runs OP_CHECKSEQUENCEVERIFY, which fails unless the input is locked for at least the relative height or time on top of the
+// stack (BIP 112)
+func (e *engine) checkSequence() error {
+	err := e.stack.require(1)
+	if err != nil {
+		return err
+	}
+	sequence, err := decodeNum(e.stack.at(0), 5)
+	if err != nil {
+		return err
+	}
+	if sequence < 0 {
+		return scriptFailed("negative sequence")
+	}
+	// sequences with the disable flag are left to later soft forks
+	if sequence&sequenceLockTimeDisableFlag != 0 {
+		return nil
+	}
+	txSequence := int64(e.tx.TransactionInputs[e.input].Sequence)
+	if e.tx.Version < 2 || txSequence&sequenceLockTimeDisableFlag != 0 {
+		return scriptFailed("unsatisfied sequence")
+	}
+	const mask = sequenceLockTimeTypeFlag | sequenceLockTimeMask
+	sequence, txSequence = sequence&mask, txSequence&mask
+	if (sequence < sequenceLockTimeTypeFlag) != (txSequence < sequenceLockTimeTypeFlag) || sequence > txSequence {
+		return scriptFailed("unsatisfied sequence")
+	}
+	return nil
+}
+
+// findAndDelete returns script without the occurrences of pattern starting where an operation does, which legacy signatures are removed from
+// the script code with (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L253-L276)
+func findAndDelete(script, pattern []byte) []byte {
+	if len(pattern) == 0 {
+		return script
+	}
+	var result []byte
+	found := false
+	kept, pc := 0, 0
+	for {
+		result = append(result, script[kept:pc]...)
+		for len(script)-pc >= len(pattern) && bytes.Equal(script[pc:pc+len(pattern)], pattern) {
+			pc += len(pattern)
+			found = true
+		}
+		kept = pc
+		if pc >= len(script) {
+			break
+		}
+		_, next, err := nextOp(script, pc)
+		if err != nil {
+			break
+		}
+		pc = next
+	}
+	if !found {
+		return script
+	}
+	return append(result, script[kept:]...)
+}
+
+// pushOf returns the operation pushing data in as few bytes as possible
+func pushOf(data []byte) []byte {
+	switch {
+	case len(data) < OpPushData1:
+		return append([]byte{byte(len(data))}, data...)
+	case len(data) <= 0xff:
+		return append([]byte{OpPushData1, byte(len(data))}, data...)
+	case len(data) <= 0xffff:
+		return append([]byte{OpPushData2, byte(len(data)), byte(len(data) >> 8)}, data...)
+	default:
+		return append([]byte{OpPushData4, byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16), byte(len(data) >> 24)}, data...)
+	}
+}
+
+// parseDERLax parses a DER-encoded signature the lax way bitcoind did before BIP 66, which signatures of old blocks rely on. Values
+// overflowing the order of the curve are returned as is, as no key verifies them.
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/pubkey.cpp#L43-L186)
+func parseDERLax(sig []byte) (*big.Int, *big.Int, bool) {
+	pos := 0
+	// readLength reads the length following a tag, skipping the bytes of a long-form length when skipLong is set
+	readLength := func(skipLong bool) (int, bool) {
+		if pos == len(sig) {
+			return 0, false
+		}
+		length := int(sig[pos])
+		pos++
+		if length&0x80 == 0 {
+			return length, true
+		}
+		length -= 0x80
+		if length > len(sig)-pos {
+			return 0, false
+		}
+		if skipLong {
+			pos += length
+			return 0, true
+		}
+		for length > 0 && sig[pos] == 0 {
+			pos++
+			length--
+		}
+		if length >= 4 {
+			return 0, false
+		}
+		value := 0
+		for ; length > 0; length-- {
+			value = value<<8 | int(sig[pos])
+			pos++
+		}
+		return value, true
+	}
+	readInteger := func() ([]byte, bool) {
+		if pos == len(sig) || sig[pos] != 0x02 {
+			return nil, false
+		}
+		pos++
+		length, ok := readLength(false)
+		if !ok || length > len(sig)-pos {
+			return nil, false
+		}
+		integer := sig[pos : pos+length]
+		pos += length
+		return bytes.TrimLeft(integer, "\x00"), true
+	}
+
+	if pos == len(sig) || sig[pos] != 0x30 {
+		return nil, nil, false
+	}
+	pos++
+	if _, ok := readLength(true); !ok {
+		return nil, nil, false
+	}
+	rBytes, ok := readInteger()
+	if !ok {
+		return nil, nil, false
+	}
+	sBytes, ok := readInteger()
+	if !ok {
+		return nil, nil, false
+	}
+	return new(big.Int).SetBytes(rBytes), new(big.Int).SetBytes(sBytes), true
+}
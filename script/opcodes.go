@@ -0,0 +1,87 @@
+package script
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// Names of the opcodes that are not numbers (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.cpp#L14-L164)
+var opcodeNames = map[byte]string{
+	OpPushData1: "OP_PUSHDATA1", OpPushData2: "OP_PUSHDATA2", OpPushData4: "OP_PUSHDATA4", Op1Negate: "-1", 0x50: "OP_RESERVED",
+	0x61: "OP_NOP", 0x62: "OP_VER", 0x63: "OP_IF", 0x64: "OP_NOTIF", 0x65: "OP_VERIF", 0x66: "OP_VERNOTIF", 0x67: "OP_ELSE", 0x68: "OP_ENDIF",
+	0x69: "OP_VERIFY", OpReturn: "OP_RETURN",
+	0x6b: "OP_TOALTSTACK", 0x6c: "OP_FROMALTSTACK", 0x6d: "OP_2DROP", 0x6e: "OP_2DUP", 0x6f: "OP_3DUP", 0x70: "OP_2OVER", 0x71: "OP_2ROT",
+	0x72: "OP_2SWAP", 0x73: "OP_IFDUP", 0x74: "OP_DEPTH", 0x75: "OP_DROP", OpDup: "OP_DUP", 0x77: "OP_NIP", 0x78: "OP_OVER", 0x79: "OP_PICK",
+	0x7a: "OP_ROLL", 0x7b: "OP_ROT", 0x7c: "OP_SWAP", 0x7d: "OP_TUCK",
+	0x7e: "OP_CAT", 0x7f: "OP_SUBSTR", 0x80: "OP_LEFT", 0x81: "OP_RIGHT", 0x82: "OP_SIZE",
+	0x83: "OP_INVERT", 0x84: "OP_AND", 0x85: "OP_OR", 0x86: "OP_XOR", OpEqual: "OP_EQUAL", OpEqualVerify: "OP_EQUALVERIFY",
+	0x89: "OP_RESERVED1", 0x8a: "OP_RESERVED2",
+	0x8b: "OP_1ADD", 0x8c: "OP_1SUB", 0x8d: "OP_2MUL", 0x8e: "OP_2DIV", 0x8f: "OP_NEGATE", 0x90: "OP_ABS", 0x91: "OP_NOT", 0x92: "OP_0NOTEQUAL",
+	0x93: "OP_ADD", 0x94: "OP_SUB", 0x95: "OP_MUL", 0x96: "OP_DIV", 0x97: "OP_MOD", 0x98: "OP_LSHIFT", 0x99: "OP_RSHIFT", 0x9a: "OP_BOOLAND",
+	0x9b: "OP_BOOLOR", 0x9c: "OP_NUMEQUAL", 0x9d: "OP_NUMEQUALVERIFY", 0x9e: "OP_NUMNOTEQUAL", 0x9f: "OP_LESSTHAN", 0xa0: "OP_GREATERTHAN",
+	0xa1: "OP_LESSTHANOREQUAL", 0xa2: "OP_GREATERTHANOREQUAL", 0xa3: "OP_MIN", 0xa4: "OP_MAX", 0xa5: "OP_WITHIN",
+	0xa6: "OP_RIPEMD160", 0xa7: "OP_SHA1", 0xa8: "OP_SHA256", OpHash160: "OP_HASH160", 0xaa: "OP_HASH256", 0xab: "OP_CODESEPARATOR",
+	OpCheckSig: "OP_CHECKSIG", 0xad: "OP_CHECKSIGVERIFY", OpCheckMultiSig: "OP_CHECKMULTISIG", 0xaf: "OP_CHECKMULTISIGVERIFY",
+	0xb0: "OP_NOP1", 0xb1: "OP_CHECKLOCKTIMEVERIFY", 0xb2: "OP_CHECKSEQUENCEVERIFY", 0xb3: "OP_NOP4", 0xb4: "OP_NOP5", 0xb5: "OP_NOP6",
+	0xb6: "OP_NOP7", 0xb7: "OP_NOP8", 0xb8: "OP_NOP9", 0xb9: "OP_NOP10",
+	0xba: "OP_CHECKSIGADD", 0xff: "OP_INVALIDOPCODE",
+}
+
+// Names of the sighash types, keyed by the byte ending the signatures using them
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/core_write.cpp#L67-L74)
+var sigHashNames = map[byte]string{
+	0x01: "ALL",
+	0x81: "ALL|ANYONECANPAY",
+	0x02: "NONE",
+	0x82: "NONE|ANYONECANPAY",
+	0x03: "SINGLE",
+	0x83: "SINGLE|ANYONECANPAY",
+}
+
+// OpcodeName returns the name of opcode as bitcoind disassembles it: small integers as numbers, other opcodes as OP_ names
+func OpcodeName(opcode byte) string {
+	switch {
+	case opcode == Op0:
+		return "0"
+	case opcode >= Op1 && opcode <= Op16:
+		return strconv.Itoa(int(opcode-Op1) + 1)
+	}
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	return "OP_UNKNOWN"
+}
+
+// disassembleSignature returns the hex of data followed by the name of its sighash type, without its last byte, if it is a strictly encoded
+// signature (https://github.com/bitcoin/bitcoin/blob/v27.0/src/core_write.cpp#L110-L122)
+func disassembleSignature(data []byte) string {
+	if isValidSignatureEncoding(data) {
+		if name, ok := sigHashNames[data[len(data)-1]]; ok {
+			return hex.EncodeToString(data[:len(data)-1]) + "[" + name + "]"
+		}
+	}
+	return hex.EncodeToString(data)
+}
+
+// isValidSignatureEncoding reports whether sig is a DER-encoded signature followed by a sighash type byte (BIP 66)
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L97-L172)
+func isValidSignatureEncoding(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 || sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 || (lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0) {
+		return false
+	}
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 || (lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0) {
+		return false
+	}
+	return true
+}
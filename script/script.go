@@ -0,0 +1,139 @@
+// Package script decodes scripts for display the way bitcoind does: their disassembly, the standard template of output scripts and the
+// address they pay to (https://github.com/bitcoin/bitcoin/blob/v27.0/src/core_write.cpp). It also runs the scripts of transaction inputs to
+// verify them (see VerifyInput).
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Opcodes the package matches scripts against (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h)
+const (
+	Op0             = 0x00
+	OpPushData1     = 0x4c
+	OpPushData2     = 0x4d
+	OpPushData4     = 0x4e
+	Op1Negate       = 0x4f
+	Op1             = 0x51
+	Op16            = 0x60
+	OpReturn        = 0x6a
+	OpDup           = 0x76
+	OpEqual         = 0x87
+	OpEqualVerify   = 0x88
+	OpHash160       = 0xa9
+	OpCheckSig      = 0xac
+	OpCheckMultiSig = 0xae
+)
+
+// https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L39
+const maxScriptSize = 10000
+
+var ErrMalformedPush = errors.New("push past the end of the script")
+
+// Op is an operation of a script: an opcode and, for pushes (opcodes up to OpPushData4), the data it pushes
+type Op struct {
+	Opcode byte
+	Data   []byte
+}
+
+// IsPush reports whether the operation pushes data, which OP_0 does with no data
+func (op Op) IsPush() bool {
+	return op.Opcode <= OpPushData4
+}
+
+// Parse returns the operations of script in order. It fails with ErrMalformedPush, along with the operations preceding it, if a push is
+// cut short by the end of the script.
+func Parse(script []byte) ([]Op, error) {
+	var ops []Op
+	for pc := 0; pc < len(script); {
+		op, next, err := nextOp(script, pc)
+		if err != nil {
+			return ops, err
+		}
+		ops = append(ops, op)
+		pc = next
+	}
+	return ops, nil
+}
+
+// nextOp returns the operation of script starting at pc, which is before the end of the script, and where the operation following it starts
+func nextOp(script []byte, pc int) (Op, int, error) {
+	opcode := script[pc]
+	pc++
+	if opcode > OpPushData4 {
+		return Op{Opcode: opcode}, pc, nil
+	}
+	size := int(opcode)
+	if opcode >= OpPushData1 {
+		width := 1 << (opcode - OpPushData1)
+		if len(script)-pc < width {
+			return Op{}, 0, ErrMalformedPush
+		}
+		switch width {
+		case 1:
+			size = int(script[pc])
+		case 2:
+			size = int(binary.LittleEndian.Uint16(script[pc:]))
+		default:
+			size = int(binary.LittleEndian.Uint32(script[pc:]))
+		}
+		pc += width
+	}
+	if size < 0 || len(script)-pc < size {
+		return Op{}, 0, ErrMalformedPush
+	}
+	return Op{Opcode: opcode, Data: script[pc : pc+size]}, pc + size, nil
+}
+
+// Disassemble returns the operations of script separated by spaces, the way bitcoind does
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/core_write.cpp#L98-L137): pushes of up to 4 bytes as numbers, longer pushes in hex and
+// other opcodes by name, followed by "[error]" if a push is malformed. With decodeSigHash, which is meant for input scripts, pushes that look
+// like signatures have their sighash type byte replaced by its name (e.g. "[ALL]").
+func Disassemble(script []byte, decodeSigHash bool) string {
+	ops, err := Parse(script)
+	decodeSigHash = decodeSigHash && !IsUnspendable(script)
+	words := make([]string, 0, len(ops)+1)
+	for _, op := range ops {
+		switch {
+		case !op.IsPush():
+			words = append(words, OpcodeName(op.Opcode))
+		case len(op.Data) <= 4:
+			words = append(words, strconv.FormatInt(scriptNum(op.Data), 10))
+		case decodeSigHash:
+			words = append(words, disassembleSignature(op.Data))
+		default:
+			words = append(words, hex.EncodeToString(op.Data))
+		}
+	}
+	if err != nil {
+		words = append(words, "[error]")
+	}
+	return strings.Join(words, " ")
+}
+
+// scriptNum decodes data as a number of the script language: little-endian, with the sign in the most significant bit of its last byte
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L325-L350)
+func scriptNum(data []byte) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * i)
+	}
+	last := len(data) - 1
+	if data[last]&0x80 != 0 {
+		return -(n &^ (int64(0x80) << (8 * last)))
+	}
+	return n
+}
+
+// IsUnspendable reports whether script provably cannot be satisfied, so that the value of an output paying to it is burnt: it starts with
+// OP_RETURN or is too long to be run
+func IsUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == OpReturn) || len(script) > maxScriptSize
+}
@@ -0,0 +1,80 @@
+package script_test
+
+import (
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	decoded, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestDisassemble(t *testing.T) {
+	const headline = "5468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73"
+	tests := []struct {
+		name          string
+		script        string
+		decodeSigHash bool
+		expected      string
+	}{
+		// pushes of up to 4 bytes are numbers (0x1d00ffff is the genesis block's target), longer ones are hex
+		{"genesis coinbase", "04ffff001d0104" + "45" + headline, false, "486604799 4 " + headline},
+		{"pay to pubkey hash", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", false,
+			"OP_DUP OP_HASH160 62e907b15cbf27d5425399ebf6f0fb50ebb88f18 OP_EQUALVERIFY OP_CHECKSIG"},
+		{"small integers", "00514f60bb", false, "0 1 -1 16 OP_UNKNOWN"},
+		{"negative number", "0281800100", false, "-129 0"},
+		{"pushdata", "4c0401020304", false, "67305985"},
+		{"signature", "093006020101020101812102" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", true,
+			"3006020101020101[ALL|ANYONECANPAY] 0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"signature not decoded in output scripts", "09300602010102010181", false, "300602010102010181"},
+		{"undefined sighash type", "09300602010102010104", true, "300602010102010104"},
+		{"signature not decoded in unspendable script", "6a09300602010102010101", true, "OP_RETURN 300602010102010101"},
+		{"truncated push", "51050102", false, "1 [error]"},
+		{"empty", "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, script.Disassemble(decodeHex(t, tt.script), tt.decodeSigHash))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	ops, err := script.Parse(decodeHex(t, "4d0300aabbcc76"))
+	require.NoError(t, err)
+	require.Equal(t, []script.Op{{Opcode: script.OpPushData2, Data: []byte{0xaa, 0xbb, 0xcc}}, {Opcode: script.OpDup}}, ops)
+
+	ops, err = script.Parse(decodeHex(t, "764e"))
+	require.ErrorIs(t, err, script.ErrMalformedPush)
+	require.Equal(t, []script.Op{{Opcode: script.OpDup}}, ops)
+}
+
+func TestClassify(t *testing.T) {
+	const key = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	tests := []struct {
+		script   string
+		expected script.Type
+	}{
+		{"76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", script.PubKeyHash},
+		{"a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba87", script.ScriptHash},
+		{"21" + key + "ac", script.PubKey},
+		{"5121" + key + "21" + key + "52ae", script.MultiSig},
+		{"5221" + key + "51ae", script.NonStandard},
+		{"0014751e76e8199196d454941c45d1b3a323f1433bd6", script.WitnessV0KeyHash},
+		{"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", script.WitnessV0ScriptHash},
+		{"5120" + key[2:], script.WitnessV1Taproot},
+		{"6002751e", script.WitnessUnknown},
+		{"0003aabbcc", script.NonStandard},
+		{"6a0b68656c6c6f20776f726c64", script.NullData},
+		{"6a", script.NullData},
+		{"6a76", script.NonStandard},
+		{"51", script.NonStandard},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, script.Classify(decodeHex(t, tt.script)), tt.script)
+	}
+}
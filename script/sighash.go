@@ -0,0 +1,280 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/aang114/bitcoin-node/internal/secp256k1"
+	"github.com/aang114/bitcoin-node/message"
+)
+
+// Sighash types, the last byte of a signature telling which parts of the transaction it commits to
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.h#L29-L35)
+const (
+	// the sighash type of the taproot signatures that have none, which commit to the same as SIGHASH_ALL
+	// (https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#common-signature-message)
+	SigHashDefault      = 0x00
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyoneCanPay = 0x80
+)
+
+// sigVersion tells which rules a script is run with: the ones of legacy scripts, of the scripts of segwit v0 (BIP 143), of taproot key path
+// spends (BIP 341) or of tapscripts (BIP 342)
+type sigVersion int
+
+const (
+	sigVersionBase sigVersion = iota
+	sigVersionWitnessV0
+	sigVersionTaproot
+	sigVersionTapscript
+)
+
+// TxSigHashes are the hashes of the parts of a transaction the segwit v0 and taproot signatures of all its inputs commit to, computed once
+// per transaction (https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki#specification and
+// https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#common-signature-message). Taproot signatures hash the parts once, and
+// segwit v0 ones twice.
+type TxSigHashes struct {
+	prevouts  [32]byte
+	sequences [32]byte
+	outputs   [32]byte
+	// the values and scripts of the outputs the inputs spend, unless they are unknown
+	prevOuts []message.TxOut
+	amounts  [32]byte
+	scripts  [32]byte
+}
+
+// NewTxSigHashes returns the hashes of tx, whose inputs spend prevOuts. prevOuts may be nil if no input spends a taproot output, as only
+// taproot signatures commit to them.
+func NewTxSigHashes(tx *message.TxPayload, prevOuts []message.TxOut) *TxSigHashes {
+	var prevouts, sequences, outputs []byte
+	for _, in := range tx.TransactionInputs {
+		prevouts = appendOutPoint(prevouts, in.PreviousOutput)
+		sequences = binary.LittleEndian.AppendUint32(sequences, in.Sequence)
+	}
+	for _, out := range tx.TransactionOutputs {
+		outputs = appendTxOut(outputs, out)
+	}
+	sigHashes := &TxSigHashes{prevouts: sha256.Sum256(prevouts), sequences: sha256.Sum256(sequences), outputs: sha256.Sum256(outputs)}
+	if len(prevOuts) == len(tx.TransactionInputs) {
+		var amounts, scripts []byte
+		for _, prevOut := range prevOuts {
+			amounts = binary.LittleEndian.AppendUint64(amounts, uint64(prevOut.Value))
+			scripts = appendCompactSize(scripts, len(prevOut.PkScript))
+			scripts = append(scripts, prevOut.PkScript...)
+		}
+		sigHashes.prevOuts, sigHashes.amounts, sigHashes.scripts = prevOuts, sha256.Sum256(amounts), sha256.Sum256(scripts)
+	}
+	return sigHashes
+}
+
+// SignatureHash returns the hash signed by the signature of input i of tx with hashType, scriptCode being the script the signature is
+// checked by, whose OP_CODESEPARATORs are left out, the way legacy scripts hash transactions
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1569-L1665). Its flaws are part of the consensus rules: a
+// SIGHASH_SINGLE signature of an input with no matching output signs the number 1.
+func SignatureHash(tx *message.TxPayload, i int, scriptCode []byte, hashType uint32) [32]byte {
+	var one [32]byte
+	one[0] = 1
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	baseType := hashType & 0x1f
+	if baseType == SigHashSingle && i >= len(tx.TransactionOutputs) {
+		return one
+	}
+
+	scriptCode = removeCodeSeparators(scriptCode)
+	preimage := binary.LittleEndian.AppendUint32(nil, tx.Version)
+	inputs := tx.TransactionInputs
+	if anyoneCanPay {
+		inputs = inputs[i : i+1]
+	}
+	preimage = appendCompactSize(preimage, len(inputs))
+	for j, in := range inputs {
+		preimage = appendOutPoint(preimage, in.PreviousOutput)
+		switch {
+		case anyoneCanPay || j == i:
+			preimage = appendCompactSize(preimage, len(scriptCode))
+			preimage = append(preimage, scriptCode...)
+		default:
+			preimage = appendCompactSize(preimage, 0)
+		}
+		sequence := in.Sequence
+		if !anyoneCanPay && j != i && (baseType == SigHashNone || baseType == SigHashSingle) {
+			sequence = 0
+		}
+		preimage = binary.LittleEndian.AppendUint32(preimage, sequence)
+	}
+	switch baseType {
+	case SigHashNone:
+		preimage = appendCompactSize(preimage, 0)
+	case SigHashSingle:
+		// the outputs before the one of the input are blanked out: no value (-1) and no script
+		preimage = appendCompactSize(preimage, i+1)
+		for range i {
+			preimage = appendTxOut(preimage, message.TxOut{Value: -1})
+		}
+		preimage = appendTxOut(preimage, tx.TransactionOutputs[i])
+	default:
+		preimage = appendCompactSize(preimage, len(tx.TransactionOutputs))
+		for _, out := range tx.TransactionOutputs {
+			preimage = appendTxOut(preimage, out)
+		}
+	}
+	preimage = binary.LittleEndian.AppendUint32(preimage, tx.LockTime)
+	preimage = binary.LittleEndian.AppendUint32(preimage, hashType)
+	return doubleSHA256(preimage)
+}
+
+// WitnessSignatureHash returns the hash signed by the signature of input i of tx with hashType, scriptCode being the script the signature is
+// checked by and amount the value of the output the input spends, the way segwit v0 scripts hash transactions
+// (https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki#specification)
+func WitnessSignatureHash(tx *message.TxPayload, i int, scriptCode []byte, hashType uint32, amount int64, sigHashes *TxSigHashes) [32]byte {
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	baseType := hashType & 0x1f
+	var hashPrevouts, hashSequence, hashOutputs [32]byte
+	if !anyoneCanPay {
+		hashPrevouts = sha256.Sum256(sigHashes.prevouts[:])
+		if baseType != SigHashSingle && baseType != SigHashNone {
+			hashSequence = sha256.Sum256(sigHashes.sequences[:])
+		}
+	}
+	switch {
+	case baseType != SigHashSingle && baseType != SigHashNone:
+		hashOutputs = sha256.Sum256(sigHashes.outputs[:])
+	case baseType == SigHashSingle && i < len(tx.TransactionOutputs):
+		hashOutputs = doubleSHA256(appendTxOut(nil, tx.TransactionOutputs[i]))
+	}
+
+	in := tx.TransactionInputs[i]
+	preimage := binary.LittleEndian.AppendUint32(nil, tx.Version)
+	preimage = append(preimage, hashPrevouts[:]...)
+	preimage = append(preimage, hashSequence[:]...)
+	preimage = appendOutPoint(preimage, in.PreviousOutput)
+	preimage = appendCompactSize(preimage, len(scriptCode))
+	preimage = append(preimage, scriptCode...)
+	preimage = binary.LittleEndian.AppendUint64(preimage, uint64(amount))
+	preimage = binary.LittleEndian.AppendUint32(preimage, in.Sequence)
+	preimage = append(preimage, hashOutputs[:]...)
+	preimage = binary.LittleEndian.AppendUint32(preimage, tx.LockTime)
+	preimage = binary.LittleEndian.AppendUint32(preimage, hashType)
+	return doubleSHA256(preimage)
+}
+
+// TaprootSignatureHash returns the hash signed by the taproot signature of input i of tx with hashType, annex being the annex of the witness
+// of the input (nil for none) (https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#common-signature-message). Signatures of key
+// path spends have no tapLeafHash, while the ones a tapscript checks commit to its hash and to the position of the last OP_CODESEPARATOR
+// run in it, 0xffffffff for none (https://github.com/bitcoin/bips/blob/master/bip-0342.mediawiki#signature-validation). It fails if hashType
+// is not a sighash type, if it is SIGHASH_SINGLE and the input has no matching output, or if sigHashes lack the outputs the inputs spend.
+func TaprootSignatureHash(tx *message.TxPayload, i int, hashType byte, sigHashes *TxSigHashes, annex []byte, tapLeafHash []byte,
+	codeSepPos uint32) ([32]byte, bool) {
+	if hashType > SigHashSingle && (hashType < SigHashAnyoneCanPay|SigHashAll || hashType > SigHashAnyoneCanPay|SigHashSingle) {
+		return [32]byte{}, false
+	}
+	if sigHashes.prevOuts == nil {
+		return [32]byte{}, false
+	}
+	outputType := hashType & 0x03
+	if hashType == SigHashDefault {
+		outputType = SigHashAll
+	}
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+
+	// epoch 0
+	preimage := []byte{0, hashType}
+	preimage = binary.LittleEndian.AppendUint32(preimage, tx.Version)
+	preimage = binary.LittleEndian.AppendUint32(preimage, tx.LockTime)
+	if !anyoneCanPay {
+		preimage = append(preimage, sigHashes.prevouts[:]...)
+		preimage = append(preimage, sigHashes.amounts[:]...)
+		preimage = append(preimage, sigHashes.scripts[:]...)
+		preimage = append(preimage, sigHashes.sequences[:]...)
+	}
+	if outputType == SigHashAll {
+		preimage = append(preimage, sigHashes.outputs[:]...)
+	}
+	var spendType byte
+	if tapLeafHash != nil {
+		spendType = 2
+	}
+	if annex != nil {
+		spendType |= 1
+	}
+	preimage = append(preimage, spendType)
+	if anyoneCanPay {
+		in := tx.TransactionInputs[i]
+		preimage = appendOutPoint(preimage, in.PreviousOutput)
+		preimage = appendTxOut(preimage, sigHashes.prevOuts[i])
+		preimage = binary.LittleEndian.AppendUint32(preimage, in.Sequence)
+	} else {
+		preimage = binary.LittleEndian.AppendUint32(preimage, uint32(i))
+	}
+	if annex != nil {
+		annexHash := sha256.Sum256(append(appendCompactSize(nil, len(annex)), annex...))
+		preimage = append(preimage, annexHash[:]...)
+	}
+	if outputType == SigHashSingle {
+		if i >= len(tx.TransactionOutputs) {
+			return [32]byte{}, false
+		}
+		output := sha256.Sum256(appendTxOut(nil, tx.TransactionOutputs[i]))
+		preimage = append(preimage, output[:]...)
+	}
+	if tapLeafHash != nil {
+		preimage = append(preimage, tapLeafHash...)
+		// key version 0
+		preimage = append(preimage, 0)
+		preimage = binary.LittleEndian.AppendUint32(preimage, codeSepPos)
+	}
+	return secp256k1.TaggedHash("TapSighash", preimage), true
+}
+
+// removeCodeSeparators returns script without its OP_CODESEPARATORs
+func removeCodeSeparators(script []byte) []byte {
+	if !bytes.Contains(script, []byte{opCodeSeparator}) {
+		return script
+	}
+	removed := make([]byte, 0, len(script))
+	pc := 0
+	for pc < len(script) {
+		op, next, err := nextOp(script, pc)
+		if err != nil {
+			break
+		}
+		if op.Opcode != opCodeSeparator {
+			removed = append(removed, script[pc:next]...)
+		}
+		pc = next
+	}
+	// a malformed push ends the script as is
+	return append(removed, script[pc:]...)
+}
+
+func appendOutPoint(b []byte, outPoint message.OutPoint) []byte {
+	b = append(b, outPoint.Hash[:]...)
+	return binary.LittleEndian.AppendUint32(b, outPoint.Index)
+}
+
+func appendTxOut(b []byte, out message.TxOut) []byte {
+	b = binary.LittleEndian.AppendUint64(b, uint64(out.Value))
+	b = appendCompactSize(b, len(out.PkScript))
+	return append(b, out.PkScript...)
+}
+
+// appendCompactSize appends n the way message.VarInt encodes it
+func appendCompactSize(b []byte, n int) []byte {
+	switch {
+	case n < 0xfd:
+		return append(b, byte(n))
+	case n <= 0xffff:
+		return binary.LittleEndian.AppendUint16(append(b, 0xfd), uint16(n))
+	case n <= 0xffffffff:
+		return binary.LittleEndian.AppendUint32(append(b, 0xfe), uint32(n))
+	default:
+		return binary.LittleEndian.AppendUint64(append(b, 0xff), uint64(n))
+	}
+}
+
+func doubleSHA256(data []byte) [32]byte {
+	hash := sha256.Sum256(data)
+	return sha256.Sum256(hash[:])
+}
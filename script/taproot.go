@@ -0,0 +1,155 @@
+package script
+
+import (
+	"bytes"
+	"github.com/aang114/bitcoin-node/internal/secp256k1"
+)
+
+// Limits and tags of taproot spends (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.h#L225-L236)
+const (
+	// first byte of the last item of a witness that is its annex
+	annexTag = 0x50
+	// bits of the first byte of a control block that are its leaf version, the other bit being the parity of the output key
+	tapLeafMask = 0xfe
+	// leaf version of tapscripts
+	tapLeafTapscript = 0xc0
+	// control blocks are the leaf version, the internal key and the hashes of up to 128 nodes of the script tree
+	controlBaseSize = 33
+	controlNodeSize = 32
+	controlMaxNodes = 128
+	// signature checks of a tapscript draw 50 from a budget of 50 plus the size of the witness, which bounds them by the size of the
+	// transaction the way the legacy limit of signature operations does
+	validationWeightPerSigOp = 50
+	validationWeightOffset   = 50
+)
+
+// isOpSuccess reports whether opcode is an OP_SUCCESSx, which makes tapscripts having it succeed whatever they run, so that soft forks can
+// give it a meaning (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.cpp#L333-L338)
+func isOpSuccess(opcode byte) bool {
+	return opcode == 80 || opcode == 98 || (opcode >= 126 && opcode <= 129) || (opcode >= 131 && opcode <= 134) ||
+		(opcode >= 137 && opcode <= 138) || (opcode >= 141 && opcode <= 142) || (opcode >= 149 && opcode <= 153) ||
+		(opcode >= 187 && opcode <= 254)
+}
+
+// verifyTaproot runs the witness spending a taproot output whose output key is program: a signature by the key, or a script the key commits
+// to followed by the path to it in the script tree, in a control block, and by an optional annex
+// (https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#script-validation-rules). Scripts of leaf versions other than
+// tapscript's are left to soft forks the node does not enforce and succeed.
+func (e *engine) verifyTaproot(witness [][]byte, program []byte) error {
+	if len(witness) == 0 {
+		return scriptFailed("empty witness")
+	}
+	witnessSize := len(appendCompactSize(nil, len(witness)))
+	for _, item := range witness {
+		witnessSize += len(appendCompactSize(nil, len(item))) + len(item)
+	}
+	if last := witness[len(witness)-1]; len(witness) >= 2 && len(last) > 0 && last[0] == annexTag {
+		e.annex, witness = last, witness[:len(witness)-1]
+	}
+	if len(witness) == 1 {
+		return e.checkSchnorrSig(witness[0], program)
+	}
+
+	control, script := witness[len(witness)-1], witness[len(witness)-2]
+	witness = witness[:len(witness)-2]
+	if len(control) < controlBaseSize || len(control) > controlBaseSize+controlMaxNodes*controlNodeSize ||
+		(len(control)-controlBaseSize)%controlNodeSize != 0 {
+		return scriptFailed("control block of the wrong size")
+	}
+	leafVersion := control[0] & tapLeafMask
+	leafHash := secp256k1.TaggedHash("TapLeaf", []byte{leafVersion}, appendCompactSize(nil, len(script)), script)
+	if !commitsToLeaf(control, program, leafHash) {
+		return scriptFailed("output key does not commit to the script")
+	}
+	if leafVersion != tapLeafTapscript {
+		return nil
+	}
+	e.tapLeafHash = leafHash[:]
+	e.validationWeight = int64(witnessSize) + validationWeightOffset
+	return e.executeWitnessScript(witness, script, sigVersionTapscript)
+}
+
+// commitsToLeaf reports whether outputKey is the internal key of control tweaked with the root of the script tree in which control is the
+// path to the leaf hashed to leafHash (https://github.com/bitcoin/bips/blob/master/bip-0341.mediawiki#script-validation-rules)
+func commitsToLeaf(control, outputKey []byte, leafHash [32]byte) bool {
+	internalKey, err := secp256k1.ParseXOnlyPublicKey(control[1:controlBaseSize])
+	if err != nil {
+		return false
+	}
+	// the nodes of a branch are hashed in lexicographic order, so that the path does not tell which side each one is on
+	node := leafHash
+	for path := control[controlBaseSize:]; len(path) > 0; path = path[controlNodeSize:] {
+		sibling := path[:controlNodeSize]
+		if bytes.Compare(node[:], sibling) < 0 {
+			node = secp256k1.TaggedHash("TapBranch", node[:], sibling)
+		} else {
+			node = secp256k1.TaggedHash("TapBranch", sibling, node[:])
+		}
+	}
+	tweak := secp256k1.TaggedHash("TapTweak", control[1:controlBaseSize], node[:])
+	tweaked, ok := internalKey.AddTweak(tweak[:])
+	return ok && bytes.Equal(tweaked.SerializeXOnly(), outputKey) && tweaked.Y.Bit(0) == uint(control[0]&1)
+}
+
+// checkSchnorrSig fails unless sig, followed by its sighash type unless it is SIGHASH_DEFAULT, is a signature by the x-only pubKey of the
+// transaction (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1679-L1699)
+func (e *engine) checkSchnorrSig(sig, pubKey []byte) error {
+	hashType := byte(SigHashDefault)
+	switch len(sig) {
+	case 64:
+	case 65:
+		hashType = sig[64]
+		if hashType == SigHashDefault {
+			return scriptFailed("SIGHASH_DEFAULT given explicitly")
+		}
+		sig = sig[:64]
+	default:
+		return scriptFailed("Schnorr signature of the wrong size")
+	}
+	sigHash, ok := TaprootSignatureHash(e.tx, e.input, hashType, e.sigHashes, e.annex, e.tapLeafHash, e.codeSepPos)
+	if !ok {
+		return scriptFailed("invalid sighash type, or outputs spent unknown")
+	}
+	key, err := secp256k1.ParseXOnlyPublicKey(pubKey)
+	if err != nil || !secp256k1.VerifySchnorr(key, sigHash[:], sig) {
+		return scriptFailed("invalid Schnorr signature")
+	}
+	return nil
+}
+
+// checkSigTapscript reports whether sig is a signature by pubKey, the way tapscripts check signatures: an empty signature is reported as
+// invalid, other invalid signatures fail the script, and public keys that are neither empty nor 32 bytes long are left to soft forks and
+// pass any signature (https://github.com/bitcoin/bips/blob/master/bip-0342.mediawiki#rules-for-signature-opcodes)
+func (e *engine) checkSigTapscript(sig, pubKey []byte) (bool, error) {
+	if len(sig) > 0 {
+		e.validationWeight -= validationWeightPerSigOp
+		if e.validationWeight < 0 {
+			return false, scriptFailed("too many signature checks for the size of the witness")
+		}
+	}
+	if len(pubKey) == 0 {
+		return false, scriptFailed("empty public key")
+	}
+	if len(pubKey) == 32 && len(sig) > 0 {
+		err := e.checkSchnorrSig(sig, pubKey)
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(sig) > 0, nil
+}
+
+// hasOpSuccess reports whether script has an OP_SUCCESSx, and fails if it cannot be parsed up to it
+func hasOpSuccess(script []byte) (bool, error) {
+	for pc := 0; pc < len(script); {
+		op, next, err := nextOp(script, pc)
+		if err != nil {
+			return false, scriptFailed(err.Error())
+		}
+		if isOpSuccess(op.Opcode) {
+			return true, nil
+		}
+		pc = next
+	}
+	return false, nil
+}
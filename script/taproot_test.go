@@ -0,0 +1,273 @@
+package script_test
+
+import (
+	"encoding/hex"
+	"github.com/aang114/bitcoin-node/internal/secp256k1"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+// xOnly returns the public key of the key as taproot serializes it
+func (k testKey) xOnly() []byte {
+	return secp256k1.PublicKeyOf(k.d).SerializeXOnly()
+}
+
+// signSchnorr returns the taproot signature of input i of tx by the key with hashType, which follows it unless it is SIGHASH_DEFAULT, for a
+// key path spend or, with tapLeafHash, for a tapscript having no OP_CODESEPARATOR
+func (k testKey) signSchnorr(t *testing.T, tx *message.TxPayload, i int, hashType byte, prevOuts []message.TxOut, annex,
+	tapLeafHash []byte) []byte {
+	hash, ok := script.TaprootSignatureHash(tx, i, hashType, script.NewTxSigHashes(tx, prevOuts), annex, tapLeafHash, 0xffffffff)
+	require.True(t, ok)
+	sig := secp256k1.SignSchnorr(k.d, hash[:], make([]byte, 32))
+	if hashType != script.SigHashDefault {
+		sig = append(sig, hashType)
+	}
+	return sig
+}
+
+func taprootPkScript(outputKey []byte) []byte {
+	return append([]byte{script.Op1, 32}, outputKey...)
+}
+
+func tapLeafHash(leafVersion byte, leafScript []byte) []byte {
+	// scripts of up to 0xffff bytes, whose sizes take 1 or 3 bytes
+	size := []byte{byte(len(leafScript))}
+	if len(leafScript) >= 0xfd {
+		size = []byte{0xfd, byte(len(leafScript)), byte(len(leafScript) >> 8)}
+	}
+	hash := secp256k1.TaggedHash("TapLeaf", []byte{leafVersion}, size, leafScript)
+	return hash[:]
+}
+
+func tapBranchHash(a, b []byte) []byte {
+	if slices.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	hash := secp256k1.TaggedHash("TapBranch", a, b)
+	return hash[:]
+}
+
+// tweak returns the output key of internalKey committing to the script tree whose root is merkleRoot, and the parity of its Y
+func tweak(t *testing.T, internalKey []byte, merkleRoot []byte) ([]byte, byte) {
+	key, err := secp256k1.ParseXOnlyPublicKey(internalKey)
+	require.NoError(t, err)
+	tweak := secp256k1.TaggedHash("TapTweak", internalKey, merkleRoot)
+	outputKey, ok := key.AddTweak(tweak[:])
+	require.True(t, ok)
+	return outputKey.SerializeXOnly(), byte(outputKey.Y.Bit(0))
+}
+
+func withWitness(tx *message.TxPayload, items ...[]byte) *message.TxPayload {
+	witness := make([]message.ComponentData, len(items))
+	for i, item := range items {
+		witness[i] = item
+	}
+	tx.TransactionWitnesses = []message.TxWitness{{ComponentDataList: witness}}
+	return tx
+}
+
+func verifyTaprootInput(tx *message.TxPayload, prevOut message.TxOut, flags script.VerifyFlags) error {
+	return script.VerifyInput(tx, 0, &prevOut, flags, script.NewTxSigHashes(tx, []message.TxOut{prevOut}))
+}
+
+func TestTaprootSignatureHash(t *testing.T) {
+	tx := &message.TxPayload{
+		Version: 2,
+		TransactionInputs: []message.TxIn{
+			{PreviousOutput: message.OutPoint{Hash: message.Hash256{1}, Index: 1}, Sequence: 0xfffffffd},
+			{PreviousOutput: message.OutPoint{Hash: message.Hash256{2}, Index: 0}, Sequence: 0xffffffff},
+		},
+		TransactionOutputs: []message.TxOut{{Value: 1000, PkScript: []byte{script.Op1}}, {Value: 2000, PkScript: []byte{script.Op1 + 1}}},
+		LockTime:           700000,
+	}
+	prevOuts := []message.TxOut{
+		{Value: 5000, PkScript: taprootPkScript(newTestKey(1).xOnly())},
+		{Value: 7000, PkScript: decodeHex(t, "0014751e76e8199196d454941c45d1b3a323f1433bd6")},
+	}
+	sigHashes := script.NewTxSigHashes(tx, prevOuts)
+	leafHash := tapLeafHash(0xc0, []byte{script.Op1})
+
+	// expected hashes worked out with a Python implementation of the signature message of BIP 341
+	tests := []struct {
+		name        string
+		i           int
+		hashType    byte
+		annex       []byte
+		tapLeafHash []byte
+		expected    string
+	}{
+		{"SIGHASH_DEFAULT", 0, script.SigHashDefault, nil, nil, "e192c38b20be47fa68819cef722c8ff3c8d3955dd182c7040048765bdb71cca5"},
+		{"SIGHASH_ALL", 0, script.SigHashAll, nil, nil, "996b97821df57523fec42c099049c695103e13b0c38144ac9742134943fa5b6b"},
+		{"SIGHASH_NONE of the second input", 1, script.SigHashNone, nil, nil, "d831d8dcc4703659f70c4fdcdf15049802552016d3b3373eca225703368d8002"},
+		{"SIGHASH_SINGLE|SIGHASH_ANYONECANPAY", 1, script.SigHashSingle | script.SigHashAnyoneCanPay, nil, nil,
+			"8481f2e4cadbe2e619af618777f1ac366ce6c9ccb9cc40e951e31ad41ca1dbe6"},
+		{"annex", 0, script.SigHashDefault, []byte{0x50, 1, 2}, nil, "47c730338f384ba0da19fd8298d1dbaa1c1f742ce25b97fd653cf7605b678e7f"},
+		{"tapscript", 0, script.SigHashAll | script.SigHashAnyoneCanPay, nil, leafHash,
+			"ac4e05a29b8ac8ec11cb1f8c54c17a6e03bb454cfec6eb8b3aa2a9284a0b295f"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, ok := script.TaprootSignatureHash(tx, test.i, test.hashType, sigHashes, test.annex, test.tapLeafHash, 0xffffffff)
+			require.True(t, ok)
+			require.Equal(t, test.expected, hex.EncodeToString(hash[:]))
+		})
+	}
+
+	for _, hashType := range []byte{0x04, 0x80, 0x84} {
+		_, ok := script.TaprootSignatureHash(tx, 0, hashType, sigHashes, nil, nil, 0xffffffff)
+		require.False(t, ok, "%#x is not a sighash type", hashType)
+	}
+	tx.TransactionOutputs = tx.TransactionOutputs[:1]
+	_, ok := script.TaprootSignatureHash(tx, 1, script.SigHashSingle, script.NewTxSigHashes(tx, prevOuts), nil, nil, 0xffffffff)
+	require.False(t, ok, "SIGHASH_SINGLE should need an output matching the input")
+	_, ok = script.TaprootSignatureHash(tx, 0, script.SigHashDefault, script.NewTxSigHashes(tx, nil), nil, nil, 0xffffffff)
+	require.False(t, ok, "the outputs spent should be needed")
+}
+
+func TestVerifyInput_TaprootKeyPath(t *testing.T) {
+	key, other := newTestKey(1), newTestKey(2)
+	prevOut := message.TxOut{Value: 5000, PkScript: taprootPkScript(key.xOnly())}
+	prevOuts := []message.TxOut{prevOut}
+
+	for _, hashType := range []byte{script.SigHashDefault, script.SigHashAll, script.SigHashSingle | script.SigHashAnyoneCanPay} {
+		tx := newSpendingTx()
+		tx = withWitness(tx, key.signSchnorr(t, tx, 0, hashType, prevOuts, nil, nil))
+		require.NoError(t, verifyTaprootInput(tx, prevOut, allFlags), "sighash type %#x", hashType)
+	}
+
+	t.Run("a signature committing to an annex should only be valid with the annex", func(t *testing.T) {
+		annex := []byte{0x50, 1}
+		tx := newSpendingTx()
+		sig := key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, annex, nil)
+		require.NoError(t, verifyTaprootInput(withWitness(tx, sig, annex), prevOut, allFlags))
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, sig), prevOut, allFlags), script.ErrScriptFailed)
+	})
+
+	tests := []struct {
+		name    string
+		witness func(tx *message.TxPayload) [][]byte
+	}{
+		{"a signature by another key", func(tx *message.TxPayload) [][]byte {
+			return [][]byte{other.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, nil)}
+		}},
+		{"a signature committing to another value spent", func(tx *message.TxPayload) [][]byte {
+			otherValue := []message.TxOut{{Value: prevOut.Value + 1, PkScript: prevOut.PkScript}}
+			return [][]byte{key.signSchnorr(t, tx, 0, script.SigHashDefault, otherValue, nil, nil)}
+		}},
+		{"SIGHASH_DEFAULT given explicitly", func(tx *message.TxPayload) [][]byte {
+			return [][]byte{append(key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, nil), script.SigHashDefault)}
+		}},
+		{"an undefined sighash type", func(tx *message.TxPayload) [][]byte {
+			return [][]byte{append(key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, nil), 0x04)}
+		}},
+		{"an ECDSA signature", func(tx *message.TxPayload) [][]byte {
+			return [][]byte{key.sign(tx, 0, prevOut.PkScript, true, prevOut.Value)}
+		}},
+		{"an empty witness", func(tx *message.TxPayload) [][]byte {
+			return nil
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name+" should fail", func(t *testing.T) {
+			tx := newSpendingTx()
+			tx = withWitness(tx, test.witness(tx)...)
+			require.ErrorIs(t, verifyTaprootInput(tx, prevOut, allFlags), script.ErrScriptFailed)
+			require.NoError(t, verifyTaprootInput(tx, prevOut, script.FlagsAt(mainnet, mainnet.TaprootHeight-1)), "before taproot")
+		})
+	}
+
+	t.Run("taproot outputs nested in P2SH should be left to later soft forks", func(t *testing.T) {
+		redeemScript := taprootPkScript(key.xOnly())
+		p2sh := message.TxOut{Value: 5000, PkScript: slices.Concat([]byte{script.OpHash160, 20}, hash160(redeemScript), []byte{script.OpEqual})}
+		tx := withWitness(newSpendingTx(), []byte{1})
+		tx.TransactionInputs[0].SignatureScript = push(redeemScript)
+		require.NoError(t, verifyTaprootInput(tx, p2sh, allFlags))
+	})
+}
+
+func TestVerifyInput_TaprootScriptPath(t *testing.T) {
+	internalKey, key, other := newTestKey(1).xOnly(), newTestKey(2), newTestKey(3)
+	checkSig := slices.Concat([]byte{32}, key.xOnly(), []byte{script.OpCheckSig})
+	// OP_CHECKSIGADD 2-of-2: <key> OP_CHECKSIG <other> OP_CHECKSIGADD 2 OP_NUMEQUAL
+	checkSigAdd := slices.Concat([]byte{32}, key.xOnly(), []byte{script.OpCheckSig, 32}, other.xOnly(), []byte{0xba, script.Op1 + 1, 0x9c})
+	leaves := [][]byte{checkSig, checkSigAdd}
+	leafHashes := [][]byte{tapLeafHash(0xc0, checkSig), tapLeafHash(0xc0, checkSigAdd)}
+	outputKey, parity := tweak(t, internalKey, tapBranchHash(leafHashes[0], leafHashes[1]))
+	prevOut := message.TxOut{Value: 5000, PkScript: taprootPkScript(outputKey)}
+	prevOuts := []message.TxOut{prevOut}
+	// control blocks of the leaves, each the path to the leaf: the hash of the other one
+	control := func(leaf int) []byte {
+		return slices.Concat([]byte{0xc0 | parity}, internalKey, leafHashes[1-leaf])
+	}
+
+	t.Run("spends of both leaves should succeed", func(t *testing.T) {
+		tx := newSpendingTx()
+		sig := key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, leafHashes[0])
+		require.NoError(t, verifyTaprootInput(withWitness(tx, sig, leaves[0], control(0)), prevOut, allFlags))
+
+		otherSig := other.signSchnorr(t, tx, 0, script.SigHashAll, prevOuts, nil, leafHashes[1])
+		sig = key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, leafHashes[1])
+		require.NoError(t, verifyTaprootInput(withWitness(tx, otherSig, sig, leaves[1], control(1)), prevOut, allFlags))
+		// an empty signature counts as a failed check, which the script then fails on
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, nil, sig, leaves[1], control(1)), prevOut, allFlags), script.ErrScriptFailed)
+	})
+
+	t.Run("a signature committing to another leaf should fail", func(t *testing.T) {
+		tx := newSpendingTx()
+		sig := key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, leafHashes[1])
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, sig, leaves[0], control(0)), prevOut, allFlags), script.ErrScriptFailed)
+	})
+
+	t.Run("a control block not committing to the script should fail", func(t *testing.T) {
+		tx := newSpendingTx()
+		sig := key.signSchnorr(t, tx, 0, script.SigHashDefault, prevOuts, nil, leafHashes[0])
+		wrongParity := control(0)
+		wrongParity[0] ^= 1
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, sig, leaves[0], wrongParity), prevOut, allFlags), script.ErrScriptFailed)
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, sig, leaves[0], control(1)), prevOut, allFlags), script.ErrScriptFailed)
+		require.ErrorIs(t, verifyTaprootInput(withWitness(tx, sig, leaves[0], control(0)[:40]), prevOut, allFlags), script.ErrScriptFailed)
+	})
+
+	// trees of a single leaf, whose control block has no path
+	spendLeaf := func(leafVersion byte, leafScript []byte, stack ...[]byte) error {
+		outputKey, parity := tweak(t, internalKey, tapLeafHash(leafVersion, leafScript))
+		prevOut := message.TxOut{Value: 5000, PkScript: taprootPkScript(outputKey)}
+		witness := append(stack, leafScript, slices.Concat([]byte{leafVersion | parity}, internalKey))
+		return verifyTaprootInput(withWitness(newSpendingTx(), witness...), prevOut, allFlags)
+	}
+	tests := []struct {
+		name        string
+		leafVersion byte
+		script      []byte
+		stack       [][]byte
+		valid       bool
+	}{
+		{"OP_SUCCESSx", 0xc0, []byte{script.OpReturn, 0x50}, nil, true},
+		{"OP_SUCCESSx after a malformed push", 0xc0, []byte{script.OpPushData1, 0x50}, nil, false},
+		{"unknown leaf version", 0xc2, []byte{script.OpReturn}, nil, true},
+		{"OP_CHECKMULTISIG", 0xc0, []byte{script.Op0, script.Op0, script.Op0, script.OpCheckMultiSig}, nil, false},
+		{"OP_IF taking 1", 0xc0, []byte{0x63, script.Op1, 0x68}, [][]byte{{1}}, true},
+		{"OP_IF taking a true item other than 1", 0xc0, []byte{0x63, script.Op1, 0x68}, [][]byte{{2}}, false},
+		{"scripts longer than 10000 bytes", 0xc0, append(slices.Repeat(append(push(make([]byte, 255)), 0x75), 40), script.Op1), nil, true},
+		{"more than 201 operations", 0xc0, append(slices.Repeat([]byte{0x61}, 300), script.Op1), nil, true},
+		{"an empty public key", 0xc0, []byte{script.Op0, script.OpCheckSig}, [][]byte{nil}, false},
+		{"public keys of unknown types", 0xc0, []byte{script.Op1, script.OpCheckSig}, [][]byte{{1}}, true},
+		{"two items left on the stack", 0xc0, []byte{script.Op1}, [][]byte{{1}}, false},
+		// each signature check costs 50 of a budget of 50 plus the size of the witness
+		{"signature checks within the budget", 0xc0, append(slices.Repeat([]byte{script.Op1, script.Op1, 0xad}, 1), script.Op1), nil, true},
+		{"signature checks beyond the budget", 0xc0, append(slices.Repeat([]byte{script.Op1, script.Op1, 0xad}, 100), script.Op1), nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := spendLeaf(test.leafVersion, test.script, test.stack...)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, script.ErrScriptFailed)
+			}
+		})
+	}
+}
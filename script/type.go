@@ -0,0 +1,145 @@
+package script
+
+// Type is the standard template an output script matches, named the way bitcoind names it
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/solver.cpp)
+type Type string
+
+const (
+	NonStandard         Type = "nonstandard"
+	PubKey              Type = "pubkey"
+	PubKeyHash          Type = "pubkeyhash"
+	ScriptHash          Type = "scripthash"
+	MultiSig            Type = "multisig"
+	NullData            Type = "nulldata"
+	WitnessV0KeyHash    Type = "witness_v0_keyhash"
+	WitnessV0ScriptHash Type = "witness_v0_scripthash"
+	WitnessV1Taproot    Type = "witness_v1_taproot"
+	WitnessUnknown      Type = "witness_unknown"
+)
+
+const maxWitnessProgramSize = 40
+
+// Classify returns the standard template pkScript matches, or NonStandard
+func Classify(pkScript []byte) Type {
+	if isPayToScriptHash(pkScript) {
+		return ScriptHash
+	}
+	if version, program, ok := WitnessProgram(pkScript); ok {
+		switch {
+		case version == 0 && len(program) == 20:
+			return WitnessV0KeyHash
+		case version == 0 && len(program) == 32:
+			return WitnessV0ScriptHash
+		case version == 1 && len(program) == 32:
+			return WitnessV1Taproot
+		case version != 0:
+			return WitnessUnknown
+		}
+		return NonStandard
+	}
+	if len(pkScript) > 0 && pkScript[0] == OpReturn && isPushOnly(pkScript[1:]) {
+		return NullData
+	}
+	if isPayToPubKey(pkScript) {
+		return PubKey
+	}
+	if isPayToPubKeyHash(pkScript) {
+		return PubKeyHash
+	}
+	if isMultiSig(pkScript) {
+		return MultiSig
+	}
+	return NonStandard
+}
+
+// WitnessProgram returns the version and program of pkScript if it is a witness program: a version opcode followed by a push of 2 to 40
+// bytes (https://github.com/bitcoin/bips/blob/master/bip-0141.mediawiki#witness-program)
+func WitnessProgram(pkScript []byte) (int, []byte, bool) {
+	if len(pkScript) < 4 || len(pkScript) > maxWitnessProgramSize+2 {
+		return 0, nil, false
+	}
+	if pkScript[0] != Op0 && (pkScript[0] < Op1 || pkScript[0] > Op16) {
+		return 0, nil, false
+	}
+	if int(pkScript[1])+2 != len(pkScript) {
+		return 0, nil, false
+	}
+	version := 0
+	if pkScript[0] != Op0 {
+		version = int(pkScript[0]-Op1) + 1
+	}
+	return version, pkScript[2:], true
+}
+
+func isPayToScriptHash(pkScript []byte) bool {
+	return len(pkScript) == 23 && pkScript[0] == OpHash160 && pkScript[1] == 20 && pkScript[22] == OpEqual
+}
+
+func isPayToPubKeyHash(pkScript []byte) bool {
+	return len(pkScript) == 25 && pkScript[0] == OpDup && pkScript[1] == OpHash160 && pkScript[2] == 20 && pkScript[23] == OpEqualVerify &&
+		pkScript[24] == OpCheckSig
+}
+
+// isPayToPubKey reports whether pkScript is a direct push of a public key followed by OP_CHECKSIG
+func isPayToPubKey(pkScript []byte) bool {
+	if len(pkScript) < 2 || pkScript[len(pkScript)-1] != OpCheckSig || int(pkScript[0])+2 != len(pkScript) {
+		return false
+	}
+	return isPubKey(Op{Opcode: pkScript[0], Data: pkScript[1 : len(pkScript)-1]})
+}
+
+// isMultiSig reports whether pkScript is a bare multisig script: the number of signatures required, the public keys, their number and
+// OP_CHECKMULTISIG
+func isMultiSig(pkScript []byte) bool {
+	ops, err := Parse(pkScript)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].Opcode != OpCheckMultiSig {
+		return false
+	}
+	required, ok := smallInt(ops[0])
+	keys, ok2 := smallInt(ops[len(ops)-2])
+	if !ok || !ok2 || required < 1 || keys < required || keys != len(ops)-3 {
+		return false
+	}
+	for _, op := range ops[1 : len(ops)-2] {
+		if !isPubKey(op) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPubKey reports whether op pushes data sized like the public key its first byte announces: 33 bytes for compressed keys, 65 for
+// uncompressed ones (https://github.com/bitcoin/bitcoin/blob/v27.0/src/pubkey.h#L60-L70)
+func isPubKey(op Op) bool {
+	if !op.IsPush() || len(op.Data) == 0 {
+		return false
+	}
+	switch op.Data[0] {
+	case 0x02, 0x03:
+		return len(op.Data) == 33
+	case 0x04, 0x06, 0x07:
+		return len(op.Data) == 65
+	}
+	return false
+}
+
+func smallInt(op Op) (int, bool) {
+	if op.Opcode < Op1 || op.Opcode > Op16 {
+		return 0, false
+	}
+	return int(op.Opcode-Op1) + 1, true
+}
+
+// isPushOnly reports whether script only pushes data, counting OP_1NEGATE, OP_RESERVED and OP_1 to OP_16 as pushes the way bitcoind does
+func isPushOnly(script []byte) bool {
+	ops, err := Parse(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if op.Opcode > Op16 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"slices"
+)
+
+// VerifyFlags are the soft forks whose rules scripts are verified with
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.h#L42-L143)
+type VerifyFlags uint32
+
+const (
+	// P2SH outputs run the script whose hash they commit to (BIP 16)
+	VerifyP2SH VerifyFlags = 1 << iota
+	// signatures must be strictly DER encoded (BIP 66)
+	VerifyDERSig
+	// OP_CHECKLOCKTIMEVERIFY is no longer a no-op (BIP 65)
+	VerifyCheckLockTimeVerify
+	// OP_CHECKSEQUENCEVERIFY is no longer a no-op (BIP 112)
+	VerifyCheckSequenceVerify
+	// witness programs run the witness of their inputs (BIP 141 and BIP 143)
+	VerifyWitness
+	// the extra item OP_CHECKMULTISIG pops must be empty (BIP 147)
+	VerifyNullDummy
+	// segwit v1 programs of 32 bytes are taproot outputs, spent with a Schnorr signature or a tapscript (BIP 341 and BIP 342)
+	VerifyTaproot
+)
+
+// StandardFlags are the flags the scripts of unconfirmed transactions are verified with before they enter the mempool: the rules of every
+// soft fork the node enforces, whatever the height of the chain (https://github.com/bitcoin/bitcoin/blob/v27.0/src/policy/policy.h#L103-L125)
+const StandardFlags = VerifyP2SH | VerifyDERSig | VerifyCheckLockTimeVerify | VerifyCheckSequenceVerify | VerifyWitness | VerifyNullDummy |
+	VerifyTaproot
+
+// FlagsAt returns the flags the scripts of the block at height are verified with, on a network whose soft forks are enforced from the heights
+// of deployments (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L2216-L2254)
+func FlagsAt(deployments constants.Deployments, height int32) VerifyFlags {
+	var flags VerifyFlags
+	if height >= deployments.BIP16Height {
+		flags |= VerifyP2SH
+	}
+	if height >= deployments.BIP66Height {
+		flags |= VerifyDERSig
+	}
+	if height >= deployments.BIP65Height {
+		flags |= VerifyCheckLockTimeVerify
+	}
+	if height >= deployments.CSVHeight {
+		flags |= VerifyCheckSequenceVerify
+	}
+	if height >= deployments.SegwitHeight {
+		flags |= VerifyWitness | VerifyNullDummy
+	}
+	if height >= deployments.TaprootHeight {
+		flags |= VerifyTaproot
+	}
+	return flags
+}
+
+// VerifyInput runs the scripts with which input i of tx spends prevOut: its signature script, the script of prevOut and, depending on
+// flags, the script a P2SH output commits to and the witness of the input
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1974-L2087). It fails with ErrScriptFailed if they do not
+// succeed. sigHashes are the hashes of tx that segwit v0 and taproot signatures commit to.
+//
+// Witness programs other than the ones of segwit v0 and taproot are left to soft forks the node does not enforce: inputs spending them
+// succeed whatever their witness.
+func VerifyInput(tx *message.TxPayload, i int, prevOut *message.TxOut, flags VerifyFlags, sigHashes *TxSigHashes) error {
+	scriptSig := tx.TransactionInputs[i].SignatureScript
+	var witness [][]byte
+	if i < len(tx.TransactionWitnesses) {
+		for _, item := range tx.TransactionWitnesses[i].ComponentDataList {
+			witness = append(witness, item)
+		}
+	}
+	e := &engine{tx: tx, input: i, amount: prevOut.Value, flags: flags, sigHashes: sigHashes}
+
+	err := e.eval(scriptSig, sigVersionBase)
+	if err != nil {
+		return err
+	}
+	// the stack the redeem script of a P2SH output runs on
+	p2shStack := slices.Clone(e.stack)
+	err = e.eval(prevOut.PkScript, sigVersionBase)
+	if err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !castToBool(e.stack.at(0)) {
+		return scriptFailed("output script evaluated to false")
+	}
+
+	hadWitness := false
+	if version, program, ok := WitnessProgram(prevOut.PkScript); ok && flags&VerifyWitness != 0 {
+		hadWitness = true
+		if len(scriptSig) != 0 {
+			return scriptFailed("witness program spent with a signature script")
+		}
+		err = e.verifyWitnessProgram(witness, version, program, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isPayToScriptHash(prevOut.PkScript) && flags&VerifyP2SH != 0 {
+		if !isPushOnly(scriptSig) {
+			return scriptFailed("P2SH output spent with a signature script that is not push-only")
+		}
+		// the signature script pushed at least the redeem script, whose hash the output script matched
+		e.stack = p2shStack
+		redeemScript := e.stack.pop()
+		err = e.eval(redeemScript, sigVersionBase)
+		if err != nil {
+			return err
+		}
+		if len(e.stack) == 0 || !castToBool(e.stack.at(0)) {
+			return scriptFailed("redeem script evaluated to false")
+		}
+		if version, program, ok := WitnessProgram(redeemScript); ok && flags&VerifyWitness != 0 {
+			hadWitness = true
+			if !bytes.Equal(scriptSig, pushOf(redeemScript)) {
+				return scriptFailed("P2SH witness program spent with a signature script pushing more than it")
+			}
+			err = e.verifyWitnessProgram(witness, version, program, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags&VerifyWitness != 0 && !hadWitness && len(witness) > 0 {
+		return scriptFailed("witness for an input spending no witness program")
+	}
+	return nil
+}
+
+// verifyWitnessProgram runs the witness spending a witness program, nested in a P2SH output if p2sh is set: a public key hash checked the way
+// P2PKH outputs are, or a script whose SHA256 hash is the program, for segwit v0, and a taproot output key when it is not nested
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1895-L1972)
+func (e *engine) verifyWitnessProgram(witness [][]byte, version int, program []byte, p2sh bool) error {
+	if version == 1 && len(program) == 32 && !p2sh {
+		if e.flags&VerifyTaproot == 0 {
+			return nil
+		}
+		return e.verifyTaproot(witness, program)
+	}
+	if version != 0 {
+		return nil
+	}
+	var script []byte
+	switch len(program) {
+	case 32:
+		if len(witness) == 0 {
+			return scriptFailed("empty witness")
+		}
+		script, witness = witness[len(witness)-1], witness[:len(witness)-1]
+		if sha256.Sum256(script) != [32]byte(program) {
+			return scriptFailed("witness script does not match the program")
+		}
+	case 20:
+		if len(witness) != 2 {
+			return scriptFailed("P2WPKH witness without two items")
+		}
+		script = slices.Concat([]byte{OpDup, OpHash160, 20}, program, []byte{OpEqualVerify, OpCheckSig})
+	default:
+		return scriptFailed("witness program of the wrong length")
+	}
+	return e.executeWitnessScript(witness, script, sigVersionWitnessV0)
+}
+
+// executeWitnessScript runs script with the rules of version on the stack of the items of a witness, which it must leave with a single true
+// item (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/interpreter.cpp#L1832-L1872). Tapscripts having an OP_SUCCESSx succeed
+// without being run.
+func (e *engine) executeWitnessScript(witness [][]byte, script []byte, version sigVersion) error {
+	if version == sigVersionTapscript {
+		success, err := hasOpSuccess(script)
+		if err != nil || success {
+			return err
+		}
+		if len(witness) > maxStackSize {
+			return scriptFailed("stack too big")
+		}
+	}
+	for _, item := range witness {
+		if len(item) > maxElementSize {
+			return scriptFailed("witness item too long")
+		}
+	}
+	e.stack = slices.Clone(witness)
+	err := e.eval(script, version)
+	if err != nil {
+		return err
+	}
+	// the witness must leave exactly one true item
+	if len(e.stack) != 1 || !castToBool(e.stack.at(0)) {
+		return scriptFailed("witness script did not leave a single true item")
+	}
+	return nil
+}
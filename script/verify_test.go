@@ -0,0 +1,285 @@
+package script_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/internal/ripemd160"
+	"github.com/aang114/bitcoin-node/internal/secp256k1"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+var (
+	mainnet  = constants.MainnetParams.Deployments
+	allFlags = script.FlagsAt(mainnet, mainnet.TaprootHeight)
+)
+
+func decodeTx(t *testing.T, s string) *message.TxPayload {
+	tx, err := message.DecodeTxPayload(bytes.NewReader(decodeHex(t, s)))
+	require.NoError(t, err)
+	return tx
+}
+
+func verifyInput(tx *message.TxPayload, i int, prevOut message.TxOut, flags script.VerifyFlags) error {
+	return script.VerifyInput(tx, i, &prevOut, flags, script.NewTxSigHashes(tx, nil))
+}
+
+func TestVerifyInput_BIP143Example(t *testing.T) {
+	// the native P2WPKH example of BIP 143 (https://github.com/bitcoin/bips/blob/master/bip-0143.mediawiki#native-p2wpkh), whose first
+	// input spends a P2PK output and whose second input a P2WPKH one
+	tx := decodeTx(t, "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000")
+	p2pk := message.TxOut{Value: 625000000, PkScript: decodeHex(t, "2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")}
+	p2wpkh := message.TxOut{Value: 600000000, PkScript: decodeHex(t, "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")}
+
+	sigHash := script.WitnessSignatureHash(tx, 1, decodeHex(t, "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac"), script.SigHashAll,
+		p2wpkh.Value, script.NewTxSigHashes(tx, nil))
+	require.Equal(t, decodeHex(t, "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670"), sigHash[:])
+
+	require.NoError(t, verifyInput(tx, 0, p2pk, allFlags))
+	require.NoError(t, verifyInput(tx, 1, p2wpkh, allFlags))
+
+	// the signatures of both inputs commit to the outputs, and the one of the second input to the value it spends
+	require.ErrorIs(t, verifyInput(tx, 1, message.TxOut{Value: p2wpkh.Value + 1, PkScript: p2wpkh.PkScript}, allFlags), script.ErrScriptFailed)
+	tx.TransactionOutputs[0].Value--
+	require.ErrorIs(t, verifyInput(tx, 0, p2pk, allFlags), script.ErrScriptFailed)
+	require.ErrorIs(t, verifyInput(tx, 1, p2wpkh, allFlags), script.ErrScriptFailed)
+}
+
+// testKey is a private key and its compressed public key
+type testKey struct {
+	d      *big.Int
+	pubKey []byte
+}
+
+func newTestKey(seed int64) testKey {
+	d := big.NewInt(seed*1_000_003 + 12345)
+	return testKey{d: d, pubKey: secp256k1.PublicKeyOf(d).SerializeCompressed()}
+}
+
+// sign returns the SIGHASH_ALL signature of input i of tx by the key, committing to scriptCode and, for witness signatures, the value spent
+func (k testKey) sign(tx *message.TxPayload, i int, scriptCode []byte, witness bool, amount int64) []byte {
+	var hash [32]byte
+	if witness {
+		hash = script.WitnessSignatureHash(tx, i, scriptCode, script.SigHashAll, amount, script.NewTxSigHashes(tx, nil))
+	} else {
+		hash = script.SignatureHash(tx, i, scriptCode, script.SigHashAll)
+	}
+	// a nonce derived from the key and the hash, which tests do not need to keep secret
+	nonce := sha256.Sum256(append(k.d.Bytes(), hash[:]...))
+	r, s := secp256k1.Sign(k.d, new(big.Int).SetBytes(nonce[:]), hash[:])
+	return append(encodeDER(r, s), script.SigHashAll)
+}
+
+func encodeDER(r, s *big.Int) []byte {
+	encodeInteger := func(v *big.Int) []byte {
+		b := v.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+	body := append(encodeInteger(r), encodeInteger(s)...)
+	return append([]byte{0x30, byte(len(body))}, body...)
+}
+
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	sum := ripemd160.Sum(sha[:])
+	return sum[:]
+}
+
+// push returns the operations pushing items
+func push(items ...[]byte) []byte {
+	var pushes []byte
+	for _, item := range items {
+		if len(item) < script.OpPushData1 {
+			pushes = append(pushes, byte(len(item)))
+		} else {
+			pushes = append(pushes, script.OpPushData1, byte(len(item)))
+		}
+		pushes = append(pushes, item...)
+	}
+	return pushes
+}
+
+// newSpendingTx returns a transaction of version 2 spending an output with its single input
+func newSpendingTx() *message.TxPayload {
+	return &message.TxPayload{
+		Version:            2,
+		TransactionInputs:  []message.TxIn{{PreviousOutput: message.OutPoint{Hash: message.Hash256{1}, Index: 1}, Sequence: 0xfffffffe}},
+		TransactionOutputs: []message.TxOut{{Value: 1000, PkScript: []byte{script.Op1}}},
+	}
+}
+
+func TestVerifyInput_PayToPubKeyHash(t *testing.T) {
+	key, other := newTestKey(1), newTestKey(2)
+	pkScript := slices.Concat([]byte{script.OpDup, script.OpHash160, 20}, hash160(key.pubKey), []byte{script.OpEqualVerify, script.OpCheckSig})
+	prevOut := message.TxOut{Value: 2000, PkScript: pkScript}
+
+	tx := newSpendingTx()
+	tx.TransactionInputs[0].SignatureScript = push(key.sign(tx, 0, pkScript, false, 0), key.pubKey)
+	require.NoError(t, verifyInput(tx, 0, prevOut, allFlags))
+	require.NoError(t, verifyInput(tx, 0, prevOut, 0))
+
+	t.Run("a signature by another key should fail", func(t *testing.T) {
+		tx := newSpendingTx()
+		tx.TransactionInputs[0].SignatureScript = push(other.sign(tx, 0, pkScript, false, 0), key.pubKey)
+		require.ErrorIs(t, verifyInput(tx, 0, prevOut, allFlags), script.ErrScriptFailed)
+	})
+
+	t.Run("a witness should fail once segwit is enforced", func(t *testing.T) {
+		tx := newSpendingTx()
+		tx.TransactionInputs[0].SignatureScript = push(key.sign(tx, 0, pkScript, false, 0), key.pubKey)
+		tx.TransactionWitnesses = []message.TxWitness{{ComponentDataList: []message.ComponentData{{1}}}}
+		require.ErrorIs(t, verifyInput(tx, 0, prevOut, allFlags), script.ErrScriptFailed)
+		require.NoError(t, verifyInput(tx, 0, prevOut, script.FlagsAt(mainnet, mainnet.SegwitHeight-1)))
+	})
+}
+
+func TestVerifyInput_MultiSig(t *testing.T) {
+	keys := []testKey{newTestKey(1), newTestKey(2), newTestKey(3)}
+	// 2 of 3
+	multiSig := slices.Concat([]byte{script.Op1 + 1}, push(keys[0].pubKey, keys[1].pubKey, keys[2].pubKey),
+		[]byte{script.Op1 + 2, script.OpCheckMultiSig})
+
+	t.Run("P2SH", func(t *testing.T) {
+		prevOut := message.TxOut{Value: 2000, PkScript: slices.Concat([]byte{script.OpHash160, 20}, hash160(multiSig), []byte{script.OpEqual})}
+		spend := func(dummy byte, signers ...testKey) *message.TxPayload {
+			tx := newSpendingTx()
+			scriptSig := []byte{dummy}
+			for _, signer := range signers {
+				scriptSig = append(scriptSig, push(signer.sign(tx, 0, multiSig, false, 0))...)
+			}
+			tx.TransactionInputs[0].SignatureScript = append(scriptSig, push(multiSig)...)
+			return tx
+		}
+
+		require.NoError(t, verifyInput(spend(script.Op0, keys[0], keys[2]), 0, prevOut, allFlags))
+		// the signatures must be in the order of the keys
+		require.ErrorIs(t, verifyInput(spend(script.Op0, keys[2], keys[0]), 0, prevOut, allFlags), script.ErrScriptFailed)
+		require.ErrorIs(t, verifyInput(spend(script.Op0, keys[0]), 0, prevOut, allFlags), script.ErrScriptFailed)
+		// the dummy must be empty once BIP 147 is enforced
+		require.ErrorIs(t, verifyInput(spend(script.Op1, keys[0], keys[2]), 0, prevOut, allFlags), script.ErrScriptFailed)
+		require.NoError(t, verifyInput(spend(script.Op1, keys[0], keys[2]), 0, prevOut, script.FlagsAt(mainnet, mainnet.SegwitHeight-1)))
+		// only the hash of the redeem script is checked before BIP 16
+		require.NoError(t, verifyInput(spend(script.Op0), 0, prevOut, script.FlagsAt(mainnet, mainnet.BIP16Height-1)))
+		require.ErrorIs(t, verifyInput(spend(script.Op0), 0, prevOut, script.FlagsAt(mainnet, mainnet.BIP16Height)), script.ErrScriptFailed)
+	})
+
+	t.Run("P2SH-P2WSH", func(t *testing.T) {
+		program := sha256.Sum256(multiSig)
+		redeemScript := slices.Concat([]byte{script.Op0, 32}, program[:])
+		prevOut := message.TxOut{Value: 2000, PkScript: slices.Concat([]byte{script.OpHash160, 20}, hash160(redeemScript), []byte{script.OpEqual})}
+		spend := func(amount int64, signers ...testKey) *message.TxPayload {
+			tx := newSpendingTx()
+			tx.TransactionInputs[0].SignatureScript = push(redeemScript)
+			witness := []message.ComponentData{{}}
+			for _, signer := range signers {
+				witness = append(witness, signer.sign(tx, 0, multiSig, true, amount))
+			}
+			tx.TransactionWitnesses = []message.TxWitness{{ComponentDataList: append(witness, multiSig)}}
+			return tx
+		}
+
+		require.NoError(t, verifyInput(spend(prevOut.Value, keys[1], keys[2]), 0, prevOut, allFlags))
+		// the signatures commit to the value spent
+		require.ErrorIs(t, verifyInput(spend(prevOut.Value-1, keys[1], keys[2]), 0, prevOut, allFlags), script.ErrScriptFailed)
+		require.ErrorIs(t, verifyInput(spend(prevOut.Value, keys[1], keys[1]), 0, prevOut, allFlags), script.ErrScriptFailed)
+
+		// the signature script must push the redeem script only
+		tx := spend(prevOut.Value, keys[1], keys[2])
+		tx.TransactionInputs[0].SignatureScript = append([]byte{script.Op0}, tx.TransactionInputs[0].SignatureScript...)
+		require.ErrorIs(t, verifyInput(tx, 0, prevOut, allFlags), script.ErrScriptFailed)
+
+		// the witness is ignored before segwit
+		tx = spend(prevOut.Value)
+		tx.TransactionWitnesses = nil
+		require.ErrorIs(t, verifyInput(tx, 0, prevOut, allFlags), script.ErrScriptFailed)
+		require.NoError(t, verifyInput(tx, 0, prevOut, script.FlagsAt(mainnet, mainnet.SegwitHeight-1)))
+	})
+}
+
+func TestVerifyInput_Opcodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		scriptSig string
+		pkScript  string
+		valid     bool
+	}{
+		{"arithmetic", "5253", "935587", true},
+		{"numbers of more than 4 bytes", "050000000001", "8b51", false},
+		{"if else", "00", "6300675168", true},
+		{"unbalanced if", "51", "6351", false},
+		{"disabled opcode in a branch that is not executed", "00", "637e6851", false},
+		{"reserved opcode in a branch that is not executed", "", "0063506851", true},
+		{"OP_VERIF in a branch that is not executed", "", "0063656851", false},
+		{"OP_RETURN", "51", "6a", false},
+		{"negative zero is false", "0180", "6951", false},
+		{"rot", "515253", "7b518853885287", true},
+		{"roll", "51525352", "7a518853885287", true},
+		{"pick past the bottom of the stack", "5152", "5279", false},
+		{"sha256", "03616263", "a820ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad87", true},
+		{"ripemd160", "03616263", "a6148eb208f7e05d987a9b044a8e98c6b087f15a0bfc87", true},
+		{"alt stack", "5152", "6b756c5287", true},
+		{"empty output script", "51", "", true},
+		{"nothing left on the stack", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := newSpendingTx()
+			tx.TransactionInputs[0].SignatureScript = decodeHex(t, test.scriptSig)
+			err := verifyInput(tx, 0, message.TxOut{PkScript: decodeHex(t, test.pkScript)}, allFlags)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, script.ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestVerifyInput_LockTimes(t *testing.T) {
+	tx := newSpendingTx()
+	tx.LockTime = 500
+	tx.TransactionInputs[0].Sequence = 10
+	tests := []struct {
+		name     string
+		pkScript string
+		flags    script.VerifyFlags
+		valid    bool
+	}{
+		{"lock time reached", "02f401b1", allFlags, true},
+		{"lock time not reached", "02f501b1", allFlags, false},
+		{"lock time before BIP 65", "02f501b1", script.FlagsAt(mainnet, mainnet.BIP65Height-1), true},
+		{"sequence reached", "5ab2", allFlags, true},
+		{"sequence not reached", "5bb2", allFlags, false},
+		{"sequence before CSV", "5bb2", script.FlagsAt(mainnet, mainnet.CSVHeight-1), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyInput(tx, 0, message.TxOut{PkScript: decodeHex(t, test.pkScript)}, test.flags)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, script.ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestFlagsAt(t *testing.T) {
+	// segwit activated later on testnet than on mainnet, and regtest enforces every soft fork from its first blocks
+	testnet := constants.TestnetParams.Deployments
+	require.Equal(t, allFlags, script.FlagsAt(testnet, testnet.SegwitHeight))
+	require.Zero(t, script.FlagsAt(testnet, mainnet.SegwitHeight)&(script.VerifyWitness|script.VerifyNullDummy))
+	require.Equal(t, allFlags, script.FlagsAt(constants.RegtestParams.Deployments, 1))
+	require.Equal(t, script.VerifyP2SH|script.VerifyWitness|script.VerifyNullDummy|script.VerifyTaproot,
+		script.FlagsAt(constants.RegtestParams.Deployments, 0))
+	require.Zero(t, script.FlagsAt(mainnet, 0))
+	require.Zero(t, script.FlagsAt(mainnet, mainnet.TaprootHeight-1)&script.VerifyTaproot)
+}
@@ -0,0 +1,23 @@
+package storage
+
+// WriteFileAtomic replaces the contents of the named file with data, writing them to a temporary file first so that a crash leaves either the
+// old or the new contents
+func WriteFileAtomic(fsys FS, name string, data []byte) error {
+	tmpName := name + ".tmp"
+	f, err := Create(fsys, tmpName)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return fsys.Rename(tmpName, name)
+}
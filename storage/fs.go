@@ -0,0 +1,221 @@
+// Package storage holds the stores the node persists its data with: the file systems they are written to (OSFS, or MemFS to keep
+// everything in memory), the key-value store KV, write-ahead logs and atomically replaced files.
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// File is the subset of *os.File used by the storage backends
+type File interface {
+	io.ReadWriteSeeker
+	io.ReaderAt
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// FS is the file system the storage backends persist their files to
+type FS interface {
+	// OpenFile opens the named file with the given os.O_* flags (only os.O_CREATE, os.O_EXCL and os.O_TRUNC are interpreted by all implementations)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	// MkdirAll creates the named directory along with any missing parents
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// Open opens the named file for reading, returning an error wrapping fs.ErrNotExist if it does not exist
+func Open(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Size returns the size of the named file in bytes
+func Size(fsys FS, name string) (int64, error) {
+	f, err := Open(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Seek(0, io.SeekEnd)
+}
+
+// Create creates or truncates the named file
+func Create(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+}
+
+// OSFS is the operating system's file system
+type OSFS struct{}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// MemFS is an in-memory file system, for running the node without writing anything to disk (e.g. in tests or short-lived analysis runs).
+// Directories are not modelled: any name can be used as a file.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFileData)}
+}
+
+type memFileData struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (m *MemFS) OpenFile(name string, flag int, _ fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		data = &memFileData{}
+		m.files[name] = data
+	}
+	if flag&os.O_TRUNC != 0 {
+		data.mu.Lock()
+		data.data = nil
+		data.mu.Unlock()
+	}
+	return &memFile{file: data}, nil
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.files[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldName)
+	m.files[newName] = data
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// MkdirAll does nothing, as directories are not modelled
+func (m *MemFS) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+// memFile is an open handle to a file of a MemFS, with its own offset
+type memFile struct {
+	file   *memFileData
+	offset int64
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+
+	if f.offset >= int64(len(f.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.file.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, offset int64) (int, error) {
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "readat", Err: fs.ErrInvalid}
+	}
+	if offset >= int64(len(f.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.file.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.file.data)) {
+		f.file.data = append(f.file.data, make([]byte, end-int64(len(f.file.data)))...)
+	}
+	copy(f.file.data[f.offset:], p)
+	f.offset = end
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.file.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+
+	if size < int64(len(f.file.data)) {
+		f.file.data = f.file.data[:size]
+	} else {
+		f.file.data = append(f.file.data, make([]byte, size-int64(len(f.file.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	t.Run("opening a missing file without O_CREATE should fail", func(t *testing.T) {
+		_, err := storage.Open(storage.NewMemFS(), "missing")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("written data should be visible to later handles and survive a rename", func(t *testing.T) {
+		fsys := storage.NewMemFS()
+		f, err := storage.Create(fsys, "tmp")
+		require.NoError(t, err)
+		_, err = f.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, fsys.Rename("tmp", "final"))
+
+		_, err = storage.Open(fsys, "tmp")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		f, err = storage.Open(fsys, "final")
+		require.NoError(t, err)
+		contents, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), contents)
+	})
+
+	t.Run("O_TRUNC and Truncate should shrink the file", func(t *testing.T) {
+		fsys := storage.NewMemFS()
+		f, err := storage.Create(fsys, "file")
+		require.NoError(t, err)
+		_, err = f.Write([]byte("abcdef"))
+		require.NoError(t, err)
+		require.NoError(t, f.Truncate(3))
+		size, err := f.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, size)
+
+		f, err = fsys.OpenFile("file", os.O_RDWR|os.O_TRUNC, 0)
+		require.NoError(t, err)
+		size, err = f.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, size)
+	})
+}
@@ -0,0 +1,494 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+)
+
+// Size of the log below which it is not compacted, however much of it is overwritten or deleted
+const minKVCompactionSize = 64 * 1024 * 1024
+
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrKVClosed       = errors.New("key-value store is closed")
+	errInvalidKVEntry = errors.New("invalid key-value store entry")
+)
+
+// Operations of the entries of a key-value store batch. Every entry starts with its operation byte, followed by the length of the key as a
+// little-endian uint32, the key and, for puts, the value.
+const (
+	kvOpPut    byte = 1
+	kvOpDelete byte = 2
+)
+
+// KV is a key-value store keeping its keys in memory, sorted, and its values in a log file, so that its memory usage does not grow with the size
+// of the values. It does grow with the number of keys, each taking 40 bytes plus its length and the room left in the chunks of the index: about
+// 100 bytes for the 37-byte keys of a utxo.DB. The store suits up to tens of millions of keys, like the blocks and filters of the chain, but
+// the 180 million unspent outputs of mainnet would take about 18 GB of memory, and a transaction index of mainnet far more.
+//
+// Every Write appends its batch of puts and deletes to the log as a single record of the WAL format, fsync'd before Write returns, so a batch
+// is either entirely applied or not at all after a crash. Values are read at their position in the log, so reads do not wait for each other.
+// The log is compacted once most of it is made of overwritten or deleted values. When the store is closed, its keys and the positions of their
+// values are written to a hint file next to the log, so that opening the store again only reads the hint file and the records appended to the
+// log after it rather than the whole log.
+type KV struct {
+	// serializes writes and compactions
+	writeMu sync.Mutex
+	// guards the fields below: reads hold it for reading and writes only hold it to update the index
+	mu   sync.RWMutex
+	fsys FS
+	path string
+	f    File
+	// where the value of each key is in the log
+	index *kvIndex
+	size  int64
+	// bytes of the log taken by overwritten or deleted entries
+	garbage int64
+}
+
+type kvValue struct {
+	offset int64
+	length int
+	// length of the whole entry, which becomes garbage when the key is overwritten or deleted
+	entryLength int
+}
+
+// KVBatch is a list of puts and deletes applied at once by KV.Write
+type KVBatch struct {
+	entries [][]byte
+}
+
+// Put sets key to value
+func (b *KVBatch) Put(key []byte, value []byte) {
+	b.entries = append(b.entries, encodeKVEntry(kvOpPut, key, value))
+}
+
+// Delete removes key
+func (b *KVBatch) Delete(key []byte) {
+	b.entries = append(b.entries, encodeKVEntry(kvOpDelete, key, nil))
+}
+
+// Len returns the number of puts and deletes in the batch
+func (b *KVBatch) Len() int {
+	return len(b.entries)
+}
+
+func encodeKVEntry(op byte, key []byte, value []byte) []byte {
+	entry := make([]byte, 5, 5+len(key)+len(value))
+	entry[0] = op
+	binary.LittleEndian.PutUint32(entry[1:5], uint32(len(key)))
+	entry = append(entry, key...)
+	return append(entry, value...)
+}
+
+// decodeKVEntry returns the operation, key and offset of the value of entry
+func decodeKVEntry(entry []byte) (byte, string, int, error) {
+	if len(entry) < 5 {
+		return 0, "", 0, errInvalidKVEntry
+	}
+	keyLength := int(binary.LittleEndian.Uint32(entry[1:5]))
+	if keyLength > len(entry)-5 {
+		return 0, "", 0, errInvalidKVEntry
+	}
+	op := entry[0]
+	if op != kvOpPut && op != kvOpDelete {
+		return 0, "", 0, fmt.Errorf("%w: unknown operation %d", errInvalidKVEntry, op)
+	}
+	return op, string(entry[5 : 5+keyLength]), 5 + keyLength, nil
+}
+
+// OpenKV opens (or creates) the key-value store whose log is at path in fsys, truncating any torn record at its end
+func OpenKV(fsys FS, path string) (*KV, error) {
+	kv := &KV{fsys: fsys, path: path}
+	err := kv.open()
+	if err != nil {
+		return nil, err
+	}
+	if kv.needsCompaction() {
+		err = kv.Compact()
+		if err != nil {
+			_ = kv.Close()
+			return nil, err
+		}
+	}
+	return kv, nil
+}
+
+// hintPath returns the path of the hint file of the store
+func (kv *KV) hintPath() string {
+	return kv.path + ".hint"
+}
+
+func (kv *KV) open() error {
+	f, err := kv.fsys.OpenFile(kv.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	kv.f = f
+	hinted, err := kv.loadHint()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	validLength, err := scanWAL(f, hinted, func(offset int64, batch [][]byte) error {
+		for i, entryOffset := range walEntryOffsets(batch) {
+			err := kv.apply(batch[i], offset+entryOffset)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if size != validLength {
+		log.Printf("⚠️ Truncating %d bytes of incomplete records at the end of %s", size-validLength, kv.path)
+		err = f.Truncate(validLength)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		_, err = f.Seek(validLength, io.SeekStart)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	kv.size = validLength
+	return nil
+}
+
+// loadHint loads the index from the hint file written when the store was last closed, returning the length of the log the hint file
+// describes, from which the log is still to be read. Without a hint file matching the log, the index is empty and the whole log is to be
+// read. The hint file is removed, so that it is not mistaken for the index of the log once more records are appended to it.
+func (kv *KV) loadHint() (int64, error) {
+	kv.index, kv.garbage = &kvIndex{}, 0
+	f, err := Open(kv.fsys, kv.hintPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	hint, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return 0, err
+	}
+	err = kv.fsys.Remove(kv.hintPath())
+	if err != nil {
+		return 0, err
+	}
+
+	index, logSize, garbage, err := decodeKVHint(hint)
+	if err != nil {
+		log.Printf("⚠️ Ignoring the hint file of %s: %s", kv.path, err)
+		return 0, nil
+	}
+	size, err := kv.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if logSize > size {
+		log.Printf("⚠️ Ignoring the hint file of %s, which describes %d bytes of a %d-byte log", kv.path, logSize, size)
+		return 0, nil
+	}
+	kv.index, kv.garbage = index, garbage
+	return logSize, nil
+}
+
+// apply updates the index with entry, which is at offset in the log
+func (kv *KV) apply(entry []byte, offset int64) error {
+	op, key, valueOffset, err := decodeKVEntry(entry)
+	if err != nil {
+		return err
+	}
+	var previous kvValue
+	var ok bool
+	switch op {
+	case kvOpPut:
+		previous, ok = kv.index.set(key, kvValue{offset: offset + int64(valueOffset), length: len(entry) - valueOffset, entryLength: len(entry)})
+	case kvOpDelete:
+		previous, ok = kv.index.remove(key)
+		kv.garbage += int64(len(entry))
+	}
+	if ok {
+		kv.garbage += int64(previous.entryLength)
+	}
+	return nil
+}
+
+// needsCompaction reports whether the log is big enough and mostly made of overwritten or deleted entries
+func (kv *KV) needsCompaction() bool {
+	return kv.size >= minKVCompactionSize && kv.garbage*2 > kv.size
+}
+
+// readValue reads the value at location in the log
+func (kv *KV) readValue(location kvValue) ([]byte, error) {
+	value := make([]byte, location.length)
+	n, err := kv.f.ReadAt(value, location.offset)
+	if n == len(value) {
+		// the value may end the log, which ReadAt may report with io.EOF
+		return value, nil
+	}
+	return nil, err
+}
+
+// Get returns the value of key, or ErrKeyNotFound
+func (kv *KV) Get(key []byte) ([]byte, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.f == nil {
+		return nil, ErrKVClosed
+	}
+	location, ok := kv.index.get(string(key))
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrKeyNotFound, key)
+	}
+	return kv.readValue(location)
+}
+
+// Has reports whether key has a value
+func (kv *KV) Has(key []byte) bool {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	_, ok := kv.index.get(string(key))
+	return ok
+}
+
+// Keys returns the keys starting with prefix, sorted
+func (kv *KV) Keys(prefix []byte) [][]byte {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	keys := make([][]byte, 0)
+	kv.index.ascend(string(prefix), func(key string, _ kvValue) bool {
+		keys = append(keys, []byte(key))
+		return true
+	})
+	return keys
+}
+
+// Iterate calls fn with every key starting with prefix and its value, in the order of the keys, until fn returns an error, which Iterate
+// returns. Writes wait for the iteration to end, so fn must not write to the store.
+func (kv *KV) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.f == nil {
+		return ErrKVClosed
+	}
+	var err error
+	kv.index.ascend(string(prefix), func(key string, location kvValue) bool {
+		var value []byte
+		value, err = kv.readValue(location)
+		if err == nil {
+			err = fn([]byte(key), value)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// Size returns the size of the log in bytes
+func (kv *KV) Size() int64 {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return kv.size
+}
+
+// Len returns the number of keys
+func (kv *KV) Len() int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return kv.index.len
+}
+
+// Write durably applies the puts and deletes of batch, in order. The log is compacted afterwards if most of it is made of overwritten or
+// deleted values. If the batch cannot be written, the log is truncated back to its previous size and the store is left unchanged.
+func (kv *KV) Write(batch *KVBatch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	// the entries are checked before the record is written, so that applying them to the index cannot fail once it is
+	for _, entry := range batch.entries {
+		_, _, _, err := decodeKVEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+	record, err := encodeWALRecord(batch.entries)
+	if err != nil {
+		return err
+	}
+
+	kv.writeMu.Lock()
+	defer kv.writeMu.Unlock()
+
+	// only writes change the log file, so it can be appended to without holding mu
+	kv.mu.RLock()
+	f, size := kv.f, kv.size
+	kv.mu.RUnlock()
+	if f == nil {
+		return ErrKVClosed
+	}
+	_, err = f.Write(record)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, kv.rollback(f, size))
+	}
+
+	kv.mu.Lock()
+	for i, entryOffset := range walEntryOffsets(batch.entries) {
+		_ = kv.apply(batch.entries[i], size+entryOffset)
+	}
+	kv.size += int64(len(record))
+	compact := kv.needsCompaction()
+	kv.mu.Unlock()
+
+	if compact {
+		// the batch is written either way, and the log is compacted after the next write otherwise
+		err = kv.compact()
+		if err != nil {
+			log.Printf("⚠️ Could not compact %s: %s", kv.path, err)
+		}
+	}
+	return nil
+}
+
+// rollback truncates the log f back to size after a failed write, so that the next records are not appended after a torn one, at which opening
+// the store would stop reading the log. A log that cannot be truncated is closed, and the store with it, holding writeMu.
+func (kv *KV) rollback(f File, size int64) error {
+	err := f.Truncate(size)
+	if err == nil {
+		// the truncation is made durable by the sync of the next write
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err == nil {
+		return nil
+	}
+	log.Printf("⚠️ Closing %s as a failed write could not be rolled back: %s", kv.path, err)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.f = nil
+	return errors.Join(err, f.Close())
+}
+
+// Compact rewrites the log with only the current value of each key, replacing the log once the rewritten one is durably written
+func (kv *KV) Compact() error {
+	kv.writeMu.Lock()
+	defer kv.writeMu.Unlock()
+	return kv.compact()
+}
+
+// compact compacts the log while reads go on, holding writeMu
+func (kv *KV) compact() error {
+	compactPath := kv.path + ".compact"
+	err := kv.writeCompacted(compactPath)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	err = kv.f.Close()
+	if err != nil {
+		return err
+	}
+	kv.f = nil
+	err = kv.fsys.Rename(compactPath, kv.path)
+	if err != nil {
+		return err
+	}
+	previousSize := kv.size
+	err = kv.open()
+	if err != nil {
+		return err
+	}
+	log.Printf("💾 Compacted %s from %d to %d bytes", kv.path, previousSize, kv.size)
+	return nil
+}
+
+// writeCompacted durably writes the current value of each key, in the order of the keys, to a log at compactPath
+func (kv *KV) writeCompacted(compactPath string) error {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	if kv.f == nil {
+		return ErrKVClosed
+	}
+	compacted, err := Create(kv.fsys, compactPath)
+	if err != nil {
+		return err
+	}
+	batch := &KVBatch{}
+	batchSize := 0
+	flush := func() error {
+		record, err := encodeWALRecord(batch.entries)
+		if err != nil {
+			return err
+		}
+		_, err = compacted.Write(record)
+		batch, batchSize = &KVBatch{}, 0
+		return err
+	}
+	kv.index.ascend("", func(key string, location kvValue) bool {
+		var value []byte
+		value, err = kv.readValue(location)
+		if err == nil && batchSize+location.entryLength > maxWALRecordSize/2 {
+			err = flush()
+		}
+		if err != nil {
+			return false
+		}
+		batch.Put([]byte(key), value)
+		batchSize += location.entryLength
+		return true
+	})
+	if err == nil && batch.Len() > 0 {
+		err = flush()
+	}
+	if err == nil {
+		err = compacted.Sync()
+	}
+	closeErr := compacted.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Close closes the log, after writing the hint file that spares reading the whole log when the store is opened again
+func (kv *KV) Close() error {
+	kv.writeMu.Lock()
+	defer kv.writeMu.Unlock()
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.f == nil {
+		return nil
+	}
+	err := WriteFileAtomic(kv.fsys, kv.hintPath(), encodeKVHint(kv.index, kv.size, kv.garbage))
+	if err != nil {
+		// the whole log is read when the store is opened again
+		log.Printf("⚠️ Could not write the hint file of %s: %s", kv.path, err)
+	}
+	err = kv.f.Close()
+	kv.f = nil
+	return err
+}
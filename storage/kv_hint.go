@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var errInvalidKVHint = errors.New("invalid key-value store hint file")
+
+// Size of the fields starting a hint file: the size of the log it describes, the garbage in the log and the number of keys
+const kvHintHeaderSize = 24
+
+// encodeKVHint encodes the hint file of a log of logSize bytes, garbage of which are overwritten or deleted entries, whose keys are in index:
+// the size of the log, the garbage and the number of keys, then every key preceded by its length and followed by where its value is, and
+// finally the CRC32C checksum of everything before it
+func encodeKVHint(index *kvIndex, logSize int64, garbage int64) []byte {
+	hint := new(bytes.Buffer)
+	var field [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(field[:], v)
+		hint.Write(field[:])
+	}
+	writeUint32 := func(v uint32) {
+		binary.LittleEndian.PutUint32(field[:4], v)
+		hint.Write(field[:4])
+	}
+	writeUint64(uint64(logSize))
+	writeUint64(uint64(garbage))
+	writeUint64(uint64(index.len))
+	index.ascend("", func(key string, value kvValue) bool {
+		writeUint32(uint32(len(key)))
+		hint.WriteString(key)
+		writeUint64(uint64(value.offset))
+		writeUint32(uint32(value.length))
+		writeUint32(uint32(value.entryLength))
+		return true
+	})
+	writeUint32(crc32.Checksum(hint.Bytes(), castagnoli))
+	return hint.Bytes()
+}
+
+// decodeKVHint decodes a hint file, returning the index it holds, the size of the log it describes and the garbage in the log
+func decodeKVHint(hint []byte) (*kvIndex, int64, int64, error) {
+	if len(hint) < kvHintHeaderSize+4 {
+		return nil, 0, 0, errInvalidKVHint
+	}
+	body := hint[:len(hint)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(hint[len(body):]) {
+		return nil, 0, 0, errInvalidKVHint
+	}
+	logSize := int64(binary.LittleEndian.Uint64(body[0:8]))
+	garbage := int64(binary.LittleEndian.Uint64(body[8:16]))
+	count := binary.LittleEndian.Uint64(body[16:24])
+	body = body[kvHintHeaderSize:]
+
+	index := &kvIndex{}
+	for range count {
+		if len(body) < 4 {
+			return nil, 0, 0, errInvalidKVHint
+		}
+		keyLength := int(binary.LittleEndian.Uint32(body[0:4]))
+		if len(body) < 4+keyLength+16 {
+			return nil, 0, 0, errInvalidKVHint
+		}
+		key := string(body[4 : 4+keyLength])
+		location := body[4+keyLength:]
+		index.set(key, kvValue{
+			offset:      int64(binary.LittleEndian.Uint64(location[0:8])),
+			length:      int(binary.LittleEndian.Uint32(location[8:12])),
+			entryLength: int(binary.LittleEndian.Uint32(location[12:16])),
+		})
+		body = location[16:]
+	}
+	if len(body) != 0 {
+		return nil, 0, 0, errInvalidKVHint
+	}
+	return index, logSize, garbage, nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Maximum number of keys of a chunk of the index, above which the chunk is split in two
+const kvIndexChunkSize = 512
+
+// kvIndex maps the keys of a KV to where their values are in the log, keeping the keys sorted so that they can be iterated in order. The keys
+// are split into chunks of consecutive keys, so that adding or removing a key only moves the keys of its chunk.
+type kvIndex struct {
+	chunks [][]kvIndexEntry
+	len    int
+}
+
+type kvIndexEntry struct {
+	key   string
+	value kvValue
+}
+
+// chunk returns the position of the chunk key belongs in: the last chunk whose first key is not after key, or the first chunk
+func (x *kvIndex) chunk(key string) int {
+	i := sort.Search(len(x.chunks), func(i int) bool { return x.chunks[i][0].key > key })
+	return max(i-1, 0)
+}
+
+// searchChunk returns the position of key in chunk, or where it would be inserted, and whether it is there
+func searchChunk(chunk []kvIndexEntry, key string) (int, bool) {
+	return slices.BinarySearchFunc(chunk, key, func(entry kvIndexEntry, key string) int { return strings.Compare(entry.key, key) })
+}
+
+func (x *kvIndex) get(key string) (kvValue, bool) {
+	if len(x.chunks) == 0 {
+		return kvValue{}, false
+	}
+	chunk := x.chunks[x.chunk(key)]
+	i, ok := searchChunk(chunk, key)
+	if !ok {
+		return kvValue{}, false
+	}
+	return chunk[i].value, true
+}
+
+// set makes key point to value, returning where the previous value of key was if it had one
+func (x *kvIndex) set(key string, value kvValue) (kvValue, bool) {
+	if len(x.chunks) == 0 {
+		x.chunks = [][]kvIndexEntry{{{key: key, value: value}}}
+		x.len = 1
+		return kvValue{}, false
+	}
+	c := x.chunk(key)
+	i, ok := searchChunk(x.chunks[c], key)
+	if ok {
+		previous := x.chunks[c][i].value
+		x.chunks[c][i].value = value
+		return previous, true
+	}
+	chunk := slices.Insert(x.chunks[c], i, kvIndexEntry{key: key, value: value})
+	if len(chunk) > kvIndexChunkSize {
+		half := len(chunk) / 2
+		// the second half gets its own array, which adding keys to the first half would overwrite otherwise
+		x.chunks = slices.Insert(x.chunks, c+1, slices.Clone(chunk[half:]))
+		chunk = chunk[:half]
+	}
+	x.chunks[c] = chunk
+	x.len++
+	return kvValue{}, false
+}
+
+// remove removes key, returning where its value was if it had one
+func (x *kvIndex) remove(key string) (kvValue, bool) {
+	if len(x.chunks) == 0 {
+		return kvValue{}, false
+	}
+	c := x.chunk(key)
+	i, ok := searchChunk(x.chunks[c], key)
+	if !ok {
+		return kvValue{}, false
+	}
+	previous := x.chunks[c][i].value
+	x.chunks[c] = slices.Delete(x.chunks[c], i, i+1)
+	if len(x.chunks[c]) == 0 {
+		x.chunks = slices.Delete(x.chunks, c, c+1)
+	}
+	x.len--
+	return previous, true
+}
+
+// ascend calls fn with every key starting with prefix and where its value is, in the order of the keys, until fn returns false
+func (x *kvIndex) ascend(prefix string, fn func(key string, value kvValue) bool) {
+	if len(x.chunks) == 0 {
+		return
+	}
+	for c := x.chunk(prefix); c < len(x.chunks); c++ {
+		chunk := x.chunks[c]
+		i, _ := searchChunk(chunk, prefix)
+		for _, entry := range chunk[i:] {
+			if !strings.HasPrefix(entry.key, prefix) || !fn(entry.key, entry.value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeKV writes the puts of values, keyed by their keys, to kv in a single batch
+func writeKV(t *testing.T, kv *storage.KV, values map[string]string) {
+	batch := &storage.KVBatch{}
+	for key, value := range values {
+		batch.Put([]byte(key), []byte(value))
+	}
+	require.NoError(t, kv.Write(batch))
+}
+
+// requireKV checks that kv holds values and nothing else
+func requireKV(t *testing.T, kv *storage.KV, values map[string]string) {
+	require.Equal(t, len(values), kv.Len())
+	for key, value := range values {
+		stored, err := kv.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, value, string(stored))
+	}
+}
+
+// faultyFS is a file system whose files fail the writes and syncs they are told to, after writing half of what is written
+type faultyFS struct {
+	storage.FS
+	files map[string]*faultyFile
+}
+
+type faultyFile struct {
+	storage.File
+	failWrite, failSync, failTruncate bool
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *faultyFS) OpenFile(name string, flag int, perm fs.FileMode) (storage.File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	f.files[name] = &faultyFile{File: file}
+	return f.files[name], nil
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.failWrite {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errInjected
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failSync {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errInjected
+	}
+	return f.File.Truncate(size)
+}
+
+func TestKV(t *testing.T) {
+	t.Run("written values should be read back after reopening", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		kv, err := storage.OpenKV(fs, "test.kv")
+		require.NoError(t, err)
+		batch := &storage.KVBatch{}
+		batch.Put([]byte("b1"), []byte("first"))
+		batch.Put([]byte("b2"), []byte("second"))
+		batch.Put([]byte("h1"), []byte("header"))
+		require.NoError(t, kv.Write(batch))
+		batch = &storage.KVBatch{}
+		batch.Put([]byte("b1"), []byte("overwritten"))
+		batch.Delete([]byte("b2"))
+		require.NoError(t, kv.Write(batch))
+		require.NoError(t, kv.Close())
+
+		kv, err = storage.OpenKV(fs, "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		value, err := kv.Get([]byte("b1"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("overwritten"), value)
+		_, err = kv.Get([]byte("b2"))
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.Equal(t, [][]byte{[]byte("b1")}, kv.Keys([]byte("b")))
+		require.Equal(t, 2, kv.Len())
+	})
+
+	t.Run("a torn batch at the end should be discarded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.kv")
+		kv, err := storage.OpenKV(storage.OSFS{}, path)
+		require.NoError(t, err)
+		batch := &storage.KVBatch{}
+		batch.Put([]byte("complete"), []byte("1"))
+		require.NoError(t, kv.Write(batch))
+		batch = &storage.KVBatch{}
+		batch.Put([]byte("torn"), []byte("2"))
+		batch.Put([]byte("complete"), []byte("2"))
+		require.NoError(t, kv.Write(batch))
+		require.NoError(t, kv.Close())
+
+		// simulate a crash in the middle of writing the last batch
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(path, info.Size()-2))
+
+		kv, err = storage.OpenKV(storage.OSFS{}, path)
+		require.NoError(t, err)
+		defer kv.Close()
+		require.False(t, kv.Has([]byte("torn")))
+		value, err := kv.Get([]byte("complete"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("1"), value)
+	})
+
+	t.Run("a batch that failed to be written or synced should be rolled back", func(t *testing.T) {
+		memFS := storage.NewMemFS()
+		fsys := &faultyFS{FS: memFS, files: make(map[string]*faultyFile)}
+		kv, err := storage.OpenKV(fsys, "test.kv")
+		require.NoError(t, err)
+		writeKV(t, kv, map[string]string{"a": "1"})
+		size := kv.Size()
+
+		file := fsys.files["test.kv"]
+		file.failWrite = true
+		batch := &storage.KVBatch{}
+		batch.Put([]byte("b"), []byte("2"))
+		require.ErrorIs(t, kv.Write(batch), errInjected)
+		file.failWrite, file.failSync = false, true
+		require.ErrorIs(t, kv.Write(batch), errInjected)
+		file.failSync = false
+		requireKV(t, kv, map[string]string{"a": "1"})
+		require.Equal(t, size, kv.Size())
+
+		// the next batch follows the last one written in full, so that it is read back after reopening
+		writeKV(t, kv, map[string]string{"c": "3"})
+		require.NoError(t, kv.Close())
+		require.NoError(t, memFS.Remove("test.kv.hint"))
+		kv, err = storage.OpenKV(memFS, "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		requireKV(t, kv, map[string]string{"a": "1", "c": "3"})
+	})
+
+	t.Run("a store whose failed write could not be rolled back should be closed", func(t *testing.T) {
+		memFS := storage.NewMemFS()
+		fsys := &faultyFS{FS: memFS, files: make(map[string]*faultyFile)}
+		kv, err := storage.OpenKV(fsys, "test.kv")
+		require.NoError(t, err)
+		writeKV(t, kv, map[string]string{"a": "1"})
+
+		file := fsys.files["test.kv"]
+		file.failWrite, file.failTruncate = true, true
+		batch := &storage.KVBatch{}
+		batch.Put([]byte("b"), []byte("2"))
+		require.ErrorIs(t, kv.Write(batch), errInjected)
+		require.ErrorIs(t, kv.Write(batch), storage.ErrKVClosed)
+		_, err = kv.Get([]byte("a"))
+		require.ErrorIs(t, err, storage.ErrKVClosed)
+		require.NoError(t, kv.Close())
+
+		// the torn batch is discarded when the store is opened again
+		kv, err = storage.OpenKV(memFS, "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		requireKV(t, kv, map[string]string{"a": "1"})
+	})
+
+	t.Run("compaction should keep the current values only", func(t *testing.T) {
+		fs := storage.NewMemFS()
+		kv, err := storage.OpenKV(fs, "test.kv")
+		require.NoError(t, err)
+		for _, value := range []string{"a", "b", "c"} {
+			batch := &storage.KVBatch{}
+			batch.Put([]byte("key"), []byte(value))
+			batch.Put([]byte("deleted"), []byte(value))
+			batch.Delete([]byte("deleted"))
+			require.NoError(t, kv.Write(batch))
+		}
+		require.NoError(t, kv.Compact())
+
+		value, err := kv.Get([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("c"), value)
+		require.Equal(t, 1, kv.Len())
+		// the store should still be usable after compacting
+		batch := &storage.KVBatch{}
+		batch.Put([]byte("next"), []byte("d"))
+		require.NoError(t, kv.Write(batch))
+		require.NoError(t, kv.Close())
+
+		kv, err = storage.OpenKV(fs, "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		require.Equal(t, [][]byte{[]byte("key"), []byte("next")}, kv.Keys(nil))
+	})
+
+	t.Run("the log should be compacted once it is mostly overwritten", func(t *testing.T) {
+		kv, err := storage.OpenKV(storage.NewMemFS(), "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		value := bytes.Repeat([]byte{0x01}, 1024*1024)
+		for i := range 70 {
+			value[0] = byte(i)
+			batch := &storage.KVBatch{}
+			batch.Put([]byte("key"), value)
+			require.NoError(t, kv.Write(batch))
+		}
+
+		require.Less(t, kv.Size(), int64(8*1024*1024))
+		stored, err := kv.Get([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, value, stored)
+	})
+
+	t.Run("keys and values should be iterated in the order of the keys", func(t *testing.T) {
+		kv, err := storage.OpenKV(storage.NewMemFS(), "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		// enough keys for the index to be split
+		values := make(map[string]string)
+		for i := 1999; i >= 0; i-- {
+			values[fmt.Sprintf("b%04d", i)] = fmt.Sprint(i)
+		}
+		values["a"], values["c"] = "before", "after"
+		writeKV(t, kv, values)
+		batch := &storage.KVBatch{}
+		batch.Delete([]byte("b0500"))
+		require.NoError(t, kv.Write(batch))
+
+		var keys []string
+		require.NoError(t, kv.Iterate([]byte("b"), func(key []byte, value []byte) error {
+			require.Equal(t, values[string(key)], string(value))
+			keys = append(keys, string(key))
+			return nil
+		}))
+		require.Len(t, keys, 1999)
+		require.Equal(t, "b0000", keys[0])
+		require.Equal(t, "b0501", keys[500])
+		require.Equal(t, "b1999", keys[1998])
+		require.Len(t, kv.Keys([]byte("b")), 1999)
+
+		stop := errors.New("stop")
+		visited := 0
+		err = kv.Iterate(nil, func([]byte, []byte) error {
+			visited++
+			return stop
+		})
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, 1, visited)
+	})
+
+	t.Run("the index should be read from the hint file written when the store was closed", func(t *testing.T) {
+		fsys := storage.NewMemFS()
+		kv, err := storage.OpenKV(fsys, "test.kv")
+		require.NoError(t, err)
+		writeKV(t, kv, map[string]string{"a": "1", "b": "2"})
+		require.NoError(t, kv.Close())
+		_, err = storage.Size(fsys, "test.kv.hint")
+		require.NoError(t, err)
+
+		kv, err = storage.OpenKV(fsys, "test.kv")
+		require.NoError(t, err)
+		// the hint file would not describe the records appended from now on
+		_, err = storage.Size(fsys, "test.kv.hint")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		requireKV(t, kv, map[string]string{"a": "1", "b": "2"})
+		writeKV(t, kv, map[string]string{"b": "3", "c": "4"})
+		require.NoError(t, kv.Close())
+
+		kv, err = storage.OpenKV(fsys, "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		requireKV(t, kv, map[string]string{"a": "1", "b": "3", "c": "4"})
+	})
+
+	t.Run("a hint file that does not match the log should be ignored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.kv")
+		kv, err := storage.OpenKV(storage.OSFS{}, path)
+		require.NoError(t, err)
+		writeKV(t, kv, map[string]string{"a": "1"})
+		writeKV(t, kv, map[string]string{"a": "2"})
+		require.NoError(t, kv.Close())
+
+		// the hint file describes the torn batch too
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(path, info.Size()-2))
+		kv, err = storage.OpenKV(storage.OSFS{}, path)
+		require.NoError(t, err)
+		requireKV(t, kv, map[string]string{"a": "1"})
+		require.NoError(t, kv.Close())
+
+		require.NoError(t, os.WriteFile(path+".hint", []byte("damaged"), 0o644))
+		kv, err = storage.OpenKV(storage.OSFS{}, path)
+		require.NoError(t, err)
+		defer kv.Close()
+		requireKV(t, kv, map[string]string{"a": "1"})
+	})
+
+	t.Run("values should be read while batches are written", func(t *testing.T) {
+		kv, err := storage.OpenKV(storage.NewMemFS(), "test.kv")
+		require.NoError(t, err)
+		defer kv.Close()
+		writeKV(t, kv, map[string]string{"key": "0"})
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					_, err := kv.Get([]byte("key"))
+					require.NoError(t, err)
+				}
+			}()
+		}
+		for i := range 100 {
+			writeKV(t, kv, map[string]string{"key": fmt.Sprint(i)})
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Maximum size of a single WAL record, so a corrupted length cannot make us allocate unbounded memory
+const maxWALRecordSize = 64 * 1024 * 1024
+
+// Size of the length and checksum starting every WAL record
+const walRecordHeaderSize = 8
+
+var ErrWALRecordTooBig = errors.New("wal record too big")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WAL is an append-only write-ahead log of batches of mutations.
+//
+// Each batch is written as a single record (length, CRC32C checksum, entries) and fsync'd before Append returns, so a batch is either entirely
+// in the log or not at all. A record that was only partially written when the process crashed is detected by its length or checksum and
+// truncated away when the log is reopened, leaving the log at the last complete batch.
+type WAL struct {
+	mu   sync.Mutex
+	f    File
+	path string
+}
+
+// OpenWAL opens (or creates) the log at path in fsys, truncating any torn record at its end
+func OpenWAL(fsys FS, path string) (*WAL, error) {
+	f, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{f: f, path: path}
+
+	validLength, err := scanWAL(f, 0, nil)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if size != validLength {
+		log.Printf("⚠️ Truncating %d bytes of incomplete records at the end of %s", size-validLength, path)
+		err = f.Truncate(validLength)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	_, err = f.Seek(validLength, io.SeekStart)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Append durably writes a batch of entries as a single record
+func (w *WAL) Append(batch [][]byte) error {
+	record, err := encodeWALRecord(batch)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.f.Write(record)
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// encodeWALRecord encodes batch as a record: the length and checksum of the payload, then the payload made of the number of entries and each
+// entry preceded by its length
+func encodeWALRecord(batch [][]byte) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	err := binary.Write(payload, binary.LittleEndian, uint32(len(batch)))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range batch {
+		err = binary.Write(payload, binary.LittleEndian, uint32(len(entry)))
+		if err != nil {
+			return nil, err
+		}
+		payload.Write(entry)
+	}
+	if payload.Len() > maxWALRecordSize {
+		return nil, ErrWALRecordTooBig
+	}
+
+	record := make([]byte, walRecordHeaderSize, walRecordHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload.Bytes(), castagnoli))
+	return append(record, payload.Bytes()...), nil
+}
+
+// walEntryOffsets returns the offset of each entry of batch from the start of its record
+func walEntryOffsets(batch [][]byte) []int64 {
+	offsets := make([]int64, len(batch))
+	// the header, then the number of entries
+	offset := int64(walRecordHeaderSize + 4)
+	for i, entry := range batch {
+		offsets[i] = offset + 4
+		offset += 4 + int64(len(entry))
+	}
+	return offsets
+}
+
+// Replay calls fn with every complete batch in the log, in the order they were appended
+func (w *WAL) Replay(fn func(batch [][]byte) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := scanWAL(w.f, 0, func(_ int64, batch [][]byte) error { return fn(batch) })
+	return err
+}
+
+// Truncate empties the log. It is called once the mutations in the log have been durably applied elsewhere (a checkpoint).
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// scanWAL reads the log in f from offset from, which must start a record, calling fn (if not nil) with the offset of every complete record and
+// its batch, and returns the length of the valid prefix of the log
+func scanWAL(f File, from int64, fn func(offset int64, batch [][]byte) error) (int64, error) {
+	_, err := f.Seek(from, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	// leave the file offset at the end for subsequent appends
+	defer f.Seek(0, io.SeekEnd)
+
+	r := bufio.NewReader(f)
+	validLength := from
+	for {
+		var header [walRecordHeaderSize]byte
+		_, err = io.ReadFull(r, header[:])
+		if err != nil {
+			// a missing or partial header is the end of the log
+			return validLength, nil
+		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > maxWALRecordSize {
+			return validLength, nil
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(r, payload)
+		if err != nil || crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(header[4:8]) {
+			return validLength, nil
+		}
+		batch, err := decodeWALBatch(payload)
+		if err != nil {
+			return validLength, nil
+		}
+		if fn != nil {
+			err = fn(validLength, batch)
+			if err != nil {
+				return validLength, err
+			}
+		}
+		validLength += int64(len(header) + len(payload))
+	}
+}
+
+func decodeWALBatch(payload []byte) ([][]byte, error) {
+	r := bytes.NewReader(payload)
+	var count uint32
+	err := binary.Read(r, binary.LittleEndian, &count)
+	if err != nil {
+		return nil, err
+	}
+	if int(count) > len(payload) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	batch := make([][]byte, count)
+	for i := range batch {
+		var length uint32
+		err = binary.Read(r, binary.LittleEndian, &length)
+		if err != nil {
+			return nil, err
+		}
+		if int(length) > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		batch[i] = make([]byte, length)
+		_, err = io.ReadFull(r, batch[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
@@ -0,0 +1,89 @@
+package storage_test
+
+import (
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func replayAll(t *testing.T, wal *storage.WAL) [][][]byte {
+	var batches [][][]byte
+	err := wal.Replay(func(batch [][]byte) error {
+		batches = append(batches, batch)
+		return nil
+	})
+	require.NoError(t, err)
+	return batches
+}
+
+func TestWAL(t *testing.T) {
+	t.Run("appended batches should be replayed after reopening", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.wal")
+		wal, err := storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		require.NoError(t, wal.Append([][]byte{[]byte("a"), []byte("bc")}))
+		require.NoError(t, wal.Append([][]byte{{}}))
+		require.NoError(t, wal.Close())
+
+		wal, err = storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		defer wal.Close()
+		require.Equal(t, [][][]byte{{[]byte("a"), []byte("bc")}, {{}}}, replayAll(t, wal))
+
+		// appending after a replay should not overwrite existing batches
+		require.NoError(t, wal.Append([][]byte{[]byte("d")}))
+		require.Len(t, replayAll(t, wal), 3)
+	})
+
+	t.Run("a torn batch at the end should be discarded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.wal")
+		wal, err := storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		require.NoError(t, wal.Append([][]byte{[]byte("complete")}))
+		require.NoError(t, wal.Append([][]byte{[]byte("torn")}))
+		require.NoError(t, wal.Close())
+
+		// simulate a crash in the middle of writing the last batch
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(path, info.Size()-2))
+
+		wal, err = storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		defer wal.Close()
+		require.Equal(t, [][][]byte{{[]byte("complete")}}, replayAll(t, wal))
+
+		require.NoError(t, wal.Append([][]byte{[]byte("next")}))
+		require.Equal(t, [][][]byte{{[]byte("complete")}, {[]byte("next")}}, replayAll(t, wal))
+	})
+
+	t.Run("a corrupted batch should end the log", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.wal")
+		wal, err := storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		require.NoError(t, wal.Append([][]byte{[]byte("first")}))
+		require.NoError(t, wal.Append([][]byte{[]byte("second")}))
+		require.NoError(t, wal.Close())
+
+		contents, err := os.ReadFile(path)
+		require.NoError(t, err)
+		contents[len(contents)-1] ^= 0xFF
+		require.NoError(t, os.WriteFile(path, contents, 0o644))
+
+		wal, err = storage.OpenWAL(storage.OSFS{}, path)
+		require.NoError(t, err)
+		defer wal.Close()
+		require.Equal(t, [][][]byte{{[]byte("first")}}, replayAll(t, wal))
+	})
+
+	t.Run("truncate should empty the log", func(t *testing.T) {
+		wal, err := storage.OpenWAL(storage.NewMemFS(), "test.wal")
+		require.NoError(t, err)
+		defer wal.Close()
+		require.NoError(t, wal.Append([][]byte{[]byte("a")}))
+		require.NoError(t, wal.Truncate())
+		require.Empty(t, replayAll(t, wal))
+	})
+}
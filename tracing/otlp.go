@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the instrumentation scope of the spans, as OTLP reports it
+const otlpScopeName = "github.com/aang114/bitcoin-node"
+
+// Kind and status codes of OTLP spans (https://github.com/open-telemetry/opentelemetry-proto/blob/v1.3.2/opentelemetry/proto/trace/v1/trace.proto)
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+// Time after which an export request is abandoned
+const otlpExportTimeout = 10 * time.Second
+
+// OTLPExporter exports spans to an OpenTelemetry collector with the OTLP/HTTP protocol, encoded in JSON
+// (https://opentelemetry.io/docs/specs/otlp/#otlphttp)
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting spans to the traces path of endpoint (e.g. http://127.0.0.1:4318 posts them to
+// http://127.0.0.1:4318/v1/traces), as the spans of the service called serviceName
+func NewOTLPExporter(endpoint string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpExportTimeout},
+	}
+}
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector answered with status %s: %s", resp.Status, bytes.TrimSpace(reason))
+	}
+	return nil
+}
+
+func (e *OTLPExporter) request(spans []*Span) otlpExportRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceId:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanId:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent != (SpanID{}) {
+			encoded[i].ParentSpanId = hex.EncodeToString(s.Parent[:])
+		}
+		if s.Error != "" {
+			encoded[i].Status = &otlpStatus{Code: otlpStatusCodeError, Message: s.Error}
+		}
+	}
+	return otlpExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", e.serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}, Spans: encoded}},
+	}}}
+}
+
+func otlpAttributes(attributes []Attribute) []otlpKeyValue {
+	encoded := make([]otlpKeyValue, len(attributes))
+	for i, attribute := range attributes {
+		encoded[i].Key = attribute.Key
+		switch value := attribute.Value.(type) {
+		case string:
+			encoded[i].Value.StringValue = &value
+		case int64:
+			// 64-bit integers are encoded as decimal strings in JSON
+			intValue := strconv.FormatInt(value, 10)
+			encoded[i].Value.IntValue = &intValue
+		case bool:
+			encoded[i].Value.BoolValue = &value
+		case float64:
+			encoded[i].Value.DoubleValue = &value
+		default:
+			stringValue := fmt.Sprint(value)
+			encoded[i].Value.StringValue = &stringValue
+		}
+	}
+	return encoded
+}
+
+// Messages of the OTLP trace service, as their JSON encoding names their fields
+// (https://github.com/open-telemetry/opentelemetry-proto/blob/v1.3.2/opentelemetry/proto/collector/trace/v1/trace_service.proto)
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,247 @@
+// Package tracing records how long the node takes to process messages and blocks as spans, which are exported in batches, e.g. to an
+// OpenTelemetry collector over OTLP (see OTLPExporter).
+//
+// Spans are only recorded once a Tracer is installed with SetTracer: until then Start returns a nil *Span, whose methods do nothing.
+package tracing
+
+import (
+	"encoding/binary"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of ended spans waiting to be exported before new ones are dropped
+	spanQueueSize = 4096
+	// Maximum number of spans exported at once
+	maxExportBatchSize = 512
+	// Interval at which the ended spans are exported, if fewer than maxExportBatchSize are waiting
+	exportInterval = 5 * time.Second
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+// SpanContext identifies a span to start its children with. The zero SpanContext identifies no span: spans started with it begin a new trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.SpanID != SpanID{}
+}
+
+// Attribute is a key and its value, which is a string, an int64, a bool or a float64
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation, such as decoding a message or connecting a block
+type Span struct {
+	Name    string
+	Context SpanContext
+	// Id of the span this span is a child of, or the zero SpanID for the first span of a trace
+	Parent     SpanID
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes []Attribute
+	// Error the operation failed with, or empty if it succeeded
+	Error  string
+	tracer *Tracer
+}
+
+// SpanContext returns the context to start the children of the span with, or the zero SpanContext if the span is nil
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes, attributes...)
+}
+
+// SetError marks the operation as failed with err, if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// End records the end of the operation and queues the span for export. The span must not be changed afterwards.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.EndTime = time.Now()
+	s.tracer.queueSpan(s)
+}
+
+// Exporter sends ended spans to where they are analyzed
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer starts spans and hands them to its exporter in batches once they end. Spans ending while spanQueueSize spans wait to be exported
+// are dropped rather than slowing the node down.
+type Tracer struct {
+	exporter     Exporter
+	queue        chan *Span
+	dropped      atomic.Int64
+	quit         chan struct{}
+	done         chan struct{}
+	shutdownOnce sync.Once
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, spanQueueSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.exportLoop()
+	return t
+}
+
+// Start starts a span called name at the given time, as a child of parent
+func (t *Tracer) Start(parent SpanContext, name string, start time.Time, attributes ...Attribute) *Span {
+	s := &Span{
+		Name:       name,
+		Context:    SpanContext{TraceID: parent.TraceID, SpanID: newSpanID()},
+		Parent:     parent.SpanID,
+		StartTime:  start,
+		Attributes: attributes,
+		tracer:     t,
+	}
+	if !parent.IsValid() {
+		s.Context.TraceID = newTraceID()
+	}
+	return s
+}
+
+// Shutdown exports the spans which ended and stops exporting the spans ending afterwards
+func (t *Tracer) Shutdown() {
+	t.shutdownOnce.Do(func() {
+		close(t.quit)
+		<-t.done
+	})
+}
+
+func (t *Tracer) queueSpan(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) exportLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxExportBatchSize)
+	export := func() {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			log.Printf("⚠️ Dropped %d spans as too many were waiting to be exported", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		err := t.exporter.Export(batch)
+		if err != nil {
+			log.Printf("⚠️ Could not export %d spans due to error: %s", len(batch), err)
+		}
+		batch = make([]*Span, 0, maxExportBatchSize)
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) == maxExportBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case <-t.quit:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					if len(batch) == maxExportBatchSize {
+						export()
+					}
+				default:
+					export()
+					return
+				}
+			}
+		}
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+var tracer atomic.Pointer[Tracer]
+
+// SetTracer makes Start and StartAt record spans with t, or stops them from recording spans if t is nil
+func SetTracer(t *Tracer) {
+	tracer.Store(t)
+}
+
+// Start starts a span called name now, as a child of parent, or returns nil if no tracer is set
+func Start(parent SpanContext, name string, attributes ...Attribute) *Span {
+	return StartAt(parent, name, time.Now(), attributes...)
+}
+
+// StartAt starts a span called name at the given time, as a child of parent, or returns nil if no tracer is set
+func StartAt(parent SpanContext, name string, start time.Time, attributes ...Attribute) *Span {
+	t := tracer.Load()
+	if t == nil {
+		return nil
+	}
+	return t.Start(parent, name, start, attributes...)
+}
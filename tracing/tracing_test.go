@@ -0,0 +1,116 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aang114/bitcoin-node/tracing"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the spans it is asked to export
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *recordingExporter) Export(spans []*tracing.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	t.Run("spans should not be recorded without a tracer", func(t *testing.T) {
+		span := tracing.Start(tracing.SpanContext{}, "decode", tracing.String("command", "block"))
+		require.Nil(t, span)
+		span.SetAttributes(tracing.Int("size", 1))
+		span.SetError(errors.New("failed"))
+		span.End()
+		require.False(t, span.SpanContext().IsValid())
+	})
+
+	t.Run("children should share the trace of their parent", func(t *testing.T) {
+		exporter := &recordingExporter{}
+		tracer := tracing.NewTracer(exporter)
+		tracing.SetTracer(tracer)
+		defer tracing.SetTracer(nil)
+
+		parent := tracing.Start(tracing.SpanContext{}, "parent")
+		child := tracing.Start(parent.SpanContext(), "child", tracing.String("hash", "ab"))
+		child.SetError(errors.New("failed"))
+		child.End()
+		parent.End()
+		tracer.Shutdown()
+
+		require.Len(t, exporter.spans, 2)
+		require.Equal(t, child, exporter.spans[0])
+		require.Equal(t, parent, exporter.spans[1])
+		require.Equal(t, parent.Context.TraceID, child.Context.TraceID)
+		require.Equal(t, parent.Context.SpanID, child.Parent)
+		require.NotEqual(t, parent.Context.SpanID, child.Context.SpanID)
+		require.Equal(t, tracing.SpanID{}, parent.Parent)
+		require.Equal(t, "failed", child.Error)
+		require.False(t, child.EndTime.Before(child.StartTime))
+	})
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var request map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+	}))
+	defer collector.Close()
+
+	tracer := tracing.NewTracer(nil)
+	defer tracer.Shutdown()
+	span := tracer.Start(tracing.SpanContext{}, "chain.connect_block", time.Unix(1, 0),
+		tracing.String("hash", "ab"), tracing.Int64("height", 840000), tracing.Bool("reorg", false))
+	span.Context = tracing.SpanContext{TraceID: tracing.TraceID{0x01}, SpanID: tracing.SpanID{0x02}}
+	span.Parent = tracing.SpanID{0x03}
+	span.EndTime = time.Unix(2, 0)
+	span.Error = "failed"
+
+	require.NoError(t, tracing.NewOTLPExporter(collector.URL+"/", "bitcoin-node").Export([]*tracing.Span{span}))
+
+	var expected map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "bitcoin-node"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "github.com/aang114/bitcoin-node"},
+			"spans": [{
+				"traceId": "01000000000000000000000000000000",
+				"spanId": "0200000000000000",
+				"parentSpanId": "0300000000000000",
+				"name": "chain.connect_block",
+				"kind": 1,
+				"startTimeUnixNano": "1000000000",
+				"endTimeUnixNano": "2000000000",
+				"attributes": [
+					{"key": "hash", "value": {"stringValue": "ab"}},
+					{"key": "height", "value": {"intValue": "840000"}},
+					{"key": "reorg", "value": {"boolValue": false}}
+				],
+				"status": {"code": 2, "message": "failed"}
+			}]
+		}]
+	}]}`), &expected))
+	require.Equal(t, expected, request)
+}
+
+func TestOTLPExporter_ReportsRejectedExports(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown service", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	err := tracing.NewOTLPExporter(collector.URL, "bitcoin-node").Export(nil)
+	require.ErrorContains(t, err, "unknown service")
+}
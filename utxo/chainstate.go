@@ -0,0 +1,211 @@
+package utxo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"sync"
+)
+
+var (
+	ErrNotChainstateTip = errors.New("block does not extend or end the chain of the chainstate")
+	ErrMissingUndoData  = errors.New("no undo data for block")
+)
+
+// Chainstate is the set of unspent outputs at the tip of a chain, kept up to date as blocks are connected to and disconnected from the chain.
+// The outputs spent by each connected block are kept as its undo data, so that it can be disconnected during a reorganization. The undo data
+// is written to the DB of the unspent outputs, if they have one, in the same batch as the outputs the block left, so that blocks connected
+// before a restart can be disconnected too.
+type Chainstate struct {
+	mu    sync.Mutex
+	coins *Set
+	tip   message.Hash256
+	// height of tip
+	height int32
+	// height of the block the chainstate started from, whose outputs and the ones of the blocks below it are already in coins (lowered when
+	// blocks below it are disconnected)
+	baseHeight int32
+	// outputs spent by each connected block, in the order ConnectBlock returned them, which are not written to the DB yet
+	undo map[message.Hash256][]Coin
+	// goroutines validating the inputs of a block
+	workers int
+	// tells whether the scripts of the block at a height need not be run
+	skipScripts func(height int32) bool
+	// heights from which the soft forks of the network are enforced
+	deployments constants.Deployments
+}
+
+// NewChainstate returns the chainstate whose unspent outputs are coins at the block with hash base, at height. The chainstate of the genesis
+// block is NewChainstate(NewSet(), genesisHash, 0), as the output of its coinbase cannot be spent.
+func NewChainstate(coins *Set, base message.Hash256, height int32) *Chainstate {
+	return &Chainstate{
+		coins:       coins,
+		tip:         base,
+		height:      height,
+		baseHeight:  height,
+		undo:        make(map[message.Hash256][]Coin),
+		workers:     1,
+		skipScripts: func(int32) bool { return false },
+		deployments: constants.MainnetParams.Deployments,
+	}
+}
+
+// OpenChainstate returns the chainstate of the unspent outputs in db, at the block they were last flushed at (the genesis block, whose hash is
+// genesisHash, if they were never flushed), caching up to about maxCacheBytes of them in memory
+func OpenChainstate(db *DB, genesisHash message.Hash256, maxCacheBytes int) (*Chainstate, error) {
+	best, height, ok, err := db.BestBlock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		best, height = genesisHash, 0
+	}
+	return NewChainstate(NewCachedSet(db, maxCacheBytes), best, height), nil
+}
+
+// Flush writes the unspent outputs at the tip to the DB of the chainstate, if it has one, keeping the cached outputs in memory. The chainstate
+// is also flushed when its cached outputs take more memory than allowed.
+func (c *Chainstate) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush(false)
+}
+
+// flush writes the unspent outputs and the undo data of the blocks connected since the last flush to the DB, if there is one
+func (c *Chainstate) flush(evict bool) error {
+	if c.coins.db == nil {
+		return nil
+	}
+	batch := &storage.KVBatch{}
+	for hash, spent := range c.undo {
+		encoded, err := encodeUndo(spent)
+		if err != nil {
+			return err
+		}
+		batch.Put(dbUndoKey(hash), encoded)
+	}
+	err := c.coins.flush(batch, c.tip, c.height, evict)
+	if err != nil {
+		return err
+	}
+	clear(c.undo)
+	return nil
+}
+
+// Coins returns the unspent outputs at the tip of the chainstate
+func (c *Chainstate) Coins() *Set {
+	return c.coins
+}
+
+// Tip returns the hash and height of the last block connected to the chainstate
+func (c *Chainstate) Tip() (message.Hash256, int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip, c.height
+}
+
+// BaseHeight returns the height of the block the chainstate started from, up to which blocks are ignored
+func (c *Chainstate) BaseHeight() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.baseHeight
+}
+
+// SetValidationWorkers sets the number of goroutines validating the inputs of each connected block
+func (c *Chainstate) SetValidationWorkers(workers int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers = max(workers, 1)
+}
+
+// SetSkipScripts sets the function telling whether the scripts of the block at a height need not be run when it is connected, e.g. because a
+// checkpoint buries it. The values of its inputs and outputs are checked either way.
+func (c *Chainstate) SetSkipScripts(skip func(height int32) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skipScripts = skip
+}
+
+// SetDeployments sets the heights from which the soft forks of the network the blocks belong to are enforced (mainnet's by default)
+func (c *Chainstate) SetDeployments(deployments constants.Deployments) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deployments = deployments
+}
+
+// ConnectBlock applies the transactions of block, whose hash is hash, to the unspent outputs and makes it the tip. Blocks up to the block the
+// chainstate started from are ignored, as their outputs are already accounted for. Nothing is changed if the inputs of the block are invalid
+// (see CheckBlockInputs).
+func (c *Chainstate) ConnectBlock(hash message.Hash256, height int32, block *message.BlockPayload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if height <= c.baseHeight {
+		return nil
+	}
+	if block.PrevBlock != c.tip || height != c.height+1 {
+		return fmt.Errorf("%w: block %s at height %d does not follow %s at height %d", ErrNotChainstateTip, hash, height, c.tip, c.height)
+	}
+	spent, err := c.coins.ConnectBlock(block, height)
+	if err != nil {
+		return err
+	}
+	err = CheckBlockInputs(block, height, spent, c.deployments, c.workers, !c.skipScripts(height))
+	if err != nil {
+		return errors.Join(fmt.Errorf("block %s at height %d: %w", hash, height, err), c.coins.DisconnectBlock(block, spent))
+	}
+	c.undo[hash] = spent
+	c.tip, c.height = hash, height
+	if c.coins.cacheFull() {
+		return c.flush(true)
+	}
+	return nil
+}
+
+// Undo returns the outputs spent by the connected block with hash hash, or ErrMissingUndoData if the chainstate does not have them, e.g.
+// because the block was connected before a chainstate without a DB was started
+func (c *Chainstate) Undo(hash message.Hash256) ([]Coin, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.undoData(hash)
+}
+
+func (c *Chainstate) undoData(hash message.Hash256) ([]Coin, error) {
+	spent, ok := c.undo[hash]
+	if !ok && c.coins.db != nil {
+		var err error
+		spent, ok, err = c.coins.db.getUndo(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingUndoData, hash)
+	}
+	return spent, nil
+}
+
+// DisconnectBlock undoes the ConnectBlock of block, which must be the tip, making its parent the tip. Blocks connected before the chainstate
+// was opened can only be disconnected if it has a DB, which holds their undo data.
+func (c *Chainstate) DisconnectBlock(hash message.Hash256, height int32, block *message.BlockPayload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hash != c.tip || height != c.height {
+		return fmt.Errorf("%w: block %s at height %d is not the tip %s at height %d", ErrNotChainstateTip, hash, height, c.tip, c.height)
+	}
+	spent, err := c.undoData(hash)
+	if err != nil {
+		return err
+	}
+	err = c.coins.DisconnectBlock(block, spent)
+	if err != nil {
+		return err
+	}
+	delete(c.undo, hash)
+	c.tip, c.height = block.PrevBlock, height-1
+	c.baseHeight = min(c.baseHeight, c.height)
+	return nil
+}
@@ -0,0 +1,74 @@
+package utxo_test
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// newBlock returns a block following prevBlock with txs, and its hash
+func newBlock(t *testing.T, prevBlock message.Hash256, txs ...message.TxPayload) (*message.BlockPayload, message.Hash256) {
+	block := &message.BlockPayload{Version: 1, PrevBlock: prevBlock, Transactions: txs}
+	hash, err := block.GetBlockHash()
+	require.NoError(t, err)
+	return block, hash
+}
+
+// connectEmptyBlocks connects n blocks following prevBlock, which is at height, to each of chainstates, and returns the hash and height of the
+// last one. Their coinbases add no output to the set: tests connect them for the coinbases before them to mature.
+func connectEmptyBlocks(t *testing.T, prevBlock message.Hash256, height int32, n int, chainstates ...*utxo.Chainstate) (message.Hash256, int32) {
+	for range n {
+		height++
+		coinbase := newCoinbase(height, 0)
+		coinbase.TransactionOutputs[0].PkScript = []byte{0x6a}
+		block, hash := newBlock(t, prevBlock, coinbase)
+		for _, chainstate := range chainstates {
+			require.NoError(t, chainstate.ConnectBlock(hash, height, block))
+		}
+		prevBlock = hash
+	}
+	return prevBlock, height
+}
+
+func TestChainstate(t *testing.T) {
+	chainstate := utxo.NewChainstate(utxo.NewSet(), genesisHash, 0)
+	coinbase1 := newCoinbase(1, 50)
+	block1, hash1 := newBlock(t, genesisHash, coinbase1)
+	require.NoError(t, chainstate.ConnectBlock(hash1, 1, block1))
+	spend := newSpend(t, []message.TxPayload{coinbase1}, 20, 30)
+	// the coinbase of block 1 cannot be spent before height 101
+	prev, prevHeight := connectEmptyBlocks(t, hash1, 1, constants.CoinbaseMaturity-2, chainstate)
+	premature, prematureHash := newBlock(t, prev, newCoinbase(2, 50), spend)
+	require.ErrorIs(t, chainstate.ConnectBlock(prematureHash, prevHeight+1, premature), utxo.ErrPrematureCoinbaseSpend)
+	matured, maturedHeight := connectEmptyBlocks(t, prev, prevHeight, 1, chainstate)
+	block2, hash2 := newBlock(t, matured, newCoinbase(2, 50), spend)
+	require.NoError(t, chainstate.ConnectBlock(hash2, maturedHeight+1, block2))
+	require.Equal(t, 3, chainstate.Coins().Len())
+	tip, height := chainstate.Tip()
+	require.Equal(t, hash2, tip)
+	require.EqualValues(t, 101, height)
+
+	// blocks must extend the tip
+	require.ErrorIs(t, chainstate.ConnectBlock(hash1, 1, block1), utxo.ErrNotChainstateTip)
+	require.ErrorIs(t, chainstate.DisconnectBlock(hash1, 1, block1), utxo.ErrNotChainstateTip)
+
+	// a reorganization disconnects the tip with its undo data and connects the competing block
+	require.NoError(t, chainstate.DisconnectBlock(hash2, height, block2))
+	_, ok, err := chainstate.Coins().Get(outPoint(t, coinbase1, 0))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 1, chainstate.Coins().Len())
+	competing, competingHash := newBlock(t, matured, newCoinbase(2, 25))
+	require.NoError(t, chainstate.ConnectBlock(competingHash, height, competing))
+	require.Equal(t, 2, chainstate.Coins().Len())
+
+	// a block spending a missing output leaves the chainstate unchanged
+	block3, hash3 := newBlock(t, competingHash, newCoinbase(3, 50), spend)
+	require.NoError(t, chainstate.ConnectBlock(hash3, height+1, block3))
+	invalid, invalidHash := newBlock(t, hash3, newCoinbase(4, 50), newSpend(t, []message.TxPayload{coinbase1}, 10))
+	require.ErrorIs(t, chainstate.ConnectBlock(invalidHash, height+2, invalid), utxo.ErrMissingCoin)
+	tip, _ = chainstate.Tip()
+	require.Equal(t, hash3, tip)
+}
@@ -0,0 +1,139 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+)
+
+// Keys of the DB
+const (
+	// followed by the outpoint (hash and little-endian index), the output it refers to as encoded by encodeCoinBody
+	dbCoinPrefix byte = 'c'
+	// followed by a block hash, the outputs the block spent as encoded by encodeUndo
+	dbUndoPrefix byte = 'u'
+)
+
+// Key of the DB holding the hash and height of the block the outputs in the DB are the unspent outputs at
+var dbBestBlockKey = []byte{'B'}
+
+// DB stores unspent outputs in a storage.KV, along with the block they are the unspent outputs at. It is read and written through a Set
+// created with NewCachedSet.
+type DB struct {
+	kv *storage.KV
+	// number of outputs
+	count int
+}
+
+// OpenDB opens (or creates) the database whose key-value store is at path in fsys
+func OpenDB(fsys storage.FS, path string) (*DB, error) {
+	kv, err := storage.OpenKV(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{kv: kv, count: len(kv.Keys([]byte{dbCoinPrefix}))}, nil
+}
+
+func dbCoinKey(outpoint message.OutPoint) []byte {
+	key := make([]byte, 0, 1+len(outpoint.Hash)+4)
+	key = append(key, dbCoinPrefix)
+	key = append(key, outpoint.Hash[:]...)
+	return binary.LittleEndian.AppendUint32(key, outpoint.Index)
+}
+
+// BestBlock returns the hash and height of the block the outputs in the database are the unspent outputs at, if any was written
+func (db *DB) BestBlock() (message.Hash256, int32, bool, error) {
+	encoded, err := db.kv.Get(dbBestBlockKey)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return message.Hash256{}, 0, false, nil
+	}
+	if err != nil {
+		return message.Hash256{}, 0, false, err
+	}
+	if len(encoded) != len(message.Hash256{})+4 {
+		return message.Hash256{}, 0, false, fmt.Errorf("%w: best block is %x", ErrInvalidCoin, encoded)
+	}
+	return message.Hash256(encoded[:32]), int32(binary.LittleEndian.Uint32(encoded[32:])), true, nil
+}
+
+func (db *DB) getCoin(outpoint message.OutPoint) (Coin, bool, error) {
+	encoded, err := db.kv.Get(dbCoinKey(outpoint))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return Coin{}, false, nil
+	}
+	if err != nil {
+		return Coin{}, false, err
+	}
+	coin, err := decodeCoinBody(bytes.NewReader(encoded))
+	return coin, err == nil, err
+}
+
+// outPoints returns the outpoints of the outputs in the database
+func (db *DB) outPoints() []message.OutPoint {
+	keys := db.kv.Keys([]byte{dbCoinPrefix})
+	outpoints := make([]message.OutPoint, len(keys))
+	for i, key := range keys {
+		outpoints[i].Hash = message.Hash256(key[1:33])
+		outpoints[i].Index = binary.LittleEndian.Uint32(key[33:])
+	}
+	return outpoints
+}
+
+func dbUndoKey(hash message.Hash256) []byte {
+	return append([]byte{dbUndoPrefix}, hash[:]...)
+}
+
+// getUndo returns the outputs spent by the block with hash hash, if they were written
+func (db *DB) getUndo(hash message.Hash256) ([]Coin, bool, error) {
+	encoded, err := db.kv.Get(dbUndoKey(hash))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	spent, err := decodeUndo(bytes.NewReader(encoded))
+	return spent, err == nil, err
+}
+
+// encodeUndo serializes the outputs spent by a block as their number followed by each one encoded by encodeCoinBody
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/undo.h#L64)
+func encodeUndo(spent []Coin) ([]byte, error) {
+	encoded, err := message.VarInt(len(spent)).Encode()
+	if err != nil {
+		return nil, err
+	}
+	for _, coin := range spent {
+		encodedCoin, err := encodeCoinBody(coin)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, encodedCoin...)
+	}
+	return encoded, nil
+}
+
+func decodeUndo(r io.Reader) ([]Coin, error) {
+	count, err := message.DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	// capped, so that a corrupted count cannot make us allocate unbounded memory
+	spent := make([]Coin, 0, min(count, 1024))
+	for range count {
+		coin, err := decodeCoinBody(r)
+		if err != nil {
+			return nil, err
+		}
+		spent = append(spent, coin)
+	}
+	return spent, nil
+}
+
+func (db *DB) Close() error {
+	return db.kv.Close()
+}
@@ -0,0 +1,73 @@
+package utxo_test
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestChainstate_CachedByDB(t *testing.T) {
+	fs := storage.NewMemFS()
+	db, err := utxo.OpenDB(fs, "chainstate.kv")
+	require.NoError(t, err)
+	// a budget of a single output makes every block flush the cache
+	chainstate, err := utxo.OpenChainstate(db, genesisHash, 200)
+	require.NoError(t, err)
+	reference := utxo.NewChainstate(utxo.NewSet(), genesisHash, 0)
+
+	coinbase1 := newCoinbase(1, 50)
+	block1, hash1 := newBlock(t, genesisHash, coinbase1)
+	for _, c := range []*utxo.Chainstate{chainstate, reference} {
+		require.NoError(t, c.ConnectBlock(hash1, 1, block1))
+	}
+	matured, height := connectEmptyBlocks(t, hash1, 1, constants.CoinbaseMaturity-1, chainstate, reference)
+	spend := newSpend(t, []message.TxPayload{coinbase1}, 20, 30)
+	block2, hash2 := newBlock(t, matured, newCoinbase(2, 50), spend)
+	block3, hash3 := newBlock(t, hash2, newCoinbase(3, 50), newSpend(t, []message.TxPayload{spend}, 20))
+	height2 := height + 1
+	for _, c := range []*utxo.Chainstate{chainstate, reference} {
+		require.NoError(t, c.ConnectBlock(hash2, height2, block2))
+		require.NoError(t, c.ConnectBlock(hash3, height2+1, block3))
+		// the outputs spent by the tip are read back from the DB
+		require.NoError(t, c.DisconnectBlock(hash3, height2+1, block3))
+	}
+	requireSameCoins := func(expected *utxo.Set, actual *utxo.Set) {
+		require.Equal(t, expected.Len(), actual.Len())
+		expectedHash, err := expected.Hash()
+		require.NoError(t, err)
+		actualHash, err := actual.Hash()
+		require.NoError(t, err)
+		require.Equal(t, expectedHash, actualHash)
+	}
+	requireSameCoins(reference.Coins(), chainstate.Coins())
+	coin, ok, err := chainstate.Coins().Get(outPoint(t, spend, 0))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.EqualValues(t, 20, coin.Value)
+
+	require.NoError(t, chainstate.Flush())
+	require.NoError(t, db.Close())
+	db, err = utxo.OpenDB(fs, "chainstate.kv")
+	require.NoError(t, err)
+	defer db.Close()
+	reopened, err := utxo.OpenChainstate(db, genesisHash, 200)
+	require.NoError(t, err)
+	tip, height := reopened.Tip()
+	require.Equal(t, hash2, tip)
+	require.Equal(t, height2, height)
+	requireSameCoins(reference.Coins(), reopened.Coins())
+
+	// blocks connected before the restart are disconnected with the undo data in the DB, and can be connected again
+	require.NoError(t, reference.DisconnectBlock(hash2, height2, block2))
+	require.NoError(t, reopened.DisconnectBlock(hash2, height2, block2))
+	requireSameCoins(reference.Coins(), reopened.Coins())
+	require.NoError(t, reopened.ConnectBlock(hash2, height2, block2))
+	tip, _ = reopened.Tip()
+	require.Equal(t, hash2, tip)
+
+	inMemory := utxo.NewChainstate(utxo.NewSet(), hash2, height2)
+	require.ErrorIs(t, inMemory.DisconnectBlock(hash2, height2, block2), utxo.ErrMissingUndoData)
+}
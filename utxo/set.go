@@ -0,0 +1,422 @@
+// Package utxo keeps the unspent transaction outputs of the active chain: the in-memory Set, the chainstate database DB it is flushed to,
+// the validation of the transactions spending them and UTXO snapshots.
+package utxo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/storage"
+	"io"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrMissingCoin = errors.New("input spends an output that does not exist or is already spent")
+	ErrInvalidCoin = errors.New("invalid unspent output")
+)
+
+const (
+	// https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L28
+	maxScriptSize = 10000
+	opReturn      = 0x6a
+)
+
+// Coin is an unspent transaction output
+type Coin struct {
+	message.TxOut
+	// height of the block whose transaction created the output
+	Height   int32
+	Coinbase bool
+}
+
+// Approximate memory taken by a cached output besides its script
+const cacheEntryOverhead = 128
+
+// Set holds the unspent transaction outputs of a chain, indexed by the outpoints that spend them.
+//
+// A set backed by a DB (see NewCachedSet) only keeps in memory the outputs it read from the DB or changed since it was last flushed to it, and
+// reads the other outputs from the DB when they are spent. Its changes are only written to the DB when it is flushed.
+type Set struct {
+	mu      sync.Mutex
+	entries map[message.OutPoint]*cacheEntry
+	// number of unspent outputs, including the ones only in the DB
+	count int
+	db    *DB
+	// memory taken by entries, and the most it may take before the set should be flushed
+	cacheBytes    int
+	maxCacheBytes int
+}
+
+type cacheEntry struct {
+	coin Coin
+	// the output was spent, and the entry is kept until the spend is written to the DB
+	spent bool
+	// the entry differs from the DB
+	dirty bool
+	// the DB does not have the output, so the entry can be dropped once the output is spent
+	fresh bool
+}
+
+func (e *cacheEntry) size() int {
+	return cacheEntryOverhead + len(e.coin.PkScript)
+}
+
+// NewSet returns an empty set kept entirely in memory
+func NewSet() *Set {
+	return &Set{entries: make(map[message.OutPoint]*cacheEntry)}
+}
+
+// NewCachedSet returns the set of the unspent outputs in db, caching up to about maxCacheBytes of them in memory
+func NewCachedSet(db *DB, maxCacheBytes int) *Set {
+	return &Set{entries: make(map[message.OutPoint]*cacheEntry), count: db.count, db: db, maxCacheBytes: maxCacheBytes}
+}
+
+// Get returns the unspent output outpoint refers to, if there is one
+func (s *Set) Get(outpoint message.OutPoint) (Coin, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(outpoint)
+}
+
+// get returns the unspent output outpoint refers to, caching it if it is read from the DB
+func (s *Set) get(outpoint message.OutPoint) (Coin, bool, error) {
+	if entry, ok := s.entries[outpoint]; ok {
+		return entry.coin, !entry.spent, nil
+	}
+	if s.db == nil {
+		return Coin{}, false, nil
+	}
+	coin, ok, err := s.db.getCoin(outpoint)
+	if err != nil || !ok {
+		return Coin{}, false, err
+	}
+	entry := &cacheEntry{coin: coin}
+	s.entries[outpoint] = entry
+	s.cacheBytes += entry.size()
+	return coin, true, nil
+}
+
+// add adds coin, which outpoint refers to
+func (s *Set) add(outpoint message.OutPoint, coin Coin) {
+	previous, exists := s.entries[outpoint]
+	// an output spent since the last flush may still be in the DB
+	entry := &cacheEntry{coin: coin, dirty: true, fresh: !exists || previous.fresh}
+	if exists {
+		s.cacheBytes -= previous.size()
+	}
+	if !exists || previous.spent {
+		s.count++
+	}
+	s.entries[outpoint] = entry
+	s.cacheBytes += entry.size()
+}
+
+// spend removes the unspent output outpoint refers to and returns it, if there is one
+func (s *Set) spend(outpoint message.OutPoint) (Coin, bool, error) {
+	coin, ok, err := s.get(outpoint)
+	if err != nil || !ok {
+		return coin, ok, err
+	}
+	entry := s.entries[outpoint]
+	s.cacheBytes -= entry.size()
+	s.count--
+	if entry.fresh || s.db == nil {
+		delete(s.entries, outpoint)
+		return coin, true, nil
+	}
+	*entry = cacheEntry{spent: true, dirty: true}
+	s.cacheBytes += entry.size()
+	return coin, true, nil
+}
+
+// Len returns the number of unspent outputs
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// cacheFull reports whether the cached outputs take more memory than the set may use
+func (s *Set) cacheFull() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db != nil && s.cacheBytes > s.maxCacheBytes
+}
+
+// ConnectBlock spends the outputs the transactions of block, which is at height, spend and adds the outputs they create, except the provably
+// unspendable ones. It returns the spent outputs in the order of the inputs spending them, which DisconnectBlock needs to undo it. Nothing is
+// changed if an input spends an output that is not in the set.
+func (s *Set) ConnectBlock(block *message.BlockPayload, height int32) ([]Coin, error) {
+	txIds, err := blockTxIds(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spent := make([]Coin, 0)
+	for i, tx := range block.Transactions {
+		// the coinbase input spends nothing
+		if i > 0 {
+			for _, txIn := range tx.TransactionInputs {
+				coin, ok, err := s.spend(txIn.PreviousOutput)
+				if err == nil && !ok {
+					err = fmt.Errorf("%w: %s:%d", ErrMissingCoin, txIn.PreviousOutput.Hash, txIn.PreviousOutput.Index)
+				}
+				if err != nil {
+					undoErr := s.disconnect(block, txIds, spent)
+					return nil, errors.Join(err, undoErr)
+				}
+				spent = append(spent, coin)
+			}
+		}
+		// outputs are added as they are created, so that later transactions of the block can spend them
+		for index, txOut := range tx.TransactionOutputs {
+			if isUnspendable(txOut.PkScript) {
+				continue
+			}
+			s.add(message.OutPoint{Hash: txIds[i], Index: uint32(index)}, Coin{TxOut: txOut, Height: height, Coinbase: i == 0})
+		}
+	}
+	return spent, nil
+}
+
+// DisconnectBlock undoes ConnectBlock, given the outputs it returned
+func (s *Set) DisconnectBlock(block *message.BlockPayload, spent []Coin) error {
+	txIds, err := blockTxIds(block)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disconnect(block, txIds, spent)
+}
+
+// disconnect removes the outputs created by the transactions of block, last first, and adds back the outputs their inputs spent, which are
+// the first inputs of the block if spent is shorter than its inputs
+func (s *Set) disconnect(block *message.BlockPayload, txIds []message.Hash256, spent []Coin) error {
+	// index in spent of the first input of each transaction
+	firstInputs := make([]int, len(block.Transactions))
+	inputs := 0
+	for i := 1; i < len(block.Transactions); i++ {
+		firstInputs[i] = inputs
+		inputs += len(block.Transactions[i].TransactionInputs)
+	}
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := &block.Transactions[i]
+		for index, txOut := range tx.TransactionOutputs {
+			if isUnspendable(txOut.PkScript) {
+				continue
+			}
+			_, _, err := s.spend(message.OutPoint{Hash: txIds[i], Index: uint32(index)})
+			if err != nil {
+				return err
+			}
+		}
+		if i == 0 {
+			break
+		}
+		for j, txIn := range tx.TransactionInputs {
+			if firstInputs[i]+j < len(spent) {
+				s.add(txIn.PreviousOutput, spent[firstInputs[i]+j])
+			}
+		}
+	}
+	return nil
+}
+
+// isUnspendable reports whether no input can spend an output locked by pkScript
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/script/script.h#L555)
+func isUnspendable(pkScript []byte) bool {
+	return (len(pkScript) > 0 && pkScript[0] == opReturn) || len(pkScript) > maxScriptSize
+}
+
+func blockTxIds(block *message.BlockPayload) ([]message.Hash256, error) {
+	txIds := make([]message.Hash256, len(block.Transactions))
+	for i := range block.Transactions {
+		txId, err := block.Transactions[i].GetTxId()
+		if err != nil {
+			return nil, err
+		}
+		txIds[i] = txId
+	}
+	return txIds, nil
+}
+
+// Hash returns the double SHA256 of the unspent outputs sorted by outpoint, each one serialized as its outpoint, its height and coinbase flag
+// and its output, which commits to the whole set (https://github.com/bitcoin/bitcoin/blob/v27.0/src/kernel/coinstats.cpp#L55)
+func (s *Set) Hash() (message.Hash256, error) {
+	hasher := sha256.New()
+	err := s.forEach(func(outpoint message.OutPoint, coin Coin) error {
+		encoded, err := encodeCoin(outpoint, coin)
+		if err != nil {
+			return err
+		}
+		hasher.Write(encoded)
+		return nil
+	})
+	if err != nil {
+		return message.Hash256{}, err
+	}
+	hash := sha256.Sum256(hasher.Sum(nil))
+	return hash, nil
+}
+
+// forEach calls fn with every unspent output, sorted by outpoint. The outputs that are only in the DB are read without being cached.
+func (s *Set) forEach(fn func(outpoint message.OutPoint, coin Coin) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outpoints := make([]message.OutPoint, 0, s.count)
+	if s.db != nil {
+		for _, outpoint := range s.db.outPoints() {
+			if _, ok := s.entries[outpoint]; !ok {
+				outpoints = append(outpoints, outpoint)
+			}
+		}
+	}
+	for outpoint, entry := range s.entries {
+		if !entry.spent {
+			outpoints = append(outpoints, outpoint)
+		}
+	}
+	slices.SortFunc(outpoints, compareOutPoints)
+
+	for _, outpoint := range outpoints {
+		coin := Coin{}
+		if entry, ok := s.entries[outpoint]; ok {
+			coin = entry.coin
+		} else {
+			var err error
+			coin, _, err = s.db.getCoin(outpoint)
+			if err != nil {
+				return err
+			}
+		}
+		err := fn(outpoint, coin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compareOutPoints(a, b message.OutPoint) int {
+	if c := bytes.Compare(a.Hash[:], b.Hash[:]); c != 0 {
+		return c
+	}
+	return int(int64(a.Index) - int64(b.Index))
+}
+
+// encodeCoin serializes the output outpoint refers to as its outpoint followed by encodeCoinBody
+func encodeCoin(outpoint message.OutPoint, coin Coin) ([]byte, error) {
+	encodedOutPoint, err := outpoint.Encode()
+	if err != nil {
+		return nil, err
+	}
+	encodedCoin, err := encodeCoinBody(coin)
+	if err != nil {
+		return nil, err
+	}
+	return append(encodedOutPoint, encodedCoin...), nil
+}
+
+// encodeCoinBody serializes coin as its height times two plus its coinbase flag and its output
+func encodeCoinBody(coin Coin) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	code := uint32(coin.Height) << 1
+	if coin.Coinbase {
+		code |= 1
+	}
+	err := binary.Write(buffer, binary.LittleEndian, code)
+	if err != nil {
+		return nil, err
+	}
+	encodedTxOut, err := coin.TxOut.Encode()
+	if err != nil {
+		return nil, err
+	}
+	buffer.Write(encodedTxOut)
+	return buffer.Bytes(), nil
+}
+
+// decodeCoinBody decodes a coin serialized by encodeCoinBody
+func decodeCoinBody(r io.Reader) (Coin, error) {
+	var coin Coin
+	var code uint32
+	err := binary.Read(r, binary.LittleEndian, &code)
+	if err != nil {
+		return coin, err
+	}
+	coin.Height, coin.Coinbase = int32(code>>1), code&1 == 1
+	err = binary.Read(r, binary.LittleEndian, &coin.Value)
+	if err != nil {
+		return coin, err
+	}
+	pkScriptLength, err := message.DecodeVarInt(r)
+	if err != nil {
+		return coin, err
+	}
+	if pkScriptLength > maxScriptSize {
+		return coin, fmt.Errorf("%w: pkScript (length %d) exceeded max length", ErrInvalidCoin, pkScriptLength)
+	}
+	coin.PkScript = make([]byte, pkScriptLength)
+	_, err = io.ReadFull(r, coin.PkScript)
+	return coin, err
+}
+
+// Flush writes the changes of the set to its DB, as the unspent outputs at the block with hash best at height, and then drops the cached
+// outputs from memory if evict is set. It does nothing if the set has no DB.
+func (s *Set) Flush(best message.Hash256, height int32, evict bool) error {
+	return s.flush(&storage.KVBatch{}, best, height, evict)
+}
+
+// flush is Flush writing the changes in the same batch as the puts and deletes already in batch
+func (s *Set) flush(batch *storage.KVBatch, best message.Hash256, height int32, evict bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+
+	for outpoint, entry := range s.entries {
+		switch {
+		case !entry.dirty:
+		case entry.spent:
+			batch.Delete(dbCoinKey(outpoint))
+		default:
+			encoded, err := encodeCoinBody(entry.coin)
+			if err != nil {
+				return err
+			}
+			batch.Put(dbCoinKey(outpoint), encoded)
+		}
+	}
+	batch.Put(dbBestBlockKey, binary.LittleEndian.AppendUint32(append([]byte{}, best[:]...), uint32(height)))
+	err := s.db.kv.Write(batch)
+	if err != nil {
+		return err
+	}
+	s.db.count = s.count
+
+	if evict {
+		s.entries, s.cacheBytes = make(map[message.OutPoint]*cacheEntry), 0
+		return nil
+	}
+	for outpoint, entry := range s.entries {
+		if entry.spent {
+			s.cacheBytes -= entry.size()
+			delete(s.entries, outpoint)
+		} else {
+			entry.dirty, entry.fresh = false, false
+		}
+	}
+	return nil
+}
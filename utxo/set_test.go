@@ -0,0 +1,123 @@
+package utxo_test
+
+import (
+	"bytes"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var genesisHash = message.Hash256(constants.GenesisBlockHash)
+
+// newCoinbase returns a coinbase transaction paying value, made unique by height
+func newCoinbase(height int32, value int64) message.TxPayload {
+	return message.TxPayload{
+		Version:            1,
+		TransactionInputs:  []message.TxIn{{SignatureScript: []byte{0x01, byte(height)}, Sequence: 0xFFFFFFFF}},
+		TransactionOutputs: []message.TxOut{{Value: value, PkScript: []byte{0x51}}},
+	}
+}
+
+// newSpend returns a transaction spending the outputs of prevTxs at index 0 and creating one output per value
+func newSpend(t *testing.T, prevTxs []message.TxPayload, values ...int64) message.TxPayload {
+	tx := message.TxPayload{Version: 1}
+	for _, prevTx := range prevTxs {
+		txId, err := prevTx.GetTxId()
+		require.NoError(t, err)
+		tx.TransactionInputs = append(tx.TransactionInputs, message.TxIn{PreviousOutput: message.OutPoint{Hash: txId}, Sequence: 0xFFFFFFFF})
+	}
+	for _, value := range values {
+		tx.TransactionOutputs = append(tx.TransactionOutputs, message.TxOut{Value: value, PkScript: []byte{0x51}})
+	}
+	return tx
+}
+
+func outPoint(t *testing.T, tx message.TxPayload, index uint32) message.OutPoint {
+	txId, err := tx.GetTxId()
+	require.NoError(t, err)
+	return message.OutPoint{Hash: txId, Index: index}
+}
+
+func TestSet_ConnectBlock(t *testing.T) {
+	coinbase1 := newCoinbase(1, 50)
+	block1 := message.BlockPayload{Transactions: []message.TxPayload{coinbase1}}
+	coinbase2 := newCoinbase(2, 50)
+	spend := newSpend(t, []message.TxPayload{coinbase1}, 20, 30)
+	// spends an output created earlier in the same block
+	chained := newSpend(t, []message.TxPayload{spend}, 20)
+	opReturn := message.TxPayload{Version: 1, TransactionOutputs: []message.TxOut{{PkScript: []byte{0x6a, 0x01}}}}
+	block2 := message.BlockPayload{Transactions: []message.TxPayload{coinbase2, spend, chained, opReturn}}
+
+	t.Run("outputs should be spent and created, and restored when the block is disconnected", func(t *testing.T) {
+		set := utxo.NewSet()
+		_, err := set.ConnectBlock(&block1, 1)
+		require.NoError(t, err)
+		hashAfterBlock1, err := set.Hash()
+		require.NoError(t, err)
+
+		spent, err := set.ConnectBlock(&block2, 2)
+		require.NoError(t, err)
+		require.Len(t, spent, 2)
+		require.Equal(t, 3, set.Len())
+		_, ok, err := set.Get(outPoint(t, coinbase1, 0))
+		require.NoError(t, err)
+		require.False(t, ok)
+		coin, ok, err := set.Get(outPoint(t, spend, 1))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, utxo.Coin{TxOut: message.TxOut{Value: 30, PkScript: []byte{0x51}}, Height: 2}, coin)
+		coin, ok, err = set.Get(outPoint(t, coinbase2, 0))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.True(t, coin.Coinbase)
+
+		require.NoError(t, set.DisconnectBlock(&block2, spent))
+		hash, err := set.Hash()
+		require.NoError(t, err)
+		require.Equal(t, hashAfterBlock1, hash)
+		require.Equal(t, 1, set.Len())
+	})
+
+	t.Run("a block spending a missing output should change nothing", func(t *testing.T) {
+		set := utxo.NewSet()
+		_, err := set.ConnectBlock(&block1, 1)
+		require.NoError(t, err)
+		before, err := set.Hash()
+		require.NoError(t, err)
+
+		missing := newSpend(t, []message.TxPayload{newCoinbase(99, 50)}, 10)
+		invalid := message.BlockPayload{Transactions: []message.TxPayload{coinbase2, spend, chained, missing}}
+		_, err = set.ConnectBlock(&invalid, 2)
+		require.ErrorIs(t, err, utxo.ErrMissingCoin)
+		after, err := set.Hash()
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	set := utxo.NewSet()
+	for height := range int32(5) {
+		_, err := set.ConnectBlock(&message.BlockPayload{Transactions: []message.TxPayload{newCoinbase(height, 50)}}, height)
+		require.NoError(t, err)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, utxo.WriteSnapshot(&buf, genesisHash, set))
+	encoded := bytes.Clone(buf.Bytes())
+
+	base, read, err := utxo.ReadSnapshot(&buf)
+	require.NoError(t, err)
+	require.Equal(t, genesisHash, base)
+	require.Equal(t, set.Len(), read.Len())
+	expected, err := set.Hash()
+	require.NoError(t, err)
+	actual, err := read.Hash()
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	encoded[0] = 'x'
+	_, _, err = utxo.ReadSnapshot(bytes.NewReader(encoded))
+	require.ErrorIs(t, err, utxo.ErrInvalidSnapshot)
+}
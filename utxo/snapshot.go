@@ -0,0 +1,100 @@
+package utxo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/message"
+	"io"
+)
+
+var ErrInvalidSnapshot = errors.New("invalid UTXO snapshot")
+
+// utxoSnapshotMagic starts UTXO snapshot files
+var utxoSnapshotMagic = [4]byte{'u', 't', 'x', 'o'}
+
+// WriteSnapshot writes set, the unspent outputs of the chain ending with the block with hash base, to w: the magic bytes "utxo", base,
+// the number of outputs as a varint, then each output sorted by outpoint as hashed by Set.Hash
+func WriteSnapshot(w io.Writer, base message.Hash256, set *Set) error {
+	bw := bufio.NewWriter(w)
+	_, err := bw.Write(utxoSnapshotMagic[:])
+	if err != nil {
+		return err
+	}
+	_, err = bw.Write(base[:])
+	if err != nil {
+		return err
+	}
+	encodedCount, err := message.VarInt(set.Len()).Encode()
+	if err != nil {
+		return err
+	}
+	_, err = bw.Write(encodedCount)
+	if err != nil {
+		return err
+	}
+	err = set.forEach(func(outpoint message.OutPoint, coin Coin) error {
+		encoded, err := encodeCoin(outpoint, coin)
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(encoded)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot, returning the hash of its base block and its unspent outputs
+func ReadSnapshot(r io.Reader) (message.Hash256, *Set, error) {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	_, err := io.ReadFull(br, magic[:])
+	if err != nil {
+		return message.Hash256{}, nil, err
+	}
+	if magic != utxoSnapshotMagic {
+		return message.Hash256{}, nil, fmt.Errorf("%w: magic bytes are %x", ErrInvalidSnapshot, magic)
+	}
+	var base message.Hash256
+	_, err = io.ReadFull(br, base[:])
+	if err != nil {
+		return message.Hash256{}, nil, err
+	}
+	count, err := message.DecodeVarInt(br)
+	if err != nil {
+		return message.Hash256{}, nil, err
+	}
+	set := NewSet()
+	var previous message.OutPoint
+	for i := range count {
+		outpoint, coin, err := decodeCoin(br)
+		if err != nil {
+			return message.Hash256{}, nil, err
+		}
+		// sorted outpoints cannot repeat
+		if i > 0 && compareOutPoints(previous, outpoint) >= 0 {
+			return message.Hash256{}, nil, fmt.Errorf("%w: outpoint %s:%d is out of order", ErrInvalidSnapshot, outpoint.Hash, outpoint.Index)
+		}
+		set.add(outpoint, coin)
+		previous = outpoint
+	}
+	return base, set, nil
+}
+
+func decodeCoin(r io.Reader) (message.OutPoint, Coin, error) {
+	var outpoint message.OutPoint
+	_, err := io.ReadFull(r, outpoint.Hash[:])
+	if err != nil {
+		return outpoint, Coin{}, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &outpoint.Index)
+	if err != nil {
+		return outpoint, Coin{}, err
+	}
+	coin, err := decodeCoinBody(r)
+	return outpoint, coin, err
+}
@@ -0,0 +1,171 @@
+package utxo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"sync"
+)
+
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+// ErrPrematureCoinbaseSpend is the error of transactions spending the outputs of a coinbase with fewer than constants.CoinbaseMaturity
+// confirmations, which wraps ErrInvalidTransaction
+var ErrPrematureCoinbaseSpend = fmt.Errorf("%w: premature spend of coinbase", ErrInvalidTransaction)
+
+// BlockSubsidy returns the newly created coins the coinbase of the block at height may claim besides the fees of the block
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/validation.cpp#L1789)
+func BlockSubsidy(height int32) int64 {
+	halvings := height / constants.SubsidyHalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return constants.InitialBlockSubsidy >> halvings
+}
+
+// CheckBlockInputs validates the inputs of the transactions of block, which is at height, against the outputs they spend, given in the order
+// ConnectBlock returned them. The transactions are checked by the given number of goroutines, and all the transactions that fail are reported
+// together, joined in the order of the block. With checkScripts, the scripts of the inputs are run too, with the rules of the soft forks
+// deployments has active at height (see script.FlagsAt). The coinbase may then claim at most the subsidy and the fees of the other
+// transactions.
+func CheckBlockInputs(block *message.BlockPayload, height int32, spent []Coin, deployments constants.Deployments, workers int, checkScripts bool) error {
+	// index in spent of the first input of each transaction
+	firstInputs := make([]int, len(block.Transactions))
+	inputs := 0
+	for i := 1; i < len(block.Transactions); i++ {
+		firstInputs[i] = inputs
+		inputs += len(block.Transactions[i].TransactionInputs)
+	}
+	if inputs != len(spent) {
+		return fmt.Errorf("%w: %d spent outputs for %d inputs", ErrInvalidTransaction, len(spent), inputs)
+	}
+
+	flags := script.FlagsAt(deployments, height)
+	workers = max(workers, 1)
+	fees := make([]int64, len(block.Transactions))
+	errs := make([]error, len(block.Transactions))
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1 + w; i < len(block.Transactions); i += workers {
+				tx := &block.Transactions[i]
+				fee, err := checkInputs(tx, spent[firstInputs[i]:firstInputs[i]+len(tx.TransactionInputs)], height, flags, checkScripts)
+				if err != nil {
+					txId, _ := tx.GetTxId()
+					errs[i] = fmt.Errorf("transaction %d (%s): %w", i, txId, err)
+					continue
+				}
+				fees[i] = fee
+			}
+		}()
+	}
+	wg.Wait()
+	err := errors.Join(errs...)
+	if err != nil || len(block.Transactions) == 0 {
+		return err
+	}
+
+	reward := BlockSubsidy(height)
+	for _, fee := range fees {
+		reward += fee
+	}
+	claimed, err := outputsValue(&block.Transactions[0])
+	if err != nil {
+		return fmt.Errorf("coinbase: %w", err)
+	}
+	if claimed > reward {
+		return fmt.Errorf("%w: coinbase claims %d but the subsidy and fees are %d", ErrInvalidTransaction, claimed, reward)
+	}
+	return nil
+}
+
+// BlockFees returns the fees the transactions of block pay, given the outputs they spend in the order ConnectBlock returned them, e.g. the
+// ones Chainstate.Undo returns for a connected block
+func BlockFees(block *message.BlockPayload, spent []Coin) int64 {
+	var fees int64
+	for _, coin := range spent {
+		fees += coin.Value
+	}
+	for i := 1; i < len(block.Transactions); i++ {
+		for _, out := range block.Transactions[i].TransactionOutputs {
+			fees -= out.Value
+		}
+	}
+	return fees
+}
+
+// checkInputs validates the inputs of tx, in a block at height, against coins, the outputs they spend, running their scripts with flags if
+// checkScripts is set, and returns the fee tx pays
+func checkInputs(tx *message.TxPayload, coins []Coin, height int32, flags script.VerifyFlags, checkScripts bool) (int64, error) {
+	fee, err := CheckTxInputs(tx, coins, height)
+	if err != nil || !checkScripts {
+		return fee, err
+	}
+	sigHashes := script.NewTxSigHashes(tx, SpentOutputs(coins))
+	for i := range coins {
+		err = script.VerifyInput(tx, i, &coins[i].TxOut, flags, sigHashes)
+		if err != nil {
+			return 0, fmt.Errorf("%w: input %d: %w", ErrInvalidTransaction, i, err)
+		}
+	}
+	return fee, nil
+}
+
+// CheckTxInputs validates the inputs of tx against coins, the outputs they spend, for tx to be in a block at spendHeight, and returns the
+// fee tx pays. The scripts of the inputs are left to the caller.
+// (https://github.com/bitcoin/bitcoin/blob/v27.0/src/consensus/tx_verify.cpp#L164-L206)
+func CheckTxInputs(tx *message.TxPayload, coins []Coin, spendHeight int32) (int64, error) {
+	if len(tx.TransactionWitnesses) > 0 && len(tx.TransactionWitnesses) != len(tx.TransactionInputs) {
+		return 0, fmt.Errorf("%w: %d witnesses for %d inputs", ErrInvalidTransaction, len(tx.TransactionWitnesses), len(tx.TransactionInputs))
+	}
+	var in int64
+	for i, coin := range coins {
+		if coin.Coinbase && spendHeight-coin.Height < constants.CoinbaseMaturity {
+			return 0, fmt.Errorf("%w: input %d spends a coinbase of height %d, which cannot be spent before height %d", ErrPrematureCoinbaseSpend, i,
+				coin.Height, coin.Height+constants.CoinbaseMaturity)
+		}
+		if coin.Value < 0 || coin.Value > constants.MaxMoney {
+			return 0, fmt.Errorf("%w: input %d spends an output value out of range", ErrInvalidTransaction, i)
+		}
+		in += coin.Value
+		if in > constants.MaxMoney {
+			return 0, fmt.Errorf("%w: total input value out of range", ErrInvalidTransaction)
+		}
+	}
+	out, err := outputsValue(tx)
+	if err != nil {
+		return 0, err
+	}
+	if in < out {
+		return 0, fmt.Errorf("%w: inputs are worth %d but outputs %d", ErrInvalidTransaction, in, out)
+	}
+	return in - out, nil
+}
+
+// SpentOutputs returns the outputs coins are, which taproot signatures commit to (see script.NewTxSigHashes)
+func SpentOutputs(coins []Coin) []message.TxOut {
+	outs := make([]message.TxOut, len(coins))
+	for i, coin := range coins {
+		outs[i] = coin.TxOut
+	}
+	return outs
+}
+
+// outputsValue returns the value of the outputs of tx
+func outputsValue(tx *message.TxPayload) (int64, error) {
+	var out int64
+	for _, txOut := range tx.TransactionOutputs {
+		if txOut.Value < 0 || txOut.Value > constants.MaxMoney {
+			return 0, fmt.Errorf("%w: output value out of range", ErrInvalidTransaction)
+		}
+		out += txOut.Value
+		if out > constants.MaxMoney {
+			return 0, fmt.Errorf("%w: total output value out of range", ErrInvalidTransaction)
+		}
+	}
+	return out, nil
+}
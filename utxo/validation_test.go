@@ -0,0 +1,116 @@
+package utxo_test
+
+import (
+	"github.com/aang114/bitcoin-node/constants"
+	"github.com/aang114/bitcoin-node/message"
+	"github.com/aang114/bitcoin-node/script"
+	"github.com/aang114/bitcoin-node/utxo"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var mainnet = constants.MainnetParams.Deployments
+
+func TestBlockSubsidy(t *testing.T) {
+	require.EqualValues(t, 50_0000_0000, utxo.BlockSubsidy(0))
+	require.EqualValues(t, 50_0000_0000, utxo.BlockSubsidy(209_999))
+	require.EqualValues(t, 25_0000_0000, utxo.BlockSubsidy(210_000))
+	require.EqualValues(t, 0, utxo.BlockSubsidy(64*210_000))
+}
+
+func TestBlockFees(t *testing.T) {
+	coinbase1, coinbase2 := newCoinbase(1, 50), newCoinbase(2, 50)
+	chainstate := utxo.NewChainstate(utxo.NewSet(), genesisHash, 0)
+	block1, hash1 := newBlock(t, genesisHash, coinbase1)
+	require.NoError(t, chainstate.ConnectBlock(hash1, 1, block1))
+	block2, hash2 := newBlock(t, hash1, coinbase2)
+	require.NoError(t, chainstate.ConnectBlock(hash2, 2, block2))
+	matured, height := connectEmptyBlocks(t, hash2, 2, constants.CoinbaseMaturity-1, chainstate)
+
+	block3, hash3 := newBlock(t, matured, newCoinbase(3, 50), newSpend(t, []message.TxPayload{coinbase1}, 40), newSpend(t, []message.TxPayload{coinbase2}, 20, 25))
+	require.NoError(t, chainstate.ConnectBlock(hash3, height+1, block3))
+	spent, err := chainstate.Undo(hash3)
+	require.NoError(t, err)
+	require.EqualValues(t, 15, utxo.BlockFees(block3, spent))
+
+	// the coinbase pays no fee
+	spent, err = chainstate.Undo(hash1)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, utxo.BlockFees(block1, spent))
+}
+
+func TestCheckBlockInputs(t *testing.T) {
+	coinbase1, coinbase2 := newCoinbase(1, 50), newCoinbase(2, 50)
+	// the outputs spent by newSpend(coinbase1) and newSpend(coinbase2), which blocks can spend from height on
+	spent := []utxo.Coin{
+		{TxOut: message.TxOut{Value: 50, PkScript: []byte{0x51}}, Height: 1, Coinbase: true},
+		{TxOut: message.TxOut{Value: 50, PkScript: []byte{0x51}}, Height: 2, Coinbase: true},
+	}
+	height := 2 + int32(constants.CoinbaseMaturity)
+
+	t.Run("fees should go to the coinbase", func(t *testing.T) {
+		for _, workers := range []int{0, 1, 4} {
+			block := message.BlockPayload{Transactions: []message.TxPayload{
+				newCoinbase(3, utxo.BlockSubsidy(height)+15),
+				newSpend(t, []message.TxPayload{coinbase1}, 40),
+				newSpend(t, []message.TxPayload{coinbase2}, 45),
+			}}
+			require.NoError(t, utxo.CheckBlockInputs(&block, height, spent, mainnet, workers, true))
+
+			block.Transactions[0] = newCoinbase(3, utxo.BlockSubsidy(height)+16)
+			require.ErrorIs(t, utxo.CheckBlockInputs(&block, height, spent, mainnet, workers, true), utxo.ErrInvalidTransaction)
+		}
+	})
+
+	t.Run("coinbases should only be spent once they have matured", func(t *testing.T) {
+		block := message.BlockPayload{Transactions: []message.TxPayload{newCoinbase(3, 50), newSpend(t, []message.TxPayload{coinbase2}, 45)}}
+		err := utxo.CheckBlockInputs(&block, height-1, spent[1:], mainnet, 1, false)
+		require.ErrorIs(t, err, utxo.ErrPrematureCoinbaseSpend)
+		require.ErrorIs(t, err, utxo.ErrInvalidTransaction)
+		// outputs of other transactions can be spent at once
+		notCoinbase := []utxo.Coin{{TxOut: spent[1].TxOut, Height: height - 1}}
+		require.NoError(t, utxo.CheckBlockInputs(&block, height-1, notCoinbase, mainnet, 1, false))
+	})
+
+	t.Run("every invalid transaction of the block should be reported", func(t *testing.T) {
+		overspend1 := newSpend(t, []message.TxPayload{coinbase1}, 51)
+		overspend2 := newSpend(t, []message.TxPayload{coinbase2}, 20, 40)
+		block := message.BlockPayload{Transactions: []message.TxPayload{newCoinbase(3, 50), overspend1, overspend2}}
+		err := utxo.CheckBlockInputs(&block, height, spent, mainnet, 2, true)
+		require.ErrorIs(t, err, utxo.ErrInvalidTransaction)
+		require.Len(t, strings.Split(err.Error(), "\n"), 2)
+		require.Contains(t, err.Error(), "transaction 1")
+		require.Contains(t, err.Error(), "transaction 2")
+	})
+
+	t.Run("inputs whose scripts fail should be reported", func(t *testing.T) {
+		block := message.BlockPayload{Transactions: []message.TxPayload{newCoinbase(3, 50), newSpend(t, []message.TxPayload{coinbase1}, 40)}}
+		// OP_0 leaves false on the stack
+		unspendable := []utxo.Coin{{TxOut: message.TxOut{Value: 50, PkScript: []byte{0x00}}, Height: 1, Coinbase: true}}
+		err := utxo.CheckBlockInputs(&block, height, unspendable, mainnet, 2, true)
+		require.ErrorIs(t, err, utxo.ErrInvalidTransaction)
+		require.ErrorIs(t, err, script.ErrScriptFailed)
+		require.Contains(t, err.Error(), "input 0")
+		// the values are still checked without the scripts
+		require.NoError(t, utxo.CheckBlockInputs(&block, height, unspendable, mainnet, 2, false))
+	})
+
+	t.Run("a chainstate should not connect a block with invalid inputs", func(t *testing.T) {
+		chainstate := utxo.NewChainstate(utxo.NewSet(), genesisHash, 0)
+		chainstate.SetValidationWorkers(4)
+		connected, hash1 := newBlock(t, genesisHash, coinbase1)
+		require.NoError(t, chainstate.ConnectBlock(hash1, 1, connected))
+		matured, height := connectEmptyBlocks(t, hash1, 1, constants.CoinbaseMaturity-1, chainstate)
+		before, err := chainstate.Coins().Hash()
+		require.NoError(t, err)
+
+		invalid, invalidHash := newBlock(t, matured, newCoinbase(2, 50), newSpend(t, []message.TxPayload{coinbase1}, 60))
+		require.ErrorIs(t, chainstate.ConnectBlock(invalidHash, height+1, invalid), utxo.ErrInvalidTransaction)
+		after, err := chainstate.Coins().Hash()
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+		tip, _ := chainstate.Tip()
+		require.Equal(t, matured, tip)
+	})
+}